@@ -0,0 +1,1009 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/goplus/gop"
+	"golang.org/x/xerrors"
+)
+
+// arrowipcPackage exchanges tables with the other kernels of the machine, like Python
+// kernels using pyarrow, as Apache Arrow IPC files in a well-known directory.
+var arrowipcPackage = gop.NewGoPackage("arrowipc")
+
+func init() {
+	arrowipcPackage.RegisterFuncs(
+		arrowipcPackage.Func("Export", arrowExport, execArrowExport),
+		arrowipcPackage.Func("Import", arrowImport, execArrowImport),
+		arrowipcPackage.Func("Dir", arrowDir, execArrowDir),
+		arrowipcPackage.Func("Len", arrowLen, execArrowLen),
+		arrowipcPackage.Func("Column", arrowValues, execArrowColumn("")),
+		arrowipcPackage.Func("Ints", arrowInts, execArrowColumn("int64")),
+		arrowipcPackage.Func("Uints", arrowUints, execArrowColumn("uint64")),
+		arrowipcPackage.Func("Floats", arrowFloats, execArrowColumn("float64")),
+		arrowipcPackage.Func("Strings", arrowStrings, execArrowColumn("string")),
+		arrowipcPackage.Func("Bools", arrowBools, execArrowColumn("bool")),
+		arrowipcPackage.Func("Times", arrowTimes, execArrowColumn("time")),
+	)
+}
+
+// This file implements just enough of the Arrow IPC format to exchange flat tables: it
+// writes and reads the file and the stream formats, with columns of integers, floats,
+// strings, booleans, timestamps and dates. Nested and dictionary-encoded columns and
+// compressed batches are reported as unsupported. The metadata of the format is encoded
+// with FlatBuffers, of which the file implements a minimal encoder and decoder too.
+// See https://arrow.apache.org/docs/format/Columnar.html.
+
+// ArrowTable is a table exchanged in the Arrow format, as returned by arrowipc.Import.
+// Its columns are read with arrowipc.Ints, Floats, Strings and the like.
+type ArrowTable struct {
+	Path    string        // the file the table was read from, if any
+	Columns []string      // the names of the columns
+	Types   []string      // the type of each column: int64, uint64, float64, string, bool or time
+	Data    []interface{} // the values of each column: a []int64, []uint64, []float64, []string, []bool or []time.Time
+	Nulls   [][]bool      // whether each value of each column is null, or nil for the columns without nulls
+}
+
+// arrowDir returns the directory where tables are exchanged: $GOPYTER_ARROW_DIR, or a
+// directory in the shared memory of the machine if it has one, or in the temporary
+// directory otherwise.
+func arrowDir() string {
+	if dir := os.Getenv("GOPYTER_ARROW_DIR"); dir != "" {
+		return dir
+	}
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm/jupyter-arrow"
+	}
+	return filepath.Join(os.TempDir(), "jupyter-arrow")
+}
+
+func execArrowDir(_ int, p *gop.Context) {
+	p.Ret(0, arrowDir())
+}
+
+// arrowPath returns the path of the file of the table called name.
+func arrowPath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid table name %q", name)
+	}
+	if filepath.Ext(name) == "" {
+		name += ".arrow"
+	}
+	return filepath.Join(arrowDir(), name), nil
+}
+
+// arrowExport writes v, a slice of structs or an *ArrowTable, as an Arrow IPC file
+// named after name in the exchange directory, and returns its path. Like the other
+// builtins it reports errors by panicking, which fails the cell.
+func arrowExport(v interface{}, name string) string {
+	path, err := exportArrow(v, name)
+	if err != nil {
+		panic(xerrors.Errorf("arrowipc.Export: %w", err))
+	}
+	return path
+}
+
+func execArrowExport(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, arrowExport(args[0], args[1].(string)))
+}
+
+func exportArrow(v interface{}, name string) (string, error) {
+	if sandboxed {
+		return "", errSandboxed
+	}
+	path, err := arrowPath(name)
+	if err != nil {
+		return "", err
+	}
+	table, ok := v.(*ArrowTable)
+	if !ok {
+		if table, err = newArrowTable(v); err != nil {
+			return "", err
+		}
+	}
+	data, err := encodeArrowFile(table)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	// Write to a temporary file first, so readers never see a partial table.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// arrowImport reads the table called name from the exchange directory, or from the
+// Arrow IPC file at name if it is a path.
+func arrowImport(name string) *ArrowTable {
+	table, err := importArrow(name)
+	if err != nil {
+		panic(xerrors.Errorf("arrowipc.Import: %w", err))
+	}
+	return table
+}
+
+func execArrowImport(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, arrowImport(args[0].(string)))
+}
+
+func importArrow(name string) (*ArrowTable, error) {
+	if sandboxed {
+		return nil, errSandboxed
+	}
+	path := name
+	if !strings.ContainsRune(name, filepath.Separator) {
+		var err error
+		if path, err = arrowPath(name); err != nil {
+			return nil, err
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := decodeArrow(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	table.Path = path
+	return table, nil
+}
+
+// newArrowTable converts a slice of structs, or of pointers to structs, to a table
+// with a column for each exported field. Integers are stored as 64-bit integers and
+// floats as 64-bit floats; zero times are null.
+func newArrowTable(v interface{}) (*ArrowTable, error) {
+	if !isTable(v) {
+		return nil, fmt.Errorf("expected a slice of structs or an *ArrowTable, got %T", v)
+	}
+	rows := reflect.ValueOf(v)
+	elem := rows.Type().Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	n := rows.Len()
+	table := &ArrowTable{}
+	for _, i := range tableFields(elem) {
+		field := elem.Field(i)
+		var data interface{}
+		var nulls []bool
+		var typ string
+		switch t := field.Type; {
+		case t == timeType:
+			typ, data = "time", make([]time.Time, n)
+		case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+			typ, data = "int64", make([]int64, n)
+		case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+			typ, data = "uint64", make([]uint64, n)
+		case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+			typ, data = "float64", make([]float64, n)
+		case t.Kind() == reflect.String:
+			typ, data = "string", make([]string, n)
+		case t.Kind() == reflect.Bool:
+			typ, data = "bool", make([]bool, n)
+		default:
+			return nil, fmt.Errorf("column %s: unsupported type %s", field.Name, t)
+		}
+		for j := 0; j < n; j++ {
+			row := reflect.Indirect(rows.Index(j))
+			if !row.IsValid() {
+				return nil, fmt.Errorf("row %d is nil", j)
+			}
+			value := row.Field(i)
+			switch data := data.(type) {
+			case []time.Time:
+				data[j] = value.Interface().(time.Time)
+				if data[j].IsZero() {
+					if nulls == nil {
+						nulls = make([]bool, n)
+					}
+					nulls[j] = true
+				}
+			case []int64:
+				data[j] = value.Int()
+			case []uint64:
+				data[j] = value.Uint()
+			case []float64:
+				data[j] = value.Float()
+			case []string:
+				data[j] = value.String()
+			case []bool:
+				data[j] = value.Bool()
+			}
+		}
+		name := field.Name
+		if tag := field.Tag.Get("arrow"); tag != "" {
+			name = tag
+		}
+		table.Columns = append(table.Columns, name)
+		table.Types = append(table.Types, typ)
+		table.Data = append(table.Data, data)
+		table.Nulls = append(table.Nulls, nulls)
+	}
+	return table, nil
+}
+
+// len returns the number of rows of the table.
+func (t *ArrowTable) len() int {
+	if len(t.Data) == 0 {
+		return 0
+	}
+	return reflect.ValueOf(t.Data[0]).Len()
+}
+
+// column returns the values of the column called name, which must be of type typ
+// unless typ is empty.
+func (t *ArrowTable) column(name, typ string) interface{} {
+	for i, column := range t.Columns {
+		if column != name {
+			continue
+		}
+		if typ != "" && t.Types[i] != typ {
+			panic(fmt.Errorf("arrowipc: column %q is of type %s, not %s", name, t.Types[i], typ))
+		}
+		return t.Data[i]
+	}
+	panic(fmt.Errorf("arrowipc: no column %q", name))
+}
+
+// The methods of the types of the kernel cannot be called from cells, so the columns of
+// tables are read with functions of the package, like arrowipc.Ints(t, "id").
+
+func arrowLen(t *ArrowTable) int { return t.len() }
+
+func arrowValues(t *ArrowTable, name string) interface{} { return t.column(name, "") }
+
+func arrowInts(t *ArrowTable, name string) []int64 { return t.column(name, "int64").([]int64) }
+
+func arrowUints(t *ArrowTable, name string) []uint64 { return t.column(name, "uint64").([]uint64) }
+
+func arrowFloats(t *ArrowTable, name string) []float64 {
+	return t.column(name, "float64").([]float64)
+}
+
+func arrowStrings(t *ArrowTable, name string) []string { return t.column(name, "string").([]string) }
+
+func arrowBools(t *ArrowTable, name string) []bool { return t.column(name, "bool").([]bool) }
+
+func arrowTimes(t *ArrowTable, name string) []time.Time {
+	return t.column(name, "time").([]time.Time)
+}
+
+func execArrowLen(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, arrowLen(args[0].(*ArrowTable)))
+}
+
+// execArrowColumn returns the Go+ implementation of the function reading the columns of
+// type typ, or of any type if typ is empty.
+func execArrowColumn(typ string) func(int, *gop.Context) {
+	return func(_ int, p *gop.Context) {
+		args := p.GetArgs(2)
+		p.Ret(2, args[0].(*ArrowTable).column(args[1].(string), typ))
+	}
+}
+
+// preview returns the first n rows of the table.
+func (t *ArrowTable) preview(n int) *TablePreview {
+	total := t.len()
+	if n > total {
+		n = total
+	}
+	path := t.Path
+	if path == "" {
+		path = "Arrow table"
+	}
+	preview := &TablePreview{Path: path, Columns: t.Columns, Types: t.Types, Total: total}
+	hasNulls := false
+	for _, nulls := range t.Nulls {
+		hasNulls = hasNulls || nulls != nil
+	}
+	for i := 0; i < n; i++ {
+		row := make([]string, len(t.Columns))
+		var rowNulls []bool
+		if hasNulls {
+			rowNulls = make([]bool, len(t.Columns))
+		}
+		for j, data := range t.Data {
+			row[j] = fmt.Sprint(reflect.ValueOf(data).Index(i))
+			if t.Nulls[j] != nil {
+				rowNulls[j] = t.Nulls[j][i]
+			}
+		}
+		preview.Rows = append(preview.Rows, row)
+		if hasNulls {
+			preview.Nulls = append(preview.Nulls, rowNulls)
+		}
+	}
+	return preview
+}
+
+func (t *ArrowTable) String() string {
+	return t.preview(maxHTMLTableRows).String()
+}
+
+// Render renders the first rows of the table, with the type of each column under its
+// name.
+func (t *ArrowTable) Render() Data {
+	return t.preview(maxHTMLTableRows).Render()
+}
+
+// The enums of the Arrow format used by the exchange.
+const (
+	arrowMetadataV5 = 4
+
+	arrowHeaderSchema          = 1
+	arrowHeaderDictionaryBatch = 2
+	arrowHeaderRecordBatch     = 3
+
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+	arrowTypeDate          = 8
+	arrowTypeTimestamp     = 10
+	arrowTypeLargeUtf8     = 20
+
+	arrowPrecisionSingle = 1
+	arrowPrecisionDouble = 2
+
+	arrowUnitNanosecond = 3
+)
+
+// arrowMagic starts and ends the files of the Arrow IPC file format.
+const arrowMagic = "ARROW1"
+
+// arrowBlock locates a message in an Arrow IPC file.
+type arrowBlock struct {
+	offset, metadataLength, bodyLength int64
+}
+
+// encodeArrowFile encodes the table in the Arrow IPC file format, as a single record
+// batch.
+func encodeArrowFile(t *ArrowTable) ([]byte, error) {
+	var fields []*fbTable
+	for i, name := range t.Columns {
+		field, err := arrowField(name, t.Types[i], t.Nulls[i] != nil)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	schema := &fbTable{int16(0), fields}
+
+	var body bytes.Buffer
+	var nodes, buffers []int64
+	addBuffer := func(data []byte) {
+		buffers = append(buffers, int64(body.Len()), int64(len(data)))
+		body.Write(data)
+		body.Write(make([]byte, (8-body.Len()%8)%8))
+	}
+	n := t.len()
+	for i, data := range t.Data {
+		if reflect.ValueOf(data).Len() != n {
+			return nil, fmt.Errorf("column %s has %d values, expected %d", t.Columns[i], reflect.ValueOf(data).Len(), n)
+		}
+		nullCount := 0
+		var validity []byte
+		if nulls := t.Nulls[i]; nulls != nil {
+			validity = make([]byte, (n+7)/8)
+			for j, null := range nulls {
+				if null {
+					nullCount++
+				} else {
+					validity[j/8] |= 1 << uint(j%8)
+				}
+			}
+		}
+		nodes = append(nodes, int64(n), int64(nullCount))
+		addBuffer(validity)
+
+		var values bytes.Buffer
+		switch data := data.(type) {
+		case []int64:
+			binary.Write(&values, binary.LittleEndian, data)
+		case []uint64:
+			binary.Write(&values, binary.LittleEndian, data)
+		case []float64:
+			binary.Write(&values, binary.LittleEndian, data)
+		case []bool:
+			bits := make([]byte, (n+7)/8)
+			for j, b := range data {
+				if b {
+					bits[j/8] |= 1 << uint(j%8)
+				}
+			}
+			values.Write(bits)
+		case []time.Time:
+			for _, tm := range data {
+				ns := int64(0)
+				if !tm.IsZero() {
+					ns = tm.UnixNano()
+				}
+				binary.Write(&values, binary.LittleEndian, ns)
+			}
+		case []string:
+			offsets := make([]int32, 0, n+1)
+			var chars bytes.Buffer
+			for _, s := range data {
+				offsets = append(offsets, int32(chars.Len()))
+				chars.WriteString(s)
+				if chars.Len() > math.MaxInt32 {
+					return nil, fmt.Errorf("column %s: strings too long", t.Columns[i])
+				}
+			}
+			offsets = append(offsets, int32(chars.Len()))
+			binary.Write(&values, binary.LittleEndian, offsets)
+			addBuffer(values.Bytes())
+			values.Reset()
+			values.Write(chars.Bytes())
+		}
+		addBuffer(values.Bytes())
+	}
+	batch := &fbTable{int64(n), fbStructs{2, nodes}, fbStructs{2, buffers}}
+
+	out := []byte(arrowMagic + "\x00\x00")
+	out, _ = appendArrowMessage(out, arrowHeaderSchema, schema, nil)
+	out, block := appendArrowMessage(out, arrowHeaderRecordBatch, batch, body.Bytes())
+	out = append(out, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0)
+
+	blocks := []int64{block.offset, block.metadataLength, block.bodyLength}
+	footer := fbEncode(&fbTable{int16(arrowMetadataV5), schema, fbStructs{3, nil}, fbStructs{3, blocks}})
+	out = append(out, footer...)
+	out = appendUint32(out, uint32(len(footer)))
+	return append(out, arrowMagic...), nil
+}
+
+// arrowField returns the FlatBuffers Field of a column.
+func arrowField(name, typ string, nullable bool) (*fbTable, error) {
+	var typeType uint8
+	var typeTable *fbTable
+	switch typ {
+	case "int64":
+		typeType, typeTable = arrowTypeInt, &fbTable{int32(64), true}
+	case "uint64":
+		typeType, typeTable = arrowTypeInt, &fbTable{int32(64), false}
+	case "float64":
+		typeType, typeTable = arrowTypeFloatingPoint, &fbTable{int16(arrowPrecisionDouble)}
+	case "string":
+		typeType, typeTable = arrowTypeUtf8, &fbTable{}
+	case "bool":
+		typeType, typeTable = arrowTypeBool, &fbTable{}
+	case "time":
+		typeType, typeTable = arrowTypeTimestamp, &fbTable{int16(arrowUnitNanosecond), "UTC"}
+	default:
+		return nil, fmt.Errorf("column %s: unsupported type %s", name, typ)
+	}
+	return &fbTable{name, nullable, typeType, typeTable, nil, []*fbTable{}}, nil
+}
+
+// appendArrowMessage appends an encapsulated message to out: its metadata, padded so
+// that its body is aligned on 8 bytes, then its body.
+func appendArrowMessage(out []byte, headerType uint8, header *fbTable, body []byte) ([]byte, arrowBlock) {
+	metadata := fbEncode(&fbTable{int16(arrowMetadataV5), headerType, header, int64(len(body))})
+	metadata = append(metadata, make([]byte, (8-len(metadata)%8)%8)...)
+	block := arrowBlock{int64(len(out)), int64(8 + len(metadata)), int64(len(body))}
+	out = appendUint32(out, 0xffffffff)
+	out = appendUint32(out, uint32(len(metadata)))
+	out = append(out, metadata...)
+	return append(out, body...), block
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// arrowColumn is a column of the schema of an Arrow stream.
+type arrowColumn struct {
+	name     string
+	typeType uint8
+	bitWidth int32 // of integers
+	signed   bool  // of integers
+	unit     int16 // the precision of floats, or the unit of dates and timestamps
+}
+
+// typeName returns the type of the values of the column in an ArrowTable.
+func (c arrowColumn) typeName() string {
+	switch c.typeType {
+	case arrowTypeInt:
+		if c.signed {
+			return "int64"
+		}
+		return "uint64"
+	case arrowTypeFloatingPoint:
+		return "float64"
+	case arrowTypeUtf8, arrowTypeLargeUtf8:
+		return "string"
+	case arrowTypeBool:
+		return "bool"
+	}
+	return "time"
+}
+
+// decodeArrow decodes a table in the Arrow IPC file or stream format.
+func decodeArrow(data []byte) (table *ArrowTable, err error) {
+	// Malformed metadata makes the FlatBuffers decoder read out of bounds.
+	defer func() {
+		if r := recover(); r != nil {
+			table, err = nil, fmt.Errorf("invalid Arrow data: %v", r)
+		}
+	}()
+
+	if bytes.HasPrefix(data, []byte(arrowMagic)) {
+		// The file format embeds the stream format, which ends before the footer.
+		data = data[8:]
+	}
+	var columns []arrowColumn
+	table = &ArrowTable{}
+	for len(data) >= 4 {
+		size := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if size == 0xffffffff {
+			size = binary.LittleEndian.Uint32(data)
+			data = data[4:]
+		}
+		if size == 0 {
+			break
+		}
+		message := fbRoot(data[:size])
+		data = data[size:]
+		bodyLength := message.int64(3)
+		body := data[:bodyLength]
+		data = data[bodyLength:]
+
+		header, ok := message.table(2)
+		if !ok {
+			return nil, errors.New("message without header")
+		}
+		switch message.uint8(1) {
+		case arrowHeaderSchema:
+			if columns, err = decodeArrowSchema(header); err != nil {
+				return nil, err
+			}
+			for _, c := range columns {
+				table.Columns = append(table.Columns, c.name)
+				table.Types = append(table.Types, c.typeName())
+				table.Data = append(table.Data, reflect.MakeSlice(reflect.TypeOf(arrowColumnData(c.typeName())), 0, 0).Interface())
+				table.Nulls = append(table.Nulls, nil)
+			}
+		case arrowHeaderRecordBatch:
+			if columns == nil {
+				return nil, errors.New("record batch before the schema")
+			}
+			if err := decodeArrowBatch(table, columns, header, body); err != nil {
+				return nil, err
+			}
+		case arrowHeaderDictionaryBatch:
+			return nil, errors.New("dictionary-encoded columns are not supported")
+		}
+	}
+	if columns == nil {
+		return nil, errors.New("no schema")
+	}
+	return table, nil
+}
+
+// arrowColumnData returns an empty slice of the values of a column of type typ.
+func arrowColumnData(typ string) interface{} {
+	switch typ {
+	case "int64":
+		return []int64(nil)
+	case "uint64":
+		return []uint64(nil)
+	case "float64":
+		return []float64(nil)
+	case "string":
+		return []string(nil)
+	case "bool":
+		return []bool(nil)
+	}
+	return []time.Time(nil)
+}
+
+// decodeArrowSchema returns the columns of a Schema message.
+func decodeArrowSchema(schema fbTableReader) ([]arrowColumn, error) {
+	columns := []arrowColumn{}
+	fields, n := schema.vector(1)
+	for i := 0; i < n; i++ {
+		field := schema.tableAt(fields, i)
+		c := arrowColumn{name: field.string(0), typeType: field.uint8(2)}
+		if _, ok := field.table(4); ok {
+			return nil, fmt.Errorf("column %s: dictionary-encoded columns are not supported", c.name)
+		}
+		typ, _ := field.table(3)
+		switch c.typeType {
+		case arrowTypeInt:
+			c.bitWidth, c.signed = typ.int32(0), typ.bool(1)
+		case arrowTypeFloatingPoint, arrowTypeDate, arrowTypeTimestamp:
+			c.unit = typ.int16(0)
+			if c.typeType == arrowTypeDate && typ.field(0) == 0 {
+				// Dates default to milliseconds.
+				c.unit = 1
+			}
+			if c.typeType == arrowTypeFloatingPoint && c.unit == 0 {
+				return nil, fmt.Errorf("column %s: half-precision floats are not supported", c.name)
+			}
+		case arrowTypeUtf8, arrowTypeLargeUtf8, arrowTypeBool:
+		default:
+			return nil, fmt.Errorf("column %s: unsupported type %d", c.name, c.typeType)
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// decodeArrowBatch appends the rows of a RecordBatch message to table.
+func decodeArrowBatch(table *ArrowTable, columns []arrowColumn, batch fbTableReader, body []byte) error {
+	if _, ok := batch.table(3); ok {
+		return errors.New("compressed record batches are not supported: write them uncompressed")
+	}
+	n := int(batch.int64(0))
+	nodes, _ := batch.vector(1)
+	buffers, _ := batch.vector(2)
+	nextBuffer := 0
+	buffer := func() []byte {
+		offset := int64(binary.LittleEndian.Uint64(batch.buf[buffers+16*nextBuffer:]))
+		length := int64(binary.LittleEndian.Uint64(batch.buf[buffers+16*nextBuffer+8:]))
+		nextBuffer++
+		return body[offset : offset+length]
+	}
+	for i, c := range columns {
+		nullCount := int64(binary.LittleEndian.Uint64(batch.buf[nodes+16*i+8:]))
+		validity := buffer()
+		if nullCount > 0 && len(validity) != 0 {
+			nulls := table.Nulls[i]
+			if nulls == nil {
+				nulls = make([]bool, reflect.ValueOf(table.Data[i]).Len())
+			}
+			for j := 0; j < n; j++ {
+				nulls = append(nulls, validity[j/8]&(1<<uint(j%8)) == 0)
+			}
+			table.Nulls[i] = nulls
+		} else if table.Nulls[i] != nil {
+			table.Nulls[i] = append(table.Nulls[i], make([]bool, n)...)
+		}
+
+		values := buffer()
+		switch c.typeType {
+		case arrowTypeInt:
+			width := int(c.bitWidth / 8)
+			if c.signed {
+				data := table.Data[i].([]int64)
+				for j := 0; j < n; j++ {
+					data = append(data, arrowInt(values[j*width:], width))
+				}
+				table.Data[i] = data
+			} else {
+				data := table.Data[i].([]uint64)
+				for j := 0; j < n; j++ {
+					data = append(data, arrowUint(values[j*width:], width))
+				}
+				table.Data[i] = data
+			}
+		case arrowTypeFloatingPoint:
+			data := table.Data[i].([]float64)
+			for j := 0; j < n; j++ {
+				if c.unit == arrowPrecisionSingle {
+					data = append(data, float64(math.Float32frombits(binary.LittleEndian.Uint32(values[4*j:]))))
+				} else {
+					data = append(data, math.Float64frombits(binary.LittleEndian.Uint64(values[8*j:])))
+				}
+			}
+			table.Data[i] = data
+		case arrowTypeBool:
+			data := table.Data[i].([]bool)
+			for j := 0; j < n; j++ {
+				data = append(data, values[j/8]&(1<<uint(j%8)) != 0)
+			}
+			table.Data[i] = data
+		case arrowTypeUtf8, arrowTypeLargeUtf8:
+			chars := buffer()
+			width := 4
+			if c.typeType == arrowTypeLargeUtf8 {
+				width = 8
+			}
+			data := table.Data[i].([]string)
+			for j := 0; j < n; j++ {
+				data = append(data, string(chars[arrowInt(values[j*width:], width):arrowInt(values[(j+1)*width:], width)]))
+			}
+			table.Data[i] = data
+		case arrowTypeDate, arrowTypeTimestamp:
+			data := table.Data[i].([]time.Time)
+			for j := 0; j < n; j++ {
+				data = append(data, arrowTime(c, values, j))
+			}
+			table.Data[i] = data
+		}
+	}
+	return nil
+}
+
+// arrowInt decodes a little-endian signed integer of width bytes.
+func arrowInt(b []byte, width int) int64 {
+	switch width {
+	case 1:
+		return int64(int8(b[0]))
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(b)))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(b)))
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+// arrowUint decodes a little-endian unsigned integer of width bytes.
+func arrowUint(b []byte, width int) uint64 {
+	switch width {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(b))
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(b))
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// arrowTime decodes the j-th value of a date or timestamp column, in UTC.
+func arrowTime(c arrowColumn, values []byte, j int) time.Time {
+	if c.typeType == arrowTypeDate {
+		if c.unit == 0 {
+			days := int64(int32(binary.LittleEndian.Uint32(values[4*j:])))
+			return time.Unix(days*24*60*60, 0).UTC()
+		}
+		return time.Unix(0, int64(binary.LittleEndian.Uint64(values[8*j:]))*int64(time.Millisecond)).UTC()
+	}
+	v := int64(binary.LittleEndian.Uint64(values[8*j:]))
+	switch c.unit {
+	case 0:
+		return time.Unix(v, 0).UTC()
+	case 1:
+		return time.Unix(0, v*int64(time.Millisecond)).UTC()
+	case 2:
+		return time.Unix(0, v*int64(time.Microsecond)).UTC()
+	}
+	return time.Unix(0, v).UTC()
+}
+
+// fbTable is a FlatBuffers table to encode. Its fields are given in the order of their
+// ids, as int8, uint8, bool, int16, int32 or int64 scalars, strings, tables, vectors of
+// tables or vectors of structs. Nil fields are left out.
+type fbTable []interface{}
+
+// fbStructs is a vector of structs made of int64 fields, like the Buffer and FieldNode
+// structs of Arrow, with the given number of fields each. The Block struct, whose second
+// field is an int32, is encoded the same way since the padding after the int32 is zero.
+type fbStructs struct {
+	fields int
+	values []int64
+}
+
+// fbEncode encodes t as the root table of a FlatBuffers buffer.
+//
+// The FlatBuffers builders write buffers back to front, but the format only requires
+// that tables, strings and vectors follow the offsets referring to them, so the encoder
+// writes each object before the objects it refers to.
+func fbEncode(t *fbTable) []byte {
+	w := &fbWriter{buf: make([]byte, 4)}
+	w.patch(0, w.table(t))
+	return w.buf
+}
+
+type fbWriter struct {
+	buf []byte
+}
+
+func (w *fbWriter) align(n int) {
+	for len(w.buf)%n != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// patch points the offset at the position at to the object at the position target.
+func (w *fbWriter) patch(at, target int) {
+	binary.LittleEndian.PutUint32(w.buf[at:], uint32(target-at))
+}
+
+// table writes the vtable and the table t, then the objects it refers to, and returns
+// the position of the table.
+func (w *fbWriter) table(t *fbTable) int {
+	offsets := make([]int, len(*t))
+	size := 4
+	for i, field := range *t {
+		n := 4
+		switch field.(type) {
+		case nil:
+			continue
+		case int8, uint8, bool:
+			n = 1
+		case int16:
+			n = 2
+		case int64:
+			n = 8
+		}
+		size = (size + n - 1) / n * n
+		offsets[i] = size
+		size += n
+	}
+
+	w.align(2)
+	vtable := len(w.buf)
+	w.buf = append(w.buf, make([]byte, 4+2*len(*t))...)
+	binary.LittleEndian.PutUint16(w.buf[vtable:], uint16(4+2*len(*t)))
+	binary.LittleEndian.PutUint16(w.buf[vtable+2:], uint16(size))
+	for i, offset := range offsets {
+		binary.LittleEndian.PutUint16(w.buf[vtable+4+2*i:], uint16(offset))
+	}
+
+	w.align(8)
+	pos := len(w.buf)
+	w.buf = append(w.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(w.buf[pos:], uint32(pos-vtable))
+	for i, field := range *t {
+		at := pos + offsets[i]
+		switch field := field.(type) {
+		case int8:
+			w.buf[at] = byte(field)
+		case uint8:
+			w.buf[at] = field
+		case bool:
+			if field {
+				w.buf[at] = 1
+			}
+		case int16:
+			binary.LittleEndian.PutUint16(w.buf[at:], uint16(field))
+		case int32:
+			binary.LittleEndian.PutUint32(w.buf[at:], uint32(field))
+		case int64:
+			binary.LittleEndian.PutUint64(w.buf[at:], uint64(field))
+		}
+	}
+	for i, field := range *t {
+		at := pos + offsets[i]
+		switch field := field.(type) {
+		case string:
+			w.align(4)
+			w.patch(at, len(w.buf))
+			w.buf = appendUint32(w.buf, uint32(len(field)))
+			w.buf = append(append(w.buf, field...), 0)
+		case *fbTable:
+			w.patch(at, w.table(field))
+		case []*fbTable:
+			w.align(4)
+			vector := len(w.buf)
+			w.patch(at, vector)
+			w.buf = appendUint32(w.buf, uint32(len(field)))
+			w.buf = append(w.buf, make([]byte, 4*len(field))...)
+			for j, elem := range field {
+				w.patch(vector+4+4*j, w.table(elem))
+			}
+		case fbStructs:
+			// The structs are aligned on 8 bytes, after the length of the vector.
+			for len(w.buf)%8 != 4 {
+				w.buf = append(w.buf, 0)
+			}
+			w.patch(at, len(w.buf))
+			w.buf = appendUint32(w.buf, uint32(len(field.values)/field.fields))
+			for _, v := range field.values {
+				var b [8]byte
+				binary.LittleEndian.PutUint64(b[:], uint64(v))
+				w.buf = append(w.buf, b[:]...)
+			}
+		}
+	}
+	return pos
+}
+
+// fbTableReader reads a table of a FlatBuffers buffer.
+type fbTableReader struct {
+	buf []byte
+	pos int
+}
+
+// fbRoot returns the root table of buf.
+func fbRoot(buf []byte) fbTableReader {
+	return fbTableReader{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field returns the position of the field of the table with the given id, or 0 if the
+// table does not have it.
+func (t fbTableReader) field(id int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if 4+2*id >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return 0
+	}
+	offset := int(binary.LittleEndian.Uint16(t.buf[vtable+4+2*id:]))
+	if offset == 0 {
+		return 0
+	}
+	return t.pos + offset
+}
+
+func (t fbTableReader) uint8(id int) uint8 {
+	if at := t.field(id); at != 0 {
+		return t.buf[at]
+	}
+	return 0
+}
+
+func (t fbTableReader) bool(id int) bool {
+	return t.uint8(id) != 0
+}
+
+func (t fbTableReader) int16(id int) int16 {
+	if at := t.field(id); at != 0 {
+		return int16(binary.LittleEndian.Uint16(t.buf[at:]))
+	}
+	return 0
+}
+
+func (t fbTableReader) int32(id int) int32 {
+	if at := t.field(id); at != 0 {
+		return int32(binary.LittleEndian.Uint32(t.buf[at:]))
+	}
+	return 0
+}
+
+func (t fbTableReader) int64(id int) int64 {
+	if at := t.field(id); at != 0 {
+		return int64(binary.LittleEndian.Uint64(t.buf[at:]))
+	}
+	return 0
+}
+
+// deref returns the position of the object the offset at the position at refers to.
+func (t fbTableReader) deref(at int) int {
+	return at + int(binary.LittleEndian.Uint32(t.buf[at:]))
+}
+
+func (t fbTableReader) table(id int) (fbTableReader, bool) {
+	at := t.field(id)
+	if at == 0 {
+		return fbTableReader{}, false
+	}
+	return fbTableReader{t.buf, t.deref(at)}, true
+}
+
+func (t fbTableReader) string(id int) string {
+	at := t.field(id)
+	if at == 0 {
+		return ""
+	}
+	s := t.deref(at)
+	n := int(binary.LittleEndian.Uint32(t.buf[s:]))
+	return string(t.buf[s+4 : s+4+n])
+}
+
+// vector returns the position of the elements of the vector with the given id, and
+// their number.
+func (t fbTableReader) vector(id int) (int, int) {
+	at := t.field(id)
+	if at == 0 {
+		return 0, 0
+	}
+	v := t.deref(at)
+	return v + 4, int(binary.LittleEndian.Uint32(t.buf[v:]))
+}
+
+// tableAt returns the i-th table of the vector of tables whose elements start at elems.
+func (t fbTableReader) tableAt(elems, i int) fbTableReader {
+	return fbTableReader{t.buf, t.deref(elems + 4*i)}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type arrowRow struct {
+	ID    int
+	Name  string `arrow:"name"`
+	Score float32
+	Count uint16
+	Ok    bool
+	When  time.Time
+	note  string
+}
+
+// TestArrowRoundTrip tests writing tables in the Arrow IPC file format and reading them
+// back.
+func TestArrowRoundTrip(t *testing.T) {
+	when := time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC)
+	rows := []arrowRow{
+		{1, "a", 1.5, 7, true, when, "x"},
+		{-2, "", 2, 0, false, time.Time{}, "y"},
+		{3, "ccc", -0.25, 65535, true, when.Add(time.Hour), "z"},
+	}
+
+	t.Logf("Should convert slices of structs to tables.")
+
+	table, err := newArrowTable(rows)
+	if err != nil {
+		t.Fatalf("\t%s Could not convert the rows: %v.", failure, err)
+	}
+	wantColumns := []string{"ID", "name", "Score", "Count", "Ok", "When"}
+	wantTypes := []string{"int64", "string", "float64", "uint64", "bool", "time"}
+	if !reflect.DeepEqual(table.Columns, wantColumns) || !reflect.DeepEqual(table.Types, wantTypes) {
+		t.Fatalf("\t%s Expected the columns %v of types %v, got %v of types %v.", failure, wantColumns, wantTypes, table.Columns, table.Types)
+	}
+	t.Logf("\t%s Converted the rows.", success)
+
+	t.Logf("Should read the tables it writes.")
+
+	data, err := encodeArrowFile(table)
+	if err != nil {
+		t.Fatalf("\t%s Could not encode the table: %v.", failure, err)
+	}
+	if !bytes.HasPrefix(data, []byte("ARROW1\x00\x00")) || !bytes.HasSuffix(data, []byte("ARROW1")) || len(data)%2 != 0 {
+		t.Fatalf("\t%s Expected an Arrow IPC file, got %q.", failure, data)
+	}
+	footerSize := int(binary.LittleEndian.Uint32(data[len(data)-10:]))
+	footer := fbRoot(data[len(data)-10-footerSize : len(data)-10])
+	blocks, n := footer.vector(3)
+	offset := binary.LittleEndian.Uint64(footer.buf[blocks:])
+	if schema, _ := footer.table(1); n != 1 || binary.LittleEndian.Uint32(data[offset:]) != 0xffffffff || len(mustArrowSchema(t, schema)) != 6 {
+		t.Fatalf("\t%s Expected the footer to locate the schema and the record batch.", failure)
+	}
+	read, err := decodeArrow(data)
+	if err != nil {
+		t.Fatalf("\t%s Could not decode the table: %v.", failure, err)
+	}
+	if !reflect.DeepEqual(read.Columns, wantColumns) || !reflect.DeepEqual(read.Types, wantTypes) {
+		t.Fatalf("\t%s Expected the columns %v of types %v, got %v of types %v.", failure, wantColumns, wantTypes, read.Columns, read.Types)
+	}
+	if ids := arrowInts(read, "ID"); !reflect.DeepEqual(ids, []int64{1, -2, 3}) {
+		t.Fatalf("\t%s Expected the IDs to be read back, got %v.", failure, ids)
+	}
+	if names := arrowStrings(read, "name"); !reflect.DeepEqual(names, []string{"a", "", "ccc"}) {
+		t.Fatalf("\t%s Expected the names to be read back, got %q.", failure, names)
+	}
+	if scores := arrowFloats(read, "Score"); !reflect.DeepEqual(scores, []float64{1.5, 2, -0.25}) {
+		t.Fatalf("\t%s Expected the scores to be read back, got %v.", failure, scores)
+	}
+	if counts := arrowUints(read, "Count"); !reflect.DeepEqual(counts, []uint64{7, 0, 65535}) {
+		t.Fatalf("\t%s Expected the counts to be read back, got %v.", failure, counts)
+	}
+	if oks := arrowBools(read, "Ok"); !reflect.DeepEqual(oks, []bool{true, false, true}) {
+		t.Fatalf("\t%s Expected the booleans to be read back, got %v.", failure, oks)
+	}
+	times := arrowTimes(read, "When")
+	if !times[0].Equal(when) || !times[2].Equal(when.Add(time.Hour)) {
+		t.Fatalf("\t%s Expected the times to be read back, got %v.", failure, times)
+	}
+	if nulls := read.Nulls[5]; !reflect.DeepEqual(nulls, []bool{false, true, false}) || read.Nulls[0] != nil {
+		t.Fatalf("\t%s Expected the zero time to be null, got %v.", failure, read.Nulls)
+	}
+	t.Logf("\t%s Read the table back.", success)
+
+	t.Logf("Should refuse values that are not tables.")
+
+	for _, v := range []interface{}{42, []int{1}, []struct{ C chan int }{{}}} {
+		if _, err := newArrowTable(v); err == nil {
+			t.Fatalf("\t%s Expected %T to be refused.", failure, v)
+		}
+	}
+	if _, err := decodeArrow([]byte("ARROW1\x00\x00\xff\xff\xff\xff\x10\x00\x00\x00garbage")); err == nil {
+		t.Fatalf("\t%s Expected invalid data to be refused.", failure)
+	}
+	t.Logf("\t%s Refused them.", success)
+}
+
+func mustArrowSchema(t *testing.T, schema fbTableReader) []arrowColumn {
+	columns, err := decodeArrowSchema(schema)
+	if err != nil {
+		t.Fatalf("\t%s Could not decode the schema: %v.", failure, err)
+	}
+	return columns
+}
+
+// TestArrowExchange tests exchanging tables through the exchange directory from cells.
+func TestArrowExchange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arrowipc")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("GOPYTER_ARROW_DIR")
+	os.Setenv("GOPYTER_ARROW_DIR", dir)
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+
+	t.Logf("Should export and import tables from cells.")
+
+	code := "type Point struct {\n\tX int\n\tY float64\n}\n" +
+		"path := arrowipc.Export([]Point{Point{1, 0.5}, Point{2, 1.5}}, \"points\")\n" +
+		"points := arrowipc.Import(\"points\")\n" +
+		"arrowipc.Ints(points, \"X\")[1] + int64(arrowipc.Len(points))"
+	vals, err := kernel.doEvalGop(outerr, code)
+	if err != nil || len(vals) != 1 || vals[0] != int64(4) {
+		t.Fatalf("\t%s Expected the table to be exchanged, got %v (%v).", failure, vals, err)
+	}
+	if _, err := os.Stat(dir + "/points.arrow"); err != nil {
+		t.Fatalf("\t%s Expected the table in the exchange directory: %v.", failure, err)
+	}
+	t.Logf("\t%s Exchanged the table.", success)
+
+	if _, err := kernel.doEvalGop(outerr, "arrowipc.Export([]Point{}, \"../points\")"); err == nil {
+		t.Fatalf("\t%s Expected a name out of the exchange directory to be refused.", failure)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/goplus/gop/ast"
+)
+
+// skippedASTFields are the fields not followed by inspectAST. Objects and scopes point
+// back into the tree, and the imports, unresolved identifiers and comments of a file
+// repeat nodes found in its declarations.
+var skippedASTFields = map[string]bool{
+	"Obj":        true,
+	"Scope":      true,
+	"Imports":    true,
+	"Unresolved": true,
+	"Comments":   true,
+}
+
+// inspectAST traverses the syntax tree rooted at node in depth-first order, like
+// ast.Inspect: f is called for each node, and the children of a node are visited only
+// if f returns true. Unlike ast.Inspect, which panics on the Go+ comprehension nodes
+// of this version of gop, it discovers the children of a node by reflection and so
+// handles every node type.
+func inspectAST(node ast.Node, f func(ast.Node) bool) {
+	if node == nil || reflect.ValueOf(node).IsNil() || !f(node) {
+		return
+	}
+	inspectFields(reflect.ValueOf(node).Elem(), f)
+}
+
+func inspectFields(v reflect.Value, f func(ast.Node) bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Type().Field(i); field.PkgPath == "" && !skippedASTFields[field.Name] {
+			inspectValue(v.Field(i), f)
+		}
+	}
+}
+
+func inspectValue(v reflect.Value, f func(ast.Node) bool) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if n, ok := v.Interface().(ast.Node); ok {
+			inspectAST(n, f)
+			return
+		}
+		if v.Kind() == reflect.Ptr {
+			inspectValue(v.Elem(), f)
+		}
+	case reflect.Struct:
+		// Nodes stored by value, like the ForPhrases of a comprehension.
+		if v.CanAddr() {
+			if n, ok := v.Addr().Interface().(ast.Node); ok {
+				inspectAST(n, f)
+				return
+			}
+		}
+		inspectFields(v, f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			inspectValue(v.Index(i), f)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			inspectValue(v.MapIndex(key), f)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// In multi-user deployments, the kernel daemon hands kernels out only to the attach
+// processes presenting a valid token. The ZMQ channels of each kernel are already
+// authenticated by the key of its connection file, which only the process that wrote
+// the file knows, but any local user can connect to the socket of the daemon.
+
+// authenticator checks the tokens presented to the daemon.
+type authenticator interface {
+	// authenticate returns the user the token belongs to, or an error if it is not
+	// valid.
+	authenticate(token string) (user string, err error)
+}
+
+// authenticators are the authenticators the auth field of the configuration can name,
+// as name:target. Builds of the kernel add theirs with registerAuthenticator.
+var authenticators = map[string]func(target string) (authenticator, error){}
+
+// registerAuthenticator makes the authenticator opened by open available as name.
+func registerAuthenticator(name string, open func(target string) (authenticator, error)) {
+	authenticators[name] = open
+}
+
+func init() {
+	registerAuthenticator("token", openTokenAuth)
+	registerAuthenticator("oidc", func(userinfo string) (authenticator, error) {
+		return &oidcAuth{userinfo: userinfo, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	})
+}
+
+// errUnauthenticated is returned for the tokens that are not valid.
+var errUnauthenticated = errors.New("invalid token")
+
+// openAuth returns the authenticator the configuration sets, as name:target, or nil if
+// it sets none.
+func openAuth(config KernelConfig) (authenticator, error) {
+	if config.Auth == "" {
+		return nil, nil
+	}
+	i := strings.Index(config.Auth, ":")
+	if i <= 0 || authenticators[config.Auth[:i]] == nil {
+		return nil, fmt.Errorf("unknown authenticator %q, expected token:file, oidc:userinfo-url or a registered one", config.Auth)
+	}
+	name, target := config.Auth[:i], config.Auth[i+1:]
+	auth, err := authenticators[name](target)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the %s authenticator: %v", name, err)
+	}
+	return auth, nil
+}
+
+// tokenAuth accepts the tokens listed in a file, one per line, optionally followed by
+// the user they belong to.
+type tokenAuth struct {
+	users map[string]string // by token
+}
+
+func openTokenAuth(path string) (authenticator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	auth := &tokenAuth{users: make(map[string]string)}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		auth.users[fields[0]] = strings.Join(fields[1:], " ")
+	}
+	if len(auth.users) == 0 {
+		return nil, fmt.Errorf("no tokens in %s", path)
+	}
+	return auth, nil
+}
+
+func (a *tokenAuth) authenticate(token string) (string, error) {
+	// Compare every token in constant time, so the time taken tells nothing about them.
+	user, found := "", false
+	for t, u := range a.users {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			user, found = u, true
+		}
+	}
+	if !found {
+		return "", errUnauthenticated
+	}
+	return user, nil
+}
+
+// oidcAuth accepts the OIDC access tokens that the userinfo endpoint of the provider
+// accepts as bearer tokens. The user of a token is its subject, which the provider
+// never reassigns, unlike the preferred username users may be able to change.
+type oidcAuth struct {
+	userinfo string
+	client   *http.Client
+}
+
+func (a *oidcAuth) authenticate(token string) (string, error) {
+	if token == "" {
+		return "", errUnauthenticated
+	}
+	req, err := http.NewRequest(http.MethodGet, a.userinfo, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not validate the token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return "", errUnauthenticated
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not validate the token: %s", resp.Status)
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil || claims.Subject == "" {
+		return "", fmt.Errorf("invalid userinfo from %s", a.userinfo)
+	}
+	return claims.Subject, nil
+}
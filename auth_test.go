@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAuth tests the token and OIDC authenticators, and that the daemon refuses kernels
+// to the attach processes they do not accept.
+func TestAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	defer os.RemoveAll(dir)
+	tokens := filepath.Join(dir, "tokens")
+	if err := ioutil.WriteFile(tokens, []byte("# token user\nsecret1 alice\nsecret2\n"), 0600); err != nil {
+		t.Fatalf("\t%s Could not write the tokens: %v.", failure, err)
+	}
+
+	userinfo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer valid":
+			fmt.Fprint(w, `{"sub": "1234", "preferred_username": "bob"}`)
+		case "Bearer nouser":
+			fmt.Fprint(w, `{"sub": "5678"}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer userinfo.Close()
+
+	cases := []struct {
+		auth, token, user string
+		valid             bool
+	}{
+		{"token:" + tokens, "secret1", "alice", true},
+		{"token:" + tokens, "secret2", "", true},
+		{"token:" + tokens, "secret", "", false},
+		{"token:" + tokens, "", "", false},
+		{"oidc:" + userinfo.URL, "valid", "1234", true},
+		{"oidc:" + userinfo.URL, "nouser", "5678", true},
+		{"oidc:" + userinfo.URL, "expired", "", false},
+		{"oidc:" + userinfo.URL, "", "", false},
+	}
+
+	t.Logf("Should accept the valid tokens and find their users.")
+
+	for _, tc := range cases {
+		config := defaultConfig()
+		config.Auth = tc.auth
+		auth, err := openAuth(config)
+		if err != nil {
+			t.Fatalf("\t%s Could not open %s: %v.", failure, tc.auth, err)
+		}
+		user, err := auth.authenticate(tc.token)
+		if (err == nil) != tc.valid || user != tc.user {
+			t.Fatalf("\t%s Expected %q to be valid: %v for %q, got %q (%v).", failure, tc.token, tc.valid, tc.user, user, err)
+		}
+		t.Logf("\t%s %q: %q, %v.", success, tc.token, user, err)
+	}
+
+	t.Logf("Should refuse the unknown authenticators.")
+
+	for _, name := range []string{"ldap:x", "token", "token:" + filepath.Join(dir, "missing")} {
+		config := defaultConfig()
+		config.Auth = name
+		if _, err := openAuth(config); err == nil {
+			t.Fatalf("\t%s Expected an error for %q.", failure, name)
+		}
+	}
+	t.Logf("\t%s Refused them.", success)
+
+	t.Logf("Should refuse a kernel to an attach process with an invalid token.")
+
+	config := defaultConfig()
+	config.Auth = "token:" + tokens
+	auth, err := openAuth(config)
+	if err != nil {
+		t.Fatalf("\t%s Could not open the authenticator: %v.", failure, err)
+	}
+	daemon, client := net.Pipe()
+	// No kernel is ready, so handleAttach would block if it accepted the token.
+	pool := &kernelPool{ready: map[string]chan *standbyKernel{"": make(chan *standbyKernel)}}
+	go handleAttach(daemon, pool, auth)
+	go json.NewEncoder(client).Encode(attachRequest{ConnectionFile: "kernel.json", Token: "wrong"})
+	var reply attachReply
+	if err := json.NewDecoder(client).Decode(&reply); err != nil || reply.Error == "" || reply.PID != 0 {
+		t.Fatalf("\t%s Expected an error, got %+v (%v).", failure, reply, err)
+	}
+	client.Close()
+	t.Logf("\t%s Refused it: %s.", success, reply.Error)
+}
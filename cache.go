@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	cellMagics["cache"] = evalCacheMagic
+	documentMagic(cacheSyntax)
+}
+
+var cacheSyntax = &magicSyntax{
+	name:  "%%cache",
+	usage: []string{"key=<name> [inputs=<var>,<var>...] [refresh]"},
+	doc: "Evaluates the cell and saves its rendered result on disk. Executing the cell again, " +
+		"even after a restart, displays the saved result as long as the source of the cell " +
+		"and the values of its inputs are unchanged.",
+	options: []magicParam{
+		{name: "key", help: "the name the result is saved under"},
+		{name: "inputs", help: "the variables the result depends on, separated by commas"},
+		{name: "refresh", help: "evaluates the cell and saves its result again", isSwitch: true},
+	},
+}
+
+// cachedResult is the result of a cell stored by %%cache.
+type cachedResult struct {
+	Hash     string    `json:"hash"`
+	Created  time.Time `json:"created"`
+	Data     MIMEMap   `json:"data"`
+	Metadata MIMEMap   `json:"metadata,omitempty"`
+}
+
+// Render implements Renderer, so a cached result is displayed exactly as the result it
+// was saved from.
+func (r cachedResult) Render() Data {
+	return Data{Data: r.Data, Metadata: r.Metadata}
+}
+
+var cacheKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// evalCacheMagic implements
+//
+//	%%cache key=<name> [inputs=<var>,<var>...] [refresh]
+//
+// which evaluates the cell and saves its rendered result on disk under the given key.
+// When the cell is executed again with the same source and the same values of the
+// declared inputs, the saved result is displayed without evaluating the cell. The
+// refresh switch forces the cell to be evaluated and its result saved again.
+func evalCacheMagic(kernel *Kernel, outerr OutErr, args string, body string) ([]interface{}, error) {
+	parsed, err := cacheSyntax.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	options := parsed.options
+
+	key := options["key"]
+	if !cacheKeyPattern.MatchString(key) {
+		return nil, fmt.Errorf("%%%%cache: key=<name> is required and may only contain letters, digits, '_', '.' and '-'")
+	}
+
+	hash, err := kernel.cacheHash(body, options["inputs"])
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := kernel.cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, key+".json")
+
+	if options["refresh"] != "true" {
+		if cached, err := readCachedResult(path); err == nil && cached.Hash == hash {
+			return []interface{}{cached}, nil
+		}
+	}
+
+	vals, err := kernel.doEvalGop(outerr, body)
+	if err != nil {
+		return nil, err
+	}
+
+	data := kernel.autoRenderResults(vals)
+	cached := cachedResult{
+		Hash:     hash,
+		Created:  time.Now().UTC(),
+		Data:     data.Data,
+		Metadata: data.Metadata,
+	}
+	if err := writeCachedResult(path, cached); err != nil {
+		fmt.Fprintf(outerr.err, "%%%%cache: could not save the result: %v\n", err)
+	}
+
+	return vals, nil
+}
+
+// cacheHash hashes the source of a cell together with the current values of its
+// declared inputs, a comma separated list of expressions.
+func (kernel *Kernel) cacheHash(body string, inputs string) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(body))
+
+	for _, input := range strings.Split(inputs, ",") {
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+		vals, err := kernel.session.Peek(input)
+		if err != nil {
+			return "", xerrors.Errorf("%%%%cache: could not read input %s: %w", input, err)
+		}
+		fmt.Fprintf(h, "\x00%s=%#v", input, vals)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheDir returns the directory holding the results saved by %%cache, creating it if
+// needed.
+func (kernel *Kernel) cacheDir() (string, error) {
+	dir := kernel.config.CacheDir
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", xerrors.Errorf("%%%%cache: could not find a cache directory: %w", err)
+		}
+		dir = filepath.Join(userDir, "gopyter", "results")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", xerrors.Errorf("%%%%cache: could not create the cache directory: %w", err)
+	}
+	return dir, nil
+}
+
+func readCachedResult(path string) (cachedResult, error) {
+	var cached cachedResult
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cached, err
+	}
+	err = json.Unmarshal(data, &cached)
+	return cached, err
+}
+
+func writeCachedResult(path string, cached cachedResult) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
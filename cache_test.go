@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestCacheMagic tests that %%cache reuses a result until the cell or its inputs change.
+func TestCacheMagic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := defaultConfig()
+	config.CacheDir = dir
+	kernel := Kernel{NewSession(), config}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+
+	eval := func(code string) []interface{} {
+		vals, err := kernel.doEvalGop(outerr, code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %q failed: %v.", failure, code, err)
+		}
+		return vals
+	}
+
+	t.Logf("Should save the result of a cell and reuse it.")
+
+	eval("n := 3")
+	cell := "%%cache key=square inputs=n\nn*n"
+	if vals := eval(cell); len(vals) != 1 || vals[0] != 9 {
+		t.Fatalf("\t%s First evaluation returned %v, expected 9.", failure, vals)
+	}
+	vals := eval(cell)
+	if cached, ok := vals[0].(cachedResult); !ok || cached.Data[MIMETypeText] != "9" {
+		t.Fatalf("\t%s Second evaluation returned %v, expected the cached result.", failure, vals)
+	}
+	t.Logf("\t%s Reused the cached result.", success)
+
+	eval("n = 4")
+	if vals := eval(cell); len(vals) != 1 || vals[0] != 16 {
+		t.Fatalf("\t%s Evaluation after changing n returned %v, expected 16.", failure, vals)
+	}
+	t.Logf("\t%s Recomputed the result after an input changed.", success)
+
+	if _, err := kernel.doEvalGop(outerr, "%%cache\nn"); err == nil {
+		t.Fatalf("\t%s Expected an error for a missing key.", failure)
+	}
+	t.Logf("\t%s Rejected a cell without a key.", success)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	cellMagics["capture"] = evalCaptureMagic
+	documentMagic(captureSyntax)
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("_gopyter_captured", capturedOutput, execCapturedOutput),
+	)
+}
+
+var captureSyntax = &magicSyntax{
+	name:  "%%capture",
+	usage: []string{"<var>"},
+	doc:   "Evaluates the cell without showing its output, and binds what it wrote to stdout and stderr and its result to a variable, as a `*CapturedOutput`.",
+	args:  []magicParam{{name: "var", help: "the variable to bind the output to"}},
+}
+
+// CapturedOutput is the output of a cell captured by %%capture.
+type CapturedOutput struct {
+	Stdout      string
+	Stderr      string
+	DisplayData []Data // the rendered result of the cell, if it has one
+}
+
+func (c *CapturedOutput) String() string {
+	return fmt.Sprintf("<captured output: %d bytes of stdout, %d bytes of stderr, %d display data>",
+		len(c.Stdout), len(c.Stderr), len(c.DisplayData))
+}
+
+// capturedOutputs holds the captured outputs until they are bound to their variable.
+var capturedOutputs = map[string]*CapturedOutput{}
+
+func capturedOutput(name string) *CapturedOutput {
+	return capturedOutputs[name]
+}
+
+func execCapturedOutput(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, capturedOutput(args[0].(string)))
+}
+
+// evalCaptureMagic implements
+//
+//	%%capture <var>
+//
+// which evaluates the cell without showing its output, and instead binds what it wrote
+// to stdout and stderr and its result to the variable, as a *CapturedOutput. The output
+// is captured even if the cell fails.
+func evalCaptureMagic(kernel *Kernel, outerr OutErr, args string, body string) ([]interface{}, error) {
+	parsed, err := captureSyntax.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	name := parsed.arg(0)
+	if !token.IsIdentifier(name) || name == "_" {
+		return nil, captureSyntax.errorf("expected the name of a variable, got %q", name)
+	}
+
+	var vals []interface{}
+	stdout, stderr, captureErr := captureOutput(func(outerr OutErr) {
+		vals, err = kernel.doEvalGop(outerr, body)
+	})
+	if captureErr != nil {
+		return nil, captureErr
+	}
+
+	redactor := kernel.session.redactor
+	captured := &CapturedOutput{Stdout: redactor.redact(stdout), Stderr: redactor.redact(stderr)}
+	if err == nil {
+		if data := redactor.redactData(kernel.autoRenderResults(vals)); len(data.Data) != 0 {
+			captured.DisplayData = append(captured.DisplayData, data)
+		}
+	}
+	capturedOutputs[name] = captured
+
+	define := ":="
+	if kernel.session.declared([]string{name}) {
+		define = "="
+	}
+	if _, bindErr := kernel.session.Eval(fmt.Sprintf("%s %s _gopyter_captured(%q)", name, define, name)); bindErr != nil {
+		return nil, fmt.Errorf("%%%%capture: could not bind %s: %v", name, bindErr)
+	}
+	return nil, err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// TestCaptureMagic tests that %%capture binds the output of a cell to a variable.
+func TestCaptureMagic(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+
+	t.Logf("Should bind the output and the result of the cell instead of showing them.")
+
+	vals, err := kernel.doEvalGop(outerr, "%%capture out\nprintln(\"hello\")")
+	if err != nil {
+		t.Fatalf("\t%s Evaluating the cell failed: %v.", failure, err)
+	}
+	if len(vals) != 0 {
+		t.Fatalf("\t%s Expected no result, got %v.", failure, vals)
+	}
+
+	if vals, err := kernel.session.Peek("out.Stdout"); err != nil || len(vals) != 1 || vals[0] != "hello\n" {
+		t.Fatalf("\t%s Expected the captured stdout to be \"hello\\n\", got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Captured the output.", success)
+
+	t.Logf("Should bind the result again to the same variable.")
+
+	if _, err := kernel.doEvalGop(outerr, "%%capture out\n1 + 2"); err != nil {
+		t.Fatalf("\t%s Evaluating the cell failed: %v.", failure, err)
+	}
+	if vals, err := kernel.session.Peek("out.DisplayData[0].Data[\"text/plain\"]"); err != nil || len(vals) != 1 || vals[0] != "3" {
+		t.Fatalf("\t%s Expected the captured result to be 3, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Captured the result.", success)
+}
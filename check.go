@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/scanner"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	cellMagics["check"] = evalCheckMagic
+	documentMagic(checkSyntax)
+}
+
+var checkSyntax = &magicSyntax{
+	name: "%%check",
+	doc:  "Parses and compiles the cell against the session without executing it, and reports the errors and lint warnings found. The cell fails if it has errors.",
+}
+
+// diagnostic is a problem found in a cell without executing it.
+type diagnostic struct {
+	Line, Column int // in the cell, starting at 1; 0 if unknown
+	Severity     string
+	Message      string
+}
+
+func (d diagnostic) String() string {
+	switch {
+	case d.Line == 0:
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	case d.Column == 0:
+		return fmt.Sprintf("%d: %s: %s", d.Line, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Message)
+}
+
+// evalCheckMagic implements `%%check`, which parses and compiles the cell against the
+// session without executing it, and reports the errors found with their position along
+// with the lint warnings. The cell fails if it has errors.
+func evalCheckMagic(kernel *Kernel, outerr OutErr, args string, body string) ([]interface{}, error) {
+	if _, err := checkSyntax.parse(args); err != nil {
+		return nil, err
+	}
+	diagnostics := kernel.session.check(body)
+	errorCount := 0
+	for _, d := range diagnostics {
+		if d.Severity == "error" {
+			errorCount++
+		}
+		fmt.Fprintln(outerr.out, d)
+	}
+	switch {
+	case errorCount == 1:
+		return nil, errors.New("%%check: 1 error")
+	case errorCount > 1:
+		return nil, fmt.Errorf("%%%%check: %d errors", errorCount)
+	case len(diagnostics) == 0:
+		fmt.Fprintln(outerr.out, "No problems found.")
+	}
+	return nil, nil
+}
+
+// check parses and compiles code in the session, without executing it or changing the
+// session, and returns the problems found. Positions are relative to code, which may
+// start with line magics but not a cell magic.
+func (s *Session) check(code string) []diagnostic {
+	stripped := rewriteGopSyntax(stripSpecialCommands(code))
+	if d, ok := parseDiagnostics(stripped); !ok {
+		return d
+	}
+
+	sessionImported, sessionSrc := s.imports, s.src
+	if sessionSrc == "" {
+		sessionImported, sessionSrc = s.preload, sessionPrelude
+	}
+	imports, rest := splitImports(stripped)
+	decls, rest := splitDecls(rest)
+	imports = sessionImported + imports + "\n"
+	source := s.decls + decls + "\n" + sessionSrc + rest + "\n"
+	imports += packageImports(imports+source, decls+rest)
+	var diagnostics []diagnostic
+	err := compileError(func() error {
+		_, err := s.compile(imports + source)
+		return err
+	})
+	if err != nil {
+		d := diagnostic{Severity: "error", Message: strings.TrimSpace(err.Error())}
+		d.Line, d.Column = locateError(stripped, err)
+		diagnostics = append(diagnostics, d)
+	}
+
+	for _, w := range lintCell(stripped) {
+		diagnostics = append(diagnostics, diagnostic{Line: w.Line, Severity: "warning", Message: fmt.Sprintf("%s (%s)", w.Message, w.Check)})
+	}
+	return diagnostics
+}
+
+// compileError runs compile, turning the panics the compiler reports errors with into
+// an error.
+func compileError(compile func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var ok bool
+			if err, ok = r.(error); !ok {
+				err = errors.New(fmt.Sprint(r))
+			}
+		}
+	}()
+	return compile()
+}
+
+// parseDiagnostics parses code and returns its syntax errors, with ok false if there
+// are any. The parser of Go+ wraps the code of a cell in a package clause and a main
+// function starting at its first statement, and reports positions in the wrapped code,
+// which are mapped back to the cell here.
+func parseDiagnostics(code string) (diagnostics []diagnostic, ok bool) {
+	const pkg, fn = len("package main;"), len(" func main(){")
+	imports, rest := splitImports(code)
+	start := len(imports) + len(rest) - len(strings.TrimLeft(rest, " \t\r\n;"))
+
+	_, err := parser.Parse(token.NewFileSet(), "", code, 0)
+	if err == nil {
+		return nil, true
+	}
+	list, isList := err.(scanner.ErrorList)
+	if !isList {
+		return []diagnostic{{Severity: "error", Message: err.Error()}}, false
+	}
+	for _, e := range list {
+		if strings.HasPrefix(e.Msg, "expected declaration") && len(list) > 1 {
+			// Left by the attempt to parse the cell before wrapping it.
+			continue
+		}
+		offset := e.Pos.Offset - pkg
+		if offset >= start+fn {
+			offset -= fn
+		}
+		d := diagnostic{Severity: "error", Message: e.Msg}
+		if offset >= 0 && offset <= len(code) {
+			d.Line, d.Column = lineColumn(code, offset)
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics, false
+}
+
+// lineColumn converts an offset in code to a line and a column, starting at 1.
+func lineColumn(code string, offset int) (line, column int) {
+	before := code[:offset]
+	line = strings.Count(before, "\n") + 1
+	column = offset - strings.LastIndex(before, "\n")
+	return line, column
+}
+
+// compileErrorSymbol matches the symbol named at the end of the compiler's errors, as
+// in "compileIdent failed: unknown - foo" or "symbol not found - int Foo".
+var compileErrorSymbol = regexp.MustCompile(`([\pL_][\pL\pN_]*)\s*$`)
+
+// locateError finds where the symbol a compile error is about first appears in code,
+// since the compiler does not report positions.
+func locateError(code string, err error) (line, column int) {
+	match := compileErrorSymbol.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0, 0
+	}
+
+	var sc scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(code))
+	sc.Init(file, []byte(code), nil, 0)
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			return 0, 0
+		}
+		if tok == token.IDENT && lit == match[1] {
+			return lineColumn(code, file.Offset(pos))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// TestCheck tests checking cells against the session without executing them.
+func TestCheck(t *testing.T) {
+	s := NewSession()
+	if _, err := s.Eval("x := 1"); err != nil {
+		t.Fatalf("\t%s Could not set up the session: %v.", failure, err)
+	}
+
+	cases := []struct {
+		code string
+		want string
+	}{
+		{"y := x + 1", ""},
+		{"import \"strings\"\ny := strings.ToUpper(\"a\")", ""},
+		{"y := 1\nz := (", "2:7: error: expected operand, found '}'"},
+		{"y := 1\nz := foo(y)", "2:6: error: compileIdent failed: unknown - foo"},
+		{"y := 1\ny = y", "2: warning: self-assignment of y to y (assign)"},
+	}
+
+	t.Logf("Should report the problems of cells with their position.")
+
+	for _, tc := range cases {
+		diagnostics := s.check(tc.code)
+		got := ""
+		if len(diagnostics) != 0 {
+			got = diagnostics[0].String()
+		}
+		if got != tc.want || len(diagnostics) > 1 {
+			t.Errorf("\t%s %q: expected %q, got %v.", failure, tc.code, tc.want, diagnostics)
+			continue
+		}
+		t.Logf("\t%s %q: %q", success, tc.code, got)
+	}
+
+	t.Logf("Should not execute the cells.")
+
+	kernel := Kernel{s, defaultConfig()}
+	if _, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, "%%check\nx = 2"); err != nil {
+		t.Fatalf("\t%s Checking failed: %v.", failure, err)
+	}
+	if vals, err := s.Peek("x"); err != nil || vals[0] != 1 {
+		t.Fatalf("\t%s Expected x to still be 1, got %v (%v).", failure, vals, err)
+	}
+	if _, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, "%%check\nx = foo"); err == nil {
+		t.Fatalf("\t%s Expected the check to fail.", failure)
+	}
+	t.Logf("\t%s The cells were only checked.", success)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	exec "github.com/goplus/gop/exec/bytecode"
+)
+
+func init() {
+	lineMagics["checkpoint"] = evalCheckpointMagic
+	lineMagics["rollback"] = evalRollbackMagic
+	documentMagic(checkpointSyntax)
+	documentMagic(rollbackSyntax)
+}
+
+var (
+	checkpointSyntax = &magicSyntax{
+		name:  "%checkpoint",
+		usage: []string{"[name]"},
+		doc:   "Saves the variables of the session, so that `%rollback` can restore them. Without a name, lists the checkpoints.",
+		args:  []magicParam{{name: "name", help: "the name of the checkpoint", optional: true}},
+	}
+	rollbackSyntax = &magicSyntax{
+		name:  "%rollback",
+		usage: []string{"<name>"},
+		doc:   "Restores the variables saved by `%checkpoint`, forgetting the cells executed since then.",
+		args:  []magicParam{{name: "name", help: "the name of the checkpoint"}},
+	}
+)
+
+// sessionState is a snapshot of the interpreter state of a session.
+//
+// Each cell is executed in a new context that receives copies of the variables of the
+// previous one, so the context of a snapshot is never modified by later cells and can
+// be kept as it is. Only the contents of maps, slices and pointers are shared with
+// later cells.
+type sessionState struct {
+	imports string
+	decls   string
+	src     string
+	ctx     *exec.Context
+	ip      int
+}
+
+// checkpoint saves the current state of the session under name.
+func (s *Session) checkpoint(name string) {
+	if s.checkpoints == nil {
+		s.checkpoints = make(map[string]sessionState)
+	}
+	s.checkpoints[name] = sessionState{s.imports, s.decls, s.src, s.ctx, s.ip}
+}
+
+// rollback restores the state of the session saved under name.
+func (s *Session) rollback(name string) error {
+	state, ok := s.checkpoints[name]
+	if !ok {
+		return fmt.Errorf("no checkpoint %q", name)
+	}
+	sourceLock.Lock()
+	s.imports, s.decls, s.src, s.ctx, s.ip = state.imports, state.decls, state.src, state.ctx, state.ip
+	sourceLock.Unlock()
+	return nil
+}
+
+// evalCheckpointMagic implements `%checkpoint name`, which saves the variables of the
+// session so that `%rollback name` can restore them later. Without a name, it lists
+// the checkpoints.
+func evalCheckpointMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := checkpointSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(parsed.args) == 0 {
+		names := make([]string, 0, len(kernel.session.checkpoints))
+		for name := range kernel.session.checkpoints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(outerr.out, name)
+		}
+		return nil
+	}
+	kernel.session.checkpoint(parsed.arg(0))
+	return nil
+}
+
+// evalRollbackMagic implements `%rollback name`, which restores the variables saved by
+// `%checkpoint name`. Cells executed since then are forgotten, but stay in the history.
+func evalRollbackMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := rollbackSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	if err := kernel.session.rollback(parsed.arg(0)); err != nil {
+		return fmt.Errorf("%%rollback: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// TestCheckpointRollback tests that a rollback restores the variables of a checkpoint.
+func TestCheckpointRollback(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+
+	eval := func(code string) []interface{} {
+		vals, err := kernel.doEvalGop(outerr, code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %q failed: %v.", failure, code, err)
+		}
+		return vals
+	}
+
+	t.Logf("Should restore the variables saved by a checkpoint.")
+
+	eval("x := 1")
+	eval("%checkpoint start\nx = 100\ny := 2")
+	if vals := eval("x"); vals[0] != 100 {
+		t.Fatalf("\t%s Expected x to be 100 before the rollback, got %v.", failure, vals)
+	}
+
+	if vals := eval("%rollback start\nx"); len(vals) != 1 || vals[0] != 1 {
+		t.Fatalf("\t%s Expected x to be 1 after the rollback, got %v.", failure, vals)
+	}
+	if _, err := kernel.session.Peek("y"); err == nil {
+		t.Fatalf("\t%s Expected y to be undeclared after the rollback.", failure)
+	}
+	t.Logf("\t%s Restored the checkpoint.", success)
+
+	if _, err := kernel.doEvalGop(outerr, "%rollback unknown"); err == nil {
+		t.Fatalf("\t%s Expected an error for an unknown checkpoint.", failure)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import "log"
+
+// A comm is a channel between the kernel and a front-end extension, opened by the
+// front-end with a comm_open message naming a target registered by the kernel. Targets
+// register themselves in commTargets from an init function in the file implementing
+// them.
+type comm struct {
+	id     string
+	target string
+
+	// onMsg, if set, handles the comm_msg messages sent by the front-end. Their binary
+	// buffers are in receipt.Msg.Buffers.
+	onMsg func(receipt msgReceipt, data map[string]interface{})
+	// onClose, if set, is called when the front-end closes the comm.
+	onClose func()
+}
+
+// commTarget sets up a comm opened by the front-end with the data of the comm_open
+// message. It may send messages on the comm and set its handlers.
+type commTarget func(kernel *Kernel, receipt msgReceipt, c *comm, data map[string]interface{}) error
+
+var (
+	commTargets = map[string]commTarget{}
+	openComms   = map[string]*comm{}
+)
+
+// send sends data to the front-end side of the comm.
+func (c *comm) send(receipt msgReceipt, data interface{}) error {
+	return c.sendBuffers(receipt, data, nil)
+}
+
+// sendBuffers sends data to the front-end side of the comm, followed by binary buffers,
+// which front-ends receive as ArrayBuffers without the cost of base64.
+func (c *comm) sendBuffers(receipt msgReceipt, data interface{}, buffers [][]byte) error {
+	return receipt.PublishWithBuffers("comm_msg", map[string]interface{}{
+		"comm_id": c.id,
+		"data":    data,
+	}, buffers)
+}
+
+// commsOf returns the open comms of target.
+func commsOf(target string) []*comm {
+	var comms []*comm
+	for _, c := range openComms {
+		if c.target == target {
+			comms = append(comms, c)
+		}
+	}
+	return comms
+}
+
+// handleCommOpen opens the comm requested by a comm_open message. Comms to unknown
+// targets are closed right away, as the protocol requires.
+func (kernel *Kernel) handleCommOpen(receipt msgReceipt) error {
+	content := receipt.Msg.Content.(map[string]interface{})
+	id, _ := content["comm_id"].(string)
+	targetName, _ := content["target_name"].(string)
+	data, _ := content["data"].(map[string]interface{})
+
+	target, ok := commTargets[targetName]
+	if !ok {
+		log.Printf("Closing comm to unknown target %q\n", targetName)
+		return receipt.Publish("comm_close", map[string]interface{}{"comm_id": id, "data": map[string]interface{}{}})
+	}
+
+	c := &comm{id: id, target: targetName}
+	openComms[id] = c
+	if err := target(kernel, receipt, c, data); err != nil {
+		delete(openComms, id)
+		log.Printf("Error opening comm to %s: %v\n", targetName, err)
+		return receipt.Publish("comm_close", map[string]interface{}{"comm_id": id, "data": map[string]interface{}{"error": err.Error()}})
+	}
+	return nil
+}
+
+// handleCommMsg passes a comm_msg message to its comm.
+func handleCommMsg(receipt msgReceipt) {
+	content := receipt.Msg.Content.(map[string]interface{})
+	id, _ := content["comm_id"].(string)
+	data, _ := content["data"].(map[string]interface{})
+
+	if c, ok := openComms[id]; ok && c.onMsg != nil {
+		c.onMsg(receipt, data)
+	}
+}
+
+// handleCommClose forgets the comm closed by a comm_close message.
+func handleCommClose(receipt msgReceipt) {
+	content := receipt.Msg.Content.(map[string]interface{})
+	id, _ := content["comm_id"].(string)
+
+	if c, ok := openComms[id]; ok {
+		delete(openComms, id)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	}
+}
+
+// handleCommInfoRequest replies with the open comms, optionally only those of the
+// requested target.
+func handleCommInfoRequest(receipt msgReceipt) error {
+	content := receipt.Msg.Content.(map[string]interface{})
+	targetName, _ := content["target_name"].(string)
+
+	comms := make(map[string]interface{})
+	for id, c := range openComms {
+		if targetName == "" || c.target == targetName {
+			comms[id] = map[string]interface{}{"target_name": c.target}
+		}
+	}
+	return receipt.Reply("comm_info_reply", map[string]interface{}{
+		"status": "ok",
+		"comms":  comms,
+	})
+}
@@ -1,47 +1,463 @@
 package main
 
-import "fmt"
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
-type Completion struct {
-	class,
-	name,
-	typ string
+	exec "github.com/goplus/gop/exec/bytecode"
+)
+
+// maxCompletions bounds the number of completions of a request, e.g. of a path in a
+// large directory.
+const maxCompletions = 200
+
+// completions are the completions of the code at the cursor, which replace the code
+// from start to end, as byte offsets.
+type completions struct {
+	start, end int
+	matches    []string
+	types      []completionType // describes the matches, if they are not plain names
+}
+
+// completionType describes a match in the _jupyter_types_experimental metadata of the
+// reply, which JupyterLab shows next to the matches. Snippets also hold their template,
+// with LSP-style placeholders like ${1:name} and the final cursor $0, for extensions
+// that can step through them; the match is the template with the default values.
+type completionType struct {
+	Start     int    `json:"start"` // in code points, like the cursor
+	End       int    `json:"end"`
+	Text      string `json:"text"`
+	Type      string `json:"type"`
+	Signature string `json:"signature,omitempty"`
+	Snippet   string `json:"snippet,omitempty"`
 }
 
-type CompletionResponse struct {
-	partial     int
-	completions []Completion
+// add adds a match described by typ.
+func (c *completions) add(text string, typ completionType) {
+	typ.Text = text
+	c.matches = append(c.matches, text)
+	c.types = append(c.types, typ)
 }
 
-/************************************************************
-* entry function
-************************************************************/
-func handleCompleteRequest(receipt msgReceipt) error {
-	return nil
-	// Extract the data from the request.
+// handleCompleteRequest replies to a complete_request with the completions of the code
+// at the cursor.
+func (kernel *Kernel) handleCompleteRequest(receipt msgReceipt) error {
 	reqcontent := receipt.Msg.Content.(map[string]interface{})
-	code := reqcontent["code"].(string)
-	fmt.Println(code)
-	cursorPos := int(reqcontent["cursor_pos"].(float64))
-
-	// autocomplete the code at the cursor position
-	matches := ""
-
-	// prepare the reply
-	content := make(map[string]interface{})
-
-	if len(matches) == 0 {
-		content["ename"] = "ERROR"
-		content["evalue"] = "no completions found"
-		content["traceback"] = nil
-		content["status"] = "error"
-	} else {
-		partialWord := ""
-		content["cursor_start"] = float64(0 - len(partialWord))
-		content["cursor_end"] = float64(cursorPos)
-		content["matches"] = matches
-		content["status"] = "ok"
-	}
-
-	return receipt.Reply("complete_reply", content)
+	code, _ := reqcontent["code"].(string)
+	cursorPos, _ := reqcontent["cursor_pos"].(float64)
+
+	// The protocol counts positions in code points.
+	cursor := byteOffset(code, int(cursorPos))
+	c := kernel.snapshot().complete(code, cursor)
+	if c.matches == nil {
+		c.matches, c.start, c.end = []string{}, cursor, cursor
+	}
+	start, end := utf8.RuneCountInString(code[:c.start]), utf8.RuneCountInString(code[:c.end])
+	metadata := map[string]interface{}{}
+	if c.types != nil {
+		for i := range c.types {
+			c.types[i].Start, c.types[i].End = start, end
+		}
+		metadata["_jupyter_types_experimental"] = c.types
+	}
+	return receipt.Reply("complete_reply", map[string]interface{}{
+		"status":       "ok",
+		"matches":      c.matches,
+		"cursor_start": start,
+		"cursor_end":   end,
+		"metadata":     metadata,
+	})
+}
+
+// byteOffset returns the byte offset in s of the code point at offset n.
+func byteOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
+
+// complete returns the completions of code at the byte offset cursor.
+func (kernel *Kernel) complete(code string, cursor int) completions {
+	quote, start := literalAt(code, cursor)
+	switch quote {
+	case '"', '`':
+		prefix := code[start:cursor]
+		if inImport(code[:start-1]) {
+			return completions{start: start, end: cursor, matches: kernel.completeImportPath(prefix)}
+		}
+		return completions{start: start, end: cursor, matches: completePath(prefix)}
+	case 0:
+		c := completions{start: identStart(code, cursor), end: cursor}
+		if c.start < cursor && (c.start == 0 || code[c.start-1] != '.') {
+			kernel.completeNames(&c, code)
+			kernel.completeSnippets(&c, code)
+		}
+		return c
+	}
+	return completions{}
+}
+
+// The keywords of Go+, which are those of Go, by the position they may appear at.
+var (
+	statementKeywords = []string{
+		"break", "case", "const", "continue", "default", "defer", "else", "fallthrough", "for",
+		"func", "go", "goto", "if", "import", "return", "select", "switch", "type", "var",
+	}
+	expressionKeywords = []string{"chan", "func", "interface", "map", "struct"}
+)
+
+// The predeclared names of Go+, and those the kernel adds.
+var (
+	builtinConstants = []string{"false", "iota", "nil", "true"}
+	builtinTypes     = []string{
+		"bool", "byte", "complex128", "complex64", "error", "float32", "float64", "int", "int16",
+		"int32", "int64", "int8", "rune", "string", "uint", "uint16", "uint32", "uint64", "uint8", "uintptr",
+	}
+	builtinFuncs = []string{
+		"append", "cap", "close", "complex", "copy", "delete", "errorf", "fprintln", "imag", "len",
+		"make", "new", "panic", "print", "printf", "println", "real", "recover",
+		// Added by the kernel.
+		"Audio", "Dot", "Download", "Dump", "Fetch", "IFrame", "Math", "Mermaid", "Mesh", "PointCloud",
+		"ReadCSVPreview", "ReadParquetPreview", "ScriptHTML", "Video", "bigint", "bigrat", "echo", "newRange",
+	}
+)
+
+// builtinCommands are the builtin functions usually called in the command form of Go+,
+// like `println "hello"`, which is completed with a space rather than parentheses.
+var builtinCommands = map[string]bool{"echo": true, "print": true, "printf": true, "println": true, "Dump": true}
+
+// completeNames adds to c the names completing the identifier of code from c.start to
+// c.end: the keywords that may appear there, the predeclared names, and the variables,
+// functions and packages of the session. At the start of a statement of a Go+ cell,
+// builtin commands complete with a space, for the command form.
+func (kernel *Kernel) completeNames(c *completions, code string) {
+	prefix := code[c.start:c.end]
+	before := strings.TrimRight(code[:c.start], " \t")
+	statement := before == "" || strings.HasSuffix(before, "\n") || strings.HasSuffix(before, ";") ||
+		strings.HasSuffix(before, "{") || strings.HasSuffix(before, "}")
+	gop := cellLanguage(code, kernel.config.Language) != languageGo
+
+	seen := make(map[string]bool)
+	add := func(name, typ string) {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			c.add(name, completionType{Type: typ})
+		}
+	}
+	keywords := expressionKeywords
+	if statement {
+		keywords = statementKeywords
+	}
+	for _, k := range keywords {
+		add(k, "keyword")
+	}
+	for _, f := range builtinFuncs {
+		if statement && gop && builtinCommands[f] && strings.HasPrefix(f, prefix) && !seen[f] {
+			seen[f] = true
+			c.add(f+" ", completionType{Type: "command"})
+		}
+		add(f, "function")
+	}
+	for _, t := range builtinTypes {
+		add(t, "type")
+	}
+	for _, k := range builtinConstants {
+		add(k, "constant")
+	}
+
+	vars, imports := kernel.session.declaredNames()
+	var names []string
+	for v := range vars {
+		if !strings.HasPrefix(v, "_gopyter") {
+			names = append(names, v)
+		}
+	}
+	sort.Strings(names)
+	for _, v := range names {
+		add(v, "variable")
+	}
+	var packages []string
+	for p := range imports {
+		packages = append(packages, path.Base(p))
+	}
+	for _, p := range sessionPackages {
+		if !vars[path.Base(p)] {
+			packages = append(packages, path.Base(p))
+		}
+	}
+	sort.Strings(packages)
+	for _, p := range packages {
+		add(p, "module")
+	}
+}
+
+// cellLanguage returns the language code is evaluated as, by a kernel evaluating
+// cells as language unless they start with %%go or %%gop.
+func cellLanguage(code, language string) string {
+	switch firstLine := strings.TrimSpace(strings.SplitN(code, "\n", 2)[0]); {
+	case firstLine == "%%go":
+		return languageGo
+	case firstLine == "%%gop":
+		return languageGop
+	}
+	return language
+}
+
+// identStart returns the offset of the identifier of code ending at cursor, or cursor
+// if there is none.
+func identStart(code string, cursor int) int {
+	start := cursor
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(code[:start])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		start -= size
+	}
+	return start
+}
+
+// stringAt returns the offset of the content of the string literal of code that
+// cursor is in, if it is in one.
+func stringAt(code string, cursor int) (start int, ok bool) {
+	quote, start := literalAt(code, cursor)
+	return start, quote == '"' || quote == '`'
+}
+
+// literalAt returns the quote of the literal cursor is in, / or * if it is in a line
+// or general comment, or 0, with the offset of the content of the literal.
+func literalAt(code string, cursor int) (quote byte, start int) {
+	for i := 0; i < cursor; i++ {
+		c := code[i]
+		switch {
+		case quote == '/': // a line comment
+			if c == '\n' {
+				quote = 0
+			}
+		case quote == '*': // a general comment
+			if c == '*' && i+1 < len(code) && code[i+1] == '/' {
+				quote = 0
+				i++
+			}
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote || (c == '\n' && quote != '`') {
+				quote = 0
+			}
+		case c == '/' && i+1 < cursor && (code[i+1] == '/' || code[i+1] == '*'):
+			quote = code[i+1]
+			i++
+		case c == '"' || c == '`' || c == '\'':
+			quote, start = c, i+1
+		}
+	}
+	return quote, start
+}
+
+// importDecl matches code ending at the opening quote of an import path, in a single
+// import or in an import block.
+var importDecl = regexp.MustCompile(`(?:^|[\s;])import\s*(?:\([^()]*?)?(?:[\pL_.][\pL\pN_]*\s+)?$`)
+
+// inImport reports whether a string literal opened at the end of code is an import path.
+func inImport(code string) bool {
+	return importDecl.MatchString(code)
+}
+
+// completePath returns the paths of the files and directories completing prefix, those
+// of directories with a trailing slash. A prefix starting with ~/ is relative to the
+// home directory. In the sandbox, only the files within its roots are completed.
+func completePath(prefix string) []string {
+	dir, base := "", prefix
+	if i := strings.LastIndexAny(prefix, `/`+string(filepath.Separator)); i >= 0 {
+		dir, base = prefix[:i+1], prefix[i+1:]
+	}
+	listed := dir
+	if listed == "" {
+		listed = "."
+	} else if strings.HasPrefix(listed, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		listed = filepath.Join(home, listed[2:])
+	}
+
+	entries, err := ioutil.ReadDir(listed)
+	if err != nil {
+		return nil
+	}
+	var matches []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if sandboxed && !inSandbox(filepath.Join(listed, name)) {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		matches = append(matches, dir+name)
+		if len(matches) == maxCompletions {
+			break
+		}
+	}
+	return matches
+}
+
+// kernelPackages are the packages the kernel adds to the interpreter, some only when
+// they are enabled.
+var kernelPackages = []string{
+	"arrowipc", "expect", "gop/osx", "gop/stringx", "grpcx", "k8s", "magic", "mq", "openapi", "render", "secrets",
+}
+
+// completeImportPath returns the import paths completing prefix: those of the packages
+// the interpreter provides and of the packages required in the session, followed by the
+// paths in the module cache, which can be required with %require. Paths in the module
+// cache are completed an element at a time, those of directories with a trailing slash.
+func (kernel *Kernel) completeImportPath(prefix string) []string {
+	var importable []string
+	for _, path := range append(append([]string{}, stdPackages...), kernelPackages...) {
+		if exec.FindGoPackage(path) != nil {
+			importable = append(importable, path)
+		}
+	}
+	if r := kernel.session.requirements; r != nil {
+		for _, pin := range r.pins {
+			importable = append(importable, pin.Package)
+		}
+	}
+	sort.Strings(importable)
+
+	seen := make(map[string]bool)
+	var matches []string
+	add := func(path string) {
+		if strings.HasPrefix(path, prefix) && !seen[path] && len(matches) < maxCompletions {
+			seen[path] = true
+			matches = append(matches, path)
+		}
+	}
+	for _, path := range importable {
+		add(path)
+	}
+	for _, path := range moduleCachePaths(prefix) {
+		add(path)
+	}
+	return matches
+}
+
+// moduleCachePaths returns the paths in the module cache completing the last element
+// of prefix, an import path. The modules in the cache are in directories named after
+// their path and version, with upper case letters escaped as ! and the lower case
+// letter, like github.com/!burnt!sushi/toml@v0.3.1.
+func moduleCachePaths(prefix string) []string {
+	dir := moduleCacheDir()
+	if dir == "" {
+		return nil
+	}
+	elems := strings.Split(prefix, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		next, ok := cacheEntry(dir, elem)
+		if !ok {
+			return nil
+		}
+		dir = next
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	parent := strings.Join(elems[:len(elems)-1], "/")
+	if parent != "" {
+		parent += "/"
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() || (parent == "" && entry.Name() == "cache") {
+			continue
+		}
+		name := entry.Name()
+		module := false
+		if i := strings.IndexByte(name, '@'); i >= 0 {
+			name, module = name[:i], true
+		}
+		name = unescapeModulePath(name)
+		if !strings.HasPrefix(name, elems[len(elems)-1]) || strings.HasPrefix(name, ".") || name == "testdata" {
+			continue
+		}
+		if module || isPackageDir(filepath.Join(dir, entry.Name())) {
+			paths = append(paths, parent+name)
+		}
+		if !module {
+			paths = append(paths, parent+name+"/")
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// cacheEntry returns the directory of the module cache below dir for the import path
+// element elem: either a directory of the path, or the latest version of a module.
+func cacheEntry(dir, elem string) (string, bool) {
+	escaped := escapeModulePath(elem)
+	if info, err := os.Stat(filepath.Join(dir, escaped)); err == nil && info.IsDir() {
+		return filepath.Join(dir, escaped), true
+	}
+	versions, _ := filepath.Glob(filepath.Join(dir, escaped+"@*"))
+	if len(versions) == 0 {
+		return "", false
+	}
+	sort.Strings(versions)
+	return versions[len(versions)-1], true
+}
+
+// isPackageDir reports whether dir holds Go files.
+func isPackageDir(dir string) bool {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	return len(files) != 0
+}
+
+// escapeModulePath escapes the upper case letters of path like the module cache does.
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if 'A' <= r && r <= 'Z' {
+			b.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// unescapeModulePath undoes escapeModulePath.
+func unescapeModulePath(path string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range path {
+		if r == '!' {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	return b.String()
 }
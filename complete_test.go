@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestCompletionContext tests finding the string literal at the cursor and whether it
+// is an import path.
+func TestCompletionContext(t *testing.T) {
+	cases := []struct {
+		code     string // the cursor is at |
+		inString bool
+		inImport bool
+	}{
+		{`x := 1|`, false, false},
+		{`f, _ := os.Open("data/|`, true, false},
+		{`f, _ := os.Open("data/|")`, true, false},
+		{"s := `multi\nline/|", true, false},
+		{`s := "done" + x|`, false, false},
+		{`r := 'a' // "not a string|`, false, false},
+		{`/* "comment */ s := "|`, true, false},
+		{`s := "escaped \" quote|`, true, false},
+		{`import "str|`, true, true},
+		{`import f "fm|`, true, true},
+		{"import (\n\t\"fmt\"\n\t\"str|", true, true},
+		{"import (\n\t\"fmt\"\n)\nx := \"str|", true, false},
+	}
+
+	t.Logf("Should find the strings completed as paths and as import paths.")
+
+	for _, tc := range cases {
+		cursor := strings.Index(tc.code, "|")
+		code := strings.Replace(tc.code, "|", "", 1)
+		start, inString := stringAt(code, cursor)
+		if inString != tc.inString || (inString && inImport(code[:start-1]) != tc.inImport) {
+			t.Fatalf("\t%s Expected %q to be in a string: %v, an import: %v.", failure, tc.code, tc.inString, tc.inImport)
+		}
+		t.Logf("\t%s %q.", success, tc.code)
+	}
+}
+
+// TestCompletePaths tests completing file paths and import paths.
+func TestCompletePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-complete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, path := range []string{
+		"data/sales.csv", "data/stock.csv", "data/.hidden", "data/sub/x.txt",
+		"mod/github.com/!burnt!sushi/toml@v0.3.1/decode.go",
+		"mod/github.com/!burnt!sushi/toml@v0.3.1/internal/tz.go",
+		"mod/github.com/gofrs/uuid@v4.0.0+incompatible/uuid.go",
+		"mod/cache/download/x",
+	} {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	moduleCacheOnce.Do(func() {})
+	defer func(cache string) { moduleCache = cache }(moduleCache)
+	moduleCache = filepath.Join(dir, "mod")
+
+	data := filepath.ToSlash(filepath.Join(dir, "data")) + "/"
+	kernel := Kernel{NewSession(), defaultConfig()}
+	cases := []struct {
+		code string
+		want []string
+	}{
+		{`os.Open("` + data + `s`, []string{data + "sales.csv", data + "stock.csv", data + "sub/"}},
+		{`os.Open("` + data + `st`, []string{data + "stock.csv"}},
+		{`os.Open("` + data + `.`, []string{data + ".hidden"}},
+		{`os.Open("` + data + `nothing`, nil},
+		{`import "strc`, []string{"strconv"}},
+		{`import "github.com/`, []string{"github.com/BurntSushi/", "github.com/gofrs/"}},
+		{`import "github.com/Burnt`, []string{"github.com/BurntSushi/"}},
+		{`import "github.com/gofrs/`, []string{"github.com/gofrs/uuid"}},
+		{`import "github.com/BurntSushi/toml/`, []string{"github.com/BurntSushi/toml/internal", "github.com/BurntSushi/toml/internal/"}},
+		{`import "cach`, nil},
+	}
+
+	t.Logf("Should complete the paths of files and the import paths of packages.")
+
+	for _, tc := range cases {
+		c := kernel.complete(tc.code, len(tc.code))
+		if !reflect.DeepEqual(c.matches, tc.want) {
+			t.Fatalf("\t%s Expected %q to complete to %q, got %q.", failure, tc.code, tc.want, c.matches)
+		}
+		if c.end != len(tc.code) || !strings.HasSuffix(tc.code[:c.start], `"`) {
+			t.Fatalf("\t%s Expected the completions of %q to replace the string, got %d to %d.", failure, tc.code, c.start, c.end)
+		}
+		t.Logf("\t%s %q.", success, tc.code)
+	}
+}
+
+// TestCompleteSnippets tests completing keywords and struct types with snippets.
+func TestCompleteSnippets(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	if _, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, "type Point struct {\n\tX, Y int\n\tName string\n\tTags []string\n}"); err != nil {
+		t.Fatalf("\t%s Could not declare the type: %v.", failure, err)
+	}
+	cases := []struct {
+		code    string
+		want    []string
+		snippet string // of the first match
+	}{
+		{"fo", []string{"for i, v := range xs {\n\t\n}"}, "for ${1:i}, ${2:v} := range ${3:xs} {\n\t$0\n}"},
+		{"x := 1\n\tif", []string{"if err != nil {\n\t\tpanic(err)\n\t}"}, "if err != nil {\n\t\t${1:panic(err)}\n\t}"},
+		{"f", []string{"func name() {\n\t\n}", "for i, v := range xs {\n\t\n}"}, "func ${1:name}(${2}) {\n\t$0\n}"},
+		{"p := Po", []string{`Point{X: 0, Y: 0, Name: "", Tags: nil}`}, `Point{X: ${1:0}, Y: ${2:0}, Name: ${3:""}, Tags: ${4:nil}}$0`},
+		{"// fo", nil, ""},
+		{"x := 1 ", nil, ""},
+	}
+
+	t.Logf("Should complete keywords and struct types with templates.")
+
+	for _, tc := range cases {
+		c := kernel.complete(tc.code, len(tc.code))
+		var matches, snippets []string
+		for i, typ := range c.types {
+			if typ.Type == "snippet" {
+				matches, snippets = append(matches, c.matches[i]), append(snippets, typ.Snippet)
+			}
+		}
+		if !reflect.DeepEqual(matches, tc.want) {
+			t.Fatalf("\t%s Expected %q to complete to the snippets %q, got %q.", failure, tc.code, tc.want, matches)
+		}
+		if len(tc.want) != 0 && snippets[0] != tc.snippet {
+			t.Fatalf("\t%s Expected the snippet %q, got %q.", failure, tc.snippet, snippets[0])
+		}
+		t.Logf("\t%s %q.", success, tc.code)
+	}
+}
+
+// TestCompleteNames tests completing keywords, builtins and the names of the session,
+// depending on their position.
+func TestCompleteNames(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	if _, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, "import \"strings\"\nprices := []int{1, 2}\nprice := prices[0]"); err != nil {
+		t.Fatalf("\t%s Could not declare the names: %v.", failure, err)
+	}
+	cases := []struct {
+		code string
+		want []string
+	}{
+		{"pri", []string{"print ", "printf ", "println ", "price", "prices"}},
+		{"x := pri", []string{"print", "printf", "println", "price", "prices"}},
+		{"%%go\npri", []string{"print", "printf", "println", "price", "prices"}},
+		{"x := 1; ret", []string{"return"}},
+		{"x := ret", nil},
+		{"m := ma", []string{"map", "make", "magic"}},
+		{"s := str", []string{"struct", "string", "strings", "stringx"}},
+		{"if t", []string{"true"}},
+		{"strings.Re", nil},
+	}
+
+	t.Logf("Should complete the names that may appear at the cursor.")
+
+	for _, tc := range cases {
+		c := kernel.complete(tc.code, len(tc.code))
+		var matches []string
+		for i, typ := range c.types {
+			if typ.Type != "snippet" {
+				matches = append(matches, c.matches[i])
+			}
+		}
+		if !reflect.DeepEqual(matches, tc.want) {
+			t.Fatalf("\t%s Expected %q to complete to %q, got %q.", failure, tc.code, tc.want, matches)
+		}
+		t.Logf("\t%s %q.", success, tc.code)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// KernelConfig holds the user-tunable settings of the kernel. It is read from the JSON
+// file passed with the -config flag; fields missing from the file keep their defaults.
+type KernelConfig struct {
+	// OutputMaxBytes is the number of bytes a single cell may write to stdout and stderr
+	// before the rest of its output is truncated. Zero disables the limit.
+	OutputMaxBytes int `json:"output_max_bytes"`
+
+	// OutputMaxLines is the number of lines a single cell may write to stdout and stderr
+	// before the rest of its output is truncated. Zero disables the limit.
+	OutputMaxLines int `json:"output_max_lines"`
+
+	// OutputSpillDir, if set, is the directory where the full output of a truncated cell
+	// is saved. The saved output is also offered to the front-end as a `page` payload.
+	OutputSpillDir string `json:"output_spill_dir"`
+
+	// IOPubRateLimit is the number of stream messages per second a cell may publish.
+	// Output written faster is merged into fewer messages. Zero disables the limit.
+	IOPubRateLimit int `json:"iopub_rate_limit"`
+
+	// ConsolePrompt and ConsoleContinuationPrompt replace the prompts printed in console
+	// mode before the first and each following line of a multiline snippet.
+	ConsolePrompt             string `json:"console_prompt"`
+	ConsoleContinuationPrompt string `json:"console_continuation_prompt"`
+
+	// CacheDir is the directory where %%cache saves cell results. It defaults to a
+	// gopyter directory in the user's cache directory.
+	CacheDir string `json:"cache_dir"`
+
+	// Secrets lists the providers secrets.Get reads from, in order. It defaults to
+	// reading environment variables.
+	Secrets []SecretProviderConfig `json:"secrets"`
+
+	// Redact lists regular expressions whose matches are replaced in all the output of
+	// the kernel, in addition to those added with %mask.
+	Redact []string `json:"redact"`
+
+	// Sandbox restricts what cells can do.
+	Sandbox SandboxConfig `json:"sandbox"`
+
+	// OTLPEndpoint, if set, is the OTLP/HTTP traces endpoint, e.g.
+	// http://localhost:4318/v1/traces, to which the kernel exports its spans. It
+	// defaults to the standard OTEL_EXPORTER_OTLP_* environment variables.
+	OTLPEndpoint string `json:"otlp_endpoint"`
+
+	// InitCell is the code replayed in the fresh interpreter that replaces a crashed
+	// one. A cell starting with %%init replaces it.
+	InitCell string `json:"init_cell"`
+
+	// Reactive enables reactive execution: executing a cell re-executes the cells that
+	// depend on the variables it changed. %reactive toggles it.
+	Reactive bool `json:"reactive"`
+
+	// AutoImport makes cells using a standard library package without importing it
+	// import it automatically. %autoimport toggles it.
+	AutoImport bool `json:"autoimport"`
+
+	// Echo evaluates the top-level statements of cells one after the other, showing the
+	// source of each before its output. %echo toggles it.
+	Echo bool `json:"echo"`
+
+	// Interactivity sets which top-level expressions of cells have their result shown:
+	// "last", the default, or "all". %interactivity sets it.
+	Interactivity string `json:"interactivity"`
+
+	// VerboseAssign shows the values of the variables assigned by the top-level
+	// statements of cells after them, as `name: value`. %verbose_assign toggles it.
+	VerboseAssign bool `json:"verbose_assign"`
+
+	// Lint enables checking cells for likely mistakes before executing them. The
+	// warnings do not prevent the execution. %lint toggles it.
+	Lint bool `json:"lint"`
+
+	// UnusedCells, if set, is the number of cells after which the variables, functions
+	// and imports that no cell executed since their declaration used are reported.
+	// %unused sets it.
+	UnusedCells int `json:"unused_cells"`
+
+	// Language is the language of cells, "gop", "go" or "auto". Go cells may not use the
+	// syntax Go+ adds to Go. With "auto", cells are Go unless they use that syntax. Cells
+	// starting with %%gop or %%go override it.
+	Language string `json:"language"`
+
+	// MemoryWarnMB, if set, is the resident memory of the kernel, in MiB, beyond which a
+	// warning banner is shown after each cell.
+	MemoryWarnMB int `json:"memory_warn_mb"`
+
+	// Python is the Python interpreter run by %%python cells. It defaults to python3.
+	Python string `json:"python"`
+
+	// Runbook enables runbook mode: cells marked `# !dangerous` only run once the user
+	// confirms them, and every cell is recorded in the audit log. %runbook toggles it.
+	Runbook bool `json:"runbook"`
+
+	// AuditLog is the file runbook mode appends its records to. It defaults to
+	// gopyter/audit.log in the user's configuration directory, such as ~/.config, outside
+	// of the working directory that front-ends can write to.
+	AuditLog string `json:"audit_log"`
+
+	// ImportCache is the directory where %require and `gopyter prewarm` keep the
+	// plugins built for packages. It defaults to a gopyter directory in the user's cache
+	// directory.
+	ImportCache string `json:"import_cache"`
+
+	// Offline prevents %require from downloading packages: they must be in the import
+	// cache, or in the vendor directory when GOFLAGS has -mod=vendor. It is also enabled
+	// by GOPROXY=off and by -mod=vendor.
+	Offline bool `json:"offline"`
+
+	// Stats enables the usage statistics, which are off by default. It is the path of
+	// the statistics file, or a sink registered with registerStatsSink, as name:target.
+	Stats string `json:"stats"`
+
+	// Auth makes the kernel daemon hand kernels out only to the attach processes
+	// presenting a valid token. It names an authenticator registered with
+	// registerAuthenticator, as name:target.
+	Auth string `json:"auth"`
+
+	// Isolation, if "user", makes the kernel daemon run each kernel as the user who
+	// attached it, rather than as the user running the daemon.
+	Isolation string `json:"isolation"`
+
+	// IsolationUsers maps the users the authenticator finds for the tokens to the local
+	// accounts their kernels run as with user isolation. The tokens of other users are
+	// refused.
+	IsolationUsers map[string]string `json:"isolation_users"`
+
+	// IsolationMinUID is the lowest uid the kernels run as with user isolation, so that
+	// they never run as root or as a system account. It defaults to 1000.
+	IsolationMinUID int `json:"isolation_min_uid"`
+
+	// UploadMaxBytes is the size of the largest file front-ends may upload into the
+	// working directory of the kernel. Zero disables the limit.
+	UploadMaxBytes int `json:"upload_max_bytes"`
+
+	// Preload lists the optional packages, such as "k8s", imported by every session so
+	// cells can use them without importing them.
+	Preload []string `json:"preload"`
+}
+
+// optionalPackages are the packages sessions do not import unless the config preloads
+// them.
+var optionalPackages = map[string]bool{"k8s": true}
+
+// defaultConfig returns the configuration used when no config file is given.
+func defaultConfig() KernelConfig {
+	return KernelConfig{
+		OutputMaxBytes:  1 << 20,
+		OutputMaxLines:  10000,
+		IOPubRateLimit:  100,
+		IsolationMinUID: 1000,
+		UploadMaxBytes:  1 << 30,
+	}
+}
+
+// loadConfig reads the kernel configuration from the JSON file at path. An empty path
+// yields the default configuration.
+func loadConfig(path string) (KernelConfig, error) {
+	config := defaultConfig()
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, xerrors.Errorf("could not read config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, xerrors.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	switch config.Language {
+	case "", languageGop, languageGo, languageAuto:
+	default:
+		return config, xerrors.Errorf("invalid language %q in config file %s: expected %q, %q or %q", config.Language, path, languageGop, languageGo, languageAuto)
+	}
+
+	switch config.Interactivity {
+	case "", interactivityLast, interactivityAll:
+	default:
+		return config, xerrors.Errorf("invalid interactivity %q in config file %s: expected %q or %q", config.Interactivity, path, interactivityLast, interactivityAll)
+	}
+
+	for _, name := range config.Preload {
+		if _, ok := optionalPackages[name]; !ok {
+			return config, xerrors.Errorf("invalid package %q to preload in config file %s: expected one of %s", name, path, strings.Join(sortedNames(optionalPackages), ", "))
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,428 @@
+//go:build conformance
+// +build conformance
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	"github.com/gofrs/uuid"
+	"github.com/wangfenjin/gopyter/wire"
+)
+
+// The conformance tests build the kernel, start it as Jupyter does, and check its
+// replies to the requests of the messaging protocol over ZMQ, like the tests of
+// jupyter_kernel_test. They are run by `make conformance`.
+
+// conformanceTimeout is how long the tests wait for a reply of the kernel.
+const conformanceTimeout = 10 * time.Second
+
+// conformanceKernel is a kernel process, and the sockets of a front-end connected to it.
+type conformanceKernel struct {
+	cmd       *exec.Cmd
+	exited    chan error
+	log       bytes.Buffer
+	key       []byte
+	session   string
+	shell     zmq4.Socket
+	control   zmq4.Socket
+	hb        zmq4.Socket
+	published chan ComposedMsg
+}
+
+// startConformanceKernel builds the kernel in dir and starts it.
+func startConformanceKernel(t *testing.T, dir string) *conformanceKernel {
+	bin := filepath.Join(dir, "gopyter")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("\t%s Could not build the kernel: %v\n%s", failure, err, out)
+	}
+	connInfo := testConnectionInfo(t, "conformance")
+	data, _ := json.Marshal(connInfo)
+	connFile := filepath.Join(dir, "connection.json")
+	if err := ioutil.WriteFile(connFile, data, 0600); err != nil {
+		t.Fatalf("\t%s Could not write the connection file: %v.", failure, err)
+	}
+
+	k := &conformanceKernel{
+		exited:    make(chan error, 1),
+		key:       []byte(connInfo.Key),
+		session:   "conformance",
+		published: make(chan ComposedMsg, 100),
+	}
+	k.cmd = exec.Command(bin, connFile)
+	k.cmd.Stdout, k.cmd.Stderr = &k.log, &k.log
+	if err := k.cmd.Start(); err != nil {
+		t.Fatalf("\t%s Could not start the kernel: %v.", failure, err)
+	}
+	go func() { k.exited <- k.cmd.Wait() }()
+
+	ctx := context.Background()
+	address := func(port int) string { return fmt.Sprintf("tcp://127.0.0.1:%d", port) }
+	k.shell = zmq4.NewDealer(ctx, zmq4.WithID(zmq4.SocketIdentity("shell")))
+	k.control = zmq4.NewDealer(ctx, zmq4.WithID(zmq4.SocketIdentity("control")))
+	k.hb = zmq4.NewReq(ctx)
+	iopub := zmq4.NewSub(ctx)
+	sockets := []struct {
+		socket zmq4.Socket
+		port   int
+	}{
+		{k.shell, connInfo.ShellPort},
+		{k.control, connInfo.ControlPort},
+		{k.hb, connInfo.HBPort},
+		{iopub, connInfo.IOPubPort},
+	}
+	for _, s := range sockets {
+		if err := k.dial(s.socket, address(s.port)); err != nil {
+			k.stop()
+			t.Fatalf("\t%s Could not connect to the kernel: %v.\n%s", failure, err, k.log.String())
+		}
+	}
+	iopub.SetOption(zmq4.OptionSubscribe, "")
+	go func() {
+		for {
+			msg, err := iopub.Recv()
+			if err != nil {
+				close(k.published)
+				return
+			}
+			if published, _, err := wire.Decode(msg.Frames, k.key); err == nil {
+				k.published <- published
+			}
+		}
+	}()
+
+	// Subscriptions take effect after a while, so the kernel is ready once the status
+	// published for a request is received.
+	deadline := time.Now().Add(conformanceTimeout)
+	for {
+		if _, _, err := k.request(k.shell, "kernel_info_request", map[string]interface{}{}); err == nil {
+			return k
+		}
+		if time.Now().After(deadline) {
+			k.stop()
+			t.Fatalf("\t%s The kernel did not get ready.\n%s", failure, k.log.String())
+		}
+	}
+}
+
+// dial connects socket to the kernel, which may not listen yet.
+func (k *conformanceKernel) dial(socket zmq4.Socket, endpoint string) error {
+	deadline := time.Now().Add(conformanceTimeout)
+	for {
+		err := socket.Dial(endpoint)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// stop kills the kernel if it is still running.
+func (k *conformanceKernel) stop() {
+	k.cmd.Process.Kill()
+	for _, s := range []zmq4.Socket{k.shell, k.control, k.hb} {
+		if s != nil {
+			s.Close()
+		}
+	}
+}
+
+// send sends a request on socket, and returns the reply.
+func (k *conformanceKernel) send(socket zmq4.Socket, msgType string, content map[string]interface{}) (ComposedMsg, error) {
+	id, _ := uuid.NewV4()
+	msg := ComposedMsg{
+		Header: MsgHeader{
+			MsgID:           id.String(),
+			Username:        "conformance",
+			Session:         k.session,
+			MsgType:         msgType,
+			ProtocolVersion: "5.3",
+			Timestamp:       time.Now().UTC().Format(time.RFC3339),
+		},
+		Content: content,
+	}
+	parts, err := wire.Encode(msg, k.key)
+	if err != nil {
+		return ComposedMsg{}, err
+	}
+	if err := socket.SendMulti(zmq4.NewMsgFrom(append([][]byte{[]byte(wire.Delimiter)}, parts...)...)); err != nil {
+		return ComposedMsg{}, err
+	}
+
+	replies := make(chan zmq4.Msg, 1)
+	go func() {
+		if reply, err := socket.Recv(); err == nil {
+			replies <- reply
+		}
+	}()
+	var reply ComposedMsg
+	select {
+	case frames := <-replies:
+		if reply, _, err = wire.Decode(frames.Frames, k.key); err != nil {
+			return reply, err
+		}
+	case <-time.After(conformanceTimeout):
+		return reply, fmt.Errorf("no reply to %s", msgType)
+	}
+	if reply.ParentHeader.MsgID != msg.Header.MsgID {
+		return reply, fmt.Errorf("the reply %s is not to the %s", reply.Header.MsgType, msgType)
+	}
+	return reply, nil
+}
+
+// request sends a request on socket, and returns the reply and the messages published
+// for the request until the kernel was idle again.
+func (k *conformanceKernel) request(socket zmq4.Socket, msgType string, content map[string]interface{}) (ComposedMsg, []ComposedMsg, error) {
+	reply, err := k.send(socket, msgType, content)
+	if err != nil {
+		return reply, nil, err
+	}
+	var published []ComposedMsg
+	timeout := time.After(conformanceTimeout)
+	for {
+		select {
+		case m, ok := <-k.published:
+			if !ok {
+				return reply, published, fmt.Errorf("the iopub channel was closed")
+			}
+			if m.ParentHeader.MsgID != reply.ParentHeader.MsgID {
+				continue
+			}
+			published = append(published, m)
+			if m.Header.MsgType == "status" && contentOf(m)["execution_state"] == kernelIdle {
+				return reply, published, nil
+			}
+		case <-timeout:
+			return reply, published, fmt.Errorf("the kernel was not idle after %s", msgType)
+		}
+	}
+}
+
+// execute executes code, and checks the status of the execute_reply.
+func (k *conformanceKernel) execute(code, status string) (map[string]interface{}, []ComposedMsg, error) {
+	reply, published, err := k.request(k.shell, "execute_request", map[string]interface{}{
+		"code":             code,
+		"silent":           false,
+		"store_history":    true,
+		"user_expressions": map[string]interface{}{},
+		"allow_stdin":      false,
+		"stop_on_error":    true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	content := contentOf(reply)
+	if reply.Header.MsgType != "execute_reply" || content["status"] != status {
+		return content, published, fmt.Errorf("expected an execute_reply with the status %s, got %s %v", status, reply.Header.MsgType, content)
+	}
+	if _, ok := content["execution_count"].(float64); !ok {
+		return content, published, fmt.Errorf("expected an execution_count, got %v", content)
+	}
+	return content, published, nil
+}
+
+func contentOf(msg ComposedMsg) map[string]interface{} {
+	content, _ := msg.Content.(map[string]interface{})
+	return content
+}
+
+// publishedOf returns the messages of type msgType among published.
+func publishedOf(published []ComposedMsg, msgType string) []map[string]interface{} {
+	var contents []map[string]interface{}
+	for _, m := range published {
+		if m.Header.MsgType == msgType {
+			contents = append(contents, contentOf(m))
+		}
+	}
+	return contents
+}
+
+// TestConformance tests the replies of the kernel to the requests of the messaging
+// protocol.
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	k := startConformanceKernel(t, dir)
+	defer k.stop()
+
+	cases := []struct {
+		name  string
+		check func() error
+	}{
+		{"heartbeat", func() error {
+			if err := k.hb.Send(zmq4.NewMsgString("ping")); err != nil {
+				return err
+			}
+			msg, err := k.hb.Recv()
+			if err != nil || string(msg.Bytes()) != "ping" {
+				return fmt.Errorf("expected ping back, got %q (%v)", msg.Bytes(), err)
+			}
+			return nil
+		}},
+		{"kernel_info", func() error {
+			reply, published, err := k.request(k.shell, "kernel_info_request", map[string]interface{}{})
+			if err != nil {
+				return err
+			}
+			content := contentOf(reply)
+			info, _ := content["language_info"].(map[string]interface{})
+			version, _ := content["protocol_version"].(string)
+			if reply.Header.MsgType != "kernel_info_reply" || !strings.HasPrefix(version, "5.") ||
+				content["implementation"] != "gopyter" || info["name"] != "go+" || info["file_extension"] != ".gop" {
+				return fmt.Errorf("unexpected %s %v", reply.Header.MsgType, content)
+			}
+			statuses := publishedOf(published, "status")
+			if len(statuses) != 2 || statuses[0]["execution_state"] != kernelBusy {
+				return fmt.Errorf("expected the kernel to be busy then idle, got %v", statuses)
+			}
+			return nil
+		}},
+		{"execute stdout", func() error {
+			code := `println("hello, conformance")`
+			_, published, err := k.execute(code, "ok")
+			if err != nil {
+				return err
+			}
+			inputs := publishedOf(published, "execute_input")
+			if len(inputs) != 1 || inputs[0]["code"] != code {
+				return fmt.Errorf("expected the execute_input of the code, got %v", inputs)
+			}
+			var stdout string
+			for _, stream := range publishedOf(published, "stream") {
+				if stream["name"] == "stdout" {
+					stdout += stream["text"].(string)
+				}
+			}
+			if stdout != "hello, conformance\n" {
+				return fmt.Errorf("expected the output on stdout, got %q", stdout)
+			}
+			return nil
+		}},
+		{"execute result", func() error {
+			reply, published, err := k.execute("conformanceValue := 40\nconformanceValue + 2", "ok")
+			if err != nil {
+				return err
+			}
+			results := publishedOf(published, "execute_result")
+			if len(results) != 1 {
+				return fmt.Errorf("expected an execute_result, got %v", published)
+			}
+			data, _ := results[0]["data"].(map[string]interface{})
+			if data[MIMETypeText] != "42" || results[0]["execution_count"] != reply["execution_count"] {
+				return fmt.Errorf("expected 42 with the execution_count %v, got %v", reply["execution_count"], results[0])
+			}
+			next, _, err := k.execute("conformanceValue", "ok")
+			if err != nil {
+				return err
+			}
+			if next["execution_count"] != reply["execution_count"].(float64)+1 {
+				return fmt.Errorf("expected the execution_count to follow %v, got %v", reply["execution_count"], next["execution_count"])
+			}
+			return nil
+		}},
+		{"execute error", func() error {
+			reply, published, err := k.execute("undefinedConformanceName + 1", "error")
+			if err != nil {
+				return err
+			}
+			if _, ok := reply["traceback"].([]interface{}); !ok || reply["ename"] == nil || reply["evalue"] == nil {
+				return fmt.Errorf("expected an ename, an evalue and a traceback, got %v", reply)
+			}
+			if errors := publishedOf(published, "error"); len(errors) != 1 {
+				return fmt.Errorf("expected an error to be published, got %v", published)
+			}
+			return nil
+		}},
+		{"complete", func() error {
+			code := "conformanceVa"
+			reply, _, err := k.request(k.shell, "complete_request", map[string]interface{}{"code": code, "cursor_pos": len(code)})
+			if err != nil {
+				return err
+			}
+			content := contentOf(reply)
+			matches, _ := content["matches"].([]interface{})
+			found := false
+			for _, m := range matches {
+				found = found || m == "conformanceValue"
+			}
+			if reply.Header.MsgType != "complete_reply" || content["status"] != "ok" || !found ||
+				content["cursor_start"] != 0.0 || content["cursor_end"] != float64(len(code)) {
+				return fmt.Errorf("expected conformanceValue to complete %q, got %s %v", code, reply.Header.MsgType, content)
+			}
+			return nil
+		}},
+		{"interrupt", func() error {
+			// Interrupts between cells are ignored.
+			if err := k.cmd.Process.Signal(os.Interrupt); err != nil {
+				return err
+			}
+			time.Sleep(100 * time.Millisecond)
+
+			// Interrupting the kernel fails the running cell.
+			executed := make(chan error, 1)
+			go func() {
+				content, _, err := k.execute("for {\n}", "error")
+				if err == nil && content["evalue"] != errInterrupted.Error() {
+					err = fmt.Errorf("expected the cell to be interrupted, got %v", content)
+				}
+				executed <- err
+			}()
+			time.Sleep(100 * time.Millisecond)
+			reply, err := k.send(k.control, "interrupt_request", map[string]interface{}{})
+			if err != nil {
+				return err
+			}
+			if reply.Header.MsgType != "interrupt_reply" || contentOf(reply)["status"] != "ok" {
+				return fmt.Errorf("unexpected %s %v", reply.Header.MsgType, contentOf(reply))
+			}
+			if err := <-executed; err != nil {
+				return err
+			}
+			// The cells sent right after one failed are aborted.
+			time.Sleep(stopOnErrorTimeout)
+			if _, _, err := k.execute("conformanceValue", "ok"); err != nil {
+				return fmt.Errorf("the kernel did not survive the interrupts: %v", err)
+			}
+			return nil
+		}},
+		{"shutdown", func() error {
+			// The kernel exits without publishing that it is idle.
+			reply, err := k.send(k.control, "shutdown_request", map[string]interface{}{"restart": false})
+			if err != nil {
+				return err
+			}
+			if reply.Header.MsgType != "shutdown_reply" || contentOf(reply)["restart"] != false {
+				return fmt.Errorf("unexpected %s %v", reply.Header.MsgType, contentOf(reply))
+			}
+			select {
+			case err := <-k.exited:
+				return err
+			case <-time.After(conformanceTimeout):
+				return fmt.Errorf("the kernel did not exit")
+			}
+		}},
+	}
+
+	t.Logf("Should reply to the requests of the messaging protocol.")
+
+	for _, tc := range cases {
+		if err := tc.check(); err != nil {
+			t.Fatalf("\t%s %s: %v.\n%s", failure, tc.name, err, k.log.String())
+		}
+		t.Logf("\t%s %s.", success, tc.name)
+	}
+}
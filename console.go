@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// runConsole runs an interactive session on the terminal instead of a Jupyter kernel.
+// Cells are read with a Reader, so multiline statements can be typed naturally.
+func runConsole(config KernelConfig) {
+	kernel, err := newKernel(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reader := NewReader(os.Stdin, os.Stdout)
+	if config.ConsolePrompt != "" {
+		reader.Prompt = config.ConsolePrompt
+	}
+	if config.ConsoleContinuationPrompt != "" {
+		reader.ContinuationPrompt = config.ConsoleContinuationPrompt
+	}
+
+	outerr := OutErr{os.Stdout, os.Stderr}
+	for count := 1; ; {
+		code, err := reader.ReadMultiline()
+		if err != nil && err != io.EOF {
+			log.Fatal(err)
+		}
+
+		if code != "" {
+			if kernel.config.Lint {
+				for _, warning := range lintCell(code) {
+					fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+				}
+			}
+			vals, evalErr := kernel.evalRecovering(outerr, code)
+			kernel.session.history.record(count, code, vals)
+			if evalErr == nil {
+				kernel.session.deps.record(count, "", code)
+			}
+			count++
+			if evalErr != nil {
+				fmt.Fprintln(os.Stderr, evalErr)
+				suggestions, _ := kernel.session.errorSuggestions(evalErr)
+				for _, suggestion := range suggestions {
+					fmt.Fprintln(os.Stderr, suggestion)
+				}
+			} else if len(vals) != 0 {
+				fmt.Println(vals...)
+			}
+			// There is no next cell to fill in the console, so show its text instead.
+			for _, payload := range kernel.session.takePayloads() {
+				if text, ok := payload.(map[string]interface{})["text"].(string); ok {
+					fmt.Println(text)
+				}
+			}
+		}
+
+		if err == io.EOF {
+			fmt.Println()
+			return
+		}
+	}
+}
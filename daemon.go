@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The kernel daemon keeps a pool of kernel processes that have already started up and
+// compiled a first cell, and hands them out to new Jupyter connections. This cuts the
+// latency of the first cell in classrooms and hosted environments, where many kernels
+// are started at once. Kernels stay separate processes, so users do not share state.
+//
+// The daemon is started with `gopyter daemon` (or by running the binary as gopyterd)
+// and the kernelspec runs `gopyter attach {connection_file}` instead of the kernel. The
+// attach process stands in for the kernel towards Jupyter: it lives as long as the
+// kernel and forwards the signals it receives to it.
+
+// attachRequest is sent by `gopyter attach` to the daemon.
+type attachRequest struct {
+	ConnectionFile string `json:"connection_file"`
+	Token          string `json:"token,omitempty"`
+}
+
+// attachReply is the daemon's answer to an attachRequest.
+type attachReply struct {
+	PID   int    `json:"pid,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// standbyKernel is a kernel process of the pool waiting for a connection file.
+type standbyKernel struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// stop stops a kernel that was not handed out, which exits once its standard input is
+// closed.
+func (k *standbyKernel) stop() {
+	k.stdin.Close()
+	k.cmd.Wait()
+}
+
+// defaultDaemonSocket returns the path of the socket the daemon listens on by default.
+func defaultDaemonSocket() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gopyterd-%d.sock", os.Getuid()))
+}
+
+// isolatedDaemonSocket is the path of the socket a daemon isolating users listens on by
+// default, in a directory only root can write to.
+const isolatedDaemonSocket = "/run/gopyter/gopyterd.sock"
+
+// runDaemon implements `gopyter daemon`. kernelArgs are the flags passed on to the
+// kernel processes of the pool.
+func runDaemon(config KernelConfig, kernelArgs []string, args []string) error {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	size := flags.Int("pool", 4, "number of kernels kept ready")
+	socket := flags.String("socket", "", "path of the socket to listen on (default "+defaultDaemonSocket()+
+		", or "+isolatedDaemonSocket+" with user isolation)")
+	idle := flags.Duration("idle", 30*time.Minute, "time after which a kernel kept ready for a user of an isolated pool is stopped")
+	wait := flags.Duration("wait", time.Minute, "time an attach process waits for a kernel")
+	flags.Parse(args)
+
+	if *size < 1 {
+		return fmt.Errorf("the pool needs at least one kernel")
+	}
+	auth, err := openAuth(config)
+	if err != nil {
+		return err
+	}
+	switch config.Isolation {
+	case "":
+	case "user":
+		if os.Geteuid() != 0 {
+			return fmt.Errorf("the daemon must run as root to run the kernels as their users")
+		}
+	default:
+		return fmt.Errorf("unknown isolation %q, expected user", config.Isolation)
+	}
+	if *socket == "" {
+		*socket = defaultDaemonSocket()
+		if config.Isolation == "user" {
+			*socket = isolatedDaemonSocket
+		}
+	}
+	if config.Isolation == "user" {
+		// The users connect to the socket, so no one but root may replace it.
+		if err := secureDir(filepath.Dir(*socket)); err != nil {
+			return err
+		}
+	}
+
+	// Remove the socket left behind by a daemon that did not exit cleanly.
+	os.Remove(*socket)
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	// Remove the socket when the daemon is stopped.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		listener.Close()
+	}()
+
+	if config.Isolation == "user" {
+		// The users must be able to connect to the socket of the daemon running as root.
+		if err := os.Chmod(*socket, 0666); err != nil {
+			return err
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	pool := &kernelPool{
+		executable: executable,
+		kernelArgs: kernelArgs,
+		size:       *size,
+		isolated:   config.Isolation == "user",
+		isolation:  isolationPolicy{users: config.IsolationUsers, minUID: config.IsolationMinUID},
+		idle:       *idle,
+		wait:       *wait,
+		ready:      make(map[string]chan *standbyKernel),
+		waiting:    make(map[string]int),
+	}
+	if pool.isolated {
+		// The kernels of the users the configuration knows are started right away.
+		owners, err := pool.isolation.mappedOwners()
+		if err != nil {
+			return err
+		}
+		for _, owner := range owners {
+			pool.warm(owner)
+		}
+		log.Printf("Running the kernels as their users, listening on %s\n", *socket)
+	} else {
+		pool.warm(nil)
+		log.Printf("Keeping %d kernels ready, listening on %s\n", *size, *socket)
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			// The listener was closed.
+			return nil
+		}
+		go handleAttach(conn, pool, auth)
+	}
+}
+
+// kernelPool keeps kernel processes ready. Isolated pools keep a kernel ready for each
+// user who attached, running as that user, until it waits for them longer than idle.
+type kernelPool struct {
+	executable string
+	kernelArgs []string
+	size       int
+	isolated   bool
+	isolation  isolationPolicy // finds the users of the kernels of isolated pools
+	idle       time.Duration   // how long the kernel ready for a user waits for them
+	wait       time.Duration   // how long attach processes wait for a kernel
+
+	mu      sync.Mutex
+	ready   map[string]chan *standbyKernel // by user ID, "" if the pool is not isolated
+	waiting map[string]int                 // the attach processes waiting, by user ID
+}
+
+// warm starts keeping kernels ready for owner, which is nil unless the pool is
+// isolated.
+func (p *kernelPool) warm(owner *user.User) {
+	p.mu.Lock()
+	p.readyKernels(owner)
+	p.mu.Unlock()
+}
+
+// take waits for a kernel ready for owner, which is nil unless the pool is isolated. It
+// gives up after p.wait, e.g. if the kernels of owner cannot start.
+func (p *kernelPool) take(owner *user.User) (*standbyKernel, error) {
+	key := ownerKey(owner)
+	p.mu.Lock()
+	ready := p.readyKernels(owner)
+	p.waiting[key]++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		if p.waiting[key]--; p.waiting[key] == 0 {
+			delete(p.waiting, key)
+		}
+		p.mu.Unlock()
+	}()
+
+	select {
+	case kernel := <-ready:
+		return kernel, nil
+	case <-time.After(p.wait):
+		return nil, fmt.Errorf("no kernel was ready within %v", p.wait)
+	}
+}
+
+// ownerKey returns the key of the kernels of owner in the pool.
+func ownerKey(owner *user.User) string {
+	if owner == nil {
+		return ""
+	}
+	return owner.Uid
+}
+
+// readyKernels returns the channel of the kernels ready for owner, and starts filling
+// it the first time. p.mu must be held.
+func (p *kernelPool) readyKernels(owner *user.User) chan *standbyKernel {
+	key, size := ownerKey(owner), p.size
+	if owner != nil {
+		size = 1
+	}
+	ready := p.ready[key]
+	if ready == nil {
+		// The goroutine filling the channel holds one more kernel than it buffers.
+		ready = make(chan *standbyKernel, size-1)
+		p.ready[key] = ready
+		go p.fill(owner, ready)
+	}
+	return ready
+}
+
+// fill keeps a kernel ready for owner on ready. The kernels of a user are no longer
+// kept ready once one waited for them longer than p.idle, or failed to start, while no
+// attach process of theirs was waiting.
+func (p *kernelPool) fill(owner *user.User, ready chan *standbyKernel) {
+	key := ownerKey(owner)
+	for {
+		kernel, err := startStandbyKernel(p.executable, p.kernelArgs, owner)
+		if err != nil {
+			log.Printf("Error starting a kernel for the pool: %v\n", err)
+			time.Sleep(time.Second)
+			if owner != nil && p.expire(key) {
+				return
+			}
+			continue
+		}
+		if owner == nil {
+			ready <- kernel
+			continue
+		}
+		if !p.offer(key, ready, kernel) {
+			kernel.stop()
+			return
+		}
+	}
+}
+
+// offer waits for an attach process of the user with key to take kernel from ready. It
+// returns false if none did within p.idle, and the pool stopped keeping their kernels.
+func (p *kernelPool) offer(key string, ready chan *standbyKernel, kernel *standbyKernel) bool {
+	for {
+		select {
+		case ready <- kernel:
+			return true
+		case <-time.After(p.idle):
+			if p.expire(key) {
+				return false
+			}
+		}
+	}
+}
+
+// expire stops keeping the kernels of the user with key ready, unless an attach process
+// of theirs is waiting for one, and reports whether it did.
+func (p *kernelPool) expire(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.waiting[key] != 0 {
+		return false
+	}
+	delete(p.ready, key)
+	return true
+}
+
+// startStandbyKernel starts a kernel process, as owner if not nil, and waits until it
+// is ready.
+func startStandbyKernel(executable string, kernelArgs []string, owner *user.User) (*standbyKernel, error) {
+	cmd := exec.Command(executable, append(kernelArgs, "standby")...)
+	cmd.Stderr = os.Stderr
+	if owner != nil {
+		if err := runAs(cmd, owner); err != nil {
+			return nil, err
+		}
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "ready\n" {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("kernel did not start: %v", err)
+	}
+	go io.Copy(ioutil.Discard, stdout)
+
+	return &standbyKernel{cmd, stdin}, nil
+}
+
+// handleAttach hands a kernel of the pool to the attach process connected on conn,
+// once auth, if not nil, accepts its token. The connection is closed when the kernel
+// exits, and the kernel is killed if the attach process goes away first.
+func handleAttach(conn net.Conn, pool *kernelPool, auth authenticator) {
+	defer conn.Close()
+
+	var req attachRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(attachReply{Error: err.Error()})
+		return
+	}
+	var name string
+	if auth != nil {
+		var err error
+		if name, err = auth.authenticate(req.Token); err != nil {
+			log.Printf("Refusing a kernel for %s: %v\n", req.ConnectionFile, err)
+			json.NewEncoder(conn).Encode(attachReply{Error: "authentication failed"})
+			return
+		}
+		log.Printf("Handing a kernel to %s for %s\n", name, req.ConnectionFile)
+	}
+	var owner *user.User
+	if pool.isolated {
+		var err error
+		if owner, err = pool.isolation.kernelOwner(conn, name); err != nil {
+			log.Printf("Refusing a kernel for %s: %v\n", req.ConnectionFile, err)
+			json.NewEncoder(conn).Encode(attachReply{Error: err.Error()})
+			return
+		}
+	}
+
+	kernel, err := pool.take(owner)
+	if err != nil {
+		log.Printf("Refusing a kernel for %s: %v\n", req.ConnectionFile, err)
+		json.NewEncoder(conn).Encode(attachReply{Error: err.Error()})
+		return
+	}
+	if _, err := fmt.Fprintln(kernel.stdin, req.ConnectionFile); err != nil {
+		kernel.cmd.Process.Kill()
+		kernel.cmd.Wait()
+		json.NewEncoder(conn).Encode(attachReply{Error: err.Error()})
+		return
+	}
+	kernel.stdin.Close()
+
+	if err := json.NewEncoder(conn).Encode(attachReply{PID: kernel.cmd.Process.Pid}); err != nil {
+		kernel.cmd.Process.Kill()
+	}
+
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		kernel.cmd.Process.Kill()
+	}()
+	kernel.cmd.Wait()
+}
+
+// runStandby implements `gopyter standby`, the kernel processes of the daemon's pool.
+// It prepares a kernel, reports that it is ready on the standard output and runs the
+// kernel once the daemon sends it a connection file on the standard input.
+func runStandby(config KernelConfig) error {
+	kernel, err := newKernel(config)
+	if err != nil {
+		return err
+	}
+
+	// Compile and run an empty cell, so the first cell of the user starts from a
+	// session that is already set up.
+	if _, err := kernel.session.Eval(""); err != nil {
+		return err
+	}
+
+	fmt.Println("ready")
+
+	connectionFile, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err == io.EOF {
+		// The daemon stopped before handing out this kernel.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	kernel.serve(strings.TrimSpace(connectionFile))
+	return nil
+}
+
+// runAttach implements `gopyter attach`, which runs a kernel from the daemon's pool on
+// the given connection file. If no daemon is running, it runs the kernel itself.
+func runAttach(config KernelConfig, args []string) error {
+	flags := flag.NewFlagSet("attach", flag.ExitOnError)
+	socket := flags.String("socket", defaultDaemonSocket(), "path of the daemon's socket")
+	tokenEnv := flags.String("token-env", "GOPYTER_TOKEN", "environment variable holding the token presented to the daemon")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		return fmt.Errorf("need a command line argument specifying the connection file")
+	}
+	connectionFile, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		log.Printf("No kernel daemon at %s, starting the kernel: %v\n", *socket, err)
+		runKernel(connectionFile, config)
+		return nil
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(attachRequest{connectionFile, os.Getenv(*tokenEnv)}); err != nil {
+		return err
+	}
+	var reply attachReply
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return err
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("kernel daemon: %s", reply.Error)
+	}
+
+	kernel, err := os.FindProcess(reply.PID)
+	if err != nil {
+		return err
+	}
+
+	// Jupyter interrupts and stops the kernel through signals sent to this process, and
+	// supervisors make it reload its connection file.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			if err := kernel.Signal(sig); err != nil {
+				log.Printf("Error forwarding %v to the kernel: %v\n", sig, err)
+			}
+		}
+	}()
+
+	// The daemon closes the connection when the kernel exits.
+	io.Copy(ioutil.Discard, conn)
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os/user"
+	"testing"
+	"time"
+)
+
+// TestKernelPool tests waiting for the kernels of isolated pools and stopping those
+// waiting for their user too long.
+func TestKernelPool(t *testing.T) {
+	owner := &user.User{Uid: "1000", Username: "ada"}
+	pool := &kernelPool{
+		idle:    50 * time.Millisecond,
+		wait:    50 * time.Millisecond,
+		ready:   map[string]chan *standbyKernel{"1000": make(chan *standbyKernel)},
+		waiting: map[string]int{},
+	}
+
+	t.Logf("Should give up waiting for a kernel that does not start.")
+
+	if kernel, err := pool.take(owner); err == nil {
+		t.Fatalf("\t%s Expected no kernel, got %+v.", failure, kernel)
+	}
+	if len(pool.waiting) != 0 {
+		t.Fatalf("\t%s Expected no attach process to wait, got %v.", failure, pool.waiting)
+	}
+	t.Logf("\t%s Gave up.", success)
+
+	t.Logf("Should hand the kernel of a user to the attach process waiting for it.")
+
+	kernel, err := startStandbyKernel("sh", []string{"-c", "echo ready; cat >/dev/null"}, nil)
+	if err != nil {
+		t.Fatalf("\t%s Could not start a kernel: %v.", failure, err)
+	}
+	defer kernel.stop()
+	ready := pool.ready["1000"]
+	pool.waiting["1000"] = 1
+	taken := make(chan *standbyKernel, 1)
+	go func() {
+		time.Sleep(3 * pool.idle)
+		taken <- <-ready
+	}()
+	if !pool.offer("1000", ready, kernel) || <-taken != kernel {
+		t.Fatalf("\t%s Expected the kernel to be taken.", failure)
+	}
+	t.Logf("\t%s Handed it.", success)
+
+	t.Logf("Should stop keeping kernels ready for a user who does not attach.")
+
+	delete(pool.waiting, "1000")
+	if pool.offer("1000", ready, kernel) {
+		t.Fatalf("\t%s Expected the kernel not to be taken.", failure)
+	}
+	if _, ok := pool.ready["1000"]; ok {
+		t.Fatalf("\t%s Expected the kernels of the user to be dropped.", failure)
+	}
+	t.Logf("\t%s Stopped keeping them.", success)
+}
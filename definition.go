@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/token"
+)
+
+// definitionReply answers a definition request sent on a structure comm, which asks
+// where a name is defined, for front-end extensions implementing "go to definition".
+// The name is given by "name", like "x" or "strings.Split", or is the one at
+// "cursor_pos" in "code". The reply holds the location in "definition", or null if
+// the name is unknown:
+//
+//   - for a name declared by a cell of the session: the "execution_count" and, if the
+//     front-end sent it, the "cell_id" of the last executed cell declaring it, with the
+//     "line" and "column" of the declaration in the cell and its "offset", in code points;
+//   - for a package or a name it declares: the "package", the "file" and the "line" of
+//     the declaration, or the "dir" of the package.
+func (kernel *Kernel) definitionReply(data map[string]interface{}) (map[string]interface{}, error) {
+	var names []string
+	if name, ok := data["name"].(string); ok {
+		names = strings.Split(name, ".")
+	} else {
+		code, _ := data["code"].(string)
+		cursorPos, _ := data["cursor_pos"].(float64)
+		cursor := byteOffset(code, int(cursorPos))
+		if quote, _ := literalAt(code, cursor); quote == 0 {
+			names = selectorAt(code, cursor)
+		}
+	}
+	var definition interface{}
+	if len(names) != 0 && names[0] != "" {
+		if d, ok := kernel.definition(names); ok {
+			definition = d
+		}
+	}
+	return map[string]interface{}{"definition": definition}, nil
+}
+
+// definition returns where the selector names are defined, in the cells of the session
+// or in a package.
+func (kernel *Kernel) definition(names []string) (map[string]interface{}, bool) {
+	g := kernel.session.deps
+	for i := len(g.cells) - 1; i >= 0; i-- {
+		cell := g.cells[i]
+		if line, column, offset, ok := declarationIn(cell.code, names[0]); ok {
+			return map[string]interface{}{
+				"name":            names[0],
+				"execution_count": cell.Count,
+				"cell_id":         cell.CellID,
+				"line":            line,
+				"column":          column,
+				"offset":          offset,
+			}, true
+		}
+	}
+
+	pkg, symbol := kernel.packageSymbol(names)
+	docs, src, ok := kernel.packageDocs(pkg)
+	if !ok {
+		return nil, false
+	}
+	if symbol == "" {
+		return map[string]interface{}{"name": pkg, "package": pkg, "dir": src.Dir}, true
+	}
+	s, ok := docs.Symbols[symbol]
+	if !ok {
+		return nil, false
+	}
+	return map[string]interface{}{
+		"name":    symbol,
+		"package": pkg,
+		"file":    filepath.Join(src.Dir, s.File),
+		"line":    s.Line,
+	}, true
+}
+
+// parsedCell is the syntax tree of the code of a cell, with the positions of its nodes
+// in the code. The body of a cell magic is parsed, with its special commands blanked,
+// as a script, around which the parser inserts a package clause and a main function.
+type parsedCell struct {
+	fset      *token.FileSet
+	file      *ast.File
+	code      string
+	stripped  string // the body parsed
+	bodyStart int
+	// The main function is inserted before the first statement, shifting the offsets
+	// after it.
+	insertedAt, inserted int
+}
+
+// cellPackageClause is the package clause given to the parser, so it does not insert
+// its own.
+const cellPackageClause = "package main\n"
+
+// parseCell parses the code of a cell.
+func parseCell(code string) (*parsedCell, error) {
+	c := &parsedCell{fset: token.NewFileSet(), code: code}
+	body := code
+	if _, _, cellBody, isMagic := splitCellMagic(code); isMagic {
+		body, c.bodyStart = cellBody, len(code)-len(cellBody)
+	}
+	// The lines of the stripped body are those of the body, but for the blanked ones.
+	c.stripped = stripSpecialCommands(body)
+	pkgs, err := parser.Parse(c.fset, "", cellPackageClause+c.stripped, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			c.file = f
+		}
+	}
+	if c.file == nil {
+		return nil, errors.New("no source")
+	}
+	if fn := c.main(); fn != nil && c.file.NoEntrypoint {
+		// " func main(){"
+		c.insertedAt = c.fset.Position(fn.Pos()).Offset - 1
+		c.inserted = c.fset.Position(fn.Body.Lbrace).Offset + 1 - c.insertedAt
+	}
+	return c, nil
+}
+
+// main returns the main function, which holds the statements of the cell, or nil.
+func (c *parsedCell) main() *ast.FuncDecl {
+	for _, decl := range c.file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == "main" && fn.Recv == nil && fn.Body != nil {
+			return fn
+		}
+	}
+	return nil
+}
+
+// offset returns the byte offset of pos in the code of the cell.
+func (c *parsedCell) offset(pos token.Pos) int {
+	at := c.fset.Position(pos).Offset
+	if c.inserted != 0 && at > c.insertedAt {
+		at -= c.inserted
+	}
+	at -= len(cellPackageClause)
+	lineIndex := strings.Count(c.stripped[:at], "\n")
+	columnIndex := at - (strings.LastIndexByte(c.stripped[:at], '\n') + 1)
+	lineStart := c.bodyStart
+	for i := 0; i < lineIndex; i++ {
+		lineStart += strings.IndexByte(c.code[lineStart:], '\n') + 1
+	}
+	return lineStart + columnIndex
+}
+
+// topLevelIdents calls f with the identifiers declared by the top-level declarations
+// and statements of a cell.
+func (c *parsedCell) topLevelIdents(f func(*ast.Ident)) {
+	declared := func(decl ast.Decl) {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				f(decl.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						f(name)
+					}
+				case *ast.TypeSpec:
+					f(spec.Name)
+				}
+			}
+		}
+	}
+	main := c.main()
+	for _, decl := range c.file.Decls {
+		if decl != ast.Decl(main) {
+			declared(decl)
+		}
+	}
+	if main == nil {
+		return
+	}
+	for _, stmt := range main.Body.List {
+		switch stmt := stmt.(type) {
+		case *ast.AssignStmt:
+			if stmt.Tok == token.DEFINE {
+				for _, lhs := range stmt.Lhs {
+					if ident, ok := lhs.(*ast.Ident); ok {
+						f(ident)
+					}
+				}
+			}
+		case *ast.DeclStmt:
+			declared(stmt.Decl)
+		}
+	}
+}
+
+// declarationIn finds the top-level declaration of name in the code of a cell, and
+// returns its line and column, from 1, and its offset, from 0, in code points.
+func declarationIn(code, name string) (line, column, offset int, ok bool) {
+	c, err := parseCell(code)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	var found *ast.Ident
+	c.topLevelIdents(func(ident *ast.Ident) {
+		if found == nil && ident.Name == name {
+			found = ident
+		}
+	})
+	if found == nil {
+		return 0, 0, 0, false
+	}
+	at := c.offset(found.Pos())
+	lineStart := strings.LastIndexByte(code[:at], '\n') + 1
+	line = strings.Count(code[:at], "\n") + 1
+	column = utf8.RuneCountInString(code[lineStart:at]) + 1
+	offset = utf8.RuneCountInString(code[:at])
+	return line, column, offset, true
+}
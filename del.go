@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+)
+
+func init() {
+	lineMagics["del"] = evalDelMagic
+	documentMagic(delSyntax)
+}
+
+var delSyntax = &magicSyntax{
+	name:  "%del",
+	usage: []string{"<var>..."},
+	doc: "Releases the values of variables, so that the garbage collector can reclaim large data, and reports " +
+		"the memory released. The variables stay declared, holding their zero value.",
+	args: []magicParam{{name: "var", help: "the variables to release", variadic: true}},
+}
+
+// The cells of a session are compiled together, so a variable cannot be removed once a
+// cell declared it: later cells may refer to it. Releasing a variable assigns it its zero
+// value instead, which drops the references to its data.
+
+// evalDelMagic implements `%del <var>...`, which releases the values of variables and
+// reports the memory the garbage collector reclaimed.
+func evalDelMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := delSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+
+	before := heapAfterGC()
+	for _, name := range parsed.args {
+		if err := kernel.session.release(name); err != nil {
+			return err
+		}
+	}
+	released := int64(before) - int64(heapAfterGC())
+	if released < 0 {
+		released = 0
+	}
+	fmt.Fprintf(outerr.out, "Released about %s.\n", formatBytes(released))
+	return nil
+}
+
+// release assigns its zero value to the variable called name.
+func (s *Session) release(name string) error {
+	vals, err := s.Peek(name)
+	if err != nil || len(vals) != 1 {
+		return fmt.Errorf("%%del: no variable %s", name)
+	}
+	v := reflect.ValueOf(vals[0])
+	zero, ok := zeroLiteral(v)
+	if !ok {
+		return fmt.Errorf("%%del: cannot release %s of type %s", name, v.Type())
+	}
+	if _, err := s.Eval(name + " = " + zero); err != nil {
+		return fmt.Errorf("%%del: cannot release %s: %v", name, err)
+	}
+	return nil
+}
+
+// zeroLiteral returns Go+ code for the zero value of the type of v. Structs and arrays
+// are written as composite literals of their type, which only works for the types
+// without names, like those declared by cells.
+func zeroLiteral(v reflect.Value) (string, bool) {
+	if !v.IsValid() {
+		return "nil", true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer:
+		return "nil", true
+	case reflect.String:
+		return `""`, true
+	case reflect.Bool:
+		return "false", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return "0", true
+	case reflect.Struct, reflect.Array:
+		if v.Type().Name() == "" {
+			return v.Type().String() + "{}", true
+		}
+	}
+	return "", false
+}
+
+// heapAfterGC returns the bytes allocated on the heap after a garbage collection.
+func heapAfterGC() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestDelMagic tests releasing the values of variables.
+func TestDelMagic(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+
+	code := "type P struct {\n\tA []int\n\tB string\n}\n" +
+		"data := make([]int, 1000000)\np := P{[]int{1}, \"s\"}\nn := 3\ns := \"abc\"\narr := [2]int{1, 2}"
+	if _, err := kernel.doEvalGop(outerr, code); err != nil {
+		t.Fatalf("\t%s Could not declare the variables: %v.", failure, err)
+	}
+
+	t.Logf("Should release the values of variables.")
+
+	if _, err := kernel.doEvalGop(outerr, "%del data p n s arr"); err != nil {
+		t.Fatalf("\t%s %%del failed: %v.", failure, err)
+	}
+	if text := out.String(); !strings.HasPrefix(text, "Released about ") || !strings.Contains(text, "MiB") {
+		t.Fatalf("\t%s Expected the released memory to be reported, got %q.", failure, text)
+	}
+	for expr, want := range map[string]string{"len(data)": "0", "p": "{[] }", "n": "0", "s": "", "arr": "[0 0]"} {
+		vals, err := kernel.session.Peek(expr)
+		if err != nil || len(vals) != 1 || fmt.Sprint(vals[0]) != want {
+			t.Fatalf("\t%s Expected %s to be %q, got %v (%v).", failure, expr, want, vals, err)
+		}
+	}
+	t.Logf("\t%s Released the values.", success)
+
+	t.Logf("Should refuse names that are not variables.")
+
+	if _, err := kernel.doEvalGop(outerr, "%del missing"); err == nil || !strings.Contains(err.Error(), "no variable missing") {
+		t.Fatalf("\t%s Expected an error for an undeclared name, got %v.", failure, err)
+	}
+	t.Logf("\t%s Refused an undeclared name.", success)
+}
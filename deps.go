@@ -0,0 +1,420 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	lineMagics["deps"] = evalDepsMagic
+	documentMagic(depsSyntax)
+	commTargets[depsCommTarget] = openDepsComm
+}
+
+// depsCommTarget is the comm target through which front-end extensions follow the
+// dependencies between cells, e.g. to re-execute stale cells reactively. When the comm
+// is opened and after every execution, the kernel sends the dependency graph on it.
+const depsCommTarget = "gopyter.deps"
+
+// cellDeps records the variables read and written by an executed cell.
+type cellDeps struct {
+	Count  int      `json:"execution_count"`
+	CellID string   `json:"cell_id,omitempty"`
+	Reads  []string `json:"reads"`
+	Writes []string `json:"writes"`
+
+	code string
+	// readVersions holds, for each variable read, the execution count of the cell that
+	// had last written it when this cell was executed.
+	readVersions map[string]int
+}
+
+// depGraph tracks which variables the executed cells read and write, to find the cells
+// that are stale because a variable they read was changed since they were executed.
+type depGraph struct {
+	cells    []*cellDeps    // in execution order
+	versions map[string]int // the execution count of the last write of each variable
+}
+
+func newDepGraph() *depGraph {
+	return &depGraph{versions: make(map[string]int)}
+}
+
+// record records the dependencies of code, executed as In[count]. cellID identifies the
+// notebook cell if the front-end sent it; a cell executed again replaces its previous
+// record.
+func (g *depGraph) record(count int, cellID string, code string) {
+	reads, writes, ok := cellVariables(code)
+	if !ok {
+		return
+	}
+
+	d := &cellDeps{Count: count, CellID: cellID, Reads: reads, Writes: writes, code: code, readVersions: make(map[string]int)}
+	for _, v := range reads {
+		d.readVersions[v] = g.versions[v]
+	}
+	for _, v := range writes {
+		g.versions[v] = count
+	}
+
+	if cellID != "" {
+		for _, old := range g.cells {
+			if old.CellID == cellID {
+				g.remove(old)
+				break
+			}
+		}
+	}
+	g.cells = append(g.cells, d)
+}
+
+// remove forgets the record of a cell.
+func (g *depGraph) remove(d *cellDeps) {
+	for i, c := range g.cells {
+		if c == d {
+			g.cells = append(g.cells[:i], g.cells[i+1:]...)
+			return
+		}
+	}
+}
+
+// stale returns the stale cells, with the reasons they are stale. A cell is stale if a
+// variable it read was written by another cell since, or was last written by a stale
+// cell.
+func (g *depGraph) stale() map[*cellDeps][]string {
+	writers := make(map[string]*cellDeps)
+	for _, d := range g.cells {
+		for _, v := range d.Writes {
+			if g.versions[v] == d.Count {
+				writers[v] = d
+			}
+		}
+	}
+
+	stale := make(map[*cellDeps][]string)
+	for _, d := range g.cells {
+		for _, v := range d.Reads {
+			if version := g.versions[v]; version > d.readVersions[v] && version != d.Count {
+				stale[d] = append(stale[d], fmt.Sprintf("%s changed by In[%d]", v, version))
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, d := range g.cells {
+			if _, ok := stale[d]; ok {
+				continue
+			}
+			for _, v := range d.Reads {
+				w := writers[v]
+				if _, ok := stale[w]; ok && w != d && w.Count < d.Count {
+					stale[d] = append(stale[d], fmt.Sprintf("%s comes from stale In[%d]", v, w.Count))
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return stale
+}
+
+// staleCells returns the stale cells in execution order, which is also an order in which
+// they can be re-executed: a cell only depends on cells executed before it.
+func (g *depGraph) staleCells() []*cellDeps {
+	stale := g.stale()
+	var cells []*cellDeps
+	for _, d := range g.cells {
+		if _, ok := stale[d]; ok {
+			cells = append(cells, d)
+		}
+	}
+	return cells
+}
+
+// report returns the dependency graph as sent on the deps comm.
+func (g *depGraph) report() map[string]interface{} {
+	stale := g.stale()
+	cells := make([]interface{}, 0, len(g.cells))
+	for _, d := range g.cells {
+		reasons, isStale := stale[d]
+		cells = append(cells, map[string]interface{}{
+			"execution_count": d.Count,
+			"cell_id":         d.CellID,
+			"reads":           d.Reads,
+			"writes":          d.Writes,
+			"stale":           isStale,
+			"stale_reasons":   reasons,
+		})
+	}
+	return map[string]interface{}{"cells": cells}
+}
+
+// cellVariables returns the session variables read and written by the Go+ code of a
+// cell. Variables declared inside functions, blocks, loops and comprehensions are local
+// and ignored. ok is false if the code does not parse.
+func cellVariables(code string) (reads, writes []string, ok bool) {
+	var magicWrites []string
+	if name, args, body, isMagic := splitCellMagic(code); isMagic {
+		code = body
+		if name == "capture" {
+			magicWrites = append(magicWrites, strings.TrimSpace(args))
+		}
+	}
+	code = stripSpecialCommands(code)
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.Parse(fset, "", code, 0)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	v := varUses{reads: make(map[string]bool), writes: make(map[string]bool)}
+	for _, name := range magicWrites {
+		v.writes[name] = true
+	}
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					if decl.Name.Name == "main" && decl.Recv == nil && decl.Body != nil {
+						// The statements of the cell.
+						for _, stmt := range decl.Body.List {
+							v.addStmt(stmt)
+						}
+						continue
+					}
+					v.writes[decl.Name.Name] = true
+					v.add(decl, nil, nil)
+				case *ast.GenDecl:
+					v.addStmt(&ast.DeclStmt{Decl: decl})
+				}
+			}
+		}
+	}
+	return sortedNames(v.reads), sortedNames(v.writes), true
+}
+
+// varUses collects the session variables read and written by a cell.
+type varUses struct {
+	reads, writes map[string]bool
+}
+
+// addStmt adds the variables used by a statement at the top level of a cell, where
+// declarations declare session variables.
+func (v *varUses) addStmt(stmt ast.Stmt) {
+	defines := make(map[*ast.Ident]bool) // the identifiers declared by stmt
+	updates := make(map[*ast.Ident]bool) // the identifiers read and written by stmt
+	switch stmt := stmt.(type) {
+	case *ast.AssignStmt:
+		if stmt.Tok == token.DEFINE || stmt.Tok == token.ASSIGN {
+			markIdents(defines, stmt.Lhs...)
+		} else {
+			markIdents(updates, stmt.Lhs...)
+		}
+	case *ast.IncDecStmt:
+		markIdents(updates, stmt.X)
+	case *ast.DeclStmt:
+		if decl, ok := stmt.Decl.(*ast.GenDecl); ok {
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						defines[name] = true
+					}
+				case *ast.TypeSpec:
+					defines[spec.Name] = true
+				}
+			}
+		}
+	}
+	v.add(stmt, defines, updates)
+}
+
+// add adds the variables used in node. defines and updates are the identifiers node
+// writes, and reads and writes, at the top level of the cell; other declarations in
+// node are local.
+func (v *varUses) add(node ast.Node, defines, updates map[*ast.Ident]bool) {
+	locals := make(map[string]bool)
+	skipped := make(map[*ast.Ident]bool)
+	nestedWrites := make(map[*ast.Ident]bool)
+	nestedUpdates := make(map[*ast.Ident]bool)
+	local := func(idents ...*ast.Ident) {
+		for _, ident := range idents {
+			if ident != nil && !defines[ident] {
+				locals[ident.Name] = true
+			}
+		}
+	}
+
+	inspectAST(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, x := range n.Lhs {
+				ident, ok := x.(*ast.Ident)
+				if !ok {
+					continue
+				}
+				switch n.Tok {
+				case token.DEFINE:
+					local(ident)
+				case token.ASSIGN:
+					nestedWrites[ident] = true
+				default:
+					nestedUpdates[ident] = true
+				}
+			}
+		case *ast.IncDecStmt:
+			markIdents(nestedUpdates, n.X)
+		case *ast.RangeStmt:
+			if n.Tok == token.DEFINE {
+				key, _ := n.Key.(*ast.Ident)
+				value, _ := n.Value.(*ast.Ident)
+				local(key, value)
+			}
+		case *ast.ForPhrase:
+			local(n.Key, n.Value)
+		case *ast.ValueSpec:
+			local(n.Names...)
+		case *ast.TypeSpec:
+			local(n.Name)
+		case *ast.Field:
+			// Parameters, results and struct fields.
+			for _, name := range n.Names {
+				skipped[name] = true
+				local(name)
+			}
+		case *ast.FuncDecl:
+			skipped[n.Name] = true
+		case *ast.SelectorExpr:
+			skipped[n.Sel] = true
+		case *ast.ImportSpec:
+			if n.Name != nil {
+				skipped[n.Name] = true
+			}
+		}
+		return true
+	})
+
+	inspectAST(node, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok || ident.Name == "_" || skipped[ident] {
+			return true
+		}
+		switch {
+		case defines[ident]:
+			v.writes[ident.Name] = true
+		case updates[ident]:
+			v.reads[ident.Name] = true
+			v.writes[ident.Name] = true
+		case locals[ident.Name]:
+		case nestedWrites[ident]:
+			v.writes[ident.Name] = true
+		case nestedUpdates[ident]:
+			v.reads[ident.Name] = true
+			v.writes[ident.Name] = true
+		case types.Universe.Lookup(ident.Name) != nil:
+			// Predeclared types, constants and functions.
+		default:
+			v.reads[ident.Name] = true
+		}
+		return true
+	})
+}
+
+func markIdents(idents map[*ast.Ident]bool, exprs ...ast.Expr) {
+	for _, x := range exprs {
+		if ident, ok := x.(*ast.Ident); ok {
+			idents[ident] = true
+		}
+	}
+}
+
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stripSpecialCommands removes the shell commands and line magics at the top of code,
+// like evalSpecialCommands but without running them.
+func stripSpecialCommands(code string) string {
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line[0] != '$' && line[0] != '%' {
+			break
+		}
+		lines[i] = ""
+	}
+	return strings.Join(lines, "\n")
+}
+
+var depsSyntax = &magicSyntax{
+	name: "%deps",
+	doc:  "Lists the variables read and written by the executed cells, and the cells that are stale because a variable they read changed since.",
+}
+
+// evalDepsMagic implements `%deps`, which lists the variables read and written by the
+// executed cells and the cells that are stale.
+func evalDepsMagic(kernel *Kernel, outerr OutErr, args string) error {
+	if _, err := depsSyntax.parse(args); err != nil {
+		return err
+	}
+	g := kernel.session.deps
+	stale := g.stale()
+	for _, d := range g.cells {
+		fmt.Fprintf(outerr.out, "In[%d]: reads %s; writes %s\n", d.Count, nameList(d.Reads), nameList(d.Writes))
+	}
+
+	staleCells := g.staleCells()
+	if len(staleCells) == 0 {
+		fmt.Fprintln(outerr.out, "No stale cells.")
+		return nil
+	}
+	fmt.Fprintln(outerr.out, "Stale cells:")
+	for _, d := range staleCells {
+		fmt.Fprintf(outerr.out, "  In[%d]: %s\n", d.Count, strings.Join(stale[d], ", "))
+	}
+	return nil
+}
+
+func nameList(names []string) string {
+	if len(names) == 0 {
+		return "nothing"
+	}
+	return strings.Join(names, ", ")
+}
+
+// openDepsComm sends the dependency graph on a newly opened deps comm.
+func openDepsComm(kernel *Kernel, receipt msgReceipt, c *comm, data map[string]interface{}) error {
+	return c.send(receipt, kernel.session.deps.report())
+}
+
+// publishDeps sends the dependency graph on the open deps comms.
+func (kernel *Kernel) publishDeps(receipt msgReceipt) {
+	comms := commsOf(depsCommTarget)
+	if len(comms) == 0 {
+		return
+	}
+	report := kernel.session.deps.report()
+	for _, c := range comms {
+		if err := c.send(receipt, report); err != nil {
+			log.Printf("Error sending the dependencies of the cells: %v\n", err)
+		}
+	}
+}
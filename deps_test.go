@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestCellVariables tests finding the session variables read and written by a cell.
+func TestCellVariables(t *testing.T) {
+	cases := []struct {
+		code   string
+		reads  []string
+		writes []string
+	}{
+		{"x := 1", []string{}, []string{"x"}},
+		{"y := x * 2", []string{"x"}, []string{"y"}},
+		{"x += y", []string{"x", "y"}, []string{"x"}},
+		{"n++", []string{"n"}, []string{"n"}},
+		{"var a, b = x, y", []string{"x", "y"}, []string{"a", "b"}},
+		{"for i := 0; i < n; i++ {\n\ttotal += i\n}", []string{"n", "total"}, []string{"total"}},
+		{"for _, v := range xs {\n\tsum = sum + v\n}", []string{"sum", "xs"}, []string{"sum"}},
+		{"squares := [v * v for v <- xs]", []string{"xs"}, []string{"squares"}},
+		{"f := func(a int) int {\n\tb := a + k\n\treturn b\n}", []string{"k"}, []string{"f"}},
+		{"import \"strings\"\ns := strings.ToUpper(name)", []string{"name", "strings"}, []string{"s"}},
+		{"%checkpoint start\nz := 1", []string{}, []string{"z"}},
+		{"%%cache key=k\nz := w", []string{"w"}, []string{"z"}},
+		{"%%capture out\nprintln(w)", []string{"w"}, []string{"out"}},
+	}
+
+	t.Logf("Should find the variables read and written by a cell.")
+
+	for _, tc := range cases {
+		reads, writes, ok := cellVariables(tc.code)
+		if !ok {
+			t.Fatalf("\t%s Could not analyze %q.", failure, tc.code)
+		}
+		if !reflect.DeepEqual(reads, tc.reads) || !reflect.DeepEqual(writes, tc.writes) {
+			t.Errorf("\t%s %q: expected reads %v and writes %v, got %v and %v.", failure, tc.code, tc.reads, tc.writes, reads, writes)
+			continue
+		}
+		t.Logf("\t%s %q reads %v and writes %v.", success, tc.code, reads, writes)
+	}
+}
+
+// TestStaleCells tests that cells are stale once a variable they read changed.
+func TestStaleCells(t *testing.T) {
+	g := newDepGraph()
+	g.record(1, "a", "x := 1")
+	g.record(2, "b", "y := x * 2")
+	g.record(3, "c", "z := y + 1")
+	g.record(4, "d", "w := 5")
+
+	t.Logf("Should find no stale cells after executing the cells in order.")
+
+	if stale := g.stale(); len(stale) != 0 {
+		t.Fatalf("\t%s Expected no stale cells, got %d.", failure, len(stale))
+	}
+	t.Logf("\t%s No stale cells.", success)
+
+	t.Logf("Should find the cells depending on a changed variable, directly or not.")
+
+	g.record(5, "a", "x := 10")
+	stale := g.stale()
+	var staleCounts []int
+	for _, d := range g.cells {
+		if _, ok := stale[d]; ok {
+			staleCounts = append(staleCounts, d.Count)
+		}
+	}
+	if !reflect.DeepEqual(staleCounts, []int{2, 3}) {
+		t.Fatalf("\t%s Expected In[2] and In[3] to be stale, got %v.", failure, staleCounts)
+	}
+	t.Logf("\t%s Stale cells: %v.", success, staleCounts)
+
+	t.Logf("Should no longer find a cell stale once it is executed again.")
+
+	g.record(6, "b", "y := x * 2")
+	g.record(7, "c", "z := y + 1")
+	if stale := g.stale(); len(stale) != 0 {
+		t.Fatalf("\t%s Expected no stale cells, got %d.", failure, len(stale))
+	}
+	t.Logf("\t%s No stale cells.", success)
+}
@@ -22,6 +22,7 @@ const (
 	MIMETypeJSON       = "application/json"
 	MIMETypeLatex      = "text/latex"
 	MIMETypeMarkdown   = "text/markdown"
+	MIMETypeMermaid    = "text/vnd.mermaid"
 	MIMETypePNG        = "image/png"
 	MIMETypePDF        = "application/pdf"
 	MIMETypeSVG        = "image/svg+xml"
@@ -95,9 +96,66 @@ func stubDisplay(Data) error {
 
 // if vals[] contain a single non-nil value which is auto-renderable,
 // convert it to Data and return it.
-// otherwise return MakeData("text/plain", fmt.Sprint(vals...))
+// otherwise return the values printed as text by resultPrinter
 func (kernel *Kernel) autoRenderResults(vals []interface{}) Data {
-	return MakeData(MIMETypeText, fmt.Sprint(vals...))
+	if len(vals) == 0 {
+		return Data{}
+	}
+	var nilcount int
+	var obj interface{}
+	for _, val := range vals {
+		if val == nil {
+			nilcount++
+		} else if canAutoRender(val) {
+			obj = val
+		}
+	}
+	if obj != nil && nilcount == len(vals)-1 {
+		return autoRender(obj)
+	}
+	return MakeData(MIMETypeText, resultPrinter.sprint(vals...))
+}
+
+// canAutoRender reports whether data is handled by a registered renderer or
+// implements one of the interfaces handled by autoRenderers.
+func canAutoRender(data interface{}) bool {
+	return hasCustomRenderer(data) || canRenderBuiltin(data)
+}
+
+// canRenderBuiltin reports whether data implements one of the interfaces
+// handled by autoRenderers.
+func canRenderBuiltin(data interface{}) bool {
+	switch data.(type) {
+	case Renderer, SimpleRenderer, HTMLer, JavaScripter, JPEGer, JSONer,
+		Latexer, Markdowner, PNGer, PDFer, SVGer, image.Image, *http.Response, Matrix:
+		return true
+	}
+	return isTable(data)
+}
+
+// autoRender converts data to Data using the registered renderers that take
+// precedence, or else every matching autoRenderer, or else the other registered
+// renderers, and fills in the plain text representation if none was provided.
+func autoRender(data interface{}) Data {
+	if d, ok := renderCustom(data, true); ok {
+		return d
+	}
+	if !canRenderBuiltin(data) {
+		if d, ok := renderCustom(data, false); ok {
+			return d
+		}
+		return MakeData(MIMETypeText, resultPrinter.sprint(data))
+	}
+	return renderBuiltin(data)
+}
+
+// renderBuiltin converts data to Data using every matching autoRenderer.
+func renderBuiltin(data interface{}) Data {
+	var d Data
+	for _, fun := range autoRenderers {
+		d = fun(d, data)
+	}
+	return fillDefaults(d, data, "", nil, "", nil)
 }
 
 var autoRenderers = map[string]func(Data, interface{}) Data{
@@ -152,6 +210,13 @@ var autoRenderers = map[string]func(Data, interface{}) Data{
 		}
 		return d
 	},
+	"Matrix": func(d Data, i interface{}) Data {
+		if m, ok := i.(Matrix); ok {
+			d.Data = ensure(d.Data)
+			d.Data[MIMETypeLatex] = "$$" + matrixLatex(m) + "$$"
+		}
+		return d
+	},
 	"Markdowner": func(d Data, i interface{}) Data {
 		if r, ok := i.(Markdowner); ok {
 			d.Data = ensure(d.Data)
@@ -180,6 +245,19 @@ var autoRenderers = map[string]func(Data, interface{}) Data{
 		}
 		return d
 	},
+	"HTTPResponse": func(d Data, i interface{}) Data {
+		if r, ok := i.(*http.Response); ok {
+			x := renderHTTPResponse(r)
+			d.Data = merge(d.Data, x.Data)
+		}
+		return d
+	},
+	"Table": func(d Data, i interface{}) Data {
+		if isTable(i) {
+			d.Data = merge(d.Data, renderTable(i).Data)
+		}
+		return d
+	},
 	"Image": func(d Data, i interface{}) Data {
 		if r, ok := i.(image.Image); ok {
 			b, mimeType, err := encodePng(r)
@@ -345,5 +423,5 @@ func SVG(svg string) Data {
 // Some easier-to-use functions for common formats supported by the Jupyter frontend
 // are provided by the various functions above.
 func MIME(data, metadata MIMEMap) Data {
-	return Data{data, metadata, nil}
+	return Data{Data: data, Metadata: metadata}
 }
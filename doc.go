@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	lineMagics["doc"] = evalDocMagic
+	documentMagic(docSyntax)
+}
+
+var docSyntax = &magicSyntax{
+	name:  "%doc",
+	usage: []string{"name"},
+	doc: "Shows the documentation of a package imported in the session, or of a name it declares, like `strings.Split`. " +
+		"For types, like `strings.Builder`, it renders tables of their fields, with their tags, and of their constructors " +
+		"and methods, with their signatures and doc comments.",
+	args: []magicParam{
+		{name: "name", help: "the package, like `strings`, or the name, like `strings.Builder`"},
+	},
+}
+
+// packageDocs is the documentation of the exported names of a package, extracted from
+// its sources like `go doc` does.
+type packageDocs struct {
+	Format  int                  `json:"format"` // docFormat when it was extracted
+	Path    string               `json:"path"`
+	Name    string               `json:"name"`
+	Doc     string               `json:"doc"`
+	Symbols map[string]symbolDoc `json:"symbols"` // by name, and by Type.Method for methods
+}
+
+// symbolDoc is the documentation of a name declared by a package.
+type symbolDoc struct {
+	Decl    string     `json:"decl"`
+	Doc     string     `json:"doc,omitempty"`
+	Members []string   `json:"members,omitempty"` // of a type, the declarations of its constructors and methods
+	Fields  []fieldDoc `json:"fields,omitempty"`  // of a struct type, its exported fields
+	File    string     `json:"file"`              // the name of the file declaring it, in the directory of the package
+	Line    int        `json:"line"`
+}
+
+// fieldDoc is the documentation of an exported field of a struct type.
+type fieldDoc struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Tag      string `json:"tag,omitempty"`
+	Doc      string `json:"doc,omitempty"`
+	Embedded bool   `json:"embedded,omitempty"`
+}
+
+// docFormat is the version of the documentation extracted from packages. Documentation
+// saved in another format is extracted again.
+const docFormat = 1
+
+// extractPackageDocs extracts the documentation of the package with path importPath
+// from its sources in dir, for the platform of the kernel.
+func extractPackageDocs(dir, importPath string) (*packageDocs, error) {
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	files := make(map[string]*ast.File)
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = f
+	}
+	mode := doc.Mode(0)
+	if importPath == "builtin" {
+		// The predeclared names are not exported.
+		mode = doc.AllDecls
+	}
+	p := doc.New(&ast.Package{Name: bp.Name, Files: files}, importPath, mode)
+
+	docs := &packageDocs{Format: docFormat, Path: importPath, Name: p.Name, Doc: p.Doc, Symbols: make(map[string]symbolDoc)}
+	add := func(name string, pos token.Pos, s symbolDoc) {
+		position := fset.Position(pos)
+		s.File, s.Line = filepath.Base(position.Filename), position.Line
+		docs.Symbols[name] = s
+	}
+	addValues := func(values []*doc.Value) {
+		for _, v := range values {
+			decl := declText(fset, v.Decl)
+			for _, spec := range v.Decl.Specs {
+				for _, ident := range spec.(*ast.ValueSpec).Names {
+					if ident.Name != "_" {
+						add(ident.Name, ident.Pos(), symbolDoc{Decl: decl, Doc: v.Doc})
+					}
+				}
+			}
+		}
+	}
+	addFuncs := func(prefix string, funcs []*doc.Func) []string {
+		var decls []string
+		for _, f := range funcs {
+			decl := declText(fset, f.Decl)
+			add(prefix+f.Name, f.Decl.Name.Pos(), symbolDoc{Decl: decl, Doc: f.Doc})
+			decls = append(decls, decl)
+		}
+		return decls
+	}
+	addValues(p.Consts)
+	addValues(p.Vars)
+	addFuncs("", p.Funcs)
+	for _, t := range p.Types {
+		addValues(t.Consts)
+		addValues(t.Vars)
+		members := append(addFuncs("", t.Funcs), addFuncs(t.Name+".", t.Methods)...)
+		spec := t.Decl.Specs[0].(*ast.TypeSpec)
+		add(t.Name, spec.Name.Pos(), symbolDoc{Decl: declText(fset, t.Decl), Doc: t.Doc, Members: members, Fields: structFields(fset, spec)})
+	}
+	return docs, nil
+}
+
+// structFields returns the exported fields of the struct type of spec, from which
+// go/doc removed the unexported ones.
+func structFields(fset *token.FileSet, spec *ast.TypeSpec) []fieldDoc {
+	st, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		return nil
+	}
+	var fields []fieldDoc
+	for _, field := range st.Fields.List {
+		var b bytes.Buffer
+		if err := printer.Fprint(&b, fset, field.Type); err != nil {
+			continue
+		}
+		f := fieldDoc{Type: b.String(), Doc: field.Doc.Text()}
+		if f.Doc == "" {
+			f.Doc = field.Comment.Text()
+		}
+		if field.Tag != nil {
+			f.Tag, _ = strconv.Unquote(field.Tag.Value)
+		}
+		if len(field.Names) == 0 {
+			f.Name, f.Embedded = strings.TrimPrefix(f.Type, "*"), true
+			if i := strings.LastIndexByte(f.Name, '.'); i >= 0 {
+				f.Name = f.Name[i+1:]
+			}
+			if ast.IsExported(f.Name) {
+				fields = append(fields, f)
+			}
+		}
+		for _, name := range field.Names {
+			f.Name = name.Name
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// declText returns the source of decl, without its doc comment and the body of a
+// function.
+func declText(fset *token.FileSet, decl ast.Decl) string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		copied := *d
+		copied.Doc, copied.Body = nil, nil
+		decl = &copied
+	case *ast.GenDecl:
+		copied := *d
+		copied.Doc = nil
+		decl = &copied
+	}
+	var b bytes.Buffer
+	if err := (&printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}).Fprint(&b, fset, decl); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// text returns the documentation of symbol, like `go doc` shows it, or that of the
+// package if symbol is "". With detail, it adds the members of types, or the names the
+// package declares.
+func (d *packageDocs) text(symbol string, detail bool) (string, bool) {
+	var b strings.Builder
+	if symbol == "" {
+		b.WriteString("package " + d.Name + " // import " + strconv.Quote(d.Path) + "\n\n" + d.Doc)
+		if detail {
+			names := make([]string, 0, len(d.Symbols))
+			for name := range d.Symbols {
+				if !strings.Contains(name, ".") {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			b.WriteString("\n" + strings.Join(names, "\n") + "\n")
+		}
+		return b.String(), true
+	}
+	s, ok := d.Symbols[symbol]
+	if !ok {
+		return "", false
+	}
+	b.WriteString(s.Decl + "\n")
+	for _, line := range strings.SplitAfter(s.Doc, "\n") {
+		if strings.TrimSpace(line) != "" {
+			b.WriteString("    ")
+		}
+		b.WriteString(line)
+	}
+	if detail && len(s.Members) != 0 {
+		b.WriteString("\n" + strings.Join(s.Members, "\n") + "\n")
+	}
+	return b.String(), true
+}
+
+// docSource is where the documentation of a package is extracted from.
+type docSource struct {
+	Path    string
+	Dir     string
+	Version string
+	// Local is set for packages read from a local directory rather than from a module
+	// or the Go distribution, whose version is the time their files were last modified.
+	Local bool
+}
+
+// localDocSource returns the source of the package with path importPath in the local
+// directory dir. Its version changes whenever a file of the package is edited, added
+// or removed, so its documentation is extracted again when the package is reloaded.
+func localDocSource(importPath, dir string) (docSource, bool) {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return docSource{}, false
+	}
+	latest := info.ModTime()
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".go") && f.ModTime().After(latest) {
+			latest = f.ModTime()
+		}
+	}
+	return docSource{Path: importPath, Dir: dir, Version: "local-" + strconv.FormatInt(latest.UnixNano(), 36), Local: true}, true
+}
+
+// moduleDocSource returns the source of the package with path importPath, in the
+// directory rel of module at version in the module cache.
+func moduleDocSource(importPath, rel, module, version string) (docSource, bool) {
+	cache := moduleCacheDir()
+	if cache == "" {
+		return docSource{}, false
+	}
+	dir := filepath.Join(cache, filepath.FromSlash(escapeModulePath(module))+"@"+version, filepath.FromSlash(rel))
+	return docSource{Path: importPath, Dir: dir, Version: version}, true
+}
+
+// docSource returns the source of the documentation of the package with path pkg: the
+// module of a package required with %require, the Go distribution for the standard
+// library, and the module the kernel was built with for the packages of Go+, unless it
+// is replaced by a local directory.
+func (kernel *Kernel) docSource(pkg string) (docSource, bool) {
+	if r := kernel.session.requirements; r != nil {
+		for _, pin := range r.pins {
+			if pin.Package == pkg {
+				return moduleDocSource(pkg, strings.TrimPrefix(pkg, pin.Module), pin.Module, pin.Version)
+			}
+		}
+	}
+	if !strings.Contains(strings.SplitN(pkg, "/", 2)[0], ".") {
+		root := goRoot()
+		if root == "" {
+			return docSource{}, false
+		}
+		return docSource{Path: pkg, Dir: filepath.Join(root, "src", filepath.FromSlash(pkg)), Version: runtime.Version()}, true
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return docSource{}, false
+	}
+	var module *debug.Module
+	for _, dep := range info.Deps {
+		if (pkg == dep.Path || strings.HasPrefix(pkg, dep.Path+"/")) && (module == nil || len(dep.Path) > len(module.Path)) {
+			module = dep
+		}
+	}
+	if module == nil {
+		return docSource{}, false
+	}
+	rel := strings.TrimPrefix(pkg, module.Path)
+	switch replace := module.Replace; {
+	case replace == nil:
+		return moduleDocSource(pkg, rel, module.Path, module.Version)
+	case replace.Version != "":
+		return moduleDocSource(pkg, rel, replace.Path, replace.Version)
+	case filepath.IsAbs(replace.Path):
+		return localDocSource(pkg, filepath.Join(replace.Path, filepath.FromSlash(rel)))
+	}
+	// Replaced by a directory relative to the module of the kernel, which is unknown.
+	return docSource{}, false
+}
+
+var (
+	goRootOnce sync.Once
+	goRootDir  string
+)
+
+// goRoot returns the root of the Go distribution, or "" if there is none.
+func goRoot() string {
+	goRootOnce.Do(func() {
+		if root := runtime.GOROOT(); root != "" {
+			if _, err := os.Stat(filepath.Join(root, "src")); err == nil {
+				goRootDir = root
+				return
+			}
+		}
+		if out, err := exec.Command(goCommand, "env", "GOROOT").Output(); err == nil {
+			goRootDir = strings.TrimSpace(string(out))
+		}
+	})
+	return goRootDir
+}
+
+// docCache holds the documentation extracted from packages, by path and version, in
+// memory and in a directory, since extracting that of large packages takes a while.
+// The documentation of local packages is only kept in memory, for their last version.
+type docCache struct {
+	mu    sync.Mutex
+	dir   string // "" keeps the documentation in memory only
+	pkgs  map[string]*packageDocs
+	local map[string]string // the key of the last version of local packages, by path
+}
+
+// newDocCache returns a cache saving documentation in dir.
+func newDocCache(dir string) *docCache {
+	return &docCache{dir: dir, pkgs: make(map[string]*packageDocs), local: make(map[string]string)}
+}
+
+// packageDocCache is the documentation cache of the kernel, shared by its sessions.
+var packageDocCache = newDocCache(defaultDocCacheDir())
+
+// defaultDocCacheDir returns the directory documentation is saved in, in the user
+// cache directory.
+func defaultDocCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gopyter", "docs")
+}
+
+// lookup returns the documentation of the package of src, from the cache if it has
+// that version of the package.
+func (c *docCache) lookup(src docSource) (*packageDocs, error) {
+	key := src.Path + "@" + src.Version
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if docs, ok := c.pkgs[key]; ok {
+		return docs, nil
+	}
+	var file string
+	if c.dir != "" && !src.Local {
+		file = filepath.Join(c.dir, filepath.FromSlash(key)+".json")
+		var docs packageDocs
+		if data, err := ioutil.ReadFile(file); err == nil && json.Unmarshal(data, &docs) == nil && docs.Format == docFormat {
+			c.pkgs[key] = &docs
+			return &docs, nil
+		}
+	}
+
+	docs, err := extractPackageDocs(src.Dir, src.Path)
+	if err != nil {
+		return nil, err
+	}
+	if src.Local {
+		// Previous versions of the package are not looked up anymore.
+		delete(c.pkgs, c.local[src.Path])
+		c.local[src.Path] = key
+	}
+	c.pkgs[key] = docs
+	if file != "" {
+		// The documentation is extracted again next time if it cannot be saved.
+		if data, err := json.Marshal(docs); err == nil && os.MkdirAll(filepath.Dir(file), 0755) == nil {
+			if err := ioutil.WriteFile(file, data, 0644); err != nil {
+				log.Printf("Could not save the documentation of %s: %v\n", key, err)
+			}
+		}
+	}
+	return docs, nil
+}
+
+// handleInspectRequest replies to an inspect_request, sent by Shift+Tab, with the
+// documentation of the name at the cursor.
+func (kernel *Kernel) handleInspectRequest(receipt msgReceipt) error {
+	reqcontent := receipt.Msg.Content.(map[string]interface{})
+	code, _ := reqcontent["code"].(string)
+	cursorPos, _ := reqcontent["cursor_pos"].(float64)
+	detailLevel, _ := reqcontent["detail_level"].(float64)
+
+	data := map[string]interface{}{}
+	text, found := kernel.snapshot().inspect(code, byteOffset(code, int(cursorPos)), detailLevel > 0)
+	if found {
+		data["text/plain"] = text
+	}
+	return receipt.Reply("inspect_reply", map[string]interface{}{
+		"status":   "ok",
+		"found":    found,
+		"data":     data,
+		"metadata": map[string]interface{}{},
+	})
+}
+
+// inspect returns the documentation of the name of code at the byte offset cursor: a
+// package imported in the session, a name it exports, pkg.Type.Method, or a
+// predeclared name.
+func (kernel *Kernel) inspect(code string, cursor int, detail bool) (string, bool) {
+	if quote, _ := literalAt(code, cursor); quote != 0 {
+		return "", false
+	}
+	names := selectorAt(code, cursor)
+	if len(names) == 0 {
+		return "", false
+	}
+	pkg, symbol := kernel.packageSymbol(names)
+	docs, _, ok := kernel.packageDocs(pkg)
+	if !ok {
+		return "", false
+	}
+	return docs.text(symbol, detail)
+}
+
+// packageSymbol returns the package of the selector names, a package imported in the
+// session or else the package of the predeclared names, and the name it declares they
+// select, or "" if they only name the package.
+func (kernel *Kernel) packageSymbol(names []string) (pkg, symbol string) {
+	pkg, symbol = "builtin", strings.Join(names, ".")
+	_, imports := kernel.session.declaredNames()
+	for p := range imports {
+		if path.Base(p) == names[0] {
+			pkg, symbol = p, strings.Join(names[1:], ".")
+		}
+	}
+	return pkg, symbol
+}
+
+// packageDocs returns the documentation of pkg and where it was extracted from.
+func (kernel *Kernel) packageDocs(pkg string) (*packageDocs, docSource, bool) {
+	src, ok := kernel.docSource(pkg)
+	if !ok {
+		return nil, src, false
+	}
+	docs, err := packageDocCache.lookup(src)
+	if err != nil {
+		// E.g. the packages of the kernel, which have no sources.
+		return nil, src, false
+	}
+	return docs, src, true
+}
+
+// selectorAt returns the identifiers of the selector expression of code at cursor, like
+// [strings Split] for strings.Split, up to the identifier at the cursor. Right after an
+// opening parenthesis, it returns those of the function called.
+func selectorAt(code string, cursor int) []string {
+	end := cursor
+	for end < len(code) {
+		r, size := utf8.DecodeRuneInString(code[end:])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		end += size
+	}
+	start := identStart(code, end)
+	if start == end {
+		before := strings.TrimRight(code[:cursor], " \t")
+		if !strings.HasSuffix(before, "(") {
+			return nil
+		}
+		end = len(before) - 1
+		if start = identStart(code, end); start == end {
+			return nil
+		}
+	}
+	names := []string{code[start:end]}
+	for start > 0 && code[start-1] == '.' {
+		s := identStart(code, start-1)
+		if s == start-1 {
+			break
+		}
+		names = append([]string{code[s : start-1]}, names...)
+		start = s
+	}
+	return names
+}
+
+// markdown returns the documentation of symbol as Markdown, or that of the package if
+// symbol is "". Types are documented by tables of their fields, constructors and
+// methods.
+func (d *packageDocs) markdown(symbol string) (string, bool) {
+	text, ok := d.text(symbol, true)
+	if !ok {
+		return "", false
+	}
+	s := d.Symbols[symbol]
+	if symbol == "" || (len(s.Members) == 0 && len(s.Fields) == 0) {
+		return "```\n" + text + "```\n", true
+	}
+
+	var b strings.Builder
+	decl := s.Decl
+	if len(s.Fields) != 0 {
+		// The fields are in the table.
+		decl = "type " + symbol + " struct"
+	}
+	b.WriteString("```go\n" + decl + "\n```\n\n" + s.Doc)
+	if len(s.Fields) != 0 {
+		b.WriteString("\n**Fields**\n\n| Field | Type | Tag | Description |\n|---|---|---|---|\n")
+		for _, f := range s.Fields {
+			name := markdownCell(f.Name)
+			if f.Embedded {
+				name += " *(embedded)*"
+			}
+			b.WriteString("| " + name + " | " + markdownCode(f.Type) + " | " + markdownCode(f.Tag) + " | " + markdownCell(f.Doc) + " |\n")
+		}
+	}
+	var constructors, methods []string
+	for _, member := range s.Members {
+		if strings.HasPrefix(member, "func (") {
+			methods = append(methods, member)
+		} else {
+			constructors = append(constructors, member)
+		}
+	}
+	members := func(title string, decls []string, name func(decl string) string) {
+		if len(decls) == 0 {
+			return
+		}
+		b.WriteString("\n**" + title + "**\n\n| Name | Signature | Description |\n|---|---|---|\n")
+		for _, decl := range decls {
+			name := name(decl)
+			b.WriteString("| " + markdownCell(name) + " | " + markdownCode(decl) + " | " + markdownCell(d.Symbols[name].Doc) + " |\n")
+		}
+	}
+	members("Constructors", constructors, func(decl string) string {
+		name := strings.TrimPrefix(decl, "func ")
+		return name[:strings.IndexByte(name, '(')]
+	})
+	members("Methods", methods, func(decl string) string {
+		name := decl[strings.IndexByte(decl, ')')+2:]
+		return symbol + "." + name[:strings.IndexByte(name, '(')]
+	})
+	return b.String(), true
+}
+
+// markdownCell returns text on a line, for a cell of a Markdown table.
+func markdownCell(text string) string {
+	return strings.Replace(strings.Join(strings.Fields(text), " "), "|", "\\|", -1)
+}
+
+// markdownCode returns code on a line, as code in a cell of a Markdown table.
+func markdownCode(code string) string {
+	if code == "" {
+		return ""
+	}
+	return "`" + markdownCell(code) + "`"
+}
+
+// evalDocMagic implements `%doc name`, which shows the documentation of a package or of
+// a name it declares.
+func evalDocMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := docSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	name := parsed.arg(0)
+	pkg, symbol := kernel.packageSymbol(strings.Split(name, "."))
+	docs, _, ok := kernel.packageDocs(pkg)
+	if !ok {
+		return fmt.Errorf("no documentation for package %s", pkg)
+	}
+	markdown, ok := docs.markdown(symbol)
+	if !ok {
+		return fmt.Errorf("no documentation for %s", name)
+	}
+	text, _ := docs.text(symbol, true)
+	kernel.display(outerr, MakeData3(MIMETypeMarkdown, text, markdown))
+	return nil
+}
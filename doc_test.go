@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestInspect tests finding the documentation of the name at the cursor.
+func TestInspect(t *testing.T) {
+	defer func(cache *docCache) { packageDocCache = cache }(packageDocCache)
+	packageDocCache = newDocCache("")
+	kernel := Kernel{NewSession(), defaultConfig()}
+	if _, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, "import \"strings\"\nx := 1"); err != nil {
+		t.Fatalf("\t%s Could not import the package: %v.", failure, err)
+	}
+	cases := []struct {
+		code string // the cursor is at |
+		want string // in the documentation, or "" if there is none
+	}{
+		{"strings.Spl|it", "func Split(s, sep string) []string"},
+		{"strings.Split(|", "func Split(s, sep string) []string"},
+		{"strings.Builder.Wri|teString", "func (b *Builder) WriteString(s string) (int, error)"},
+		{"stri|ngs", `package strings // import "strings"`},
+		{"n := le|n(x)", "func len(v Type) int"},
+		{"x|", ""},
+		{`s := "strings.Sp|lit"`, ""},
+		{"strings.Nothing|", ""},
+	}
+
+	t.Logf("Should find the documentation of packages and the names they declare.")
+
+	for _, tc := range cases {
+		cursor := strings.Index(tc.code, "|")
+		code := strings.Replace(tc.code, "|", "", 1)
+		text, found := kernel.inspect(code, cursor, false)
+		if found != (tc.want != "") || !strings.Contains(text, tc.want) {
+			t.Fatalf("\t%s Expected the documentation of %q to have %q, got %q.", failure, tc.code, tc.want, text)
+		}
+		t.Logf("\t%s %q.", success, tc.code)
+	}
+}
+
+// TestDocCache tests caching the documentation of packages in memory and on disk.
+func TestDocCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pkgDir := filepath.Join(dir, "src", "shapes")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(pkgDir, "shapes.go")
+	write := func(doc string, modified time.Time) {
+		if err := ioutil.WriteFile(source, []byte("// Package shapes draws shapes.\npackage shapes\n\n// "+doc+"\nfunc Circle() {}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(source, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Circle draws a circle.", time.Now().Add(-time.Hour))
+
+	t.Logf("Should keep the documentation of a version of a package in memory and on disk.")
+
+	src := docSource{Path: "example.com/shapes", Dir: pkgDir, Version: "v1.0.0"}
+	cache := newDocCache(filepath.Join(dir, "cache"))
+	docs, err := cache.lookup(src)
+	if err != nil {
+		t.Fatalf("\t%s Could not extract the documentation: %v.", failure, err)
+	}
+	if text, _ := docs.text("Circle", false); text != "func Circle()\n    Circle draws a circle.\n" {
+		t.Fatalf("\t%s Unexpected documentation %q.", failure, text)
+	}
+	if cached, _ := cache.lookup(src); cached != docs {
+		t.Fatalf("\t%s Expected the documentation to be kept in memory.", failure)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cache", "example.com", "shapes@v1.0.0.json")); err != nil {
+		t.Fatalf("\t%s Expected the documentation to be saved: %v.", failure, err)
+	}
+	// Another kernel reads it from the disk, without the sources.
+	moved := docSource{Path: src.Path, Dir: filepath.Join(dir, "nowhere"), Version: src.Version}
+	if saved, err := newDocCache(filepath.Join(dir, "cache")).lookup(moved); err != nil || saved.Symbols["Circle"].Doc != "Circle draws a circle.\n" {
+		t.Fatalf("\t%s Expected the documentation to be read from the disk, got %v.", failure, err)
+	}
+	t.Logf("\t%s Cached %s.", success, src.Path)
+
+	t.Logf("Should extract the documentation of a local package again when it is edited.")
+
+	local, _ := localDocSource("shapes", pkgDir)
+	docs, err = cache.lookup(local)
+	if err != nil {
+		t.Fatalf("\t%s Could not extract the documentation: %v.", failure, err)
+	}
+	write("Circle draws a round shape.", time.Now())
+	edited, _ := localDocSource("shapes", pkgDir)
+	if edited.Version == local.Version {
+		t.Fatalf("\t%s Expected the version of the package to change, got %s.", failure, edited.Version)
+	}
+	docs, err = cache.lookup(edited)
+	if err != nil || docs.Symbols["Circle"].Doc != "Circle draws a round shape.\n" {
+		t.Fatalf("\t%s Expected the edited documentation, got %+v (%v).", failure, docs, err)
+	}
+	if _, ok := cache.pkgs["shapes@"+local.Version]; ok {
+		t.Fatalf("\t%s Expected the previous version to be dropped.", failure)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "cache", "shapes@*")); len(files) != 0 {
+		t.Fatalf("\t%s Expected local packages not to be saved, got %v.", failure, files)
+	}
+	t.Logf("\t%s Reloaded %s.", success, local.Path)
+}
+
+// TestDocMarkdown tests documenting types with tables of their fields and methods.
+func TestDocMarkdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := "package shapes\n\n" +
+		"// Point is a point of the plane.\ntype Point struct {\n" +
+		"\t// X is the abscissa.\n\tX, Y float64 `json:\"x\"`\n\tLabel string // shown | next to it\n\tfmt.Stringer\n\thidden int\n}\n\n" +
+		"// Origin returns the origin.\nfunc Origin() Point { return Point{} }\n\n" +
+		"// Move moves p.\nfunc (p *Point) Move(dx, dy float64) {}\n\n" +
+		"// Celsius is a temperature.\ntype Celsius float64\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "shapes.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	docs, err := extractPackageDocs(dir, "example.com/shapes")
+	if err != nil {
+		t.Fatalf("\t%s Could not extract the documentation: %v.", failure, err)
+	}
+	cases := []struct {
+		symbol string
+		want   []string // in the Markdown
+	}{
+		{"Point", []string{
+			"```go\ntype Point struct\n```\n\nPoint is a point of the plane.\n",
+			"| X | `float64` | `json:\"x\"` | X is the abscissa. |\n| Y | `float64` | `json:\"x\"` | X is the abscissa. |\n",
+			"| Label | `string` |  | shown \\| next to it |\n",
+			"| Stringer *(embedded)* | `fmt.Stringer` |  |  |\n",
+			"**Constructors**\n\n| Name | Signature | Description |\n|---|---|---|\n| Origin | `func Origin() Point` | Origin returns the origin. |\n",
+			"**Methods**\n\n| Name | Signature | Description |\n|---|---|---|\n| Point.Move | `func (p *Point) Move(dx, dy float64)` | Move moves p. |\n",
+		}},
+		{"Celsius", []string{"```\ntype Celsius float64\n    Celsius is a temperature.\n```\n"}},
+	}
+
+	t.Logf("Should render the fields and methods of types as tables.")
+
+	for _, tc := range cases {
+		markdown, ok := docs.markdown(tc.symbol)
+		if !ok {
+			t.Fatalf("\t%s Expected the documentation of %s.", failure, tc.symbol)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(markdown, want) {
+				t.Fatalf("\t%s Expected the documentation of %s to have %q, got:\n%s", failure, tc.symbol, want, markdown)
+			}
+		}
+		if strings.Contains(markdown, "hidden") {
+			t.Fatalf("\t%s Expected the unexported fields to be left out, got:\n%s", failure, markdown)
+		}
+		t.Logf("\t%s %s.", success, tc.symbol)
+	}
+	if _, ok := docs.markdown("Nothing"); ok {
+		t.Fatalf("\t%s Expected no documentation for an undeclared name.", failure)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("Dot", newDotGraph, execDot),
+	)
+}
+
+// dotCommand is the Graphviz program rendering DOT graphs.
+var dotCommand = "dot"
+
+// vizScript loads Viz.js, the build of Graphviz for browsers, which renders graphs
+// when Graphviz is not installed on the machine of the kernel.
+const vizScript = "https://cdn.jsdelivr.net/npm/@viz-js/viz@3.2.4/lib/viz-standalone.js"
+
+// DotGraph is a graph in the DOT language of Graphviz, as returned by Dot.
+type DotGraph struct {
+	Source string
+}
+
+func newDotGraph(src string) *DotGraph {
+	return &DotGraph{Source: src}
+}
+
+func execDot(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, newDotGraph(args[0].(string)))
+}
+
+func (g *DotGraph) String() string {
+	return g.Source
+}
+
+// Render renders the graph as SVG with the dot program. If Graphviz is not installed,
+// or in the sandbox where cells cannot start programs, the graph is rendered by the
+// browser with Viz.js instead, which needs access to the Internet.
+func (g *DotGraph) Render() Data {
+	path, err := exec.LookPath(dotCommand)
+	if err != nil || sandboxed {
+		return g.renderInBrowser()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, "-Tsvg")
+	cmd.Stdin = strings.NewReader(g.Source)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", dotCommand, msg)
+		}
+		return makeDataErr(err)
+	}
+	return MakeData3(MIMETypeSVG, g.Source, stdout.String())
+}
+
+// renderInBrowser renders the graph as an isolated output loading Viz.js.
+func (g *DotGraph) renderInBrowser() Data {
+	src, _ := json.Marshal(g.Source)
+	page := `<div id="graph"></div><script src="` + vizScript + `"></script>` +
+		`<script>Viz.instance().then(function(viz) {` +
+		`document.getElementById("graph").appendChild(viz.renderSVGElement(` + string(src) + `));` +
+		`}).catch(function(err) { document.getElementById("graph").textContent = err; });</script>`
+	d := MakeData3(MIMETypeHTML, g.Source, page)
+	d.Metadata = MIMEMap{MIMETypeHTML: map[string]interface{}{"isolated": true}}
+	return d
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestDot tests rendering DOT graphs with Graphviz, or in the browser without it.
+func TestDot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake dot program is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "gopyter-dot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The fake dot program wraps its input in an SVG document.
+	fakeDot := filepath.Join(dir, "dot")
+	script := "#!/bin/sh\n[ \"$1\" = -Tsvg ] || exit 1\necho '<svg>'; cat; echo '</svg>'\n"
+	if err := ioutil.WriteFile(fakeDot, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(command string) { dotCommand = command }(dotCommand)
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	vals, err := kernel.session.Eval("Dot(`digraph { a -> b }`)")
+	if err != nil {
+		t.Fatalf("\t%s Evaluating Dot failed: %v.", failure, err)
+	}
+
+	t.Logf("Should render graphs as SVG with the dot program.")
+
+	dotCommand = fakeDot
+	data := kernel.autoRenderResults(vals)
+	if svg, _ := data.Data[MIMETypeSVG].(string); svg != "<svg>\ndigraph { a -> b }</svg>\n" {
+		t.Fatalf("\t%s Expected the SVG of the graph, got %q.", failure, svg)
+	}
+	t.Logf("\t%s Rendered the graph as SVG.", success)
+
+	t.Logf("Should render graphs in the browser without Graphviz.")
+
+	dotCommand = filepath.Join(dir, "missing")
+	data = kernel.autoRenderResults(vals)
+	html, _ := data.Data[MIMETypeHTML].(string)
+	if !strings.Contains(html, vizScript) || !strings.Contains(html, `renderSVGElement("digraph { a -\u003e b }")`) {
+		t.Fatalf("\t%s Expected a script rendering the graph, got %s.", failure, html)
+	}
+	t.Logf("\t%s Rendered the graph with Viz.js.", success)
+}
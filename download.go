@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("Download", newDownload, execDownload),
+	)
+}
+
+// maxInlineDownload is the size up to which the content of a file is embedded in its
+// download link, so that it can be downloaded even when the notebook is viewed away
+// from the kernel. Larger files are linked by path, relative to the notebook.
+const maxInlineDownload = 1 << 20
+
+// DownloadLink is a link to download a file from the output of a cell, as returned by
+// Download.
+type DownloadLink struct {
+	Path  string
+	Label string
+}
+
+// newDownload returns a link to download the file at path, showing label, or the name of
+// the file if label is empty.
+func newDownload(path, label string) *DownloadLink {
+	if label == "" {
+		label = filepath.Base(path)
+	}
+	return &DownloadLink{Path: path, Label: label}
+}
+
+func execDownload(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, newDownload(args[0].(string), args[1].(string)))
+}
+
+func (d *DownloadLink) String() string {
+	return fmt.Sprintf("%s (%s)", d.Label, d.Path)
+}
+
+// Render renders the link as HTML. The link fails to render if the file cannot be read,
+// or is outside of the sandbox roots when the sandbox is enabled.
+func (d *DownloadLink) Render() Data {
+	if sandboxed && !inSandbox(d.Path) {
+		return makeDataErr(&os.PathError{Op: "download", Path: d.Path, Err: errSandboxed})
+	}
+	info, err := os.Stat(d.Path)
+	if err != nil {
+		return makeDataErr(err)
+	}
+	if info.IsDir() {
+		return makeDataErr(fmt.Errorf("%s is a directory", d.Path))
+	}
+
+	href := filepath.ToSlash(d.Path)
+	if info.Size() <= maxInlineDownload {
+		content, err := ioutil.ReadFile(d.Path)
+		if err != nil {
+			return makeDataErr(err)
+		}
+		mimeType := mime.TypeByExtension(filepath.Ext(d.Path))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		href = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(content)
+	}
+
+	link := fmt.Sprintf(`<a href="%s" download="%s" target="_blank">%s</a> <small>(%s)</small>`,
+		html.EscapeString(href), html.EscapeString(filepath.Base(d.Path)),
+		html.EscapeString(d.Label), formatSize(info.Size()))
+	return MakeData3(MIMETypeHTML, d.String(), link)
+}
+
+// formatSize formats a number of bytes for humans.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
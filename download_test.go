@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDownload tests rendering links to download files.
+func TestDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.csv")
+	if err := ioutil.WriteFile(path, []byte("a,b\n1,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Should render a cell calling Download as a link embedding the file.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	vals, err := kernel.session.Eval(`Download("` + filepath.ToSlash(path) + `", "")`)
+	if err != nil {
+		t.Fatalf("\t%s Evaluating Download failed: %v.", failure, err)
+	}
+	data := kernel.autoRenderResults(vals)
+	link, _ := data.Data[MIMETypeHTML].(string)
+	for _, s := range []string{`href="data:text/csv`, `download="out.csv"`, `>out.csv</a>`, "(8 B)"} {
+		if !strings.Contains(link, s) {
+			t.Fatalf("\t%s Expected %s in the link, got %s.", failure, s, link)
+		}
+	}
+	t.Logf("\t%s Rendered %s.", success, data.Data[MIMETypeText])
+
+	t.Logf("Should link large files by path.")
+
+	big := filepath.Join(dir, "big.bin")
+	if err := ioutil.WriteFile(big, make([]byte, maxInlineDownload+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link, _ = newDownload(big, "all data").Render().Data[MIMETypeHTML].(string)
+	if !strings.Contains(link, `href="`+filepath.ToSlash(big)+`"`) || !strings.Contains(link, ">all data</a> <small>(1.0 MiB)</small>") {
+		t.Fatalf("\t%s Expected a link to the path, got %s.", failure, link)
+	}
+	t.Logf("\t%s Linked the file by path.", success)
+
+	if data := newDownload(filepath.Join(dir, "missing"), "").Render(); data.Data["ename"] != "ERROR" {
+		t.Fatalf("\t%s Expected an error for a missing file, got %v.", failure, data.Data)
+	}
+
+	t.Logf("Should refuse files outside of the sandbox roots.")
+
+	root, err := ioutil.TempDir("", "gopyter-download-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.Symlink(path, filepath.Join(root, "escape.csv")); err != nil {
+		t.Fatal(err)
+	}
+	defer func(enabled bool, roots []string) { sandboxed, sandboxRoots = enabled, roots }(sandboxed, sandboxRoots)
+	resolved, err := resolvePath(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sandboxed, sandboxRoots = true, []string{resolved}
+	for _, p := range []string{path, filepath.Join(root, "escape.csv")} {
+		data := newDownload(p, "").Render()
+		if data.Data["ename"] != "ERROR" || !strings.Contains(data.Data["evalue"].(string), errSandboxed.Error()) {
+			t.Fatalf("\t%s Expected %s to be refused, got %v.", failure, p, data.Data)
+		}
+		t.Logf("\t%s Refused %s.", success, p)
+	}
+}
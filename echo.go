@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	lineMagics["echo"] = evalEchoMagic
+	lineMagics["interactivity"] = evalInteractivityMagic
+	lineMagics["verbose_assign"] = evalVerboseAssignMagic
+	documentMagic(echoSyntax)
+	documentMagic(interactivitySyntax)
+	documentMagic(verboseAssignSyntax)
+}
+
+var echoSyntax = &magicSyntax{
+	name:  "%echo",
+	usage: []string{"[on|off]"},
+	doc: "Enables or disables echoing the source of each top-level statement of the cells before evaluating it, " +
+		"or reports whether it is enabled.",
+	args: []magicParam{{name: "on|off", help: "enables or disables echoing the statements", optional: true}},
+}
+
+var interactivitySyntax = &magicSyntax{
+	name:  "%interactivity",
+	usage: []string{"[last|all]"},
+	doc: "Sets which top-level expressions of the cells have their result shown: only the last one, " +
+		"or all of them, or reports the setting.",
+	args: []magicParam{{name: "last|all", help: "the expressions whose result is shown", optional: true}},
+}
+
+var verboseAssignSyntax = &magicSyntax{
+	name:  "%verbose_assign",
+	usage: []string{"[on|off]"},
+	doc: "Enables or disables showing the value of the variables assigned by each top-level statement of the cells, " +
+		"as `name: value`, or reports whether it is enabled.",
+	args: []magicParam{{name: "on|off", help: "enables or disables showing the assigned values", optional: true}},
+}
+
+// The settings of interactivity, after the ast_node_interactivity setting of IPython.
+const (
+	interactivityLast = "last"
+	interactivityAll  = "all"
+)
+
+// In echo mode, meant for teaching, the top-level statements of a cell are evaluated one
+// after the other, and the source of each is shown before its result, so students see
+// what every statement does. Statements evaluated before a failing one are kept.
+
+// evalEchoMagic implements `%echo [on|off]`, which enables or disables echo mode, or
+// reports whether it is enabled.
+func evalEchoMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := echoSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	switch parsed.arg(0) {
+	case "on":
+		kernel.config.Echo = true
+	case "off":
+		kernel.config.Echo = false
+	case "":
+	default:
+		return echoSyntax.errorf("expected on or off, got %q", args)
+	}
+	state := "off"
+	if kernel.config.Echo {
+		state = "on"
+	}
+	fmt.Fprintf(outerr.out, "Echo mode is %s.\n", state)
+	return nil
+}
+
+// evalInteractivityMagic implements `%interactivity [last|all]`, which sets whether the
+// result of every top-level expression of the cells is shown, or only the last one.
+func evalInteractivityMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := interactivitySyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	switch parsed.arg(0) {
+	case interactivityLast, interactivityAll:
+		kernel.config.Interactivity = parsed.arg(0)
+	case "":
+	default:
+		return interactivitySyntax.errorf("expected last or all, got %q", args)
+	}
+	if kernel.config.Interactivity == interactivityAll {
+		fmt.Fprintln(outerr.out, "The results of all the top-level expressions are shown.")
+	} else {
+		fmt.Fprintln(outerr.out, "The result of the last top-level expression is shown.")
+	}
+	return nil
+}
+
+// evalVerboseAssignMagic implements `%verbose_assign [on|off]`, which enables or
+// disables showing the values assigned by the top-level statements of the cells.
+func evalVerboseAssignMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := verboseAssignSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	switch parsed.arg(0) {
+	case "on":
+		kernel.config.VerboseAssign = true
+	case "off":
+		kernel.config.VerboseAssign = false
+	case "":
+	default:
+		return verboseAssignSyntax.errorf("expected on or off, got %q", args)
+	}
+	state := "off"
+	if kernel.config.VerboseAssign {
+		state = "on"
+	}
+	fmt.Fprintf(outerr.out, "Verbose assignment is %s.\n", state)
+	return nil
+}
+
+// evalStatements evaluates the top-level statements of code, Go+ code free of magics
+// and shell commands, one after the other. The result of each expression statement is
+// shown, in echo mode the source of each statement before it, and with verbose
+// assignment the values of the variables it assigned after it.
+func (kernel *Kernel) evalStatements(outerr OutErr, code string) ([]interface{}, error) {
+	stmts := splitStatements(code)
+	for i, stmt := range stmts {
+		if kernel.config.Echo && strings.TrimSpace(stmt.src) != "" {
+			kernel.display(outerr, echoData(stmt.src))
+		}
+		vals, err := kernel.evalChunk(outerr, stmt.src)
+		if err == nil && kernel.config.VerboseAssign {
+			for _, name := range stmt.assigns {
+				if data, ok := kernel.assignmentData(name); ok {
+					kernel.display(outerr, data)
+				}
+			}
+		}
+		if err != nil || i == len(stmts)-1 {
+			return vals, err
+		}
+		if data := kernel.autoRenderResults(vals); stmt.expr && len(data.Data) != 0 {
+			kernel.display(outerr, data)
+		}
+	}
+	return nil, nil
+}
+
+// evalChunk evaluates Go+ code, free of magics and shell commands, importing the
+// packages it uses if autoimport is on.
+func (kernel *Kernel) evalChunk(outerr OutErr, code string) ([]interface{}, error) {
+	if kernel.config.AutoImport {
+		return kernel.evalAutoImporting(outerr, code)
+	}
+	return kernel.evalCode(code)
+}
+
+// display shows data in the output of the cell being evaluated. Without a front-end,
+// as in the console, the plain text of data is printed.
+func (kernel *Kernel) display(outerr OutErr, data Data) {
+	data = kernel.session.redactor.redactData(data)
+	if kernel.session.display != nil {
+		kernel.session.display(data)
+		return
+	}
+	fmt.Fprintln(outerr.out, data.Data[MIMETypeText])
+}
+
+// echoData returns the source of a statement, to show before its output.
+func echoData(stmt string) Data {
+	stmt = strings.Trim(stmt, "\n")
+	return MakeData3(MIMETypeMarkdown, ">>> "+strings.Replace(stmt, "\n", "\n... ", -1), "```go\n"+stmt+"\n```")
+}
+
+// assignmentData returns the value of the variable name, rendered like results are,
+// as `name: value`.
+func (kernel *Kernel) assignmentData(name string) (Data, bool) {
+	vals, err := kernel.session.Peek(name)
+	if err != nil {
+		return Data{}, false
+	}
+	data := kernel.autoRenderResults(vals)
+	prefixed := make(MIMEMap, len(data.Data))
+	for mimeType, value := range data.Data {
+		prefixed[mimeType] = value
+		if s, ok := value.(string); ok {
+			switch mimeType {
+			case MIMETypeText:
+				prefixed[mimeType] = name + ": " + s
+			case MIMETypeHTML:
+				prefixed[mimeType] = "<code>" + html.EscapeString(name) + ":</code> " + s
+			case MIMETypeMarkdown:
+				prefixed[mimeType] = "`" + name + ":` " + s
+			}
+		}
+	}
+	data.Data = prefixed
+	return data, true
+}
+
+// cellStatement is one or more top-level statements of a cell.
+type cellStatement struct {
+	src     string
+	expr    bool     // whether the last statement is an expression
+	assigns []string // the variables the statements assign, in order
+}
+
+// splitStatements splits code, the code of a cell, into its top-level statements, each
+// with the declarations and comments before it. Statements ending on the same line are
+// kept together, so the statements are made of whole lines of code. Code that does not
+// parse is not split, so that evaluating it reports the error.
+func splitStatements(code string) []cellStatement {
+	fset := token.NewFileSet()
+	rewritten := rewriteGopSyntax(code)
+	f, err := parser.ParseFile(fset, "", rewritten, 0)
+	if err != nil || !f.NoEntrypoint {
+		return []cellStatement{{src: code}}
+	}
+	var body []ast.Stmt
+	for _, decl := range f.Decls {
+		if main, ok := decl.(*ast.FuncDecl); ok && main.Recv == nil && main.Name.Name == "main" {
+			body = main.Body.List
+		}
+	}
+
+	lines := strings.SplitAfter(code, "\n")
+	var stmts []cellStatement
+	start := 0
+	for _, stmt := range body {
+		end, _ := cellPosition(fset, f, rewritten, stmt.End())
+		_, expr := stmt.(*ast.ExprStmt)
+		if end <= start {
+			if len(stmts) != 0 {
+				stmts[len(stmts)-1].expr = expr
+				stmts[len(stmts)-1].assigns = assignedNames(stmts[len(stmts)-1].assigns, stmt)
+			}
+			continue
+		}
+		stmts = append(stmts, cellStatement{strings.Join(lines[start:end], ""), expr, assignedNames(nil, stmt)})
+		start = end
+	}
+	if len(stmts) == 0 {
+		return []cellStatement{{src: code}}
+	}
+	// Keep the comments after the last statement with it.
+	stmts[len(stmts)-1].src += strings.Join(lines[start:], "")
+	return stmts
+}
+
+// assignedNames adds the variables assigned by stmt, an assignment or a variable
+// declaration, to names, once.
+func assignedNames(names []string, stmt ast.Stmt) []string {
+	add := func(name string) {
+		if name == "_" {
+			return
+		}
+		for _, n := range names {
+			if n == name {
+				return
+			}
+		}
+		names = append(names, name)
+	}
+	switch stmt := stmt.(type) {
+	case *ast.AssignStmt:
+		for _, lhs := range stmt.Lhs {
+			if ident, ok := lhs.(*ast.Ident); ok {
+				add(ident.Name)
+			}
+		}
+	case *ast.IncDecStmt:
+		if ident, ok := stmt.X.(*ast.Ident); ok {
+			add(ident.Name)
+		}
+	case *ast.DeclStmt:
+		if decl, ok := stmt.Decl.(*ast.GenDecl); ok && decl.Tok == token.VAR {
+			for _, spec := range decl.Specs {
+				for _, ident := range spec.(*ast.ValueSpec).Names {
+					add(ident.Name)
+				}
+			}
+		}
+	}
+	return names
+}
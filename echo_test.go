@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// TestSplitStatements tests splitting cells into their top-level statements.
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		code  string
+		stmts []string
+	}{
+		{"x := 1\nx + 1", []string{"x := 1\n", "x + 1"}},
+		{"type P struct {\n\tx int\n}\n// make one\np := P{1}; q := p\nif p.x > 0 {\n\tprintln \"yes\"\n}\n// done\n", []string{
+			"type P struct {\n\tx int\n}\n// make one\np := P{1}; q := p\n",
+			"if p.x > 0 {\n\tprintln \"yes\"\n}\n// done\n",
+		}},
+		{"func f() int {\n\treturn 1\n}", []string{"func f() int {\n\treturn 1\n}"}},
+		{"x := (\ny := 2", []string{"x := (\ny := 2"}},
+	}
+
+	t.Logf("Should split cells into their top-level statements.")
+
+	for _, tc := range cases {
+		var stmts []string
+		for _, stmt := range splitStatements(tc.code) {
+			stmts = append(stmts, stmt.src)
+		}
+		if !reflect.DeepEqual(stmts, tc.stmts) {
+			t.Fatalf("\t%s Expected %q to be split into %q, got %q.", failure, tc.code, tc.stmts, stmts)
+		}
+		t.Logf("\t%s Split %q.", success, tc.code)
+	}
+}
+
+// TestEchoMode tests showing the statements of cells before their output.
+func TestEchoMode(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+	var shown []string
+	kernel.session.display = func(data Data) {
+		shown = append(shown, fmt.Sprint(data.Data[MIMETypeText]))
+	}
+
+	if _, err := kernel.doEvalGop(outerr, "%echo on"); err != nil || !kernel.config.Echo {
+		t.Fatalf("\t%s Expected %%echo on to enable echo mode, got %v.", failure, err)
+	}
+
+	t.Logf("Should show each statement before evaluating it.")
+
+	vals, err := kernel.doEvalGop(outerr, "x := 20\ny := x +\n\t1\nx + y")
+	if err != nil || len(vals) != 1 || vals[0] != 41 {
+		t.Fatalf("\t%s Expected the cell to evaluate to 41, got %v (%v).", failure, vals, err)
+	}
+	want := []string{">>> x := 20", ">>> y := x +\n... \t1", ">>> x + y"}
+	if !reflect.DeepEqual(shown, want) {
+		t.Fatalf("\t%s Expected %q to be shown, got %q.", failure, want, shown)
+	}
+	t.Logf("\t%s Showed %q.", success, shown)
+
+	t.Logf("Should keep the statements before a failing one.")
+
+	if _, err := kernel.doEvalGop(outerr, "z := 1\nundefinedName"); err == nil {
+		t.Fatalf("\t%s Expected the cell to fail.", failure)
+	}
+	if vals, err := kernel.doEvalGop(outerr, "%echo off\nz"); err != nil || len(vals) != 1 || vals[0] != 1 {
+		t.Fatalf("\t%s Expected z to be 1, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Kept the statements.", success)
+}
+
+// TestInteractivityAll tests showing the results of all the top-level expressions.
+func TestInteractivityAll(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+	var shown []string
+	kernel.session.display = func(data Data) {
+		shown = append(shown, fmt.Sprint(data.Data[MIMETypeText]))
+	}
+
+	t.Logf("Should show the result of every top-level expression.")
+
+	vals, err := kernel.doEvalGop(outerr, "%interactivity all\nx := 2\nx * 10\nx = 3\nx * 100; x * 1000\nx")
+	if err != nil || len(vals) != 1 || vals[0] != 3 {
+		t.Fatalf("\t%s Expected the cell to evaluate to 3, got %v (%v).", failure, vals, err)
+	}
+	if want := []string{"20", "300 3000"}; !reflect.DeepEqual(shown, want) {
+		t.Fatalf("\t%s Expected %q to be shown, got %q.", failure, want, shown)
+	}
+	t.Logf("\t%s Showed %q.", success, shown)
+}
+
+// TestVerboseAssign tests showing the values assigned by the top-level statements.
+func TestVerboseAssign(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+	var shown []string
+	kernel.session.display = func(data Data) {
+		shown = append(shown, fmt.Sprint(data.Data[MIMETypeText]))
+	}
+
+	t.Logf("Should show the variables assigned by each statement after it.")
+
+	vals, err := kernel.doEvalGop(outerr, "%verbose_assign on\nx, y := 2, \"two\"\nx++; _ = y\nif x > 2 {\n\tx = 10\n}\nvar z = x * 2\nz")
+	if err != nil || len(vals) != 1 || vals[0] != 20 {
+		t.Fatalf("\t%s Expected the cell to evaluate to 20, got %v (%v).", failure, vals, err)
+	}
+	if want := []string{"x: 2", "y: two", "x: 3", "z: 20"}; !reflect.DeepEqual(shown, want) {
+		t.Fatalf("\t%s Expected %q to be shown, got %q.", failure, want, shown)
+	}
+	t.Logf("\t%s Showed %q.", success, shown)
+
+	t.Logf("Should render the values like results.")
+
+	data, ok := kernel.assignmentData("x")
+	if !ok || data.Data[MIMETypeText] != "x: 10" {
+		t.Fatalf("\t%s Expected x: 10, got %v.", failure, data.Data)
+	}
+	if _, ok := kernel.assignmentData("undefinedName"); ok {
+		t.Fatalf("\t%s Expected no value for an undefined variable.", failure)
+	}
+	t.Logf("\t%s Rendered x.", success)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("IFrame", newIFrame, execIFrame),
+		builtin.I.Func("ScriptHTML", newScriptHTML, execScriptHTML),
+	)
+}
+
+// IFrameOutput embeds a web page in the output of a cell, as returned by IFrame.
+type IFrameOutput struct {
+	URL    string
+	Width  int // in pixels, or 0 for the width of the output
+	Height int // in pixels
+}
+
+// newIFrame returns an output embedding the page at url, width by height pixels. A
+// width of 0 takes the width of the output, and a height of 0 defaults to 400 pixels.
+func newIFrame(url string, width, height int) *IFrameOutput {
+	if height <= 0 {
+		height = 400
+	}
+	return &IFrameOutput{URL: url, Width: width, Height: height}
+}
+
+func execIFrame(_ int, p *gop.Context) {
+	args := p.GetArgs(3)
+	p.Ret(3, newIFrame(args[0].(string), args[1].(int), args[2].(int)))
+}
+
+func (f *IFrameOutput) String() string {
+	return "IFrame(" + f.URL + ")"
+}
+
+// Render renders the page in a sandboxed frame. Scripts, forms and popups are allowed
+// so that maps and viewers work, but pages from the origin of the notebook are kept in
+// a distinct origin, so they cannot reach the notebook through it. Only the browser
+// knows the origin of the notebook, so the frame starts in a distinct origin, and a
+// script lets pages of absolute URLs keep their own origin, which they need for their
+// cookies and storage, unless it is that of the notebook. In untrusted notebooks,
+// whose scripts do not run, pages always stay in a distinct origin.
+func (f *IFrameOutput) Render() Data {
+	width := "100%"
+	if f.Width > 0 {
+		width = fmt.Sprint(f.Width)
+	}
+	frame := fmt.Sprintf(`<iframe src="%s" width="%s" height="%d" sandbox="allow-scripts allow-forms allow-popups" frameborder="0" allowfullscreen></iframe>`,
+		html.EscapeString(f.URL), width, f.Height)
+	if u, err := url.Parse(f.URL); err == nil && u.Host != "" {
+		frame += sameOriginScript
+	}
+	return MakeData3(MIMETypeHTML, f.String(), frame)
+}
+
+// sameOriginScript reloads the frame before it with its own origin, unless it is the
+// origin of the notebook.
+const sameOriginScript = `<script>(function(frame) {` +
+	`if (new URL(frame.src, location.href).origin === location.origin) return;` +
+	`frame.sandbox.add("allow-same-origin"); frame.src = frame.src;` +
+	`})(document.currentScript.previousElementSibling)</script>`
+
+// ScriptOutput is HTML with scripts, as returned by ScriptHTML.
+type ScriptOutput struct {
+	HTML string
+}
+
+func newScriptHTML(html string) *ScriptOutput {
+	return &ScriptOutput{HTML: html}
+}
+
+func execScriptHTML(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, newScriptHTML(args[0].(string)))
+}
+
+func (s *ScriptOutput) String() string {
+	return fmt.Sprintf("ScriptHTML(%d bytes)", len(s.HTML))
+}
+
+// Render renders the HTML as an isolated output, which Jupyter front-ends show in a
+// frame of its own: its scripts run without access to the notebook, and its styles do
+// not leak into it. Front-ends only run the scripts of trusted notebooks.
+func (s *ScriptOutput) Render() Data {
+	d := MakeData3(MIMETypeHTML, s.String(), s.HTML)
+	d.Metadata = MIMEMap{MIMETypeHTML: map[string]interface{}{"isolated": true}}
+	return d
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestEmbed tests the outputs embedding pages and scripts.
+func TestEmbed(t *testing.T) {
+	cases := []struct {
+		code     string
+		html     string
+		metadata MIMEMap
+	}{
+		{
+			`IFrame("https://example.com/map?a=1&b=2", 600, 300)`,
+			`<iframe src="https://example.com/map?a=1&amp;b=2" width="600" height="300" sandbox="allow-scripts allow-forms allow-popups" frameborder="0" allowfullscreen></iframe>` + sameOriginScript,
+			nil,
+		},
+		{
+			`IFrame("files/report.html", 0, 0)`,
+			`width="100%" height="400" sandbox="allow-scripts allow-forms allow-popups"`,
+			nil,
+		},
+		{
+			`ScriptHTML("<div id=\"map\"></div><script>draw()</script>")`,
+			`<div id="map"></div><script>draw()</script>`,
+			MIMEMap{MIMETypeHTML: map[string]interface{}{"isolated": true}},
+		},
+	}
+
+	t.Logf("Should render the embedded pages and scripts.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	for _, tc := range cases {
+		vals, err := kernel.session.Eval(tc.code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %s failed: %v.", failure, tc.code, err)
+		}
+		data := kernel.autoRenderResults(vals)
+		if html, _ := data.Data[MIMETypeHTML].(string); !strings.Contains(html, tc.html) {
+			t.Fatalf("\t%s Expected %s to render %s, got %s.", failure, tc.code, tc.html, html)
+		}
+		if !reflect.DeepEqual(data.Metadata, tc.metadata) {
+			t.Fatalf("\t%s Expected the metadata %v, got %v.", failure, tc.metadata, data.Metadata)
+		}
+		t.Logf("\t%s Rendered %s.", success, tc.code)
+	}
+
+	t.Logf("Should keep the pages of relative URLs, from the notebook's server, in a distinct origin.")
+
+	if html := newIFrame("files/report.html", 0, 0).Render().Data[MIMETypeHTML].(string); strings.Contains(html, "allow-same-origin") {
+		t.Fatalf("\t%s Expected no way to the same origin, got %s.", failure, html)
+	}
+	t.Logf("\t%s Kept them.", success)
+}
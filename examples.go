@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	lineMagics["examples"] = evalExamplesMagic
+	lineMagics["runexample"] = evalRunExampleMagic
+	documentMagic(examplesSyntax)
+	documentMagic(runExampleSyntax)
+}
+
+var examplesSyntax = &magicSyntax{
+	name:  "%examples",
+	usage: []string{"package"},
+	doc: "Lists the `Example` functions of the tests of a package, with the names they show and whether " +
+		"`%runexample` can run them.",
+	args: []magicParam{
+		{name: "package", help: "the package imported in the session, like `strings`, or its import path"},
+	},
+}
+
+var runExampleSyntax = &magicSyntax{
+	name:  "%runexample",
+	usage: []string{"package.Example"},
+	doc: "Shows the source of an `Example` function of the tests of a package and runs it in the session, " +
+		"then reports whether its output differs from the output the example expects.",
+	args: []magicParam{
+		{name: "package.Example", help: "the package and the example, like `strings.ExampleSplit`"},
+	},
+}
+
+// packageExample is an Example function of the tests of a package.
+type packageExample struct {
+	*doc.Example
+	fset *token.FileSet
+}
+
+// exampleName returns the name of the function of the example, like ExampleSplit.
+func (e packageExample) exampleName() string {
+	if e.Name == "" {
+		return "Example"
+	}
+	return "Example" + e.Name
+}
+
+// shows returns the name the example shows, qualified by pkg, like strings.Split for
+// ExampleSplit or strings.Builder.WriteString for ExampleBuilder_WriteString, or pkg
+// for the examples of the package.
+func (e packageExample) shows(pkg string) string {
+	parts := strings.Split(e.Name, "_")
+	// The suffix naming one of several examples starts with a lower case letter.
+	if r, _ := utf8.DecodeRuneInString(parts[len(parts)-1]); unicode.IsLower(r) {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) == 0 || parts[0] == "" {
+		return pkg
+	}
+	return pkg + "." + strings.Join(parts, ".")
+}
+
+// cell returns the code of a cell running the example: the imports of the example and
+// the declarations it uses, followed by its body in a function literal, which keeps
+// the variables of the example out of the session. Examples of the internal tests of
+// packages, using their unexported names, cannot run outside of them.
+func (e packageExample) cell() (string, error) {
+	if e.Play == nil {
+		return "", fmt.Errorf("%s is not self-contained and cannot run in the session", e.exampleName())
+	}
+	var main *ast.FuncDecl
+	play := *e.Play
+	play.Decls = nil
+	for _, decl := range e.Play.Decls {
+		if f, ok := decl.(*ast.FuncDecl); ok && f.Name.Name == "main" && f.Recv == nil {
+			main = f
+		} else {
+			play.Decls = append(play.Decls, decl)
+		}
+	}
+	if main == nil {
+		return "", fmt.Errorf("could not find the body of %s", e.exampleName())
+	}
+	// The comments of the body are printed with it, and those of the declarations with
+	// them.
+	var comments []*ast.CommentGroup
+	play.Comments = nil
+	for _, c := range e.Play.Comments {
+		if c.Pos() >= main.Pos() && c.End() <= main.End() {
+			comments = append(comments, c)
+		} else {
+			play.Comments = append(play.Comments, c)
+		}
+	}
+
+	var prelude, body bytes.Buffer
+	if err := format.Node(&prelude, e.fset, &play); err != nil {
+		return "", err
+	}
+	if err := format.Node(&body, e.fset, &printer.CommentedNode{Node: main.Body, Comments: comments}); err != nil {
+		return "", err
+	}
+	code := strings.TrimSpace(strings.TrimPrefix(prelude.String(), "package main\n"))
+	if code != "" {
+		code += "\n\n"
+	}
+	return code + "func() " + body.String() + "()\n", nil
+}
+
+// packageExamples returns the examples of the tests in dir, sorted by name.
+func packageExamples(dir string) ([]packageExample, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, f)
+	}
+	var examples []packageExample
+	for _, e := range doc.Examples(parsed...) {
+		examples = append(examples, packageExample{e, fset})
+	}
+	sort.Slice(examples, func(i, j int) bool { return examples[i].Name < examples[j].Name })
+	return examples, nil
+}
+
+// examplesOf returns the package named by name, imported in the session or given by
+// its import path, and the examples of its tests.
+func (kernel *Kernel) examplesOf(name string) (string, []packageExample, error) {
+	pkg, symbol := kernel.packageSymbol([]string{name})
+	if symbol != "" {
+		// Not the name of a package imported in the session.
+		pkg = name
+	}
+	src, ok := kernel.packageSource(pkg, "")
+	if !ok {
+		return pkg, nil, fmt.Errorf("no sources for package %s", pkg)
+	}
+	if err := sandboxedSource(src); err != nil {
+		return pkg, nil, err
+	}
+	examples, err := packageExamples(src.Dir)
+	if err != nil {
+		return pkg, nil, fmt.Errorf("could not read the tests of %s: %v", pkg, err)
+	}
+	return pkg, examples, nil
+}
+
+// evalExamplesMagic implements `%examples package`, which lists the examples of the
+// tests of a package.
+func evalExamplesMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := examplesSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	pkg, examples, err := kernel.examplesOf(parsed.arg(0))
+	if err != nil {
+		return err
+	}
+	if len(examples) == 0 {
+		fmt.Fprintf(outerr.out, "The tests of %s have no examples.\n", pkg)
+		return nil
+	}
+	rows := make([][]string, len(examples))
+	for i, e := range examples {
+		runnable := "yes"
+		if e.Play == nil {
+			runnable = "no"
+		}
+		rows[i] = []string{e.exampleName(), e.shows(pkg), runnable, doc.Synopsis(e.Doc)}
+	}
+	kernel.display(outerr, k8sTable([]string{"Example", "Shows", "Runnable", "Description"}, rows, -1, nil))
+	return nil
+}
+
+// evalRunExampleMagic implements `%runexample package.Example`, which shows the source
+// of an example and runs it in the session.
+func evalRunExampleMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := runExampleSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	arg := parsed.arg(0)
+	i := strings.LastIndexByte(arg, '.')
+	if i <= 0 || !strings.HasPrefix(arg[i+1:], "Example") {
+		return runExampleSyntax.errorf("expected package.Example, got %q", arg)
+	}
+	pkg, examples, err := kernel.examplesOf(arg[:i])
+	if err != nil {
+		return err
+	}
+	var example *packageExample
+	for j := range examples {
+		if examples[j].exampleName() == arg[i+1:] {
+			example = &examples[j]
+		}
+	}
+	if example == nil {
+		return fmt.Errorf("no example %s in the tests of %s; %%examples %s lists them", arg[i+1:], pkg, arg[:i])
+	}
+	code, err := example.cell()
+	if err != nil {
+		return err
+	}
+	kernel.display(outerr, MakeData3(MIMETypeMarkdown, code, "```go\n"+code+"```\n"))
+
+	var runErr error
+	stdout, stderr, err := captureOutput(func(outerr OutErr) {
+		_, runErr = kernel.evalCell(outerr, code, languageGo)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(outerr.out, stdout)
+	fmt.Fprint(outerr.err, stderr)
+	if runErr != nil {
+		return runErr
+	}
+	if example.Output != "" || example.EmptyOutput {
+		if !sameExampleOutput(stdout, example.Output, example.Unordered) {
+			fmt.Fprintf(outerr.err, "The output differs from the output %s expects:\n%s", example.exampleName(), example.Output)
+		}
+	}
+	return nil
+}
+
+// sameExampleOutput reports whether got is the output want that an example expects,
+// like go test compares them: ignoring the spaces around them, and the order of their
+// lines if unordered.
+func sameExampleOutput(got, want string, unordered bool) bool {
+	got, want = strings.TrimSpace(got), strings.TrimSpace(want)
+	if unordered {
+		gotLines, wantLines := strings.Split(got, "\n"), strings.Split(want, "\n")
+		sort.Strings(gotLines)
+		sort.Strings(wantLines)
+		got, want = strings.Join(gotLines, "\n"), strings.Join(wantLines, "\n")
+	}
+	return got == want
+}
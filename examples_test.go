@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// exampleTests are the tests of a package with examples, some of which can run
+// outside of the package.
+const exampleTests = `package greet_test
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This example greets the world.
+func ExampleHello() {
+	fmt.Println(strings.ToUpper("hello"))
+	// Output: HELLO
+}
+
+func ExampleGreeter_Greet_twice() {
+	fmt.Println(shout("hi"))
+	fmt.Println(shout("hi"))
+	// Unordered output:
+	// HI!
+	// HI!
+}
+
+func shout(s string) string { return strings.ToUpper(s) + "!" }
+`
+
+// TestExamples tests listing the examples of the tests of a package and running them.
+func TestExamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-examples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "greet_test.go"), []byte(exampleTests), 0644); err != nil {
+		t.Fatal(err)
+	}
+	internal := "package greet\n\nimport \"fmt\"\n\nfunc Example() {\n\tfmt.Println(secret)\n}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "internal_test.go"), []byte(internal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Should find the examples, the names they show, and the code running them.")
+
+	examples, err := packageExamples(dir)
+	if err != nil || len(examples) != 3 {
+		t.Fatalf("\t%s Expected 3 examples, got %d: %v.", failure, len(examples), err)
+	}
+	cases := []struct {
+		name, shows, cell string
+	}{
+		{"Example", "greet", ""},
+		{"ExampleGreeter_Greet_twice", "greet.Greeter.Greet", "import (\n\t\"fmt\"\n\t\"strings\"\n)\n\nfunc shout(s string) string { return strings.ToUpper(s) + \"!\" }\n\nfunc() {\n\tfmt.Println(shout(\"hi\"))\n\tfmt.Println(shout(\"hi\"))\n}()\n"},
+		{"ExampleHello", "greet.Hello", "import (\n\t\"fmt\"\n\t\"strings\"\n)\n\nfunc() {\n\tfmt.Println(strings.ToUpper(\"hello\"))\n}()\n"},
+	}
+	for i, tc := range cases {
+		e := examples[i]
+		cell, err := e.cell()
+		if e.exampleName() != tc.name || e.shows("greet") != tc.shows || cell != tc.cell || (err == nil) != (tc.cell != "") {
+			t.Fatalf("\t%s Expected %s showing %s with\n%s\ngot %s showing %s with\n%s\n(%v).", failure, tc.name, tc.shows, tc.cell, e.exampleName(), e.shows("greet"), cell, err)
+		}
+		t.Logf("\t%s %s shows %s.", success, tc.name, tc.shows)
+	}
+	if !sameExampleOutput("HI!\nHI!\n", examples[1].Output, examples[1].Unordered) || sameExampleOutput("HELLO!", examples[2].Output, false) {
+		t.Fatalf("\t%s Expected the outputs to be compared like go test does.", failure)
+	}
+
+	t.Logf("Should run the examples of the standard library in the session.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var shown []string
+	kernel.session.display = func(data Data) { shown = append(shown, data.Data[MIMETypeText].(string)) }
+	var out, errOut bytes.Buffer
+	if err := evalRunExampleMagic(&kernel, OutErr{&out, &errOut}, "strings.ExampleToUpper"); err != nil {
+		t.Fatalf("\t%s Could not run strings.ExampleToUpper: %v.", failure, err)
+	}
+	if len(shown) != 1 || !strings.Contains(shown[0], "strings.ToUpper") || out.String() != "GOPHER\n" || errOut.Len() != 0 {
+		t.Fatalf("\t%s Expected the example and its output, got %q, %q and %q.", failure, shown, out.String(), errOut.String())
+	}
+	if err := evalRunExampleMagic(&kernel, OutErr{&out, &errOut}, "strings.ExampleNothing"); err == nil {
+		t.Fatalf("\t%s Expected an error for a missing example.", failure)
+	}
+	t.Logf("\t%s Ran strings.ExampleToUpper.", success)
+}
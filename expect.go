@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"reflect"
+	"strings"
+
+	"github.com/goplus/gop"
+)
+
+// The expect package gives cells assertions for exercises graded from the notebook:
+//
+//	expect.Eq(fib(10), 55)
+//	expect.Panics(func() { divide(1, 0) })
+//
+// A failed expectation does not stop the cell, so every failure is reported. Once the
+// cell is done, the failures are shown as red blocks and the cell ends in error.
+
+// expectPackage is the Go+ package providing the assertions to the cells.
+var expectPackage = gop.NewGoPackage("expect")
+
+func init() {
+	expectPackage.RegisterFuncs(
+		expectPackage.Func("Eq", expectEq, execExpectEq),
+		expectPackage.Func("Panics", expectPanics, execExpectPanics),
+	)
+}
+
+// expectationError is returned by a cell in which expectations failed.
+type expectationError struct {
+	failures []string
+}
+
+func (e *expectationError) Error() string {
+	if len(e.failures) == 1 {
+		return "expectation failed: " + e.failures[0]
+	}
+	return fmt.Sprintf("%d expectations failed:\n%s", len(e.failures), strings.Join(e.failures, "\n"))
+}
+
+// HTML renders the failures as red blocks.
+func (e *expectationError) HTML() string {
+	var b strings.Builder
+	for _, failure := range e.failures {
+		b.WriteString(`<div style="background-color:#fdd;border-left:4px solid #d00;color:#900;` +
+			`font-family:monospace;margin:2px 0;padding:4px 8px;white-space:pre-wrap">`)
+		b.WriteString("✗ " + html.EscapeString(failure))
+		b.WriteString("</div>")
+	}
+	return b.String()
+}
+
+// failExpectation records a failed expectation of the cell being evaluated.
+func failExpectation(format string, args ...interface{}) {
+	activeSession.expectFailures = append(activeSession.expectFailures, fmt.Sprintf(format, args...))
+}
+
+// takeExpectationError returns the expectations that failed since the last call, as an
+// error, or nil if none failed.
+func (s *Session) takeExpectationError() error {
+	if len(s.expectFailures) == 0 {
+		return nil
+	}
+	err := &expectationError{s.expectFailures}
+	s.expectFailures = nil
+	return err
+}
+
+// expectEq expects got and want to be deeply equal, and reports whether they are.
+func expectEq(got, want interface{}) bool {
+	if reflect.DeepEqual(got, want) {
+		return true
+	}
+	if got != nil && want != nil && reflect.TypeOf(got) != reflect.TypeOf(want) {
+		failExpectation("expect.Eq: got %#v (%T), want %#v (%T)", got, got, want, want)
+	} else {
+		failExpectation("expect.Eq: got %#v, want %#v", got, want)
+	}
+	return false
+}
+
+func execExpectEq(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, expectEq(args[0], args[1]))
+}
+
+// expectPanics expects fn to panic, and reports whether it did.
+func expectPanics(fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+		}
+		if !panicked {
+			failExpectation("expect.Panics: the function did not panic")
+		}
+	}()
+	fn()
+	return false
+}
+
+func execExpectPanics(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, expectPanics(args[0].(func())))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestExpect tests that failed expectations make the cell fail with all the failures.
+func TestExpect(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+
+	t.Logf("Should pass a cell whose expectations hold.")
+
+	if _, err := kernel.doEvalGop(outerr, "expect.Eq(1 + 1, 2)\nexpect.Panics(func() { panic(\"boom\") })"); err != nil {
+		t.Fatalf("\t%s Expected the cell to pass, got %v.", failure, err)
+	}
+	t.Logf("\t%s The cell passed.", success)
+
+	t.Logf("Should fail a cell whose expectations do not hold, reporting every failure.")
+
+	_, err := kernel.doEvalGop(outerr, "expect.Eq(1 + 1, 3)\nexpect.Panics(func() {})\nx := 1")
+	expectErr, ok := err.(*expectationError)
+	if !ok {
+		t.Fatalf("\t%s Expected an expectation error, got %v.", failure, err)
+	}
+	if len(expectErr.failures) != 2 {
+		t.Fatalf("\t%s Expected 2 failures, got %q.", failure, expectErr.failures)
+	}
+	if !strings.Contains(expectErr.failures[0], "got 2, want 3") {
+		t.Fatalf("\t%s Unexpected failure %q.", failure, expectErr.failures[0])
+	}
+	if html := expectErr.HTML(); strings.Count(html, "<div") != 2 {
+		t.Fatalf("\t%s Expected 2 blocks in %q.", failure, html)
+	}
+	t.Logf("\t%s The cell failed with %q.", success, expectErr.failures)
+
+	t.Logf("Should not report the failures again in the next cell.")
+
+	if _, err := kernel.doEvalGop(outerr, "expect.Eq(x, 1)"); err != nil {
+		t.Fatalf("\t%s Expected the cell to pass, got %v.", failure, err)
+	}
+	t.Logf("\t%s The cell passed.", success)
+}
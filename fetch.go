@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("Fetch", fetch, execFetch),
+	)
+}
+
+const (
+	// maxFetchBody bounds the size of the bodies read by Fetch and shown for responses.
+	maxFetchBody = 32 << 20
+
+	// maxShownBody bounds the size of the text bodies shown in the output of a cell.
+	maxShownBody = 64 << 10
+)
+
+// HTTPResponse is an HTTP response with its body read, as returned by Fetch. It renders
+// as its status, its headers and its body, shown according to its content type.
+type HTTPResponse struct {
+	URL        string
+	Status     string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Truncated  bool // whether Body was cut at maxFetchBody
+}
+
+// fetch sends a GET request to url and returns the response. Like the other builtins it
+// reports errors by panicking, which fails the cell.
+func fetch(url string) *HTTPResponse {
+	if sandboxed {
+		panic(xerrors.Errorf("Fetch: %w", errSandboxed))
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	r, err := readHTTPResponse(resp)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func execFetch(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, fetch(args[0].(string)))
+}
+
+// readHTTPResponse reads the body of resp.
+func readHTTPResponse(resp *http.Response) (*HTTPResponse, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFetchBody+1))
+	if err != nil {
+		return nil, err
+	}
+	r := &HTTPResponse{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		r.URL = resp.Request.URL.String()
+	}
+	if len(body) > maxFetchBody {
+		r.Body, r.Truncated = body[:maxFetchBody], true
+	}
+	return r, nil
+}
+
+// Text returns the body as a string.
+func (r *HTTPResponse) Text() string {
+	return string(r.Body)
+}
+
+func (r *HTTPResponse) String() string {
+	return fmt.Sprintf("%s %s (%s, %d bytes)", r.Status, r.URL, r.contentType(), len(r.Body))
+}
+
+// contentType returns the media type of the body, without its parameters.
+func (r *HTTPResponse) contentType() string {
+	header := r.Header.Get("Content-Type")
+	if header == "" {
+		header = http.DetectContentType(r.Body)
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return header
+	}
+	return mediaType
+}
+
+// Render renders the response as its status, collapsible headers, and its body: JSON
+// is indented, images are shown, HTML is shown in a sandboxed frame and other text as
+// is.
+func (r *HTTPResponse) Render() Data {
+	var b strings.Builder
+	color := "#080"
+	if r.StatusCode >= 400 {
+		color = "#d00"
+	}
+	fmt.Fprintf(&b, `<div><b style="color:%s">%s</b> <code>%s</code></div>`,
+		color, html.EscapeString(r.Status), html.EscapeString(r.URL))
+
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(&b, "<details><summary>%d headers</summary><table>", len(names))
+	for _, name := range names {
+		for _, value := range r.Header[name] {
+			fmt.Fprintf(&b, `<tr><th style="text-align:left">%s</th><td style="text-align:left">%s</td></tr>`,
+				html.EscapeString(name), html.EscapeString(value))
+		}
+	}
+	b.WriteString("</table></details>")
+
+	b.WriteString(r.bodyHTML())
+	if r.Truncated {
+		fmt.Fprintf(&b, "<small>The body was cut at %s.</small>", formatSize(maxFetchBody))
+	}
+	return MakeData3(MIMETypeHTML, r.String(), b.String())
+}
+
+// bodyHTML renders the body according to its content type.
+func (r *HTTPResponse) bodyHTML() string {
+	if len(r.Body) == 0 {
+		return "<i>empty body</i>"
+	}
+	contentType := r.contentType()
+	switch {
+	case contentType == MIMETypeJSON || strings.HasSuffix(contentType, "+json"):
+		var indented bytes.Buffer
+		if json.Indent(&indented, r.Body, "", "  ") == nil {
+			return preHTML(indented.String())
+		}
+	case strings.HasPrefix(contentType, "image/"):
+		return fmt.Sprintf(`<img src="data:%s;base64,%s">`,
+			html.EscapeString(contentType), base64.StdEncoding.EncodeToString(r.Body))
+	case contentType == MIMETypeHTML:
+		// The page is isolated from the notebook: it cannot run scripts or reach the
+		// notebook's origin.
+		return fmt.Sprintf(`<iframe sandbox srcdoc="%s" style="width:100%%;height:400px;border:1px solid #ddd"></iframe>`,
+			html.EscapeString(string(r.Body)))
+	case !strings.HasPrefix(contentType, "text/") && contentType != "application/xml" &&
+		contentType != MIMETypeJavaScript:
+		return fmt.Sprintf("<i>%s of %s</i>", formatSize(int64(len(r.Body))), html.EscapeString(contentType))
+	}
+	return preHTML(string(r.Body))
+}
+
+// preHTML renders text as preformatted HTML, cut at maxShownBody.
+func preHTML(text string) string {
+	var note string
+	if len(text) > maxShownBody {
+		text = text[:maxShownBody]
+		note = fmt.Sprintf("<small>Only the first %s are shown.</small>", formatSize(maxShownBody))
+	}
+	return "<pre>" + html.EscapeString(text) + "</pre>" + note
+}
+
+// renderHTTPResponse renders an *http.Response like the responses of Fetch. The body is
+// read and replaced with a reader of what was read, so it can still be read after
+// rendering.
+func renderHTTPResponse(resp *http.Response) Data {
+	r, err := readHTTPResponse(resp)
+	if err != nil {
+		return makeDataErr(err)
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(r.Body))
+	return r.Render()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFetch tests fetching and rendering HTTP responses.
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			fmt.Fprint(w, `{"name":"gopyter","tags":["go+"]}`)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<p class="x">hi</p>`)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		path  string
+		shows []string
+	}{
+		{"/json", []string{"200 OK", "<pre>{\n  &#34;name&#34;: &#34;gopyter&#34;,", "<th style=\"text-align:left\">Content-Type</th>"}},
+		{"/page", []string{`<iframe sandbox srcdoc="&lt;p class=&#34;x&#34;&gt;hi&lt;/p&gt;"`}},
+		{"/image", []string{`<img src="data:image/png;base64,iVBORw=="`}},
+		{"/missing", []string{`color:#d00">404 Not Found`, "<pre>404 page not found\n</pre>"}},
+	}
+
+	t.Logf("Should render the responses of Fetch according to their content type.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	for _, tc := range cases {
+		vals, err := kernel.session.Eval(`Fetch("` + server.URL + tc.path + `")`)
+		if err != nil {
+			t.Fatalf("\t%s Fetching %s failed: %v.", failure, tc.path, err)
+		}
+		html, _ := kernel.autoRenderResults(vals).Data[MIMETypeHTML].(string)
+		for _, s := range tc.shows {
+			if !strings.Contains(html, s) {
+				t.Fatalf("\t%s Expected %q in the rendering of %s, got %s.", failure, s, tc.path, html)
+			}
+		}
+		t.Logf("\t%s Rendered %s.", success, tc.path)
+	}
+
+	t.Logf("Should render *http.Response values and keep their body readable.")
+
+	resp, err := http.Get(server.URL + "/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html, _ := autoRender(resp).Data[MIMETypeHTML].(string); !strings.Contains(html, "gopyter") {
+		t.Fatalf("\t%s Expected the body in the rendering, got %s.", failure, html)
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); !strings.Contains(string(body), "gopyter") {
+		t.Fatalf("\t%s Expected the body to remain readable, got %q.", failure, body)
+	}
+	t.Logf("\t%s Rendered the response.", success)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	commTargets[filesCommTarget] = openFilesComm
+}
+
+// filesCommTarget is the comm target through which front-ends and widgets manage the
+// files of the session directory, the working directory of the kernel, such as the
+// artifacts written by cells. Each message {"op": ..., "path": ...} is answered with
+// the same op and path, and the id of the message if it has one:
+//
+//	list   lists the directory at path, answering {"entries": [...]}
+//	read   answers with at most maxReadBytes of the file from "offset", zero if unset,
+//	       as the binary buffer of the reply, and {"size": ..., "offset": ...}, the size
+//	       of the file and the offset of the buffer
+//	write  replaces the file with "data", base64 encoded, or with the binary buffer of
+//	       the message, answering {"size": ...}
+//
+// Paths are relative to the session directory, which they cannot leave. The connection
+// file of the kernel cannot be read or written, nor its audit log written. Failing
+// operations are answered with {"error": ...}.
+const filesCommTarget = "gopyter.files"
+
+// maxReadBytes is the most a read of the files comm answers with. Larger files are read
+// in several.
+const maxReadBytes = 1 << 20
+
+// workspaceEntry describes a file of the session directory.
+type workspaceEntry struct {
+	Name     string    `json:"name"`
+	Dir      bool      `json:"dir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// workspacePath returns the absolute path of name, relative to root, with symbolic
+// links resolved. Paths leaving root are refused.
+func workspacePath(root, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("%s is not relative to the session directory", name)
+	}
+	root, err := resolvePath(root)
+	if err != nil {
+		return "", err
+	}
+	path, err := resolvePath(filepath.Join(root, name))
+	if err != nil {
+		return "", err
+	}
+	if path != root && !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the session directory", name)
+	}
+	if sandboxed && !inSandbox(path) {
+		return "", &os.PathError{Op: "open", Path: path, Err: errSandboxed}
+	}
+	return path, nil
+}
+
+// listWorkspace lists the directory name of root.
+func listWorkspace(root, name string) ([]workspaceEntry, error) {
+	path, err := workspacePath(root, name)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]workspaceEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, workspaceEntry{info.Name(), info.IsDir(), info.Size(), info.ModTime()})
+	}
+	return entries, nil
+}
+
+// isKernelFile reports whether path is one of the files of the kernel, its connection
+// file or its audit log, which front-ends must not replace.
+func (kernel *Kernel) isKernelFile(path string) bool {
+	return isConnectionFile(path) || kernel.isAuditLog(path)
+}
+
+// readWorkspaceFile returns at most maxReadBytes of the file name of root from offset,
+// and the size of the file. The connection file, which holds the key of the kernel,
+// cannot be read.
+func readWorkspaceFile(root, name string, offset int64) ([]byte, int64, error) {
+	path, err := workspacePath(root, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if isConnectionFile(path) {
+		return nil, 0, &os.PathError{Op: "read", Path: path, Err: os.ErrPermission}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.IsDir() {
+		return nil, 0, fmt.Errorf("%s is a directory", name)
+	}
+	if offset < 0 || offset > info.Size() {
+		return nil, 0, fmt.Errorf("offset %d is outside of the %d bytes of %s", offset, info.Size(), name)
+	}
+	n := info.Size() - offset
+	if n > maxReadBytes {
+		n = maxReadBytes
+	}
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	return data, info.Size(), nil
+}
+
+// writeWorkspaceFile replaces the file name of root with data, creating its directory
+// if needed. Readers never see a partially written file. The files of the kernel
+// cannot be written.
+func writeWorkspaceFile(kernel *Kernel, root, name string, data []byte) error {
+	path, err := workspacePath(root, name)
+	if err != nil {
+		return err
+	}
+	if kernel.isKernelFile(path) {
+		return &os.PathError{Op: "write", Path: path, Err: os.ErrPermission}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.write")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// openFilesComm sets up a comm operating on the files of the session directory.
+func openFilesComm(kernel *Kernel, receipt msgReceipt, c *comm, data map[string]interface{}) error {
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	c.onMsg = func(receipt msgReceipt, data map[string]interface{}) {
+		op, _ := data["op"].(string)
+		name, _ := data["path"].(string)
+		if name == "" {
+			name = "."
+		}
+
+		reply := map[string]interface{}{"op": op, "path": name}
+		if id, ok := data["id"]; ok {
+			reply["id"] = id
+		}
+		var buffers [][]byte
+		err := func() error {
+			switch op {
+			case "list":
+				entries, err := listWorkspace(root, name)
+				reply["entries"] = entries
+				return err
+			case "read":
+				offset, _ := data["offset"].(float64)
+				content, size, err := readWorkspaceFile(root, name, int64(offset))
+				if err != nil {
+					return err
+				}
+				reply["size"] = size
+				reply["offset"] = int64(offset)
+				buffers = [][]byte{content}
+				return nil
+			case "write":
+				var content []byte
+				if chunk, ok := data["data"].(string); ok {
+					var err error
+					if content, err = base64.StdEncoding.DecodeString(chunk); err != nil {
+						return fmt.Errorf("invalid data: %v", err)
+					}
+				} else if len(receipt.Msg.Buffers) > 0 {
+					content = receipt.Msg.Buffers[0]
+				}
+				reply["size"] = len(content)
+				return writeWorkspaceFile(kernel, root, name, content)
+			default:
+				return fmt.Errorf("unknown op %q, expected list, read or write", op)
+			}
+		}()
+		if err != nil {
+			delete(reply, "entries")
+			delete(reply, "size")
+			delete(reply, "offset")
+			reply["error"] = err.Error()
+			buffers = nil
+		}
+		if err := c.sendBuffers(receipt, reply, buffers); err != nil {
+			log.Printf("Error sending files reply: %v\n", err)
+		}
+	}
+	return nil
+}
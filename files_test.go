@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWorkspaceFiles tests listing, reading and writing the files of the session
+// directory.
+func TestWorkspaceFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "session")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	kernel := Kernel{NewSession(), defaultConfig()}
+	kernel.config.AuditLog = filepath.Join(root, "audit.log")
+
+	t.Logf("Should write files, creating their directories, and read them back.")
+
+	if err := writeWorkspaceFile(&kernel, root, "out/plot.svg", []byte("<svg/>")); err != nil {
+		t.Fatalf("\t%s Could not write the file: %v.", failure, err)
+	}
+	data, size, err := readWorkspaceFile(root, "out/plot.svg", 0)
+	if err != nil || string(data) != "<svg/>" || size != 6 {
+		t.Fatalf("\t%s Expected the file written, got %q (%d bytes): %v.", failure, data, size, err)
+	}
+	entries, err := listWorkspace(root, "out")
+	if err != nil || len(entries) != 1 || entries[0].Name != "plot.svg" || entries[0].Size != 6 || entries[0].Dir {
+		t.Fatalf("\t%s Expected only plot.svg, got %+v: %v.", failure, entries, err)
+	}
+	entries, err = listWorkspace(root, ".")
+	if err != nil || len(entries) != 2 || entries[1].Name != "out" || !entries[1].Dir {
+		t.Fatalf("\t%s Expected escape and out, got %+v: %v.", failure, entries, err)
+	}
+	t.Logf("\t%s Wrote, read and listed them.", success)
+
+	t.Logf("Should refuse the paths outside of the session directory.")
+
+	for _, name := range []string{"..", "../x", "out/../../x", "/etc/passwd", "escape/x", "escape"} {
+		if _, err := workspacePath(root, name); err == nil {
+			t.Fatalf("\t%s Expected %q to be refused.", failure, name)
+		}
+	}
+	if err := writeWorkspaceFile(&kernel, root, "../x", nil); err == nil {
+		t.Fatalf("\t%s Expected writing outside of the session directory to fail.", failure)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x")); !os.IsNotExist(err) {
+		t.Fatalf("\t%s Expected no file outside of the session directory.", failure)
+	}
+	t.Logf("\t%s Refused them.", success)
+
+	t.Logf("Should read large files in chunks.")
+
+	big := make([]byte, maxReadBytes+10)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	if err := writeWorkspaceFile(&kernel, root, "big.bin", big); err != nil {
+		t.Fatalf("\t%s Could not write the file: %v.", failure, err)
+	}
+	var read []byte
+	for len(read) < len(big) {
+		data, size, err := readWorkspaceFile(root, "big.bin", int64(len(read)))
+		if err != nil || size != int64(len(big)) || len(data) == 0 || len(data) > maxReadBytes {
+			t.Fatalf("\t%s Expected a chunk of at most %d bytes, got %d (%d bytes): %v.", failure, maxReadBytes, len(data), size, err)
+		}
+		read = append(read, data...)
+	}
+	if string(read) != string(big) {
+		t.Fatalf("\t%s Expected the chunks to make up the file.", failure)
+	}
+	if _, _, err := readWorkspaceFile(root, "big.bin", int64(len(big)+1)); err == nil {
+		t.Fatalf("\t%s Expected an offset beyond the file to fail.", failure)
+	}
+	t.Logf("\t%s Read it in chunks.", success)
+
+	t.Logf("Should not expose the files of the kernel.")
+
+	defer func(path string) { connectionFilePath = path }(connectionFilePath)
+	if err := ioutil.WriteFile(filepath.Join(root, "kernel.json"), []byte(`{"key": "k"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if connectionFilePath, err = resolvePath(filepath.Join(root, "kernel.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readWorkspaceFile(root, "kernel.json", 0); !os.IsPermission(err) {
+		t.Fatalf("\t%s Expected reading the connection file to be refused, got %v.", failure, err)
+	}
+	for _, name := range []string{"kernel.json", "audit.log"} {
+		if err := writeWorkspaceFile(&kernel, root, name, nil); !os.IsPermission(err) {
+			t.Fatalf("\t%s Expected writing %s to be refused, got %v.", failure, name, err)
+		}
+	}
+	t.Logf("\t%s Refused them.", success)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop/format"
+)
+
+func init() {
+	lineMagics["fmt"] = evalFmtMagic
+	documentMagic(fmtSyntax)
+	commTargets[formatCommTarget] = openFormatComm
+}
+
+var fmtSyntax = &magicSyntax{
+	name:  "%fmt",
+	usage: []string{"[n]"},
+	doc:   "Formats the source of the previous cell, or of `In[n]`, and offers it as the new content of the current cell.",
+	args:  []magicParam{{name: "n", help: "the number of the cell to format", optional: true}},
+}
+
+// formatCommTarget is the comm target through which front-ends ask the kernel to format
+// the source of cells. Each message {"code": ...} is answered with {"code": ...} holding
+// the formatted code, or {"error": ...} if the code does not parse.
+const formatCommTarget = "gopyter.format"
+
+// formatCell formats the source of a cell like gofmt. Magics and shell commands are
+// kept as they are.
+func formatCell(code string) (string, error) {
+	var header strings.Builder
+	if name, args, body, ok := splitCellMagic(code); ok {
+		header.WriteString(strings.TrimSpace("%%"+name+" "+args) + "\n")
+		code = body
+	}
+
+	// Keep the shell commands and line magics at the top of the cell.
+	lines := strings.SplitAfter(code, "\n")
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line != "" && line[0] != '$' && line[0] != '%' {
+			break
+		}
+		if line != "" {
+			header.WriteString(line + "\n")
+		}
+	}
+	code = strings.Join(lines[i:], "")
+
+	// Format the imports and the statements separately: the formatter expects the
+	// imports of a partial file to be followed by declarations.
+	imports, rest := splitImports(code)
+	var parts []string
+	for _, part := range []string{imports, rest} {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		formatted, err := format.Source([]byte(strings.TrimSpace(part)))
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, string(bytes.TrimSpace(formatted)))
+	}
+	return strings.TrimRight(header.String()+strings.Join(parts, "\n\n"), "\n"), nil
+}
+
+// evalFmtMagic implements `%fmt [n]`, which formats the source of the previous cell, or
+// of In[n], and offers it as the new content of the current cell.
+func evalFmtMagic(kernel *Kernel, outerr OutErr, args string) error {
+	in := kernel.session.history.In
+	count := 0
+	parsed, err := fmtSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	if arg := parsed.arg(0); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmtSyntax.errorf("expected a cell number, got %q", arg)
+		}
+		count = n
+	} else {
+		counts := make([]int, 0, len(in))
+		for n := range in {
+			counts = append(counts, n)
+		}
+		sort.Ints(counts)
+		if len(counts) == 0 {
+			return fmt.Errorf("%%fmt: no cell to format")
+		}
+		count = counts[len(counts)-1]
+	}
+
+	code, ok := in[count]
+	if !ok {
+		return fmt.Errorf("%%fmt: no cell In[%d]", count)
+	}
+	formatted, err := formatCell(code)
+	if err != nil {
+		return fmt.Errorf("%%fmt: %v", err)
+	}
+	kernel.session.setNextInput(formatted, true)
+	return nil
+}
+
+// openFormatComm sets up a comm formatting the code sent on it.
+func openFormatComm(kernel *Kernel, receipt msgReceipt, c *comm, data map[string]interface{}) error {
+	c.onMsg = func(receipt msgReceipt, data map[string]interface{}) {
+		code, _ := data["code"].(string)
+		reply := map[string]interface{}{}
+		if formatted, err := formatCell(code); err != nil {
+			reply["error"] = err.Error()
+		} else {
+			reply["code"] = formatted
+		}
+		if err := c.send(receipt, reply); err != nil {
+			log.Printf("Error sending formatted code: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// handleFormatRequest answers a format_request, the message proposed to let Jupyter
+// front-ends format cells with the kernel, with a format_reply.
+func handleFormatRequest(receipt msgReceipt) error {
+	content := receipt.Msg.Content.(map[string]interface{})
+	code, _ := content["code"].(string)
+
+	formatted, err := formatCell(code)
+	if err != nil {
+		return receipt.Reply("format_reply", map[string]interface{}{
+			"status": "error",
+			"ename":  "SyntaxError",
+			"evalue": err.Error(),
+		})
+	}
+	return receipt.Reply("format_reply", map[string]interface{}{
+		"status": "ok",
+		"code":   formatted,
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// TestFormatCell tests formatting the source of cells.
+func TestFormatCell(t *testing.T) {
+	cases := []struct {
+		code string
+		want string
+	}{
+		{"x:=1\ny  :=  x+2", "x := 1\ny := x + 2"},
+		{"import \"fmt\"\nfmt.Println( 1 )", "import \"fmt\"\n\nfmt.Println(1)"},
+		{"for i:=0;i<3;i++{\nprintln(i)\n}", "for i := 0; i < 3; i++ {\n\tprintln(i)\n}"},
+		{"ys := [a*2 for a <- xs]", "ys := [a*2 for a <- xs]"},
+		{"%checkpoint start\n$ ls\nx:=1", "%checkpoint start\n$ ls\nx := 1"},
+		{"%%cache key=k\nx:=1", "%%cache key=k\nx := 1"},
+	}
+
+	t.Logf("Should format cells like gofmt, keeping magics.")
+
+	for _, tc := range cases {
+		got, err := formatCell(tc.code)
+		if err != nil {
+			t.Errorf("\t%s Formatting %q failed: %v.", failure, tc.code, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("\t%s Formatting %q: expected %q, got %q.", failure, tc.code, tc.want, got)
+			continue
+		}
+		t.Logf("\t%s %q", success, got)
+	}
+
+	if _, err := formatCell("x := ("); err == nil {
+		t.Errorf("\t%s Expected an error for invalid code.", failure)
+	}
+}
+
+// TestFmtMagic tests that %fmt offers the formatted previous cell.
+func TestFmtMagic(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+	kernel.session.history.record(1, "x:=1", nil)
+
+	t.Logf("Should replace the cell with the formatted previous cell.")
+
+	if _, err := kernel.doEvalGop(outerr, "%fmt"); err != nil {
+		t.Fatalf("\t%s %%fmt failed: %v.", failure, err)
+	}
+	payloads := kernel.session.takePayloads()
+	if len(payloads) != 1 {
+		t.Fatalf("\t%s Expected a payload, got %v.", failure, payloads)
+	}
+	payload := payloads[0].(map[string]interface{})
+	if payload["source"] != "set_next_input" || payload["text"] != "x := 1" || payload["replace"] != true {
+		t.Fatalf("\t%s Unexpected payload %v.", failure, payload)
+	}
+	t.Logf("\t%s Got %v.", success, payload)
+}
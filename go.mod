@@ -6,5 +6,6 @@ require (
 	github.com/go-zeromq/zmq4 v0.9.0
 	github.com/gofrs/uuid v3.3.0+incompatible
 	github.com/goplus/gop v0.7.17
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
 	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543
 )
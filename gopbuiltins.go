@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/lib/builtin"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/scanner"
+	"github.com/goplus/gop/token"
+)
+
+// The builtins and the syntax of later versions of Go+ that tutorials use, added to the
+// interpreter so their code runs unchanged.
+func init() {
+	builtin.I.RegisterFuncvs(
+		builtin.I.Funcv("echo", fmt.Println, execEcho),
+	)
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("newRange", newRange, execNewRange),
+		builtin.I.Func("bigint", newBigInt, execBigInt),
+		builtin.I.Func("bigrat", newBigRat, execBigRat),
+	)
+}
+
+// execEcho implements echo, which is println under another name.
+func execEcho(arity int, p *gop.Context) {
+	args := p.GetArgs(arity)
+	n, err := fmt.Println(args...)
+	p.Ret(arity, n, err)
+}
+
+// newRange returns the integers from start up to end, excluded, by step, like the
+// range expression start:end:step of Go+.
+func newRange(start, end, step int) []int {
+	if step == 0 {
+		panic(fmt.Errorf("newRange: step must not be 0"))
+	}
+	r := []int{}
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		r = append(r, i)
+	}
+	return r
+}
+
+func execNewRange(_ int, p *gop.Context) {
+	args := p.GetArgs(3)
+	p.Ret(3, newRange(args[0].(int), args[1].(int), args[2].(int)))
+}
+
+// newBigInt converts an integer, an integral float, a string in the syntax of Go
+// integer literals, a *big.Int or a *big.Rat of an integer to a *big.Int.
+func newBigInt(x interface{}) *big.Int {
+	r := toBigRat("bigint", x)
+	if !r.IsInt() {
+		panic(fmt.Errorf("bigint: %v is not an integer", x))
+	}
+	return new(big.Int).Set(r.Num())
+}
+
+func execBigInt(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, newBigInt(args[0]))
+}
+
+// newBigRat returns the fraction a/b of numbers or strings, like "1/3" or "0.25", as a
+// *big.Rat.
+func newBigRat(a, b interface{}) *big.Rat {
+	denom := toBigRat("bigrat", b)
+	if denom.Sign() == 0 {
+		panic(fmt.Errorf("bigrat: division by zero"))
+	}
+	return new(big.Rat).Quo(toBigRat("bigrat", a), denom)
+}
+
+func execBigRat(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, newBigRat(args[0], args[1]))
+}
+
+// toBigRat converts a number, or a string holding one, to a *big.Rat.
+func toBigRat(fn string, x interface{}) *big.Rat {
+	switch x := x.(type) {
+	case *big.Int:
+		return new(big.Rat).SetInt(x)
+	case *big.Rat:
+		return new(big.Rat).Set(x)
+	case string:
+		if i, ok := new(big.Int).SetString(strings.Replace(x, "_", "", -1), 0); ok {
+			return new(big.Rat).SetInt(i)
+		}
+		if r, ok := new(big.Rat).SetString(x); ok {
+			return r
+		}
+		panic(fmt.Errorf("%s: invalid number %q", fn, x))
+	}
+
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return new(big.Rat).SetFloat64(f)
+		}
+	}
+	panic(fmt.Errorf("%s: cannot convert %v (%T) to a number", fn, x, x))
+}
+
+// rewriteGopSyntax rewrites the syntax of later versions of Go+ in code into the
+// syntax this version supports:
+//
+//   - big integer literals, `120bi`, into calls of bigint,
+//   - the comprehensions and for loops of later versions, see rewriteComprehensions,
+//   - calls in the command form, `println "hello", name`, into regular calls,
+//   - `expr?` in the code of the cell itself, outside of functions, into `expr!`.
+//
+// The lines of code are kept, so positions in the rewritten code are valid in code.
+func rewriteGopSyntax(code string) string {
+	code, _ = rewriteBigIntLiterals(code)
+	code = rewriteComprehensions(code)
+	code, _ = rewriteCommandCalls(code)
+	return rewriteCellErrReturns(code)
+}
+
+// rewriteBigIntLiterals replaces the big integer literals in code, integer literals
+// followed by `bi`, with calls of bigint. It returns the offsets of the literals.
+func rewriteBigIntLiterals(code string) (string, []int) {
+	if !strings.Contains(code, "bi") {
+		return code, nil
+	}
+
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(code))
+	s.Init(file, []byte(code), nil, 0)
+
+	var b strings.Builder
+	var offsets []int
+	last, literal, end := 0, "", -1
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		offset := file.Offset(pos)
+		if tok == token.IDENT && lit == "bi" && offset == end {
+			start := end - len(literal)
+			b.WriteString(code[last:start])
+			fmt.Fprintf(&b, "bigint(%q)", literal)
+			last = offset + len(lit)
+			offsets = append(offsets, start)
+		}
+		literal, end = "", -1
+		if tok == token.INT {
+			literal, end = lit, offset+len(lit)
+		}
+	}
+	if offsets == nil {
+		return code, nil
+	}
+	b.WriteString(code[last:])
+	return b.String(), offsets
+}
+
+// commandCall matches a line calling a function, possibly of a package, in the command
+// form of Go+: its name followed by its arguments without parentheses.
+var commandCall = regexp.MustCompile(`^(\s*)([\pL_][\pL\pN_]*(?:\.[\pL_][\pL\pN_]*)?)[ \t]+([^\s=:+\-*/%&|^<>!.,;)\]}].*)$`)
+
+// rewriteCommandCalls rewrites the calls in the command form in code, which the parser
+// of this version of Go+ does not support, into regular calls. Only the lines the
+// parser fails at are rewritten, so code that parses is left unchanged, as is code
+// whose errors are not calls in the command form. It returns the numbers of the lines
+// rewritten, starting at 1.
+func rewriteCommandCalls(code string) (string, []int) {
+	lines := strings.Split(code, "\n")
+	var rewritten []int
+	for i := 0; i <= len(lines); i++ {
+		diagnostics, ok := parseDiagnostics(strings.Join(lines, "\n"))
+		if ok {
+			return strings.Join(lines, "\n"), rewritten
+		}
+		n := diagnostics[0].Line
+		if n == 0 || len(rewritten) != 0 && rewritten[len(rewritten)-1] == n {
+			break
+		}
+		call, ok := commandCallLine(lines[n-1])
+		if !ok {
+			break
+		}
+		lines[n-1] = call
+		rewritten = append(rewritten, n)
+	}
+	return code, nil
+}
+
+// commandCallLine rewrites line, if it is a call in the command form, into a regular
+// call, keeping a trailing comment.
+func commandCallLine(line string) (string, bool) {
+	m := commandCall.FindStringSubmatch(line)
+	if m == nil || token.Lookup(strings.SplitN(m[2], ".", 2)[0]).IsKeyword() {
+		return "", false
+	}
+	args, comment := m[3], ""
+
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(args))
+	s.Init(file, []byte(args), nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.COMMENT {
+			args, comment = strings.TrimRight(args[:file.Offset(pos)], " \t"), " "+lit
+			break
+		}
+	}
+	return m[1] + m[2] + "(" + strings.TrimRight(args, " \t;") + ")" + comment, true
+}
+
+// rewriteCellErrReturns rewrites the uses of `expr?` in the code of a cell outside of
+// functions into `expr!`. The code of a cell runs in a function without results, so
+// `expr?` cannot return its error there and fails to compile; the nearest meaning is
+// to stop the cell with the error, which is what `expr!` does. `expr?` in functions
+// returning an error, and `expr?:default`, are left to the compiler.
+func rewriteCellErrReturns(code string) string {
+	if !strings.Contains(code, "?") {
+		return code
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", code, 0)
+	if err != nil {
+		return code
+	}
+
+	var offsets []int
+	inspectAST(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			return f.NoEntrypoint && n.Recv == nil && n.Name.Name == "main"
+		case *ast.FuncLit:
+			return false
+		case *ast.ErrWrapExpr:
+			if n.Tok == token.QUESTION && n.Default == nil {
+				offsets = append(offsets, cellOffset(fset, f, n.TokPos))
+			}
+		}
+		return true
+	})
+
+	b := []byte(code)
+	for _, offset := range offsets {
+		if offset >= 0 && offset < len(b) && b[offset] == '?' {
+			b[offset] = '!'
+		}
+	}
+	return string(b)
+}
+
+// sourceToken is a token of code, with its offsets and line.
+type sourceToken struct {
+	tok        token.Token
+	start, end int
+	line       int
+}
+
+// scanTokens returns the tokens of code, including the semicolons inserted at the end
+// of lines, which are empty.
+func scanTokens(code string) []sourceToken {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(code))
+	s.Init(file, []byte(code), nil, 0)
+
+	var tokens []sourceToken
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return tokens
+		}
+		t := sourceToken{tok: tok, start: file.Offset(pos), line: file.Line(pos)}
+		switch {
+		case tok == token.SEMICOLON && lit != ";":
+			t.end = t.start
+		case lit != "":
+			t.end = t.start + len(lit)
+		default:
+			t.end = t.start + len(tok.String())
+		}
+		tokens = append(tokens, t)
+	}
+}
+
+// rewriteComprehensions rewrites the comprehensions and for loops of later versions of
+// Go+ into the syntax of this version:
+//
+//   - ranges, `for x <- 1:10:2`, into calls of newRange,
+//   - conditions introduced by if, `[x for x <- xs if x > 0]`, into `, x > 0`,
+//   - map comprehensions starting a statement, which are parsed as blocks, into
+//     parenthesized expressions.
+func rewriteComprehensions(code string) string {
+	if !strings.Contains(code, "<-") {
+		return code
+	}
+	code = rewriteRangeExprs(code)
+	code = rewriteComprehensionConditions(code)
+	return rewriteMapComprehensionStmts(code)
+}
+
+// rewriteRangeExprs rewrites the ranges `start:end` and `start:end:step` following the
+// `<-` of for loops and comprehensions into calls of newRange. The start may be left
+// out, as in `:10`, and defaults to 0.
+func rewriteRangeExprs(code string) string {
+	tokens := scanTokens(code)
+	var b strings.Builder
+	last := 0
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i].tok != token.ARROW || tokens[i-1].tok != token.IDENT {
+			continue
+		}
+		end, colons := rangeExprEnd(tokens, i)
+		if len(colons) == 0 || len(colons) > 2 {
+			continue
+		}
+
+		bounds := append(append([]int{i + 1}, colons...), end)
+		var parts []string
+		for k := 0; k+1 < len(bounds); k++ {
+			from, to := bounds[k], bounds[k+1]
+			if k > 0 {
+				from++ // the colon
+			}
+			if from >= to {
+				parts = append(parts, "")
+				continue
+			}
+			parts = append(parts, code[tokens[from].start:tokens[to-1].end])
+		}
+		if parts[0] == "" {
+			parts[0] = "0"
+		}
+		if len(parts) == 2 {
+			parts = append(parts, "1")
+		}
+		if parts[1] == "" || parts[2] == "" {
+			continue
+		}
+
+		b.WriteString(code[last:tokens[i+1].start])
+		fmt.Fprintf(&b, "newRange(%s, %s, %s)", parts[0], parts[1], parts[2])
+		last = tokens[end-1].end
+		i = end - 1
+	}
+	if last == 0 {
+		return code
+	}
+	b.WriteString(code[last:])
+	return b.String()
+}
+
+// rangeExprEnd returns the index of the token ending the expression following the
+// `<-` at tokens[arrow], on the same line, and the indexes of the colons at its top
+// level.
+func rangeExprEnd(tokens []sourceToken, arrow int) (end int, colons []int) {
+	depth := 0
+	for end = arrow + 1; end < len(tokens); end++ {
+		t := tokens[end]
+		if t.line != tokens[arrow].line {
+			return end, colons
+		}
+		switch t.tok {
+		case token.LPAREN, token.LBRACK:
+			depth++
+		case token.LBRACE:
+			if depth == 0 {
+				return end, colons
+			}
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if depth == 0 {
+				return end, colons
+			}
+			depth--
+		case token.COMMA, token.SEMICOLON, token.IF, token.FOR:
+			if depth == 0 {
+				return end, colons
+			}
+		case token.COLON:
+			if depth == 0 {
+				colons = append(colons, end)
+			}
+		}
+	}
+	return end, colons
+}
+
+// rewriteComprehensionConditions rewrites the `if` introducing the condition of a
+// comprehension into the comma of this version, keeping the offsets of the code.
+func rewriteComprehensionConditions(code string) string {
+	tokens := scanTokens(code)
+	b := []byte(code)
+	changed := false
+	var comprehensions []bool // for each open bracket, whether it holds a comprehension
+	for i, t := range tokens {
+		n := len(comprehensions)
+		switch t.tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			comprehensions = append(comprehensions, false)
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if n > 0 {
+				comprehensions = comprehensions[:n-1]
+			}
+		case token.SEMICOLON:
+			if n > 0 {
+				comprehensions[n-1] = false
+			}
+		case token.FOR:
+			if n > 0 && isForPhrase(tokens, i) {
+				comprehensions[n-1] = true
+			}
+		case token.IF:
+			if n > 0 && comprehensions[n-1] {
+				b[t.start], b[t.start+1] = ',', ' '
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return code
+	}
+	return string(b)
+}
+
+// isForPhrase reports whether the `for` at tokens[i] starts a for phrase, `for x <-`
+// or `for k, v <-`.
+func isForPhrase(tokens []sourceToken, i int) bool {
+	for _, n := range []int{2, 4} {
+		if i+n < len(tokens) && tokens[i+n].tok == token.ARROW {
+			ok := true
+			for k := 1; k < n; k++ {
+				want := token.IDENT
+				if k%2 == 0 {
+					want = token.COMMA
+				}
+				ok = ok && tokens[i+k].tok == want
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rewriteMapComprehensionStmts puts in parentheses the map comprehensions starting a
+// statement, like `{v: k for k, v <- m}` at the end of a cell, which the parser takes
+// for blocks. Code that parses is left unchanged.
+func rewriteMapComprehensionStmts(code string) string {
+	if _, ok := parseDiagnostics(code); ok {
+		return code
+	}
+	tokens := scanTokens(code)
+	var b strings.Builder
+	last, depth := 0, 0
+	for i, t := range tokens {
+		switch t.tok {
+		case token.LPAREN, token.LBRACK:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.LBRACE:
+			if depth == 0 && (i == 0 || tokens[i-1].tok == token.SEMICOLON) {
+				if end, ok := mapComprehensionEnd(tokens, i); ok {
+					b.WriteString(code[last:t.start] + "(" + code[t.start:tokens[end].end] + ")")
+					last = tokens[end].end
+				}
+			}
+			depth++
+		}
+	}
+	if last == 0 {
+		return code
+	}
+	b.WriteString(code[last:])
+	return b.String()
+}
+
+// mapComprehensionEnd returns the index of the closing brace of the map comprehension
+// opened by tokens[lbrace]: a key and a value followed by a for phrase.
+func mapComprehensionEnd(tokens []sourceToken, lbrace int) (int, bool) {
+	depth, colon, phrase := 0, false, false
+	for i := lbrace + 1; i < len(tokens); i++ {
+		switch tokens[i].tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK:
+			depth--
+		case token.RBRACE:
+			if depth == 0 {
+				return i, phrase
+			}
+			depth--
+		case token.COLON:
+			colon = colon || depth == 0
+		case token.SEMICOLON:
+			if depth == 0 {
+				return 0, false
+			}
+		case token.FOR:
+			if depth == 0 {
+				if !colon || !isForPhrase(tokens, i) {
+					return 0, false
+				}
+				phrase = true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// TestGopBuiltins tests the builtins and the syntax of later versions of Go+.
+func TestGopBuiltins(t *testing.T) {
+	cases := []struct {
+		code   string
+		result string
+	}{
+		{"z := 120bi * 2bi\nz", "240"},
+		{`bigint("1_000") + bigint(5)`, "1005"},
+		{"bigrat(1, 3) + 1/3r", "2/3"},
+		{`bigrat("0.25", 1)`, "1/4"},
+		{"newRange(5, 0, -2)", "[5 3 1]"},
+		{"n := 0\nfor i <- newRange(0, 4, 1) {\n\tn += i\n}\nn", "6"},
+		{"echo \"x\", 1 // echoed\n", "4 <nil>"},
+		{"type P struct {\n\tname string\n}\nn := 1\nif n > 0 {\n\tprintln \"positive\", P{\"x\"}\n}", "13 <nil>"},
+		{"[x * x for x <- 1:5]", "[1 4 9 16]"},
+		{"[x for x <- :10:3 if x > 0]", "[3 6 9]"},
+		{"n, sum := 2, 0\nfor i <- 0:n*2 {\n\tsum += i\n}\nsum", "6"},
+		{"ids := {\"a\": 1}\n{id: name for name, id <- ids}", "map[1:a]"},
+		{"import \"strconv\"\nn := strconv.Atoi(\"42\")?\nn", "42"},
+		{"import \"strconv\"\nstrconv.Atoi(\"x\")?:7", "7"},
+		{"import \"strconv\"\ndouble := func(s string) (int, error) {\n\tn := strconv.Atoi(s)?\n\treturn n * 2, nil\n}\ndouble(\"x\")?:-1", "-1"},
+	}
+
+	t.Logf("Should evaluate the code of later versions of Go+.")
+
+	for _, tc := range cases {
+		kernel := Kernel{NewSession(), defaultConfig()}
+		vals, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, tc.code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %q failed: %v.", failure, tc.code, err)
+		}
+		if result := fmt.Sprint(vals...); result != tc.result {
+			t.Fatalf("\t%s Expected %q to evaluate to %s, got %s.", failure, tc.code, tc.result, result)
+		}
+		t.Logf("\t%s Evaluated %q.", success, tc.code)
+	}
+}
+
+// TestRewriteCommandCalls tests rewriting the calls in the command form of Go+.
+func TestRewriteCommandCalls(t *testing.T) {
+	cases := []struct {
+		code      string
+		rewritten string
+	}{
+		{`println "hi", name`, `println("hi", name)`},
+		{"x := 1\n  log.Printf \"%d\", x; // logged\n", "x := 1\n  log.Printf(\"%d\", x) // logged\n"},
+		{"f(1)\ng 2", "f(1)\ng(2)"},
+		// Code that parses, or whose errors are something else, is left alone.
+		{"type P struct {\n\tname string\n}", "type P struct {\n\tname string\n}"},
+		{"x := )\nprintln 1", "x := )\nprintln 1"},
+		{"go f 1", "go f 1"},
+	}
+
+	t.Logf("Should rewrite the calls in the command form into regular calls.")
+
+	for _, tc := range cases {
+		if rewritten, _ := rewriteCommandCalls(tc.code); rewritten != tc.rewritten {
+			t.Fatalf("\t%s Expected %q to be rewritten to %q, got %q.", failure, tc.code, tc.rewritten, rewritten)
+		}
+		t.Logf("\t%s Rewrote %q.", success, tc.code)
+	}
+}
+
+// TestRewriteComprehensions tests rewriting the comprehensions and for loops of later
+// versions of Go+.
+func TestRewriteComprehensions(t *testing.T) {
+	cases := []struct {
+		code      string
+		rewritten string
+	}{
+		{"for i <- 1:10 {\n}", "for i <- newRange(1, 10, 1) {\n}"},
+		{"[i for i <- :n:2]", "[i for i <- newRange(0, n, 2)]"},
+		{"[i for i <- xs[1:], i > 0]", "[i for i <- xs[1:], i > 0]"},
+		{"[i for i <- xs if i > 0]", "[i for i <- xs ,  i > 0]"},
+		{"{v: k for k, v <- m}", "({v: k for k, v <- m})"},
+		{"m := {v: k for k, v <- m}", "m := {v: k for k, v <- m}"},
+		// Channel operations and blocks are left alone.
+		{"select {\ncase ch <- v:\n\tx := 1\n}", "select {\ncase ch <- v:\n\tx := 1\n}"},
+		{"for i <- xs {\n\tif i > 0 {\n\t}\n}", "for i <- xs {\n\tif i > 0 {\n\t}\n}"},
+		{"{\n\tx := <-ch\n}", "{\n\tx := <-ch\n}"},
+	}
+
+	t.Logf("Should rewrite the comprehensions of later versions of Go+.")
+
+	for _, tc := range cases {
+		if rewritten := rewriteComprehensions(tc.code); rewritten != tc.rewritten {
+			t.Fatalf("\t%s Expected %q to be rewritten to %q, got %q.", failure, tc.code, tc.rewritten, rewritten)
+		}
+		t.Logf("\t%s Rewrote %q.", success, tc.code)
+	}
+}
+
+// TestRewriteCellErrReturns tests rewriting `expr?` outside of functions.
+func TestRewriteCellErrReturns(t *testing.T) {
+	cases := []struct {
+		code      string
+		rewritten string
+	}{
+		{"n := f()?\nn", "n := f()!\nn"},
+		{"if n := f()?; n > 0 {\n\tg()?\n}", "if n := f()!; n > 0 {\n\tg()!\n}"},
+		// Functions, defaults and code that does not parse are left alone.
+		{"n := f()?:1", "n := f()?:1"},
+		{"g := func() error {\n\tf()?\n\treturn nil\n}\nh()?", "g := func() error {\n\tf()?\n\treturn nil\n}\nh()!"},
+		{"func g() error {\n\tf()?\n\treturn nil\n}", "func g() error {\n\tf()?\n\treturn nil\n}"},
+		{"n := (f()?", "n := (f()?"},
+	}
+
+	t.Logf("Should rewrite `expr?` outside of functions into `expr!`.")
+
+	for _, tc := range cases {
+		if rewritten := rewriteCellErrReturns(tc.code); rewritten != tc.rewritten {
+			t.Fatalf("\t%s Expected %q to be rewritten to %q, got %q.", failure, tc.code, tc.rewritten, rewritten)
+		}
+		t.Logf("\t%s Rewrote %q.", success, tc.code)
+	}
+}
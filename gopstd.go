@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/goplus/gop"
+)
+
+// The packages of the Go+ standard library that the snippets of the Go+ documentation
+// use, under their `gop/` import paths. Every session imports them, like the packages
+// of the kernel, so the snippets run whether or not they import them.
+var (
+	osxPackage     = gop.NewGoPackage("gop/osx")
+	stringxPackage = gop.NewGoPackage("gop/stringx")
+)
+
+func init() {
+	osxPackage.RegisterFuncs(
+		osxPackage.Func("Lines", osxLines, execOsxLines),
+	)
+	osxPackage.RegisterFuncvs(
+		osxPackage.Funcv("Errorln", osxErrorln, execOsxErrorln),
+	)
+	stringxPackage.RegisterFuncs(
+		stringxPackage.Func("Capitalize", stringxCapitalize, execStringxCapitalize),
+	)
+	stringxPackage.RegisterFuncvs(
+		stringxPackage.Funcv("Concat", stringxConcat, execStringxConcat),
+	)
+}
+
+// osxLines returns the lines read from r, without their line endings.
+func osxLines(r io.Reader) []string {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		panic(fmt.Errorf("osx.Lines: %v", err))
+	}
+	return lines
+}
+
+func execOsxLines(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, osxLines(args[0].(io.Reader)))
+}
+
+// osxErrorln prints its arguments to stderr, like println does to stdout.
+func osxErrorln(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, args...)
+}
+
+func execOsxErrorln(arity int, p *gop.Context) {
+	args := p.GetArgs(arity)
+	osxErrorln(args...)
+	p.Ret(arity)
+}
+
+// stringxCapitalize returns s with its first letter in upper case.
+func stringxCapitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError || unicode.IsUpper(r) {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+func execStringxCapitalize(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, stringxCapitalize(args[0].(string)))
+}
+
+// stringxConcat returns the concatenation of parts.
+func stringxConcat(parts ...string) string {
+	return strings.Join(parts, "")
+}
+
+func execStringxConcat(arity int, p *gop.Context) {
+	args := p.GetArgs(arity)
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = arg.(string)
+	}
+	p.Ret(arity, stringxConcat(parts...))
+}
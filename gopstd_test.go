@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestGopStdPackages tests the packages of the Go+ standard library bound in sessions.
+func TestGopStdPackages(t *testing.T) {
+	cases := []struct {
+		code   string
+		result string
+	}{
+		{"import \"strings\"\nosx.Lines(strings.NewReader(\"a\\nb\\n\"))", "[a b]"},
+		{"stringx.Concat(\"Go\", \"+\")", "Go+"},
+		{"import \"gop/stringx\"\nstringx.Capitalize(\"été\")", "Été"},
+	}
+
+	t.Logf("Should evaluate code using the Go+ standard packages without importing them.")
+
+	for _, tc := range cases {
+		vals, err := NewSession().Eval(tc.code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %q failed: %v.", failure, tc.code, err)
+		}
+		if result := fmt.Sprint(vals...); result != tc.result {
+			t.Fatalf("\t%s Expected %q to evaluate to %s, got %s.", failure, tc.code, tc.result, result)
+		}
+		t.Logf("\t%s Evaluated %q.", success, tc.code)
+	}
+}
@@ -0,0 +1,1275 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/goplus/gop"
+	"golang.org/x/net/http2"
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	lineMagics["grpc"] = evalGrpcMagic
+	documentMagic(grpcSyntax)
+
+	grpcxPackage.RegisterFuncs(
+		grpcxPackage.Func("Call", grpcCall, execGrpcCall),
+		grpcxPackage.Func("Invoke", grpcInvoke, execGrpcInvoke),
+	)
+}
+
+var grpcSyntax = &magicSyntax{
+	name:  "%grpc",
+	usage: []string{"load <file.proto> [addr=<host:port>] [tls]", "list"},
+	doc: "Loads the services of a .proto file, declaring its messages as struct types in the session, so " +
+		"that their methods can be called with `grpcx.Call(\"pkg.Service/Method\", req)`, or lists the " +
+		"methods loaded so far.",
+	args: []magicParam{
+		{name: "command", help: "`load` or `list`"},
+		{name: "file", help: "the .proto file to load", optional: true},
+	},
+	options: []magicParam{
+		{name: "addr", help: "the address of the server, `localhost:50051` by default"},
+		{name: "tls", help: "connect to the server with TLS", isSwitch: true},
+	},
+}
+
+// grpcxPackage is the Go+ package calling the methods loaded by %grpc load.
+var grpcxPackage = gop.NewGoPackage("grpcx")
+
+// grpcTimeout bounds the duration of a call.
+const grpcTimeout = time.Minute
+
+// grpcRegistry holds the methods loaded by %grpc load in a session.
+type grpcRegistry struct {
+	methods map[string]*grpcMethod // by full name, as "pkg.Service/Method"
+	order   []string
+	clients map[grpcTarget]*http.Client
+}
+
+// grpcMethod is a method loaded by %grpc load, with the server its calls are sent to.
+type grpcMethod struct {
+	*protoMethod
+	proto3 bool
+	target grpcTarget
+}
+
+// grpcTarget is a gRPC server.
+type grpcTarget struct {
+	addr string
+	tls  bool
+}
+
+// evalGrpcMagic implements
+//
+//	%grpc load <file.proto> [addr=<host:port>] [tls]
+//	%grpc list
+//
+// The first form parses the file, declares the types of its messages in the session
+// and registers the methods of its services for grpcx.Call.
+func evalGrpcMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := grpcSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	s := kernel.session
+	if s.grpc == nil {
+		s.grpc = &grpcRegistry{methods: map[string]*grpcMethod{}, clients: map[grpcTarget]*http.Client{}}
+	}
+
+	switch parsed.arg(0) {
+	case "load":
+		if parsed.arg(1) == "" {
+			return grpcSyntax.errorf("missing file")
+		}
+		src, err := ioutil.ReadFile(parsed.arg(1))
+		if err != nil {
+			return fmt.Errorf("%%grpc: %v", err)
+		}
+		file, err := parseProto(string(src))
+		if err != nil {
+			return fmt.Errorf("%%grpc: %s: %v", parsed.arg(1), err)
+		}
+		types, err := s.declareProto(file)
+		if err != nil {
+			return fmt.Errorf("%%grpc: %s: %v", parsed.arg(1), err)
+		}
+		target := grpcTarget{addr: parsed.options["addr"], tls: parsed.options["tls"] == "true"}
+		if target.addr == "" {
+			target.addr = "localhost:50051"
+		}
+		var names []string
+		for _, service := range file.services {
+			for _, m := range service.methods {
+				if _, ok := s.grpc.methods[m.fullName()]; !ok {
+					s.grpc.order = append(s.grpc.order, m.fullName())
+				}
+				s.grpc.methods[m.fullName()] = &grpcMethod{m, file.proto3, target}
+				names = append(names, m.fullName())
+			}
+		}
+		methods := "methods"
+		if len(names) == 1 {
+			methods = "method"
+		}
+		fmt.Fprintf(outerr.out, "Loaded %d %s from %s for %s.\n", len(names), methods, parsed.arg(1), target.addr)
+		if len(types) != 0 {
+			fmt.Fprintf(outerr.out, "Declared %s.\n", strings.Join(types, ", "))
+		}
+	case "list":
+		if len(s.grpc.order) == 0 {
+			fmt.Fprintln(outerr.out, "No methods loaded.")
+		}
+		for _, name := range s.grpc.order {
+			m := s.grpc.methods[name]
+			fmt.Fprintf(outerr.out, "%s(%s) returns (%s) at %s\n", name, m.input.goName, m.output.goName, m.target.addr)
+		}
+	default:
+		return grpcSyntax.errorf("unknown command %q", parsed.arg(0))
+	}
+	return nil
+}
+
+// declareProto declares the messages of file as struct types in the session, and the
+// values of its enums as int32 variables, then records the types of the messages. It
+// returns the names it declared. Names already declared, e.g. by loading the file
+// again, are kept.
+func (s *Session) declareProto(file *protoFile) ([]string, error) {
+	messages, err := file.sortedMessages()
+	if err != nil {
+		return nil, err
+	}
+	var src strings.Builder
+	var names []string
+	for _, m := range messages {
+		if _, err := s.Peek(m.goName + "{}"); err == nil {
+			continue
+		}
+		fmt.Fprintf(&src, "type %s struct {\n", m.goName)
+		for _, f := range m.fields {
+			fmt.Fprintf(&src, "\t%s %s\n", f.goName(), f.goType())
+		}
+		src.WriteString("}\n")
+		names = append(names, m.goName)
+	}
+	for _, e := range file.enums {
+		for _, v := range e.values {
+			name := e.goName + "_" + v.name
+			if _, err := s.Peek(name); err == nil {
+				continue
+			}
+			fmt.Fprintf(&src, "%s := int32(%d)\n", name, v.number)
+			names = append(names, name)
+		}
+	}
+	if src.Len() != 0 {
+		if _, err := s.Eval(src.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range messages {
+		vals, err := s.Peek(m.goName + "{}")
+		if err != nil || len(vals) != 1 || reflect.TypeOf(vals[0]).Kind() != reflect.Struct {
+			return nil, fmt.Errorf("%s is declared but not as a struct type", m.goName)
+		}
+		m.goType = reflect.TypeOf(vals[0])
+	}
+	return names, nil
+}
+
+// grpcCall implements grpcx.Call, which calls a method loaded by %grpc load and
+// returns its response. Like the other builtins it reports errors by panicking, which
+// fails the cell.
+func grpcCall(method string, req interface{}) interface{} {
+	m, err := activeSession.grpc.method(method)
+	if err != nil {
+		panic(xerrors.Errorf("grpcx.Call: %w", err))
+	}
+	resp := reflect.New(m.output.goType).Elem()
+	if err := activeSession.grpc.invoke(m, req, resp); err != nil {
+		panic(xerrors.Errorf("grpcx.Call: %w", err))
+	}
+	return resp.Interface()
+}
+
+func execGrpcCall(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, grpcCall(args[0].(string), args[1]))
+}
+
+// grpcInvoke implements grpcx.Invoke, which calls a method loaded by %grpc load and
+// stores its response in the struct resp points to. Unlike grpcx.Call, it gives the
+// cells a response they can read the fields of.
+func grpcInvoke(method string, req, resp interface{}) {
+	m, err := activeSession.grpc.method(method)
+	if err != nil {
+		panic(xerrors.Errorf("grpcx.Invoke: %w", err))
+	}
+	v := reflect.ValueOf(resp)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		panic(xerrors.Errorf("grpcx.Invoke: the response must be a pointer to a struct, not %T", resp))
+	}
+	v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	if err := activeSession.grpc.invoke(m, req, v.Elem()); err != nil {
+		panic(xerrors.Errorf("grpcx.Invoke: %w", err))
+	}
+}
+
+func execGrpcInvoke(_ int, p *gop.Context) {
+	args := p.GetArgs(3)
+	grpcInvoke(args[0].(string), args[1], args[2])
+	p.Ret(3)
+}
+
+// method returns the method called name, as "pkg.Service/Method".
+func (r *grpcRegistry) method(name string) (*grpcMethod, error) {
+	if sandboxed {
+		return nil, errSandboxed
+	}
+	var m *grpcMethod
+	if r != nil {
+		m = r.methods[strings.TrimPrefix(name, "/")]
+	}
+	if m == nil {
+		return nil, fmt.Errorf("unknown method %s: load its service with %%grpc load", name)
+	}
+	return m, nil
+}
+
+// grpcCodes are the names of the status codes of gRPC.
+var grpcCodes = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED",
+	"OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+// invoke sends a unary call of m to its server, and decodes the response into resp.
+// Calls are sent over HTTP/2, in cleartext unless the service was loaded with tls, as
+// described by https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md.
+func (r *grpcRegistry) invoke(m *grpcMethod, req interface{}, resp reflect.Value) error {
+	if m.clientStreaming || m.serverStreaming {
+		return fmt.Errorf("%s streams its messages, which is not supported", m.fullName())
+	}
+	v := reflect.Indirect(reflect.ValueOf(req))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("the request must be a %s struct, not %T", m.input.goName, req)
+	}
+	body, err := encodeProto(m.input, v, m.proto3)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	frame = append(frame, body...)
+
+	scheme := "http"
+	if m.target.tls {
+		scheme = "https"
+	}
+	httpReq, err := http.NewRequest("POST", scheme+"://"+m.target.addr+"/"+m.fullName(), bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	httpReq.Header.Set("TE", "trailers")
+	httpResp, err := r.client(m.target).Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	data, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the server replied %s", httpResp.Status)
+	}
+
+	// Servers send the status in the trailers, or in the headers if they fail before
+	// sending any message.
+	status, message := httpResp.Trailer.Get("Grpc-Status"), httpResp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = httpResp.Header.Get("Grpc-Status"), httpResp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if unescaped, err := url.PathUnescape(message); err == nil {
+			message = unescaped
+		}
+		if code, err := strconv.Atoi(status); err == nil && code > 0 && code < len(grpcCodes) {
+			status = grpcCodes[code]
+		}
+		return fmt.Errorf("%s: %s", status, message)
+	}
+
+	switch {
+	case len(data) < 5:
+		return errors.New("the server sent no response")
+	case data[0]&1 != 0:
+		return errors.New("the server sent a compressed response, which is not supported")
+	case 5+int(binary.BigEndian.Uint32(data[1:])) > len(data):
+		return errors.New("the response is truncated")
+	}
+	return decodeProto(m.output, data[5:5+binary.BigEndian.Uint32(data[1:])], resp)
+}
+
+// client returns the HTTP/2 client sending the calls to target.
+func (r *grpcRegistry) client(target grpcTarget) *http.Client {
+	if c, ok := r.clients[target]; ok {
+		return c
+	}
+	transport := &http2.Transport{}
+	if !target.tls {
+		// Cleartext HTTP/2 (h2c) is sent over a plain connection, with no upgrade.
+		transport.AllowHTTP = true
+		transport.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		}
+	}
+	c := &http.Client{Transport: transport, Timeout: grpcTimeout}
+	r.clients[target] = c
+	return c
+}
+
+// This file parses the subset of the .proto language needed to call unary methods:
+// messages, including nested ones, with scalar, enum, message, repeated, map and oneof
+// fields, enums and services. Options, imports, extensions and groups are skipped or
+// refused, and so are the types of imported files. The messages are encoded in the
+// protobuf wire format by the file too, see https://protobuf.dev/programming-guides/encoding/.
+
+// protoFile is a parsed .proto file.
+type protoFile struct {
+	pkg      string
+	proto3   bool
+	messages []*protoMessage
+	enums    []*protoEnum
+	services []*protoService
+}
+
+// protoMessage is a message type. Its Go+ type is a struct with a field for each field
+// of the message.
+type protoMessage struct {
+	name   string // the full name, as "pkg.Outer.Inner"
+	goName string // the name of the Go+ type, as "Outer_Inner"
+	fields []*protoField
+	goType reflect.Type // once declared in the session
+}
+
+// protoField is a field of a message. Its Go+ type is the Go type of its scalar type,
+// int32 for enums, or the type of its message: a pointer for singular fields, a slice
+// for repeated fields, a map for map fields.
+type protoField struct {
+	name     string
+	number   int
+	typ      string // the scalar type, or the full name of the message or enum once resolved
+	key      string // the scalar type of the keys, for map fields
+	repeated bool
+	required bool
+	scope    string // the full name of the message declaring the field
+	message  *protoMessage
+	enum     *protoEnum
+}
+
+type protoEnum struct {
+	name   string
+	goName string
+	values []protoEnumValue
+}
+
+type protoEnumValue struct {
+	name   string
+	number int
+}
+
+type protoService struct {
+	name    string // the full name, as "pkg.Service"
+	methods []*protoMethod
+}
+
+type protoMethod struct {
+	service         *protoService
+	name            string
+	input, output   *protoMessage
+	inName, outName string
+	clientStreaming bool
+	serverStreaming bool
+}
+
+// fullName returns the name of the method in calls, as "pkg.Service/Method".
+func (m *protoMethod) fullName() string {
+	return m.service.name + "/" + m.name
+}
+
+// protoScalars maps the scalar types of protobuf to Go types.
+var protoScalars = map[string]string{
+	"double": "float64", "float": "float32",
+	"int32": "int32", "int64": "int64", "uint32": "uint32", "uint64": "uint64",
+	"sint32": "int32", "sint64": "int64", "fixed32": "uint32", "fixed64": "uint64",
+	"sfixed32": "int32", "sfixed64": "int64",
+	"bool": "bool", "string": "string", "bytes": "[]byte",
+}
+
+// kind returns the scalar type of the field, or "message" or "enum".
+func (f *protoField) kind() string {
+	switch {
+	case f.message != nil:
+		return "message"
+	case f.enum != nil:
+		return "enum"
+	}
+	return f.typ
+}
+
+// goName returns the name of the field in Go+, in CamelCase: user_id becomes UserId.
+func (f *protoField) goName() string {
+	var b strings.Builder
+	upper := true
+	for _, r := range f.name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// goType returns the Go+ type of the field.
+func (f *protoField) goType() string {
+	elem := protoScalars[f.typ]
+	switch {
+	case f.message != nil:
+		elem = f.message.goName
+	case f.enum != nil:
+		elem = "int32"
+	}
+	switch {
+	case f.key != "":
+		return "map[" + protoScalars[f.key] + "]" + elem
+	case f.repeated:
+		return "[]" + elem
+	case f.message != nil:
+		return "*" + elem
+	}
+	return elem
+}
+
+// sortedMessages returns the messages of the file ordered so that the types of the
+// fields of each message come before it, as Go+ requires. Go+ types cannot refer to
+// themselves, so recursive messages are refused.
+func (file *protoFile) sortedMessages() ([]*protoMessage, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[*protoMessage]int{}
+	var sorted []*protoMessage
+	var visit func(m *protoMessage) error
+	visit = func(m *protoMessage) error {
+		switch state[m] {
+		case visiting:
+			return fmt.Errorf("message %s refers to itself, which Go+ types cannot express", m.name)
+		case visited:
+			return nil
+		}
+		state[m] = visiting
+		for _, f := range m.fields {
+			if f.message != nil {
+				if err := visit(f.message); err != nil {
+					return err
+				}
+			}
+		}
+		state[m] = visited
+		sorted = append(sorted, m)
+		return nil
+	}
+	for _, m := range file.messages {
+		if err := visit(m); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// protoToken is a token of a .proto file: an identifier, possibly qualified, a number,
+// a quoted string or a punctuation character.
+type protoToken struct {
+	text string
+	line int
+	str  bool
+}
+
+// protoError is a syntax error in a .proto file.
+type protoError string
+
+func (e protoError) Error() string {
+	return string(e)
+}
+
+// tokenizeProto splits a .proto file into tokens, skipping comments.
+func tokenizeProto(src string) ([]protoToken, error) {
+	var toks []protoToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, protoError(fmt.Sprintf("line %d: unterminated comment", line))
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != c {
+				return nil, protoError(fmt.Sprintf("line %d: unterminated string", line))
+			}
+			toks = append(toks, protoToken{src[i+1 : j], line, true})
+			i = j + 1
+		case c == '_' || c == '.' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, protoToken{src[i:j], line, false})
+			i = j
+		default:
+			toks = append(toks, protoToken{string(c), line, false})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+// protoParser parses the tokens of a .proto file. Its methods report syntax errors by
+// panicking with a protoError, which parseProto recovers.
+type protoParser struct {
+	toks []protoToken
+	pos  int
+	file *protoFile
+}
+
+// parseProto parses a .proto file and resolves the types it refers to.
+func parseProto(src string) (file *protoFile, err error) {
+	toks, err := tokenizeProto(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &protoParser{toks: toks, file: &protoFile{}}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(protoError)
+			if !ok {
+				panic(r)
+			}
+			file, err = nil, e
+		}
+	}()
+	p.parseFile()
+	p.file.resolve()
+	return p.file, nil
+}
+
+func (p *protoParser) peek(n int) string {
+	if p.pos+n < len(p.toks) {
+		return p.toks[p.pos+n].text
+	}
+	return ""
+}
+
+func (p *protoParser) next() protoToken {
+	if p.pos >= len(p.toks) {
+		line := 0
+		if len(p.toks) != 0 {
+			line = p.toks[len(p.toks)-1].line
+		}
+		panic(protoError(fmt.Sprintf("line %d: unexpected end of file", line)))
+	}
+	p.pos++
+	return p.toks[p.pos-1]
+}
+
+func (p *protoParser) fail(t protoToken, format string, args ...interface{}) {
+	panic(protoError(fmt.Sprintf("line %d: %s", t.line, fmt.Sprintf(format, args...))))
+}
+
+func (p *protoParser) expect(text string) {
+	if t := p.next(); t.text != text || t.str {
+		p.fail(t, "expected %q, found %q", text, t.text)
+	}
+}
+
+func (p *protoParser) ident() string {
+	t := p.next()
+	if t.str || !(t.text[0] == '_' || t.text[0] == '.' || unicode.IsLetter(rune(t.text[0]))) {
+		p.fail(t, "expected a name, found %q", t.text)
+	}
+	return t.text
+}
+
+func (p *protoParser) number() int {
+	t := p.next()
+	sign := int64(1)
+	if t.text == "-" {
+		sign, t = -1, p.next()
+	}
+	n, err := strconv.ParseInt(t.text, 0, 32)
+	if err != nil || t.str {
+		p.fail(t, "expected a number, found %q", t.text)
+	}
+	return int(sign * n)
+}
+
+// skipStatement skips the tokens up to the end of a statement, including the blocks
+// of option values.
+func (p *protoParser) skipStatement() {
+	depth := 0
+	for {
+		switch t := p.next(); {
+		case t.str:
+		case t.text == "{" || t.text == "[" || t.text == "(":
+			depth++
+		case t.text == "}" || t.text == "]" || t.text == ")":
+			depth--
+		case t.text == ";" && depth == 0:
+			return
+		}
+	}
+}
+
+// skipBlock skips a block between braces.
+func (p *protoParser) skipBlock() {
+	p.expect("{")
+	for depth := 1; depth > 0; {
+		switch t := p.next(); {
+		case t.str:
+		case t.text == "{":
+			depth++
+		case t.text == "}":
+			depth--
+		}
+	}
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (p *protoParser) parseFile() {
+	for p.pos < len(p.toks) {
+		switch t := p.next(); t.text {
+		case "syntax":
+			p.expect("=")
+			syntax := p.next()
+			if syntax.text != "proto2" && syntax.text != "proto3" {
+				p.fail(syntax, "unsupported syntax %q", syntax.text)
+			}
+			p.file.proto3 = syntax.text == "proto3"
+			p.expect(";")
+		case "package":
+			p.file.pkg = p.ident()
+			p.expect(";")
+		case "import", "option":
+			p.skipStatement()
+		case "message":
+			p.parseMessage(p.file.pkg)
+		case "enum":
+			p.parseEnum(p.file.pkg)
+		case "service":
+			p.parseService()
+		case "extend":
+			p.ident()
+			p.skipBlock()
+		case ";":
+		default:
+			p.fail(t, "unexpected %q", t.text)
+		}
+	}
+}
+
+func (p *protoParser) parseMessage(scope string) {
+	m := &protoMessage{name: qualify(scope, p.ident())}
+	p.file.messages = append(p.file.messages, m)
+	p.expect("{")
+	for {
+		switch p.peek(0) {
+		case "}":
+			p.next()
+			return
+		case "message":
+			p.next()
+			p.parseMessage(m.name)
+		case "enum":
+			p.next()
+			p.parseEnum(m.name)
+		case "option", "reserved", "extensions":
+			p.skipStatement()
+		case "extend":
+			p.next()
+			p.ident()
+			p.skipBlock()
+		case "oneof":
+			// The fields of a oneof are plain fields of the struct, of which at most
+			// one should be set.
+			p.next()
+			p.ident()
+			p.expect("{")
+			for p.peek(0) != "}" {
+				if p.peek(0) == "option" {
+					p.skipStatement()
+					continue
+				}
+				m.fields = append(m.fields, p.parseField(m.name))
+			}
+			p.next()
+		case ";":
+			p.next()
+		default:
+			m.fields = append(m.fields, p.parseField(m.name))
+		}
+	}
+}
+
+func (p *protoParser) parseField(scope string) *protoField {
+	f := &protoField{scope: scope}
+	switch p.peek(0) {
+	case "repeated":
+		f.repeated = true
+		p.next()
+	case "required":
+		f.required = true
+		p.next()
+	case "optional":
+		p.next()
+	}
+	switch {
+	case p.peek(0) == "map" && p.peek(1) == "<":
+		p.next()
+		p.next()
+		f.key = p.ident()
+		p.expect(",")
+		f.typ = p.ident()
+		p.expect(">")
+	case p.peek(0) == "group":
+		p.fail(p.next(), "groups are not supported")
+	default:
+		f.typ = p.ident()
+	}
+	f.name = p.ident()
+	p.expect("=")
+	f.number = p.number()
+	if p.peek(0) == "[" {
+		p.skipStatement()
+	} else {
+		p.expect(";")
+	}
+	return f
+}
+
+func (p *protoParser) parseEnum(scope string) {
+	e := &protoEnum{name: qualify(scope, p.ident())}
+	p.file.enums = append(p.file.enums, e)
+	p.expect("{")
+	for {
+		switch p.peek(0) {
+		case "}":
+			p.next()
+			return
+		case "option", "reserved":
+			p.skipStatement()
+		case ";":
+			p.next()
+		default:
+			v := protoEnumValue{name: p.ident()}
+			p.expect("=")
+			v.number = p.number()
+			if p.peek(0) == "[" {
+				p.skipStatement()
+			} else {
+				p.expect(";")
+			}
+			e.values = append(e.values, v)
+		}
+	}
+}
+
+func (p *protoParser) parseService() {
+	s := &protoService{name: qualify(p.file.pkg, p.ident())}
+	p.file.services = append(p.file.services, s)
+	p.expect("{")
+	for {
+		switch t := p.next(); t.text {
+		case "}":
+			return
+		case "option":
+			p.skipStatement()
+		case ";":
+		case "rpc":
+			m := &protoMethod{service: s, name: p.ident()}
+			p.expect("(")
+			if p.peek(0) == "stream" && p.peek(1) != ")" {
+				m.clientStreaming = true
+				p.next()
+			}
+			m.inName = p.ident()
+			p.expect(")")
+			p.expect("returns")
+			p.expect("(")
+			if p.peek(0) == "stream" && p.peek(1) != ")" {
+				m.serverStreaming = true
+				p.next()
+			}
+			m.outName = p.ident()
+			p.expect(")")
+			if p.peek(0) == "{" {
+				p.skipBlock()
+			} else {
+				p.expect(";")
+			}
+			s.methods = append(s.methods, m)
+		default:
+			p.fail(t, "unexpected %q", t.text)
+		}
+	}
+}
+
+// resolve finds the messages and enums the fields and methods of the file refer to,
+// and names their Go+ types.
+func (file *protoFile) resolve() {
+	goName := func(name string) string {
+		if file.pkg != "" {
+			name = strings.TrimPrefix(name, file.pkg+".")
+		}
+		return strings.Replace(name, ".", "_", -1)
+	}
+	for _, m := range file.messages {
+		m.goName = goName(m.name)
+	}
+	for _, e := range file.enums {
+		e.goName = goName(e.name)
+	}
+
+	for _, m := range file.messages {
+		for _, f := range m.fields {
+			if f.key != "" {
+				if _, ok := protoScalars[f.key]; !ok || f.key == "float" || f.key == "double" || f.key == "bytes" {
+					panic(protoError(fmt.Sprintf("%s.%s: invalid map key type %s", m.name, f.name, f.key)))
+				}
+			}
+			if _, ok := protoScalars[f.typ]; ok {
+				continue
+			}
+			f.message, f.enum = file.lookup(f.scope, f.typ)
+			switch {
+			case f.message != nil:
+				f.typ = f.message.name
+			case f.enum != nil:
+				f.typ = f.enum.name
+			default:
+				panic(protoError(fmt.Sprintf("%s.%s: unknown type %s", m.name, f.name, f.typ)))
+			}
+		}
+	}
+	for _, s := range file.services {
+		for _, m := range s.methods {
+			if m.input, _ = file.lookup(file.pkg, m.inName); m.input == nil {
+				panic(protoError(fmt.Sprintf("%s: unknown message %s", m.fullName(), m.inName)))
+			}
+			if m.output, _ = file.lookup(file.pkg, m.outName); m.output == nil {
+				panic(protoError(fmt.Sprintf("%s: unknown message %s", m.fullName(), m.outName)))
+			}
+		}
+	}
+}
+
+// lookup finds the message or enum called name from scope, looking in the enclosing
+// scopes in turn.
+func (file *protoFile) lookup(scope, name string) (*protoMessage, *protoEnum) {
+	find := func(full string) (*protoMessage, *protoEnum) {
+		for _, m := range file.messages {
+			if m.name == full {
+				return m, nil
+			}
+		}
+		for _, e := range file.enums {
+			if e.name == full {
+				return nil, e
+			}
+		}
+		return nil, nil
+	}
+	if strings.HasPrefix(name, ".") {
+		return find(name[1:])
+	}
+	for {
+		if m, e := find(qualify(scope, name)); m != nil || e != nil {
+			return m, e
+		}
+		if scope == "" {
+			return nil, nil
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// protoWireType returns the wire type of the values of a kind of field.
+func protoWireType(kind string) int {
+	switch kind {
+	case "fixed64", "sfixed64", "double":
+		return 1
+	case "string", "bytes", "message":
+		return 2
+	case "fixed32", "sfixed32", "float":
+		return 5
+	}
+	return 0
+}
+
+// encodeProto encodes the struct v as a message m. The fields of the message that v
+// does not have are left out. Singular fields holding their zero value are left out
+// too, unless they are required, and proto3 files pack repeated numbers.
+func encodeProto(m *protoMessage, v reflect.Value, proto3 bool) ([]byte, error) {
+	var b []byte
+	for _, f := range m.fields {
+		fv := v.FieldByName(f.goName())
+		if !fv.IsValid() {
+			continue
+		}
+		var err error
+		switch {
+		case f.key != "":
+			keys := fv.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+			for _, k := range keys {
+				var entry []byte
+				if entry, err = appendProtoValue(nil, &protoField{typ: f.key}, 1, k, proto3); err != nil {
+					return nil, err
+				}
+				if entry, err = appendProtoValue(entry, f, 2, fv.MapIndex(k), proto3); err != nil {
+					return nil, err
+				}
+				b = appendProtoBytes(b, f.number, entry)
+			}
+		case f.repeated && proto3 && protoWireType(f.kind()) != 2:
+			if fv.Len() == 0 {
+				continue
+			}
+			var packed []byte
+			for i := 0; i < fv.Len(); i++ {
+				if packed, err = appendProtoScalar(packed, f.kind(), fv.Index(i)); err != nil {
+					return nil, fmt.Errorf("%s: %v", f.name, err)
+				}
+			}
+			b = appendProtoBytes(b, f.number, packed)
+		case f.repeated:
+			for i := 0; i < fv.Len(); i++ {
+				if b, err = appendProtoValue(b, f, f.number, fv.Index(i), proto3); err != nil {
+					return nil, err
+				}
+			}
+		case fv.IsZero() && !f.required:
+		default:
+			if b, err = appendProtoValue(b, f, f.number, fv, proto3); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// appendProtoValue appends a value of field f, numbered number, to b.
+func appendProtoValue(b []byte, f *protoField, number int, v reflect.Value, proto3 bool) ([]byte, error) {
+	kind := f.kind()
+	b = binary.AppendUvarint(b, uint64(number)<<3|uint64(protoWireType(kind)))
+	if kind != "message" {
+		b, err := appendProtoScalar(b, kind, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.name, err)
+		}
+		return b, nil
+	}
+	msg, err := encodeProto(f.message, reflect.Indirect(v), proto3)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.AppendUvarint(b, uint64(len(msg)))
+	return append(b, msg...), nil
+}
+
+// appendProtoBytes appends a length-delimited field to b.
+func appendProtoBytes(b []byte, number int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(number)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// appendProtoScalar appends a scalar value to b, without its tag.
+func appendProtoScalar(b []byte, kind string, v reflect.Value) ([]byte, error) {
+	var n uint64
+	var f float64
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			n = 1
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = uint64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = v.Uint()
+	case reflect.Float32, reflect.Float64:
+		f = v.Float()
+	case reflect.String:
+		if kind != "string" && kind != "bytes" {
+			return nil, fmt.Errorf("cannot encode a string as %s", kind)
+		}
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.String()...), nil
+	case reflect.Slice:
+		if kind != "bytes" || v.Type().Elem().Kind() != reflect.Uint8 {
+			return nil, fmt.Errorf("cannot encode %s as %s", v.Type(), kind)
+		}
+		b = binary.AppendUvarint(b, uint64(v.Len()))
+		return append(b, v.Bytes()...), nil
+	default:
+		return nil, fmt.Errorf("cannot encode %s as %s", v.Type(), kind)
+	}
+
+	switch kind {
+	case "sint32", "sint64":
+		return binary.AppendUvarint(b, uint64(int64(n)<<1^int64(n)>>63)), nil
+	case "fixed32", "sfixed32":
+		return binary.LittleEndian.AppendUint32(b, uint32(n)), nil
+	case "fixed64", "sfixed64":
+		return binary.LittleEndian.AppendUint64(b, n), nil
+	case "float":
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(f))), nil
+	case "double":
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(f)), nil
+	case "string", "bytes":
+		return nil, fmt.Errorf("cannot encode %s as %s", v.Type(), kind)
+	}
+	return binary.AppendUvarint(b, n), nil
+}
+
+// protoValue is a value read from the wire: an integer for the varint and fixed wire
+// types, bytes for the length-delimited one.
+type protoValue struct {
+	wireType int
+	n        uint64
+	data     []byte
+}
+
+// readProtoValue reads a value of a wire type from data and returns the bytes it read.
+func readProtoValue(data []byte, wireType int) (protoValue, int, error) {
+	v := protoValue{wireType: wireType}
+	switch wireType {
+	case 0:
+		n, size := binary.Uvarint(data)
+		if size <= 0 {
+			return v, 0, errors.New("invalid varint")
+		}
+		v.n = n
+		return v, size, nil
+	case 1:
+		if len(data) < 8 {
+			return v, 0, errors.New("truncated message")
+		}
+		v.n = binary.LittleEndian.Uint64(data)
+		return v, 8, nil
+	case 5:
+		if len(data) < 4 {
+			return v, 0, errors.New("truncated message")
+		}
+		v.n = uint64(binary.LittleEndian.Uint32(data))
+		return v, 4, nil
+	case 2:
+		n, size := binary.Uvarint(data)
+		if size <= 0 || n > uint64(len(data)-size) {
+			return v, 0, errors.New("truncated message")
+		}
+		v.data = data[size : size+int(n)]
+		return v, size + int(n), nil
+	}
+	return v, 0, fmt.Errorf("unsupported wire type %d", wireType)
+}
+
+// decodeProto decodes the message m from data into the struct v. The fields that v
+// does not have are skipped, like the fields m does not know.
+func decodeProto(m *protoMessage, data []byte, v reflect.Value) error {
+	for len(data) > 0 {
+		tag, size := binary.Uvarint(data)
+		if size <= 0 {
+			return errors.New("invalid field tag")
+		}
+		value, n, err := readProtoValue(data[size:], int(tag&7))
+		if err != nil {
+			return err
+		}
+		data = data[size+n:]
+
+		var f *protoField
+		for _, field := range m.fields {
+			if uint64(field.number) == tag>>3 {
+				f = field
+			}
+		}
+		if f == nil {
+			continue
+		}
+		fv := v.FieldByName(f.goName())
+		if !fv.IsValid() {
+			continue
+		}
+		if err := decodeProtoField(f, value, fv); err != nil {
+			return fmt.Errorf("%s: %v", f.name, err)
+		}
+	}
+	return nil
+}
+
+// decodeProtoField stores a value read for field f into fv.
+func decodeProtoField(f *protoField, value protoValue, fv reflect.Value) error {
+	switch {
+	case f.key != "":
+		entry := &protoMessage{fields: []*protoField{
+			{name: "Key", number: 1, typ: f.key},
+			{name: "Value", number: 2, typ: f.typ, message: f.message, enum: f.enum},
+		}}
+		kv := reflect.New(reflect.StructOf([]reflect.StructField{
+			{Name: "Key", Type: fv.Type().Key()},
+			{Name: "Value", Type: fv.Type().Elem()},
+		})).Elem()
+		if value.wireType != 2 {
+			return fmt.Errorf("unexpected wire type %d", value.wireType)
+		}
+		if err := decodeProto(entry, value.data, kv); err != nil {
+			return err
+		}
+		if fv.IsNil() {
+			fv.Set(reflect.MakeMap(fv.Type()))
+		}
+		fv.SetMapIndex(kv.Field(0), kv.Field(1))
+	case f.repeated && value.wireType == 2 && protoWireType(f.kind()) != 2:
+		// Packed numbers.
+		for data := value.data; len(data) > 0; {
+			elem, n, err := readProtoValue(data, protoWireType(f.kind()))
+			if err != nil {
+				return err
+			}
+			data = data[n:]
+			e := reflect.New(fv.Type().Elem()).Elem()
+			if err := setProtoValue(f, elem, e); err != nil {
+				return err
+			}
+			fv.Set(reflect.Append(fv, e))
+		}
+	case f.repeated:
+		e := reflect.New(fv.Type().Elem()).Elem()
+		if err := setProtoValue(f, value, e); err != nil {
+			return err
+		}
+		fv.Set(reflect.Append(fv, e))
+	default:
+		return setProtoValue(f, value, fv)
+	}
+	return nil
+}
+
+// setProtoValue stores a singular value of field f into v.
+func setProtoValue(f *protoField, value protoValue, v reflect.Value) error {
+	kind := f.kind()
+	if value.wireType != protoWireType(kind) {
+		return fmt.Errorf("unexpected wire type %d", value.wireType)
+	}
+	if kind == "message" {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		return decodeProto(f.message, value.data, v)
+	}
+
+	n := value.n
+	switch kind {
+	case "string":
+		if v.Kind() == reflect.String {
+			v.SetString(string(value.data))
+			return nil
+		}
+	case "bytes":
+		if v.Kind() == reflect.Slice {
+			v.SetBytes(append([]byte(nil), value.data...))
+			return nil
+		}
+	case "float":
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			v.SetFloat(float64(math.Float32frombits(uint32(n))))
+			return nil
+		}
+	case "double":
+		if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			v.SetFloat(math.Float64frombits(n))
+			return nil
+		}
+	case "sint32", "sint64":
+		n = uint64(int64(n>>1) ^ -int64(n&1))
+	case "int32", "sfixed32", "enum":
+		n = uint64(int32(n))
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(n != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(n)
+	default:
+		return fmt.Errorf("cannot decode %s into %s", kind, v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const greeterProto = `
+syntax = "proto3";
+
+package greet.v1;
+
+option go_package = "example.com/greet"; // ignored
+
+/* A greeting. */
+message HelloRequest {
+  string name = 1;
+  repeated int32 lucky_numbers = 2 [packed = true];
+  Mood mood = 3;
+  map<string, Tag> tags = 4;
+  oneof contact {
+    string email = 5;
+    sint64 phone = 6;
+  }
+  message Tag {
+    double weight = 1;
+  }
+}
+
+enum Mood {
+  MOOD_UNSPECIFIED = 0;
+  HAPPY = 1;
+  GRUMPY = -1;
+}
+
+message HelloReply {
+  string message = 1;
+  HelloRequest.Tag tag = 2;
+  repeated HelloRequest.Tag history = 3;
+  fixed64 count = 4;
+  bytes raw = 5;
+  bool ok = 6;
+}
+
+service Greeter {
+  rpc SayHello (HelloRequest) returns (HelloReply) {
+    option idempotency_level = NO_SIDE_EFFECTS;
+  }
+  rpc Chat (stream HelloRequest) returns (stream HelloReply);
+}
+`
+
+// TestParseProto tests parsing .proto files.
+func TestParseProto(t *testing.T) {
+	t.Logf("Should parse messages, enums and services.")
+
+	file, err := parseProto(greeterProto)
+	if err != nil {
+		t.Fatalf("\t%s Could not parse the file: %v.", failure, err)
+	}
+	var messages []string
+	for _, m := range file.messages {
+		messages = append(messages, m.goName)
+	}
+	if want := []string{"HelloRequest", "HelloRequest_Tag", "HelloReply"}; !file.proto3 || file.pkg != "greet.v1" || !reflect.DeepEqual(messages, want) {
+		t.Fatalf("\t%s Expected the proto3 messages %v of greet.v1, got %v of %q.", failure, want, messages, file.pkg)
+	}
+	var fields []string
+	for _, f := range file.messages[0].fields {
+		fields = append(fields, f.goName()+" "+f.goType())
+	}
+	want := []string{"Name string", "LuckyNumbers []int32", "Mood int32", "Tags map[string]HelloRequest_Tag", "Email string", "Phone int64"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("\t%s Expected the fields %q, got %q.", failure, want, fields)
+	}
+	if tag := file.messages[2].fields[1]; tag.goType() != "*HelloRequest_Tag" {
+		t.Fatalf("\t%s Expected a pointer to the nested message, got %s.", failure, tag.goType())
+	}
+	methods := file.services[0].methods
+	if len(methods) != 2 || methods[0].fullName() != "greet.v1.Greeter/SayHello" || methods[0].output != file.messages[2] ||
+		!methods[1].clientStreaming || !methods[1].serverStreaming {
+		t.Fatalf("\t%s Expected the methods of the service to be resolved.", failure)
+	}
+	if values := file.enums[0].values; len(values) != 3 || values[2].number != -1 {
+		t.Fatalf("\t%s Expected the values of the enum, got %v.", failure, values)
+	}
+	t.Logf("\t%s Parsed the file.", success)
+
+	t.Logf("Should refuse the files it cannot handle.")
+
+	cases := []struct {
+		src, err string
+	}{
+		{"message A {\n  B b = 1;\n}", "unknown type B"},
+		{"message A {\n  A next = 1;\n}", "refers to itself"},
+		{"message A {\n  map<double, string> m = 1;\n}", "invalid map key type"},
+		{"message A {\n  string s = 1\n}", "line 3: expected \";\""},
+		{"syntax = \"proto4\";", "unsupported syntax"},
+		{"service S {\n  rpc M (A) returns (A);\n}", "unknown message A"},
+	}
+	for _, tc := range cases {
+		file, err := parseProto(tc.src)
+		if err == nil {
+			_, err = file.sortedMessages()
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("\t%s Expected an error with %q, got %v.", failure, tc.err, err)
+		}
+		t.Logf("\t%s Refused: %v.", success, err)
+	}
+}
+
+// TestProtoEncoding tests encoding and decoding messages in the protobuf wire format.
+func TestProtoEncoding(t *testing.T) {
+	file, err := parseProto(greeterProto)
+	if err != nil {
+		t.Fatalf("\t%s Could not parse the file: %v.", failure, err)
+	}
+	request, reply := file.messages[0], file.messages[2]
+
+	type tag struct{ Weight float64 }
+	type helloRequest struct {
+		Name         string
+		LuckyNumbers []int32
+		Mood         int32
+		Tags         map[string]tag
+		Email        string
+		Phone        int64
+	}
+	type helloReply struct {
+		Message string
+		Tag     *tag
+		History []tag
+		Count   uint64
+		Raw     []byte
+		Ok      bool
+	}
+
+	t.Logf("Should encode messages as the protobuf encoding does.")
+
+	data, err := encodeProto(request, reflect.ValueOf(helloRequest{Name: "testing", LuckyNumbers: []int32{3, 270}, Mood: -1, Phone: -2}), true)
+	if err != nil {
+		t.Fatalf("\t%s Could not encode the message: %v.", failure, err)
+	}
+	want := []byte{
+		0x0a, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g',
+		0x12, 0x03, 0x03, 0x8e, 0x02,
+		0x18, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x30, 0x03,
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("\t%s Expected % x, got % x.", failure, want, data)
+	}
+	t.Logf("\t%s Encoded the message.", success)
+
+	t.Logf("Should decode the messages it encodes.")
+
+	cases := []struct {
+		m *protoMessage
+		v interface{}
+	}{
+		{request, helloRequest{Name: "a", LuckyNumbers: []int32{-1, 0, 5}, Mood: 1, Tags: map[string]tag{"x": {0.5}, "y": {}}, Email: "a@b.c"}},
+		{reply, helloReply{Message: "hi", Tag: &tag{}, History: []tag{{1}, {2}}, Count: 1 << 63, Raw: []byte{0, 1}, Ok: true}},
+		{reply, helloReply{}},
+	}
+	for _, tc := range cases {
+		data, err := encodeProto(tc.m, reflect.ValueOf(tc.v), true)
+		if err != nil {
+			t.Fatalf("\t%s Could not encode %+v: %v.", failure, tc.v, err)
+		}
+		v := reflect.New(reflect.TypeOf(tc.v)).Elem()
+		if err := decodeProto(tc.m, data, v); err != nil {
+			t.Fatalf("\t%s Could not decode %+v: %v.", failure, tc.v, err)
+		}
+		if !reflect.DeepEqual(v.Interface(), tc.v) {
+			t.Fatalf("\t%s Expected %+v, got %+v.", failure, tc.v, v.Interface())
+		}
+		t.Logf("\t%s Decoded %+v.", success, tc.v)
+	}
+
+	t.Logf("Should decode unpacked numbers and skip unknown fields.")
+
+	var decoded helloRequest
+	if err := decodeProto(request, []byte{0x10, 0x07, 0x10, 0x08, 0x78, 0x01, 0x0a, 0x01, 'z'}, reflect.ValueOf(&decoded).Elem()); err != nil {
+		t.Fatalf("\t%s Could not decode the message: %v.", failure, err)
+	}
+	if !reflect.DeepEqual(decoded, helloRequest{Name: "z", LuckyNumbers: []int32{7, 8}}) {
+		t.Fatalf("\t%s Expected the unpacked numbers, got %+v.", failure, decoded)
+	}
+	if err := decodeProto(request, []byte{0x0a, 0x05, 'z'}, reflect.ValueOf(&decoded).Elem()); err == nil {
+		t.Fatalf("\t%s Expected a truncated message to be refused.", failure)
+	}
+	t.Logf("\t%s Decoded the message.", success)
+}
+
+// TestGrpcMagic tests loading a service and calling it from cells.
+func TestGrpcMagic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grpc")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "greeter.proto")
+	if err := ioutil.WriteFile(path, []byte(greeterProto), 0644); err != nil {
+		t.Fatalf("\t%s Could not write the file: %v.", failure, err)
+	}
+
+	// The server answers greet.v1.Greeter/SayHello in cleartext HTTP/2, like gRPC
+	// servers do, failing the calls without a name.
+	server := &http.Server{Handler: h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		if r.URL.Path != "/greet.v1.Greeter/SayHello" || r.ProtoMajor != 2 || len(body) < 7 {
+			w.Header().Set("Grpc-Status", "3")
+			w.Header().Set("Grpc-Message", "name%20is%20required")
+			return
+		}
+		name := body[7:]
+		message := append([]byte("Hello, "), name...)
+		reply := append([]byte{0x0a, byte(len(message))}, message...)
+		reply = append(reply, 0x30, 0x01, 0x12, 0x00)
+		frame := make([]byte, 5)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(reply)))
+		w.Write(append(frame, reply...))
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("\t%s Could not listen: %v.", failure, err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+
+	t.Logf("Should declare the messages of the file in the session.")
+
+	if _, err := kernel.doEvalGop(outerr, "%grpc load "+path+" addr="+listener.Addr().String()); err != nil {
+		t.Fatalf("\t%s %%grpc load failed: %v.", failure, err)
+	}
+	if text := out.String(); !strings.Contains(text, "Loaded 2 methods") || !strings.Contains(text, "HelloRequest_Tag, HelloRequest, HelloReply, Mood_MOOD_UNSPECIFIED") {
+		t.Fatalf("\t%s Expected the methods to be loaded and the types declared, got %q.", failure, text)
+	}
+	vals, err := kernel.doEvalGop(outerr, "Mood_GRUMPY")
+	if err != nil || len(vals) != 1 || vals[0] != int32(-1) {
+		t.Fatalf("\t%s Expected the values of the enum to be declared, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Declared the messages.", success)
+
+	t.Logf("Should call the methods of the service.")
+
+	code := "req := HelloRequest{Name: \"gopher\"}\nreply := HelloReply{}\ngrpcx.Invoke(\"greet.v1.Greeter/SayHello\", req, &reply)\nreply.Message"
+	vals, err = kernel.doEvalGop(outerr, code)
+	if err != nil || len(vals) != 1 || vals[0] != "Hello, gopher" {
+		t.Fatalf("\t%s Expected the reply of the server, got %v (%v).", failure, vals, err)
+	}
+	vals, err = kernel.doEvalGop(outerr, "r := grpcx.Call(\"/greet.v1.Greeter/SayHello\", &req)\nr")
+	if err != nil || len(vals) != 1 || reflect.ValueOf(vals[0]).FieldByName("Ok").Interface() != true ||
+		reflect.ValueOf(vals[0]).FieldByName("Tag").IsNil() {
+		t.Fatalf("\t%s Expected the reply of the server, got %+v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Called the service.", success)
+
+	t.Logf("Should report the errors of the calls.")
+
+	cases := []struct {
+		code, err string
+	}{
+		{"grpcx.Call(\"greet.v1.Greeter/SayHello\", HelloRequest{})", "INVALID_ARGUMENT: name is required"},
+		{"grpcx.Call(\"greet.v1.Greeter/Chat\", req)", "streams its messages"},
+		{"grpcx.Call(\"greet.v1.Greeter/Nope\", req)", "load its service with %grpc load"},
+		{"grpcx.Call(\"greet.v1.Greeter/SayHello\", 3)", "must be a HelloRequest struct"},
+	}
+	for _, tc := range cases {
+		if _, err := kernel.doEvalGop(outerr, "_ = "+tc.code); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("\t%s Expected an error with %q, got %v.", failure, tc.err, err)
+		}
+		t.Logf("\t%s Reported %q.", success, tc.err)
+	}
+
+	t.Logf("Should keep the types when the file is loaded again, and list the methods.")
+
+	out.Reset()
+	if _, err := kernel.doEvalGop(outerr, "%grpc load "+path+" addr="+listener.Addr().String()+"\n%grpc list"); err != nil {
+		t.Fatalf("\t%s Loading the file again failed: %v.", failure, err)
+	}
+	if text := out.String(); strings.Contains(text, "Declared") || !strings.Contains(text, "greet.v1.Greeter/SayHello(HelloRequest) returns (HelloReply) at 127.0.0.1:") {
+		t.Fatalf("\t%s Expected the file to be loaded again and the methods listed, got %q.", failure, text)
+	}
+	t.Logf("\t%s Loaded the file again.", success)
+}
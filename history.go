@@ -0,0 +1,211 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/lib/builtin"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/scanner"
+	"github.com/goplus/gop/token"
+)
+
+// history records the source and the result of every executed cell. It is bound into
+// the session as the In and Out maps, like in IPython, and provides the results that
+// `_`, `__` and `___` refer to.
+type history struct {
+	In      map[int]string
+	Out     map[int]interface{}
+	results []interface{} // every result in execution order, the most recent last
+
+	// mask, if set, is applied to the recorded source and string results, so that
+	// secrets are not kept in the history.
+	mask func(string) string
+}
+
+func newHistory() *history {
+	return &history{
+		In:  make(map[int]string),
+		Out: make(map[int]interface{}),
+	}
+}
+
+// record stores the source and the result values of the cell executed as In[count].
+// A cell producing several values is recorded with a slice holding all of them.
+func (h *history) record(count int, code string, vals []interface{}) {
+	if h.mask != nil {
+		code = h.mask(code)
+		for i, val := range vals {
+			if s, ok := val.(string); ok {
+				vals[i] = h.mask(s)
+			}
+		}
+	}
+	h.In[count] = code
+
+	var result interface{}
+	switch len(vals) {
+	case 0:
+		return
+	case 1:
+		result = vals[0]
+	default:
+		result = vals
+	}
+	h.Out[count] = result
+	h.results = append(h.results, result)
+}
+
+// result returns the n-th most recent result, or nil if there are fewer results.
+func (h *history) result(n int) interface{} {
+	if n < 1 || n > len(h.results) {
+		return nil
+	}
+	return h.results[len(h.results)-n]
+}
+
+// sessionPrelude is evaluated before the first cell of a session. It binds the history
+// maps, which are shared with the kernel and so always up to date.
+const sessionPrelude = "In := _gopyter_In()\nOut := _gopyter_Out()\n"
+
+func historyIn() map[int]string {
+	return activeSession.history.In
+}
+
+func historyOut() map[int]interface{} {
+	return activeSession.history.Out
+}
+
+func historyResult(n int) interface{} {
+	return activeSession.history.result(n)
+}
+
+func execHistoryIn(_ int, p *gop.Context) {
+	p.Ret(0, historyIn())
+}
+
+func execHistoryOut(_ int, p *gop.Context) {
+	p.Ret(0, historyOut())
+}
+
+func execHistoryResult(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, historyResult(args[0].(int)))
+}
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("_gopyter_In", historyIn, execHistoryIn),
+		builtin.I.Func("_gopyter_Out", historyOut, execHistoryOut),
+		builtin.I.Func("_gopyter_result", historyResult, execHistoryResult),
+	)
+}
+
+// resultRefs maps the IPython names of the last three results to how recent they are.
+// Go+ treats `_` as the blank identifier, so the names cannot be ordinary variables;
+// reads of them are rewritten into calls looking up the history instead.
+var resultRefs = map[string]string{
+	"_":   "1",
+	"__":  "2",
+	"___": "3",
+}
+
+// rewriteResultRefs replaces each read of `_`, `__` or `___` in code with a lookup of
+// the corresponding result. Uses as a blank identifier or assignment target are left
+// alone. Code that does not parse is returned unchanged for the compiler to report.
+func rewriteResultRefs(code string) string {
+	if !strings.Contains(code, "_") {
+		return code
+	}
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.Parse(fset, "", code, 0)
+	if err != nil {
+		return code
+	}
+
+	// Collect the result references in the parsed code and mark the ones that are
+	// written to. The parser may wrap the code in a package clause and main function,
+	// so positions are not offsets into code; the references are matched to the
+	// identifiers found by scanning code instead, in order.
+	var refs []*ast.Ident
+	written := make(map[*ast.Ident]bool)
+	write := func(exprs ...ast.Expr) {
+		for _, x := range exprs {
+			if ident, ok := x.(*ast.Ident); ok {
+				written[ident] = true
+			}
+		}
+	}
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			inspectAST(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.Ident:
+					if _, ok := resultRefs[n.Name]; ok {
+						refs = append(refs, n)
+					}
+				case *ast.AssignStmt:
+					write(n.Lhs...)
+				case *ast.IncDecStmt:
+					write(n.X)
+				case *ast.RangeStmt:
+					write(n.Key, n.Value)
+				case *ast.ForPhrase:
+					write(n.Key, n.Value)
+				case *ast.ValueSpec:
+					for _, name := range n.Names {
+						written[name] = true
+					}
+				case *ast.Field:
+					for _, name := range n.Names {
+						written[name] = true
+					}
+				case *ast.ImportSpec:
+					if n.Name != nil {
+						written[n.Name] = true
+					}
+				}
+				return true
+			})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Pos() < refs[j].Pos() })
+
+	var s scanner.Scanner
+	file := fset.AddFile("", -1, len(code))
+	s.Init(file, []byte(code), nil, 0)
+
+	var b strings.Builder
+	last, i := 0, 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.IDENT {
+			continue
+		}
+		if _, ok := resultRefs[lit]; !ok {
+			continue
+		}
+		if i >= len(refs) || refs[i].Name != lit {
+			// The scanner and the parser disagree, leave the code alone.
+			return code
+		}
+		if !written[refs[i]] {
+			offset := file.Offset(pos)
+			b.WriteString(code[last:offset])
+			b.WriteString("_gopyter_result(" + resultRefs[lit] + ")")
+			last = offset + len(lit)
+		}
+		i++
+	}
+	if i != len(refs) {
+		return code
+	}
+	b.WriteString(code[last:])
+	return b.String()
+}
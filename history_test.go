@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+// TestRewriteResultRefs tests that only reads of the last results are rewritten.
+func TestRewriteResultRefs(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"a := 1", "a := 1"},
+		{"_", "_gopyter_result(1)"},
+		{"x := _ + __", "x := _gopyter_result(1) + _gopyter_result(2)"},
+		{"println(___)", "println(_gopyter_result(3))"},
+		{"_ = f()", "_ = f()"},
+		{"_, err := f(_)", "_, err := f(_gopyter_result(1))"},
+		{"for _, v := range __ {\n\tprintln(v)\n}", "for _, v := range _gopyter_result(2) {\n\tprintln(v)\n}"},
+		{"y := [x for _, x <- _]", "y := [x for _, x <- _gopyter_result(1)]"},
+		{"s := \"_\" // _", "s := \"_\" // _"},
+		{"x := _ +", "x := _ +"},
+	}
+
+	t.Logf("Should rewrite reads of the last results.")
+
+	for k, tc := range cases {
+		t.Logf("  Rewriting snippet %d/%d.", k+1, len(cases))
+
+		if got := rewriteResultRefs(tc.Input); got != tc.Output {
+			t.Errorf("\t%s rewriteResultRefs(%q) = %q, expected %q.", failure, tc.Input, got, tc.Output)
+			continue
+		}
+		t.Logf("\t%s Returned the expected code.", success)
+	}
+}
+
+// TestHistory tests that executed cells are recorded for In, Out and the last results.
+func TestHistory(t *testing.T) {
+	h := newHistory()
+	h.record(1, "a := 1", nil)
+	h.record(2, "a", []interface{}{1})
+	h.record(3, "f()", []interface{}{2, nil})
+
+	if h.In[1] != "a := 1" || h.In[3] != "f()" {
+		t.Fatalf("\t%s Unexpected inputs %v.", failure, h.In)
+	}
+	if _, ok := h.Out[1]; ok || h.Out[2] != 1 || len(h.Out[3].([]interface{})) != 2 {
+		t.Fatalf("\t%s Unexpected outputs %v.", failure, h.Out)
+	}
+	if h.result(2) != 1 || h.result(3) != nil {
+		t.Fatalf("\t%s Unexpected last results %v, %v.", failure, h.result(2), h.result(3))
+	}
+	t.Logf("\t%s Recorded the expected history.", success)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	lineMagics["importgraph"] = evalImportGraphMagic
+	documentMagic(importGraphSyntax)
+}
+
+var importGraphSyntax = &magicSyntax{
+	name:  "%importgraph",
+	usage: []string{"[depth]"},
+	doc: "Renders the graph of the packages imported in the session and of the packages they import, " +
+		"`depth` levels deep (1 by default), with the size of their sources, and the size and load time of " +
+		"the plugins of the packages required with `%require`.",
+	args: []magicParam{
+		{name: "depth", help: "the number of levels of imported packages shown", optional: true},
+	},
+}
+
+// importNode is a package of the import graph of a session.
+type importNode struct {
+	Path     string
+	Imported bool  // by the session, rather than by another package
+	Files    int   // the number of Go files of the package, or 0 if its sources were not found
+	Size     int64 // the size of its Go files
+	Plugin   int64 // the size of the plugin loaded by %require, or 0
+	Load     time.Duration
+	Imports  []string // the packages of the graph it imports
+}
+
+// importGraph returns the packages imported by the session, and those they import up
+// to depth levels, sorted by path.
+func (kernel *Kernel) importGraph(depth int) []*importNode {
+	nodes := make(map[string]*importNode)
+	_, imports := kernel.session.declaredNames()
+	var level []string
+	for p := range imports {
+		nodes[p] = &importNode{Path: p, Imported: true}
+		level = append(level, p)
+	}
+	// The packages of the last level only import packages already in the graph.
+	last := make(map[*importNode][]string)
+	for ; len(level) != 0; depth-- {
+		var next []string
+		for _, p := range level {
+			n := nodes[p]
+			deps := kernel.describePackage(n)
+			if depth == 0 {
+				last[n] = deps
+				continue
+			}
+			for _, dep := range deps {
+				if dep == "C" || dep == "unsafe" {
+					continue
+				}
+				n.Imports = append(n.Imports, dep)
+				if _, ok := nodes[dep]; !ok {
+					nodes[dep] = &importNode{Path: dep}
+					next = append(next, dep)
+				}
+			}
+		}
+		level = next
+	}
+	for n, deps := range last {
+		for _, dep := range deps {
+			if _, ok := nodes[dep]; ok {
+				n.Imports = append(n.Imports, dep)
+			}
+		}
+	}
+	graph := make([]*importNode, 0, len(nodes))
+	for _, n := range nodes {
+		graph = append(graph, n)
+	}
+	sort.Slice(graph, func(i, j int) bool { return graph[i].Path < graph[j].Path })
+	return graph
+}
+
+// describePackage sets the sizes and load time of the package of n, and returns the
+// packages it imports.
+func (kernel *Kernel) describePackage(n *importNode) []string {
+	if r := kernel.session.requirements; r != nil {
+		for _, pin := range r.pins {
+			if pin.Package == n.Path {
+				if info, err := os.Stat(r.imports.plugin(pin)); err == nil {
+					n.Plugin = info.Size()
+				}
+				n.Load = r.loads[n.Path]
+			}
+		}
+	}
+	src, ok := kernel.docSource(n.Path)
+	if !ok {
+		return nil
+	}
+	bp, err := build.ImportDir(src.Dir, 0)
+	if err != nil {
+		return nil
+	}
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		if info, err := os.Stat(filepath.Join(src.Dir, name)); err == nil {
+			n.Files++
+			n.Size += info.Size()
+		}
+	}
+	return bp.Imports
+}
+
+// importGraphDot returns the import graph in the DOT language. The packages imported by
+// the session are bold, and the heavier packages darker.
+func importGraphDot(graph []*importNode) string {
+	var heaviest int64 = 1
+	for _, n := range graph {
+		if n.Size+n.Plugin > heaviest {
+			heaviest = n.Size + n.Plugin
+		}
+	}
+	var b strings.Builder
+	b.WriteString("digraph imports {\n  rankdir=LR;\n  node [shape=box, style=filled, fontname=\"Helvetica\", fontsize=10];\n")
+	for _, n := range graph {
+		label := n.Path
+		if n.Files != 0 {
+			label += fmt.Sprintf("\n%d files, %s", n.Files, formatBytes(n.Size))
+		}
+		if n.Plugin != 0 {
+			label += "\nplugin " + formatBytes(n.Plugin)
+		}
+		if n.Load != 0 {
+			label += ", loaded in " + n.Load.Round(time.Millisecond).String()
+		}
+		// From white to light blue, by weight.
+		saturation := 0.3 * float64(n.Size+n.Plugin) / float64(heaviest)
+		attrs := fmt.Sprintf("label=%s, fillcolor=\"0.58 %.2f 1.0\"", strconv.Quote(label), saturation)
+		if n.Imported {
+			attrs += ", penwidth=2, fontname=\"Helvetica-Bold\""
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", strconv.Quote(n.Path), attrs)
+	}
+	for _, n := range graph {
+		for _, dep := range n.Imports {
+			fmt.Fprintf(&b, "  %s -> %s;\n", strconv.Quote(n.Path), strconv.Quote(dep))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// evalImportGraphMagic implements `%importgraph [depth]`, which renders the import graph
+// of the session.
+func evalImportGraphMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := importGraphSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	depth := 1
+	if arg := parsed.arg(0); arg != "" {
+		if depth, err = strconv.Atoi(arg); err != nil || depth < 0 {
+			return importGraphSyntax.errorf("expected a number of levels, got %q", arg)
+		}
+	}
+	graph := kernel.importGraph(depth)
+	if len(graph) == 0 {
+		fmt.Fprintln(outerr.out, "The session imports no packages.")
+		return nil
+	}
+	kernel.display(outerr, newDotGraph(importGraphDot(graph)).Render())
+	return nil
+}
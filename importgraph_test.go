@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestImportGraph tests the graph of the packages imported in the session.
+func TestImportGraph(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	if _, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, "import \"strings\"\nimport \"strconv\"\nn := strconv.Itoa(len(strings.Fields(\"a b\")))"); err != nil {
+		t.Fatalf("\t%s Could not import the packages: %v.", failure, err)
+	}
+
+	t.Logf("Should list the packages imported in the session and the packages they import.")
+
+	graph := kernel.importGraph(1)
+	nodes := make(map[string]*importNode)
+	for _, n := range graph {
+		nodes[n.Path] = n
+	}
+	strs, conv := nodes["strings"], nodes["strconv"]
+	if strs == nil || conv == nil || !strs.Imported || !conv.Imported {
+		t.Fatalf("\t%s Expected the imported packages in the graph, got %d packages.", failure, len(graph))
+	}
+	if strs.Files == 0 || strs.Size == 0 {
+		t.Fatalf("\t%s Expected the size of the sources of strings, got %+v.", failure, strs)
+	}
+	if dep := nodes["unicode/utf8"]; dep == nil || dep.Imported {
+		t.Fatalf("\t%s Expected unicode/utf8, imported by strings, in the graph.", failure)
+	}
+	if !strings.Contains(strings.Join(strs.Imports, " "), "unicode/utf8") {
+		t.Fatalf("\t%s Expected strings to import unicode/utf8, got %v.", failure, strs.Imports)
+	}
+	t.Logf("\t%s Found %d packages.", success, len(graph))
+
+	t.Logf("Should render the graph in the DOT language.")
+
+	dot := importGraphDot(graph)
+	for _, want := range []string{`"strings" [label="strings\n`, `penwidth=2`, `"strings" -> "unicode/utf8";`} {
+		if !strings.Contains(dot, want) {
+			t.Fatalf("\t%s Expected %q in the graph, got:\n%s", failure, want, dot)
+		}
+	}
+	t.Logf("\t%s Rendered the graph.", success)
+}
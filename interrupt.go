@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("_gopyter_interrupted", checkInterrupt, execCheckInterrupt),
+	)
+}
+
+// Jupyter interrupts kernels with SIGINT, or with an interrupt_request on the control
+// channel when their interrupt_mode is message. The interpreter cannot be preempted, so
+// the cells are instrumented to check for interrupts: the body of each loop and function
+// of a cell starts with a call to _gopyter_interrupted, which fails the cell once the
+// kernel is interrupted. A cell blocked in a Go function, like time.Sleep, stops when the
+// function returns. Cells waiting for interrupts, like mq.Tail, get them instead, and
+// interrupts between cells are ignored.
+
+// interrupts tracks whether a cell is running and whether it waits for interrupts.
+var interrupts struct {
+	sync.Mutex
+	running  bool // a cell is being evaluated
+	pending  bool // the cell running was interrupted
+	watchers int  // the interrupt watchers of the cell running
+}
+
+// interruptCheck is the call cells are instrumented with to check for interrupts.
+const interruptCheck = "_gopyter_interrupted();"
+
+// errInterrupted fails the cells that were interrupted.
+var errInterrupted = errors.New("the cell was interrupted")
+
+// handleInterrupts handles the interrupts the kernel receives as SIGINT.
+func handleInterrupts() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		for range signals {
+			interrupted()
+		}
+	}()
+}
+
+// interrupted handles an interrupt of the kernel. The cells waiting for interrupts get
+// them from their own watchers.
+func interrupted() {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	switch {
+	case interrupts.watchers != 0:
+	case interrupts.running:
+		log.Println("Interrupting the running cell")
+		interrupts.pending = true
+	default:
+		log.Println("Ignoring an interrupt between cells")
+	}
+}
+
+// setCellRunning records whether a cell is being evaluated. The interrupts of the
+// previous cell no longer apply.
+func setCellRunning(running bool) {
+	interrupts.Lock()
+	interrupts.running = running
+	interrupts.pending = false
+	interrupts.Unlock()
+}
+
+// checkInterrupt fails the cell being evaluated if it was interrupted. The interrupt
+// stays pending until the cell ends, so a cell recovering from the failure is failed
+// again by its next check.
+func checkInterrupt() {
+	interrupts.Lock()
+	pending := interrupts.pending
+	interrupts.Unlock()
+	if pending {
+		panic(errInterrupted)
+	}
+}
+
+func execCheckInterrupt(_ int, p *gop.Context) {
+	checkInterrupt()
+	p.Ret(0)
+}
+
+// instrumentInterrupts returns code, Go+ code free of magics, with a call to
+// _gopyter_interrupted at the start of the body of each loop and function.
+func instrumentInterrupts(code string) string {
+	c, err := parseCell(code)
+	if err != nil {
+		// Evaluating it reports the error.
+		return code
+	}
+	var offsets []int
+	body := func(b *ast.BlockStmt) {
+		if b != nil {
+			offsets = append(offsets, c.offset(b.Lbrace)+1)
+		}
+	}
+	main := c.main()
+	inspectAST(c.file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ForStmt:
+			body(n.Body)
+		case *ast.RangeStmt:
+			body(n.Body)
+		case *ast.ForPhraseStmt:
+			body(n.Body)
+		case *ast.FuncDecl:
+			if n != main {
+				body(n.Body)
+			}
+		case *ast.FuncLit:
+			body(n.Body)
+		}
+		return true
+	})
+	sort.Ints(offsets)
+	var b strings.Builder
+	last := 0
+	for _, at := range offsets {
+		b.WriteString(code[last:at] + interruptCheck)
+		last = at
+	}
+	b.WriteString(code[last:])
+	return b.String()
+}
+
+// watchInterrupts returns a channel receiving the interrupts of the kernel, which then
+// no longer fail the cell, and a function to stop watching them.
+func watchInterrupts() (<-chan os.Signal, func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	interrupts.Lock()
+	interrupts.watchers++
+	interrupts.Unlock()
+	return c, func() {
+		signal.Stop(c)
+		interrupts.Lock()
+		interrupts.watchers--
+		interrupts.Unlock()
+	}
+}
+
+// handleInterruptRequest interrupts the kernel as SIGINT does, and replies to the
+// interrupt_request.
+func handleInterruptRequest(receipt msgReceipt) error {
+	if p, err := os.FindProcess(os.Getpid()); err != nil || p.Signal(os.Interrupt) != nil {
+		// Signals cannot be sent on every platform.
+		interrupted()
+	}
+	return receipt.Reply("interrupt_reply", map[string]interface{}{"status": "ok"})
+}
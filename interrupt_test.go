@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wangfenjin/gopyter/wire"
+)
+
+// TestInterrupts tests interrupting the cells, which fails them and keeps the kernel.
+func TestInterrupts(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+	if _, err := kernel.doEvalGop(outerr, "n := 1"); err != nil {
+		t.Fatalf("\t%s Could not set up the session: %v.", failure, err)
+	}
+
+	t.Logf("Should fail the running cell when the kernel is interrupted, and keep the session.")
+
+	cells := []string{
+		"for {\n\tn++\n}",
+		"for i := 0; ; i++ {\n}",
+		"for x := range [1, 2, 3] {\n\tfor {\n\t\tn += x\n\t}\n}",
+		"func spin() {\n\tfor {\n\t}\n}\nspin()",
+		"spin := func() {\n\tfor {\n\t}\n}\nspin()",
+	}
+	for _, code := range cells {
+		done := make(chan error, 1)
+		setCellRunning(true)
+		go func() {
+			_, err := kernel.doEvalGop(outerr, code)
+			done <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		interrupted()
+		select {
+		case err := <-done:
+			setCellRunning(false)
+			if err != errInterrupted {
+				t.Fatalf("\t%s Expected %q to be interrupted, got %v.", failure, code, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("\t%s Expected %q to stop.", failure, code)
+		}
+		if vals, err := kernel.session.Eval("n"); err != nil || len(vals) != 1 || vals[0] != 1 {
+			t.Fatalf("\t%s Expected n to keep its value, got %v (%v).", failure, vals, err)
+		}
+		t.Logf("\t%s Interrupted %q.", success, code)
+	}
+
+	t.Logf("Should keep the source of the cells as they were typed.")
+
+	triple := "func triple(n int) int {\n\tfor i := 0; i < n; i++ {\n\t}\n\treturn 3 * n\n}"
+	if _, err := kernel.doEvalGop(outerr, triple); err != nil {
+		t.Fatalf("\t%s Could not declare triple: %v.", failure, err)
+	}
+	if src, _, ok := kernel.session.funcSource("triple"); !ok || src != triple {
+		t.Fatalf("\t%s Expected the source of triple to be %q, got %q.", failure, triple, src)
+	}
+	if !strings.Contains(kernel.session.compiledSource(), interruptCheck) {
+		t.Fatalf("\t%s Expected the compiled source to check for interrupts.", failure)
+	}
+	t.Logf("\t%s Kept it.", success)
+
+	t.Logf("Should ignore the interrupts between cells, and leave them to the cells watching them.")
+
+	interrupted()
+	setCellRunning(true)
+	if _, err := kernel.doEvalGop(outerr, "for i := 0; i < 3; i++ {\n}"); err != nil {
+		t.Fatalf("\t%s Expected the cell to run, got %v.", failure, err)
+	}
+	_, stopWatching := watchInterrupts()
+	interrupted()
+	stopWatching()
+	interrupts.Lock()
+	pending := interrupts.pending
+	interrupts.Unlock()
+	setCellRunning(false)
+	if pending {
+		t.Fatalf("\t%s Expected the interrupt to be left to the watcher.", failure)
+	}
+	t.Logf("\t%s Ignored them.", success)
+}
+
+// TestInterruptRequest tests replying to the requests of the control channel on that
+// channel.
+func TestInterruptRequest(t *testing.T) {
+	// The interrupt is sent as SIGINT, which must not end the test.
+	signals, stopWatching := watchInterrupts()
+	defer stopWatching()
+
+	msg, err := NewMsg("interrupt_request", ComposedMsg{})
+	if err != nil {
+		t.Fatalf("\t%s Could not create a request: %v.", failure, err)
+	}
+	msg.Content = map[string]interface{}{}
+	parts, err := wire.Encode(msg, []byte("key"))
+	if err != nil {
+		t.Fatalf("\t%s Could not encode a request: %v.", failure, err)
+	}
+	request, ok := decodeRecordedMsg("control", "in", append([][]byte{[]byte("client"), []byte("<IDS|MSG>")}, parts...))
+	if !ok {
+		t.Fatalf("\t%s Could not decode the request.", failure)
+	}
+
+	t.Logf("Should interrupt the kernel and reply on the control channel.")
+
+	results := (&Kernel{NewSession(), defaultConfig()}).replay([]recordedMsg{request}, nil)
+	if len(results) != 1 || !strings.Contains(strings.Join(results[0].Replayed, "\n"), `control interrupt_reply {"status":"ok"}`) {
+		t.Fatalf("\t%s Expected an interrupt_reply on the control channel, got %v.", failure, results)
+	}
+	select {
+	case <-signals:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("\t%s Expected the kernel to be interrupted.", failure)
+	}
+	t.Logf("\t%s Replied %q.", success, results[0].Replayed)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// With user isolation, the kernel daemon runs as root and only routes connections: it
+// runs each kernel as the user who attached it, with that user's home and permissions,
+// so the pooled kernels of different users share neither state nor files. The user is
+// the local account the configuration maps the user of the token to, if the
+// authenticator found one, and else the owner of the attach process. Kernels never run
+// as root, nor as the system accounts below the minimum uid.
+
+// isolationPolicy is how the daemon finds the local accounts the kernels run as.
+type isolationPolicy struct {
+	users  map[string]string // the local account of each user of the authenticator
+	minUID int               // the lowest uid the kernels run as
+}
+
+// kernelOwner returns the user the kernel of the attach process connected on conn runs
+// as. name is the user its token belongs to, if any.
+func (p isolationPolicy) kernelOwner(conn net.Conn, name string) (*user.User, error) {
+	var u *user.User
+	if name != "" {
+		account, ok := p.users[name]
+		if !ok {
+			return nil, fmt.Errorf("no local account is mapped to %s", name)
+		}
+		var err error
+		if u, err = user.Lookup(account); err != nil {
+			return nil, fmt.Errorf("no local account %s for %s", account, name)
+		}
+	} else {
+		uid, err := peerUID(conn)
+		if err != nil {
+			return nil, fmt.Errorf("could not find the user attaching: %v", err)
+		}
+		if u, err = user.LookupId(strconv.Itoa(uid)); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.allows(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// allows returns an error if kernels cannot run as u.
+func (p isolationPolicy) allows(u *user.User) error {
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %s of %s", u.Uid, u.Username)
+	}
+	switch {
+	case uid == 0:
+		return fmt.Errorf("kernels cannot run as %s, whose uid is 0", u.Username)
+	case uid < p.minUID:
+		return fmt.Errorf("kernels cannot run as %s, whose uid %d is below %d", u.Username, uid, p.minUID)
+	}
+	return nil
+}
+
+// mappedOwners returns the local accounts the users of the authenticator are mapped to
+// that kernels can run as, sorted by name.
+func (p isolationPolicy) mappedOwners() ([]*user.User, error) {
+	var accounts []string
+	seen := make(map[string]bool)
+	for _, account := range p.users {
+		if !seen[account] {
+			seen[account] = true
+			accounts = append(accounts, account)
+		}
+	}
+	sort.Strings(accounts)
+	var owners []*user.User
+	for _, account := range accounts {
+		u, err := user.Lookup(account)
+		if err != nil {
+			return nil, fmt.Errorf("no local account %s", account)
+		}
+		if err := p.allows(u); err != nil {
+			return nil, err
+		}
+		owners = append(owners, u)
+	}
+	return owners, nil
+}
+
+// userEnv returns env with the variables naming the user and their home replaced by
+// those of u.
+func userEnv(env []string, u *user.User) []string {
+	var result []string
+	for _, v := range env {
+		if strings.HasPrefix(v, "HOME=") || strings.HasPrefix(v, "USER=") || strings.HasPrefix(v, "LOGNAME=") {
+			continue
+		}
+		result = append(result, v)
+	}
+	return append(result, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+	"os/user"
+)
+
+var errNoIsolation = errors.New("user isolation is only supported on Linux")
+
+// peerUID returns the user ID of the process at the other end of the Unix socket conn.
+func peerUID(conn net.Conn) (int, error) {
+	return 0, errNoIsolation
+}
+
+// secureDir creates the directory dir if needed, and returns an error unless only root
+// can write to it, so that the socket of a daemon running as root cannot be replaced.
+func secureDir(dir string) error {
+	return errNoIsolation
+}
+
+// runAs makes cmd run as u, in their home directory.
+func runAs(cmd *exec.Cmd, u *user.User) error {
+	return errNoIsolation
+}
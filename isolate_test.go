@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// TestKernelOwner tests finding the user the kernels of attach processes run as.
+func TestKernelOwner(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("Cannot find the current user: %v", err)
+	}
+
+	t.Logf("Should run the kernels as the local account mapped to the user of the token.")
+
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("Cannot find the nobody account: %v", err)
+	}
+	policy := isolationPolicy{
+		users:  map[string]string{"1234": "nobody", "admin": "root", "ghost": "no-such-user-gopyter"},
+		minUID: defaultConfig().IsolationMinUID,
+	}
+	if u, err := policy.kernelOwner(nil, "1234"); err != nil || u.Uid != nobody.Uid {
+		t.Fatalf("\t%s Expected %s, got %+v (%v).", failure, nobody.Uid, u, err)
+	}
+	t.Logf("\t%s Found the account of the token.", success)
+
+	t.Logf("Should refuse the users without an account, root and the accounts below the minimum uid.")
+
+	for _, name := range []string{"nobody", "admin", "ghost"} {
+		if u, err := policy.kernelOwner(nil, name); err == nil {
+			t.Fatalf("\t%s Expected %s to be refused, got %+v.", failure, name, u)
+		}
+	}
+	high := isolationPolicy{users: policy.users, minUID: 1 << 30}
+	if u, err := high.kernelOwner(nil, "1234"); err == nil {
+		t.Fatalf("\t%s Expected nobody to be below the minimum uid, got %+v.", failure, u)
+	}
+	if owners, err := policy.mappedOwners(); err == nil {
+		t.Fatalf("\t%s Expected the mapping to root to be refused, got %v.", failure, owners)
+	}
+	mapped := isolationPolicy{users: map[string]string{"1234": "nobody", "5678": "nobody"}, minUID: policy.minUID}
+	if owners, err := mapped.mappedOwners(); err != nil || len(owners) != 1 || owners[0].Uid != nobody.Uid {
+		t.Fatalf("\t%s Expected the mapped accounts to be nobody, got %v (%v).", failure, owners, err)
+	}
+	t.Logf("\t%s Refused them.", success)
+
+	t.Logf("Should give the kernels the environment of their user.")
+
+	u := &user.User{Username: "ada", HomeDir: "/home/ada"}
+	env := userEnv([]string{"HOME=/root", "PATH=/bin", "USER=root", "LOGNAME=root"}, u)
+	if want := []string{"PATH=/bin", "HOME=/home/ada", "USER=ada", "LOGNAME=ada"}; !reflect.DeepEqual(env, want) {
+		t.Fatalf("\t%s Expected %q, got %q.", failure, want, env)
+	}
+	t.Logf("\t%s Gave them %q.", success, env)
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	t.Logf("Should run the kernels as the owner of the attach process otherwise.")
+
+	dir, err := ioutil.TempDir("", "isolate")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	defer os.RemoveAll(dir)
+	listener, err := net.Listen("unix", filepath.Join(dir, "socket"))
+	if err != nil {
+		t.Fatalf("\t%s Could not listen: %v.", failure, err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := net.Dial("unix", filepath.Join(dir, "socket")); err == nil {
+			defer conn.Close()
+			ioutil.ReadAll(conn)
+		}
+	}()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("\t%s Could not accept: %v.", failure, err)
+	}
+	defer conn.Close()
+	u, err = isolationPolicy{}.kernelOwner(conn, "")
+	if current.Uid == "0" {
+		// The tests run as root.
+		if err == nil {
+			t.Fatalf("\t%s Expected root to be refused, got %+v.", failure, u)
+		}
+	} else if err != nil || u.Uid != current.Uid {
+		t.Fatalf("\t%s Expected %s, got %+v (%v).", failure, current.Uid, u, err)
+	}
+	t.Logf("\t%s Found the owner of the attach process.", success)
+}
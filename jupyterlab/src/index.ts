@@ -0,0 +1,45 @@
+// The JupyterLab extension registering the "gop" language advertised in the
+// kernel_info_reply, which extends the Go mode with the Go+ builtins and literals, like
+// kernel/kernel.js does for the classic Notebook.
+import { JupyterFrontEnd, JupyterFrontEndPlugin } from '@jupyterlab/application';
+import { IEditorLanguageRegistry } from '@jupyterlab/codemirror';
+import { LanguageSupport, StreamLanguage, StreamParser, StringStream } from '@codemirror/language';
+import { go } from '@codemirror/legacy-modes/mode/go';
+
+const builtins = ['println', 'print', 'printf', 'echo', 'newRange'];
+
+const gop: StreamParser<unknown> = {
+  name: 'gop',
+  startState: (indentUnit: number) => go.startState!(indentUnit),
+  copyState: go.copyState,
+  token: (stream: StringStream, state: unknown) => {
+    const style = go.token(stream, state);
+    if (style === 'number') {
+      // Rational and big number literals: 1r, 3/4r, 120bi.
+      stream.match(/^(r|bi)\b/);
+    } else if (style === 'variable' && builtins.indexOf(stream.current()) >= 0) {
+      return 'builtin';
+    }
+    return style;
+  },
+  indent: go.indent,
+  languageData: go.languageData
+};
+
+const plugin: JupyterFrontEndPlugin<void> = {
+  id: 'gopyter-highlight:plugin',
+  description: 'Highlights the Go+ cells of the gopyter kernel.',
+  autoStart: true,
+  requires: [IEditorLanguageRegistry],
+  activate: (app: JupyterFrontEnd, languages: IEditorLanguageRegistry) => {
+    languages.addLanguage({
+      name: 'gop',
+      displayName: 'Go+',
+      mime: 'text/x-gop',
+      extensions: ['gop'],
+      support: new LanguageSupport(StreamLanguage.define(gop))
+    });
+  }
+};
+
+export default plugin;
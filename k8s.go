@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/goplus/gop"
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	lineMagics["kubectx"] = evalKubectxMagic
+	documentMagic(kubectxSyntax)
+
+	k8sPackage.RegisterFuncs(
+		k8sPackage.Func("Pods", k8sPods, execK8sPods),
+		k8sPackage.Func("Deployments", k8sDeployments, execK8sDeployments),
+		k8sPackage.Func("Context", k8sContext, execK8sContext),
+	)
+	k8sPackage.RegisterFuncvs(
+		k8sPackage.Funcv("Kubectl", k8sKubectl, execK8sKubectl),
+	)
+}
+
+var kubectxSyntax = &magicSyntax{
+	name:  "%kubectx",
+	usage: []string{"[context]"},
+	doc: "Switches the Kubernetes context used by the k8s package in this session, or lists the contexts of " +
+		"the kubeconfig, marking the current one. The context of kubectl outside the kernel is not changed.",
+	args: []magicParam{{name: "context", help: "the context to switch to", optional: true}},
+}
+
+// k8sPackage is the optional Go+ package exploring Kubernetes clusters, for notebooks
+// used as runbooks. It is imported like other packages, or preloaded in every session
+// by the preload field of the configuration. It runs kubectl, which must be installed.
+var k8sPackage = gop.NewGoPackage("k8s")
+
+// kubectlCommand is the kubectl run by the k8s package.
+var kubectlCommand = "kubectl"
+
+// K8sPod is a pod, as listed by k8s.Pods.
+type K8sPod struct {
+	Namespace string
+	Name      string
+	Ready     string // the ready containers out of all, as "1/2"
+	Status    string // the phase of the pod, or the reason its containers are not running
+	Restarts  int
+	Age       string
+	Node      string
+}
+
+// K8sPods is a list of pods, rendered as a table with their status in color.
+type K8sPods []K8sPod
+
+// K8sDeployment is a deployment, as listed by k8s.Deployments.
+type K8sDeployment struct {
+	Namespace string
+	Name      string
+	Ready     string // the ready replicas out of the desired ones, as "2/3"
+	UpToDate  int
+	Available int
+	Age       string
+}
+
+// K8sDeployments is a list of deployments, rendered as a table with the deployments
+// missing replicas in color.
+type K8sDeployments []K8sDeployment
+
+// kubectl runs kubectl with args in the context of the session, and returns its output.
+func kubectl(s *Session, args ...string) ([]byte, error) {
+	if sandboxed {
+		return nil, errSandboxed
+	}
+	if s != nil && s.kubeContext != "" {
+		args = append([]string{"--context", s.kubeContext}, args...)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(kubectlCommand, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, errors.New(message)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// namespaceArgs returns the arguments of kubectl selecting a namespace: the current one
+// if namespace is empty, and all of them if it is "*".
+func namespaceArgs(namespace string) []string {
+	switch namespace {
+	case "":
+		return nil
+	case "*":
+		return []string{"--all-namespaces"}
+	}
+	return []string{"--namespace", namespace}
+}
+
+// k8sObjectMeta is the metadata of the objects listed by kubectl.
+type k8sObjectMeta struct {
+	Name              string     `json:"name"`
+	Namespace         string     `json:"namespace"`
+	CreationTimestamp time.Time  `json:"creationTimestamp"`
+	DeletionTimestamp *time.Time `json:"deletionTimestamp"`
+}
+
+// k8sPods implements k8s.Pods, which lists the pods of a namespace. Like the other
+// builtins it reports errors by panicking, which fails the cell.
+func k8sPods(namespace string) K8sPods {
+	pods, err := listPods(activeSession, namespace, time.Now())
+	if err != nil {
+		panic(xerrors.Errorf("k8s.Pods: %w", err))
+	}
+	return pods
+}
+
+func execK8sPods(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, k8sPods(args[0].(string)))
+}
+
+func listPods(s *Session, namespace string, now time.Time) (K8sPods, error) {
+	out, err := kubectl(s, append([]string{"get", "pods", "--output", "json"}, namespaceArgs(namespace)...)...)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []struct {
+			Metadata k8sObjectMeta `json:"metadata"`
+			Spec     struct {
+				NodeName string `json:"nodeName"`
+			} `json:"spec"`
+			Status struct {
+				Phase             string `json:"phase"`
+				Reason            string `json:"reason"`
+				ContainerStatuses []struct {
+					Ready        bool `json:"ready"`
+					RestartCount int  `json:"restartCount"`
+					State        map[string]struct {
+						Reason string `json:"reason"`
+					} `json:"state"`
+				} `json:"containerStatuses"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, xerrors.Errorf("parsing the output of kubectl: %w", err)
+	}
+
+	pods := K8sPods{}
+	for _, item := range list.Items {
+		pod := K8sPod{
+			Namespace: item.Metadata.Namespace,
+			Name:      item.Metadata.Name,
+			Status:    item.Status.Phase,
+			Age:       k8sAge(now.Sub(item.Metadata.CreationTimestamp)),
+			Node:      item.Spec.NodeName,
+		}
+		if item.Status.Reason != "" {
+			pod.Status = item.Status.Reason
+		}
+		ready := 0
+		for _, c := range item.Status.ContainerStatuses {
+			if c.Ready {
+				ready++
+			}
+			pod.Restarts += c.RestartCount
+			// Like kubectl, show why a container is waiting or terminated.
+			for _, state := range []string{"waiting", "terminated"} {
+				if reason := c.State[state].Reason; reason != "" && reason != "Completed" {
+					pod.Status = reason
+				}
+			}
+		}
+		if item.Metadata.DeletionTimestamp != nil {
+			pod.Status = "Terminating"
+		}
+		pod.Ready = fmt.Sprintf("%d/%d", ready, len(item.Status.ContainerStatuses))
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// k8sDeployments implements k8s.Deployments, which lists the deployments of a
+// namespace.
+func k8sDeployments(namespace string) K8sDeployments {
+	deployments, err := listDeployments(activeSession, namespace, time.Now())
+	if err != nil {
+		panic(xerrors.Errorf("k8s.Deployments: %w", err))
+	}
+	return deployments
+}
+
+func execK8sDeployments(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, k8sDeployments(args[0].(string)))
+}
+
+func listDeployments(s *Session, namespace string, now time.Time) (K8sDeployments, error) {
+	out, err := kubectl(s, append([]string{"get", "deployments", "--output", "json"}, namespaceArgs(namespace)...)...)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []struct {
+			Metadata k8sObjectMeta `json:"metadata"`
+			Spec     struct {
+				Replicas *int `json:"replicas"`
+			} `json:"spec"`
+			Status struct {
+				ReadyReplicas     int `json:"readyReplicas"`
+				UpdatedReplicas   int `json:"updatedReplicas"`
+				AvailableReplicas int `json:"availableReplicas"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, xerrors.Errorf("parsing the output of kubectl: %w", err)
+	}
+
+	deployments := K8sDeployments{}
+	for _, item := range list.Items {
+		desired := 1
+		if item.Spec.Replicas != nil {
+			desired = *item.Spec.Replicas
+		}
+		deployments = append(deployments, K8sDeployment{
+			Namespace: item.Metadata.Namespace,
+			Name:      item.Metadata.Name,
+			Ready:     fmt.Sprintf("%d/%d", item.Status.ReadyReplicas, desired),
+			UpToDate:  item.Status.UpdatedReplicas,
+			Available: item.Status.AvailableReplicas,
+			Age:       k8sAge(now.Sub(item.Metadata.CreationTimestamp)),
+		})
+	}
+	return deployments, nil
+}
+
+// k8sKubectl implements k8s.Kubectl, which runs kubectl in the context of the session
+// and returns its output.
+func k8sKubectl(args ...string) string {
+	out, err := kubectl(activeSession, args...)
+	if err != nil {
+		panic(xerrors.Errorf("k8s.Kubectl: %w", err))
+	}
+	return string(out)
+}
+
+func execK8sKubectl(arity int, p *gop.Context) {
+	args := p.GetArgs(arity)
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.(string)
+	}
+	p.Ret(arity, k8sKubectl(strs...))
+}
+
+// k8sContext implements k8s.Context, which returns the Kubernetes context of the
+// session.
+func k8sContext() string {
+	context, err := currentKubeContext(activeSession)
+	if err != nil {
+		panic(xerrors.Errorf("k8s.Context: %w", err))
+	}
+	return context
+}
+
+func execK8sContext(_ int, p *gop.Context) {
+	p.Ret(0, k8sContext())
+}
+
+// currentKubeContext returns the context set by %kubectx, or the current context of
+// the kubeconfig.
+func currentKubeContext(s *Session) (string, error) {
+	if s.kubeContext != "" {
+		return s.kubeContext, nil
+	}
+	out, err := kubectl(s, "config", "current-context")
+	return strings.TrimSpace(string(out)), err
+}
+
+// k8sAge formats the age of an object like kubectl does: with two units when the
+// second one is significant, as "5m30s" or "3d4h", and with one otherwise.
+func k8sAge(d time.Duration) string {
+	seconds := int(d.Seconds())
+	minutes, hours := seconds/60, seconds/3600
+	switch {
+	case seconds < 0:
+		return "0s"
+	case seconds < 120:
+		return fmt.Sprintf("%ds", seconds)
+	case minutes < 10:
+		return fmt.Sprintf("%dm%ds", minutes, seconds%60)
+	case minutes < 180:
+		return fmt.Sprintf("%dm", minutes)
+	case hours < 8:
+		return fmt.Sprintf("%dh%dm", hours, minutes%60)
+	case hours < 48:
+		return fmt.Sprintf("%dh", hours)
+	case hours < 192:
+		return fmt.Sprintf("%dd%dh", hours/24, hours%24)
+	}
+	return fmt.Sprintf("%dd", hours/24)
+}
+
+// Render renders the pods as a table, with their status in green when they run or
+// succeeded, in red when they failed and in orange otherwise.
+func (pods K8sPods) Render() Data {
+	rows := make([][]string, len(pods))
+	colors := make([]string, len(pods))
+	for i, pod := range pods {
+		rows[i] = []string{pod.Namespace, pod.Name, pod.Ready, pod.Status, fmt.Sprint(pod.Restarts), pod.Age, pod.Node}
+		switch {
+		case pod.Status == "Running" || pod.Status == "Succeeded":
+			colors[i] = "#080"
+		case pod.Status == "Failed" || strings.Contains(pod.Status, "Err") || strings.Contains(pod.Status, "BackOff"):
+			colors[i] = "#d00"
+		default:
+			colors[i] = "#c60"
+		}
+	}
+	return k8sTable([]string{"Namespace", "Name", "Ready", "Status", "Restarts", "Age", "Node"}, rows, 3, colors)
+}
+
+// Render renders the deployments as a table, with the ready replicas in red when some
+// are missing.
+func (deployments K8sDeployments) Render() Data {
+	rows := make([][]string, len(deployments))
+	colors := make([]string, len(deployments))
+	for i, d := range deployments {
+		rows[i] = []string{d.Namespace, d.Name, d.Ready, fmt.Sprint(d.UpToDate), fmt.Sprint(d.Available), d.Age}
+		if parts := strings.SplitN(d.Ready, "/", 2); len(parts) == 2 && parts[0] != parts[1] {
+			colors[i] = "#d00"
+		}
+	}
+	return k8sTable([]string{"Namespace", "Name", "Ready", "Up-to-date", "Available", "Age"}, rows, 2, colors)
+}
+
+// k8sTable renders rows as an HTML table and as aligned text, like kubectl does. The
+// column colored has the color of its row, if any.
+func k8sTable(header []string, rows [][]string, colored int, colors []string) Data {
+	var b strings.Builder
+	b.WriteString("<table><thead><tr>")
+	for _, h := range header {
+		b.WriteString("<th>" + html.EscapeString(h) + "</th>")
+	}
+	b.WriteString("</tr></thead><tbody>")
+	for i, row := range rows {
+		b.WriteString("<tr>")
+		for j, cell := range row {
+			if j == colored && colors[i] != "" {
+				fmt.Fprintf(&b, `<td style="color:%s">%s</td>`, colors[i], html.EscapeString(cell))
+			} else {
+				b.WriteString("<td>" + html.EscapeString(cell) + "</td>")
+			}
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</tbody></table>")
+
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for j, cell := range row {
+			if len(cell) > widths[j] {
+				widths[j] = len(cell)
+			}
+		}
+	}
+	var text strings.Builder
+	for _, row := range append([][]string{header}, rows...) {
+		var line strings.Builder
+		for j, cell := range row {
+			fmt.Fprintf(&line, "%-*s   ", widths[j], cell)
+		}
+		text.WriteString(strings.TrimRight(line.String(), " ") + "\n")
+	}
+	return MakeData3(MIMETypeHTML, strings.TrimSuffix(text.String(), "\n"), b.String())
+}
+
+// evalKubectxMagic implements `%kubectx [context]`, which switches the context of the
+// k8s package for the session, or lists the contexts.
+func evalKubectxMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := kubectxSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	out, err := kubectl(nil, "config", "get-contexts", "--output", "name")
+	if err != nil {
+		return fmt.Errorf("%%kubectx: %v", err)
+	}
+	contexts := strings.Fields(string(out))
+
+	if name := parsed.arg(0); name != "" {
+		for _, c := range contexts {
+			if c == name {
+				kernel.session.kubeContext = name
+				fmt.Fprintf(outerr.out, "Switched to context %s.\n", name)
+				return nil
+			}
+		}
+		return fmt.Errorf("%%kubectx: no context %s in the kubeconfig", name)
+	}
+
+	current, err := currentKubeContext(kernel.session)
+	if err != nil {
+		return fmt.Errorf("%%kubectx: %v", err)
+	}
+	for _, c := range contexts {
+		marker := "  "
+		if c == current {
+			marker = "* "
+		}
+		fmt.Fprintln(outerr.out, marker+c)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeKubectl is a kubectl answering the commands of the k8s package with fixtures, and
+// logging its arguments to the file args.
+const fakeKubectl = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/args"
+case "$*" in
+*"config get-contexts"*) printf 'dev\nprod\n' ;;
+*"config current-context"*) echo dev ;;
+*"get pods"*) cat <<'EOF'
+{"items": [
+  {"metadata": {"name": "web-1", "namespace": "shop", "creationTimestamp": "2020-01-01T00:00:00Z"},
+   "spec": {"nodeName": "node-a"},
+   "status": {"phase": "Running", "containerStatuses": [
+     {"ready": true, "restartCount": 1, "state": {"running": {}}},
+     {"ready": false, "restartCount": 2, "state": {"waiting": {"reason": "CrashLoopBackOff"}}}]}},
+  {"metadata": {"name": "job-1", "namespace": "shop", "creationTimestamp": "2020-01-01T23:55:30Z"},
+   "status": {"phase": "Pending"}},
+  {"metadata": {"name": "web-0", "namespace": "shop", "creationTimestamp": "2019-12-20T00:00:00Z",
+                "deletionTimestamp": "2020-01-02T00:00:00Z"},
+   "spec": {"nodeName": "node-b"},
+   "status": {"phase": "Running", "containerStatuses": [{"ready": true, "state": {"running": {}}}]}}
+]}
+EOF
+;;
+*"get deployments"*) cat <<'EOF'
+{"items": [
+  {"metadata": {"name": "web", "namespace": "shop", "creationTimestamp": "2020-01-01T21:00:00Z"},
+   "spec": {"replicas": 3}, "status": {"readyReplicas": 2, "updatedReplicas": 3, "availableReplicas": 2}},
+  {"metadata": {"name": "cron", "namespace": "shop", "creationTimestamp": "2020-01-01T21:00:00Z"},
+   "spec": {}, "status": {"readyReplicas": 1, "updatedReplicas": 1, "availableReplicas": 1}}
+]}
+EOF
+;;
+*) echo "error: unknown command $*" >&2; exit 1 ;;
+esac
+`
+
+// useFakeKubectl makes the k8s package run fakeKubectl, and returns the file it logs
+// its arguments to and a function restoring kubectl.
+func useFakeKubectl(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "kubectl")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	path := filepath.Join(dir, "kubectl")
+	if err := ioutil.WriteFile(path, []byte(fakeKubectl), 0755); err != nil {
+		t.Fatalf("\t%s Could not write kubectl: %v.", failure, err)
+	}
+	old := kubectlCommand
+	kubectlCommand = path
+	return filepath.Join(dir, "args"), func() {
+		kubectlCommand = old
+		os.RemoveAll(dir)
+	}
+}
+
+// TestK8sLists tests listing and rendering pods and deployments.
+func TestK8sLists(t *testing.T) {
+	args, restore := useFakeKubectl(t)
+	defer restore()
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	t.Logf("Should list the pods with their status like kubectl.")
+
+	pods, err := listPods(NewSession(), "shop", now)
+	if err != nil {
+		t.Fatalf("\t%s Could not list the pods: %v.", failure, err)
+	}
+	want := K8sPods{
+		{Namespace: "shop", Name: "web-1", Ready: "1/2", Status: "CrashLoopBackOff", Restarts: 3, Age: "24h", Node: "node-a"},
+		{Namespace: "shop", Name: "job-1", Ready: "0/0", Status: "Pending", Age: "4m30s"},
+		{Namespace: "shop", Name: "web-0", Ready: "1/1", Status: "Terminating", Age: "13d", Node: "node-b"},
+	}
+	if !reflect.DeepEqual(pods, want) {
+		t.Fatalf("\t%s Expected %+v, got %+v.", failure, want, pods)
+	}
+	html := pods.Render().Data[MIMETypeHTML].(string)
+	if !strings.Contains(html, `<td style="color:#d00">CrashLoopBackOff</td>`) || !strings.Contains(html, `<td style="color:#c60">Pending</td>`) {
+		t.Fatalf("\t%s Expected the status in color, got %s.", failure, html)
+	}
+	t.Logf("\t%s Listed %d pods.", success, len(pods))
+
+	t.Logf("Should list the deployments, highlighting those missing replicas.")
+
+	deployments, err := listDeployments(NewSession(), "*", now)
+	if err != nil {
+		t.Fatalf("\t%s Could not list the deployments: %v.", failure, err)
+	}
+	if len(deployments) != 2 || deployments[0].Ready != "2/3" || deployments[0].Age != "3h0m" || deployments[1].Ready != "1/1" {
+		t.Fatalf("\t%s Expected the replicas of the deployments, got %+v.", failure, deployments)
+	}
+	data := deployments.Render()
+	if text := data.Data[MIMETypeText].(string); !strings.HasPrefix(text, "Namespace   Name   Ready   Up-to-date") ||
+		!strings.Contains(data.Data[MIMETypeHTML].(string), `<td style="color:#d00">2/3</td>`) {
+		t.Fatalf("\t%s Expected a table, got %v.", failure, data.Data)
+	}
+	t.Logf("\t%s Listed %d deployments.", success, len(deployments))
+
+	logged, _ := ioutil.ReadFile(args)
+	if want := "get pods --output json --namespace shop\nget deployments --output json --all-namespaces\n"; string(logged) != want {
+		t.Fatalf("\t%s Expected kubectl to be run with %q, got %q.", failure, want, logged)
+	}
+}
+
+// TestKubectxMagic tests switching contexts, and using them from preloaded cells.
+func TestKubectxMagic(t *testing.T) {
+	args, restore := useFakeKubectl(t)
+	defer restore()
+	config := defaultConfig()
+	config.Preload = []string{"k8s"}
+	kernel, err := newKernel(config)
+	if err != nil {
+		t.Fatalf("\t%s Could not create the kernel: %v.", failure, err)
+	}
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+
+	t.Logf("Should list the contexts, marking the current one.")
+
+	if _, err := kernel.doEvalGop(outerr, "%kubectx"); err != nil || out.String() != "* dev\n  prod\n" {
+		t.Fatalf("\t%s Expected the contexts, got %q (%v).", failure, out.String(), err)
+	}
+	t.Logf("\t%s Listed them.", success)
+
+	t.Logf("Should switch the context of the session.")
+
+	out.Reset()
+	if _, err := kernel.doEvalGop(outerr, "%kubectx prod"); err != nil || out.String() != "Switched to context prod.\n" {
+		t.Fatalf("\t%s Expected the context to be switched, got %q (%v).", failure, out.String(), err)
+	}
+	os.Remove(args)
+	vals, err := kernel.doEvalGop(outerr, "pods := k8s.Pods(\"\")\nk8s.Context() + \" \" + pods[0].Name")
+	if err != nil || len(vals) != 1 || vals[0] != "prod web-1" {
+		t.Fatalf("\t%s Expected the pods of prod, got %v (%v).", failure, vals, err)
+	}
+	logged, _ := ioutil.ReadFile(args)
+	if want := "--context prod get pods --output json\n"; string(logged) != want {
+		t.Fatalf("\t%s Expected kubectl to be run with %q, got %q.", failure, want, logged)
+	}
+	t.Logf("\t%s Switched it.", success)
+
+	t.Logf("Should refuse unknown contexts and report the errors of kubectl.")
+
+	if _, err := kernel.doEvalGop(outerr, "%kubectx staging"); err == nil || !strings.Contains(err.Error(), "no context staging") {
+		t.Fatalf("\t%s Expected staging to be refused, got %v.", failure, err)
+	}
+	if _, err := kernel.doEvalGop(outerr, `_ = k8s.Kubectl("top", "pods")`); err == nil || !strings.Contains(err.Error(), "error: unknown command --context prod top pods") {
+		t.Fatalf("\t%s Expected the error of kubectl, got %v.", failure, err)
+	}
+	t.Logf("\t%s Refused them.", success)
+}
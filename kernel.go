@@ -2,21 +2,21 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"runtime"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-zeromq/zmq4"
-	"github.com/goplus/gop/repl"
+	"github.com/goplus/gop/token"
+	"github.com/wangfenjin/gopyter/wire"
 	"golang.org/x/xerrors"
 
 	// gop lib
@@ -54,7 +54,10 @@ type SocketGroup struct {
 	StdinSocket   Socket
 	IOPubSocket   Socket
 	HBSocket      Socket
-	Key           []byte
+	Key           *signingKey
+
+	// readStdin waits for the next message received on the stdin socket.
+	readStdin func() ([][]byte, error)
 }
 
 // KernelLanguageInfo holds information about the language that this kernel executes code in.
@@ -82,6 +85,7 @@ type kernelInfo struct {
 	LanguageInfo          kernelLanguageInfo `json:"language_info"`
 	Banner                string             `json:"banner"`
 	HelpLinks             []helpLink         `json:"help_links"`
+	SupportedFeatures     []string           `json:"supported_features"`
 }
 
 // shutdownReply encodes a boolean indication of shutdown/restart.
@@ -103,22 +107,47 @@ func (s *Socket) RunWithSocket(run func(socket zmq4.Socket) error) error {
 }
 
 type Kernel struct {
-	rr *repl.REPL
+	session *Session
+	config  KernelConfig
+}
+
+// newKernel creates a kernel with an empty session.
+func newKernel(config KernelConfig) (*Kernel, error) {
+	secrets, err := newSecretStore(config.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	session := NewSession()
+	session.secrets = secrets
+	for _, name := range config.Preload {
+		session.preload += "import \"" + name + "\"\n"
+	}
+	for _, pattern := range config.Redact {
+		if err := session.redactor.addPattern(pattern); err != nil {
+			return nil, xerrors.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+	}
+	return &Kernel{session, config}, nil
 }
 
 // runKernel is the main entry point to start the kernel.
-func runKernel(connectionFile string) {
-	rr := repl.New()
+func runKernel(connectionFile string, config KernelConfig) {
+	kernel, err := newKernel(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kernel.serve(connectionFile)
+}
 
+// serve runs the kernel on the connection described by connectionFile until it is shut
+// down.
+func (kernel *Kernel) serve(connectionFile string) {
 	// Parse the connection info.
-	var connInfo ConnectionInfo
-
-	connData, err := ioutil.ReadFile(connectionFile)
+	connInfo, err := readConnectionInfo(connectionFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	if err = json.Unmarshal(connData, &connInfo); err != nil {
+	if connectionFilePath, err = resolvePath(connectionFile); err != nil {
 		log.Fatal(err)
 	}
 
@@ -127,9 +156,13 @@ func runKernel(connectionFile string) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	sockets.reloadOnHangup(connectionFile)
+	sockets.record(messageLog)
 
 	// TODO connect all channel handlers to a WaitGroup to ensure shutdown before returning from runKernel.
 
+	handleInterrupts()
+
 	// Start up the heartbeat handler.
 	startHeartbeat(sockets.HBSocket, &sync.WaitGroup{})
 
@@ -160,11 +193,22 @@ func runKernel(connectionFile string) {
 		}
 	}
 
+	// The main shell handles the requests of the shell channel not sent to a subshell.
+	startShell("", kernel.handleShellMsg)
+
 	go poll(shell, sockets.ShellSocket.Socket)
 	go poll(stdin, sockets.StdinSocket.Socket)
 	go poll(ctl, sockets.ControlSocket.Socket)
 
-	kernel := Kernel{rr}
+	// The replies to input requests are read by the cell waiting for them. Late replies
+	// are skipped by the next cell asking for input.
+	sockets.readStdin = func() ([][]byte, error) {
+		v, ok := <-stdin
+		if !ok {
+			return nil, errors.New("the stdin socket is closed")
+		}
+		return v.Msg.Frames, v.Err
+	}
 
 	// Start a message receiving loop.
 	for {
@@ -176,17 +220,7 @@ func runKernel(connectionFile string) {
 				continue
 			}
 
-			msg, ids, err := WireMsgToComposedMsg(v.Msg.Frames, sockets.Key)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-
-			kernel.handleShellMsg(msgReceipt{msg, ids, sockets})
-
-		case <-stdin:
-			// TODO Handle stdin socket.
-			continue
+			kernel.handleMessage("shell", v.Msg.Frames, sockets)
 
 		case v := <-ctl:
 			if v.Err != nil {
@@ -194,13 +228,7 @@ func runKernel(connectionFile string) {
 				return
 			}
 
-			msg, ids, err := WireMsgToComposedMsg(v.Msg.Frames, sockets.Key)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-
-			kernel.handleShellMsg(msgReceipt{msg, ids, sockets})
+			kernel.handleMessage("control", v.Msg.Frames, sockets)
 		}
 	}
 }
@@ -217,27 +245,27 @@ func prepareSockets(connInfo ConnectionInfo) (SocketGroup, error) {
 
 	// Create the shell socket, a request-reply socket that may receive messages from multiple frontend for
 	// code execution, introspection, auto-completion, etc.
-	sg.ShellSocket.Socket = zmq4.NewRouter(ctx)
+	sg.ShellSocket.Socket = newRebindableSocket(func() zmq4.Socket { return zmq4.NewRouter(ctx) })
 	sg.ShellSocket.Lock = &sync.Mutex{}
 
 	// Create the control socket. This socket is a duplicate of the shell socket where messages on this channel
 	// should jump ahead of queued messages on the shell socket.
-	sg.ControlSocket.Socket = zmq4.NewRouter(ctx)
+	sg.ControlSocket.Socket = newRebindableSocket(func() zmq4.Socket { return zmq4.NewRouter(ctx) })
 	sg.ControlSocket.Lock = &sync.Mutex{}
 
 	// Create the stdin socket, a request-reply socket used to request user input from a front-end. This is analogous
 	// to a standard input stream.
-	sg.StdinSocket.Socket = zmq4.NewRouter(ctx)
+	sg.StdinSocket.Socket = newRebindableSocket(func() zmq4.Socket { return zmq4.NewRouter(ctx) })
 	sg.StdinSocket.Lock = &sync.Mutex{}
 
 	// Create the iopub socket, a publisher for broadcasting data like stdout/stderr output, displaying execution
 	// results or errors, kernel status, etc. to connected subscribers.
-	sg.IOPubSocket.Socket = zmq4.NewPub(ctx)
+	sg.IOPubSocket.Socket = newRebindableSocket(func() zmq4.Socket { return zmq4.NewPub(ctx) })
 	sg.IOPubSocket.Lock = &sync.Mutex{}
 
 	// Create the heartbeat socket, a request-reply socket that only allows alternating recv-send (request-reply)
 	// calls. It should echo the byte strings it receives to let the requester know the kernel is still alive.
-	sg.HBSocket.Socket = zmq4.NewRep(ctx)
+	sg.HBSocket.Socket = newRebindableSocket(func() zmq4.Socket { return zmq4.NewRep(ctx) })
 	sg.HBSocket.Lock = &sync.Mutex{}
 
 	// Bind the sockets.
@@ -268,11 +296,67 @@ func prepareSockets(connInfo ConnectionInfo) (SocketGroup, error) {
 	}
 
 	// Set the message signing key.
-	sg.Key = []byte(connInfo.Key)
+	sg.Key = &signingKey{key: []byte(connInfo.Key)}
 
 	return sg, nil
 }
 
+// handleMessage decodes a message received on channel and handles it. The requests of
+// the shell channel are queued in the shell they are sent to, and handled by it.
+// Malformed messages are replied to with an error.
+func (kernel *Kernel) handleMessage(channel string, frames [][]byte, sockets SocketGroup) {
+	span := startSpan(nil, "jupyter message")
+	span.setAttribute("jupyter.channel", channel)
+
+	decode := startSpan(span, "decode")
+	msg, ids, err := wire.Decode(frames, sockets.Key.get())
+	decode.setError(err)
+	decode.end()
+	if err != nil {
+		span.setError(err)
+		span.end()
+		replyMalformed(msgReceipt{msg, ids, sockets, nil}, err)
+		return
+	}
+
+	span.setName("jupyter " + msg.Header.MsgType)
+	span.setAttribute("jupyter.msg_type", msg.Header.MsgType)
+	span.setAttribute("jupyter.msg_id", msg.Header.MsgID)
+	if channel == "control" {
+		// The replies go back on the channel of the request.
+		sockets.ShellSocket = sockets.ControlSocket
+	}
+	receipt := msgReceipt{msg, ids, sockets, span}
+	if channel == "shell" {
+		if err := dispatchShellMsg(receipt); err != nil {
+			replyUnknownSubshell(receipt, err)
+		}
+		return
+	}
+	kernel.handleShellMsg(receipt)
+	span.end()
+}
+
+// replyMalformed logs a message that could not be decoded, and replies to it with an
+// error if it is a request whose header could be decoded. Messages with an invalid
+// signature are ignored, as the protocol requires.
+func replyMalformed(receipt msgReceipt, err error) {
+	log.Printf("Ignoring a malformed message: %v\n", err)
+	msgType := receipt.Msg.Header.MsgType
+	if errors.Is(err, wire.ErrInvalidSignature) || !strings.HasSuffix(msgType, "_request") {
+		return
+	}
+	reply := strings.TrimSuffix(msgType, "_request") + "_reply"
+	if err := receipt.Reply(reply, map[string]interface{}{
+		"status":    "error",
+		"ename":     "MalformedMessage",
+		"evalue":    err.Error(),
+		"traceback": []string{},
+	}); err != nil {
+		log.Printf("Error replying to %s: %v\n", msgType, err)
+	}
+}
+
 // handleShellMsg responds to a message on the shell ROUTER socket.
 func (kernel *Kernel) handleShellMsg(receipt msgReceipt) {
 	// Tell the front-end that the kernel is working and when finished notify the
@@ -285,6 +369,11 @@ func (kernel *Kernel) handleShellMsg(receipt msgReceipt) {
 			log.Printf("Error publishing kernel status 'idle': %v\n", err)
 		}
 	}()
+	if usesInterpreter(receipt.Msg.Header.MsgType) {
+		interpreterLock.Lock()
+		defer interpreterLock.Unlock()
+	}
+	defer kernel.recoverCrash(receipt)
 
 	switch receipt.Msg.Header.MsgType {
 	case "kernel_info_request":
@@ -292,13 +381,57 @@ func (kernel *Kernel) handleShellMsg(receipt msgReceipt) {
 			log.Fatal(err)
 		}
 	case "complete_request":
-		if err := handleCompleteRequest(receipt); err != nil {
+		if err := kernel.handleCompleteRequest(receipt); err != nil {
+			log.Fatal(err)
+		}
+	case "inspect_request":
+		if err := kernel.handleInspectRequest(receipt); err != nil {
+			log.Fatal(err)
+		}
+	case "is_complete_request":
+		if err := handleIsCompleteRequest(receipt); err != nil {
 			log.Fatal(err)
 		}
 	case "execute_request":
 		if err := kernel.handleExecuteRequest(receipt); err != nil {
 			log.Fatal(err)
 		}
+	case "comm_info_request":
+		if err := handleCommInfoRequest(receipt); err != nil {
+			log.Fatal(err)
+		}
+	case "comm_open":
+		if err := kernel.handleCommOpen(receipt); err != nil {
+			log.Fatal(err)
+		}
+	case "comm_msg":
+		handleCommMsg(receipt)
+	case "comm_close":
+		handleCommClose(receipt)
+	case "format_request":
+		if err := handleFormatRequest(receipt); err != nil {
+			log.Fatal(err)
+		}
+	case "create_subshell_request":
+		if err := kernel.handleCreateSubshellRequest(receipt); err != nil {
+			log.Fatal(err)
+		}
+	case "delete_subshell_request":
+		if err := handleDeleteSubshellRequest(receipt); err != nil {
+			log.Fatal(err)
+		}
+	case "list_subshell_request":
+		if err := handleListSubshellRequest(receipt); err != nil {
+			log.Fatal(err)
+		}
+	case "queue_request":
+		if err := handleQueueRequest(receipt); err != nil {
+			log.Fatal(err)
+		}
+	case "interrupt_request":
+		if err := handleInterruptRequest(receipt); err != nil {
+			log.Fatal(err)
+		}
 	case "shutdown_request":
 		handleShutdownRequest(receipt)
 	default:
@@ -313,16 +446,20 @@ func sendKernelInfo(receipt msgReceipt) error {
 			ProtocolVersion:       ProtocolVersion,
 			Implementation:        "gopyter",
 			ImplementationVersion: Version,
-			Banner:                fmt.Sprintf("Go kernel: gopyter - v%s", Version),
+			Banner:                currentVersion().banner(),
 			LanguageInfo: kernelLanguageInfo{
-				Name:          "go+",
-				Version:       runtime.Version(),
-				FileExtension: ".go",
+				Name:           "go+",
+				Version:        runtime.Version(),
+				MIMEType:       "text/x-gop",
+				FileExtension:  ".gop",
+				PygmentsLexer:  "go",
+				CodeMirrorMode: "gop",
 			},
 			HelpLinks: []helpLink{
 				{Text: "Go+", URL: "https://goplus.org/"},
 				{Text: "gopyter", URL: "https://github.com/wangfenjin/gopyter"},
 			},
+			SupportedFeatures: []string{"kernel subshells"},
 		},
 	)
 }
@@ -334,8 +471,10 @@ func (kernel *Kernel) handleExecuteRequest(receipt msgReceipt) error {
 	// Extract the data from the request.
 	reqcontent := receipt.Msg.Content.(map[string]interface{})
 	code := reqcontent["code"].(string)
-	silent := reqcontent["silent"].(bool)
+	silent, _ := reqcontent["silent"].(bool)
+	cellID := cellIDOf(receipt.Msg)
 
+	started := time.Now()
 	if !silent {
 		ExecCounter++
 	}
@@ -365,26 +504,97 @@ func (kernel *Kernel) handleExecuteRequest(receipt msgReceipt) error {
 	}
 	os.Stderr = wErr
 
+	// A crash escaping the evaluation is recovered by the caller, which must find the
+	// streams of the kernel restored and no cell running.
+	defer func() {
+		if r := recover(); r != nil {
+			setCellRunning(false)
+			kernel.session.span, kernel.session.display, kernel.session.updateDisplay = nil, nil, nil
+			kernel.session.input = nil
+			wOut.Close()
+			os.Stdout = oldStdout
+			wErr.Close()
+			os.Stderr = oldStderr
+			panic(r)
+		}
+	}()
+
 	var writersWG sync.WaitGroup
 	writersWG.Add(2)
 
-	jupyterStdOut := JupyterStreamWriter{StreamStdout, &receipt}
-	jupyterStdErr := JupyterStreamWriter{StreamStderr, &receipt}
-	outerr := OutErr{&jupyterStdOut, &jupyterStdErr}
+	// Both streams share a limiter so the output limits apply to the cell as a whole.
+	limiter := newOutputLimiter(kernel.config)
+	redactor := kernel.session.redactor
+	throttle := newIOPubThrottle(kernel.config.IOPubRateLimit, receipt.PublishWriteStream)
+	jupyterStdOut := JupyterStreamWriter{StreamStdout, &receipt, limiter, redactor, throttle, ""}
+	jupyterStdErr := JupyterStreamWriter{StreamStderr, &receipt, limiter, redactor, throttle, ""}
+	var audit *cellAudit
+	if !silent {
+		audit = kernel.startAudit(receipt.Msg.Header.Username, cellID, code)
+	}
+	outerr := audit.tee(OutErr{&jupyterStdOut, &jupyterStdErr})
 
 	// Forward all data written to stdout/stderr to the front-end.
 	go func() {
 		defer writersWG.Done()
-		io.Copy(&jupyterStdOut, rOut)
+		io.Copy(outerr.out, rOut)
 	}()
 
 	go func() {
 		defer writersWG.Done()
-		io.Copy(&jupyterStdErr, rErr)
+		io.Copy(outerr.err, rErr)
 	}()
 
+	if kernel.config.Lint && !silent {
+		if warnings := lintCell(code); len(warnings) != 0 {
+			if err := receipt.PublishDisplayData(lintData(warnings)); err != nil {
+				log.Printf("Error publishing lint warnings: %v\n", err)
+			}
+		}
+	}
+
 	// eval
-	vals, executionErr := doEvalGop(kernel.rr, outerr, code)
+	receipt.Span.setAttribute("gop.code_length", len(code))
+	if cellID != "" {
+		receipt.Span.setAttribute("jupyter.cell_id", cellID)
+	}
+	kernel.session.span = receipt.Span
+	if !silent {
+		kernel.session.display = func(data Data) {
+			throttle.flush()
+			if err := receipt.PublishDisplayData(data); err != nil {
+				log.Printf("Error publishing display data: %v\n", err)
+			}
+		}
+		kernel.session.updateDisplay = func(data Data) {
+			throttle.flush()
+			if err := receipt.PublishUpdateDisplayData(data); err != nil {
+				log.Printf("Error publishing display data: %v\n", err)
+			}
+		}
+	}
+	if allowStdin, _ := reqcontent["allow_stdin"].(bool); allowStdin && !silent {
+		kernel.session.input = receipt.RequestInput
+	}
+	evalCode := code
+	if kernel.config.Reactive {
+		// Cells are re-executed often in reactive mode, so let them declare their
+		// variables again.
+		evalCode = kernel.session.redeclare(code)
+	}
+	var vals []interface{}
+	executionErr := kernel.confirm(audit)
+	var duration time.Duration
+	if executionErr == nil {
+		evalStarted := time.Now()
+		setCellRunning(true)
+		vals, executionErr = kernel.doEvalGop(outerr, evalCode)
+		setCellRunning(false)
+		duration = time.Since(evalStarted)
+	}
+	kernel.session.span, kernel.session.display, kernel.session.updateDisplay = nil, nil, nil
+	kernel.session.input = nil
+	receipt.Span.setError(executionErr)
 
 	// Close and restore the streams.
 	wOut.Close()
@@ -395,10 +605,48 @@ func (kernel *Kernel) handleExecuteRequest(receipt msgReceipt) error {
 
 	// Wait for the writers to finish forwarding the data.
 	writersWG.Wait()
+	for _, writer := range []*JupyterStreamWriter{&jupyterStdOut, &jupyterStdErr} {
+		if err := writer.flush(); err != nil {
+			log.Printf("Error publishing output: %v\n", err)
+		}
+	}
+	throttle.finish()
+
+	if err := kernel.finishAudit(audit, vals, executionErr); err != nil {
+		log.Printf("Error writing the audit log: %v\n", err)
+		receipt.PublishWriteStream(StreamStderr, fmt.Sprintf("Could not write the audit log: %v\n", err))
+	}
+
+	// Offer the full output of a truncated cell to the front-end's pager.
+	if payload := limiter.finish(); payload != nil {
+		content["payload"] = payload
+	}
+	if payload := kernel.session.takePayloads(); payload != nil && !silent {
+		previous, _ := content["payload"].([]interface{})
+		content["payload"] = append(previous, payload...)
+	}
+
+	if !silent {
+		kernel.session.history.record(ExecCounter, code, vals)
+		recordStats(code, executionErr)
+	}
+	if !silent {
+		// Failing cells may have changed the watched values too.
+		kernel.publishWatches(receipt)
+	}
+	if executionErr == nil {
+		kernel.session.deps.record(ExecCounter, cellID, code)
+		kernel.publishDeps(receipt)
+		if data, ok := kernel.unusedAdvisory(); ok && !silent {
+			if err := receipt.PublishDisplayData(data); err != nil {
+				log.Printf("Error publishing unused names: %v\n", err)
+			}
+		}
+	}
 
 	if executionErr == nil {
 		// if the only non-nil value should be auto-rendered graphically, render it
-		data := kernel.autoRenderResults(vals)
+		data := kernel.session.redactor.redactData(kernel.autoRenderResults(vals))
 
 		content["status"] = "ok"
 		content["user_expressions"] = make(map[string]string)
@@ -409,40 +657,125 @@ func (kernel *Kernel) handleExecuteRequest(receipt msgReceipt) error {
 				log.Printf("Error publishing execution result: %v\n", err)
 			}
 		}
+	} else if expectErr, ok := executionErr.(*expectationError); ok {
+		// Show the failed expectations as red blocks rather than as a traceback.
+		content["status"] = "error"
+		content["ename"] = "ExpectationFailed"
+		content["evalue"] = kernel.session.redactor.redact(expectErr.Error())
+		content["traceback"] = []string{}
+
+		if !silent {
+			data := kernel.session.redactor.redactData(autoRender(expectErr))
+			if err := receipt.PublishDisplayData(data); err != nil {
+				log.Printf("Error publishing failed expectations: %v\n", err)
+			}
+		}
 	} else {
 		content["status"] = "error"
 		content["ename"] = "ERROR"
-		content["evalue"] = executionErr.Error()
-		content["traceback"] = nil
+		evalue := kernel.session.redactor.redact(executionErr.Error())
+		content["evalue"] = evalue
+
+		// Point out how to fix common mistakes, and offer a cell importing the missing
+		// package.
+		suggestions, importPath := kernel.session.errorSuggestions(executionErr)
+		if importPath != "" && !silent {
+			kernel.session.setNextInput("%autoimport "+importPath+"\n"+code, false)
+			payload, _ := content["payload"].([]interface{})
+			content["payload"] = append(payload, kernel.session.takePayloads()...)
+		}
 
-		if err := receipt.PublishExecutionError(executionErr.Error(), []string{executionErr.Error()}); err != nil {
+		traceback := cellTraceback(ExecCounter, cellID, evalue, suggestions...)
+		content["traceback"] = traceback
+		if err := receipt.PublishExecutionError(evalue, traceback, cellID); err != nil {
 			log.Printf("Error publishing execution error: %v\n", err)
 		}
 	}
+	if executionErr != nil {
+		if cellID != "" {
+			content["cell_id"] = cellID
+		}
+		log.Printf("%s failed: %s\n", cellLocation(ExecCounter, cellID), content["evalue"])
+	}
+
+	if data, ok := kernel.memoryWarning(); ok && !silent {
+		if err := receipt.PublishDisplayData(data); err != nil {
+			log.Printf("Error publishing memory warning: %v\n", err)
+		}
+	}
+
+	if executionErr == nil && !silent && kernel.config.Reactive {
+		kernel.reexecuteStale(receipt)
+	}
 
 	// Send the output back to the notebook.
-	return receipt.Reply("execute_reply", content)
+	if err := receipt.ReplyWithMetadata("execute_reply", content, executionMetadata(started, duration)); err != nil {
+		return err
+	}
+
+	// Like Run All expects, the cells queued after one that failed are not executed,
+	// unless it was sent with stop_on_error=false.
+	if executionErr != nil && !silent && newQueuedCell(receipt).StopOnError {
+		abortQueuedCells(receipt.Msg.Header.SubshellID)
+	}
+	return nil
 }
 
-// LinerUI implements repl.UI interface.
-type LinerUI struct {
-	result []interface{}
+// cellIDOf returns the id of the notebook cell executed by msg, which JupyterLab and
+// Notebook send as cellId in the metadata of execute_request, or "" if there is none.
+func cellIDOf(msg ComposedMsg) string {
+	if id, ok := msg.Metadata["cellId"].(string); ok {
+		return id
+	}
+	id, _ := msg.Metadata["cell_id"].(string)
+	return id
 }
 
-// SetPrompt is required by repl.UI interface.
-func (u *LinerUI) SetPrompt(prompt string) {
-	// do nothiner
+// cellLocation names the cell executed as In[count], with its id if it is known, in
+// the tracebacks and the logs of its errors.
+func cellLocation(count int, cellID string) string {
+	if cellID == "" {
+		return fmt.Sprintf("Cell In[%d]", count)
+	}
+	return fmt.Sprintf("Cell In[%d], id %s", count, cellID)
+}
+
+// cellTraceback returns the traceback of the error evalue of a cell, followed by
+// hints. When the id of the cell is known, the traceback starts with its location, so
+// front-end extensions can link the error to the cell even after the notebook is
+// reordered; the positions in evalue are relative to the cell.
+func cellTraceback(count int, cellID string, evalue string, hints ...string) []string {
+	var trace []string
+	if cellID != "" {
+		trace = append(trace, cellLocation(count, cellID)+":")
+	}
+	return append(append(trace, evalue), hints...)
 }
 
-// Printf is required by repl.UI interface.
-func (u *LinerUI) Printf(format string, a ...interface{}) {
-	u.result = a
+// executionMetadata returns the metadata of the execute_reply to a cell handled from
+// started, whose evaluation took duration: when it started and completed, as ISO 8601
+// timestamps, which JupyterLab records as the execution time of the cell, and how many
+// seconds the evaluation took.
+func executionMetadata(started time.Time, duration time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"started":   started.UTC().Format(time.RFC3339Nano),
+		"completed": time.Now().UTC().Format(time.RFC3339Nano),
+		"duration":  duration.Seconds(),
+	}
 }
 
-func doEvalGop(rr *repl.REPL, outerr OutErr, code string) (val []interface{}, err error) {
+func (kernel *Kernel) doEvalGop(outerr OutErr, code string) (val []interface{}, err error) {
 	// Capture a panic from the evaluation if one occurs and store it in the `err` return parameter.
+	// Runtime errors cannot come from user code, which the session recovers from itself, so
+	// they are escalated as crashes of the kernel.
 	defer func() {
 		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(&kernelCrash{r, debug.Stack()})
+			}
+			if _, ok := r.(*kernelCrash); ok {
+				panic(r)
+			}
 			var ok bool
 			if err, ok = r.(error); !ok {
 				err = errors.New(fmt.Sprint(r))
@@ -450,12 +783,79 @@ func doEvalGop(rr *repl.REPL, outerr OutErr, code string) (val []interface{}, er
 		}
 	}()
 
-	code = evalSpecialCommands(outerr, code)
+	code = stripDangerMarkers(stripGraderMarkers(code))
 
-	ui := &LinerUI{}
-	rr.SetUI(ui)
-	rr.Run(code)
-	return ui.result, nil
+	if name, args, body, ok := splitCellMagic(code); ok {
+		return kernel.evalCellMagic(outerr, name, args, body)
+	}
+	return kernel.evalCell(outerr, code, kernel.config.Language)
+}
+
+// evalCell evaluates the code of a cell without cell magic, as Go+ or as Go depending
+// on language, which may be detected. It must be called by doEvalGop, which recovers
+// from the panics of the shell commands and line magics.
+func (kernel *Kernel) evalCell(outerr OutErr, code string, language string) ([]interface{}, error) {
+	if language == languageAuto {
+		language = detectLanguage(stripSpecialCommands(code))
+	} else if language == languageGo {
+		if err := checkGoCell(stripSpecialCommands(code)); err != nil {
+			return nil, err
+		}
+	}
+
+	code = kernel.evalSpecialCommands(outerr, code)
+
+	var vals []interface{}
+	var err error
+	if kernel.config.Echo || kernel.config.Interactivity == interactivityAll || kernel.config.VerboseAssign {
+		vals, err = kernel.evalStatements(outerr, code)
+	} else {
+		vals, err = kernel.evalChunk(outerr, code)
+	}
+	if err == nil && suppressesResult(code) {
+		return nil, nil
+	}
+	return vals, err
+}
+
+// suppressesResult reports whether code ends with a semicolon, which, like in IPython,
+// hides the result of the cell.
+func suppressesResult(code string) bool {
+	tokens := scanTokens(code)
+	if len(tokens) == 0 {
+		return false
+	}
+	last := tokens[len(tokens)-1]
+	return last.tok == token.SEMICOLON && last.end > last.start
+}
+
+// evalCode evaluates Go+ code, free of magics and shell commands, in the session.
+func (kernel *Kernel) evalCode(code string) ([]interface{}, error) {
+	if err := checkUnterminated(code); err != nil {
+		return nil, err
+	}
+
+	vals, err := kernel.session.Eval(instrumentInterrupts(kernel.session.instrumentTraces(rewriteResultRefs(rewriteGopSyntax(code)))))
+	if expectErr := kernel.session.takeExpectationError(); expectErr != nil && err == nil {
+		return vals, expectErr
+	}
+	return vals, err
+}
+
+// handleIsCompleteRequest sends an is_complete_reply telling the front-end whether the
+// code typed so far can be executed or needs more lines.
+func handleIsCompleteRequest(receipt msgReceipt) error {
+	reqcontent := receipt.Msg.Content.(map[string]interface{})
+	code := reqcontent["code"].(string)
+
+	content := make(map[string]interface{})
+	status, indent := isComplete(code)
+	content["status"] = status
+	if status == completeIncomplete {
+		content["indent"] = indent
+	}
+
+	return receipt.Reply("is_complete_reply", content)
 }
 
 // handleShutdownRequest sends a "shutdown" message.
@@ -538,7 +938,7 @@ func startHeartbeat(hbSocket Socket, wg *sync.WaitGroup) (shutdown chan struct{}
 }
 
 // find and execute special commands in code, remove them from returned string
-func evalSpecialCommands(outerr OutErr, code string) string {
+func (kernel *Kernel) evalSpecialCommands(outerr OutErr, code string) string {
 	lines := strings.Split(code, "\n")
 	stop := false
 	for i, line := range lines {
@@ -548,6 +948,9 @@ func evalSpecialCommands(outerr OutErr, code string) string {
 			case '$':
 				evalShellCommand(outerr, line)
 				lines[i] = ""
+			case '%':
+				kernel.evalLineMagic(outerr, line)
+				lines[i] = ""
 			default:
 				// if a line is NOT a special command,
 				// stop processing special commands
@@ -563,6 +966,10 @@ func evalSpecialCommands(outerr OutErr, code string) string {
 
 // execute shell command. line must start with '$'
 func evalShellCommand(outerr OutErr, line string) {
+	if sandboxed {
+		panic(errors.New("shell commands are not permitted in the sandbox"))
+	}
+
 	args := strings.Fields(line[1:])
 	if len(args) <= 0 {
 		return
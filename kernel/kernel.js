@@ -0,0 +1,57 @@
+// kernel.js is loaded by the notebook front-end when a gopyter kernel starts. It
+// registers the "gop" CodeMirror mode advertised in the kernel_info_reply, which
+// extends the Go mode with the Go+ builtins and literals.
+define(['codemirror/lib/codemirror', 'codemirror/mode/go/go'], function (CodeMirror) {
+    'use strict';
+
+    var builtins = ['println', 'print', 'printf', 'echo', 'newRange'];
+
+    function defineGopMode() {
+        CodeMirror.defineMode('gop', function (config) {
+            var goMode = CodeMirror.getMode(config, 'go');
+
+            return {
+                startState: function (basecolumn) {
+                    return CodeMirror.startState(goMode, basecolumn);
+                },
+                copyState: function (state) {
+                    return CodeMirror.copyState(goMode, state);
+                },
+                token: function (stream, state) {
+                    var style = goMode.token(stream, state);
+                    if (style === 'number') {
+                        // Rational and big number literals: 1r, 3/4r, 120bi.
+                        stream.match(/^(r|bi)\b/);
+                    } else if (style === 'variable' && builtins.indexOf(stream.current()) >= 0) {
+                        style = 'builtin';
+                    } else if (style === 'operator' && stream.current() === '=') {
+                        // The "=>" of a lambda.
+                        stream.eat('>');
+                    }
+                    return style;
+                },
+                indent: function (state, textAfter) {
+                    return goMode.indent(state, textAfter);
+                },
+                electricChars: goMode.electricChars,
+                closeBrackets: goMode.closeBrackets,
+                fold: 'brace',
+                blockCommentStart: '/*',
+                blockCommentEnd: '*/',
+                lineComment: '//'
+            };
+        });
+
+        CodeMirror.defineMIME('text/x-gop', 'gop');
+        CodeMirror.modeInfo.push({
+            name: 'Go+',
+            mime: 'text/x-gop',
+            mode: 'gop',
+            ext: ['gop']
+        });
+    }
+
+    return {
+        onload: defineGopMode
+    };
+});
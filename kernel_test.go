@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/go-zeromq/zmq4"
+	"github.com/wangfenjin/gopyter/wire"
 )
 
 const (
@@ -61,7 +62,7 @@ func runTest(m *testing.M) int {
 	iopubPort = connInfo.IOPubPort
 
 	// Start the kernel.
-	go runKernel(connectionFile)
+	go runKernel(connectionFile, defaultConfig())
 
 	return m.Run()
 }
@@ -384,9 +385,9 @@ func (client *testJupyterClient) sendShellRequest(t *testing.T, request Composed
 
 	frames = append(frames, []byte("<IDS|MSG>"))
 
-	reqMsgParts, err := request.ToWireMsg([]byte(connectionKey))
+	reqMsgParts, err := wire.Encode(request, []byte(connectionKey))
 	if err != nil {
-		t.Fatalf("\t%s request.ToWireMsg: %s", failure, err)
+		t.Fatalf("\t%s wire.Encode: %s", failure, err)
 	}
 	frames = append(frames, reqMsgParts...)
 
@@ -408,7 +409,7 @@ func (client *testJupyterClient) recvShellReply(t *testing.T, timeout time.Durat
 			t.Fatalf("\t%s Shell socket RecvMessageBytes: %s", failure, err)
 		}
 
-		msgParsed, _, err := WireMsgToComposedMsg(repMsgParts.Frames, []byte(connectionKey))
+		msgParsed, _, err := wire.Decode(repMsgParts.Frames, []byte(connectionKey))
 		if err != nil {
 			t.Fatalf("\t%s Could not parse wire message: %s", failure, err)
 		}
@@ -441,7 +442,7 @@ func (client *testJupyterClient) recvIOSub(t *testing.T, timeout time.Duration)
 			t.Fatalf("\t%s IOPub socket RecvMessageBytes: %s", failure, err)
 		}
 
-		msgParsed, _, err := WireMsgToComposedMsg(repMsgParts.Frames, []byte(connectionKey))
+		msgParsed, _, err := wire.Decode(repMsgParts.Frames, []byte(connectionKey))
 		if err != nil {
 			t.Fatalf("\t%s Could not parse wire message: %s", failure, err)
 		}
@@ -629,3 +630,109 @@ func testOutputStream(t *testing.T, codeIn string) ([]string, []string) {
 
 	return stdout, stderr
 }
+
+// TestSuppressResult tests hiding the result of cells ending with a semicolon.
+func TestSuppressResult(t *testing.T) {
+	cases := []struct {
+		code  string
+		shown bool
+	}{
+		{"x := 2\nx * 2", true},
+		{"x := 2\nx * 2;", false},
+		{"x := 2\nx * 2; // hidden\n", false},
+		{"x := 2; x * 2", true},
+	}
+
+	t.Logf("Should hide the result of cells ending with a semicolon.")
+
+	for _, tc := range cases {
+		kernel := Kernel{NewSession(), defaultConfig()}
+		vals, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, tc.code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %q failed: %v.", failure, tc.code, err)
+		}
+		if shown := len(vals) != 0; shown != tc.shown {
+			t.Fatalf("\t%s Expected the result of %q to be shown: %v, got %v.", failure, tc.code, tc.shown, vals)
+		}
+		t.Logf("\t%s Evaluated %q.", success, tc.code)
+	}
+}
+
+// TestDeclarationCells tests the cells declaring functions and types, which the
+// statements of earlier cells and the prelude of the session must not precede.
+func TestDeclarationCells(t *testing.T) {
+	cells := []struct {
+		code string
+		want string
+	}{
+		{"func double(x int) int { return 2 * x }", ""},
+		{"double(21)", "42"},
+		{"y := 3", ""},
+		{"type point struct{ X int }\nfunc (p point) scaled() int { return double(p.X) * y }\npoint{2}.scaled()", "12"},
+		{"func() { y++ }()\ny", "4"},
+		{"expect := y + 1\nexpect", "5"},
+	}
+
+	t.Logf("Should call the functions declared by earlier cells.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	for _, cell := range cells {
+		vals, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, cell.code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %q failed: %v.", failure, cell.code, err)
+		}
+		got := ""
+		if len(vals) != 0 {
+			got = fmt.Sprint(vals...)
+		}
+		if got != cell.want {
+			t.Fatalf("\t%s Expected %q to return %q, got %q.", failure, cell.code, cell.want, got)
+		}
+		t.Logf("\t%s Evaluated %q.", success, cell.code)
+	}
+}
+
+// TestExecutionMetadata tests the timing metadata of execute_reply messages.
+func TestExecutionMetadata(t *testing.T) {
+	t.Logf("Should report when a cell started and completed, and how long it was evaluated.")
+
+	started := time.Now().Add(-2 * time.Second)
+	metadata := executionMetadata(started, 1500*time.Millisecond)
+
+	begin, err := time.Parse(time.RFC3339Nano, metadata["started"].(string))
+	if err != nil || !begin.Equal(started) {
+		t.Fatalf("\t%s Expected the start %v, got %v: %v.", failure, started, metadata["started"], err)
+	}
+	end, err := time.Parse(time.RFC3339Nano, metadata["completed"].(string))
+	if err != nil || !end.After(begin) {
+		t.Fatalf("\t%s Expected a completion after the start, got %v: %v.", failure, metadata["completed"], err)
+	}
+	if duration := metadata["duration"].(float64); duration != 1.5 {
+		t.Fatalf("\t%s Expected a duration of 1.5s, got %v.", failure, duration)
+	}
+	t.Logf("\t%s Reported %v.", success, metadata)
+}
+
+// TestCellTraceback tests locating the errors of cells by their ids.
+func TestCellTraceback(t *testing.T) {
+	cases := []struct {
+		metadata map[string]interface{}
+		hints    []string
+		want     []string
+	}{
+		{nil, nil, []string{"2:5: undefined: x"}},
+		{map[string]interface{}{"cellId": "3b7c"}, nil, []string{"Cell In[4], id 3b7c:", "2:5: undefined: x"}},
+		{map[string]interface{}{"cell_id": "9f01"}, []string{"Did you mean y?"}, []string{"Cell In[4], id 9f01:", "2:5: undefined: x", "Did you mean y?"}},
+	}
+
+	t.Logf("Should start the tracebacks of cells with their ids when the front-end sent them.")
+
+	for _, tc := range cases {
+		cellID := cellIDOf(ComposedMsg{Metadata: tc.metadata})
+		got := cellTraceback(4, cellID, "2:5: undefined: x", tc.hints...)
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Fatalf("\t%s Expected %q for %v, got %q.", failure, tc.want, tc.metadata, got)
+		}
+		t.Logf("\t%s Got %q.", success, got)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	cellMagics["go"] = evalGoMagic
+	cellMagics["gop"] = evalGopMagic
+	documentMagic(goSyntax)
+	documentMagic(gopSyntax)
+}
+
+// The languages of cells. Go+ is a superset of Go, and both are evaluated by the Go+
+// interpreter in the same session, but Go cells may not use the syntax Go+ adds, so
+// their code can be moved to Go programs unchanged.
+const (
+	languageGop = "gop"
+	languageGo  = "go"
+
+	// languageAuto detects the language of each cell: cells only using the syntax of Go
+	// are Go, the others Go+.
+	languageAuto = "auto"
+)
+
+var (
+	goSyntax = &magicSyntax{
+		name: "%%go",
+		doc:  "Evaluates the cell as Go, refusing the syntax Go+ adds to Go, such as list comprehensions or `expr!`.",
+	}
+	gopSyntax = &magicSyntax{
+		name: "%%gop",
+		doc:  "Evaluates the cell as Go+, when the kernel is configured to evaluate Go.",
+	}
+)
+
+// evalGoMagic implements `%%go`, which evaluates the cell as Go.
+func evalGoMagic(kernel *Kernel, outerr OutErr, args string, body string) ([]interface{}, error) {
+	if _, err := goSyntax.parse(args); err != nil {
+		return nil, err
+	}
+	// Keep the lines of the cell, for the errors.
+	return kernel.evalCell(outerr, "\n"+body, languageGo)
+}
+
+// evalGopMagic implements `%%gop`, which evaluates the cell as Go+.
+func evalGopMagic(kernel *Kernel, outerr OutErr, args string, body string) ([]interface{}, error) {
+	if _, err := gopSyntax.parse(args); err != nil {
+		return nil, err
+	}
+	return kernel.evalCell(outerr, "\n"+body, languageGop)
+}
+
+// detectLanguage returns the language of code, the code of a cell without its magics:
+// Go if it only uses the syntax of Go, and Go+ otherwise. Cells that do not parse are
+// Go+, for the compiler to report their errors.
+func detectLanguage(code string) string {
+	if _, ok := parseDiagnostics(rewriteGopSyntax(code)); !ok {
+		debugf("Cannot detect the language of a cell that does not parse, evaluating it as Go+")
+		return languageGop
+	}
+	if found := goPlusSyntax(code); len(found) != 0 {
+		debugf("Detected a Go+ cell, using %s", strings.Join(found, ", "))
+		return languageGop
+	}
+	debugf("Detected a Go cell, which is also valid Go+")
+	return languageGo
+}
+
+// checkGoCell reports the syntax of Go+ that is not Go in code, the code of a cell
+// without its magics. Like the cells of Go+, Go cells may have statements outside of
+// functions. Code that does not parse is left to the compiler to report.
+func checkGoCell(code string) error {
+	found := goPlusSyntax(code)
+	if len(found) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the Go cell uses syntax of Go+ (use %%%%gop to evaluate it as Go+):\n%s", strings.Join(found, "\n"))
+}
+
+// goPlusSyntax returns the uses of the syntax of Go+ that is not Go in code, with their
+// positions.
+func goPlusSyntax(code string) []string {
+	var found []string
+	rewritten, offsets := rewriteBigIntLiterals(code)
+	for _, offset := range offsets {
+		line, column := lineColumn(code, offset)
+		found = append(found, fmt.Sprintf("%d:%d: big integer literal", line, column))
+	}
+	code, lines := rewriteCommandCalls(rewriteComprehensions(rewritten))
+	for _, line := range lines {
+		found = append(found, fmt.Sprintf("%d: call without parentheses", line))
+	}
+
+	fset := token.NewFileSet()
+	pkgs, _ := parser.Parse(fset, "", code, 0)
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			report := func(node ast.Node, what string) {
+				line, column := cellPosition(fset, f, code, node.Pos())
+				found = append(found, fmt.Sprintf("%d:%d: %s", line, column, what))
+			}
+
+			// Composite literals may only leave out their type within another one.
+			nested := map[ast.Node]bool{}
+			inspectAST(f, func(n ast.Node) bool {
+				lit, ok := n.(*ast.CompositeLit)
+				if !ok {
+					return true
+				}
+				for _, elt := range lit.Elts {
+					if kv, ok := elt.(*ast.KeyValueExpr); ok {
+						nested[kv.Key], nested[kv.Value] = true, true
+					} else {
+						nested[elt] = true
+					}
+				}
+				return true
+			})
+
+			inspectAST(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.SliceLit:
+					report(n, "slice literal without a type")
+				case *ast.CompositeLit:
+					if n.Type == nil && !nested[n] {
+						report(n, "composite literal without a type")
+					}
+				case *ast.ListComprehensionExpr, *ast.MapComprehensionExpr:
+					report(n, "comprehension")
+				case *ast.ForPhraseStmt:
+					report(n, "for <- loop")
+				case *ast.ErrWrapExpr:
+					report(n, "error handling with "+n.Tok.String())
+				case *ast.TernaryExpr:
+					report(n, "conditional expression")
+				case *ast.BasicLit:
+					if n.Kind == token.RAT {
+						report(n, "rational literal")
+					}
+				}
+				return true
+			})
+		}
+	}
+	return found
+}
+
+// cellPosition maps pos, in the code parsed by the parser of Go+, back to a line and a
+// column of the cell.
+func cellPosition(fset *token.FileSet, f *ast.File, code string, pos token.Pos) (line, column int) {
+	offset := cellOffset(fset, f, pos)
+	if offset < 0 || offset > len(code) {
+		return 0, 0
+	}
+	return lineColumn(code, offset)
+}
+
+// cellOffset maps pos, in the code parsed by the parser of Go+, back to an offset in
+// the cell: the parser prefixes the code of a cell with a package clause and wraps the
+// statements starting at the first one in a main function.
+func cellOffset(fset *token.FileSet, f *ast.File, pos token.Pos) int {
+	const pkg, fn = len("package main;"), len(" func main(){")
+	offset := fset.Position(pos).Offset - pkg
+	if f.NoEntrypoint {
+		for _, decl := range f.Decls {
+			if main, ok := decl.(*ast.FuncDecl); ok && main.Recv == nil && main.Name.Name == "main" {
+				if start := fset.Position(main.Pos()).Offset - pkg - 1; offset >= start+fn {
+					offset -= fn
+				}
+			}
+		}
+	}
+	return offset
+}
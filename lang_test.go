@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestGoCells tests evaluating cells as Go or as Go+ with %%go and %%gop.
+func TestGoCells(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+
+	cases := []struct {
+		code    string
+		result  interface{}
+		errText string // a part of the expected error
+	}{
+		{"%%go\nx := []int{1, 2}\nm := map[string]int{\"a\": 1}\nlen(x) + len(m)", 3, ""},
+		{"%%go\nys := [y * 2 for y <- [1, 2]]", nil, "2:7: comprehension\n2:23: slice literal without a type"},
+		{"%%go\nn := 0\nfor i <- 1:3 {\n\tn += i\n}", nil, "3:1: for <- loop"},
+		{"%%go\nz := {\"a\": 1}", nil, "2:6: composite literal without a type"},
+		{"%%go\nr := 1r", nil, "2:6: rational literal"},
+		{"%%go\nprintln \"hi\"\nb := 2bi", nil, "3:6: big integer literal\n2: call without parentheses"},
+		{"%%gop\nlen([y * 2 for y <- [1, 2]])", 2, ""},
+		{"len([y * 2 for y <- [1, 2]])", 2, ""},
+	}
+
+	t.Logf("Should refuse the syntax of Go+ in Go cells.")
+
+	for _, tc := range cases {
+		vals, err := kernel.doEvalGop(outerr, tc.code)
+		if tc.errText != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.errText) {
+				t.Fatalf("\t%s Expected %q to fail with %q, got %v.", failure, tc.code, tc.errText, err)
+			}
+		} else if err != nil || len(vals) != 1 || vals[0] != tc.result {
+			t.Fatalf("\t%s Expected %q to evaluate to %v, got %v (%v).", failure, tc.code, tc.result, vals, err)
+		}
+		t.Logf("\t%s Evaluated %q.", success, tc.code)
+	}
+
+	if err := checkGoCell(`m := map[string][]int{"a": {1}}`); err != nil {
+		t.Fatalf("\t%s Expected the nested literal without a type to be accepted, got %v.", failure, err)
+	}
+
+	t.Logf("Should evaluate cells as Go when configured to.")
+
+	kernel.config.Language = languageGo
+	if _, err := kernel.doEvalGop(outerr, "%lint off\nys := [1, 2]"); err == nil || !strings.Contains(err.Error(), "2:7: slice literal without a type") {
+		t.Fatalf("\t%s Expected the cell to be refused, got %v.", failure, err)
+	}
+	if vals, err := kernel.doEvalGop(outerr, "%%gop\nlen([1, 2])"); err != nil || len(vals) != 1 || vals[0] != 2 {
+		t.Fatalf("\t%s Expected the %%%%gop cell to evaluate to 2, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Evaluated the cells as Go.", success)
+}
+
+// TestDetectLanguage tests detecting the language of cells.
+func TestDetectLanguage(t *testing.T) {
+	cases := []struct {
+		code     string
+		language string
+	}{
+		{"x := []int{1, 2}\nlen(x)", languageGo},
+		{"ys := [y * 2 for y <- [1, 2]]", languageGop},
+		{"println \"hi\"", languageGop},
+		{"x := (", languageGop},
+	}
+
+	t.Logf("Should detect whether cells are Go or Go+.")
+
+	for _, tc := range cases {
+		if language := detectLanguage(tc.code); language != tc.language {
+			t.Fatalf("\t%s Expected %q to be %s, got %s.", failure, tc.code, tc.language, language)
+		}
+		t.Logf("\t%s Detected %q as %s.", success, tc.code, tc.language)
+	}
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	kernel.config.Language = languageAuto
+	if vals, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, "len([y * 2 for y <- [1, 2]])"); err != nil || len(vals) != 1 || vals[0] != 2 {
+		t.Fatalf("\t%s Expected the Go+ cell to evaluate to 2, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Evaluated a Go+ cell without %%%%gop.", success)
+}
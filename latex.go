@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("Math", newLatexMath, execMath),
+	)
+}
+
+// LatexMath is a formula in LaTeX, as returned by Math. It renders as display math.
+type LatexMath struct {
+	Latex string
+}
+
+func newLatexMath(latex string) *LatexMath {
+	return &LatexMath{Latex: latex}
+}
+
+func execMath(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, newLatexMath(args[0].(string)))
+}
+
+func (m *LatexMath) String() string {
+	return m.Latex
+}
+
+func (m *LatexMath) Render() Data {
+	return Math(m.Latex)
+}
+
+// Matrix is implemented by the matrices of gonum (gonum.org/v1/gonum/mat), which are
+// rendered as LaTeX matrices without depending on gonum.
+type Matrix = interface {
+	Dims() (r, c int)
+	At(i, j int) float64
+}
+
+// maxMatrixSide is the number of rows or columns of a matrix above which only its
+// first and last ones are rendered.
+const maxMatrixSide = 12
+
+// matrixLatex formats m as a bmatrix. The middle rows and columns of large matrices are
+// elided with dots.
+func matrixLatex(m Matrix) string {
+	rows, cols := m.Dims()
+	rowIndexes, colIndexes := matrixIndexes(rows), matrixIndexes(cols)
+
+	var b strings.Builder
+	b.WriteString(`\begin{bmatrix}`)
+	for ri, i := range rowIndexes {
+		if ri > 0 {
+			b.WriteString(` \\ `)
+		}
+		for ci, j := range colIndexes {
+			if ci > 0 {
+				b.WriteString(" & ")
+			}
+			switch {
+			case i < 0 && j < 0:
+				b.WriteString(`\ddots`)
+			case i < 0:
+				b.WriteString(`\vdots`)
+			case j < 0:
+				b.WriteString(`\cdots`)
+			default:
+				b.WriteString(strconv.FormatFloat(m.At(i, j), 'g', 6, 64))
+			}
+		}
+	}
+	b.WriteString(`\end{bmatrix}`)
+	return b.String()
+}
+
+// matrixIndexes returns the indexes of the rows or columns of a matrix to render, with
+// -1 standing for the elided ones.
+func matrixIndexes(n int) []int {
+	var indexes []int
+	for i := 0; i < n; i++ {
+		if n > maxMatrixSide && i == maxMatrixSide/2 {
+			indexes = append(indexes, -1)
+			i = n - maxMatrixSide/2
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// denseMatrix implements Matrix like the matrices of gonum.
+type denseMatrix struct {
+	rows, cols int
+}
+
+func (m denseMatrix) Dims() (int, int)    { return m.rows, m.cols }
+func (m denseMatrix) At(i, j int) float64 { return float64(i*m.cols+j) / 2 }
+
+// TestMatrixLatex tests rendering matrices as LaTeX.
+func TestMatrixLatex(t *testing.T) {
+	cases := []struct {
+		m     denseMatrix
+		latex string
+	}{
+		{denseMatrix{2, 2}, `$$\begin{bmatrix}0 & 0.5 \\ 1 & 1.5\end{bmatrix}$$`},
+		{denseMatrix{1, 3}, `$$\begin{bmatrix}0 & 0.5 & 1\end{bmatrix}$$`},
+	}
+
+	t.Logf("Should render matrices as bmatrix.")
+
+	for _, tc := range cases {
+		if latex := autoRender(tc.m).Data[MIMETypeLatex]; latex != tc.latex {
+			t.Fatalf("\t%s Expected %s, got %s.", failure, tc.latex, latex)
+		}
+		t.Logf("\t%s Rendered %s.", success, tc.latex)
+	}
+
+	t.Logf("Should elide the middle of large matrices.")
+
+	latex := matrixLatex(denseMatrix{20, 30})
+	rows := strings.Split(latex, `\\`)
+	if len(rows) != 13 || strings.Count(rows[0], "&") != 12 || !strings.Contains(rows[6], `\vdots & \vdots`) || !strings.Contains(rows[6], `\ddots`) {
+		t.Fatalf("\t%s Expected 12 of the rows and columns with dots, got %s.", failure, latex)
+	}
+	if !strings.Contains(rows[12], "299.5") {
+		t.Fatalf("\t%s Expected the last element in the last row, got %s.", failure, rows[12])
+	}
+	t.Logf("\t%s Elided the middle of the matrix.", success)
+
+	t.Logf("Should render formulas returned by Math.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	vals, err := kernel.session.Eval(`Math("e^{i\\pi} + 1 = 0")`)
+	if err != nil {
+		t.Fatalf("\t%s Evaluating Math failed: %v.", failure, err)
+	}
+	if latex := kernel.autoRenderResults(vals).Data[MIMETypeLatex]; latex != `$$e^{i\pi} + 1 = 0$$` {
+		t.Fatalf("\t%s Expected display math, got %v.", failure, latex)
+	}
+	t.Logf("\t%s Rendered the formula.", success)
+}
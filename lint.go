@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/format"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	lineMagics["lint"] = evalLintMagic
+	documentMagic(lintSyntax)
+}
+
+var lintSyntax = &magicSyntax{
+	name:  "%lint",
+	usage: []string{"[on|off]"},
+	doc:   "Enables or disables checking cells for likely mistakes before executing them, or reports whether it is enabled.",
+	args:  []magicParam{{name: "on|off", help: "enables or disables linting", optional: true}},
+}
+
+// The analyzers of go vet and staticcheck work on Go syntax trees checked by go/types,
+// which Go+ cells are not, so the kernel implements the checks that matter most in
+// notebooks itself, on the Go+ syntax tree. They are named after the analyzers they
+// mirror.
+
+// lintWarning is a problem found in a cell by a lint check.
+type lintWarning struct {
+	Line    int // in the cell
+	Check   string
+	Message string
+}
+
+func (w lintWarning) String() string {
+	return fmt.Sprintf("line %d: %s (%s)", w.Line, w.Message, w.Check)
+}
+
+// lintCheck inspects a node of the syntax tree of a cell and reports its problems.
+type lintCheck func(l *linter, n ast.Node)
+
+var lintChecks = map[string]lintCheck{
+	"assign":      lintSelfAssign,
+	"printf":      lintPrintf,
+	"unreachable": lintUnreachable,
+	"SA4000":      lintIdenticalOperands,
+	"SA9003":      lintEmptyBranch,
+}
+
+// linter holds the state of the checks of a cell.
+type linter struct {
+	fset     *token.FileSet
+	check    string // the name of the running check
+	offset   int    // the line of the cell the code starts at, minus one
+	warnings []lintWarning
+}
+
+func (l *linter) report(pos token.Pos, format string, args ...interface{}) {
+	l.warnings = append(l.warnings, lintWarning{
+		Line:    l.fset.Position(pos).Line + l.offset,
+		Check:   l.check,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// text returns the source of node, formatted.
+func (l *linter) text(node ast.Node) string {
+	var b bytes.Buffer
+	if err := format.Node(&b, l.fset, node); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// lintCell runs the lint checks on the Go+ code of a cell. Code that does not parse is
+// left to the compiler to report.
+func lintCell(code string) []lintWarning {
+	l := &linter{fset: token.NewFileSet()}
+	if _, _, body, ok := splitCellMagic(code); ok {
+		code = body
+		l.offset = 1
+	}
+	code = stripSpecialCommands(code)
+
+	pkgs, err := parser.Parse(l.fset, "", code, 0)
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(lintChecks))
+	for name := range lintChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, name := range names {
+				l.check = name
+				inspectAST(f, func(n ast.Node) bool {
+					lintChecks[name](l, n)
+					return true
+				})
+			}
+		}
+	}
+	sort.SliceStable(l.warnings, func(i, j int) bool { return l.warnings[i].Line < l.warnings[j].Line })
+	return l.warnings
+}
+
+// lintSelfAssign reports assignments of variables to themselves.
+func lintSelfAssign(l *linter, n ast.Node) {
+	assign, ok := n.(*ast.AssignStmt)
+	if !ok || assign.Tok != token.ASSIGN || len(assign.Lhs) != len(assign.Rhs) {
+		return
+	}
+	for i, lhs := range assign.Lhs {
+		if x := l.text(lhs); x != "" && x == l.text(assign.Rhs[i]) {
+			l.report(assign.Pos(), "self-assignment of %s to %s", x, x)
+		}
+	}
+}
+
+// printfFuncs maps the printf-like functions to the index of their format argument.
+var printfFuncs = map[string]int{
+	"fmt.Printf": 0, "fmt.Sprintf": 0, "fmt.Errorf": 0, "fmt.Fprintf": 1,
+	"log.Printf": 0, "log.Fatalf": 0, "log.Panicf": 0,
+}
+
+// printFuncs are the print functions that do not take a format.
+var printFuncs = map[string]bool{
+	"fmt.Println": true, "fmt.Print": true, "fmt.Sprintln": true, "fmt.Sprint": true,
+	"log.Println": true, "log.Print": true, "println": true,
+}
+
+// lintPrintf reports calls of printf-like functions whose format does not match their
+// arguments, and calls of print functions with a format.
+func lintPrintf(l *linter, n ast.Node) {
+	call, ok := n.(*ast.CallExpr)
+	if !ok || call.Ellipsis.IsValid() {
+		return
+	}
+	name := l.text(call.Fun)
+
+	if printFuncs[name] {
+		for _, arg := range call.Args {
+			if s, ok := stringLiteral(arg); ok && strings.Contains(strings.ReplaceAll(s, "%%", ""), "%") && printfVerbs(s) > 0 {
+				l.report(call.Pos(), "%s call has possible formatting directive in %q", name, s)
+				return
+			}
+		}
+		return
+	}
+
+	index, ok := printfFuncs[name]
+	if !ok || len(call.Args) <= index {
+		return
+	}
+	format, ok := stringLiteral(call.Args[index])
+	if !ok {
+		return
+	}
+	verbs := printfVerbs(format)
+	if verbs < 0 {
+		return
+	}
+	if args := len(call.Args) - index - 1; verbs != args {
+		l.report(call.Pos(), "%s format %q reads %d args, but the call has %d", name, format, verbs, args)
+	}
+}
+
+func stringLiteral(x ast.Expr) (string, bool) {
+	lit, ok := x.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// printfVerbs returns the number of arguments a printf format reads, or -1 if it uses
+// explicit argument indexes, which are not checked.
+func printfVerbs(format string) int {
+	n := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		for i++; i < len(format); i++ {
+			c := format[i]
+			if c == '[' {
+				return -1
+			}
+			if c == '*' {
+				n++
+				continue
+			}
+			if strings.IndexByte("+-# 0123456789.", c) < 0 {
+				if c != '%' {
+					n++
+				}
+				break
+			}
+		}
+	}
+	return n
+}
+
+// lintUnreachable reports statements following a return, a panic or a jump in the
+// same block.
+func lintUnreachable(l *linter, n ast.Node) {
+	block, ok := n.(*ast.BlockStmt)
+	if !ok {
+		return
+	}
+	for i, stmt := range block.List[:maxInt(len(block.List)-1, 0)] {
+		if terminates(stmt) {
+			if _, labeled := block.List[i+1].(*ast.LabeledStmt); !labeled {
+				l.report(block.List[i+1].Pos(), "unreachable code")
+			}
+			return
+		}
+	}
+}
+
+func terminates(stmt ast.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		return stmt.Tok != token.FALLTHROUGH
+	case *ast.ExprStmt:
+		call, ok := stmt.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		fun, ok := call.Fun.(*ast.Ident)
+		return ok && fun.Name == "panic"
+	}
+	return false
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// lintIdenticalOperands reports binary expressions with the same expression on both
+// sides, which are always true, false or zero. == and != are left out, since they are
+// used to check for NaN.
+func lintIdenticalOperands(l *linter, n ast.Node) {
+	binary, ok := n.(*ast.BinaryExpr)
+	if !ok {
+		return
+	}
+	switch binary.Op {
+	case token.LAND, token.LOR, token.SUB, token.QUO, token.REM, token.XOR, token.AND, token.OR, token.AND_NOT,
+		token.LSS, token.LEQ, token.GTR, token.GEQ:
+	default:
+		return
+	}
+	if x := l.text(binary.X); x != "" && x == l.text(binary.Y) {
+		l.report(binary.Pos(), "identical expressions on the left and right side of '%s'", binary.Op)
+	}
+}
+
+// lintEmptyBranch reports if statements with an empty branch.
+func lintEmptyBranch(l *linter, n ast.Node) {
+	stmt, ok := n.(*ast.IfStmt)
+	if !ok {
+		return
+	}
+	if len(stmt.Body.List) == 0 && stmt.Else == nil {
+		l.report(stmt.Pos(), "empty branch")
+	}
+	if block, ok := stmt.Else.(*ast.BlockStmt); ok && len(block.List) == 0 {
+		l.report(block.Pos(), "empty branch")
+	}
+}
+
+// lintData renders lint warnings as an annotation, in HTML and with ANSI colors.
+func lintData(warnings []lintWarning) Data {
+	var text, markup strings.Builder
+	markup.WriteString(`<div style="background-color:#fff8e1;border-left:4px solid #f0ad00;color:#6d4c00;` +
+		`font-family:monospace;padding:4px 8px;white-space:pre-wrap">`)
+	for _, w := range warnings {
+		fmt.Fprintf(&text, "\x1b[33mwarning: %s\x1b[0m\n", w)
+		markup.WriteString("⚠ " + html.EscapeString(w.String()) + "<br>")
+	}
+	markup.WriteString("</div>")
+	return Data{Data: MIMEMap{MIMETypeText: text.String(), MIMETypeHTML: markup.String()}}
+}
+
+// evalLintMagic implements `%lint [on|off]`, which enables or disables checking cells
+// before they are executed, or reports whether it is enabled.
+func evalLintMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := lintSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	switch parsed.arg(0) {
+	case "on":
+		kernel.config.Lint = true
+	case "off":
+		kernel.config.Lint = false
+	case "":
+	default:
+		return lintSyntax.errorf("expected on or off, got %q", args)
+	}
+	state := "off"
+	if kernel.config.Lint {
+		state = "on"
+	}
+	fmt.Fprintf(outerr.out, "Linting is %s.\n", state)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestLintCell tests the checks run on cells in lint mode.
+func TestLintCell(t *testing.T) {
+	cases := []struct {
+		code  string
+		check string
+		line  int
+	}{
+		{"x := 1\nx = x", "assign", 2},
+		{"import \"fmt\"\nfmt.Printf(\"%d %s\\n\", 1)", "printf", 2},
+		{"import \"fmt\"\nfmt.Println(\"%d\", 1)", "printf", 2},
+		{"f := func() int {\n\treturn 1\n\tprintln(\"never\")\n}", "unreachable", 3},
+		{"x := 1\nok := x > 0 && x > 0", "SA4000", 2},
+		{"x := 1\nif x > 0 {\n}", "SA9003", 2},
+		{"%%cache key=k\nx := 1\nx = x", "assign", 3},
+	}
+
+	t.Logf("Should report likely mistakes with their line.")
+
+	for _, tc := range cases {
+		warnings := lintCell(tc.code)
+		if len(warnings) != 1 || warnings[0].Check != tc.check || warnings[0].Line != tc.line {
+			t.Errorf("\t%s %q: expected a %s warning on line %d, got %v.", failure, tc.code, tc.check, tc.line, warnings)
+			continue
+		}
+		t.Logf("\t%s %q: %v", success, tc.code, warnings[0])
+	}
+
+	t.Logf("Should not report correct code.")
+
+	for _, code := range []string{
+		"import \"fmt\"\nfmt.Printf(\"%d%% %*d\\n\", 1, 2, 3)",
+		"x := 1.0\nnan := x != x",
+		"x := 1\nif x > 0 {\n\tx = 2\n}",
+	} {
+		if warnings := lintCell(code); len(warnings) != 0 {
+			t.Errorf("\t%s %q: expected no warnings, got %v.", failure, code, warnings)
+			continue
+		}
+		t.Logf("\t%s %q", success, code)
+	}
+}
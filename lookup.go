@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	lineMagics["lookup"] = evalLookupMagic
+	documentMagic(lookupSyntax)
+}
+
+var lookupSyntax = &magicSyntax{
+	name:  "%lookup",
+	usage: []string{"regexp"},
+	doc: "Searches the names visible in the session, the variables, functions and types declared by the cells " +
+		"and the names exported by the imported packages, like `strings.Split`, for those matching `regexp`, " +
+		"and lists them with their types and the cells declaring them.",
+	args: []magicParam{
+		{name: "regexp", help: "the regular expression matched by the names, like `^max` or `(?i)json`"},
+	},
+}
+
+// lookupMatch is a name visible in the session matched by %lookup.
+type lookupMatch struct {
+	Name  string
+	Kind  string // "var", "const", "func", "type" or "package"
+	Type  string
+	Count int // the execution count of the cell declaring or importing it, or 0
+}
+
+// lookup returns the names visible in the session matching re: the names declared by
+// the session, then the imported packages and the names they export, qualified by the
+// name of their package.
+func (kernel *Kernel) lookup(re *regexp.Regexp) []lookupMatch {
+	s := kernel.session
+	var matches []lookupMatch
+	if session, err := parseCell(s.source()); err == nil {
+		prelude := map[string]bool{}
+		if c, err := parseCell(sessionPrelude); err == nil {
+			c.topLevelIdents(func(ident *ast.Ident) { prelude[ident.Name] = true })
+		}
+		kinds, types := session.declarations()
+		seen := map[string]bool{}
+		session.topLevelIdents(func(ident *ast.Ident) {
+			name := ident.Name
+			if seen[name] || prelude[name] || name == "_" || strings.HasPrefix(name, "_gopyter") || !re.MatchString(name) {
+				return
+			}
+			seen[name] = true
+			m := lookupMatch{Name: name, Kind: kinds[name], Type: types[name], Count: s.deps.declaringCell(name)}
+			if m.Kind == "var" || m.Kind == "const" {
+				if vals, err := s.Peek(name); err == nil && len(vals) == 1 && vals[0] != nil {
+					m.Type = fmt.Sprintf("%T", vals[0])
+					if strings.HasPrefix(m.Type, "func(") {
+						m.Kind = "func"
+					}
+				}
+			}
+			matches = append(matches, m)
+		})
+		sort.Slice(matches, func(i, j int) bool { return matches[i].Name < matches[j].Name })
+	}
+
+	_, imports := s.declaredNames()
+	paths := make([]string, 0, len(imports))
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		count := s.deps.importingCell(p)
+		if re.MatchString(p) {
+			matches = append(matches, lookupMatch{Name: p, Kind: "package", Count: count})
+		}
+		docs, _, ok := kernel.packageDocs(p)
+		if !ok {
+			continue
+		}
+		names := make([]string, 0, len(docs.Symbols))
+		for name := range docs.Symbols {
+			// Methods are listed by %doc.
+			if !strings.Contains(name, ".") && ast.IsExported(name) && re.MatchString(path.Base(p)+"."+name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			kind, typ := declSummary(name, docs.Symbols[name].Decl)
+			matches = append(matches, lookupMatch{Name: path.Base(p) + "." + name, Kind: kind, Type: typ, Count: count})
+		}
+	}
+	return matches
+}
+
+// declarations returns the kinds of the top-level names declared by the cell, and the
+// types of its functions and types.
+func (c *parsedCell) declarations() (kinds, types map[string]string) {
+	kinds, types = make(map[string]string), make(map[string]string)
+	source := func(from, to token.Pos) string {
+		return strings.Join(strings.Fields(c.code[c.offset(from):c.offset(to)]), " ")
+	}
+	var declared func(decl ast.Decl)
+	declared = func(decl ast.Decl) {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				kinds[decl.Name.Name] = "func"
+				types[decl.Name.Name] = "func" + source(decl.Type.Params.Pos(), decl.Type.End())
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						kinds[name.Name] = "var"
+						if decl.Tok == token.CONST {
+							kinds[name.Name] = "const"
+						}
+					}
+				case *ast.TypeSpec:
+					kinds[spec.Name.Name] = "type"
+					switch spec.Type.(type) {
+					case *ast.StructType:
+						types[spec.Name.Name] = "struct"
+					case *ast.InterfaceType:
+						types[spec.Name.Name] = "interface"
+					default:
+						types[spec.Name.Name] = source(spec.Type.Pos(), spec.Type.End())
+					}
+				}
+			}
+		}
+	}
+	main := c.main()
+	for _, decl := range c.file.Decls {
+		if decl != ast.Decl(main) {
+			declared(decl)
+		}
+	}
+	c.topLevelIdents(func(ident *ast.Ident) {
+		if _, ok := kinds[ident.Name]; !ok {
+			kinds[ident.Name] = "var"
+		}
+	})
+	if main != nil {
+		for _, stmt := range main.Body.List {
+			if stmt, ok := stmt.(*ast.DeclStmt); ok {
+				declared(stmt.Decl)
+			}
+		}
+	}
+	return kinds, types
+}
+
+// declSummary returns the kind of the name declared by the declaration of a package,
+// and its type, on a line.
+func declSummary(name, decl string) (kind, typ string) {
+	line := decl
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	kind = strings.SplitN(line, " ", 2)[0]
+	switch kind {
+	case "func":
+		return kind, "func" + strings.TrimPrefix(line, "func "+name)
+	case "type":
+		typ = strings.TrimSuffix(strings.TrimPrefix(line, "type "+name+" "), " {")
+		if typ == "struct" || typ == "interface" || !strings.HasSuffix(line, "{") {
+			return kind, typ
+		}
+		return kind, ""
+	}
+	return kind, ""
+}
+
+// declaringCell returns the execution count of the last executed cell declaring name
+// at the top level, or 0.
+func (g *depGraph) declaringCell(name string) int {
+	for i := len(g.cells) - 1; i >= 0; i-- {
+		if _, _, _, ok := declarationIn(g.cells[i].code, name); ok {
+			return g.cells[i].Count
+		}
+	}
+	return 0
+}
+
+// importingCell returns the execution count of the first executed cell importing the
+// package with path importPath, or 0.
+func (g *depGraph) importingCell(importPath string) int {
+	for _, cell := range g.cells {
+		c, err := parseCell(cell.code)
+		if err != nil {
+			continue
+		}
+		for _, spec := range c.file.Imports {
+			if p, err := strconv.Unquote(spec.Path.Value); err == nil && p == importPath {
+				return cell.Count
+			}
+		}
+	}
+	return 0
+}
+
+// evalLookupMagic implements `%lookup regexp`, which lists the names visible in the
+// session matching regexp.
+func evalLookupMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := lookupSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	re, err := regexp.Compile(parsed.arg(0))
+	if err != nil {
+		return lookupSyntax.errorf("invalid regexp: %v", err)
+	}
+	matches := kernel.lookup(re)
+	if len(matches) == 0 {
+		fmt.Fprintf(outerr.out, "No name matches %s.\n", re)
+		return nil
+	}
+	rows := make([][]string, len(matches))
+	for i, m := range matches {
+		cell := ""
+		if m.Count != 0 {
+			cell = fmt.Sprintf("In[%d]", m.Count)
+		}
+		rows[i] = []string{m.Name, m.Kind, m.Type, cell}
+	}
+	kernel.display(outerr, k8sTable([]string{"Name", "Kind", "Type", "Cell"}, rows, -1, nil))
+	return nil
+}
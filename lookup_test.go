@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// TestLookup tests searching the names visible in the session.
+func TestLookup(t *testing.T) {
+	defer func(cache *docCache) { packageDocCache = cache }(packageDocCache)
+	packageDocCache = newDocCache("")
+	kernel := Kernel{NewSession(), defaultConfig()}
+	cells := []string{
+		"import \"strings\"\nmaxLen := func(words []string) int { return len(words) }\nmaxWords := 3",
+		"type maxPoint struct{ X int }\nmaxName := \"x\"",
+		"maxWords := 4",
+	}
+	for _, code := range cells[:2] {
+		if _, err := kernel.session.Eval(code); err != nil {
+			t.Fatalf("\t%s Could not evaluate %q: %v.", failure, code, err)
+		}
+	}
+	// The last cell declares maxWords again, as if the first had been edited.
+	for i, code := range cells {
+		kernel.session.deps.record(i+1, "", code)
+	}
+	cases := []struct {
+		re   string
+		want []lookupMatch
+	}{
+		{"^max", []lookupMatch{
+			{Name: "maxLen", Kind: "func", Type: "func([]string) int", Count: 1},
+			{Name: "maxName", Kind: "var", Type: "string", Count: 2},
+			{Name: "maxPoint", Kind: "type", Type: "struct", Count: 2},
+			{Name: "maxWords", Kind: "var", Type: "int", Count: 3},
+		}},
+		{`^strings\.(Split|Builder)$`, []lookupMatch{
+			{Name: "strings.Builder", Kind: "type", Type: "struct", Count: 1},
+			{Name: "strings.Split", Kind: "func", Type: "func(s, sep string) []string", Count: 1},
+		}},
+		{"^strings$", []lookupMatch{{Name: "strings", Kind: "package", Count: 1}}},
+		{"^In$|nothing", nil},
+	}
+
+	t.Logf("Should find the names declared by the cells and exported by the imported packages.")
+
+	for _, tc := range cases {
+		if got := kernel.lookup(regexp.MustCompile(tc.re)); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("\t%s Expected %s to match %+v, got %+v.", failure, tc.re, tc.want, got)
+		}
+		t.Logf("\t%s %s.", success, tc.re)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// magicSyntax documents the arguments of a magic and parses them, so that all magics
+// accept quoted strings, key=value options and switches the same way, and show their
+// usage with --help.
+type magicSyntax struct {
+	name    string       // "%name" or "%%name"
+	usage   []string     // the forms of the magic, without its name, e.g. "start [addr]"
+	doc     string       // what the magic does, in Markdown
+	args    []magicParam // the positional arguments, in order
+	options []magicParam // the key=value options and the switches
+
+	anyOptions bool // whether options that are not declared are accepted
+	verbatim   bool // whether the values of options keep their quotes
+}
+
+// magicParam is an argument, an option or a switch of a magic.
+type magicParam struct {
+	name     string
+	help     string
+	optional bool // for arguments: may be left out
+	variadic bool // for the last argument: takes the remaining words
+	rest     bool // for the last argument: takes the rest of the line as written
+	isSwitch bool // for options: given without a value, as `name` or `--name`
+}
+
+// magicArgs are the parsed arguments of a magic.
+type magicArgs struct {
+	args    []string
+	options map[string]string // switches that are given are set to "true"
+}
+
+// arg returns the i-th positional argument, or "" if it was not given.
+func (a *magicArgs) arg(i int) string {
+	if i < len(a.args) {
+		return a.args[i]
+	}
+	return ""
+}
+
+// magicSyntaxes holds the syntax of the documented magics by name, as `%name` or
+// `%%name`. Magics document themselves from the init function registering them.
+var magicSyntaxes = map[string]*magicSyntax{}
+
+func documentMagic(s *magicSyntax) {
+	magicSyntaxes[s.name] = s
+}
+
+// magicWord is a word of the arguments of a magic.
+type magicWord struct {
+	text   string // unquoted, unless the syntax is verbatim
+	key    string // the key of a key=value option or of a --switch
+	option bool
+	start  int // the offset of the word in the arguments
+}
+
+// splitMagicArgs splits the arguments of a magic into words separated by spaces.
+// Double-quoted strings are unquoted like Go strings and single-quoted strings are
+// taken literally, so that words, and the values of key=value options, can contain
+// spaces. If verbatim is true, quoted strings are kept as written.
+func splitMagicArgs(args string, verbatim bool) ([]magicWord, error) {
+	var words []magicWord
+	i := 0
+	for {
+		for i < len(args) && (args[i] == ' ' || args[i] == '\t') {
+			i++
+		}
+		if i == len(args) {
+			return words, nil
+		}
+
+		w := magicWord{start: i}
+		var b strings.Builder
+		for i < len(args) && args[i] != ' ' && args[i] != '\t' {
+			switch c := args[i]; {
+			case c == '"' || c == '\'':
+				end := i + 1
+				for end < len(args) && args[end] != c {
+					if c == '"' && args[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(args) {
+					return nil, fmt.Errorf("unterminated string %s", args[i:])
+				}
+				quoted := args[i : end+1]
+				switch {
+				case verbatim:
+					b.WriteString(quoted)
+				case c == '\'':
+					b.WriteString(quoted[1 : len(quoted)-1])
+				default:
+					s, err := unquoteMagicString(quoted)
+					if err != nil {
+						return nil, err
+					}
+					b.WriteString(s)
+				}
+				i = end + 1
+			case c == '=' && !w.option && b.Len() != 0 && i == w.start+b.Len():
+				// The key of an option is the unquoted text before the first '='.
+				w.key, w.option = strings.TrimPrefix(b.String(), "--"), true
+				b.Reset()
+				i++
+			default:
+				b.WriteByte(c)
+				i++
+			}
+		}
+		w.text = b.String()
+		if !w.option && strings.HasPrefix(args[w.start:i], "--") && len(w.text) > 2 {
+			w.key, w.option, w.text = w.text[2:], true, "true"
+		}
+		words = append(words, w)
+	}
+}
+
+// unquoteMagicString unquotes a double-quoted string, which may contain the escape
+// sequences of Go strings.
+func unquoteMagicString(quoted string) (string, error) {
+	var b strings.Builder
+	s := quoted[1 : len(quoted)-1]
+	for len(s) > 0 {
+		r, _, tail, err := strconv.UnquoteChar(s, '"')
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", quoted)
+		}
+		b.WriteRune(r)
+		s = tail
+	}
+	return b.String(), nil
+}
+
+// wantsHelp reports whether the arguments of a magic ask for its usage: they start
+// with --help or -h. Later words are left to the magic, which may take -h as a value.
+func wantsHelp(args string) bool {
+	words, err := splitMagicArgs(args, true)
+	if err != nil || len(words) == 0 {
+		return false
+	}
+	w := words[0]
+	return (w.option && w.key == "help" && w.text == "true") || (!w.option && w.text == "-h")
+}
+
+// parse parses the arguments of the magic, checking them against its syntax.
+func (s *magicSyntax) parse(args string) (*magicArgs, error) {
+	words, err := splitMagicArgs(args, s.verbatim)
+	if err != nil {
+		return nil, s.errorf("%v", err)
+	}
+
+	parsed := &magicArgs{options: map[string]string{}}
+	for i, w := range words {
+		if s.restArg() && len(parsed.args) == len(s.args)-1 {
+			parsed.args = append(parsed.args, strings.TrimSpace(args[w.start:]))
+			break
+		}
+
+		if w.option {
+			param := s.option(w.key)
+			switch {
+			case param == nil && !s.anyOptions:
+				return nil, s.errorf("unknown option %s", w.key)
+			case param != nil && param.isSwitch && w.text != "true":
+				return nil, s.errorf("%s is a switch and takes no value", w.key)
+			case !token.IsIdentifier(strings.Replace(w.key, "-", "_", -1)):
+				return nil, s.errorf("invalid option name %q", w.key)
+			}
+			parsed.options[w.key] = w.text
+			continue
+		}
+		if param := s.option(w.text); param != nil && param.isSwitch {
+			parsed.options[w.text] = "true"
+			continue
+		}
+
+		if len(parsed.args) >= len(s.args) && !s.variadicArg() {
+			return nil, s.errorf("unexpected argument %q", strings.TrimSpace(args[w.start:wordEnd(words, i, args)]))
+		}
+		parsed.args = append(parsed.args, w.text)
+	}
+
+	for i, param := range s.args {
+		if i >= len(parsed.args) && !param.optional {
+			return nil, s.errorf("missing %s", param.name)
+		}
+	}
+	return parsed, nil
+}
+
+// wordEnd returns the offset of the end of the i-th word of args.
+func wordEnd(words []magicWord, i int, args string) int {
+	if i+1 < len(words) {
+		return words[i+1].start
+	}
+	return len(args)
+}
+
+func (s *magicSyntax) option(name string) *magicParam {
+	for i := range s.options {
+		if s.options[i].name == name {
+			return &s.options[i]
+		}
+	}
+	return nil
+}
+
+func (s *magicSyntax) restArg() bool {
+	return len(s.args) != 0 && s.args[len(s.args)-1].rest
+}
+
+func (s *magicSyntax) variadicArg() bool {
+	return len(s.args) != 0 && s.args[len(s.args)-1].variadic
+}
+
+// errorf returns an error about the arguments of the magic, pointing to its usage.
+func (s *magicSyntax) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s (see %s --help)", s.name, fmt.Sprintf(format, args...), s.name)
+}
+
+// help returns the usage of the magic, as Markdown and as plain text.
+func (s *magicSyntax) help() MIMEMap {
+	var md, text strings.Builder
+	md.WriteString("```\n")
+	for _, usage := range s.usage {
+		line := strings.TrimSpace(s.name + " " + usage)
+		md.WriteString(line + "\n")
+		text.WriteString(line + "\n")
+	}
+	md.WriteString("```\n")
+	if s.doc != "" {
+		md.WriteString("\n" + s.doc + "\n")
+		text.WriteString("\n" + s.doc + "\n")
+	}
+
+	params := append(append([]magicParam(nil), s.args...), s.options...)
+	if len(params) != 0 {
+		md.WriteString("\n| | |\n|---|---|\n")
+		text.WriteString("\n")
+		for i, p := range params {
+			name := p.name
+			switch {
+			case i >= len(s.args) && !p.isSwitch:
+				name += "=<value>"
+			case i < len(s.args) && p.variadic:
+				name += "..."
+			}
+			fmt.Fprintf(&md, "| `%s` | %s |\n", name, strings.Replace(p.help, "|", `\|`, -1))
+			fmt.Fprintf(&text, "  %-16s %s\n", name, p.help)
+		}
+	}
+	return MIMEMap{MIMETypeMarkdown: md.String(), MIMETypeText: text.String()}
+}
+
+// magicHelp returns the usage of the magic called name, which is prefixed with `%`
+// or `%%`. Magics that are not documented only show how they are called.
+func magicHelp(name string) MIMEMap {
+	if s, ok := magicSyntaxes[name]; ok {
+		return s.help()
+	}
+	return (&magicSyntax{name: name, usage: []string{"[args]"}, doc: "No documentation."}).help()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseMagicArgs tests parsing the arguments of magics against their syntax.
+func TestParseMagicArgs(t *testing.T) {
+	syntax := &magicSyntax{
+		name: "%test",
+		args: []magicParam{
+			{name: "command"},
+			{name: "rest", optional: true, rest: true},
+		},
+		options: []magicParam{
+			{name: "key"},
+			{name: "refresh", isSwitch: true},
+		},
+	}
+
+	cases := []struct {
+		args    string
+		parsed  *magicArgs
+		errText string
+	}{
+		{"add", &magicArgs{[]string{"add"}, map[string]string{}}, ""},
+		{`key="a b" refresh 'it''s' x`, &magicArgs{[]string{"its", "x"}, map[string]string{"key": "a b", "refresh": "true"}}, ""},
+		{`key="tab\t" --refresh add \d+ "x  y"`, &magicArgs{[]string{"add", `\d+ "x  y"`}, map[string]string{"key": "tab\t", "refresh": "true"}}, ""},
+		{"--key=v add", &magicArgs{[]string{"add"}, map[string]string{"key": "v"}}, ""},
+		{"", nil, "%test: missing command (see %test --help)"},
+		{"add other=1", nil, ""}, // the rest of the line is taken as written
+		{"other=1 add", nil, "%test: unknown option other (see %test --help)"},
+		{"refresh=yes add", nil, "%test: refresh is a switch and takes no value (see %test --help)"},
+		{`key="a b`, nil, `%test: unterminated string "a b (see %test --help)`},
+	}
+
+	t.Logf("Should parse quoted strings, key=value options and switches.")
+
+	for _, tc := range cases {
+		parsed, err := syntax.parse(tc.args)
+		switch {
+		case tc.errText != "":
+			if err == nil || err.Error() != tc.errText {
+				t.Fatalf("\t%s Expected parsing %q to fail with %q, got %v.", failure, tc.args, tc.errText, err)
+			}
+		case err != nil:
+			t.Fatalf("\t%s Parsing %q failed: %v.", failure, tc.args, err)
+		case tc.parsed != nil && !reflect.DeepEqual(parsed, tc.parsed):
+			t.Fatalf("\t%s Expected %q to parse as %v, got %v.", failure, tc.args, tc.parsed, parsed)
+		}
+		t.Logf("\t%s Parsed %q.", success, tc.args)
+	}
+
+	t.Logf("Should refuse unexpected arguments.")
+
+	if _, err := (&magicSyntax{name: "%none"}).parse("a b"); err == nil || err.Error() != `%none: unexpected argument "a" (see %none --help)` {
+		t.Fatalf("\t%s Expected the argument to be refused, got %v.", failure, err)
+	}
+	t.Logf("\t%s Refused the argument.", success)
+}
+
+// TestMagicHelp tests showing the usage of magics with --help.
+func TestMagicHelp(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+
+	t.Logf("Should show the usage of magics in the pager instead of running them.")
+
+	for _, code := range []string{"%serve --help", "%mask -h\n1", "%%cache --help\npanic(1)"} {
+		if _, err := kernel.doEvalGop(outerr, code); err != nil {
+			t.Fatalf("\t%s Evaluating %q failed: %v.", failure, code, err)
+		}
+		payloads := kernel.session.takePayloads()
+		if len(payloads) != 1 {
+			t.Fatalf("\t%s Expected the usage in the pager, got %v.", failure, payloads)
+		}
+		data := payloads[0].(map[string]interface{})["data"].(MIMEMap)
+		name, _ := splitMagicLine(strings.TrimLeft(code, "%"))
+		if md, _ := data[MIMETypeMarkdown].(string); !strings.Contains(md, "%"+name) || !strings.Contains(md, "| `") {
+			t.Fatalf("\t%s Expected the usage of %s, got %q.", failure, name, md)
+		}
+		t.Logf("\t%s Showed the usage of %s.", success, name)
+	}
+
+	t.Logf("Should document custom magics.")
+
+	defer func() {
+		delete(lineMagics, "deploy")
+		delete(magicSyntaxes, "%deploy")
+		delete(customMagics, "%deploy")
+	}()
+	setup := `magic.RegisterLine("deploy", func(args string) error {
+	envs := magic.Args(args)
+	options := magic.Options(args)
+	println(len(envs), envs[0], options["version"], options["dry-run"])
+	return nil
+})
+magic.Describe("%deploy", "<env> [version=<v>] [--dry-run]", "Deploys the *service*.")`
+	if _, err := kernel.doEvalGop(outerr, setup); err != nil {
+		t.Fatalf("\t%s Registering the magic failed: %v.", failure, err)
+	}
+	if _, err := kernel.doEvalGop(outerr, "%deploy --help"); err != nil {
+		t.Fatalf("\t%s Showing the usage of the magic failed: %v.", failure, err)
+	}
+	payloads := kernel.session.takePayloads()
+	if len(payloads) != 1 {
+		t.Fatalf("\t%s Expected the usage in the pager, got %v.", failure, payloads)
+	}
+	want := "```\n%deploy <env> [version=<v>] [--dry-run]\n```\n\nDeploys the *service*.\n"
+	if md := payloads[0].(map[string]interface{})["data"].(MIMEMap)[MIMETypeMarkdown]; md != want {
+		t.Fatalf("\t%s Expected the usage %q, got %q.", failure, want, md)
+	}
+	if args, options := magicPositionalArgs(`prod "eu west" --dry-run version=1.2`), magicOptions("prod --dry-run version=1.2"); !reflect.DeepEqual(args, []string{"prod", "eu west"}) ||
+		!reflect.DeepEqual(options, map[string]string{"dry-run": "true", "version": "1.2"}) {
+		t.Fatalf("\t%s Expected the arguments and options of the magic, got %v and %v.", failure, args, options)
+	}
+	t.Logf("\t%s Documented the custom magic.", success)
+
+	t.Logf("Should pass -h to the magic when it is not the first argument.")
+
+	if _, err := kernel.doEvalGop(outerr, "%deploy prod -h --help"); err != nil {
+		t.Fatalf("\t%s Running the magic failed: %v.", failure, err)
+	}
+	if payloads := kernel.session.takePayloads(); len(payloads) != 0 {
+		t.Fatalf("\t%s Expected the magic to run, got the usage %v.", failure, payloads)
+	}
+	for args, want := range map[string]bool{"--help": true, " -h prod": true, "prod -h": false, "prod --help": false, "": false} {
+		if wantsHelp(args) != want {
+			t.Fatalf("\t%s Expected wantsHelp(%q) to be %v.", failure, args, want)
+		}
+	}
+	t.Logf("\t%s Ran the magic.", success)
+}
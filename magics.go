@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lineMagic handles a `%name args` line at the top of a cell. Line magics run before the
+// rest of the cell is evaluated, in the order they appear.
+type lineMagic func(kernel *Kernel, outerr OutErr, args string) error
+
+// cellMagic handles a cell whose first line is `%%name args`. It receives the rest of
+// the cell as body and takes over its evaluation, returning the values to display.
+type cellMagic func(kernel *Kernel, outerr OutErr, args string, body string) ([]interface{}, error)
+
+// lineMagics and cellMagics hold the magics known to the kernel by name. Each magic
+// registers itself from an init function in the file implementing it.
+var (
+	lineMagics = map[string]lineMagic{}
+	cellMagics = map[string]cellMagic{}
+)
+
+// splitCellMagic returns the name, arguments and body of a cell starting with a
+// `%%name args` line. ok is false if the cell does not start with a cell magic.
+func splitCellMagic(code string) (name, args, body string, ok bool) {
+	trimmed := strings.TrimLeft(code, " \t\r\n")
+	if !strings.HasPrefix(trimmed, "%%") {
+		return "", "", "", false
+	}
+
+	line := trimmed[2:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line, body = line[:i], line[i+1:]
+	}
+	name, args = splitMagicLine(line)
+	return name, args, body, true
+}
+
+// splitMagicLine splits the text following the `%` or `%%` of a magic into the magic's
+// name and its arguments.
+func splitMagicLine(line string) (name, args string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i+1:])
+	}
+	return line, ""
+}
+
+// evalCellMagic runs the cell magic called name on body. With --help, it shows the
+// usage of the magic in the pager instead.
+func (kernel *Kernel) evalCellMagic(outerr OutErr, name, args, body string) ([]interface{}, error) {
+	magic, ok := cellMagics[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cell magic %%%%%s", name)
+	}
+	if wantsHelp(args) {
+		kernel.session.page(magicHelp("%%" + name))
+		return nil, nil
+	}
+	return magic(kernel, outerr, args, body)
+}
+
+// evalLineMagic runs the line magic in line, which must start with `%`. Like shell
+// commands, line magics report failures by panicking. With --help, the usage of the
+// magic is shown in the pager instead.
+func (kernel *Kernel) evalLineMagic(outerr OutErr, line string) {
+	name, args := splitMagicLine(line[1:])
+	magic, ok := lineMagics[name]
+	if !ok {
+		panic(fmt.Errorf("unknown line magic %%%s", name))
+	}
+	if wantsHelp(args) {
+		kernel.session.page(magicHelp("%" + name))
+		return
+	}
+	if err := magic(kernel, outerr, args); err != nil {
+		panic(err)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
+	"path/filepath"
 )
 
 const (
@@ -15,13 +17,125 @@ const (
 )
 
 func main() {
+	configFile := flag.String("config", "", "path to a JSON file with the kernel configuration")
+	console := flag.Bool("console", false, "run an interactive session on the terminal")
+	sandbox := flag.Bool("sandbox", false, "restrict what cells can do, see the sandbox configuration")
+	flag.BoolVar(&debugLogging, "debug", false, "log details of the evaluation of cells")
+	record := flag.String("record", "", "record the messages of the kernel to a JSON lines file, see gopyter replay")
 
-	// Parse the connection file.
 	flag.Parse()
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *sandbox {
+		config.Sandbox.Enabled = true
+	}
+
+	startTracing(config)
+	if err := startStats(config); err != nil {
+		log.Fatal(err)
+	}
+
+	// The daemon only manages kernel processes, which it starts with the same flags.
+	if flag.Arg(0) == "daemon" || filepath.Base(os.Args[0]) == "gopyterd" {
+		var kernelArgs []string
+		if *configFile != "" {
+			kernelArgs = append(kernelArgs, "-config", *configFile)
+		}
+		if *sandbox {
+			kernelArgs = append(kernelArgs, "-sandbox")
+		}
+		if debugLogging {
+			kernelArgs = append(kernelArgs, "-debug")
+		}
+		args := flag.Args()
+		if flag.Arg(0) == "daemon" {
+			args = args[1:]
+		}
+		if err := runDaemon(config, kernelArgs, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if config.Sandbox.Enabled {
+		if err := enableSandbox(config.Sandbox); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *record != "" {
+		if err := startRecording(*record); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch flag.Arg(0) {
+	case "replay":
+		if err := runReplay(config, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "run":
+		if err := runNotebook(config, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "attach":
+		if err := runAttach(config, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "prewarm":
+		if err := runPrewarm(config, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "version":
+		if err := runVersion(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "stats":
+		if err := runStats(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "upgrade":
+		if err := runUpgrade(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "standby":
+		if err := runStandby(config); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *console {
+		runConsole(config)
+		return
+	}
+
+	// Parse the connection file.
 	if flag.NArg() < 1 {
 		log.Fatalln("Need a command line argument specifying the connection file.")
 	}
 
 	// Run the kernel.
-	runKernel(flag.Arg(0))
+	runKernel(flag.Arg(0), config)
+}
+
+// debugLogging enables the logs of debugf, with the -debug flag.
+var debugLogging bool
+
+// debugf logs the details of the evaluation of cells that help diagnose the kernel.
+func debugf(format string, args ...interface{}) {
+	if debugLogging {
+		log.Printf(format+"\n", args...)
+	}
 }
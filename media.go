@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("Audio", newAudio, execAudio),
+		builtin.I.Func("Video", newVideo, execVideo),
+	)
+}
+
+// AudioOutput is a sound played from the output of a cell, as returned by Audio.
+type AudioOutput struct {
+	Data     []byte
+	MIMEType string
+}
+
+// newAudio returns an output playing data, e.g. the bytes of a WAV or MP3 file. The
+// MIME type of data is detected if mimeType is empty.
+func newAudio(data []byte, mimeType string) *AudioOutput {
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return &AudioOutput{Data: data, MIMEType: mimeType}
+}
+
+func execAudio(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, newAudio(args[0].([]byte), args[1].(string)))
+}
+
+func (a *AudioOutput) String() string {
+	return fmt.Sprintf("Audio(%s, %s)", a.MIMEType, formatSize(int64(len(a.Data))))
+}
+
+// Render renders the sound as an audio player embedding it.
+func (a *AudioOutput) Render() Data {
+	player := fmt.Sprintf(`<audio controls src="data:%s;base64,%s"></audio>`,
+		html.EscapeString(a.MIMEType), base64.StdEncoding.EncodeToString(a.Data))
+	return MakeData3(MIMETypeHTML, a.String(), player)
+}
+
+// VideoOutput is a video played from the output of a cell, as returned by Video.
+type VideoOutput struct {
+	Source string // a URL or the path of a file
+}
+
+func newVideo(src string) *VideoOutput {
+	return &VideoOutput{Source: src}
+}
+
+func execVideo(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, newVideo(args[0].(string)))
+}
+
+func (v *VideoOutput) String() string {
+	return "Video(" + v.Source + ")"
+}
+
+// Render renders the video as a video player. Videos from URLs are streamed by the
+// browser. Video files up to maxInlineDownload are embedded in the output, larger
+// ones are linked by path, relative to the notebook, as they would bloat it. Files
+// outside of the sandbox roots are refused when the sandbox is enabled.
+func (v *VideoOutput) Render() Data {
+	src := v.Source
+	if u, err := url.Parse(v.Source); err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// A path, possibly starting with a Windows drive letter.
+		if sandboxed && !inSandbox(v.Source) {
+			return makeDataErr(&os.PathError{Op: "open", Path: v.Source, Err: errSandboxed})
+		}
+		info, err := os.Stat(v.Source)
+		if err != nil {
+			return makeDataErr(err)
+		}
+		src = filepath.ToSlash(v.Source)
+		if info.Size() <= maxInlineDownload {
+			content, err := ioutil.ReadFile(v.Source)
+			if err != nil {
+				return makeDataErr(err)
+			}
+			mimeType := mime.TypeByExtension(filepath.Ext(v.Source))
+			if mimeType == "" {
+				mimeType = http.DetectContentType(content)
+			}
+			src = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(content)
+		}
+	}
+	player := fmt.Sprintf(`<video controls src="%s" style="max-width:100%%"></video>`, html.EscapeString(src))
+	return MakeData3(MIMETypeHTML, v.String(), player)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMedia tests rendering audio and video players.
+func TestMedia(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	small := filepath.Join(dir, "clip.mp4")
+	large := filepath.Join(dir, "movie.mp4")
+	if err := ioutil.WriteFile(small, []byte("\x00\x00\x00\x18ftypmp42"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(large, make([]byte, maxInlineDownload+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		code   string
+		player string
+	}{
+		{`Audio([]byte("RIFF\x24\x00\x00\x00WAVEfmt "), "")`, `<audio controls src="data:audio/wave;base64,UklGRiQAAABXQVZFZm10IA=="></audio>`},
+		{`Audio([]byte("ID3"), "audio/mpeg")`, `<audio controls src="data:audio/mpeg;base64,SUQz"></audio>`},
+		{`Video("https://example.com/a.mp4")`, `<video controls src="https://example.com/a.mp4"`},
+		{`Video("` + filepath.ToSlash(small) + `")`, `<video controls src="data:video/mp4;base64,AAAAGGZ0eXBtcDQy"`},
+		{`Video("` + filepath.ToSlash(large) + `")`, `<video controls src="` + filepath.ToSlash(large) + `"`},
+	}
+
+	t.Logf("Should render audio and video players.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	for _, tc := range cases {
+		vals, err := kernel.session.Eval(tc.code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %s failed: %v.", failure, tc.code, err)
+		}
+		if html, _ := kernel.autoRenderResults(vals).Data[MIMETypeHTML].(string); !strings.Contains(html, tc.player) {
+			t.Fatalf("\t%s Expected %s to render %s, got %s.", failure, tc.code, tc.player, html)
+		}
+		t.Logf("\t%s Rendered %s.", success, tc.code)
+	}
+
+	t.Logf("Should refuse video files outside of the sandbox roots.")
+
+	defer func(enabled bool, roots []string) { sandboxed, sandboxRoots = enabled, roots }(sandboxed, sandboxRoots)
+	sandboxed, sandboxRoots = true, []string{filepath.Join(dir, "root")}
+	data := newVideo(small).Render()
+	if data.Data["ename"] != "ERROR" || !strings.Contains(data.Data["evalue"].(string), errSandboxed.Error()) {
+		t.Fatalf("\t%s Expected %s to be refused, got %v.", failure, small, data.Data)
+	}
+	if html, _ := newVideo("https://example.com/a.mp4").Render().Data[MIMETypeHTML].(string); html == "" {
+		t.Fatalf("\t%s Expected videos from URLs to be played.", failure)
+	}
+	t.Logf("\t%s Refused %s.", success, small)
+}
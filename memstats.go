@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	lineMagics["memstats"] = evalMemstatsMagic
+	lineMagics["gc"] = evalGCMagic
+	lineMagics["gogc"] = evalGOGCMagic
+	documentMagic(memstatsSyntax)
+	documentMagic(gcSyntax)
+	documentMagic(gogcSyntax)
+}
+
+var memstatsSyntax = &magicSyntax{
+	name:  "%memstats",
+	usage: []string{""},
+	doc: "Reports the memory used by the kernel: the heap in use, the resident memory and the number of " +
+		"garbage collections, with their growth since the previous %memstats of the session.",
+}
+
+var gcSyntax = &magicSyntax{
+	name:  "%gc",
+	usage: []string{""},
+	doc: "Runs a garbage collection and returns the freed memory to the operating system, then reports " +
+		"the memory reclaimed.",
+}
+
+var gogcSyntax = &magicSyntax{
+	name:  "%gogc",
+	usage: []string{"[<percent>|off]"},
+	doc: "Sets the garbage collection target percentage, like the GOGC environment variable: a collection " +
+		"runs when the heap has grown by that percentage since the previous one. `off` disables the " +
+		"garbage collector. Without argument, reports the setting.",
+	args: []magicParam{{name: "percent|off", help: "the garbage collection target percentage", optional: true}},
+}
+
+// memSample is the memory usage of the kernel at some point.
+type memSample struct {
+	heapInUse uint64 // the bytes of the heap in use
+	resident  uint64 // the bytes of the resident memory of the process
+	gcCycles  uint32 // the number of completed garbage collections
+}
+
+// readMemSample measures the memory usage of the kernel.
+func readMemSample() memSample {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return memSample{heapInUse: stats.HeapInuse, resident: residentMemory(stats), gcCycles: stats.NumGC}
+}
+
+// residentMemory returns the resident memory of the process, read from /proc where
+// available. Elsewhere, it falls back to the memory the Go runtime obtained from the
+// system, which is an upper bound of the resident memory of the heap.
+func residentMemory(stats runtime.MemStats) uint64 {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err == nil {
+		if fields := strings.Fields(string(statm)); len(fields) > 1 {
+			if pages, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	return stats.Sys
+}
+
+// formatBytes formats a number of bytes in the binary unit that suits it best.
+func formatBytes(n int64) string {
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	if n < 1<<10 {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	value, unit := float64(n)/(1<<10), 0
+	for value >= 1<<10 && unit < len(units)-1 {
+		value /= 1 << 10
+		unit++
+	}
+	return fmt.Sprintf("%s%.1f %s", sign, value, units[unit])
+}
+
+// formatGrowth formats the growth of a number of bytes, with its sign.
+func formatGrowth(n int64) string {
+	if n < 0 {
+		return formatBytes(n)
+	}
+	return "+" + formatBytes(n)
+}
+
+// evalMemstatsMagic implements `%memstats`, which reports the memory used by the kernel
+// and its growth since the previous %memstats of the session.
+func evalMemstatsMagic(kernel *Kernel, outerr OutErr, args string) error {
+	if _, err := memstatsSyntax.parse(args); err != nil {
+		return err
+	}
+	sample := readMemSample()
+	previous := kernel.session.memSample
+	kernel.session.memSample = &sample
+
+	fmt.Fprintf(outerr.out, "Heap in use:     %s", formatBytes(int64(sample.heapInUse)))
+	if previous != nil {
+		fmt.Fprintf(outerr.out, " (%s)", formatGrowth(int64(sample.heapInUse)-int64(previous.heapInUse)))
+	}
+	fmt.Fprintf(outerr.out, "\nResident memory: %s", formatBytes(int64(sample.resident)))
+	if previous != nil {
+		fmt.Fprintf(outerr.out, " (%s)", formatGrowth(int64(sample.resident)-int64(previous.resident)))
+	}
+	fmt.Fprintf(outerr.out, "\nGC cycles:       %d", sample.gcCycles)
+	if previous != nil {
+		fmt.Fprintf(outerr.out, " (+%d)", sample.gcCycles-previous.gcCycles)
+	}
+	fmt.Fprintln(outerr.out)
+	if limit := kernel.config.MemoryWarnMB; limit > 0 {
+		fmt.Fprintf(outerr.out, "Warning limit:   %s\n", formatBytes(int64(limit)<<20))
+	}
+	return nil
+}
+
+// memoryWarning returns a banner warning that the resident memory of the kernel exceeds
+// the MemoryWarnMB limit of the configuration, if it does.
+func (kernel *Kernel) memoryWarning() (Data, bool) {
+	limit := uint64(kernel.config.MemoryWarnMB) << 20
+	if limit == 0 {
+		return Data{}, false
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	resident := residentMemory(stats)
+	if resident <= limit {
+		return Data{}, false
+	}
+	warning := fmt.Sprintf("The kernel uses %s of memory, more than the limit of %s. "+
+		"Release large values and run %%gc, or restart the kernel.", formatBytes(int64(resident)), formatBytes(int64(limit)))
+	return Data{Data: MIMEMap{
+		MIMETypeText: "\x1b[33mwarning: " + warning + "\x1b[0m\n",
+		MIMETypeHTML: `<div style="background-color:#fff8e1;border-left:4px solid #f0ad00;color:#6d4c00;` +
+			`padding:4px 8px">⚠ ` + html.EscapeString(warning) + "</div>",
+	}}, true
+}
+
+// evalGCMagic implements `%gc`, which collects garbage and returns the freed memory to
+// the operating system, for long sessions that accumulated large intermediate data.
+func evalGCMagic(kernel *Kernel, outerr OutErr, args string) error {
+	if _, err := gcSyntax.parse(args); err != nil {
+		return err
+	}
+	before := readMemSample()
+	runtime.GC()
+	debug.FreeOSMemory()
+	after := readMemSample()
+	fmt.Fprintf(outerr.out, "Reclaimed %s of heap and returned %s to the operating system.\n",
+		formatBytes(int64(before.heapInUse)-int64(after.heapInUse)), formatBytes(int64(before.resident)-int64(after.resident)))
+	fmt.Fprintf(outerr.out, "Heap in use: %s, resident memory: %s.\n", formatBytes(int64(after.heapInUse)), formatBytes(int64(after.resident)))
+	return nil
+}
+
+// evalGOGCMagic implements `%gogc [<percent>|off]`, which sets the garbage collection
+// target percentage, or reports it.
+func evalGOGCMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := gogcSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	percent := debug.SetGCPercent(100)
+	switch arg := parsed.arg(0); arg {
+	case "":
+	case "off":
+		percent = -1
+	default:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			debug.SetGCPercent(percent)
+			return gogcSyntax.errorf("expected a percentage >= 0 or off, got %q", arg)
+		}
+		percent = n
+	}
+	debug.SetGCPercent(percent)
+	if percent < 0 {
+		fmt.Fprintln(outerr.out, "The garbage collector is off.")
+	} else {
+		fmt.Fprintf(outerr.out, "GOGC is %d.\n", percent)
+	}
+	return nil
+}
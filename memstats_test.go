@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+// TestFormatBytes tests formatting sizes in binary units.
+func TestFormatBytes(t *testing.T) {
+	cases := []struct {
+		n    int64
+		text string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{-3 << 30, "-3.0 GiB"},
+	}
+
+	t.Logf("Should format sizes in binary units.")
+
+	for _, tc := range cases {
+		if text := formatBytes(tc.n); text != tc.text {
+			t.Fatalf("\t%s Expected %d to be formatted as %q, got %q.", failure, tc.n, tc.text, text)
+		}
+		t.Logf("\t%s Formatted %q.", success, tc.text)
+	}
+}
+
+// TestMemstatsMagic tests reporting the memory used by the kernel.
+func TestMemstatsMagic(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+
+	t.Logf("Should report the memory used by the kernel.")
+
+	if _, err := kernel.doEvalGop(outerr, "%memstats"); err != nil {
+		t.Fatalf("\t%s %%memstats failed: %v.", failure, err)
+	}
+	if text := out.String(); !strings.Contains(text, "Heap in use:") || strings.Contains(text, "(") {
+		t.Fatalf("\t%s Expected the memory usage without growth, got %q.", failure, text)
+	}
+	t.Logf("\t%s Reported the memory usage.", success)
+
+	t.Logf("Should report the growth since the previous %%memstats.")
+
+	out.Reset()
+	if _, err := kernel.doEvalGop(outerr, "%memstats"); err != nil {
+		t.Fatalf("\t%s %%memstats failed: %v.", failure, err)
+	}
+	if text := out.String(); !strings.Contains(text, "GC cycles:") || !strings.Contains(text, "(+") {
+		t.Fatalf("\t%s Expected the growth of the memory usage, got %q.", failure, text)
+	}
+	t.Logf("\t%s Reported the growth.", success)
+}
+
+// TestMemoryWarning tests warning when the kernel uses too much memory.
+func TestMemoryWarning(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+
+	t.Logf("Should warn only when the memory exceeds the limit.")
+
+	if _, ok := kernel.memoryWarning(); ok {
+		t.Fatalf("\t%s Expected no warning without a limit.", failure)
+	}
+	kernel.config.MemoryWarnMB = 1
+	data, ok := kernel.memoryWarning()
+	if !ok || !strings.Contains(data.Data[MIMETypeText].(string), "more than the limit of 1.0 MiB") {
+		t.Fatalf("\t%s Expected a warning above 1 MiB, got %v.", failure, data.Data)
+	}
+	kernel.config.MemoryWarnMB = 1 << 20
+	if _, ok := kernel.memoryWarning(); ok {
+		t.Fatalf("\t%s Expected no warning below 1 TiB.", failure)
+	}
+	t.Logf("\t%s Warned above the limit.", success)
+}
+
+// TestGCMagics tests collecting garbage and tuning the garbage collector.
+func TestGCMagics(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+
+	t.Logf("Should collect garbage with %%gc.")
+
+	if _, err := kernel.doEvalGop(outerr, "%gc"); err != nil || !strings.HasPrefix(out.String(), "Reclaimed ") {
+		t.Fatalf("\t%s Expected %%gc to report the reclaimed memory, got %q (%v).", failure, out.String(), err)
+	}
+	t.Logf("\t%s Collected garbage.", success)
+
+	t.Logf("Should set GOGC with %%gogc.")
+
+	cases := []struct {
+		args    string
+		percent int
+	}{
+		{"50", 50},
+		{"", 50},
+		{"off", -1},
+		{"0", 0},
+	}
+	for _, tc := range cases {
+		out.Reset()
+		if _, err := kernel.doEvalGop(outerr, "%gogc "+tc.args); err != nil {
+			t.Fatalf("\t%s %%gogc %s failed: %v.", failure, tc.args, err)
+		}
+		if percent := debug.SetGCPercent(tc.percent); percent != tc.percent {
+			t.Fatalf("\t%s Expected %%gogc %s to set GOGC to %d, got %d.", failure, tc.args, tc.percent, percent)
+		}
+		t.Logf("\t%s %%gogc %s reported %q.", success, tc.args, strings.TrimSpace(out.String()))
+	}
+	if _, err := kernel.doEvalGop(outerr, "%gogc -5"); err == nil {
+		t.Fatalf("\t%s Expected a negative percentage to be refused.", failure)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"html"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("Mermaid", newMermaidDiagram, execMermaid),
+	)
+}
+
+// mermaidScript is the module of Mermaid rendering diagrams in front-ends that do not
+// support the Mermaid MIME type.
+const mermaidScript = "https://cdn.jsdelivr.net/npm/mermaid@10/dist/mermaid.esm.min.mjs"
+
+// MermaidDiagram is a diagram in the Mermaid language, as returned by Mermaid.
+type MermaidDiagram struct {
+	Source string
+}
+
+func newMermaidDiagram(src string) *MermaidDiagram {
+	return &MermaidDiagram{Source: src}
+}
+
+func execMermaid(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, newMermaidDiagram(args[0].(string)))
+}
+
+func (m *MermaidDiagram) String() string {
+	return m.Source
+}
+
+// Render renders the diagram with the Mermaid MIME type, which JupyterLab 4.1 and later
+// display natively. Other front-ends fall back to an isolated HTML output loading
+// Mermaid, which needs access to the Internet.
+func (m *MermaidDiagram) Render() Data {
+	page := `<pre class="mermaid">` + html.EscapeString(m.Source) + `</pre>` +
+		`<script type="module">import mermaid from "` + mermaidScript + `";` +
+		`mermaid.initialize({startOnLoad: true});</script>`
+	return Data{
+		Data: MIMEMap{
+			MIMETypeText:    m.Source,
+			MIMETypeMermaid: m.Source,
+			MIMETypeHTML:    page,
+		},
+		Metadata: MIMEMap{MIMETypeHTML: map[string]interface{}{"isolated": true}},
+	}
+}
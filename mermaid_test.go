@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestMermaid tests rendering Mermaid diagrams.
+func TestMermaid(t *testing.T) {
+	t.Logf("Should render diagrams with the Mermaid MIME type and an HTML fallback.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	vals, err := kernel.session.Eval("Mermaid(`graph LR\n  a --> b`)")
+	if err != nil {
+		t.Fatalf("\t%s Evaluating Mermaid failed: %v.", failure, err)
+	}
+	data := kernel.autoRenderResults(vals)
+	if src := data.Data[MIMETypeMermaid]; src != "graph LR\n  a --> b" {
+		t.Fatalf("\t%s Expected the source of the diagram, got %v.", failure, src)
+	}
+	html, _ := data.Data[MIMETypeHTML].(string)
+	if !strings.Contains(html, `<pre class="mermaid">graph LR`+"\n"+`  a --&gt; b</pre>`) || !strings.Contains(html, mermaidScript) {
+		t.Fatalf("\t%s Expected the HTML fallback, got %s.", failure, html)
+	}
+	t.Logf("\t%s Rendered the diagram.", success)
+}
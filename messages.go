@@ -1,34 +1,20 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"io"
+	"log"
 	"time"
 
 	"github.com/go-zeromq/zmq4"
 	"github.com/gofrs/uuid"
+	"github.com/wangfenjin/gopyter/wire"
 )
 
 // MsgHeader encodes header info for ZMQ messages.
-type MsgHeader struct {
-	MsgID           string `json:"msg_id"`
-	Username        string `json:"username"`
-	Session         string `json:"session"`
-	MsgType         string `json:"msg_type"`
-	ProtocolVersion string `json:"version"`
-	Timestamp       string `json:"date"`
-}
+type MsgHeader = wire.Header
 
 // ComposedMsg represents an entire message in a high-level structure.
-type ComposedMsg struct {
-	Header       MsgHeader
-	ParentHeader MsgHeader
-	Metadata     map[string]interface{}
-	Content      interface{}
-}
+type ComposedMsg = wire.Message
 
 // msgReceipt represents a received message, its return identities, and
 // the sockets for communication.
@@ -36,6 +22,7 @@ type msgReceipt struct {
 	Msg        ComposedMsg
 	Identities [][]byte
 	Sockets    SocketGroup
+	Span       *span // traces the handling of Msg
 }
 
 // MIMEMap holds data that can be presented in multiple formats. The keys are MIME types
@@ -45,104 +32,19 @@ type MIMEMap = map[string]interface{}
 
 // Data is the exact structure returned to Jupyter.
 // It allows to fully specify how a value should be displayed.
+// Buffers are sent as binary buffers of the message rather than in its JSON content,
+// e.g. for the arrays of custom front-end renderers.
 type Data = struct {
 	Data      MIMEMap
 	Metadata  MIMEMap
 	Transient MIMEMap
-}
-
-// InvalidSignatureError is returned when the signature on a received message does not
-// validate.
-type InvalidSignatureError struct{}
-
-func (e *InvalidSignatureError) Error() string {
-	return "A message had an invalid signature"
-}
-
-// WireMsgToComposedMsg translates a multipart ZMQ messages received from a socket into
-// a ComposedMsg struct and a slice of return identities. This includes verifying the
-// message signature.
-func WireMsgToComposedMsg(msgparts [][]byte, signkey []byte) (ComposedMsg, [][]byte, error) {
-
-	i := 0
-	for string(msgparts[i]) != "<IDS|MSG>" {
-		i++
-	}
-	identities := msgparts[:i]
-
-	// Validate signature.
-	var msg ComposedMsg
-	if len(signkey) != 0 {
-		mac := hmac.New(sha256.New, signkey)
-		for _, msgpart := range msgparts[i+2 : i+6] {
-			mac.Write(msgpart)
-		}
-		signature := make([]byte, hex.DecodedLen(len(msgparts[i+1])))
-		hex.Decode(signature, msgparts[i+1])
-		if !hmac.Equal(mac.Sum(nil), signature) {
-			return msg, nil, &InvalidSignatureError{}
-		}
-	}
-
-	// Unmarshal contents.
-	json.Unmarshal(msgparts[i+2], &msg.Header)
-	json.Unmarshal(msgparts[i+3], &msg.ParentHeader)
-	json.Unmarshal(msgparts[i+4], &msg.Metadata)
-	json.Unmarshal(msgparts[i+5], &msg.Content)
-	return msg, identities, nil
-}
-
-// ToWireMsg translates a ComposedMsg into a multipart ZMQ message ready to send, and
-// signs it. This does not add the return identities or the delimiter.
-func (msg ComposedMsg) ToWireMsg(signkey []byte) ([][]byte, error) {
-
-	msgparts := make([][]byte, 5)
-
-	header, err := json.Marshal(msg.Header)
-	if err != nil {
-		return msgparts, err
-	}
-	msgparts[1] = header
-
-	parentHeader, err := json.Marshal(msg.ParentHeader)
-	if err != nil {
-		return msgparts, err
-	}
-	msgparts[2] = parentHeader
-
-	if msg.Metadata == nil {
-		msg.Metadata = make(map[string]interface{})
-	}
-
-	metadata, err := json.Marshal(msg.Metadata)
-	if err != nil {
-		return msgparts, err
-	}
-	msgparts[3] = metadata
-
-	content, err := json.Marshal(msg.Content)
-	if err != nil {
-		return msgparts, err
-	}
-	msgparts[4] = content
-
-	// Sign the message.
-	if len(signkey) != 0 {
-		mac := hmac.New(sha256.New, signkey)
-		for _, msgpart := range msgparts[1:] {
-			mac.Write(msgpart)
-		}
-		msgparts[0] = make([]byte, hex.EncodedLen(mac.Size()))
-		hex.Encode(msgparts[0], mac.Sum(nil))
-	}
-
-	return msgparts, nil
+	Buffers   [][]byte
 }
 
 // SendResponse sends a message back to return identities of the received message.
 func (receipt *msgReceipt) SendResponse(socket zmq4.Socket, msg ComposedMsg) error {
 
-	msgParts, err := msg.ToWireMsg(receipt.Sockets.Key)
+	msgParts, err := wire.Encode(msg, receipt.Sockets.Key.get())
 	if err != nil {
 		return err
 	}
@@ -184,31 +86,55 @@ func NewMsg(msgType string, parent ComposedMsg) (ComposedMsg, error) {
 // Publish creates a new ComposedMsg and sends it back to the return identities over the
 // IOPub channel.
 func (receipt *msgReceipt) Publish(msgType string, content interface{}) error {
+	return receipt.PublishWithBuffers(msgType, content, nil)
+}
+
+// PublishWithBuffers publishes a message like Publish, followed by binary buffers.
+func (receipt *msgReceipt) PublishWithBuffers(msgType string, content interface{}, buffers [][]byte) error {
+	span := startSpan(receipt.Span, "publish "+msgType)
+	defer span.end()
+
 	msg, err := NewMsg(msgType, receipt.Msg)
 
 	if err != nil {
+		span.setError(err)
 		return err
 	}
 
 	msg.Content = content
-	return receipt.Sockets.IOPubSocket.RunWithSocket(func(iopub zmq4.Socket) error {
+	msg.Buffers = buffers
+	err = receipt.Sockets.IOPubSocket.RunWithSocket(func(iopub zmq4.Socket) error {
 		return receipt.SendResponse(iopub, msg)
 	})
+	span.setError(err)
+	return err
 }
 
 // Reply creates a new ComposedMsg and sends it back to the return identities over the
 // Shell channel.
 func (receipt *msgReceipt) Reply(msgType string, content interface{}) error {
+	return receipt.ReplyWithMetadata(msgType, content, nil)
+}
+
+// ReplyWithMetadata replies like Reply, with metadata.
+func (receipt *msgReceipt) ReplyWithMetadata(msgType string, content interface{}, metadata map[string]interface{}) error {
+	span := startSpan(receipt.Span, "reply "+msgType)
+	defer span.end()
+
 	msg, err := NewMsg(msgType, receipt.Msg)
 
 	if err != nil {
+		span.setError(err)
 		return err
 	}
 
 	msg.Content = content
-	return receipt.Sockets.ShellSocket.RunWithSocket(func(shell zmq4.Socket) error {
+	msg.Metadata = metadata
+	err = receipt.Sockets.ShellSocket.RunWithSocket(func(shell zmq4.Socket) error {
 		return receipt.SendResponse(shell, msg)
 	})
+	span.setError(err)
+	return err
 }
 
 // PublishKernelStatus publishes a status message notifying front-ends of the state the kernel is in. Supports
@@ -259,7 +185,7 @@ func merge(a MIMEMap, b MIMEMap) MIMEMap {
 
 // PublishExecuteResult publishes the result of the `execCount` execution as a string.
 func (receipt *msgReceipt) PublishExecutionResult(execCount int, data Data) error {
-	return receipt.Publish("execute_result", struct {
+	return receipt.PublishWithBuffers("execute_result", struct {
 		ExecCount int     `json:"execution_count"`
 		Data      MIMEMap `json:"data"`
 		Metadata  MIMEMap `json:"metadata"`
@@ -267,20 +193,23 @@ func (receipt *msgReceipt) PublishExecutionResult(execCount int, data Data) erro
 		ExecCount: execCount,
 		Data:      data.Data,
 		Metadata:  ensure(data.Metadata),
-	})
+	}, data.Buffers)
 }
 
 // PublishExecuteResult publishes a serialized error that was encountered during execution.
-func (receipt *msgReceipt) PublishExecutionError(err string, trace []string) error {
+// cellID is the id of the notebook cell that failed, if the front-end sent it.
+func (receipt *msgReceipt) PublishExecutionError(err string, trace []string, cellID string) error {
 	return receipt.Publish("error",
 		struct {
-			Name  string   `json:"ename"`
-			Value string   `json:"evalue"`
-			Trace []string `json:"traceback"`
+			Name   string   `json:"ename"`
+			Value  string   `json:"evalue"`
+			Trace  []string `json:"traceback"`
+			CellID string   `json:"cell_id,omitempty"`
 		}{
-			Name:  "ERROR",
-			Value: err,
-			Trace: trace,
+			Name:   "ERROR",
+			Value:  err,
+			Trace:  trace,
+			CellID: cellID,
 		},
 	)
 }
@@ -288,7 +217,7 @@ func (receipt *msgReceipt) PublishExecutionError(err string, trace []string) err
 // PublishDisplayData publishes a single image.
 func (receipt *msgReceipt) PublishDisplayData(data Data) error {
 	// copy Data in a struct with appropriate json tags
-	return receipt.Publish("display_data", struct {
+	return receipt.PublishWithBuffers("display_data", struct {
 		Data      MIMEMap `json:"data"`
 		Metadata  MIMEMap `json:"metadata"`
 		Transient MIMEMap `json:"transient"`
@@ -296,7 +225,59 @@ func (receipt *msgReceipt) PublishDisplayData(data Data) error {
 		Data:      data.Data,
 		Metadata:  ensure(data.Metadata),
 		Transient: ensure(data.Transient),
+	}, data.Buffers)
+}
+
+// PublishUpdateDisplayData replaces the output shown by an earlier display_data message
+// with the same display_id in its transient data.
+func (receipt *msgReceipt) PublishUpdateDisplayData(data Data) error {
+	return receipt.PublishWithBuffers("update_display_data", struct {
+		Data      MIMEMap `json:"data"`
+		Metadata  MIMEMap `json:"metadata"`
+		Transient MIMEMap `json:"transient"`
+	}{
+		Data:      data.Data,
+		Metadata:  ensure(data.Metadata),
+		Transient: ensure(data.Transient),
+	}, data.Buffers)
+}
+
+// RequestInput asks the front-end for a line of input over the stdin channel, and waits
+// for the reply. A password is not echoed by the front-end.
+func (receipt *msgReceipt) RequestInput(prompt string, password bool) (string, error) {
+	msg, err := NewMsg("input_request", receipt.Msg)
+	if err != nil {
+		return "", err
+	}
+	msg.Content = struct {
+		Prompt   string `json:"prompt"`
+		Password bool   `json:"password"`
+	}{prompt, password}
+	err = receipt.Sockets.StdinSocket.RunWithSocket(func(stdin zmq4.Socket) error {
+		return receipt.SendResponse(stdin, msg)
 	})
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		frames, err := receipt.Sockets.readStdin()
+		if err != nil {
+			return "", err
+		}
+		reply, _, err := wire.Decode(frames, receipt.Sockets.Key.get())
+		if err != nil {
+			log.Printf("Ignoring a malformed input reply: %v\n", err)
+			continue
+		}
+		// Skip the replies to earlier requests that came too late.
+		if reply.Header.MsgType != "input_reply" || reply.ParentHeader.MsgID != msg.Header.MsgID {
+			continue
+		}
+		content, _ := reply.Content.(map[string]interface{})
+		value, _ := content["value"].(string)
+		return value, nil
+	}
 }
 
 const (
@@ -326,22 +307,76 @@ func (receipt *msgReceipt) PublishWriteStream(stream string, data string) error
 // JupyterStreamWriter is an `io.Writer` implementation that writes the data to the notebook
 // front-end.
 type JupyterStreamWriter struct {
-	stream  string
-	receipt *msgReceipt
+	stream   string
+	receipt  *msgReceipt
+	limiter  *outputLimiter
+	redactor *redactor
+	throttle *iopubThrottle
+	held     string // the end of the data written, which may be the start of redacted text
 }
 
-// Write implements `io.Writer.Write` by publishing the data via `PublishWriteStream`
+// Write implements `io.Writer.Write` by publishing the data via `PublishWriteStream`.
+// If the writer has an output limiter, only the part of the data within the limits is
+// published; the rest is reported as written but withheld from the front-end. If the
+// writer has a redactor, the data is redacted first: the end of the data that may be
+// the start of redacted text, like a secret printed in two writes, is held until the
+// next write or flush. If it has a throttle, the data is published at the rate it
+// allows.
 func (writer *JupyterStreamWriter) Write(p []byte) (int, error) {
-	data := string(p)
 	n := len(p)
-
-	if err := writer.receipt.PublishWriteStream(writer.stream, data); err != nil {
+	if writer.redactor != nil {
+		text := writer.held + string(p)
+		cut := writer.redactor.split(text)
+		redacted := writer.redactor.redact(text[:cut])
+		writer.held = text[cut:]
+		p = []byte(redacted)
+	}
+	if err := writer.write(p); err != nil {
 		return 0, err
 	}
-
 	return n, nil
 }
 
+// flush publishes the data held by Write, once no more data is written.
+func (writer *JupyterStreamWriter) flush() error {
+	if writer.held == "" {
+		return nil
+	}
+	text := writer.redactor.redact(writer.held)
+	writer.held = ""
+	return writer.write([]byte(text))
+}
+
+// write publishes redacted data within the limits.
+func (writer *JupyterStreamWriter) write(p []byte) error {
+	var note string
+	if writer.limiter != nil {
+		p, note = writer.limiter.filter(p)
+	}
+
+	if len(p) != 0 {
+		if err := writer.publish(string(p)); err != nil {
+			return err
+		}
+	}
+
+	if len(note) != 0 {
+		if err := writer.publish(note); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publish publishes text on the stream of the writer, through its throttle if it has one.
+func (writer *JupyterStreamWriter) publish(text string) error {
+	if writer.throttle != nil {
+		return writer.throttle.write(writer.stream, text)
+	}
+	return writer.receipt.PublishWriteStream(writer.stream, text)
+}
+
 type OutErr struct {
 	out io.Writer
 	err io.Writer
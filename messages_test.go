@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wangfenjin/gopyter/wire"
+)
+
+// TestMalformedMessage tests that the kernel replies to malformed requests with an
+// error, rather than crashing.
+func TestMalformedMessage(t *testing.T) {
+	w := &replayWire{pending: make(map[string][][][]byte)}
+	socket := func(channel string) Socket {
+		return Socket{&replaySocket{channel, w}, &sync.Mutex{}}
+	}
+	key := []byte("key")
+	sockets := SocketGroup{
+		ShellSocket:   socket("shell"),
+		ControlSocket: socket("control"),
+		IOPubSocket:   socket("iopub"),
+		Key:           &signingKey{key: key},
+	}
+	kernel := Kernel{NewSession(), defaultConfig()}
+
+	// frames returns the frames of a request signed with signKey.
+	frames := func(msgType, content string, signKey []byte) [][]byte {
+		parts := [][]byte{[]byte(`{"msg_id": "m", "msg_type": "` + msgType + `"}`), []byte("{}"), []byte("{}"), []byte(content)}
+		mac := hmac.New(sha256.New, signKey)
+		for _, part := range parts {
+			mac.Write(part)
+		}
+		signature := []byte(hex.EncodeToString(mac.Sum(nil)))
+		return append([][]byte{[]byte("id"), []byte(wire.Delimiter), signature}, parts...)
+	}
+	cases := []struct {
+		name   string
+		frames [][]byte
+		reply  string // the type of the error reply, if any
+	}{
+		{"no delimiter", [][]byte{[]byte("id")}, ""},
+		{"invalid signature", frames("execute_request", `{"code": "1"}`, []byte("other")), ""},
+		{"no code", frames("execute_request", "{}", key), "execute_reply"},
+		{"invalid content", frames("complete_request", "[", key), "complete_reply"},
+		{"not a request", frames("comm_msg", "{}", key), ""},
+	}
+
+	t.Logf("Should reply to the malformed requests with an error.")
+
+	for _, tc := range cases {
+		w.sent = nil
+		kernel.handleMessage("control", tc.frames, sockets)
+		var replies []string
+		for _, m := range w.sent {
+			replies = append(replies, m.header().MsgType+" "+string(m.Content))
+		}
+		if tc.reply == "" && len(replies) != 0 || tc.reply != "" && (len(replies) != 1 || !strings.HasPrefix(replies[0], tc.reply)) {
+			t.Fatalf("\t%s %s: expected the reply %q, got %q.", failure, tc.name, tc.reply, replies)
+		}
+		t.Logf("\t%s %s: %q.", success, tc.name, replies)
+	}
+}
+
+// TestExecuteErrorReply tests the tracebacks of the replies to failing cells, which
+// front-ends show when they do not get the error message.
+func TestExecuteErrorReply(t *testing.T) {
+	defer func(count int) { ExecCounter = count }(ExecCounter)
+
+	var requests []recordedMsg
+	for _, code := range []string{"undefinedName + 1", "expect.Eq(1, 2)"} {
+		msg, err := NewMsg("execute_request", ComposedMsg{})
+		if err != nil {
+			t.Fatalf("\t%s Could not create a request: %v.", failure, err)
+		}
+		msg.Content = map[string]interface{}{"code": code, "silent": false, "store_history": true}
+		parts, err := wire.Encode(msg, []byte("key"))
+		if err != nil {
+			t.Fatalf("\t%s Could not encode a request: %v.", failure, err)
+		}
+		request, ok := decodeRecordedMsg("shell", "in", append([][]byte{[]byte("client"), []byte(wire.Delimiter)}, parts...))
+		if !ok {
+			t.Fatalf("\t%s Could not decode the request.", failure)
+		}
+		requests = append(requests, request)
+	}
+
+	t.Logf("Should reply to errors with their traceback, and to failed expectations with none.")
+
+	ExecCounter = 0
+	results := (&Kernel{NewSession(), defaultConfig()}).replay(requests, nil)
+	if len(results) != 2 {
+		t.Fatalf("\t%s Expected 2 requests to be replayed, got %v.", failure, results)
+	}
+	wants := []string{`"traceback":["compileIdent failed: unknown - undefinedName`, `"traceback":[]`}
+	for i, want := range wants {
+		var reply string
+		for _, summary := range results[i].Replayed {
+			if strings.HasPrefix(summary, "shell execute_reply ") {
+				reply = summary
+			}
+		}
+		if !strings.Contains(reply, want) {
+			t.Fatalf("\t%s Expected the reply to %s to have %s, got %q.", failure, results[i].Request.MsgType, want, reply)
+		}
+		t.Logf("\t%s Replied %s.", success, reply)
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/goplus/gop"
+	"golang.org/x/xerrors"
+)
+
+// mqPackage is the Go+ package tailing message queues.
+var mqPackage = gop.NewGoPackage("mq")
+
+func init() {
+	mqPackage.RegisterFuncs(
+		mqPackage.Func("Tail", mqTail, execMQTail),
+	)
+}
+
+const (
+	// mqRefresh is the interval between the updates of the output of mq.Tail.
+	mqRefresh = 250 * time.Millisecond
+
+	// mqPoll is the interval between the reads of the files tailed.
+	mqPoll = 200 * time.Millisecond
+)
+
+// MQMessage is a message read by mq.Tail.
+type MQMessage struct {
+	Offset string // the position of the message in its topic, in the terms of the queue
+	Time   time.Time
+	Key    string
+	Value  string
+}
+
+// mqDriver reads the last n messages of a topic from the queue at u, then the new
+// messages, passing each of them to emit, until stop is closed.
+type mqDriver func(u *url.URL, topic string, n int, emit func(MQMessage), stop <-chan struct{}) error
+
+// mqDrivers are the queues mq.Tail reads from, by the scheme of their URL.
+var mqDrivers = map[string]mqDriver{
+	"file":  tailFile,
+	"kafka": tailKafka,
+	"redis": tailRedis,
+}
+
+// mqTail implements mq.Tail, which shows the last n messages of a topic, followed by
+// the new messages as they arrive, until the cell is interrupted. It returns the last n
+// messages read. Like the other builtins it reports errors by panicking, which fails
+// the cell.
+func mqTail(driver, topic string, n int) []MQMessage {
+	if sandboxed {
+		panic(xerrors.Errorf("mq.Tail: %w", errSandboxed))
+	}
+
+	// Interrupting the kernel stops the tail instead of the kernel.
+	interrupt, stopWatching := watchInterrupts()
+	defer stopWatching()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupt:
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	messages, err := tailMQ(activeSession, driver, topic, n, stop)
+	if err != nil {
+		panic(xerrors.Errorf("mq.Tail: %w", err))
+	}
+	return messages
+}
+
+func execMQTail(_ int, p *gop.Context) {
+	args := p.GetArgs(3)
+	p.Ret(3, mqTail(args[0].(string), args[1].(string), args[2].(int)))
+}
+
+// tailMQ reads a topic with its driver until stop is closed or the driver fails,
+// showing the last n messages in the output of the cell being evaluated by s.
+func tailMQ(s *Session, driver, topic string, n int, stop <-chan struct{}) ([]MQMessage, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid number of messages %d", n)
+	}
+	u, err := url.Parse(driver)
+	if err != nil {
+		return nil, err
+	}
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = driver
+	}
+	read, ok := mqDrivers[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown driver %q: use file, kafka://<brokers> or redis://<host:port>", driver)
+	}
+
+	var mu sync.Mutex
+	var window []MQMessage
+	received := 0
+	emit := func(m MQMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		window = append(window, m)
+		if len(window) > n {
+			window = window[len(window)-n:]
+		}
+		received++
+	}
+	failed := make(chan error, 1)
+	go func() {
+		failed <- read(u, topic, n, emit, stop)
+	}()
+
+	view := &mqView{session: s, topic: topic}
+	ticker := time.NewTicker(mqRefresh)
+	defer ticker.Stop()
+	ended := false
+	for {
+		select {
+		case <-ticker.C:
+		case err = <-failed:
+			ended = true
+		}
+		mu.Lock()
+		messages := append([]MQMessage(nil), window...)
+		count := received
+		mu.Unlock()
+		view.show(messages, count, ended || isClosed(stop))
+		if ended || isClosed(stop) {
+			if isClosed(stop) {
+				err = nil
+			}
+			return messages, err
+		}
+	}
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// mqView is the output of mq.Tail: a table of the last messages, replaced as messages
+// arrive. Without a front-end, the new messages are printed instead.
+type mqView struct {
+	session  *Session
+	topic    string
+	id       string // the display_id of the table, once shown
+	shown    int    // the number of messages received when the table was last shown
+	printed  int    // the number of messages printed, without a front-end
+	finished bool
+}
+
+// show shows the window of the last messages, of count received so far.
+func (v *mqView) show(window []MQMessage, count int, stopped bool) {
+	if v.finished || (count == v.shown && v.id != "" && !stopped) {
+		return
+	}
+	v.finished = stopped
+	v.shown = count
+
+	if v.session.display == nil {
+		for i := len(window) - (count - v.printed); i < len(window); i++ {
+			if i >= 0 {
+				fmt.Fprintln(os.Stdout, window[i].line())
+			}
+		}
+		v.printed = count
+		return
+	}
+
+	state := "live, interrupt the kernel to stop"
+	if stopped {
+		state = "stopped"
+	}
+	header := fmt.Sprintf("%s: %d messages received (%s)", v.topic, count, state)
+	data := Data{Data: MIMEMap{}}
+	if len(window) != 0 {
+		data = renderTable(window)
+	}
+	lines := []string{header}
+	for _, m := range window {
+		lines = append(lines, m.line())
+	}
+	page := "<div><b>" + html.EscapeString(header) + "</b></div>"
+	if table, ok := data.Data[MIMETypeHTML].(string); ok {
+		page += table
+	}
+	data = Data{Data: MIMEMap{MIMETypeText: strings.Join(lines, "\n"), MIMETypeHTML: page}}
+	data = v.session.redactor.redactData(data)
+
+	if v.id == "" {
+		id, _ := uuid.NewV4()
+		v.id = id.String()
+		data.Transient = MIMEMap{"display_id": v.id}
+		v.session.display(data)
+		return
+	}
+	data.Transient = MIMEMap{"display_id": v.id}
+	if v.session.updateDisplay != nil {
+		v.session.updateDisplay(data)
+	}
+}
+
+// line formats the message on a line.
+func (m MQMessage) line() string {
+	var b strings.Builder
+	b.WriteString(m.Offset)
+	if !m.Time.IsZero() {
+		b.WriteString(" " + m.Time.Format(time.RFC3339))
+	}
+	if m.Key != "" {
+		b.WriteString(" " + m.Key)
+	}
+	return b.String() + " " + m.Value
+}
+
+// tailFile tails a file of messages, one per line, as `tail -f` does. The topic is the
+// path of the file, and the offsets are the line numbers.
+func tailFile(_ *url.URL, path string, n int, emit func(MQMessage), stop <-chan struct{}) error {
+	var offset int64
+	var lines []MQMessage
+	line := 0
+	read := func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() < offset {
+			// The file was truncated: read it again from the start.
+			offset, line = 0, 0
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		r := bufio.NewReader(f)
+		for {
+			text, err := r.ReadString('\n')
+			if err != nil {
+				// An incomplete line is read again once complete.
+				return nil
+			}
+			offset += int64(len(text))
+			line++
+			lines = append(lines, MQMessage{Offset: strconv.Itoa(line), Value: strings.TrimRight(text, "\r\n")})
+		}
+	}
+
+	if err := read(); err != nil {
+		return err
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for {
+		for _, m := range lines {
+			emit(m)
+		}
+		lines = lines[:0]
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(mqPoll):
+		}
+		if err := read(); err != nil {
+			return err
+		}
+	}
+}
+
+// kafkaMessage is a message printed by kcat -J.
+type kafkaMessage struct {
+	Partition int     `json:"partition"`
+	Offset    int64   `json:"offset"`
+	Timestamp int64   `json:"ts"`
+	Key       *string `json:"key"`
+	Payload   *string `json:"payload"`
+}
+
+// tailKafka tails a Kafka topic of the brokers of u with the kcat command-line tool,
+// formerly kafkacat, reading the last n messages of each partition. The offsets are
+// the partitions and the offsets of the messages in them, as "2:1234".
+func tailKafka(u *url.URL, topic string, n int, emit func(MQMessage), stop <-chan struct{}) error {
+	kcat, err := exec.LookPath("kcat")
+	if err != nil {
+		if kcat, err = exec.LookPath("kafkacat"); err != nil {
+			return errors.New("the kafka driver needs kcat: see https://github.com/edenhill/kcat")
+		}
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(kcat, "-C", "-q", "-u", "-J", "-b", u.Host, "-t", topic, "-o", strconv.Itoa(-n))
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		<-stop
+		cmd.Process.Kill()
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, maxFetchBody)
+	for scanner.Scan() {
+		var km kafkaMessage
+		if err := json.Unmarshal(scanner.Bytes(), &km); err != nil {
+			continue
+		}
+		m := MQMessage{Offset: fmt.Sprintf("%d:%d", km.Partition, km.Offset)}
+		if km.Timestamp > 0 {
+			m.Time = time.Unix(0, km.Timestamp*int64(time.Millisecond))
+		}
+		if km.Key != nil {
+			m.Key = *km.Key
+		}
+		if km.Payload != nil {
+			m.Value = *km.Payload
+		}
+		emit(m)
+	}
+	if err := cmd.Wait(); err != nil && !isClosed(stop) {
+		return fmt.Errorf("kcat: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// tailRedis tails a Redis stream, the topic, of the server at u. The offsets are the
+// IDs of the entries, and the values their fields, as "field=value", or the value of
+// their only field, which is then the key.
+func tailRedis(u *url.URL, topic string, n int, emit func(MQMessage), stop <-chan struct{}) error {
+	conn, err := net.DialTimeout("tcp", u.Host, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-stop
+		conn.Close()
+	}()
+	defer conn.Close()
+	r := &respConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if password, ok := u.User.Password(); ok {
+		args := []string{"AUTH", password}
+		if name := u.User.Username(); name != "" {
+			args = []string{"AUTH", name, password}
+		}
+		if _, err := r.do(args...); err != nil {
+			return err
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if _, err := r.do("SELECT", db); err != nil {
+			return err
+		}
+	}
+
+	reply, err := r.do("XREVRANGE", topic, "+", "-", "COUNT", strconv.Itoa(n))
+	if err != nil {
+		return err
+	}
+	entries, _ := reply.([]interface{})
+	last := "0-0"
+	for i := len(entries) - 1; i >= 0; i-- {
+		m, id := redisMessage(entries[i])
+		emit(m)
+		last = id
+	}
+	for {
+		reply, err := r.do("XREAD", "COUNT", "100", "BLOCK", "1000", "STREAMS", topic, last)
+		if err != nil {
+			if isClosed(stop) {
+				return nil
+			}
+			return err
+		}
+		// The reply is a list of streams, each a list of its name and its entries.
+		streams, _ := reply.([]interface{})
+		for _, stream := range streams {
+			parts, _ := stream.([]interface{})
+			if len(parts) != 2 {
+				continue
+			}
+			entries, _ := parts[1].([]interface{})
+			for _, entry := range entries {
+				m, id := redisMessage(entry)
+				emit(m)
+				last = id
+			}
+		}
+	}
+}
+
+// redisMessage converts an entry of a stream, a list of its ID and its fields, to a
+// message.
+func redisMessage(entry interface{}) (MQMessage, string) {
+	parts, _ := entry.([]interface{})
+	if len(parts) != 2 {
+		return MQMessage{}, ""
+	}
+	id, _ := parts[0].(string)
+	m := MQMessage{Offset: id}
+	if i := strings.IndexByte(id, '-'); i > 0 {
+		if ms, err := strconv.ParseInt(id[:i], 10, 64); err == nil {
+			m.Time = time.Unix(0, ms*int64(time.Millisecond))
+		}
+	}
+	fields, _ := parts[1].([]interface{})
+	var values []string
+	for i := 0; i+1 < len(fields); i += 2 {
+		values = append(values, fmt.Sprintf("%v=%v", fields[i], fields[i+1]))
+	}
+	if len(fields) == 2 {
+		m.Key, m.Value = fmt.Sprint(fields[0]), fmt.Sprint(fields[1])
+	} else {
+		m.Value = strings.Join(values, " ")
+	}
+	return m, id
+}
+
+// respConn is a connection to a Redis server, speaking RESP, its protocol:
+// https://redis.io/docs/reference/protocol-spec/.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// do sends a command and returns its reply: a string, an int64, nil, or a list of
+// replies. Errors of the server are returned as errors.
+func (c *respConn) do(args ...string) (interface{}, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *respConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("invalid reply from Redis")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("invalid reply from Redis: %q", line)
+}
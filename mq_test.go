@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tailSession returns a session whose displays are recorded.
+func tailSession() (*Session, func() []Data) {
+	var mu sync.Mutex
+	var shown []Data
+	s := NewSession()
+	record := func(data Data) {
+		mu.Lock()
+		defer mu.Unlock()
+		shown = append(shown, data)
+	}
+	s.display, s.updateDisplay = record, record
+	return s, func() []Data {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Data(nil), shown...)
+	}
+}
+
+// waitFor waits until cond holds, for up to 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("\t%s Timed out waiting for %s.", failure, what)
+		}
+	}
+}
+
+// TestTailFile tests tailing a file, with its table updated as lines are appended.
+func TestTailFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mq")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.log")
+	if err := ioutil.WriteFile(path, []byte("a\nb\nc\nd\ne\n"), 0644); err != nil {
+		t.Fatalf("\t%s Could not write the file: %v.", failure, err)
+	}
+
+	s, shown := tailSession()
+	stop := make(chan struct{})
+	type result struct {
+		messages []MQMessage
+		err      error
+	}
+	done := make(chan result)
+	go func() {
+		messages, err := tailMQ(s, "file", path, 3, stop)
+		done <- result{messages, err}
+	}()
+
+	t.Logf("Should show the last messages, then the new ones as they arrive.")
+
+	waitFor(t, "the last messages", func() bool { return len(shown()) != 0 })
+	first := shown()[0]
+	id := first.Transient["display_id"]
+	if text := first.Data[MIMETypeText].(string); id == nil || !strings.Contains(text, "3 messages received (live") || !strings.HasSuffix(text, "3 c\n4 d\n5 e") {
+		t.Fatalf("\t%s Expected the last 3 lines with a display_id, got %q (%v).", failure, text, id)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("\t%s Could not open the file: %v.", failure, err)
+	}
+	f.WriteString("f\ng")
+	waitFor(t, "the new message", func() bool {
+		data := shown()
+		return strings.Contains(data[len(data)-1].Data[MIMETypeText].(string), "4 messages received")
+	})
+	f.WriteString("\n")
+	f.Close()
+	waitFor(t, "the completed line", func() bool {
+		data := shown()
+		return strings.Contains(data[len(data)-1].Data[MIMETypeText].(string), "5 messages received")
+	})
+	t.Logf("\t%s Showed the new messages.", success)
+
+	t.Logf("Should stop when asked, and return the last messages.")
+
+	close(stop)
+	r := <-done
+	if r.err != nil || len(r.messages) != 3 || r.messages[0].Value != "e" || r.messages[2].Offset != "7" || r.messages[2].Value != "g" {
+		t.Fatalf("\t%s Expected the last 3 messages, got %+v (%v).", failure, r.messages, r.err)
+	}
+	data := shown()
+	last := data[len(data)-1]
+	if last.Transient["display_id"] != id || !strings.Contains(last.Data[MIMETypeText].(string), "(stopped)") ||
+		!strings.Contains(last.Data[MIMETypeHTML].(string), "<td>g</td>") {
+		t.Fatalf("\t%s Expected the table to be updated as stopped, got %v.", failure, last)
+	}
+	t.Logf("\t%s Stopped after %d updates.", success, len(data))
+
+	t.Logf("Should refuse unknown drivers and missing files.")
+
+	if _, err := tailMQ(s, "nats://localhost", "events", 3, stop); err == nil || !strings.Contains(err.Error(), "unknown driver") {
+		t.Fatalf("\t%s Expected an unknown driver to be refused, got %v.", failure, err)
+	}
+	if _, err := tailMQ(s, "file", filepath.Join(dir, "missing"), 3, make(chan struct{})); err == nil {
+		t.Fatalf("\t%s Expected a missing file to fail.", failure)
+	}
+	t.Logf("\t%s Refused them.", success)
+}
+
+// TestTailRedis tests tailing a Redis stream.
+func TestTailRedis(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("\t%s Could not listen: %v.", failure, err)
+	}
+	defer listener.Close()
+
+	// The server answers XREVRANGE with two entries and the first XREAD with a third,
+	// then lets the next XREAD block.
+	commands := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := &respConn{conn: conn, r: bufio.NewReader(conn)}
+		reads := 0
+		for {
+			command, err := r.read()
+			if err != nil {
+				return
+			}
+			args := command.([]interface{})
+			commands <- args[0].(string)
+			switch args[0] {
+			case "SELECT":
+				conn.Write([]byte("+OK\r\n"))
+			case "XREVRANGE":
+				conn.Write([]byte("*2\r\n" +
+					"*2\r\n$15\r\n1600000000002-0\r\n*2\r\n$4\r\nuser\r\n$3\r\nbob\r\n" +
+					"*2\r\n$15\r\n1600000000001-0\r\n*4\r\n$4\r\nuser\r\n$5\r\nalice\r\n$2\r\nok\r\n:1\r\n"))
+			case "XREAD":
+				if reads++; reads == 1 {
+					conn.Write([]byte("*1\r\n*2\r\n$6\r\nevents\r\n*1\r\n" +
+						"*2\r\n$15\r\n1600000000003-0\r\n*2\r\n$4\r\nuser\r\n$5\r\ncarol\r\n"))
+				}
+			}
+		}
+	}()
+
+	s, _ := tailSession()
+	stop := make(chan struct{})
+	done := make(chan []MQMessage)
+	go func() {
+		messages, err := tailMQ(s, "redis://"+listener.Addr().String()+"/2", "events", 10, stop)
+		if err != nil {
+			t.Errorf("\t%s Tailing the stream failed: %v.", failure, err)
+		}
+		done <- messages
+	}()
+
+	t.Logf("Should read the last entries of the stream, then the new ones.")
+
+	for _, want := range []string{"SELECT", "XREVRANGE", "XREAD", "XREAD"} {
+		select {
+		case command := <-commands:
+			if command != want {
+				t.Fatalf("\t%s Expected %s, got %s.", failure, want, command)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("\t%s Timed out waiting for %s.", failure, want)
+		}
+	}
+	close(stop)
+	messages := <-done
+	if len(messages) != 3 || messages[0].Value != "user=alice ok=1" || messages[1].Key != "user" || messages[2].Value != "carol" ||
+		messages[2].Offset != "1600000000003-0" || messages[2].Time.Unix() != 1600000000 {
+		t.Fatalf("\t%s Expected the entries in order, got %+v.", failure, messages)
+	}
+	t.Logf("\t%s Read %d entries.", success, len(messages))
+}
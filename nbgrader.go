@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// The kernel understands the cell metadata of nbgrader, so Go+ assignments can be
+// autograded by `gopyter run -nbgrader`: locked cells whose source was modified are
+// refused, every cell is executed even after failures, and the points of the grade
+// cells that ran without error are reported as JSON.
+
+// graderMarker matches the lines nbgrader uses to delimit solutions and hidden tests.
+// They are comments for Python but not for Go+, so the kernel drops them.
+var graderMarker = regexp.MustCompile(`(?m)^[ \t]*(#+|//)[ \t]*(BEGIN|END) (SOLUTION|HIDDEN TESTS)[ \t]*\r?\n?`)
+
+// stripGraderMarkers removes the nbgrader markers from code.
+func stripGraderMarkers(code string) string {
+	return graderMarker.ReplaceAllString(code, "")
+}
+
+// gradeResult is the score of a grade cell.
+type gradeResult struct {
+	GradeID   string  `json:"grade_id"`
+	Points    float64 `json:"points"`
+	MaxPoints float64 `json:"max_points"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// gradeReport is the score of an autograded notebook.
+type gradeReport struct {
+	Score    float64       `json:"score"`
+	MaxScore float64       `json:"max_score"`
+	Cells    []gradeResult `json:"cells"`
+}
+
+// grader returns the nbgrader metadata of the cell, or nil if it has none.
+func (cell notebookCell) grader() map[string]interface{} {
+	metadata, _ := cell["metadata"].(map[string]interface{})
+	grader, _ := metadata["nbgrader"].(map[string]interface{})
+	return grader
+}
+
+func (cell notebookCell) isGradeCell() bool {
+	grade, _ := cell.grader()["grade"].(bool)
+	return grade
+}
+
+func (cell notebookCell) isSolutionCell() bool {
+	solution, _ := cell.grader()["solution"].(bool)
+	return solution
+}
+
+// isLockedCell reports whether students may not change the cell. Like in nbgrader,
+// grade cells are locked unless they are also solution cells.
+func (cell notebookCell) isLockedCell() bool {
+	switch {
+	case cell.grader() == nil || cell.isSolutionCell():
+		return false
+	case cell.isGradeCell():
+		return true
+	}
+	locked, _ := cell.grader()["locked"].(bool)
+	return locked
+}
+
+func (cell notebookCell) gradePoints() float64 {
+	points, _ := cell.grader()["points"].(float64)
+	return points
+}
+
+func (cell notebookCell) gradeID() string {
+	id, _ := cell.grader()["grade_id"].(string)
+	return id
+}
+
+// graderChecksum computes the checksum nbgrader stores in the metadata of locked cells
+// to detect changes, the same way nbgrader does.
+func (cell notebookCell) graderChecksum() string {
+	cellType, _ := cell["cell_type"].(string)
+	h := md5.New()
+	h.Write([]byte(cell.source()))
+	h.Write([]byte(cellType))
+	h.Write([]byte(pythonBool(cell.isGradeCell())))
+	h.Write([]byte(pythonBool(cell.isSolutionCell())))
+	h.Write([]byte(pythonBool(cell.isLockedCell())))
+	h.Write([]byte(cell.gradeID()))
+	if cell.isGradeCell() {
+		h.Write([]byte(pythonFloat(cell.gradePoints())))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// modified reports whether a locked cell differs from the version nbgrader released.
+func (cell notebookCell) modified() bool {
+	checksum, ok := cell.grader()["checksum"].(string)
+	return ok && checksum != cell.graderChecksum()
+}
+
+// pythonBool and pythonFloat format values like Python's str, for checksums.
+func pythonBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+func pythonFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if _, err := strconv.Atoi(s); err == nil {
+		s += ".0"
+	}
+	return s
+}
+
+// gradeNotebook executes the code cells of nb in order, stores their outputs in the
+// cells and scores the grade cells. Execution continues after failing cells, and
+// locked cells that were modified are not executed.
+func (kernel *Kernel) gradeNotebook(nb *notebook) gradeReport {
+	report := gradeReport{Cells: []gradeResult{}}
+	count := 0
+	for _, cell := range nb.Cells {
+		var err error
+		if cell.isCode() {
+			count++
+			var outputs []interface{}
+			if cell.isLockedCell() && cell.modified() {
+				err = fmt.Errorf("the locked cell %s was modified", cell.gradeID())
+				outputs = []interface{}{map[string]interface{}{
+					"output_type": "error",
+					"ename":       "LockedCellModified",
+					"evalue":      err.Error(),
+					"traceback":   []string{err.Error()},
+				}}
+			} else {
+				outputs, err = kernel.executeCell(count, cell.id(), cell.source())
+			}
+			cell["execution_count"] = count
+			cell["outputs"] = outputs
+		} else if cell.isLockedCell() && cell.modified() {
+			err = fmt.Errorf("the locked cell %s was modified", cell.gradeID())
+		}
+
+		if !cell.isGradeCell() {
+			continue
+		}
+		result := gradeResult{GradeID: cell.gradeID(), MaxPoints: cell.gradePoints()}
+		if err != nil {
+			result.Error = kernel.session.redactor.redact(err.Error())
+		} else if cell.isCode() {
+			// Markdown grade cells are graded by hand.
+			result.Points = result.MaxPoints
+		}
+		report.Score += result.Points
+		report.MaxScore += result.MaxPoints
+		report.Cells = append(report.Cells, result)
+	}
+	return report
+}
+
+// writeGradeReport writes report as JSON to path, or to the standard error if path is
+// empty.
+func writeGradeReport(path string, report gradeReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "" {
+		_, err = os.Stderr.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
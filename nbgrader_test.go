@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestStripGraderMarkers tests removing the nbgrader markers from a cell.
+func TestStripGraderMarkers(t *testing.T) {
+	code := "func add(a, b int) int {\n\t### BEGIN SOLUTION\n\treturn a + b\n\t### END SOLUTION\n}\n// BEGIN HIDDEN TESTS\nexpect.Eq(add(1, 2), 3)\n// END HIDDEN TESTS\n"
+	want := "func add(a, b int) int {\n\treturn a + b\n}\nexpect.Eq(add(1, 2), 3)\n"
+
+	t.Logf("Should remove the solution and hidden test markers.")
+
+	if got := stripGraderMarkers(code); got != want {
+		t.Fatalf("\t%s Expected %q, got %q.", failure, want, got)
+	}
+	t.Logf("\t%s Removed the markers.", success)
+}
+
+// TestGradeNotebook tests autograding a notebook with nbgrader metadata.
+func TestGradeNotebook(t *testing.T) {
+	graderCell := func(source string, grader map[string]interface{}) notebookCell {
+		cell := newCodeCell(source)
+		cell["metadata"] = map[string]interface{}{"nbgrader": grader}
+		return cell
+	}
+
+	solution := graderCell("### BEGIN SOLUTION\nanswer := 42\n### END SOLUTION", map[string]interface{}{
+		"solution": true, "grade": false, "grade_id": "answer",
+	})
+	passing := graderCell("expect.Eq(answer, 42)", map[string]interface{}{
+		"solution": false, "grade": true, "grade_id": "test_answer", "points": 2.0,
+	})
+	failing := graderCell("expect.Eq(answer, 41)", map[string]interface{}{
+		"solution": false, "grade": true, "grade_id": "test_wrong", "points": 1.0,
+	})
+	tampered := graderCell("expect.Eq(answer, 0)", map[string]interface{}{
+		"solution": false, "grade": true, "grade_id": "test_tampered", "points": 3.0,
+	})
+	for _, cell := range []notebookCell{passing, failing, tampered} {
+		cell.grader()["checksum"] = cell.graderChecksum()
+	}
+	tampered["source"] = "expect.Eq(1, 1)"
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	report := kernel.gradeNotebook(&notebook{Cells: []notebookCell{solution, passing, failing, tampered}})
+
+	t.Logf("Should score the grade cells that pass and refuse modified locked cells.")
+
+	if report.Score != 2 || report.MaxScore != 6 {
+		t.Fatalf("\t%s Expected a score of 2/6, got %v/%v.", failure, report.Score, report.MaxScore)
+	}
+	if len(report.Cells) != 3 || report.Cells[1].Error == "" || report.Cells[2].Error != "the locked cell test_tampered was modified" {
+		t.Fatalf("\t%s Unexpected results %+v.", failure, report.Cells)
+	}
+	if tampered["execution_count"] != 4 {
+		t.Fatalf("\t%s Expected the cells after a failure to be executed.", failure)
+	}
+	t.Logf("\t%s Scored %v/%v.", success, report.Score, report.MaxScore)
+}
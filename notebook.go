@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Tags of the cells involved in parameterizing a notebook, as used by papermill.
+const (
+	parametersTag         = "parameters"
+	injectedParametersTag = "injected-parameters"
+)
+
+// notebook is a Jupyter notebook in nbformat 4. Cells are kept as generic maps so that
+// fields the kernel does not know about are written back unchanged.
+type notebook struct {
+	Cells         []notebookCell         `json:"cells"`
+	Metadata      map[string]interface{} `json:"metadata"`
+	NBFormat      int                    `json:"nbformat"`
+	NBFormatMinor int                    `json:"nbformat_minor"`
+}
+
+// notebookCell is a cell of a notebook.
+type notebookCell map[string]interface{}
+
+// newCodeCell creates a code cell that has not been executed.
+func newCodeCell(source string, tags ...string) notebookCell {
+	tagList := make([]interface{}, len(tags))
+	for i, tag := range tags {
+		tagList[i] = tag
+	}
+	return notebookCell{
+		"cell_type":       "code",
+		"execution_count": nil,
+		"metadata":        map[string]interface{}{"tags": tagList},
+		"outputs":         []interface{}{},
+		"source":          source,
+	}
+}
+
+func (cell notebookCell) isCode() bool {
+	return cell["cell_type"] == "code"
+}
+
+// source returns the source of the cell, which nbformat allows to be stored either as
+// a string or as a list of lines.
+func (cell notebookCell) source() string {
+	switch source := cell["source"].(type) {
+	case string:
+		return source
+	case []interface{}:
+		var b strings.Builder
+		for _, line := range source {
+			if s, ok := line.(string); ok {
+				b.WriteString(s)
+			}
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// id returns the id of the cell, which nbformat 4.5 added.
+func (cell notebookCell) id() string {
+	id, _ := cell["id"].(string)
+	return id
+}
+
+func (cell notebookCell) hasTag(tag string) bool {
+	metadata, _ := cell["metadata"].(map[string]interface{})
+	tags, _ := metadata["tags"].([]interface{})
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func readNotebook(path string) (*notebook, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("reading notebook: %w", err)
+	}
+	var nb notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return nil, xerrors.Errorf("parsing notebook %s: %w", path, err)
+	}
+	if nb.NBFormat != 4 {
+		return nil, xerrors.Errorf("notebook %s has unsupported format %d", path, nb.NBFormat)
+	}
+	return &nb, nil
+}
+
+// writeNotebook writes nb to path, or to the standard output if path is "-".
+func writeNotebook(path string, nb *notebook) error {
+	data, err := json.MarshalIndent(nb, "", " ")
+	if err != nil {
+		return xerrors.Errorf("encoding notebook: %w", err)
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = ioutil.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		return xerrors.Errorf("writing notebook: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goplus/gop"
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	lineMagics["openapi"] = evalOpenAPIMagic
+	documentMagic(openAPISyntax)
+
+	openAPIPackage.RegisterFuncs(
+		openAPIPackage.Func("Call", openAPICall, execOpenAPICall),
+		openAPIPackage.Func("SetHeader", openAPISetHeader, execOpenAPISetHeader),
+	)
+}
+
+var openAPISyntax = &magicSyntax{
+	name:  "%openapi",
+	usage: []string{"<url|file> [server=<url>]", ""},
+	doc: "Loads the operations of an OpenAPI 3 or Swagger 2 description in JSON, so that they can be called " +
+		"with `openapi.Call(\"operationId\", params)`, or lists the operations loaded so far with their " +
+		"parameters.",
+	args: []magicParam{{name: "spec", help: "the URL or the file of the description", optional: true}},
+	options: []magicParam{
+		{name: "server", help: "the base URL of the calls, instead of the first server of the description"},
+	},
+}
+
+// openAPIPackage is the Go+ package calling the operations loaded by %openapi.
+var openAPIPackage = gop.NewGoPackage("openapi")
+
+// openAPITimeout bounds the duration of loading a description and of a call.
+const openAPITimeout = 30 * time.Second
+
+// openAPIRegistry holds the operations loaded by %openapi in a session.
+type openAPIRegistry struct {
+	operations map[string]*openAPIOperation // by operationId and by "METHOD /path"
+	order      []*openAPIOperation
+	header     http.Header // the headers sent with every call
+}
+
+// openAPIOperation is an operation of an API: a method on a path.
+type openAPIOperation struct {
+	id      string // the operationId, or "METHOD /path" if it has none
+	method  string
+	path    string
+	summary string
+	server  string // the base URL of the API
+	params  []openAPIParam
+	body    *openAPIParam // the request body, if the operation takes one
+}
+
+// openAPIParam is a parameter of an operation, passed in the path, the query, a header
+// or a cookie.
+type openAPIParam struct {
+	name        string
+	in          string
+	required    bool
+	contentType string // for the request body
+}
+
+// evalOpenAPIMagic implements
+//
+//	%openapi <url|file> [server=<url>]
+//	%openapi
+//
+// The first form loads the operations of an API description for openapi.Call, the
+// second lists them.
+func evalOpenAPIMagic(kernel *Kernel, outerr OutErr, args string) error {
+	if sandboxed {
+		return errors.New("%openapi: not permitted in the sandbox")
+	}
+	parsed, err := openAPISyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	s := kernel.session
+	if s.openAPI == nil {
+		s.openAPI = &openAPIRegistry{operations: map[string]*openAPIOperation{}, header: http.Header{}}
+	}
+	if parsed.arg(0) == "" {
+		s.openAPI.list(outerr)
+		return nil
+	}
+
+	data, err := readOpenAPISpec(parsed.arg(0))
+	if err != nil {
+		return fmt.Errorf("%%openapi: %v", err)
+	}
+	title, operations, err := parseOpenAPI(data, parsed.arg(0), parsed.options["server"])
+	if err != nil {
+		return fmt.Errorf("%%openapi: %s: %v", parsed.arg(0), err)
+	}
+	for _, op := range operations {
+		s.openAPI.add(op)
+	}
+	if title == "" {
+		title = parsed.arg(0)
+	}
+	server := "no server"
+	if len(operations) != 0 {
+		server = operations[0].server
+	}
+	noun := "operations"
+	if len(operations) == 1 {
+		noun = "operation"
+	}
+	fmt.Fprintf(outerr.out, "Loaded %d %s of %s, calling %s.\n", len(operations), noun, title, server)
+	return nil
+}
+
+// readOpenAPISpec reads an API description from a URL or a file.
+func readOpenAPISpec(location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return ioutil.ReadFile(location)
+	}
+	client := http.Client{Timeout: openAPITimeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// add registers an operation, replacing the operation of the same name loaded before.
+func (r *openAPIRegistry) add(op *openAPIOperation) {
+	key := op.method + " " + op.path
+	if old, ok := r.operations[key]; ok {
+		delete(r.operations, old.id)
+		for i, o := range r.order {
+			if o == old {
+				r.order = append(r.order[:i], r.order[i+1:]...)
+				break
+			}
+		}
+	}
+	r.operations[op.id] = op
+	r.operations[key] = op
+	r.order = append(r.order, op)
+}
+
+// list prints the operations loaded, with their parameters. The required parameters
+// are marked with a star.
+func (r *openAPIRegistry) list(outerr OutErr) {
+	if len(r.order) == 0 {
+		fmt.Fprintln(outerr.out, "No operations loaded.")
+		return
+	}
+	w := tabwriter.NewWriter(outerr.out, 0, 4, 2, ' ', 0)
+	for _, op := range r.order {
+		var params []string
+		for _, p := range op.allParams() {
+			if p.required {
+				params = append(params, p.name+"*")
+			} else {
+				params = append(params, p.name)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s %s\t%s\t%s\n", op.id, op.method, op.path, strings.Join(params, ", "), op.summary)
+	}
+	w.Flush()
+}
+
+// allParams returns the parameters of the operation, including its body.
+func (op *openAPIOperation) allParams() []openAPIParam {
+	if op.body == nil {
+		return op.params
+	}
+	return append(append([]openAPIParam(nil), op.params...), *op.body)
+}
+
+// openAPIMethods are the methods of HTTP that operations may use, in the order they
+// are listed.
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openAPIDoc is the part of an OpenAPI 3 or Swagger 2 description needed to call its
+// operations.
+type openAPIDoc struct {
+	OpenAPI string `json:"openapi"`
+	Swagger string `json:"swagger"`
+	Info    struct {
+		Title string `json:"title"`
+	} `json:"info"`
+	Servers []struct {
+		URL       string `json:"url"`
+		Variables map[string]struct {
+			Default string `json:"default"`
+		} `json:"variables"`
+	} `json:"servers"`
+	Host       string                                `json:"host"`
+	BasePath   string                                `json:"basePath"`
+	Schemes    []string                              `json:"schemes"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Parameters    map[string]openAPIDocParam `json:"parameters"`
+		RequestBodies map[string]openAPIDocBody  `json:"requestBodies"`
+	} `json:"components"`
+	Parameters map[string]openAPIDocParam `json:"parameters"` // Swagger 2
+}
+
+type openAPIDocOperation struct {
+	OperationID string            `json:"operationId"`
+	Summary     string            `json:"summary"`
+	Parameters  []openAPIDocParam `json:"parameters"`
+	RequestBody *openAPIDocBody   `json:"requestBody"`
+	Consumes    []string          `json:"consumes"` // Swagger 2
+}
+
+type openAPIDocParam struct {
+	Ref      string `json:"$ref"`
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+}
+
+type openAPIDocBody struct {
+	Ref      string                     `json:"$ref"`
+	Required bool                       `json:"required"`
+	Content  map[string]json.RawMessage `json:"content"`
+}
+
+// parseOpenAPI reads the title and the operations of an API description loaded from
+// location. The operations are called on server, if set, or on the first server of the
+// description, resolved against location.
+func parseOpenAPI(data []byte, location, server string) (string, []*openAPIOperation, error) {
+	var doc openAPIDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", nil, fmt.Errorf("invalid description, which must be in JSON: %v", err)
+	}
+	swagger := strings.HasPrefix(doc.Swagger, "2.")
+	if !swagger && !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return "", nil, errors.New("not an OpenAPI 3 or Swagger 2 description")
+	}
+
+	if server == "" {
+		server = doc.server(swagger)
+	}
+	if base, err := url.Parse(location); err == nil && (base.Scheme == "http" || base.Scheme == "https") {
+		if ref, err := url.Parse(server); err == nil {
+			server = base.ResolveReference(ref).String()
+		}
+	}
+	server = strings.TrimSuffix(server, "/")
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var operations []*openAPIOperation
+	for _, path := range paths {
+		item := doc.Paths[path]
+		var shared []openAPIDocParam
+		if raw, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(raw, &shared); err != nil {
+				return "", nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		for _, method := range openAPIMethods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+			var docOp openAPIDocOperation
+			if err := json.Unmarshal(raw, &docOp); err != nil {
+				return "", nil, fmt.Errorf("%s %s: %v", method, path, err)
+			}
+			op, err := doc.operation(method, path, server, shared, &docOp)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s %s: %v", strings.ToUpper(method), path, err)
+			}
+			operations = append(operations, op)
+		}
+	}
+	return doc.Info.Title, operations, nil
+}
+
+// openAPIVariable matches the variables of server URLs and paths, as {name}.
+var openAPIVariable = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// server returns the first server of the description.
+func (doc *openAPIDoc) server(swagger bool) string {
+	if swagger {
+		if doc.Host == "" {
+			return doc.BasePath
+		}
+		scheme := "https"
+		if len(doc.Schemes) != 0 {
+			scheme = doc.Schemes[0]
+		}
+		return scheme + "://" + doc.Host + doc.BasePath
+	}
+	if len(doc.Servers) == 0 {
+		return "/"
+	}
+	s := doc.Servers[0]
+	return openAPIVariable.ReplaceAllStringFunc(s.URL, func(v string) string {
+		return s.Variables[v[1:len(v)-1]].Default
+	})
+}
+
+// operation converts an operation of the description.
+func (doc *openAPIDoc) operation(method, path, server string, shared []openAPIDocParam, docOp *openAPIDocOperation) (*openAPIOperation, error) {
+	op := &openAPIOperation{
+		id:      docOp.OperationID,
+		method:  strings.ToUpper(method),
+		path:    path,
+		summary: docOp.Summary,
+		server:  server,
+	}
+	if op.id == "" {
+		op.id = op.method + " " + path
+	}
+
+	// The parameters of the operation override the parameters of its path with the
+	// same name and location.
+	seen := map[string]bool{}
+	for _, params := range [][]openAPIDocParam{docOp.Parameters, shared} {
+		for _, p := range params {
+			p, err := doc.resolveParam(p)
+			if err != nil {
+				return nil, err
+			}
+			if seen[p.In+" "+p.Name] {
+				continue
+			}
+			seen[p.In+" "+p.Name] = true
+			switch p.In {
+			case "path", "query", "header", "cookie":
+				op.params = append(op.params, openAPIParam{name: p.Name, in: p.In, required: p.Required || p.In == "path"})
+			case "body":
+				op.body = &openAPIParam{name: "body", in: "body", required: p.Required, contentType: "application/json"}
+				if len(docOp.Consumes) != 0 {
+					op.body.contentType = docOp.Consumes[0]
+				}
+			default:
+				return nil, fmt.Errorf("parameters in %s are not supported", p.In)
+			}
+		}
+	}
+
+	if body := docOp.RequestBody; body != nil {
+		if strings.HasPrefix(body.Ref, "#/components/requestBodies/") {
+			resolved, ok := doc.Components.RequestBodies[strings.TrimPrefix(body.Ref, "#/components/requestBodies/")]
+			if !ok {
+				return nil, fmt.Errorf("unknown request body %s", body.Ref)
+			}
+			body = &resolved
+		}
+		op.body = &openAPIParam{name: "body", in: "body", required: body.Required, contentType: "application/json"}
+		if _, ok := body.Content["application/json"]; !ok {
+			types := make([]string, 0, len(body.Content))
+			for t := range body.Content {
+				types = append(types, t)
+			}
+			sort.Strings(types)
+			if len(types) != 0 {
+				op.body.contentType = types[0]
+			}
+		}
+	}
+	return op, nil
+}
+
+// resolveParam resolves a reference to a parameter of the components of the
+// description.
+func (doc *openAPIDoc) resolveParam(p openAPIDocParam) (openAPIDocParam, error) {
+	switch {
+	case p.Ref == "":
+		return p, nil
+	case strings.HasPrefix(p.Ref, "#/components/parameters/"):
+		if resolved, ok := doc.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]; ok {
+			return resolved, nil
+		}
+	case strings.HasPrefix(p.Ref, "#/parameters/"):
+		if resolved, ok := doc.Parameters[strings.TrimPrefix(p.Ref, "#/parameters/")]; ok {
+			return resolved, nil
+		}
+	}
+	return p, fmt.Errorf("unknown parameter %s", p.Ref)
+}
+
+// openAPICall implements openapi.Call, which calls an operation loaded by %openapi
+// with params, a map from the names of its parameters to their values, and returns the
+// response. The request body is the value of "body": strings and []byte are sent as
+// they are, other values as JSON. Like the other builtins it reports errors by
+// panicking, which fails the cell.
+func openAPICall(operation string, params interface{}) *HTTPResponse {
+	resp, err := activeSession.openAPI.call(operation, params)
+	if err != nil {
+		panic(xerrors.Errorf("openapi.Call: %w", err))
+	}
+	return resp
+}
+
+func execOpenAPICall(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, openAPICall(args[0].(string), args[1]))
+}
+
+// openAPISetHeader implements openapi.SetHeader, which sets a header sent with every
+// call, e.g. for authentication. An empty value removes the header.
+func openAPISetHeader(name, value string) {
+	r := activeSession.openAPI
+	if r == nil {
+		r = &openAPIRegistry{operations: map[string]*openAPIOperation{}, header: http.Header{}}
+		activeSession.openAPI = r
+	}
+	if value == "" {
+		r.header.Del(name)
+	} else {
+		r.header.Set(name, value)
+	}
+}
+
+func execOpenAPISetHeader(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	openAPISetHeader(args[0].(string), args[1].(string))
+	p.Ret(2)
+}
+
+// call sends a request for an operation.
+func (r *openAPIRegistry) call(operation string, params interface{}) (*HTTPResponse, error) {
+	if sandboxed {
+		return nil, errSandboxed
+	}
+	var op *openAPIOperation
+	if r != nil {
+		op = r.operations[operation]
+	}
+	if op == nil {
+		return nil, fmt.Errorf("unknown operation %s: load its API with %%openapi", operation)
+	}
+	values, err := openAPIParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	path := op.path
+	query := url.Values{}
+	header := http.Header{}
+	for name, values := range r.header {
+		header[name] = values
+	}
+	var cookies []*http.Cookie
+	var body []byte
+	for _, p := range op.allParams() {
+		v, ok := values[p.name]
+		if !ok {
+			if p.required {
+				return nil, fmt.Errorf("%s: missing parameter %s", op.id, p.name)
+			}
+			continue
+		}
+		delete(values, p.name)
+		switch p.in {
+		case "path":
+			path = strings.Replace(path, "{"+p.name+"}", url.PathEscape(openAPIString(v)), -1)
+		case "query":
+			if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+				for i := 0; i < rv.Len(); i++ {
+					query.Add(p.name, openAPIString(rv.Index(i).Interface()))
+				}
+			} else {
+				query.Add(p.name, openAPIString(v))
+			}
+		case "header":
+			header.Set(p.name, openAPIString(v))
+		case "cookie":
+			cookies = append(cookies, &http.Cookie{Name: p.name, Value: openAPIString(v)})
+		case "body":
+			switch v := v.(type) {
+			case string:
+				body = []byte(v)
+			case []byte:
+				body = v
+			default:
+				if body, err = json.Marshal(v); err != nil {
+					return nil, fmt.Errorf("%s: cannot encode the body: %v", op.id, err)
+				}
+			}
+			header.Set("Content-Type", p.contentType)
+		}
+	}
+	if len(values) != 0 {
+		var names, known []string
+		for name := range values {
+			names = append(names, name)
+		}
+		for _, p := range op.allParams() {
+			known = append(known, p.name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%s: unknown parameters %s; it takes %s", op.id, strings.Join(names, ", "), strings.Join(known, ", "))
+	}
+
+	u := op.server + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(op.method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	client := http.Client{Timeout: openAPITimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readHTTPResponse(resp)
+}
+
+// openAPIParams converts the parameters of openapi.Call, a map with string keys or
+// nil, to a map.
+func openAPIParams(params interface{}) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if params == nil {
+		return values, nil
+	}
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("the parameters must be a map with string keys, not %T", params)
+	}
+	for _, k := range v.MapKeys() {
+		values[k.String()] = v.MapIndex(k).Interface()
+	}
+	return values, nil
+}
+
+// openAPIString formats the value of a parameter.
+func openAPIString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const petstoreSpec = `{
+  "openapi": "3.0.0",
+  "info": {"title": "Petstore"},
+  "servers": [{"url": "/{version}", "variables": {"version": {"default": "v1"}}}],
+  "paths": {
+    "/pets": {
+      "get": {"operationId": "listPets", "summary": "Lists the pets", "parameters": [
+        {"name": "tag", "in": "query"}, {"$ref": "#/components/parameters/limit"}
+      ]},
+      "post": {"operationId": "addPet", "requestBody": {"$ref": "#/components/requestBodies/Pet"}}
+    },
+    "/pets/{petId}": {
+      "parameters": [{"name": "petId", "in": "path", "required": true}],
+      "delete": {"parameters": [{"name": "X-Reason", "in": "header"}]}
+    }
+  },
+  "components": {
+    "parameters": {"limit": {"name": "limit", "in": "query", "required": true}},
+    "requestBodies": {"Pet": {"required": true, "content": {"application/json": {}}}}
+  }
+}`
+
+// TestParseOpenAPI tests reading the operations of OpenAPI and Swagger descriptions.
+func TestParseOpenAPI(t *testing.T) {
+	t.Logf("Should read the operations of an OpenAPI 3 description.")
+
+	title, operations, err := parseOpenAPI([]byte(petstoreSpec), "https://example.com/specs/openapi.json", "")
+	if err != nil {
+		t.Fatalf("\t%s Could not read the description: %v.", failure, err)
+	}
+	var ids []string
+	for _, op := range operations {
+		ids = append(ids, op.id)
+	}
+	if want := []string{"listPets", "addPet", "DELETE /pets/{petId}"}; title != "Petstore" || !reflect.DeepEqual(ids, want) {
+		t.Fatalf("\t%s Expected the operations %q of Petstore, got %q of %q.", failure, want, ids, title)
+	}
+	if server := operations[0].server; server != "https://example.com/v1" {
+		t.Fatalf("\t%s Expected the server to be resolved against the description, got %q.", failure, server)
+	}
+	wantParams := []openAPIParam{{name: "tag", in: "query"}, {name: "limit", in: "query", required: true}}
+	if !reflect.DeepEqual(operations[0].params, wantParams) || operations[1].body == nil || !operations[1].body.required ||
+		!reflect.DeepEqual(operations[2].params, []openAPIParam{{name: "X-Reason", in: "header"}, {name: "petId", in: "path", required: true}}) {
+		t.Fatalf("\t%s Expected the parameters to be resolved, got %+v.", failure, operations)
+	}
+	t.Logf("\t%s Read the operations.", success)
+
+	t.Logf("Should read the operations of a Swagger 2 description.")
+
+	swagger := `{"swagger": "2.0", "host": "api.example.com", "basePath": "/v2", "schemes": ["http"], "paths": {
+	  "/user": {"put": {"operationId": "updateUser", "consumes": ["application/xml"], "parameters": [{"name": "user", "in": "body"}]}}
+	}}`
+	_, operations, err = parseOpenAPI([]byte(swagger), "swagger.json", "")
+	if err != nil || len(operations) != 1 || operations[0].server != "http://api.example.com/v2" ||
+		operations[0].body == nil || operations[0].body.contentType != "application/xml" {
+		t.Fatalf("\t%s Expected the body of updateUser, got %+v (%v).", failure, operations, err)
+	}
+	if _, _, err := parseOpenAPI([]byte(swagger), "swagger.json", "http://localhost:8080/"); err != nil {
+		t.Fatalf("\t%s Could not read the description: %v.", failure, err)
+	}
+	t.Logf("\t%s Read the operations.", success)
+
+	t.Logf("Should refuse descriptions it cannot read.")
+
+	for _, spec := range []string{"openapi: 3.0.0", `{"info": {}}`, `{"openapi": "3.1.0", "paths": {"/": {"get": {"parameters": [{"$ref": "#/nope"}]}}}}`} {
+		if _, _, err := parseOpenAPI([]byte(spec), "spec.json", ""); err == nil {
+			t.Fatalf("\t%s Expected %q to be refused.", failure, spec)
+		}
+	}
+	t.Logf("\t%s Refused them.", success)
+}
+
+// TestOpenAPIMagic tests loading an API and calling its operations from cells.
+func TestOpenAPIMagic(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/openapi.json" {
+			w.Write([]byte(petstoreSpec))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + r.Header.Get("X-Reason") + r.Header.Get("Authorization") + " " + string(body)))
+	}))
+	defer server.Close()
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+
+	t.Logf("Should load the operations of an API.")
+
+	if _, err := kernel.doEvalGop(outerr, "%openapi "+server.URL+"/openapi.json"); err != nil {
+		t.Fatalf("\t%s %%openapi failed: %v.", failure, err)
+	}
+	if text := out.String(); text != "Loaded 3 operations of Petstore, calling "+server.URL+"/v1.\n" {
+		t.Fatalf("\t%s Expected the operations to be loaded, got %q.", failure, text)
+	}
+	out.Reset()
+	if _, err := kernel.doEvalGop(outerr, "%openapi"); err != nil || !strings.Contains(out.String(), "GET /pets             tag, limit*       Lists the pets") ||
+		!strings.Contains(out.String(), "X-Reason, petId*") {
+		t.Fatalf("\t%s Expected the operations to be listed, got %q (%v).", failure, out.String(), err)
+	}
+	t.Logf("\t%s Loaded the operations.", success)
+
+	t.Logf("Should call the operations.")
+
+	cases := []struct {
+		setup, code, body string
+	}{
+		{"", `openapi.Call("listPets", {"limit": 2, "tag": "cat"})`, "GET /v1/pets?limit=2&tag=cat  "},
+		{"", `openapi.Call("GET /pets", {"limit": []int{1, 2}})`, "GET /v1/pets?limit=1&limit=2  "},
+		{"", `openapi.Call("addPet", {"body": {"name": "Rex"}})`, `POST /v1/pets  {"name":"Rex"}`},
+		{"", `openapi.Call("DELETE /pets/{petId}", {"petId": "a b", "X-Reason": "sold"})`, "DELETE /v1/pets/a%20b sold "},
+		{"openapi.SetHeader(\"Authorization\", \"token\")\n", `openapi.Call("addPet", {"body": "raw"})`, "POST /v1/pets token raw"},
+	}
+	for i, tc := range cases {
+		vals, err := kernel.doEvalGop(outerr, fmt.Sprintf("%sresp%d := %s\nstring(resp%d.Body)", tc.setup, i, tc.code, i))
+		if err != nil || len(vals) != 1 || vals[0] != tc.body {
+			t.Fatalf("\t%s Expected %q, got %v (%v).", failure, tc.body, vals, err)
+		}
+		t.Logf("\t%s Sent %q.", success, tc.body)
+	}
+
+	t.Logf("Should refuse invalid calls.")
+
+	errCases := []struct {
+		code, err string
+	}{
+		{`openapi.Call("listPets", nil)`, "missing parameter limit"},
+		{`openapi.Call("listPets", {"limit": 1, "page": 2})`, "unknown parameters page; it takes tag, limit"},
+		{`openapi.Call("getPet", nil)`, "load its API with %openapi"},
+		{`openapi.Call("listPets", 3)`, "must be a map with string keys"},
+	}
+	for _, tc := range errCases {
+		if _, err := kernel.doEvalGop(outerr, "_ = "+tc.code); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("\t%s Expected an error with %q, got %v.", failure, tc.err, err)
+		}
+		t.Logf("\t%s Reported %q.", success, tc.err)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// outputLimiter enforces the output limits of a single execution. It is shared by the
+// stdout and stderr writers of a cell so that the limits apply to their combined output.
+// Once a limit is crossed the rest of the output is withheld from the front-end and, if
+// a spill directory is configured, saved to a file instead.
+type outputLimiter struct {
+	maxBytes int
+	maxLines int
+	spillDir string
+	maxPage  int // the bytes of the spill file paged at most
+
+	lock      sync.Mutex
+	bytes     int
+	lines     int
+	truncated bool
+	shown     bytes.Buffer
+	spill     *os.File
+}
+
+// maxPageBytes is the size of the spill files beyond which only their start is paged,
+// since the page payload is sent in the execute_reply.
+const maxPageBytes = 1 << 20
+
+// newOutputLimiter creates an outputLimiter using the limits of the given config.
+func newOutputLimiter(config KernelConfig) *outputLimiter {
+	return &outputLimiter{
+		maxBytes: config.OutputMaxBytes,
+		maxLines: config.OutputMaxLines,
+		spillDir: config.OutputSpillDir,
+		maxPage:  maxPageBytes,
+	}
+}
+
+// filter accounts for the chunk p and returns the part of it that may still be shown.
+// When p is the chunk that crosses a limit, note holds the message telling the user
+// that the output was truncated; it is empty otherwise.
+func (l *outputLimiter) filter(p []byte) (visible []byte, note string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.truncated {
+		l.save(p)
+		return nil, ""
+	}
+
+	n := len(p)
+	if l.maxBytes > 0 && l.bytes+n > l.maxBytes {
+		n = l.maxBytes - l.bytes
+	}
+	if l.maxLines > 0 && l.lines >= l.maxLines {
+		n = 0
+	} else if l.maxLines > 0 {
+		lines := l.lines
+		for i, c := range p[:n] {
+			if c == '\n' {
+				lines++
+				if lines == l.maxLines && i+1 < n {
+					n = i + 1
+					break
+				}
+			}
+		}
+	}
+
+	// Never split a multibyte character.
+	for n < len(p) && n > 0 && !utf8.RuneStart(p[n]) {
+		n--
+	}
+
+	visible = p[:n]
+	l.bytes += n
+	l.lines += bytes.Count(visible, []byte{'\n'})
+	if l.spillDir != "" {
+		l.shown.Write(visible)
+	}
+
+	if n == len(p) {
+		return visible, ""
+	}
+
+	l.truncated = true
+	l.startSpill()
+	l.save(p[n:])
+
+	note = fmt.Sprintf("\n... output truncated after %d bytes and %d lines", l.bytes, l.lines)
+	if l.spill != nil {
+		note += fmt.Sprintf("; full output saved to %s", l.spill.Name())
+	}
+	return visible, note + " ...\n"
+}
+
+// startSpill creates the spill file and writes the output shown so far into it.
+func (l *outputLimiter) startSpill() {
+	if l.spillDir == "" {
+		return
+	}
+	if err := os.MkdirAll(l.spillDir, 0755); err != nil {
+		log.Printf("Error creating output spill directory: %v\n", err)
+		return
+	}
+	f, err := ioutil.TempFile(l.spillDir, "output-*.txt")
+	if err != nil {
+		log.Printf("Error creating output spill file: %v\n", err)
+		return
+	}
+	l.spill = f
+	l.save(l.shown.Bytes())
+	l.shown.Reset()
+}
+
+// save appends withheld output to the spill file, if there is one.
+func (l *outputLimiter) save(p []byte) {
+	if l.spill == nil {
+		return
+	}
+	if _, err := l.spill.Write(p); err != nil {
+		log.Printf("Error writing output spill file: %v\n", err)
+	}
+}
+
+// finish closes the spill file and, if the output was truncated and saved, returns the
+// `page` payload that lets the front-end show the full output in its pager, or its
+// start and the path of the file if the output is larger than l.maxPage.
+func (l *outputLimiter) finish() []interface{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.spill == nil {
+		return nil
+	}
+
+	name := l.spill.Name()
+	if err := l.spill.Close(); err != nil {
+		log.Printf("Error closing output spill file: %v\n", err)
+	}
+	l.spill = nil
+
+	f, err := os.Open(name)
+	if err != nil {
+		log.Printf("Error reading output spill file: %v\n", err)
+		return nil
+	}
+	defer f.Close()
+	page, err := ioutil.ReadAll(io.LimitReader(f, int64(l.maxPage)+1))
+	if err != nil {
+		log.Printf("Error reading output spill file: %v\n", err)
+		return nil
+	}
+	text := string(page)
+	if len(page) > l.maxPage {
+		// Never split a multibyte character.
+		n := l.maxPage
+		for n > 0 && !utf8.RuneStart(page[n]) {
+			n--
+		}
+		text = fmt.Sprintf("%s\n... the output goes on in %s ...\n", page[:n], name)
+	}
+
+	return []interface{}{
+		map[string]interface{}{
+			"source": "page",
+			"data":   MIMEMap{MIMETypeText: text},
+			"start":  0,
+		},
+	}
+}
+
+// iopubThrottle limits the rate of the stream messages of a single execution, so tight
+// print loops do not flood the front-end. Like the outputLimiter, it is shared by the
+// stdout and stderr writers of a cell. Chunks written faster than the rate are held and
+// merged with the adjacent chunks of the same stream, and published together once the
+// interval between messages has passed. If more chunks than a second's worth of
+// messages are held, because the streams alternate, the next ones are dropped.
+type iopubThrottle struct {
+	publish    func(stream, text string) error
+	interval   time.Duration
+	maxPending int
+
+	lock      sync.Mutex
+	last      time.Time // when the last messages were published
+	pending   []streamChunk
+	timer     *time.Timer
+	written   int // chunks written
+	published int // messages published
+	dropped   int // chunks dropped
+}
+
+// streamChunk is output held by an iopubThrottle.
+type streamChunk struct {
+	stream, text string
+}
+
+// newIOPubThrottle creates a throttle publishing at most rate messages per second with
+// publish. It returns nil, which publishes every chunk right away, if rate is not
+// positive.
+func newIOPubThrottle(rate int, publish func(stream, text string) error) *iopubThrottle {
+	if rate <= 0 {
+		return nil
+	}
+	return &iopubThrottle{publish: publish, interval: time.Second / time.Duration(rate), maxPending: rate}
+}
+
+// write publishes text on stream, or holds it until the rate allows it.
+func (t *iopubThrottle) write(stream, text string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.written++
+	if n := len(t.pending); n != 0 && t.pending[n-1].stream == stream {
+		t.pending[n-1].text += text
+	} else if n >= t.maxPending {
+		t.dropped++
+		return nil
+	} else {
+		t.pending = append(t.pending, streamChunk{stream, text})
+	}
+	return t.flushLocked(false)
+}
+
+// flushLocked publishes the held chunks, unless the interval since the last messages
+// has not passed and force is false, in which case it schedules their publication.
+func (t *iopubThrottle) flushLocked(force bool) error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+	if wait := t.interval - time.Since(t.last); wait > 0 && !force {
+		if t.timer == nil {
+			t.timer = time.AfterFunc(wait, func() {
+				t.lock.Lock()
+				defer t.lock.Unlock()
+				t.timer = nil
+				if err := t.flushLocked(true); err != nil {
+					log.Printf("Error publishing output: %v\n", err)
+				}
+			})
+		}
+		return nil
+	}
+	pending := t.pending
+	t.pending = nil
+	t.last = time.Now()
+	for _, c := range pending {
+		t.published++
+		if err := t.publish(c.stream, c.text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush publishes the held chunks right away, e.g. before other output of the cell so
+// the output stays in order.
+func (t *iopubThrottle) flush() {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err := t.flushLocked(true); err != nil {
+		log.Printf("Error publishing output: %v\n", err)
+	}
+}
+
+// finish publishes the held chunks, once the cell has written all its output, and a
+// note telling the user that chunks were merged or dropped, if any were.
+func (t *iopubThrottle) finish() {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	if err := t.flushLocked(true); err != nil {
+		log.Printf("Error publishing output: %v\n", err)
+	}
+	if t.published+t.dropped == t.written {
+		return
+	}
+	note := fmt.Sprintf("\n... output rate limited: %d writes were published as %d messages", t.written, t.published)
+	if t.dropped != 0 {
+		note += fmt.Sprintf(", and %d were dropped", t.dropped)
+	}
+	if err := t.publish(StreamStderr, note+" ...\n"); err != nil {
+		log.Printf("Error publishing output: %v\n", err)
+	}
+}
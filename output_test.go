@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOutputLimiter tests that output crossing the configured limits is truncated.
+func TestOutputLimiter(t *testing.T) {
+	cases := []struct {
+		MaxBytes  int
+		MaxLines  int
+		Chunks    []string
+		Visible   string
+		Truncated bool
+	}{
+		{0, 0, []string{"a\n", "b\n"}, "a\nb\n", false},
+		{4, 0, []string{"ab", "cdef"}, "abcd", true},
+		{0, 2, []string{"a\nb\nc\n"}, "a\nb\n", true},
+		{0, 2, []string{"a\nb\n", "c"}, "a\nb\n", true},
+		{5, 0, []string{"ab€"}, "ab€", false},
+		{4, 0, []string{"ab€"}, "ab", true},
+	}
+
+	t.Logf("Should truncate output that exceeds the limits.")
+
+	for k, tc := range cases {
+		t.Logf("  Checking limiter case %d/%d.", k+1, len(cases))
+
+		limiter := newOutputLimiter(KernelConfig{OutputMaxBytes: tc.MaxBytes, OutputMaxLines: tc.MaxLines})
+
+		var visible strings.Builder
+		var truncated bool
+		for _, chunk := range tc.Chunks {
+			p, note := limiter.filter([]byte(chunk))
+			visible.Write(p)
+			truncated = truncated || note != ""
+		}
+
+		if visible.String() != tc.Visible || truncated != tc.Truncated {
+			t.Errorf("\t%s Limiter showed %q (truncated: %v), expected %q (truncated: %v).",
+				failure, visible.String(), truncated, tc.Visible, tc.Truncated)
+			continue
+		}
+		t.Logf("\t%s Showed the expected output.", success)
+	}
+}
+
+// TestOutputLimiterSpill tests that the full output of a truncated cell is saved and paged.
+func TestOutputLimiterSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-spill")
+	if err != nil {
+		t.Fatalf("\t%s ioutil.TempDir: %s", failure, err)
+	}
+	defer os.RemoveAll(dir)
+
+	limiter := newOutputLimiter(KernelConfig{OutputMaxLines: 1, OutputSpillDir: dir})
+	limiter.filter([]byte("first\nsecond\n"))
+	limiter.filter([]byte("third\n"))
+
+	payload := limiter.finish()
+	if len(payload) != 1 {
+		t.Fatalf("\t%s Expected a single page payload but got %d.", failure, len(payload))
+	}
+
+	page := payload[0].(map[string]interface{})
+	text := page["data"].(MIMEMap)[MIMETypeText]
+	if page["source"] != "page" || text != "first\nsecond\nthird\n" {
+		t.Fatalf("\t%s Page payload does not hold the full output: %v", failure, page)
+	}
+	t.Logf("\t%s Saved the full output to a page payload.", success)
+
+	t.Logf("Should only page the start of an output larger than the page.")
+
+	limiter = newOutputLimiter(KernelConfig{OutputMaxLines: 1, OutputSpillDir: dir})
+	limiter.maxPage = 8
+	limiter.filter([]byte("first\nsecond\nthird\n"))
+	payload = limiter.finish()
+	if len(payload) != 1 {
+		t.Fatalf("\t%s Expected a single page payload but got %d.", failure, len(payload))
+	}
+	text = payload[0].(map[string]interface{})["data"].(MIMEMap)[MIMETypeText]
+	if !strings.HasPrefix(text.(string), "first\nse\n... the output goes on in "+dir) {
+		t.Fatalf("\t%s Expected the start of the output and its file, got %q.", failure, text)
+	}
+	t.Logf("\t%s Paged %q.", success, text)
+}
+
+// TestIOPubThrottle tests that output written faster than the rate limit is merged
+// into fewer messages.
+func TestIOPubThrottle(t *testing.T) {
+	var messages []streamChunk
+	publish := func(stream, text string) error {
+		messages = append(messages, streamChunk{stream, text})
+		return nil
+	}
+
+	t.Logf("Should merge the chunks written faster than the rate, keeping their order.")
+
+	throttle := newIOPubThrottle(4, publish)
+	throttle.write(StreamStdout, "a")
+	for _, text := range []string{"b", "c"} {
+		throttle.write(StreamStdout, text)
+	}
+	throttle.write(StreamStderr, "d")
+	throttle.write(StreamStdout, "e")
+	throttle.finish()
+
+	var got []string
+	for _, m := range messages {
+		got = append(got, m.stream+":"+m.text)
+	}
+	want := []string{"stdout:a", "stdout:bc", "stderr:d", "stdout:e", "stderr:\n... output rate limited: 5 writes were published as 4 messages ...\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("\t%s Expected %q, got %q.", failure, want, got)
+	}
+	t.Logf("\t%s Merged them.", success)
+
+	t.Logf("Should drop chunks beyond a second's worth of messages, and say so.")
+
+	messages = nil
+	throttle = newIOPubThrottle(2, publish)
+	for _, stream := range []string{StreamStdout, StreamStdout, StreamStderr, StreamStdout, StreamStderr} {
+		throttle.write(stream, "x")
+	}
+	throttle.finish()
+	if last := messages[len(messages)-1].text; len(messages) != 4 || !strings.Contains(last, "5 writes were published as 3 messages, and 1 were dropped") {
+		t.Fatalf("\t%s Expected a chunk to be dropped, got %q.", failure, messages)
+	}
+	t.Logf("\t%s Dropped them.", success)
+
+	t.Logf("Should publish the chunks once the interval has passed, and not limit the rate when disabled.")
+
+	messages = nil
+	throttle = newIOPubThrottle(100, publish)
+	throttle.write(StreamStdout, "a")
+	throttle.write(StreamStdout, "b")
+	time.Sleep(50 * time.Millisecond)
+	throttle.lock.Lock()
+	n := len(messages)
+	throttle.lock.Unlock()
+	if n != 2 {
+		t.Fatalf("\t%s Expected the held chunk to be published, got %q.", failure, messages)
+	}
+	if newIOPubThrottle(0, publish) != nil {
+		t.Fatalf("\t%s Expected no throttle without a rate.", failure)
+	}
+	t.Logf("\t%s Published them.", success)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	lineMagics["params"] = evalParamsMagic
+	documentMagic(paramsSyntax)
+}
+
+var paramsSyntax = &magicSyntax{
+	name:  "%params",
+	usage: []string{"name=value..."},
+	doc: "Sets parameters in the session the same way `gopyter run -param` does. Numbers, " +
+		"`true`/`false` and quoted strings keep their type; other values are strings.",
+	anyOptions: true,
+	verbatim:   true,
+}
+
+// param is a notebook parameter given as `name=value`.
+type param struct {
+	Name  string
+	Value string
+}
+
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseParam parses a `name=value` parameter.
+func parseParam(s string) (param, error) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return param{}, fmt.Errorf("invalid parameter %q, expected name=value", s)
+	}
+	p := param{Name: s[:i], Value: s[i+1:]}
+	if !paramNamePattern.MatchString(p.Name) {
+		return param{}, fmt.Errorf("invalid parameter name %q", p.Name)
+	}
+	return p, nil
+}
+
+// paramList collects the parameters given with repeated -param flags.
+type paramList []param
+
+func (l *paramList) String() string {
+	var s []string
+	for _, p := range *l {
+		s = append(s, p.Name+"="+p.Value)
+	}
+	return strings.Join(s, " ")
+}
+
+func (l *paramList) Set(s string) error {
+	p, err := parseParam(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, p)
+	return nil
+}
+
+// paramLiteral returns the Go+ literal for the value of a parameter. Integers,
+// floating-point numbers, booleans and quoted strings are kept as they are, so the
+// variable gets the corresponding type; any other value becomes a string.
+func paramLiteral(value string) string {
+	if _, err := strconv.ParseInt(value, 0, 64); err == nil {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	if value == "true" || value == "false" {
+		return value
+	}
+	if _, err := strconv.Unquote(value); err == nil {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// paramsCode returns the code setting params in the session. Variables that are already
+// declared, typically by the cell tagged `parameters`, are assigned so they keep their
+// declared type; the others are declared.
+func (kernel *Kernel) paramsCode(params []param) string {
+	var b strings.Builder
+	for _, p := range params {
+		op := ":="
+		if _, err := kernel.session.Peek(p.Name); err == nil {
+			op = "="
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", p.Name, op, paramLiteral(p.Value))
+	}
+	return b.String()
+}
+
+// evalParamsMagic implements `%params name=value...`, which sets parameters in the
+// session the same way `gopyter run -param` does.
+func evalParamsMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := paramsSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	options := parsed.options
+	if len(options) == 0 {
+		return paramsSyntax.errorf("expected name=value arguments")
+	}
+
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := make([]param, 0, len(names))
+	for _, name := range names {
+		p, err := parseParam(name + "=" + options[name])
+		if err != nil {
+			return fmt.Errorf("%%params: %v", err)
+		}
+		params = append(params, p)
+	}
+
+	_, err = kernel.evalCode(kernel.paramsCode(params))
+	return err
+}
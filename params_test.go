@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+// TestParamLiteral tests that parameter values are typed like their literals.
+func TestParamLiteral(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"42", "42"},
+		{"0x1f", "0x1f"},
+		{"-2.5", "-2.5"},
+		{"true", "true"},
+		{`"quoted"`, `"quoted"`},
+		{"hello world", `"hello world"`},
+		{"", `""`},
+	}
+
+	t.Logf("Should convert parameter values to Go+ literals.")
+
+	for k, tc := range cases {
+		t.Logf("  Converting value %d/%d.", k+1, len(cases))
+
+		if got := paramLiteral(tc.Input); got != tc.Output {
+			t.Errorf("\t%s paramLiteral(%q) = %q, expected %q.", failure, tc.Input, got, tc.Output)
+			continue
+		}
+		t.Logf("\t%s Returned the expected literal.", success)
+	}
+}
+
+// TestExecuteNotebook tests that parameters are injected after the parameters cell.
+func TestExecuteNotebook(t *testing.T) {
+	nb := &notebook{
+		Cells: []notebookCell{
+			newCodeCell("n := 1", parametersTag),
+			newCodeCell("n = 0", injectedParametersTag),
+			newCodeCell("n * 2"),
+		},
+		NBFormat: 4,
+	}
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	if err := kernel.executeNotebook(nb, []param{{"n", "21"}}); err != nil {
+		t.Fatalf("\t%s Executing the notebook failed: %v.", failure, err)
+	}
+
+	if len(nb.Cells) != 3 || !nb.Cells[1].hasTag(injectedParametersTag) || nb.Cells[1].source() != "n = 21\n" {
+		t.Fatalf("\t%s Unexpected cells %v.", failure, nb.Cells)
+	}
+	outputs := nb.Cells[2]["outputs"].([]interface{})
+	if len(outputs) != 1 || outputs[0].(map[string]interface{})["data"].(MIMEMap)[MIMETypeText] != "42" {
+		t.Fatalf("\t%s Unexpected outputs %v.", failure, outputs)
+	}
+	t.Logf("\t%s Executed the notebook with the injected parameters.", success)
+}
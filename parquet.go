@@ -0,0 +1,1013 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/goplus/gop"
+)
+
+// This file implements just enough of the Parquet format to preview files: it reads the
+// first rows of flat schemas stored with the PLAIN, dictionary and delta encodings,
+// either uncompressed or compressed with Snappy or gzip, which covers the files written
+// with the default settings of the common writers. Nested columns, the other encodings
+// and the other codecs are reported as unsupported.
+// See https://github.com/apache/parquet-format.
+
+// readParquetPreview reads the first n rows of the Parquet file at path.
+func readParquetPreview(path string, n int) *TablePreview {
+	preview, err := previewParquet(path, n)
+	if err != nil {
+		panic(err)
+	}
+	return preview
+}
+
+func execReadParquetPreview(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, readParquetPreview(args[0].(string), args[1].(int)))
+}
+
+// The enums of the Parquet format used by the preview.
+const (
+	parquetBoolean           = 0
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetInt96             = 3
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+
+	parquetOptional = 1
+
+	parquetUTF8            = 0
+	parquetEnum            = 4
+	parquetDecimal         = 5
+	parquetDate            = 6
+	parquetTimestampMillis = 9
+	parquetTimestampMicros = 10
+	parquetJSON            = 19
+
+	parquetUncompressed = 0
+	parquetSnappy       = 1
+	parquetGzip         = 2
+
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+
+	parquetPlain           = 0
+	parquetPlainDictionary = 2
+	parquetRLE             = 3
+	parquetDeltaBinary     = 5
+	parquetDeltaLength     = 6
+	parquetDeltaByteArray  = 7
+	parquetRLEDictionary   = 8
+)
+
+var parquetTypeNames = []string{"bool", "int32", "int64", "int96", "float", "double", "binary", "fixed"}
+
+// parquetColumn is a leaf of the schema of a Parquet file.
+type parquetColumn struct {
+	name      string
+	typ       int64
+	length    int64 // the length of fixed length byte arrays
+	optional  bool
+	converted int64 // the converted type, or -1
+	scale     int64
+	precision int64
+	isString  bool
+}
+
+func previewParquet(path string, n int) (*TablePreview, error) {
+	if sandboxed && !inSandbox(path) {
+		return nil, &os.PathError{Op: "open", Path: path, Err: errSandboxed}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	meta, err := readParquetFooter(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	columns, err := parquetSchema(meta.list(2))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	preview := &TablePreview{Path: path, Total: int(meta.int(3))}
+	for _, c := range columns {
+		preview.Columns = append(preview.Columns, c.name)
+		preview.Types = append(preview.Types, c.typeName())
+	}
+
+	for _, rg := range meta.list(4) {
+		if len(preview.Rows) >= n {
+			break
+		}
+		rowGroup := rg.(thriftStruct)
+		want := n - len(preview.Rows)
+		if rows := int(rowGroup.int(3)); rows < want {
+			want = rows
+		}
+
+		rows := make([][]string, want)
+		nulls := make([][]bool, want)
+		for i := range rows {
+			rows[i] = make([]string, len(columns))
+			nulls[i] = make([]bool, len(columns))
+		}
+		for j, chunk := range rowGroup.list(1) {
+			if j >= len(columns) {
+				break
+			}
+			values, err := readParquetColumn(f, columns[j], chunk.(thriftStruct).strct(3), want)
+			if err != nil {
+				return nil, fmt.Errorf("%s: column %s: %v", path, columns[j].name, err)
+			}
+			for i := 0; i < want && i < len(values); i++ {
+				if values[i] == nil {
+					nulls[i][j] = true
+				} else {
+					rows[i][j] = columns[j].format(values[i])
+				}
+			}
+		}
+		preview.Rows = append(preview.Rows, rows...)
+		preview.Nulls = append(preview.Nulls, nulls...)
+	}
+	return preview, nil
+}
+
+// readParquetFooter reads the FileMetaData at the end of a Parquet file.
+func readParquetFooter(f *os.File) (thriftStruct, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var tail [8]byte
+	if info.Size() < 12 {
+		return nil, errors.New("not a Parquet file")
+	}
+	if _, err := f.ReadAt(tail[:], info.Size()-8); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != "PAR1" {
+		return nil, errors.New("not a Parquet file")
+	}
+	size := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if size > info.Size()-12 {
+		return nil, errors.New("invalid footer")
+	}
+	footer := make([]byte, size)
+	if _, err := f.ReadAt(footer, info.Size()-8-size); err != nil {
+		return nil, err
+	}
+	return readThriftStruct(&thriftReader{data: footer})
+}
+
+// parquetSchema returns the columns of a flat schema.
+func parquetSchema(schema []interface{}) ([]parquetColumn, error) {
+	if len(schema) == 0 {
+		return nil, errors.New("missing schema")
+	}
+	var columns []parquetColumn
+	for _, e := range schema[1:] {
+		element := e.(thriftStruct)
+		name := element.str(4)
+		if element.int(5) > 0 {
+			return nil, fmt.Errorf("nested column %s is not supported", name)
+		}
+		if element.int(3) > parquetOptional {
+			return nil, fmt.Errorf("repeated column %s is not supported", name)
+		}
+		c := parquetColumn{
+			name:      name,
+			typ:       element.int(1),
+			length:    element.int(2),
+			optional:  element.int(3) == parquetOptional,
+			converted: -1,
+			scale:     element.int(7),
+			precision: element.int(8),
+		}
+		if _, ok := element[6]; ok {
+			c.converted = element.int(6)
+		}
+		if logical := element.strct(10); logical != nil {
+			_, c.isString = logical[1]
+		}
+		switch c.converted {
+		case parquetUTF8, parquetEnum, parquetJSON:
+			c.isString = true
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// typeName returns the type of the column shown in the preview.
+func (c parquetColumn) typeName() string {
+	switch {
+	case c.isString:
+		return "string"
+	case c.converted == parquetDate:
+		return "date"
+	case c.converted == parquetTimestampMillis || c.converted == parquetTimestampMicros:
+		return "timestamp"
+	case c.converted == parquetDecimal:
+		return fmt.Sprintf("decimal(%d,%d)", c.precision, c.scale)
+	case c.typ >= 0 && int(c.typ) < len(parquetTypeNames):
+		return parquetTypeNames[c.typ]
+	}
+	return "unknown"
+}
+
+// format formats a value of the column.
+func (c parquetColumn) format(v interface{}) string {
+	switch c.converted {
+	case parquetDate:
+		if days, ok := v.(int32); ok {
+			return time.Unix(int64(days)*86400, 0).UTC().Format("2006-01-02")
+		}
+	case parquetTimestampMillis:
+		if ms, ok := v.(int64); ok {
+			return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+		}
+	case parquetTimestampMicros:
+		if us, ok := v.(int64); ok {
+			return time.Unix(0, us*int64(time.Microsecond)).UTC().Format(time.RFC3339Nano)
+		}
+	case parquetDecimal:
+		var unscaled *big.Int
+		switch v := v.(type) {
+		case int32:
+			unscaled = big.NewInt(int64(v))
+		case int64:
+			unscaled = big.NewInt(v)
+		case []byte:
+			unscaled = new(big.Int).SetBytes(v)
+			if len(v) > 0 && v[0]&0x80 != 0 {
+				unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(v)*8)))
+			}
+		}
+		if unscaled != nil {
+			return formatDecimal(unscaled, int(c.scale))
+		}
+	}
+	switch v := v.(type) {
+	case []byte:
+		if c.isString || utf8.Valid(v) {
+			return string(v)
+		}
+		return "0x" + hex.EncodeToString(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// formatDecimal formats the decimal number unscaled * 10^-scale.
+func formatDecimal(unscaled *big.Int, scale int) string {
+	digits := new(big.Int).Abs(unscaled).String()
+	sign := ""
+	if unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	if scale <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// readParquetColumn reads the first n values of a column chunk. Null values are nil.
+func readParquetColumn(f *os.File, c parquetColumn, meta thriftStruct, n int) ([]interface{}, error) {
+	if meta == nil {
+		return nil, errors.New("missing column metadata")
+	}
+	codec := meta.int(4)
+	offset := meta.int(9)
+	if dict, ok := meta[11]; ok && dict.(int64) > 0 && dict.(int64) < offset {
+		offset = dict.(int64)
+	}
+	end := offset + meta.int(7)
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if end > info.Size() {
+		return nil, errors.New("column chunk beyond the end of the file")
+	}
+
+	var dictionary []interface{}
+	var values []interface{}
+	for len(values) < n && offset < end {
+		page, size, err := readParquetPageHeader(f, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(size)
+
+		// The size comes from the file, so it must fit in the chunk before anything is
+		// allocated.
+		bodySize := page.int(3)
+		if bodySize < 0 || bodySize > end-offset {
+			return nil, fmt.Errorf("invalid page size %d", bodySize)
+		}
+		body := make([]byte, bodySize)
+		if _, err := f.ReadAt(body, offset); err != nil {
+			return nil, err
+		}
+		offset += int64(len(body))
+
+		switch page.int(1) {
+		case parquetDictionaryPage:
+			header := page.strct(7)
+			data, err := parquetDecompress(codec, body, int(page.int(2)))
+			if err != nil {
+				return nil, err
+			}
+			if dictionary, _, err = decodeParquetPlain(c, data, int(header.int(1))); err != nil {
+				return nil, err
+			}
+		case parquetDataPage:
+			header := page.strct(5)
+			data, err := parquetDecompress(codec, body, int(page.int(2)))
+			if err != nil {
+				return nil, err
+			}
+			count := int(header.int(1))
+			var defined []bool
+			if c.optional {
+				if len(data) < 4 {
+					return nil, errors.New("truncated page")
+				}
+				size := int(binary.LittleEndian.Uint32(data))
+				if 4+size > len(data) {
+					return nil, errors.New("truncated page")
+				}
+				defined = decodeParquetLevels(data[4:4+size], count)
+				data = data[4+size:]
+			}
+			page, err := decodeParquetValues(c, header.int(2), data, count, defined, dictionary)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, page...)
+		case parquetDataPageV2:
+			header := page.strct(8)
+			count := int(header.int(1))
+			defSize, repSize := int(header.int(5)), int(header.int(6))
+			if defSize+repSize > len(body) {
+				return nil, errors.New("truncated page")
+			}
+			var defined []bool
+			if c.optional {
+				defined = decodeParquetLevels(body[repSize:repSize+defSize], count)
+			}
+			data := body[repSize+defSize:]
+			if compressed, ok := header[7]; !ok || compressed.(bool) {
+				var err error
+				if data, err = parquetDecompress(codec, data, int(page.int(2))-repSize-defSize); err != nil {
+					return nil, err
+				}
+			}
+			page, err := decodeParquetValues(c, header.int(4), data, count, defined, dictionary)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, page...)
+		}
+	}
+	return values, nil
+}
+
+// readParquetPageHeader reads the header of the page at offset, and returns its size.
+func readParquetPageHeader(f *os.File, offset int64) (thriftStruct, int, error) {
+	// Page headers are small unless they hold large statistics, but their exact size is
+	// only known once decoded.
+	for size := 256; ; size *= 4 {
+		header := make([]byte, size)
+		read, err := f.ReadAt(header, offset)
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		r := &thriftReader{data: header[:read]}
+		page, err := readThriftStruct(r)
+		if err == errThriftTruncated && read == size && size < 1<<24 {
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid page header: %v", err)
+		}
+		return page, r.pos, nil
+	}
+}
+
+// decodeParquetLevels decodes the definition levels of a flat optional column, telling
+// which of its values are defined.
+func decodeParquetLevels(data []byte, count int) []bool {
+	levels := decodeParquetHybrid(data, 1, count)
+	defined := make([]bool, count)
+	for i := range defined {
+		defined[i] = i < len(levels) && levels[i] == 1
+	}
+	return defined
+}
+
+// decodeParquetValues decodes the values of a data page, with nil for the undefined ones.
+func decodeParquetValues(c parquetColumn, encoding int64, data []byte, count int, defined []bool, dictionary []interface{}) ([]interface{}, error) {
+	present := count
+	if defined != nil {
+		present = 0
+		for _, d := range defined {
+			if d {
+				present++
+			}
+		}
+	}
+
+	var decoded []interface{}
+	switch encoding {
+	case parquetPlain:
+		var err error
+		if decoded, _, err = decodeParquetPlain(c, data, present); err != nil {
+			return nil, err
+		}
+	case parquetPlainDictionary, parquetRLEDictionary:
+		if len(data) == 0 {
+			if present != 0 {
+				return nil, errors.New("truncated page")
+			}
+			break
+		}
+		for _, i := range decodeParquetHybrid(data[1:], int(data[0]), present) {
+			if int(i) >= len(dictionary) {
+				return nil, errors.New("invalid dictionary index")
+			}
+			decoded = append(decoded, dictionary[i])
+		}
+	case parquetRLE:
+		if c.typ != parquetBoolean || len(data) < 4 {
+			return nil, errors.New("unsupported RLE encoding")
+		}
+		for _, v := range decodeParquetHybrid(data[4:], 1, present) {
+			decoded = append(decoded, v == 1)
+		}
+	case parquetDeltaBinary:
+		if c.typ != parquetInt32 && c.typ != parquetInt64 {
+			return nil, errors.New("unsupported delta encoding")
+		}
+		ints, _, err := decodeParquetDelta(data, present)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range ints {
+			if c.typ == parquetInt32 {
+				decoded = append(decoded, int32(v))
+			} else {
+				decoded = append(decoded, v)
+			}
+		}
+	case parquetDeltaLength:
+		var err error
+		if decoded, _, err = decodeParquetDeltaLength(data, present); err != nil {
+			return nil, err
+		}
+	case parquetDeltaByteArray:
+		prefixes, n, err := decodeParquetDelta(data, present)
+		if err != nil {
+			return nil, err
+		}
+		suffixes, _, err := decodeParquetDeltaLength(data[n:], present)
+		if err != nil {
+			return nil, err
+		}
+		var previous []byte
+		for i, suffix := range suffixes {
+			if prefixes[i] < 0 || int(prefixes[i]) > len(previous) {
+				return nil, errors.New("invalid prefix length")
+			}
+			v := append(previous[:prefixes[i]:prefixes[i]], suffix.([]byte)...)
+			decoded = append(decoded, v)
+			previous = v
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %d", encoding)
+	}
+	if len(decoded) < present {
+		return nil, errors.New("truncated page")
+	}
+
+	if defined == nil {
+		return decoded, nil
+	}
+	values := make([]interface{}, count)
+	for i, j := 0, 0; i < count; i++ {
+		if defined[i] {
+			values[i] = decoded[j]
+			j++
+		}
+	}
+	return values, nil
+}
+
+// decodeParquetPlain decodes count values of the PLAIN encoding, returning the number of
+// bytes they took.
+func decodeParquetPlain(c parquetColumn, data []byte, count int) ([]interface{}, int, error) {
+	values := make([]interface{}, 0, count)
+	pos := 0
+	need := func(n int) error {
+		if pos+n > len(data) {
+			return errors.New("truncated page")
+		}
+		return nil
+	}
+	for i := 0; i < count; i++ {
+		switch c.typ {
+		case parquetBoolean:
+			// Booleans are bit-packed, least significant bit first.
+			if i%8 == 0 {
+				if err := need(1); err != nil {
+					return nil, 0, err
+				}
+				pos++
+			}
+			values = append(values, data[i/8]>>(uint(i)%8)&1 == 1)
+		case parquetInt32:
+			if err := need(4); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, int32(binary.LittleEndian.Uint32(data[pos:])))
+			pos += 4
+		case parquetInt64:
+			if err := need(8); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, int64(binary.LittleEndian.Uint64(data[pos:])))
+			pos += 8
+		case parquetInt96:
+			if err := need(12); err != nil {
+				return nil, 0, err
+			}
+			// Legacy timestamps: nanoseconds of the day, then the Julian day.
+			nanos := int64(binary.LittleEndian.Uint64(data[pos:]))
+			day := int64(binary.LittleEndian.Uint32(data[pos+8:]))
+			values = append(values, time.Unix((day-2440588)*86400, nanos).UTC().Format(time.RFC3339Nano))
+			pos += 12
+		case parquetFloat:
+			if err := need(4); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, math.Float32frombits(binary.LittleEndian.Uint32(data[pos:])))
+			pos += 4
+		case parquetDouble:
+			if err := need(8); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(data[pos:])))
+			pos += 8
+		case parquetByteArray:
+			if err := need(4); err != nil {
+				return nil, 0, err
+			}
+			size := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			if err := need(size); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, data[pos:pos+size])
+			pos += size
+		case parquetFixedLenByteArray:
+			size := int(c.length)
+			if err := need(size); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, data[pos:pos+size])
+			pos += size
+		default:
+			return nil, 0, fmt.Errorf("unsupported type %d", c.typ)
+		}
+	}
+	return values, pos, nil
+}
+
+// decodeParquetDelta decodes count integers of the DELTA_BINARY_PACKED encoding,
+// returning the number of bytes they took.
+func decodeParquetDelta(data []byte, count int) ([]int64, int, error) {
+	errCorrupt := errors.New("corrupt delta encoding")
+	pos := 0
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			pos = len(data) + 1
+			return 0
+		}
+		pos += n
+		return v
+	}
+	varint := func() int64 {
+		v := uvarint()
+		return int64(v>>1) ^ -int64(v&1)
+	}
+
+	blockSize, miniblocks, total, value := int(uvarint()), int(uvarint()), int(uvarint()), varint()
+	if pos > len(data) || miniblocks <= 0 || blockSize%miniblocks != 0 {
+		return nil, 0, errCorrupt
+	}
+	if total < count {
+		count = total
+	}
+	perMiniblock := blockSize / miniblocks
+
+	var values []int64
+	if total > 0 {
+		values = append(values, value)
+	}
+	for len(values) < total {
+		minDelta := varint()
+		if pos+miniblocks > len(data) {
+			return nil, 0, errCorrupt
+		}
+		widths := data[pos : pos+miniblocks]
+		pos += miniblocks
+		for _, width := range widths {
+			if len(values) >= total {
+				break
+			}
+			size := perMiniblock * int(width) / 8
+			if pos+size > len(data) {
+				return nil, 0, errCorrupt
+			}
+			for i := 0; i < perMiniblock && len(values) < total; i++ {
+				var delta uint64
+				for b := 0; b < int(width); b++ {
+					bit := i*int(width) + b
+					delta |= uint64(data[pos+bit/8]>>(uint(bit)%8)&1) << uint(b)
+				}
+				value += minDelta + int64(delta)
+				values = append(values, value)
+			}
+			pos += size
+		}
+	}
+	return values[:count], pos, nil
+}
+
+// decodeParquetDeltaLength decodes count byte arrays of the DELTA_LENGTH_BYTE_ARRAY
+// encoding, returning the number of bytes they took.
+func decodeParquetDeltaLength(data []byte, count int) ([]interface{}, int, error) {
+	lengths, pos, err := decodeParquetDelta(data, count)
+	if err != nil {
+		return nil, 0, err
+	}
+	values := make([]interface{}, 0, len(lengths))
+	for _, length := range lengths {
+		if length < 0 || pos+int(length) > len(data) {
+			return nil, 0, errors.New("truncated page")
+		}
+		values = append(values, data[pos:pos+int(length)])
+		pos += int(length)
+	}
+	return values, pos, nil
+}
+
+// decodeParquetHybrid decodes up to count values of the RLE/bit-packing hybrid encoding.
+func decodeParquetHybrid(data []byte, width int, count int) []uint64 {
+	var values []uint64
+	pos := 0
+	for len(values) < count && pos < len(data) {
+		header, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			break
+		}
+		pos += n
+		if header&1 == 0 {
+			// A run of the same value.
+			size := (width + 7) / 8
+			if pos+size > len(data) {
+				break
+			}
+			var v uint64
+			for i := 0; i < size; i++ {
+				v |= uint64(data[pos+i]) << (8 * uint(i))
+			}
+			pos += size
+			for i := uint64(0); i < header>>1 && len(values) < count; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+		// Groups of 8 bit-packed values, least significant bits first.
+		bits := int(header>>1) * 8 * width
+		for i := 0; i+width <= bits && len(values) < count; i += width {
+			var v uint64
+			for b := 0; b < width; b++ {
+				bit := i + b
+				if pos+bit/8 >= len(data) {
+					return values
+				}
+				v |= uint64(data[pos+bit/8]>>(uint(bit)%8)&1) << uint(b)
+			}
+			values = append(values, v)
+		}
+		pos += (bits + 7) / 8
+	}
+	return values
+}
+
+// parquetDecompress decompresses a page of size bytes once decompressed.
+func parquetDecompress(codec int64, data []byte, size int) ([]byte, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid page size %d", size)
+	}
+	switch codec {
+	case parquetUncompressed:
+		return data, nil
+	case parquetSnappy:
+		return snappyDecode(data)
+	case parquetGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(r, int64(size)+1))
+		if err == nil && len(data) > size {
+			err = errors.New("page larger than its header says")
+		}
+		return data, err
+	}
+	return nil, fmt.Errorf("unsupported compression codec %d", codec)
+}
+
+// snappyMaxExpansion bounds how many times larger than a Snappy block its decoded data
+// is: no element decodes to more than 22 times its size, as a copy of 3 bytes copies at
+// most 64 bytes.
+const snappyMaxExpansion = 22
+
+// snappyDecode decodes a block of the Snappy format.
+// See https://github.com/google/snappy/blob/main/format_description.txt.
+func snappyDecode(src []byte) ([]byte, error) {
+	errCorrupt := errors.New("corrupt Snappy data")
+	size, n := binary.Uvarint(src)
+	if n <= 0 || size > uint64(len(src))*snappyMaxExpansion {
+		return nil, errCorrupt
+	}
+	dst := make([]byte, 0, size)
+	pos := n
+	for pos < len(src) {
+		tag := src[pos]
+		pos++
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag >> 2)
+			if length >= 60 {
+				extra := length - 59
+				if pos+extra > len(src) {
+					return nil, errCorrupt
+				}
+				length = 0
+				for i := 0; i < extra; i++ {
+					length |= int(src[pos+i]) << (8 * uint(i))
+				}
+				pos += extra
+			}
+			length++
+			if pos+length > len(src) || uint64(len(dst)+length) > size {
+				return nil, errCorrupt
+			}
+			dst = append(dst, src[pos:pos+length]...)
+			pos += length
+			continue
+		case 1:
+			if pos >= len(src) {
+				return nil, errCorrupt
+			}
+			length = 4 + int(tag>>2)&7
+			offset = int(tag>>5)<<8 | int(src[pos])
+			pos++
+		case 2:
+			if pos+2 > len(src) {
+				return nil, errCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[pos:]))
+			pos += 2
+		case 3:
+			if pos+4 > len(src) {
+				return nil, errCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[pos:]))
+			pos += 4
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+length) > size {
+			return nil, errCorrupt
+		}
+		// Copies may overlap their own output, so copy byte by byte.
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != size {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
+
+// thriftStruct is a struct decoded from the Thrift compact protocol, which Parquet uses
+// for its metadata, mapping field ids to values. Integers are int64, binaries []byte,
+// lists []interface{} and structs thriftStruct.
+type thriftStruct map[int16]interface{}
+
+func (s thriftStruct) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s thriftStruct) str(id int16) string {
+	v, _ := s[id].([]byte)
+	return string(v)
+}
+
+func (s thriftStruct) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+func (s thriftStruct) strct(id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
+
+// thriftReader reads the Thrift compact protocol.
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+var errThriftTruncated = errors.New("truncated metadata")
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errThriftTruncated
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) varint() (int64, error) {
+	v, err := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func readThriftStruct(r *thriftReader) (thriftStruct, error) {
+	s := thriftStruct{}
+	var id int16
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		typ := b & 0x0f
+		switch typ {
+		case 1, 2:
+			// Booleans are encoded in the type of their field.
+			s[id] = typ == 1
+			continue
+		}
+		if s[id], err = readThriftValue(r, typ); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readThriftValue(r *thriftReader, typ byte) (interface{}, error) {
+	switch typ {
+	case 1, 2:
+		// Booleans in containers take a byte.
+		b, err := r.byte()
+		return b == 1, err
+	case 3:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case 4, 5, 6:
+		return r.varint()
+	case 7:
+		if r.pos+8 > len(r.data) {
+			return nil, errThriftTruncated
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+		return v, nil
+	case 8:
+		size, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(r.data)-r.pos) < size {
+			return nil, errThriftTruncated
+		}
+		v := r.data[r.pos : r.pos+int(size)]
+		r.pos += int(size)
+		return v, nil
+	case 9, 10:
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(b >> 4)
+		if size == 15 {
+			if size, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if size > uint64(len(r.data)) {
+			return nil, errThriftTruncated
+		}
+		list := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			v, err := readThriftValue(r, b&0x0f)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case 11:
+		size, err := r.uvarint()
+		if err != nil || size == 0 {
+			return map[interface{}]interface{}{}, err
+		}
+		types, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(len(r.data)) {
+			return nil, errThriftTruncated
+		}
+		m := make(map[interface{}]interface{}, size)
+		for i := uint64(0); i < size; i++ {
+			k, err := readThriftValue(r, types>>4)
+			if err != nil {
+				return nil, err
+			}
+			v, err := readThriftValue(r, types&0x0f)
+			if err != nil {
+				return nil, err
+			}
+			if b, ok := k.([]byte); ok {
+				k = string(b)
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 12:
+		return readThriftStruct(r)
+	}
+	return nil, fmt.Errorf("unknown Thrift type %d", typ)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	lineMagics["pkgdoc"] = evalPkgdocMagic
+	documentMagic(pkgdocSyntax)
+}
+
+var pkgdocSyntax = &magicSyntax{
+	name:  "%pkgdoc",
+	usage: []string{"path[@version]"},
+	doc: "Shows the overview and the index of the documentation of any package, imported or not, " +
+		"extracted from its sources in the module cache, or else fetched from pkg.go.dev and saved for offline use.",
+	args: []magicParam{
+		{name: "path", help: "the import path of the package, like `github.com/google/uuid`, optionally followed by `@version`"},
+	},
+}
+
+// pkgGoDev is the address of pkg.go.dev, where %pkgdoc fetches the documentation of
+// the packages that are not in the module cache.
+var pkgGoDev = "https://pkg.go.dev"
+
+// pkgGoDevTimeout bounds the time fetching documentation from pkg.go.dev takes.
+const pkgGoDevTimeout = 20 * time.Second
+
+// pkgGoDevMaxAge is how long the documentation of the latest version of a package
+// fetched from pkg.go.dev is used before it is fetched again. That of a given version
+// does not change.
+const pkgGoDevMaxAge = 24 * time.Hour
+
+// cachedDocSource returns the source of the package with path importPath in the module
+// cache, at version, or at the latest version in the cache if version is "".
+func cachedDocSource(importPath, version string) (docSource, bool) {
+	cache := moduleCacheDir()
+	if cache == "" {
+		return docSource{}, false
+	}
+	elems := strings.Split(importPath, "/")
+	dir := cache
+	for i, elem := range elems {
+		module, rel := strings.Join(elems[:i+1], "/"), strings.Join(elems[i+1:], "/")
+		if version != "" {
+			if src, ok := moduleDocSource(importPath, rel, module, version); ok && isPackageDir(src.Dir) {
+				return src, true
+			}
+			continue
+		}
+		entry, ok := cacheEntry(dir, elem)
+		if !ok {
+			return docSource{}, false
+		}
+		if j := strings.LastIndexByte(filepath.Base(entry), '@'); j >= 0 {
+			src, _ := moduleDocSource(importPath, rel, module, filepath.Base(entry)[j+1:])
+			return src, isPackageDir(src.Dir)
+		}
+		dir = entry
+	}
+	return docSource{}, false
+}
+
+// packageSource returns the sources of the package with path importPath at version:
+// those of the session for the packages it knows, or those in the module cache.
+func (kernel *Kernel) packageSource(importPath, version string) (docSource, bool) {
+	if version == "" {
+		if src, ok := kernel.docSource(importPath); ok && isPackageDir(src.Dir) {
+			return src, true
+		}
+	}
+	return cachedDocSource(importPath, version)
+}
+
+// sandboxedSource returns an error if the sources of src cannot be read in the
+// sandbox, because they are outside of its roots.
+func sandboxedSource(src docSource) error {
+	if sandboxed && !inSandbox(src.Dir) {
+		return &os.PathError{Op: "open", Path: src.Dir, Err: errSandboxed}
+	}
+	return nil
+}
+
+// localPackageDocs returns the documentation of the package with path importPath at
+// version, extracted from its sources.
+func (kernel *Kernel) localPackageDocs(importPath, version string) (*packageDocs, docSource, bool) {
+	src, ok := kernel.packageSource(importPath, version)
+	if !ok || sandboxedSource(src) != nil {
+		return nil, src, false
+	}
+	docs, err := packageDocCache.lookup(src)
+	if err != nil {
+		return nil, src, false
+	}
+	return docs, src, true
+}
+
+var (
+	// pkgGoDevOverview and pkgGoDevIndex match the overview and the index of the
+	// documentation pages of pkg.go.dev.
+	pkgGoDevOverview = regexp.MustCompile(`(?s)id="pkg-overview".*?</h3>(.*?)<div class="Documentation-index"`)
+	pkgGoDevIndex    = regexp.MustCompile(`(?s)id="pkg-index".*?</h3>(.*?)</ul>\s*</div>`)
+	pkgGoDevEntry    = regexp.MustCompile(`<a href="#([^"]+)">(.*?)</a>`)
+	pkgGoDevName     = regexp.MustCompile(`<h1[^>]*>\s*(?:package\s+)?([\pL_][\pL\pN_]*)\s*</h1>`)
+	htmlPre          = regexp.MustCompile(`(?s)<pre[^>]*>(.*?)</pre>`)
+	htmlHeading      = regexp.MustCompile(`(?s)<h4[^>]*>(.*?)</h4>`)
+	htmlTag          = regexp.MustCompile(`<[^>]*>`)
+)
+
+// parsePkgGoDev extracts the documentation of the package with path importPath from
+// its page on pkg.go.dev: the overview, as text, and the declarations of the index, by
+// the names they declare.
+func parsePkgGoDev(importPath string, page []byte) (*packageDocs, error) {
+	overview := pkgGoDevOverview.FindSubmatch(page)
+	index := pkgGoDevIndex.FindSubmatch(page)
+	if overview == nil && index == nil {
+		return nil, fmt.Errorf("no documentation for %s on pkg.go.dev", importPath)
+	}
+	docs := &packageDocs{Format: docFormat, Path: importPath, Name: path.Base(importPath), Symbols: make(map[string]symbolDoc)}
+	if m := pkgGoDevName.FindSubmatch(page); m != nil {
+		docs.Name = string(m[1])
+	}
+	if overview != nil {
+		docs.Doc = htmlText(string(overview[1]))
+	}
+	if index != nil {
+		for _, m := range pkgGoDevEntry.FindAllSubmatch(index[1], -1) {
+			name := string(m[1])
+			if !strings.HasPrefix(name, "pkg-") {
+				docs.Symbols[name] = symbolDoc{Decl: htmlText(string(m[2]))}
+			}
+		}
+	}
+	return docs, nil
+}
+
+// htmlText returns the text of the HTML of a doc comment, with its code blocks
+// indented and its headings marked like in Go doc comments.
+func htmlText(s string) string {
+	s = htmlPre.ReplaceAllStringFunc(s, func(pre string) string {
+		code := htmlTag.ReplaceAllString(htmlPre.FindStringSubmatch(pre)[1], "")
+		return "\n\n\t" + strings.Replace(strings.TrimRight(code, "\n"), "\n", "\n\t", -1) + "\n\n"
+	})
+	s = htmlHeading.ReplaceAllString(s, "\n\n# $1\n\n")
+	s = strings.NewReplacer("</p>", "\n\n", "<br>", "\n", "</li>", "\n", "<li>", "  - ").Replace(s)
+	s = html.UnescapeString(htmlTag.ReplaceAllString(s, ""))
+	var lines []string
+	blank := true
+	for _, line := range strings.Split(s, "\n") {
+		if !strings.HasPrefix(line, "\t") {
+			line = strings.TrimSpace(line)
+		}
+		if line == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// fetchPkgGoDev returns the documentation of the package with path importPath at
+// version, or at the latest version if version is "", fetched from pkg.go.dev. It is
+// saved in the directory of the cache, and used from there when it cannot be fetched,
+// as when offline.
+func (c *docCache) fetchPkgGoDev(importPath, version string, offline bool) (*packageDocs, error) {
+	key := importPath + "@" + version
+	if version == "" {
+		key += "latest"
+	}
+	var saved *packageDocs
+	var file string
+	if c.dir != "" {
+		file = filepath.Join(c.dir, "pkg.go.dev", filepath.FromSlash(key)+".json")
+		var docs packageDocs
+		if data, err := ioutil.ReadFile(file); err == nil && json.Unmarshal(data, &docs) == nil && docs.Format == docFormat {
+			saved = &docs
+			if info, err := os.Stat(file); err == nil && (version != "" || time.Since(info.ModTime()) < pkgGoDevMaxAge) {
+				return saved, nil
+			}
+		}
+	}
+	if offline {
+		if saved != nil {
+			return saved, nil
+		}
+		return nil, fmt.Errorf("%s is not in the module cache, and the kernel is offline", importPath)
+	}
+
+	url := pkgGoDev + "/" + importPath
+	if version != "" {
+		url += "@" + version
+	}
+	docs, err := func() (*packageDocs, error) {
+		client := http.Client{Timeout: pkgGoDevTimeout}
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", url, resp.Status)
+		}
+		page, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return parsePkgGoDev(importPath, page)
+	}()
+	if err != nil {
+		if saved != nil {
+			return saved, nil
+		}
+		return nil, fmt.Errorf("could not fetch the documentation of %s: %v", importPath, err)
+	}
+	if file != "" {
+		// The documentation is fetched again next time if it cannot be saved.
+		if data, err := json.Marshal(docs); err == nil && os.MkdirAll(filepath.Dir(file), 0755) == nil {
+			if err := ioutil.WriteFile(file, data, 0644); err != nil {
+				log.Printf("Could not save the documentation of %s: %v\n", key, err)
+			}
+		}
+	}
+	return docs, nil
+}
+
+// overviewMarkdown returns the overview of the documentation of the package and its
+// index as Markdown, each name linking to its documentation at url.
+func (d *packageDocs) overviewMarkdown(url string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## package %s\n\n`import %q`\n\n", d.Name, d.Path)
+	if d.Doc != "" {
+		b.WriteString(strings.TrimSpace(d.Doc) + "\n\n")
+	}
+
+	// Names are listed like in the index of go doc: methods after their type.
+	names := make([]string, 0, len(d.Symbols))
+	for name := range d.Symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := strings.SplitN(names[i], ".", 2), strings.SplitN(names[j], ".", 2)
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		return len(a) < len(b) || len(a) == len(b) && names[i] < names[j]
+	})
+	if len(names) != 0 {
+		b.WriteString("### Index\n\n")
+	}
+	for _, name := range names {
+		indent := ""
+		if strings.Contains(name, ".") {
+			indent = "  "
+		}
+		fmt.Fprintf(&b, "%s- [`%s`](%s#%s)\n", indent, markdownCell(declLine(name, d.Symbols[name].Decl)), url, name)
+	}
+	return b.String()
+}
+
+// evalPkgdocMagic implements `%pkgdoc path[@version]`, which shows the overview and the
+// index of the documentation of a package.
+func evalPkgdocMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := pkgdocSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	importPath, version := splitPackageVersion(parsed.arg(0))
+	if importPath == "" {
+		return pkgdocSyntax.errorf("no package")
+	}
+
+	docs, src, ok := kernel.localPackageDocs(importPath, version)
+	url := pkgGoDevURL(src, "")
+	if !ok && sandboxed {
+		// Neither the sources outside of the roots nor pkg.go.dev can be read.
+		if err := sandboxedSource(src); src.Dir != "" && err != nil {
+			return err
+		}
+		return fmt.Errorf("%%pkgdoc: no sources for package %s within the sandbox roots", importPath)
+	}
+	if !ok {
+		offline := kernel.config.Offline || os.Getenv("GOPROXY") == "off"
+		if docs, err = packageDocCache.fetchPkgGoDev(importPath, version, offline); err != nil {
+			return err
+		}
+		url = pkgGoDevURL(docSource{Path: importPath, Version: version}, "")
+	}
+	text := docs.Doc
+	if text == "" {
+		text = "package " + docs.Name
+	}
+	kernel.display(outerr, MakeData3(MIMETypeMarkdown, text, docs.overviewMarkdown(url)))
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// pkgGoDevPage is the part of a documentation page of pkg.go.dev that %pkgdoc reads.
+const pkgGoDevPage = `<html><body>
+<h1 class="UnitHeader-titleHeading">uuid</h1>
+<div class="Documentation-overview"><h3 tabindex="-1" id="pkg-overview" class="Documentation-overviewHeader">Overview <a href="#pkg-overview">¶</a></h3>
+<p>Package uuid generates and inspects UUIDs &amp; more.</p>
+<h4 id="hdr-Usage">Usage</h4>
+<pre>id := uuid.New()
+fmt.Println(id)
+</pre>
+</div>
+<div class="Documentation-index"><h3 tabindex="-1" id="pkg-index" class="Documentation-indexHeader">Index <a href="#pkg-index">¶</a></h3>
+<ul class="Documentation-indexList">
+<li class="Documentation-indexConstants"><a href="#pkg-constants">Constants</a></li>
+<li><a href="#New">func New() UUID</a></li>
+<li><a href="#UUID">type UUID</a></li>
+<ul><li><a href="#UUID.String">func (uuid UUID) String() string</a></li></ul>
+</ul>
+</div>
+</body></html>`
+
+// TestPkgdoc tests showing the documentation of packages from their sources, or from
+// pkg.go.dev.
+func TestPkgdoc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-pkgdoc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(cache *docCache, url string) { packageDocCache, pkgGoDev = cache, url }(packageDocCache, pkgGoDev)
+	packageDocCache = newDocCache(dir)
+
+	t.Logf("Should render the overview and the index of a package from its sources.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var markdown string
+	kernel.session.display = func(data Data) { markdown, _ = data.Data[MIMETypeMarkdown].(string) }
+	if err := evalPkgdocMagic(&kernel, OutErr{ioutil.Discard, ioutil.Discard}, "strings"); err != nil {
+		t.Fatalf("\t%s Could not show the documentation of strings: %v.", failure, err)
+	}
+	for _, want := range []string{"## package strings", "Package strings implements", "- [`func Split(s, sep string) []string`](https://pkg.go.dev/strings#Split)", "  - [`func (b *Builder) String() string`](https://pkg.go.dev/strings#Builder.String)"} {
+		if !strings.Contains(markdown, want) {
+			t.Fatalf("\t%s Expected %q in:\n%s", failure, want, markdown)
+		}
+	}
+	t.Logf("\t%s Rendered it.", success)
+
+	t.Logf("Should fetch the documentation of the other packages from pkg.go.dev, and save it.")
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/example.com/uuid@v1.0.0" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, pkgGoDevPage)
+	}))
+	pkgGoDev = server.URL
+	docs, err := packageDocCache.fetchPkgGoDev("example.com/uuid", "v1.0.0", false)
+	if err != nil {
+		t.Fatalf("\t%s Could not fetch the documentation: %v.", failure, err)
+	}
+	wantDoc := "Package uuid generates and inspects UUIDs & more.\n\n# Usage\n\n\tid := uuid.New()\n\tfmt.Println(id)"
+	if docs.Name != "uuid" || docs.Doc != wantDoc || len(docs.Symbols) != 3 || docs.Symbols["UUID.String"].Decl != "func (uuid UUID) String() string" {
+		t.Fatalf("\t%s Unexpected documentation %+v.", failure, docs)
+	}
+	markdown = docs.overviewMarkdown(server.URL + "/example.com/uuid@v1.0.0")
+	if want := "- [`func New() UUID`](" + server.URL + "/example.com/uuid@v1.0.0#New)\n- [`type UUID`]"; !strings.Contains(markdown, want) {
+		t.Fatalf("\t%s Expected %q in:\n%s", failure, want, markdown)
+	}
+	if _, err := packageDocCache.fetchPkgGoDev("example.com/missing", "", false); err == nil {
+		t.Fatalf("\t%s Expected an error for a missing package.", failure)
+	}
+	t.Logf("\t%s Fetched it.", success)
+
+	t.Logf("Should use the saved documentation offline.")
+
+	server.Close()
+	requests = 0
+	for _, offline := range []bool{true, false} {
+		if docs, err := newDocCache(dir).fetchPkgGoDev("example.com/uuid", "v1.0.0", offline); err != nil || docs.Doc != wantDoc || requests != 0 {
+			t.Fatalf("\t%s Expected the saved documentation, got %+v (%v).", failure, docs, err)
+		}
+	}
+	if _, err := newDocCache(dir).fetchPkgGoDev("example.com/other", "", true); err == nil {
+		t.Fatalf("\t%s Expected an error for a package never fetched.", failure)
+	}
+	t.Logf("\t%s Used it.", success)
+
+	t.Logf("Should only read the sources within the roots in the sandbox.")
+
+	defer func(enabled bool, roots []string) { sandboxed, sandboxRoots = enabled, roots }(sandboxed, sandboxRoots)
+	sandboxed, sandboxRoots = true, []string{dir}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+	if err := evalPkgdocMagic(&kernel, outerr, "strings"); !errors.Is(err, errSandboxed) {
+		t.Fatalf("\t%s Expected the sources of strings to be refused, got %v.", failure, err)
+	}
+	if err := evalPkgdocMagic(&kernel, outerr, "example.com/uuid@v1.0.0"); err == nil || !strings.Contains(err.Error(), "sandbox") {
+		t.Fatalf("\t%s Expected the saved documentation to be refused, got %v.", failure, err)
+	}
+	if _, _, err := kernel.examplesOf("strings"); !errors.Is(err, errSandboxed) {
+		t.Fatalf("\t%s Expected the examples of strings to be refused, got %v.", failure, err)
+	}
+	t.Logf("\t%s Refused the others.", success)
+}
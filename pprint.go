@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncvs(
+		builtin.I.Funcv("Dump", dump, execDump),
+	)
+	lineMagics["pprint"] = evalPprintMagic
+	documentMagic(pprintSyntax)
+}
+
+var pprintSyntax = &magicSyntax{
+	name:  "%pprint",
+	usage: []string{"[depth=<n>] [width=<n>] [maxelems=<n>] [maxsize=<bytes>]"},
+	doc: "Sets how results are printed as text: how deeply nested values are printed, the width beyond which " +
+		"values are printed on several lines, how many elements of slices, arrays and maps are printed, and " +
+		"the size of the text beyond which only a summary of a value is printed. `0` removes a limit. " +
+		"Without options, reports the settings.",
+	options: []magicParam{
+		{name: "depth", help: "how deeply nested structs, slices and maps are printed"},
+		{name: "width", help: "the width beyond which values are printed on several lines"},
+		{name: "maxelems", help: "how many elements of slices, arrays and maps are printed"},
+		{name: "maxsize", help: "the size in bytes of the text beyond which a summary is printed"},
+	},
+}
+
+// valuePrinter prints the results of cells as text, like fmt.Sprint, within limits that
+// keep large values readable and fast to print. Unlike fmt, it follows nested pointers,
+// so linked structures are printed rather than their addresses; a pointer, map or slice
+// referring back to a value being printed is printed as `&^cycle`. Values whose text
+// would exceed maxSize are summarized instead, to protect the memory of the kernel and
+// the front-end. A limit of 0 is no limit.
+type valuePrinter struct {
+	depth    int // how deeply nested values are printed
+	width    int // the width beyond which values are printed on several lines
+	maxElems int // how many elements of slices, arrays and maps are printed
+	maxSize  int // the size of the text beyond which a summary is printed
+}
+
+// resultPrinter prints the results of the cells. %pprint changes its limits.
+var resultPrinter = valuePrinter{depth: 8, width: 100, maxElems: 100, maxSize: 1 << 20}
+
+// evalPprintMagic implements `%pprint [depth=<n>] [width=<n>] [maxelems=<n>]
+// [maxsize=<bytes>]`, which sets the limits of the printing of results, or reports them.
+func evalPprintMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := pprintSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	p := resultPrinter
+	limits := map[string]*int{"depth": &p.depth, "width": &p.width, "maxelems": &p.maxElems, "maxsize": &p.maxSize}
+	for name, value := range parsed.options {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return pprintSyntax.errorf("%s must be a number >= 0, got %q", name, value)
+		}
+		*limits[name] = n
+	}
+	resultPrinter = p
+	fmt.Fprintf(outerr.out, "Results are printed with depth=%d width=%d maxelems=%d maxsize=%d.\n", p.depth, p.width, p.maxElems, p.maxSize)
+	return nil
+}
+
+// sprint formats vals like fmt.Sprint: spaces are added between operands when neither
+// is a string.
+func (p valuePrinter) sprint(vals ...interface{}) string {
+	var b strings.Builder
+	for i, val := range vals {
+		_, isString := val.(string)
+		if i > 0 && !isString {
+			if _, prevString := vals[i-1].(string); !prevString {
+				b.WriteByte(' ')
+			}
+		}
+		state := &printState{path: map[printVisit]bool{}}
+		n := p.node(reflect.ValueOf(val), 0, state)
+		if state.tooLarge {
+			b.WriteString(p.summary(reflect.ValueOf(val)))
+			continue
+		}
+		b.WriteString(p.print(n, ""))
+	}
+	return b.String()
+}
+
+// printState is the state of the printing of a value.
+type printState struct {
+	path     map[printVisit]bool // the pointers, maps and slices being printed
+	size     int                 // the size of the text of the value so far
+	tooLarge bool                // whether the size exceeds maxSize
+}
+
+// add accounts for text in the size of the value, and reports whether printing may go
+// on.
+func (p valuePrinter) add(state *printState, text string) bool {
+	state.size += len(text) + 1
+	state.tooLarge = state.tooLarge || (p.maxSize != 0 && state.size > p.maxSize)
+	return !state.tooLarge
+}
+
+// summary describes v, whose text is too large to be printed: its type, its length
+// and its first and last elements.
+func (p valuePrinter) summary(v reflect.Value) string {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	var b strings.Builder
+	b.WriteString(v.Type().String())
+	elem := valuePrinter{depth: 1, maxElems: 3, maxSize: 100}
+	switch v.Kind() {
+	case reflect.String:
+		s := v.String()
+		if len(s) > 100 {
+			s = s[:100]
+		}
+		fmt.Fprintf(&b, " of length %d: %q...", v.Len(), s)
+	case reflect.Slice, reflect.Array:
+		var first, last []string
+		for i := 0; i < v.Len() && i < 3; i++ {
+			first = append(first, elem.sprint(v.Index(i).Interface()))
+		}
+		for i := v.Len() - 3; i < v.Len(); i++ {
+			if i >= 3 {
+				last = append(last, elem.sprint(v.Index(i).Interface()))
+			}
+		}
+		fmt.Fprintf(&b, " of length %d: [%s ... %s]", v.Len(), strings.Join(first, " "), strings.Join(last, " "))
+	case reflect.Map:
+		fmt.Fprintf(&b, " of length %d", v.Len())
+	}
+	fmt.Fprintf(&b, "\nThe value is too large to print (more than %d bytes): print it with Dump(v), "+
+		"or raise the limit with %%pprint maxsize=<bytes>.", p.maxSize)
+	return b.String()
+}
+
+// dump prints vals in full, without the limits of the printing of results, for values
+// too large to be printed as results.
+func dump(vals ...interface{}) {
+	fmt.Println(valuePrinter{}.sprint(vals...))
+}
+
+func execDump(arity int, p *gop.Context) {
+	args := p.GetArgs(arity)
+	dump(args...)
+	p.Ret(arity)
+}
+
+// printedValue is a value laid out for printing: either text, or elements between
+// brackets, which are printed on one line if they fit and on a line each otherwise.
+type printedValue struct {
+	prefix      string // printed before the value, like the key of a map entry
+	text        string
+	open, close string
+	elems       []printedValue
+}
+
+func (v printedValue) line() string {
+	if v.open == "" {
+		return v.prefix + v.text
+	}
+	elems := make([]string, len(v.elems))
+	for i, e := range v.elems {
+		elems[i] = e.line()
+	}
+	return v.prefix + v.open + strings.Join(elems, " ") + v.close
+}
+
+// print prints v at the given indentation.
+func (p valuePrinter) print(v printedValue, indent string) string {
+	line := v.line()
+	if v.open == "" || len(v.elems) == 0 || p.width == 0 || len(indent)+len(line) <= p.width {
+		return line
+	}
+	var b strings.Builder
+	b.WriteString(v.prefix + v.open + "\n")
+	for _, e := range v.elems {
+		b.WriteString(indent + "  " + p.print(e, indent+"  ") + "\n")
+	}
+	b.WriteString(indent + v.close)
+	return b.String()
+}
+
+// node lays out v, nested depth levels deep. It stops early once the text is too large.
+func (p valuePrinter) node(v reflect.Value, depth int, state *printState) printedValue {
+	if state.tooLarge {
+		return printedValue{}
+	}
+	if !v.IsValid() {
+		return printedValue{text: "<nil>"}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			if v.Kind() == reflect.Slice || v.Kind() == reflect.Map {
+				break
+			}
+			return printedValue{text: "<nil>"}
+		}
+		if v.Kind() != reflect.Interface {
+			visit := printVisit{v.Pointer(), v.Type()}
+			if state.path[visit] && (v.Kind() != reflect.Slice || v.Len() != 0) {
+				return printedValue{text: "&^cycle"}
+			}
+			state.path[visit] = true
+			defer delete(state.path, visit)
+		}
+	}
+	if text, ok := printMethod(v); ok {
+		p.add(state, text)
+		return printedValue{text: text}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		return p.node(v.Elem(), depth, state)
+	case reflect.Ptr:
+		switch v.Elem().Kind() {
+		case reflect.Struct, reflect.Array, reflect.Slice, reflect.Map:
+			n := p.node(v.Elem(), depth, state)
+			n.prefix = "&" + n.prefix
+			return n
+		}
+	case reflect.Struct:
+		if p.depth != 0 && depth >= p.depth {
+			return printedValue{text: "{...}"}
+		}
+		n := printedValue{open: "{", close: "}"}
+		for i := 0; i < v.NumField() && !state.tooLarge; i++ {
+			n.elems = append(n.elems, p.node(v.Field(i), depth+1, state))
+		}
+		return n
+	case reflect.Array, reflect.Slice:
+		if p.depth != 0 && depth >= p.depth && v.Len() != 0 {
+			return printedValue{text: "[...]"}
+		}
+		n := printedValue{open: "[", close: "]"}
+		for i := 0; i < v.Len() && !state.tooLarge; i++ {
+			if p.maxElems != 0 && i == p.maxElems {
+				n.elems = append(n.elems, printedValue{text: fmt.Sprintf("...+%d", v.Len()-i)})
+				break
+			}
+			n.elems = append(n.elems, p.node(v.Index(i), depth+1, state))
+		}
+		return n
+	case reflect.Map:
+		if p.depth != 0 && depth >= p.depth && v.Len() != 0 {
+			return printedValue{text: "map[...]"}
+		}
+		n := printedValue{open: "map[", close: "]"}
+		for i, key := range sortedMapKeys(v) {
+			if state.tooLarge {
+				break
+			}
+			if p.maxElems != 0 && i == p.maxElems {
+				n.elems = append(n.elems, printedValue{text: fmt.Sprintf("...+%d", v.Len()-i)})
+				break
+			}
+			e := p.node(v.MapIndex(key), depth+1, state)
+			e.prefix = p.node(key, depth+1, state).line() + ":" + e.prefix
+			n.elems = append(n.elems, e)
+		}
+		return n
+	}
+	text := fmt.Sprint(v)
+	p.add(state, text)
+	return printedValue{text: text}
+}
+
+// printVisit is a pointer, map or slice being printed.
+type printVisit struct {
+	addr uintptr
+	typ  reflect.Type
+}
+
+// printMethod returns the text of v given by its Error or String method, which fmt
+// prints instead of the value. A panicking method is reported like fmt does.
+func printMethod(v reflect.Value) (text string, ok bool) {
+	if !v.CanInterface() {
+		return "", false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			text, ok = fmt.Sprintf("%%!v(PANIC=%v)", r), true
+		}
+	}()
+	switch x := v.Interface().(type) {
+	case error:
+		return x.Error(), true
+	case fmt.Stringer:
+		return x.String(), true
+	}
+	return "", false
+}
+
+// sortedMapKeys returns the keys of the map v in the order fmt prints them.
+func sortedMapKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return compareKeys(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+func compareKeys(a, b reflect.Value) int {
+	if a.Kind() != b.Kind() {
+		return int(a.Kind()) - int(b.Kind())
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(a.Int() < b.Int(), a.Int() > b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return compareOrdered(a.Uint() < b.Uint(), a.Uint() > b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(a.Float() < b.Float(), a.Float() > b.Float())
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Bool:
+		return compareOrdered(!a.Bool() && b.Bool(), a.Bool() && !b.Bool())
+	case reflect.Ptr, reflect.Chan:
+		return compareOrdered(a.Pointer() < b.Pointer(), a.Pointer() > b.Pointer())
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return compareOrdered(a.IsNil() && !b.IsNil(), !a.IsNil() && b.IsNil())
+		}
+		return compareKeys(a.Elem(), b.Elem())
+	case reflect.Struct, reflect.Array:
+		n := a.Len
+		if a.Kind() == reflect.Struct {
+			n = a.NumField
+		}
+		for i := 0; i < n(); i++ {
+			var c int
+			if a.Kind() == reflect.Struct {
+				c = compareKeys(a.Field(i), b.Field(i))
+			} else {
+				c = compareKeys(a.Index(i), b.Index(i))
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+type pprintPoint struct {
+	X, y int
+}
+
+type pprintTree struct {
+	Label    string
+	Children []*pprintTree
+}
+
+// TestValuePrinter tests printing the results of cells as text.
+func TestValuePrinter(t *testing.T) {
+	t.Logf("Should print small values like fmt.Sprint.")
+
+	var nilMap map[string]int
+	var nilErr error
+	for _, val := range []interface{}{
+		1, "s", 2.5, true, nil, nilErr, nilMap, []int(nil), []byte("ab"),
+		pprintPoint{1, 2}, &pprintPoint{3, 4}, [2]string{"a", "b"},
+		map[string]int{"b": 2, "a": 1}, map[int]bool{3: true, -1: false},
+		errors.New("failed"), big.NewInt(42), []interface{}{1, "x", nil},
+	} {
+		if got, want := resultPrinter.sprint(val), fmt.Sprint(val); got != want {
+			t.Fatalf("\t%s Expected %#v to be printed as %q, got %q.", failure, val, want, got)
+		}
+	}
+	if got, want := resultPrinter.sprint(1, "a", "b", 2, 3), fmt.Sprint(1, "a", "b", 2, 3); got != want {
+		t.Fatalf("\t%s Expected several values to be printed as %q, got %q.", failure, want, got)
+	}
+	t.Logf("\t%s Printed the values like fmt.Sprint.", success)
+
+	t.Logf("Should print values within the limits.")
+
+	tree := &pprintTree{"root", []*pprintTree{{"a", []*pprintTree{{"a1", nil}}}, {"b", nil}}}
+	cases := []struct {
+		printer valuePrinter
+		val     interface{}
+		text    string
+	}{
+		{valuePrinter{}, tree, "&{root [&{a [&{a1 []}]} &{b []}]}"},
+		{valuePrinter{depth: 2}, tree, "&{root [&{...} &{...}]}"},
+		{valuePrinter{maxElems: 3}, []int{1, 2, 3, 4, 5}, "[1 2 3 ...+2]"},
+		{valuePrinter{maxElems: 1}, map[string]int{"b": 2, "a": 1}, "map[a:1 ...+1]"},
+		{valuePrinter{width: 20}, map[string][]int{"a": {1, 2}, "b": {3, 4, 5, 6}}, "map[\n  a:[1 2]\n  b:[3 4 5 6]\n]"},
+		{valuePrinter{width: 12}, []pprintPoint{{1, 2}, {3, 4}}, "[\n  {1 2}\n  {3 4}\n]"},
+	}
+	for _, tc := range cases {
+		if text := tc.printer.sprint(tc.val); text != tc.text {
+			t.Fatalf("\t%s Expected %+v to print %q, got %q.", failure, tc.printer, tc.text, text)
+		}
+		t.Logf("\t%s Printed %q.", success, tc.text)
+	}
+}
+
+// TestValuePrinterCycles tests printing values referring to themselves.
+func TestValuePrinterCycles(t *testing.T) {
+	type node struct {
+		Value int
+		Next  *node
+	}
+	ring := &node{Value: 1}
+	ring.Next = &node{2, ring}
+
+	self := map[string]interface{}{"name": "m"}
+	self["self"] = self
+
+	list := []interface{}{1, nil}
+	list[1] = list
+
+	cases := []struct {
+		val  interface{}
+		text string
+	}{
+		{ring, "&{1 &{2 &^cycle}}"},
+		{self, "map[name:m self:&^cycle]"},
+		{list, "[1 &^cycle]"},
+		// Values shared without a cycle are printed each time.
+		{[]*node{ring.Next.Next.Next, ring.Next}, "[&{2 &{1 &^cycle}} &{2 &{1 &^cycle}}]"},
+	}
+
+	t.Logf("Should print cycles as &^cycle.")
+
+	for _, tc := range cases {
+		if text := (valuePrinter{}).sprint(tc.val); text != tc.text {
+			t.Fatalf("\t%s Expected %q, got %q.", failure, tc.text, text)
+		}
+		t.Logf("\t%s Printed %q.", success, tc.text)
+	}
+}
+
+// TestValuePrinterLargeValues tests summarizing values too large to be printed.
+func TestValuePrinterLargeValues(t *testing.T) {
+	large := make([]int, 100000)
+	index := map[int]int{}
+	for i := range large {
+		large[i] = i
+		index[i] = i
+	}
+	cases := []struct {
+		val     interface{}
+		summary string
+	}{
+		{large, "[]int of length 100000: [0 1 2 ... 99997 99998 99999]\n"},
+		{strings.Repeat("ab", 1000), "string of length 2000: \"" + strings.Repeat("ab", 50) + "\"...\n"},
+		{index, "map[int]int of length 100000\n"},
+	}
+
+	t.Logf("Should print a summary of values too large to be printed.")
+
+	printer := valuePrinter{maxSize: 1000}
+	for _, tc := range cases {
+		text := printer.sprint(tc.val)
+		if !strings.HasPrefix(text, tc.summary) || !strings.Contains(text, "Dump(v)") {
+			t.Fatalf("\t%s Expected a summary starting with %q, got %q.", failure, tc.summary, text)
+		}
+		t.Logf("\t%s Printed %q.", success, tc.summary)
+	}
+
+	t.Logf("Should print values within the size in full.")
+
+	if text := printer.sprint(large[:10]); text != fmt.Sprint(large[:10]) {
+		t.Fatalf("\t%s Expected a small slice to be printed in full, got %q.", failure, text)
+	}
+	t.Logf("\t%s Printed a small slice in full.", success)
+}
+
+// TestPprintMagic tests setting the limits of the printing of results.
+func TestPprintMagic(t *testing.T) {
+	defer func(p valuePrinter) { resultPrinter = p }(resultPrinter)
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+
+	t.Logf("Should set the limits with %%pprint.")
+
+	if _, err := kernel.doEvalGop(outerr, "%pprint depth=3 width=0 maxelems=2"); err != nil {
+		t.Fatalf("\t%s %%pprint failed: %v.", failure, err)
+	}
+	if want := (valuePrinter{depth: 3, maxElems: 2, maxSize: 1 << 20}); resultPrinter != want {
+		t.Fatalf("\t%s Expected the limits %+v, got %+v.", failure, want, resultPrinter)
+	}
+	data := kernel.autoRenderResults([]interface{}{[]int{1, 2, 3}})
+	if text := data.Data[MIMETypeText]; text != "[1 2 ...+1]" {
+		t.Fatalf("\t%s Expected the result to be printed within the limits, got %q.", failure, text)
+	}
+	t.Logf("\t%s Set the limits.", success)
+
+	if _, err := kernel.doEvalGop(outerr, "%pprint depth=-1"); err == nil {
+		t.Fatalf("\t%s Expected a negative depth to be refused.", failure)
+	}
+}
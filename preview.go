@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("ReadCSVPreview", readCSVPreview, execReadCSVPreview),
+		builtin.I.Func("ReadParquetPreview", readParquetPreview, execReadParquetPreview),
+	)
+}
+
+// TablePreview holds the first rows of a data file, as returned by ReadCSVPreview and
+// ReadParquetPreview. It renders as a table.
+type TablePreview struct {
+	Path    string
+	Columns []string
+	Types   []string   // the type of each column
+	Rows    [][]string // the values of the first rows, formatted
+	Nulls   [][]bool   // whether each value is null, or nil if the file has no nulls
+	Total   int        // the number of rows of the file, or -1 if unknown
+}
+
+// readCSVPreview reads the first n rows of the CSV file at path, after its header, and
+// infers the types of its columns from them. Like the other builtins it reports errors
+// by panicking, which fails the cell.
+func readCSVPreview(path string, n int) *TablePreview {
+	preview, err := previewCSV(path, n)
+	if err != nil {
+		panic(err)
+	}
+	return preview
+}
+
+func execReadCSVPreview(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, readCSVPreview(args[0].(string), args[1].(int)))
+}
+
+func previewCSV(path string, n int) (*TablePreview, error) {
+	if sandboxed && !inSandbox(path) {
+		return nil, &os.PathError{Op: "open", Path: path, Err: errSandboxed}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%s is empty", path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	preview := &TablePreview{Path: path, Columns: header, Total: -1}
+	for len(preview.Rows) < n {
+		record, err := r.Read()
+		if err == io.EOF {
+			preview.Total = len(preview.Rows)
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		// Pad or cut the rows to the columns of the header.
+		row := make([]string, len(header))
+		copy(row, record)
+		preview.Rows = append(preview.Rows, row)
+	}
+	if preview.Total == -1 {
+		if _, err := r.Read(); err == io.EOF {
+			preview.Total = len(preview.Rows)
+		}
+	}
+
+	preview.Types = make([]string, len(header))
+	for i := range header {
+		preview.Types[i] = inferColumnType(preview.Rows, i)
+	}
+	return preview, nil
+}
+
+// inferColumnType returns the narrowest of bool, int, float and string that can hold
+// the values of column i of rows. Empty values are ignored.
+func inferColumnType(rows [][]string, i int) string {
+	isBool, isInt, isFloat, empty := true, true, true, true
+	for _, row := range rows {
+		v := strings.TrimSpace(row[i])
+		if v == "" {
+			continue
+		}
+		empty = false
+		if _, err := strconv.ParseBool(v); err != nil || v == "1" || v == "0" {
+			isBool = false
+		}
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			isInt = false
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			isFloat = false
+		}
+	}
+	switch {
+	case empty:
+		return "string"
+	case isBool:
+		return "bool"
+	case isInt:
+		return "int"
+	case isFloat:
+		return "float"
+	}
+	return "string"
+}
+
+func (t *TablePreview) String() string {
+	var b strings.Builder
+	for i, name := range t.Columns {
+		if i > 0 {
+			b.WriteString("\t")
+		}
+		b.WriteString(name + " (" + t.Types[i] + ")")
+	}
+	b.WriteString("\n")
+	for i, row := range t.Rows {
+		for j := range row {
+			if j > 0 {
+				b.WriteString("\t")
+			}
+			b.WriteString(t.value(i, j))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(t.summary())
+	return b.String()
+}
+
+// Render renders the preview as a table, with the type of each column under its name.
+func (t *TablePreview) Render() Data {
+	return MakeData3(MIMETypeHTML, t.String(), t.html())
+}
+
+func (t *TablePreview) html() string {
+	var b strings.Builder
+	b.WriteString("<table><thead><tr>")
+	for i, name := range t.Columns {
+		fmt.Fprintf(&b, `<th>%s<br><small style="font-weight:normal;color:#888">%s</small></th>`,
+			html.EscapeString(name), html.EscapeString(t.Types[i]))
+	}
+	b.WriteString("</tr></thead><tbody>")
+	for i, row := range t.Rows {
+		b.WriteString("<tr>")
+		for j := range row {
+			if t.Nulls != nil && t.Nulls[i][j] {
+				b.WriteString(`<td style="color:#888"><i>null</i></td>`)
+			} else {
+				b.WriteString("<td>" + html.EscapeString(row[j]) + "</td>")
+			}
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</tbody></table>")
+	b.WriteString("<small>" + html.EscapeString(t.summary()) + "</small>")
+	return b.String()
+}
+
+// value returns the formatted value of column j of row i.
+func (t *TablePreview) value(i, j int) string {
+	if t.Nulls != nil && t.Nulls[i][j] {
+		return "null"
+	}
+	return t.Rows[i][j]
+}
+
+// summary tells how many of the rows of the file the preview shows.
+func (t *TablePreview) summary() string {
+	switch {
+	case t.Total == len(t.Rows):
+		return fmt.Sprintf("%s: %d rows", t.Path, t.Total)
+	case t.Total < 0:
+		return fmt.Sprintf("%s: first %d rows", t.Path, len(t.Rows))
+	}
+	return fmt.Sprintf("%s: first %d of %d rows", t.Path, len(t.Rows), t.Total)
+}
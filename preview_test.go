@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestCSVPreview tests previewing CSV files.
+func TestCSVPreview(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-preview")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "people.csv")
+	csv := "name,age,score,active\nann,31,9.5,true\nbob,27,,false\n\"doe, j\",45,8,true\n"
+	if err := ioutil.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		n       int
+		types   []string
+		rows    int
+		summary string
+	}{
+		{2, []string{"string", "int", "float", "bool"}, 2, ": first 2 rows"},
+		{10, []string{"string", "int", "float", "bool"}, 3, ": 3 rows"},
+		{3, []string{"string", "int", "float", "bool"}, 3, ": 3 rows"},
+	}
+
+	t.Logf("Should read the first rows of CSV files and infer the types of their columns.")
+
+	for _, tc := range cases {
+		preview := readCSVPreview(path, tc.n)
+		if !reflect.DeepEqual(preview.Types, tc.types) || len(preview.Rows) != tc.rows {
+			t.Fatalf("\t%s Expected %d rows of %v, got %d rows of %v.", failure, tc.rows, tc.types, len(preview.Rows), preview.Types)
+		}
+		if summary := preview.summary(); !strings.HasSuffix(summary, tc.summary) {
+			t.Fatalf("\t%s Expected the summary %q, got %q.", failure, tc.summary, summary)
+		}
+		t.Logf("\t%s Read %s.", success, preview.summary())
+	}
+	if html := readCSVPreview(path, 3).html(); !strings.Contains(html, "<td>doe, j</td>") {
+		t.Fatalf("\t%s Expected the quoted value in the table, got %s.", failure, html)
+	}
+
+	t.Logf("Should refuse files outside of the sandbox roots.")
+
+	defer func(enabled bool, roots []string) { sandboxed, sandboxRoots = enabled, roots }(sandboxed, sandboxRoots)
+	sandboxed, sandboxRoots = true, []string{filepath.Join(dir, "root")}
+	for _, preview := range []func(string, int) (*TablePreview, error){previewCSV, previewParquet} {
+		if _, err := preview(path, 3); !errors.Is(err, errSandboxed) {
+			t.Fatalf("\t%s Expected the preview of %s to be refused, got %v.", failure, path, err)
+		}
+	}
+	t.Logf("\t%s Refused %s.", success, path)
+}
+
+// TestParquetPreview tests previewing a Parquet file written with Snappy compression
+// and dictionary encoding.
+func TestParquetPreview(t *testing.T) {
+	t.Logf("Should read the first rows of Parquet files with the types of their columns.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	vals, err := kernel.session.Eval(`ReadParquetPreview("fixtures/people.parquet", 2)`)
+	if err != nil {
+		t.Fatalf("\t%s Evaluating ReadParquetPreview failed: %v.", failure, err)
+	}
+	preview := vals[0].(*TablePreview)
+
+	columns := []string{"name", "age", "id", "score", "active", "note"}
+	types := []string{"string", "int32", "int64", "double", "bool", "string"}
+	rows := [][]string{{"ann", "31", "1", "9.5", "true", "vip"}, {"bob", "27", "2", "7.25", "false", ""}}
+	if !reflect.DeepEqual(preview.Columns, columns) || !reflect.DeepEqual(preview.Types, types) {
+		t.Fatalf("\t%s Expected columns %v of %v, got %v of %v.", failure, columns, types, preview.Columns, preview.Types)
+	}
+	if !reflect.DeepEqual(preview.Rows, rows) || !preview.Nulls[1][5] || preview.Total != 3 {
+		t.Fatalf("\t%s Expected rows %v of 3, got %v of %d.", failure, rows, preview.Rows, preview.Total)
+	}
+	text := kernel.autoRenderResults(vals).Data[MIMETypeText].(string)
+	if !strings.Contains(text, "bob\t27\t2\t7.25\tfalse\tnull") {
+		t.Fatalf("\t%s Expected the null value in the text, got %q.", failure, text)
+	}
+	t.Logf("\t%s Read %s.", success, preview.summary())
+
+	if _, err := kernel.session.Eval(`ReadParquetPreview("fixtures/connection_file.json", 2)`); err == nil || !strings.Contains(err.Error(), "not a Parquet file") {
+		t.Fatalf("\t%s Expected an error for a JSON file, got %v.", failure, err)
+	}
+
+	t.Logf("Should refuse pages whose sizes do not match their data, without allocating them.")
+
+	huge := make([]byte, binary.MaxVarintLen64)
+	huge = append(huge[:binary.PutUvarint(huge, 1<<40)], 0)
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	w.Write(make([]byte, 100))
+	w.Close()
+	for _, tc := range []struct {
+		codec int64
+		data  []byte
+		size  int
+	}{
+		{parquetSnappy, huge, 1},
+		{parquetSnappy, []byte{2, 0, 'a', 1, 1}, 2},
+		{parquetGzip, gzipped.Bytes(), 10},
+		{parquetUncompressed, nil, -1},
+	} {
+		if _, err := parquetDecompress(tc.codec, tc.data, tc.size); err == nil {
+			t.Fatalf("\t%s Expected decompressing %q with codec %d to fail.", failure, tc.data, tc.codec)
+		}
+	}
+	t.Logf("\t%s Refused them.", success)
+}
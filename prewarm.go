@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runPrewarm implements `gopyter prewarm`, which builds the plugins of packages into the
+// import cache ahead of time, so %require loads them without building or downloading
+// them, e.g. in classrooms without network access.
+func runPrewarm(config KernelConfig, args []string) error {
+	flags := flag.NewFlagSet("prewarm", flag.ExitOnError)
+	packagesFile := flags.String("packages-file", "", "a file listing the packages to prewarm, as package@version, one per line")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gopyter prewarm [-packages-file file] [package@version]...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	packages := flags.Args()
+	if *packagesFile != "" {
+		f, err := os.Open(*packagesFile)
+		if err != nil {
+			return err
+		}
+		listed, err := readPackageList(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", *packagesFile, err)
+		}
+		packages = append(packages, listed...)
+	}
+	if len(packages) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	kernel := &Kernel{NewSession(), config}
+	return kernel.prewarm(os.Stdout, packages)
+}
+
+// readPackageList reads a list of packages, one per line. Blank lines and comments
+// starting with # are ignored.
+func readPackageList(r io.Reader) ([]string, error) {
+	var packages []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			packages = append(packages, line)
+		}
+	}
+	return packages, scanner.Err()
+}
+
+// prewarm builds the plugins of packages into the import cache, unless they are there.
+// It goes on after a package fails, and returns an error if any did.
+func (kernel *Kernel) prewarm(out io.Writer, packages []string) error {
+	imports := kernel.importCache()
+	failed := 0
+	for _, arg := range packages {
+		pkg, version := splitPackageVersion(arg)
+		if pkg == "" || version == "" {
+			fmt.Fprintf(out, "%s: expected <package>@<version>\n", arg)
+			failed++
+			continue
+		}
+		if _, ok := imports.lookup(out, pkg, version); ok {
+			fmt.Fprintf(out, "%s@%s is already in the import cache.\n", pkg, version)
+			continue
+		}
+		pin, err := imports.build(out, pkg, version)
+		if err != nil {
+			fmt.Fprintf(out, "%s@%s: %v\n", pkg, version, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "Prewarmed %s %s.\n", pin.Package, pin.Version)
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d packages could not be prewarmed", failed, len(packages))
+	}
+	return nil
+}
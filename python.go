@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"log"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	cellMagics["python"] = evalPythonMagic
+	documentMagic(pythonSyntax)
+}
+
+var pythonSyntax = &magicSyntax{
+	name:  "%%python",
+	usage: []string{"[in=<var>,<var>...] [out=<var>,<var>...]"},
+	doc: "Executes the cell in a Python interpreter that persists across cells, after copying the `in` " +
+		"variables of the session to Python, then copies the `out` variables of Python back to the session. " +
+		"Numbers, strings, booleans, lists and dicts are copied as JSON; arrowipc tables and pandas " +
+		"DataFrames are exchanged as Arrow files.",
+	options: []magicParam{
+		{name: "in", help: "the variables of the session to copy to Python, separated by commas"},
+		{name: "out", help: "the variables of Python to copy to the session, separated by commas"},
+	},
+}
+
+// pythonDriver is the program run by the Python interpreter of %%python. It executes
+// the requests read from stdin, one JSON object per line, in a namespace kept across
+// requests. What the cells print is forwarded as it is written, in stream messages,
+// and each request is answered with the values of its out variables, or the traceback
+// of its error.
+const pythonDriver = `
+import json, os, sys, traceback
+
+proto = sys.stdout
+namespace = {"__name__": "__main__"}
+
+def send(message):
+    proto.write(json.dumps(message) + "\n")
+    proto.flush()
+
+class Stream:
+    def __init__(self, name):
+        self.name = name
+    def write(self, text):
+        if text:
+            send({"stream": self.name, "text": text})
+        return len(text)
+    def flush(self):
+        pass
+
+def to_python(value):
+    if isinstance(value, dict) and "__arrow__" in value:
+        import pyarrow
+        table = pyarrow.ipc.open_file(value["__arrow__"]).read_all()
+        try:
+            return table.to_pandas()
+        except ImportError:
+            return table
+    return value
+
+def from_python(name, value, arrow_dir):
+    if type(value).__name__ in ("DataFrame", "Table") and type(value).__module__.split(".")[0] in ("pandas", "pyarrow"):
+        import pyarrow
+        if not isinstance(value, pyarrow.Table):
+            value = pyarrow.Table.from_pandas(value, preserve_index=False)
+        os.makedirs(arrow_dir, exist_ok=True)
+        path = os.path.join(arrow_dir, "python-" + name + ".arrow")
+        with pyarrow.OSFile(path, "wb") as f:
+            with pyarrow.ipc.new_file(f, value.schema) as writer:
+                writer.write_table(value)
+        return {"__arrow__": path}
+    if hasattr(value, "tolist"):
+        value = value.tolist()
+    json.dumps(value, allow_nan=False)
+    return value
+
+for line in sys.stdin:
+    request = json.loads(line)
+    reply = {"out": {}}
+    sys.stdout, sys.stderr = Stream("stdout"), Stream("stderr")
+    try:
+        for name, value in request["in"].items():
+            namespace[name] = to_python(value)
+        exec(compile(request["code"], "<cell>", "exec"), namespace)
+        for name in request["out"]:
+            if name not in namespace:
+                raise NameError("name '%s' is not defined" % name)
+            reply["out"][name] = from_python(name, namespace[name], request["arrow_dir"])
+    except BaseException:
+        kind, error, tb = sys.exc_info()
+        reply["error"] = "".join(traceback.format_exception(kind, error, tb.tb_next))
+    finally:
+        sys.stdout, sys.stderr = proto, sys.__stderr__
+    send(reply)
+`
+
+// pythonProcess is the Python interpreter of the %%python cells of a session.
+type pythonProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	out   *bufio.Reader
+}
+
+// pythonRequest asks the Python interpreter to execute code.
+type pythonRequest struct {
+	Code     string                     `json:"code"`
+	In       map[string]json.RawMessage `json:"in"`
+	Out      []string                   `json:"out"`
+	ArrowDir string                     `json:"arrow_dir"`
+}
+
+// pythonMessage is either output of the code, or the reply to a request.
+type pythonMessage struct {
+	Stream string                     `json:"stream"`
+	Text   string                     `json:"text"`
+	Out    map[string]json.RawMessage `json:"out"`
+	Error  string                     `json:"error"`
+}
+
+// startPython starts the Python interpreter of %%python.
+func startPython(python string) (*pythonProcess, error) {
+	cmd := exec.Command(python, "-u", "-c", pythonDriver)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	// The driver reports the errors of the cells; the errors of the interpreter itself go
+	// to the log of the kernel.
+	cmd.Stderr = log.Writer()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &pythonProcess{cmd, stdin, bufio.NewReader(stdout)}, nil
+}
+
+// run executes a request, forwarding the output of the code to outerr, and returns the
+// values of its out variables.
+func (p *pythonProcess) run(outerr OutErr, request pythonRequest) (map[string]json.RawMessage, error) {
+	line, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	for {
+		line, err := p.out.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		var message pythonMessage
+		if err := json.Unmarshal(line, &message); err != nil {
+			return nil, xerrors.Errorf("invalid message from Python: %w", err)
+		}
+		switch {
+		case message.Stream == "stdout":
+			io.WriteString(outerr.out, message.Text)
+		case message.Stream == "stderr":
+			io.WriteString(outerr.err, message.Text)
+		case message.Error != "":
+			return nil, pythonError(strings.TrimSpace(message.Error))
+		default:
+			return message.Out, nil
+		}
+	}
+}
+
+// pythonError is the traceback of an error raised by the code of a cell.
+type pythonError string
+
+func (e pythonError) Error() string {
+	return string(e)
+}
+
+// stop ends the Python interpreter.
+func (p *pythonProcess) stop() {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+}
+
+// evalPythonMagic implements
+//
+//	%%python [in=<var>,<var>...] [out=<var>,<var>...]
+//
+// which executes the cell in the Python interpreter of the session, started on first
+// use, after copying the in variables to Python, then copies the out variables back.
+func evalPythonMagic(kernel *Kernel, outerr OutErr, args string, body string) ([]interface{}, error) {
+	if sandboxed {
+		return nil, errors.New("%%python: not permitted in the sandbox")
+	}
+	parsed, err := pythonSyntax.parse(args)
+	if err != nil {
+		return nil, err
+	}
+	request := pythonRequest{Code: body, In: map[string]json.RawMessage{}, Out: []string{}, ArrowDir: arrowDir()}
+	for _, name := range splitVarList(parsed.options["in"]) {
+		value, err := kernel.pythonValue(name)
+		if err != nil {
+			return nil, fmt.Errorf("%%%%python: could not copy %s to Python: %v", name, err)
+		}
+		request.In[name] = value
+	}
+	for _, name := range splitVarList(parsed.options["out"]) {
+		if !token.IsIdentifier(name) || name == "_" {
+			return nil, pythonSyntax.errorf("expected the names of variables, got %q", name)
+		}
+		request.Out = append(request.Out, name)
+	}
+
+	if kernel.session.python == nil {
+		python := kernel.config.Python
+		if python == "" {
+			python = "python3"
+		}
+		if kernel.session.python, err = startPython(python); err != nil {
+			return nil, fmt.Errorf("%%%%python: could not start %s: %v", python, err)
+		}
+	}
+	out, err := kernel.session.python.run(outerr, request)
+	if _, ok := err.(pythonError); err != nil && !ok {
+		// The interpreter died, e.g. the cell called os._exit(): start a new one next time.
+		kernel.session.python.stop()
+		kernel.session.python = nil
+		return nil, fmt.Errorf("%%%%python: the Python interpreter exited, its variables are lost: %v", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var code strings.Builder
+	for _, name := range request.Out {
+		value, err := goLiteral(out[name])
+		if err != nil {
+			return nil, fmt.Errorf("%%%%python: could not copy %s from Python: %v", name, err)
+		}
+		op := ":="
+		if kernel.session.declared([]string{name}) {
+			op = "="
+		}
+		fmt.Fprintf(&code, "%s %s %s\n", name, op, value)
+	}
+	if code.Len() != 0 {
+		if _, err := kernel.session.Eval(code.String()); err != nil {
+			return nil, fmt.Errorf("%%%%python: could not bind the out variables: %v", err)
+		}
+	}
+	return nil, nil
+}
+
+// splitVarList splits a list of variables separated by commas.
+func splitVarList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// pythonValue returns the value of the variable called name as JSON for Python. Arrow
+// tables are exported to the exchange directory of arrowipc and passed by path.
+func (kernel *Kernel) pythonValue(name string) (json.RawMessage, error) {
+	vals, err := kernel.session.Peek(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) != 1 {
+		return nil, fmt.Errorf("not a variable")
+	}
+	if table, ok := vals[0].(*ArrowTable); ok {
+		path, err := exportArrow(table, "python-"+name)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]string{"__arrow__": path})
+	}
+	return json.Marshal(vals[0])
+}
+
+// goLiteral returns the Go+ expression of a value received from Python as JSON. Lists
+// and dicts whose elements are all of the same simple type become slices and maps of
+// that type.
+func goLiteral(data json.RawMessage) (string, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return "", err
+	}
+	if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+		if path, ok := m["__arrow__"].(string); ok {
+			return fmt.Sprintf("arrowipc.Import(%s)", strconv.Quote(path)), nil
+		}
+	}
+	if v == nil {
+		return "", errors.New("None has no type in Go")
+	}
+	return literalOf(v)
+}
+
+func literalOf(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "nil", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case string:
+		return strconv.Quote(v), nil
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return v.String(), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return "", err
+		}
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, nil
+	case []interface{}:
+		elems := make([]string, len(v))
+		for i, e := range v {
+			var err error
+			if elems[i], err = literalOf(e); err != nil {
+				return "", err
+			}
+		}
+		return "[]" + elemType(v) + "{" + strings.Join(elems, ", ") + "}", nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		values := make([]interface{}, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		elems := make([]string, len(keys))
+		for i, key := range keys {
+			values = append(values, v[key])
+			value, err := literalOf(v[key])
+			if err != nil {
+				return "", err
+			}
+			elems[i] = strconv.Quote(key) + ": " + value
+		}
+		return "map[string]" + elemType(values) + "{" + strings.Join(elems, ", ") + "}", nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// elemType returns the type of the elements of a list or a dict: int, float64, string
+// or bool if they all are of that type, and interface{} otherwise.
+func elemType(elems []interface{}) string {
+	typ := ""
+	for _, e := range elems {
+		t := "interface{}"
+		switch e := e.(type) {
+		case bool:
+			t = "bool"
+		case string:
+			t = "string"
+		case json.Number:
+			t = "float64"
+			if _, err := e.Int64(); err == nil {
+				t = "int"
+			}
+		}
+		switch {
+		case typ == "" || typ == t:
+			typ = t
+		case (typ == "int" && t == "float64") || (typ == "float64" && t == "int"):
+			typ = "float64"
+		default:
+			return "interface{}"
+		}
+	}
+	if typ == "" {
+		return "interface{}"
+	}
+	return typ
+}
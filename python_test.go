@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestGoLiteral tests converting values received from Python to Go+ expressions.
+func TestGoLiteral(t *testing.T) {
+	cases := []struct {
+		json    string
+		literal string
+	}{
+		{`42`, `42`},
+		{`2.0`, `2.0`},
+		{`"a\"b"`, `"a\"b"`},
+		{`true`, `true`},
+		{`[1, 2.5]`, `[]float64{1, 2.5}`},
+		{`["a", "b"]`, `[]string{"a", "b"}`},
+		{`[1, "x", null]`, `[]interface{}{1, "x", nil}`},
+		{`[]`, `[]interface{}{}`},
+		{`{"b": 2, "a": 1}`, `map[string]int{"a": 1, "b": 2}`},
+		{`{"__arrow__": "/tmp/t.arrow"}`, `arrowipc.Import("/tmp/t.arrow")`},
+	}
+
+	t.Logf("Should convert JSON values to Go+ expressions.")
+
+	for _, tc := range cases {
+		literal, err := goLiteral(json.RawMessage(tc.json))
+		if err != nil || literal != tc.literal {
+			t.Fatalf("\t%s Expected %s to be converted to %s, got %s (%v).", failure, tc.json, tc.literal, literal, err)
+		}
+		t.Logf("\t%s Converted %s.", success, tc.json)
+	}
+	if _, err := goLiteral(json.RawMessage(`null`)); err == nil {
+		t.Fatalf("\t%s Expected None to be refused.", failure)
+	}
+}
+
+// TestPythonMagic tests executing cells in Python.
+func TestPythonMagic(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	kernel := Kernel{NewSession(), defaultConfig()}
+	defer func() {
+		if kernel.session.python != nil {
+			kernel.session.python.stop()
+		}
+	}()
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+
+	t.Logf("Should copy variables to Python and back.")
+
+	if _, err := kernel.doEvalGop(outerr, "nums := []int{1, 2, 3}\nname := \"go\""); err != nil {
+		t.Fatalf("\t%s Could not declare the variables: %v.", failure, err)
+	}
+	code := "%%python in=nums,name out=total,words\nprint('hello from', name)\ntotal = sum(nums)\nwords = [name] * 2"
+	if _, err := kernel.doEvalGop(outerr, code); err != nil {
+		t.Fatalf("\t%s %%%%python failed: %v.", failure, err)
+	}
+	if out.String() != "hello from go\n" {
+		t.Fatalf("\t%s Expected the output of Python, got %q.", failure, out.String())
+	}
+	vals, err := kernel.doEvalGop(outerr, "total * 10")
+	if err != nil || !reflect.DeepEqual(vals, []interface{}{60}) {
+		t.Fatalf("\t%s Expected total to be 6, got %v (%v).", failure, vals, err)
+	}
+	vals, err = kernel.doEvalGop(outerr, "words")
+	if err != nil || !reflect.DeepEqual(vals, []interface{}{[]string{"go", "go"}}) {
+		t.Fatalf("\t%s Expected the words, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Copied the variables.", success)
+
+	t.Logf("Should keep the namespace of Python across cells.")
+
+	if _, err := kernel.doEvalGop(outerr, "%%python out=total\ntotal += 1"); err != nil {
+		t.Fatalf("\t%s %%%%python failed: %v.", failure, err)
+	}
+	if vals, err = kernel.doEvalGop(outerr, "total"); err != nil || !reflect.DeepEqual(vals, []interface{}{7}) {
+		t.Fatalf("\t%s Expected total to be 7, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Kept the namespace.", success)
+
+	t.Logf("Should report the errors of Python.")
+
+	_, err = kernel.doEvalGop(outerr, "%%python\nraise ValueError('bad value')")
+	if err == nil || !strings.HasSuffix(err.Error(), "ValueError: bad value") {
+		t.Fatalf("\t%s Expected the traceback of the error, got %v.", failure, err)
+	}
+	t.Logf("\t%s Reported the error.", success)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	lineMagics["queue"] = evalQueueMagic
+	documentMagic(queueSyntax)
+}
+
+var queueSyntax = &magicSyntax{
+	name:  "%queue",
+	usage: []string{"", "drop [position...]"},
+	doc: "Lists the cells waiting to be executed after the current one, e.g. after Run All. " +
+		"`%queue drop` drops them all, or those at the given positions; the front-end shows them as aborted.",
+	args: []magicParam{
+		{name: "action", help: "`drop` to drop queued cells", optional: true},
+		{name: "position", help: "the positions of the cells to drop, as listed", optional: true, variadic: true},
+	},
+}
+
+// queuedCell describes an execute_request waiting in the queue of a shell.
+type queuedCell struct {
+	MsgID       string `json:"msg_id"`
+	SubshellID  string `json:"subshell_id,omitempty"`
+	Code        string `json:"code"`
+	Silent      bool   `json:"silent"`
+	StopOnError bool   `json:"stop_on_error"`
+	AllowStdin  bool   `json:"allow_stdin"`
+}
+
+// newQueuedCell describes the execute_request of receipt. The flags of the request
+// take their default values from the protocol when they are left out.
+func newQueuedCell(receipt msgReceipt) queuedCell {
+	content, _ := receipt.Msg.Content.(map[string]interface{})
+	cell := queuedCell{
+		MsgID:       receipt.Msg.Header.MsgID,
+		SubshellID:  receipt.Msg.Header.SubshellID,
+		StopOnError: true,
+		AllowStdin:  true,
+	}
+	cell.Code, _ = content["code"].(string)
+	cell.Silent, _ = content["silent"].(bool)
+	if stop, ok := content["stop_on_error"].(bool); ok {
+		cell.StopOnError = stop
+	}
+	if allow, ok := content["allow_stdin"].(bool); ok {
+		cell.AllowStdin = allow
+	}
+	return cell
+}
+
+// queuedShells returns the main shell followed by the subshells, in the order of
+// their ids.
+func queuedShells() []*shell {
+	ids := append([]string{""}, subshellIDs()...)
+	shells.Lock()
+	defer shells.Unlock()
+	var list []*shell
+	for _, id := range ids {
+		if s, ok := shells.byID[id]; ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// pendingCells returns the execute_requests waiting in the shells, in the order they
+// will be handled by each shell.
+func pendingCells() []queuedCell {
+	var cells []queuedCell
+	for _, s := range queuedShells() {
+		s.mu.Lock()
+		for _, receipt := range s.pending {
+			if receipt.Msg.Header.MsgType == "execute_request" {
+				cells = append(cells, newQueuedCell(receipt))
+			}
+		}
+		s.mu.Unlock()
+	}
+	return cells
+}
+
+// dropPendingCells removes the execute_requests waiting in the shells for which drop
+// returns true, and answers them with aborted replies. It returns the dropped cells.
+func dropPendingCells(drop func(queuedCell) bool) []queuedCell {
+	var dropped []msgReceipt
+	for _, s := range queuedShells() {
+		s.mu.Lock()
+		kept := s.pending[:0]
+		for _, receipt := range s.pending {
+			if receipt.Msg.Header.MsgType == "execute_request" && drop(newQueuedCell(receipt)) {
+				dropped = append(dropped, receipt)
+			} else {
+				kept = append(kept, receipt)
+			}
+		}
+		s.pending = kept
+		s.mu.Unlock()
+	}
+
+	cells := make([]queuedCell, 0, len(dropped))
+	for _, receipt := range dropped {
+		cells = append(cells, newQueuedCell(receipt))
+		abortExecuteRequest(receipt)
+	}
+	return cells
+}
+
+// stopOnErrorTimeout is how long the shell of a failed cell keeps aborting the
+// execute_requests it receives, like the stop_on_error_timeout of ipykernel: the
+// requests of the cells after the failed one, e.g. with Run All, may only reach the
+// shell once it failed.
+const stopOnErrorTimeout = 100 * time.Millisecond
+
+// abortQueuedCells drops the execute_requests queued in the shell with subshellID,
+// after a cell of the shell failed, and those it receives for stopOnErrorTimeout.
+func abortQueuedCells(subshellID string) []queuedCell {
+	shells.Lock()
+	s, ok := shells.byID[subshellID]
+	shells.Unlock()
+	if ok {
+		s.abortFor(stopOnErrorTimeout)
+	}
+	return dropPendingCells(func(cell queuedCell) bool { return cell.SubshellID == subshellID })
+}
+
+// abortExecuteRequest answers an execute_request that will not be executed with an
+// aborted reply, as kernels do for the cells queued after one that failed.
+func abortExecuteRequest(receipt msgReceipt) {
+	defer receipt.Span.end()
+	if err := receipt.PublishKernelStatus(kernelBusy); err != nil {
+		log.Printf("Error publishing kernel status 'busy': %v\n", err)
+	}
+	if err := receipt.Reply("execute_reply", map[string]interface{}{"status": "aborted"}); err != nil {
+		log.Printf("Error replying to an aborted execute_request: %v\n", err)
+	}
+	if err := receipt.PublishKernelStatus(kernelIdle); err != nil {
+		log.Printf("Error publishing kernel status 'idle': %v\n", err)
+	}
+}
+
+// handleQueueRequest answers a queue_request on the control channel, which lists the
+// queued cells and optionally drops some: its content may set "drop" to "all" or to
+// a list of msg_ids of execute_requests. The reply lists the cells still queued, in
+// "queue", and the msg_ids of those dropped, in "dropped".
+func handleQueueRequest(receipt msgReceipt) error {
+	content, _ := receipt.Msg.Content.(map[string]interface{})
+	var drop func(queuedCell) bool
+	switch ids := content["drop"].(type) {
+	case string:
+		if ids != "all" {
+			return receipt.Reply("queue_reply", map[string]interface{}{
+				"status": "error",
+				"ename":  "ValueError",
+				"evalue": fmt.Sprintf(`drop must be "all" or a list of msg_ids, not %q`, ids),
+			})
+		}
+		drop = func(queuedCell) bool { return true }
+	case []interface{}:
+		set := make(map[string]bool)
+		for _, id := range ids {
+			if id, ok := id.(string); ok {
+				set[id] = true
+			}
+		}
+		drop = func(cell queuedCell) bool { return set[cell.MsgID] }
+	}
+
+	dropped := []string{}
+	if drop != nil {
+		for _, cell := range dropPendingCells(drop) {
+			dropped = append(dropped, cell.MsgID)
+		}
+	}
+	queue := pendingCells()
+	if queue == nil {
+		queue = []queuedCell{}
+	}
+	return receipt.Reply("queue_reply", map[string]interface{}{
+		"status":  "ok",
+		"queue":   queue,
+		"dropped": dropped,
+	})
+}
+
+// evalQueueMagic implements `%queue`, which lists the cells queued after the current
+// one, and `%queue drop [position...]`, which drops them.
+func evalQueueMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := queueSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	cells := pendingCells()
+	switch parsed.arg(0) {
+	case "":
+		if len(cells) == 0 {
+			fmt.Fprintln(outerr.out, "No cells are queued.")
+		}
+		for i, cell := range cells {
+			fmt.Fprintf(outerr.out, "%3d  %s\n", i+1, summarizeCode(cell.Code))
+		}
+		return nil
+	case "drop":
+	default:
+		return queueSyntax.errorf("unknown action %q", parsed.arg(0))
+	}
+
+	drop := make(map[string]bool)
+	for _, arg := range parsed.args[1:] {
+		position, err := strconv.Atoi(arg)
+		if err != nil || position < 1 || position > len(cells) {
+			return queueSyntax.errorf("no queued cell at position %s", arg)
+		}
+		drop[cells[position-1].MsgID] = true
+	}
+	dropped := dropPendingCells(func(cell queuedCell) bool {
+		return len(drop) == 0 || drop[cell.MsgID]
+	})
+	fmt.Fprintf(outerr.out, "Dropped %d queued cells.\n", len(dropped))
+	return nil
+}
+
+// summarizeCode returns the first line of code, shortened to fit a listing.
+func summarizeCode(code string) string {
+	code = strings.TrimSpace(code)
+	line := code
+	if i := strings.IndexByte(code, '\n'); i >= 0 {
+		line = code[:i] + " ..."
+	}
+	if len(line) > 72 {
+		line = line[:69] + "..."
+	}
+	return line
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	"github.com/wangfenjin/gopyter/wire"
+)
+
+// TestQueue tests listing and dropping the execute_requests waiting in a shell.
+func TestQueue(t *testing.T) {
+	connInfo := testConnectionInfo(t, "")
+	sockets, err := prepareSockets(connInfo)
+	if err != nil {
+		t.Fatalf("\t%s Could not listen: %v.", failure, err)
+	}
+	defer func() {
+		for _, s := range []Socket{sockets.ShellSocket, sockets.ControlSocket, sockets.StdinSocket, sockets.IOPubSocket, sockets.HBSocket} {
+			s.Socket.Close()
+		}
+	}()
+
+	// The front-end, to which the kernel sends the aborted replies.
+	frontend := zmq4.NewDealer(context.Background(), zmq4.WithID(zmq4.SocketIdentity("frontend")))
+	defer frontend.Close()
+	if err := frontend.Dial(fmt.Sprintf("tcp://127.0.0.1:%d", connInfo.ShellPort)); err != nil {
+		t.Fatalf("\t%s Could not connect: %v.", failure, err)
+	}
+	if err := frontend.Send(zmq4.NewMsgString("hello")); err != nil {
+		t.Fatalf("\t%s Could not send: %v.", failure, err)
+	}
+	if _, err := sockets.ShellSocket.Socket.Recv(); err != nil {
+		t.Fatalf("\t%s Could not receive: %v.", failure, err)
+	}
+
+	// The shell is not started, so its requests stay queued.
+	s := newShell("test-queue")
+	shells.Lock()
+	shells.byID[s.id] = s
+	shells.Unlock()
+	defer deleteSubshell(s.id)
+	request := func(id, msgType string, content map[string]interface{}) msgReceipt {
+		return msgReceipt{
+			Msg:        ComposedMsg{Header: MsgHeader{MsgID: id, MsgType: msgType, SubshellID: s.id}, Content: content},
+			Identities: [][]byte{[]byte("frontend")},
+			Sockets:    sockets,
+		}
+	}
+	s.push(request("a", "execute_request", map[string]interface{}{"code": "x := 1\ny := 2"}))
+	s.push(request("b", "is_complete_request", map[string]interface{}{"code": "x"}))
+	s.push(request("c", "execute_request", map[string]interface{}{"code": "println(x)", "stop_on_error": false}))
+
+	t.Logf("Should list the queued cells.")
+
+	var queued []queuedCell
+	for _, cell := range pendingCells() {
+		if cell.SubshellID == s.id {
+			queued = append(queued, cell)
+		}
+	}
+	if len(queued) != 2 || queued[0].MsgID != "a" || queued[1].MsgID != "c" {
+		t.Fatalf("\t%s Expected the execute_requests a and c, got %+v.", failure, queued)
+	}
+	if !queued[0].StopOnError || queued[1].StopOnError || !queued[0].AllowStdin {
+		t.Fatalf("\t%s Expected the flags of the requests, got %+v.", failure, queued)
+	}
+	if summary := summarizeCode(queued[0].Code); summary != "x := 1 ..." {
+		t.Fatalf("\t%s Expected the first line of the cell, got %q.", failure, summary)
+	}
+	t.Logf("\t%s Listed %d cells.", success, len(queued))
+
+	t.Logf("Should drop queued cells, answering them with aborted replies.")
+
+	dropped := dropPendingCells(func(cell queuedCell) bool { return cell.MsgID == "c" })
+	if len(dropped) != 1 || dropped[0].MsgID != "c" {
+		t.Fatalf("\t%s Expected to drop c, dropped %+v.", failure, dropped)
+	}
+	if len(s.pending) != 2 || s.pending[0].Msg.Header.MsgID != "a" || s.pending[1].Msg.Header.MsgID != "b" {
+		t.Fatalf("\t%s Expected a and b to stay queued, got %d requests.", failure, len(s.pending))
+	}
+
+	replies := make(chan zmq4.Msg, 1)
+	go func() {
+		msg, err := frontend.Recv()
+		if err == nil {
+			replies <- msg
+		}
+	}()
+	select {
+	case msg := <-replies:
+		reply, _, err := wire.Decode(msg.Frames, nil)
+		if err != nil {
+			t.Fatalf("\t%s Could not decode the reply: %v.", failure, err)
+		}
+		status, _ := reply.Content.(map[string]interface{})["status"].(string)
+		if reply.Header.MsgType != "execute_reply" || reply.ParentHeader.MsgID != "c" || status != "aborted" {
+			t.Fatalf("\t%s Expected an aborted execute_reply to c, got %s %+v.", failure, reply.Header.MsgType, reply.Content)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("\t%s Expected an aborted reply.", failure)
+	}
+	t.Logf("\t%s Dropped c.", success)
+
+	t.Logf("Should abort the cells queued in a shell after one failed.")
+
+	if dropped := abortQueuedCells("another-shell"); len(dropped) != 0 {
+		t.Fatalf("\t%s Expected the cells of other shells to stay queued, dropped %+v.", failure, dropped)
+	}
+	dropped = abortQueuedCells(s.id)
+	if len(dropped) != 1 || dropped[0].MsgID != "a" || len(s.pending) != 1 || s.pending[0].Msg.Header.MsgID != "b" {
+		t.Fatalf("\t%s Expected to drop a and keep b, dropped %+v.", failure, dropped)
+	}
+	t.Logf("\t%s Aborted a.", success)
+
+	t.Logf("Should abort the cells a shell receives shortly after one failed.")
+
+	if !s.aborts(request("d", "execute_request", nil)) || s.aborts(request("e", "is_complete_request", nil)) {
+		t.Fatalf("\t%s Expected only the execute_request to be aborted.", failure)
+	}
+	time.Sleep(stopOnErrorTimeout)
+	if s.aborts(request("f", "execute_request", nil)) {
+		t.Fatalf("\t%s Expected the execute_request to be handled after %v.", failure, stopOnErrorTimeout)
+	}
+	t.Logf("\t%s Aborted d only.", success)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/goplus/gop/scanner"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	lineMagics["reactive"] = evalReactiveMagic
+	documentMagic(reactiveSyntax)
+}
+
+var reactiveSyntax = &magicSyntax{
+	name:  "%reactive",
+	usage: []string{"[on|off]"},
+	doc:   "Enables or disables re-executing the cells depending on a changed variable, or reports whether it is enabled.",
+	args:  []magicParam{{name: "on|off", help: "enables or disables reactive execution", optional: true}},
+}
+
+// In reactive mode, executing a cell re-executes the cells depending on the variables it
+// changed, in the order they were executed, so the notebook stays consistent like in
+// Pluto.jl. Front-ends cannot be told to update the outputs of other cells, so the new
+// outputs are sent on the deps comm for extensions to display, and the cell that caused
+// the re-execution reports which cells were re-executed.
+
+// evalReactiveMagic implements `%reactive [on|off]`, which enables or disables reactive
+// execution, or reports whether it is enabled.
+func evalReactiveMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := reactiveSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	switch parsed.arg(0) {
+	case "on":
+		kernel.config.Reactive = true
+	case "off":
+		kernel.config.Reactive = false
+	case "":
+	default:
+		return reactiveSyntax.errorf("expected on or off, got %q", args)
+	}
+	state := "off"
+	if kernel.config.Reactive {
+		state = "on"
+	}
+	fmt.Fprintf(outerr.out, "Reactive execution is %s.\n", state)
+	return nil
+}
+
+// reexecuteStale re-executes the stale cells, in execution order, publishing their
+// outputs on the deps comms. It stops at the first cell that fails, leaving the cells
+// after it stale.
+func (kernel *Kernel) reexecuteStale(receipt msgReceipt) {
+	for _, d := range kernel.session.deps.staleCells() {
+		ExecCounter++
+		kernel.session.deps.remove(d)
+		outputs, err := kernel.executeCell(ExecCounter, d.CellID, kernel.session.redeclare(d.code))
+
+		note := fmt.Sprintf("Re-executed In[%d] as In[%d].\n", d.Count, ExecCounter)
+		if err != nil {
+			note = fmt.Sprintf("Re-executing In[%d] failed: %s\n", d.Count, kernel.session.redactor.redact(err.Error()))
+		}
+		if err := receipt.PublishWriteStream(StreamStderr, note); err != nil {
+			log.Printf("Error publishing stream: %v\n", err)
+		}
+
+		executed := map[string]interface{}{
+			"executed": map[string]interface{}{
+				"cell_id":         d.CellID,
+				"execution_count": ExecCounter,
+				"outputs":         outputs,
+			},
+		}
+		for _, c := range commsOf(depsCommTarget) {
+			if err := c.send(receipt, executed); err != nil {
+				log.Printf("Error sending the outputs of a re-executed cell: %v\n", err)
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+	kernel.publishDeps(receipt)
+}
+
+// redeclare returns code with the short variable declarations at its top level turned
+// into assignments when the session already declares all their variables. Re-executing
+// a cell declaring variables would otherwise fail, since the session keeps the earlier
+// declarations.
+func (s *Session) redeclare(code string) string {
+	var sc scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(code))
+	sc.Init(file, []byte(code), nil, 0)
+
+	var defines []int // the offsets of the ":=" to replace
+	var names []string
+	depth := 0
+	simple := true // whether the statement only has identifiers so far
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		switch {
+		case tok == token.LPAREN || tok == token.LBRACK || tok == token.LBRACE:
+			depth++
+			simple = false
+		case tok == token.RPAREN || tok == token.RBRACK || tok == token.RBRACE:
+			depth--
+		case depth != 0:
+		case tok == token.SEMICOLON:
+			names, simple = nil, true
+		case tok == token.IDENT && simple:
+			names = append(names, lit)
+		case tok == token.COMMA && simple:
+		case tok == token.DEFINE && simple && s.declared(names):
+			defines = append(defines, file.Offset(pos))
+			simple = false
+		default:
+			simple = false
+		}
+	}
+
+	b := []byte(code)
+	for _, offset := range defines {
+		copy(b[offset:], "= ")
+	}
+	return string(b)
+}
+
+// declared reports whether the session declares all the variables named.
+func (s *Session) declared(names []string) bool {
+	if s.src == "" || len(names) == 0 {
+		return false
+	}
+	for _, name := range names {
+		if name == "_" {
+			continue
+		}
+		if _, err := s.Peek(name); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestRedeclare tests turning the declarations of declared variables into assignments.
+func TestRedeclare(t *testing.T) {
+	s := NewSession()
+	if _, err := s.Eval("x := 1\ny := 2"); err != nil {
+		t.Fatalf("\t%s Could not declare the variables: %v.", failure, err)
+	}
+
+	cases := []struct {
+		code string
+		want string
+	}{
+		{"x := 5", "x =  5"},
+		{"x, y := 3, 4", "x, y =  3, 4"},
+		{"x, z := 3, 4", "x, z := 3, 4"},
+		{"for i := 0; i < 3; i++ {\n\tx := i\n}", "for i := 0; i < 3; i++ {\n\tx := i\n}"},
+		{"z := 1\ny := z", "z := 1\ny =  z"},
+	}
+
+	t.Logf("Should only turn the top-level declarations of declared variables into assignments.")
+
+	for _, tc := range cases {
+		if got := s.redeclare(tc.code); got != tc.want {
+			t.Errorf("\t%s redeclare(%q) = %q, expected %q.", failure, tc.code, got, tc.want)
+			continue
+		}
+		t.Logf("\t%s %q", success, tc.code)
+	}
+}
+
+// TestReexecuteDependents tests re-executing the cells depending on a changed variable.
+func TestReexecuteDependents(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+
+	execute := func(count int, cellID, code string) {
+		if _, err := kernel.executeCell(count, cellID, kernel.session.redeclare(code)); err != nil {
+			t.Fatalf("\t%s Executing %q failed: %v.", failure, code, err)
+		}
+	}
+
+	t.Logf("Should bring a dependent cell up to date by re-executing it.")
+
+	execute(1, "a", "x := 1")
+	execute(2, "b", "y := x * 2")
+	execute(3, "a", "x := 5")
+
+	stale := kernel.session.deps.staleCells()
+	if len(stale) != 1 || stale[0].CellID != "b" {
+		t.Fatalf("\t%s Expected cell b to be stale, got %v.", failure, stale)
+	}
+	kernel.session.deps.remove(stale[0])
+	execute(4, stale[0].CellID, stale[0].code)
+
+	if vals, err := kernel.session.Peek("y"); err != nil || len(vals) != 1 || vals[0] != 10 {
+		t.Fatalf("\t%s Expected y to be 10, got %v (%v).", failure, vals, err)
+	}
+	if stale := kernel.session.deps.staleCells(); len(stale) != 0 {
+		t.Fatalf("\t%s Expected no stale cells, got %v.", failure, stale)
+	}
+	t.Logf("\t%s y was updated.", success)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultPrompt is the prompt printed before the first line of a cell in console mode.
+	DefaultPrompt = "gop> "
+
+	// DefaultContinuationPrompt is the prompt printed before each continuation line.
+	DefaultContinuationPrompt = "...> "
+)
+
+// Reader reads complete snippets of Go+ source from a line-oriented input, such as a
+// terminal, asking for continuation lines while the source read so far is incomplete.
+type Reader struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// Prompt is printed to the output before the first line of a snippet.
+	Prompt string
+
+	// ContinuationPrompt is printed before each continuation line. It is followed by
+	// the indentation suggested for the line, if any.
+	ContinuationPrompt string
+}
+
+// NewReader creates a Reader reading lines from in and printing prompts to out. A nil
+// out disables the prompts.
+func NewReader(in io.Reader, out io.Writer) *Reader {
+	return &Reader{
+		in:                 bufio.NewReader(in),
+		out:                out,
+		Prompt:             DefaultPrompt,
+		ContinuationPrompt: DefaultContinuationPrompt,
+	}
+}
+
+// ReadMultiline reads lines until they form a complete snippet of source and returns
+// them joined. Two consecutive empty continuation lines end the snippet even if it is
+// still incomplete, leaving the evaluator to report the problem. At the end of the
+// input, ReadMultiline returns whatever was read together with io.EOF.
+func (r *Reader) ReadMultiline() (string, error) {
+	var (
+		src    strings.Builder
+		prompt = r.Prompt
+		empty  int
+	)
+
+	for {
+		if r.out != nil {
+			io.WriteString(r.out, prompt)
+		}
+
+		line, err := r.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return src.String(), err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if src.Len() != 0 {
+			src.WriteByte('\n')
+		}
+		src.WriteString(line)
+
+		status, indent := isComplete(src.String())
+		if status != completeIncomplete {
+			return src.String(), nil
+		}
+
+		if strings.TrimSpace(line) == "" {
+			empty++
+			if empty == 2 {
+				return src.String(), nil
+			}
+		} else {
+			empty = 0
+		}
+
+		prompt = r.ContinuationPrompt + indent
+	}
+}
+
+const (
+	completeComplete   = "complete"
+	completeIncomplete = "incomplete"
+	completeInvalid    = "invalid"
+)
+
+// isComplete reports whether src is a complete snippet of source, using the status
+// values of the Jupyter is_complete_reply. For incomplete source it also returns the
+// indentation suggested for the next line.
+func isComplete(src string) (status string, indent string) {
+	state := scanSource(src)
+
+	switch {
+	case state.invalid:
+		return completeInvalid, ""
+	case len(state.brackets) != 0 || state.inRawString || state.inComment || state.continues():
+		depth := 0
+		for _, b := range state.brackets {
+			if b == '{' {
+				depth++
+			}
+		}
+		return completeIncomplete, strings.Repeat("    ", depth)
+	default:
+		return completeComplete, ""
+	}
+}
+
+// scanState summarizes the lexical state at the end of a snippet of source.
+type scanState struct {
+	brackets    []rune // brackets that are still open, innermost last
+	inRawString bool   // inside a `raw string`
+	inComment   bool   // inside a /* block comment */
+	openedAt    int    // offset of the unterminated raw string or block comment
+	invalid     bool   // a bracket was closed by the wrong kind of bracket
+	lastWord    string // the last significant token if it is an identifier or keyword
+	lastOp      string // the last significant token if it is an operator
+}
+
+// scanSource scans src rune by rune, skipping over string, rune and comment contents
+// so that brackets and operators inside them are ignored. Multibyte characters are
+// decoded as a whole and never mistaken for delimiters.
+func scanSource(src string) scanState {
+	var state scanState
+
+	for i := 0; i < len(src); {
+		c, size := utf8.DecodeRuneInString(src[i:])
+		next, _ := utf8.DecodeRuneInString(src[i+size:])
+
+		switch {
+		case c == '/' && next == '/':
+			// Line comments run to the end of the line and do not change the last token.
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				return state
+			}
+			i += end
+			continue
+
+		case c == '/' && next == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				state.inComment = true
+				state.openedAt = i
+				return state
+			}
+			i += 2 + end + 2
+			continue
+
+		case c == '`':
+			end := strings.IndexByte(src[i+1:], '`')
+			if end < 0 {
+				state.inRawString = true
+				state.openedAt = i
+				return state
+			}
+			state.lastWord, state.lastOp = "", ""
+			i += 1 + end + 1
+			continue
+
+		case c == '"' || c == '\'':
+			i += size + quotedLen(src[i+size:], c)
+			state.lastWord, state.lastOp = "", ""
+			continue
+
+		case c == '(' || c == '[' || c == '{':
+			state.brackets = append(state.brackets, c)
+			state.lastWord, state.lastOp = "", string(c)
+
+		case c == ')' || c == ']' || c == '}':
+			n := len(state.brackets)
+			if n == 0 || state.brackets[n-1] != openingBracket(c) {
+				state.invalid = true
+				return state
+			}
+			state.brackets = state.brackets[:n-1]
+			state.lastWord, state.lastOp = "", string(c)
+
+		case unicode.IsLetter(c) || c == '_' || unicode.IsDigit(c):
+			j := i
+			for j < len(src) {
+				r, n := utf8.DecodeRuneInString(src[j:])
+				if !unicode.IsLetter(r) && r != '_' && !unicode.IsDigit(r) {
+					break
+				}
+				j += n
+			}
+			state.lastWord, state.lastOp = src[i:j], ""
+			i = j
+			continue
+
+		case unicode.IsSpace(c):
+
+		default:
+			op := operatorAt(src[i:])
+			state.lastWord, state.lastOp = "", op
+			i += len(op)
+			continue
+		}
+
+		i += size
+	}
+
+	return state
+}
+
+// checkUnterminated returns an error if src ends inside a raw string or block comment.
+// The error points at the opening backquote or "/*", which is more helpful than the
+// parser's complaint about the unexpected end of the cell.
+func checkUnterminated(src string) error {
+	state := scanSource(src)
+
+	var what string
+	switch {
+	case state.inRawString:
+		what = "raw string literal"
+	case state.inComment:
+		what = "comment"
+	default:
+		return nil
+	}
+
+	line, col := position(src, state.openedAt)
+	return fmt.Errorf("%d:%d: %s not terminated", line, col, what)
+}
+
+// position converts a byte offset in src to a 1-based line and rune column.
+func position(src string, offset int) (line, col int) {
+	before := src[:offset]
+	line = strings.Count(before, "\n") + 1
+	col = utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+	return line, col
+}
+
+// quotedLen returns the length of the rest of a string or rune literal opened with
+// quote, including the closing quote. An unterminated literal ends at the end of the
+// line, where the parser will report it.
+func quotedLen(src string, quote rune) int {
+	for i := 0; i < len(src); {
+		c, size := utf8.DecodeRuneInString(src[i:])
+		switch c {
+		case '\\':
+			_, escaped := utf8.DecodeRuneInString(src[i+size:])
+			i += size + escaped
+			continue
+		case quote:
+			return i + size
+		case '\n':
+			return i
+		}
+		i += size
+	}
+	return len(src)
+}
+
+func openingBracket(c rune) rune {
+	switch c {
+	case ')':
+		return '('
+	case ']':
+		return '['
+	}
+	return '{'
+}
+
+// goplusOperators lists the Go+ operators of more than one character, longest first,
+// so that the scanner can split runs of operator characters the way the parser does.
+// Besides the Go operators it holds "=>", which introduces the body of a lambda.
+var goplusOperators = []string{
+	"<<=", ">>=", "&^=", "...",
+	"&&", "||", "<-", "++", "--", "==", "!=", "<=", ">=", ":=", "=>",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "<<", ">>", "&^",
+}
+
+// operatorAt returns the operator at the start of src.
+func operatorAt(src string) string {
+	for _, op := range goplusOperators {
+		if strings.HasPrefix(src, op) {
+			return op
+		}
+	}
+	_, size := utf8.DecodeRuneInString(src)
+	return src[:size]
+}
+
+// continues reports whether the last significant token of the source requires the
+// statement to continue on the next line.
+func (state scanState) continues() bool {
+	if state.lastWord != "" {
+		return lastIsKeywordIgnoresNl(state.lastWord)
+	}
+	return lastIsOperatorIgnoresNl(state.lastOp)
+}
+
+// lastIsOperatorIgnoresNl reports whether op is an operator after which a newline does
+// not end the statement. This is the case for every binary and assignment operator,
+// and for the Go+ "=>" of a lambda and "<-" of a comprehension, but not for the Go+
+// error operators: `f()?` and `f()!` are complete statements, while the default value
+// of `f()?:` must follow.
+func lastIsOperatorIgnoresNl(op string) bool {
+	switch op {
+	case "", "++", "--", ")", "]", "}", ";", "?", "!":
+		return false
+	}
+	return true
+}
+
+// lastIsKeywordIgnoresNl reports whether word is a keyword after which a newline does
+// not end the statement. Go+ shares the keywords of Go, which inserts a semicolon after
+// identifiers and after break, continue, fallthrough and return, but not after other
+// keywords. In Go+ this also keeps command-style calls such as `println x` complete.
+func lastIsKeywordIgnoresNl(word string) bool {
+	switch word {
+	case "case", "chan", "const", "default", "defer", "else", "for", "func", "go",
+		"goto", "if", "import", "interface", "map", "package", "range", "select",
+		"struct", "switch", "type", "var":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestIsComplete tests the detection of complete and incomplete snippets.
+func TestIsComplete(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Status string
+		Indent string
+	}{
+		{"a := 1", completeComplete, ""},
+		{"a++", completeComplete, ""},
+		{"func f() {", completeIncomplete, "    "},
+		{"if x {\n\tfor {", completeIncomplete, "        "},
+		{"fmt.Println(", completeIncomplete, ""},
+		{"a := 1 +", completeIncomplete, ""},
+		{"x := []int{1, 2,", completeIncomplete, "    "},
+		{"s := \"({[\"", completeComplete, ""},
+		{"r := '('", completeComplete, ""},
+		{"s := \"héllo ( wörld\"", completeComplete, ""},
+		{"r := '世'", completeComplete, ""},
+		{"s := \"日本語 {\" // {", completeComplete, ""},
+		{"s := `日本語\n(", completeIncomplete, ""},
+		{"/* 注释 (", completeIncomplete, ""},
+		{"f := x =>", completeIncomplete, ""},
+		{"f := (x, y) => {", completeIncomplete, "    "},
+		{"println \"hello\", x", completeComplete, ""},
+		{"println \"hello\",", completeIncomplete, ""},
+		{"a := [x*x for x <- [1, 3, 5],\n\tx > 1", completeIncomplete, ""},
+		{"a := [x*x for x <-", completeIncomplete, ""},
+		{"m := {k: v for k, v <- src}", completeComplete, ""},
+		{"a := [x for x <- 1:", completeIncomplete, ""},
+		{"a := [x for x <- 0:10 if", completeIncomplete, ""},
+		{"for i <- :10 {", completeIncomplete, "    "},
+		{"n := strconv.Atoi(s)?", completeComplete, ""},
+		{"n := strconv.Atoi(s)!", completeComplete, ""},
+		{"n := strconv.Atoi(s)?:", completeIncomplete, ""},
+		{"x := 1/3r", completeComplete, ""},
+		{"a := 1)", completeInvalid, ""},
+		{"} else", completeInvalid, ""},
+	}
+
+	t.Logf("Should detect whether snippets are complete.")
+
+	for k, tc := range cases {
+		t.Logf("  Checking snippet %d/%d.", k+1, len(cases))
+
+		status, indent := isComplete(tc.Input)
+		if status != tc.Status || indent != tc.Indent {
+			t.Errorf("\t%s isComplete(%q) = %q, %q; expected %q, %q.", failure, tc.Input, status, indent, tc.Status, tc.Indent)
+			continue
+		}
+		t.Logf("\t%s Returned the expected status.", success)
+	}
+}
+
+// TestReadMultiline tests that the reader joins continuation lines into a single snippet.
+func TestReadMultiline(t *testing.T) {
+	input := "a := 1\nfunc f() {\n\treturn\n}\ns := `多\n行`\nb := (\n\n\n"
+
+	var prompts strings.Builder
+	reader := NewReader(strings.NewReader(input), &prompts)
+	reader.Prompt = "> "
+	reader.ContinuationPrompt = ". "
+
+	expected := []string{"a := 1", "func f() {\n\treturn\n}", "s := `多\n行`", "b := (\n\n"}
+	for _, snippet := range expected {
+		got, err := reader.ReadMultiline()
+		if err != nil {
+			t.Fatalf("\t%s ReadMultiline: %s", failure, err)
+		}
+		if got != snippet {
+			t.Fatalf("\t%s ReadMultiline returned %q, expected %q.", failure, got, snippet)
+		}
+	}
+
+	if _, err := reader.ReadMultiline(); err != io.EOF {
+		t.Fatalf("\t%s Expected io.EOF at the end of the input but got %v.", failure, err)
+	}
+
+	if !strings.HasPrefix(prompts.String(), "> > .     .     > . > ") {
+		t.Fatalf("\t%s Unexpected prompts %q.", failure, prompts.String())
+	}
+	t.Logf("\t%s Read the expected snippets.", success)
+}
+
+// TestCheckUnterminated tests that unterminated raw strings and comments are reported at
+// their opening position.
+func TestCheckUnterminated(t *testing.T) {
+	cases := []struct {
+		Input string
+		Error string
+	}{
+		{"s := `raw`", ""},
+		{"a := 1\ns := `日本語\n", "2:6: raw string literal not terminated"},
+		{"/* ok */ x := 1", ""},
+		{"x := \"é\" /* open", "1:10: comment not terminated"},
+		{"s := \"`\" // `", ""},
+	}
+
+	t.Logf("Should report unterminated raw strings and comments.")
+
+	for k, tc := range cases {
+		t.Logf("  Checking snippet %d/%d.", k+1, len(cases))
+
+		var got string
+		if err := checkUnterminated(tc.Input); err != nil {
+			got = err.Error()
+		}
+		if got != tc.Error {
+			t.Errorf("\t%s checkUnterminated(%q) = %q, expected %q.", failure, tc.Input, got, tc.Error)
+			continue
+		}
+		t.Logf("\t%s Returned the expected error.", success)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	"github.com/wangfenjin/gopyter/wire"
+)
+
+// With -record, the kernel writes the messages it receives and sends on the shell,
+// control, stdin and iopub channels to a JSON lines file, without their signatures.
+// `gopyter replay` handles the requests of such a file again with a new kernel, and
+// compares its replies with those recorded, to reproduce the bugs users report.
+
+// messageLog records the messages of the kernel, with the -record flag.
+var messageLog *messageRecorder
+
+// messageRecorder writes messages as JSON lines.
+type messageRecorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// recordedMsg is a message recorded, as a line of the file.
+type recordedMsg struct {
+	Time         time.Time       `json:"time"`
+	Channel      string          `json:"channel"`
+	Direction    string          `json:"direction"` // "in" for the messages received, "out" for those sent
+	Header       json.RawMessage `json:"header"`
+	ParentHeader json.RawMessage `json:"parent_header"`
+	Metadata     json.RawMessage `json:"metadata"`
+	Content      json.RawMessage `json:"content"`
+	Buffers      [][]byte        `json:"buffers,omitempty"`
+}
+
+func newMessageRecorder(w io.Writer) *messageRecorder {
+	return &messageRecorder{enc: json.NewEncoder(w)}
+}
+
+// startRecording records the messages of the kernel to the file at path.
+func startRecording(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create the record file: %v", err)
+	}
+	messageLog = newMessageRecorder(f)
+	return nil
+}
+
+// record records the message of the wire frames, received or sent on channel. The
+// return identities and the signature are left out. A nil recorder records nothing.
+func (r *messageRecorder) record(channel, direction string, frames [][]byte) {
+	if r == nil {
+		return
+	}
+	msg, ok := decodeRecordedMsg(channel, direction, frames)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(msg); err != nil {
+		log.Printf("Could not record a %s message: %v\n", channel, err)
+	}
+}
+
+// decodeRecordedMsg returns the message of the wire frames, or false if they are not a
+// message of the Jupyter protocol.
+func decodeRecordedMsg(channel, direction string, frames [][]byte) (recordedMsg, bool) {
+	i := 0
+	for i < len(frames) && string(frames[i]) != wire.Delimiter {
+		i++
+	}
+	if len(frames) < i+6 {
+		return recordedMsg{}, false
+	}
+	msg := recordedMsg{
+		Time:         time.Now().UTC(),
+		Channel:      channel,
+		Direction:    direction,
+		Header:       frames[i+2],
+		ParentHeader: frames[i+3],
+		Metadata:     frames[i+4],
+		Content:      frames[i+5],
+	}
+	if len(frames) > i+6 {
+		msg.Buffers = frames[i+6:]
+	}
+	return msg, true
+}
+
+// frames returns the unsigned wire frames of the message, without return identities.
+func (m recordedMsg) frames() [][]byte {
+	frames := [][]byte{[]byte(wire.Delimiter), nil, m.Header, m.ParentHeader, m.Metadata, m.Content}
+	return append(frames, m.Buffers...)
+}
+
+func (m recordedMsg) header() MsgHeader {
+	var header MsgHeader
+	json.Unmarshal(m.Header, &header)
+	return header
+}
+
+func (m recordedMsg) parentHeader() MsgHeader {
+	var header MsgHeader
+	json.Unmarshal(m.ParentHeader, &header)
+	return header
+}
+
+// record sets the sockets of sg to record the messages of their channel to r.
+func (sg SocketGroup) record(r *messageRecorder) {
+	sockets := map[string]Socket{
+		"shell":   sg.ShellSocket,
+		"control": sg.ControlSocket,
+		"stdin":   sg.StdinSocket,
+		"iopub":   sg.IOPubSocket,
+	}
+	for channel, s := range sockets {
+		if socket, ok := s.Socket.(*rebindableSocket); ok {
+			socket.channel, socket.recorder = channel, r
+		}
+	}
+}
+
+// readRecording reads the messages recorded by -record.
+func readRecording(r io.Reader) ([]recordedMsg, error) {
+	var msgs []recordedMsg
+	dec := json.NewDecoder(r)
+	for {
+		var msg recordedMsg
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid recording: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// replayWire holds the messages received and sent by the sockets of a replay.
+type replayWire struct {
+	mu      sync.Mutex
+	pending map[string][][][]byte // the frames to receive next, by channel
+	inputs  []recordedMsg         // the input replies recorded, received in order
+	sent    []recordedMsg
+}
+
+// replaySocket is a socket of a replay, which receives the messages recorded and keeps
+// those sent.
+type replaySocket struct {
+	channel string
+	wire    *replayWire
+}
+
+func (s *replaySocket) Close() error { return nil }
+
+func (s *replaySocket) Send(msg zmq4.Msg) error { return s.SendMulti(msg) }
+
+func (s *replaySocket) SendMulti(msg zmq4.Msg) error {
+	sent, ok := decodeRecordedMsg(s.channel, "out", msg.Frames)
+	if !ok {
+		return errors.New("not a Jupyter message")
+	}
+	s.wire.mu.Lock()
+	defer s.wire.mu.Unlock()
+	s.wire.sent = append(s.wire.sent, sent)
+	return nil
+}
+
+// Recv receives the next message recorded on the channel. The input replies answer
+// the last input request sent.
+func (s *replaySocket) Recv() (zmq4.Msg, error) {
+	w := s.wire
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if s.channel == "stdin" {
+		if len(w.inputs) == 0 {
+			return zmq4.Msg{}, errors.New("no more input replies were recorded")
+		}
+		reply := w.inputs[0]
+		w.inputs = w.inputs[1:]
+		for i := len(w.sent) - 1; i >= 0; i-- {
+			if w.sent[i].Channel == "stdin" {
+				parent := reply.parentHeader()
+				parent.MsgID = w.sent[i].header().MsgID
+				reply.ParentHeader, _ = json.Marshal(parent)
+				break
+			}
+		}
+		return zmq4.NewMsgFrom(reply.frames()...), nil
+	}
+	pending := w.pending[s.channel]
+	if len(pending) == 0 {
+		return zmq4.Msg{}, io.EOF
+	}
+	w.pending[s.channel] = pending[1:]
+	return zmq4.NewMsgFrom(pending[0]...), nil
+}
+
+func (s *replaySocket) Listen(endpoint string) error { return nil }
+
+func (s *replaySocket) Dial(endpoint string) error { return nil }
+
+func (s *replaySocket) Type() zmq4.SocketType { return zmq4.Router }
+
+func (s *replaySocket) Addr() net.Addr { return nil }
+
+func (s *replaySocket) GetOption(name string) (interface{}, error) { return nil, nil }
+
+func (s *replaySocket) SetOption(name string, value interface{}) error { return nil }
+
+// replayResult compares the replies to a request recorded with those of the replay.
+type replayResult struct {
+	Request            MsgHeader
+	Recorded, Replayed []string // the replies, as "channel msg_type content"
+}
+
+func (r replayResult) same() bool {
+	if len(r.Recorded) != len(r.Replayed) {
+		return false
+	}
+	for i := range r.Recorded {
+		if r.Recorded[i] != r.Replayed[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the result, with the replies that differ as a diff.
+func (r replayResult) String() string {
+	if r.same() {
+		return fmt.Sprintf("%s %s: same %d replies", r.Request.MsgType, r.Request.MsgID, len(r.Replayed))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s: different replies", r.Request.MsgType, r.Request.MsgID)
+	prefix := 0
+	for prefix < len(r.Recorded) && prefix < len(r.Replayed) && r.Recorded[prefix] == r.Replayed[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(r.Recorded)-prefix && suffix < len(r.Replayed)-prefix &&
+		r.Recorded[len(r.Recorded)-1-suffix] == r.Replayed[len(r.Replayed)-1-suffix] {
+		suffix++
+	}
+	for _, reply := range r.Recorded[prefix : len(r.Recorded)-suffix] {
+		b.WriteString("\n- " + reply)
+	}
+	for _, reply := range r.Replayed[prefix : len(r.Replayed)-suffix] {
+		b.WriteString("\n+ " + reply)
+	}
+	return b.String()
+}
+
+// replySummaries returns the replies to compare. The streams written in several
+// messages are joined, and the transient data, which holds random display ids, is
+// left out.
+func replySummaries(msgs []recordedMsg) []string {
+	type reply struct {
+		channel, msgType string
+		content          map[string]interface{}
+	}
+	var replies []reply
+	for _, m := range msgs {
+		var content map[string]interface{}
+		json.Unmarshal(m.Content, &content)
+		delete(content, "transient")
+		msgType := m.header().MsgType
+		if n := len(replies); n != 0 && msgType == "stream" && replies[n-1].msgType == "stream" &&
+			replies[n-1].content["name"] == content["name"] {
+			text, _ := content["text"].(string)
+			prev, _ := replies[n-1].content["text"].(string)
+			replies[n-1].content["text"] = prev + text
+			continue
+		}
+		replies = append(replies, reply{m.Channel, msgType, content})
+	}
+	summaries := make([]string, len(replies))
+	for i, r := range replies {
+		content, _ := json.Marshal(r.content)
+		summaries[i] = r.channel + " " + r.msgType + " " + string(content)
+	}
+	return summaries
+}
+
+// replay handles the shell and control requests of the messages recorded one at a time,
+// in the order recorded, until a shutdown_request, and compares the replies. The
+// messages of the replay are recorded to rec, if not nil.
+func (kernel *Kernel) replay(msgs []recordedMsg, rec *messageRecorder) []replayResult {
+	w := &replayWire{pending: make(map[string][][][]byte)}
+	socket := func(channel string) Socket {
+		s := &replaySocket{channel, w}
+		return Socket{newRebindableSocket(func() zmq4.Socket { return s }), &sync.Mutex{}}
+	}
+	sockets := SocketGroup{
+		ShellSocket:   socket("shell"),
+		ControlSocket: socket("control"),
+		StdinSocket:   socket("stdin"),
+		IOPubSocket:   socket("iopub"),
+		HBSocket:      socket("hb"),
+		Key:           &signingKey{},
+	}
+	sockets.record(rec)
+	sockets.readStdin = func() ([][]byte, error) {
+		msg, err := sockets.StdinSocket.Socket.Recv()
+		return msg.Frames, err
+	}
+
+	var requests []recordedMsg
+	replies := make(map[string][]recordedMsg)
+	for _, m := range msgs {
+		switch {
+		case m.Direction == "in" && m.Channel == "stdin":
+			w.inputs = append(w.inputs, m)
+		case m.Direction == "in":
+			requests = append(requests, m)
+		default:
+			parent := m.parentHeader().MsgID
+			replies[parent] = append(replies[parent], m)
+		}
+	}
+
+	var results []replayResult
+	for _, request := range requests {
+		header := request.header()
+		if header.MsgType == "shutdown_request" {
+			break
+		}
+		w.mu.Lock()
+		w.pending[request.Channel] = append(w.pending[request.Channel], request.frames())
+		w.sent = nil
+		w.mu.Unlock()
+
+		// The replies go back on the channel of the request.
+		channel := sockets
+		if request.Channel == "control" {
+			channel.ShellSocket = sockets.ControlSocket
+		}
+		received, err := channel.ShellSocket.Socket.Recv()
+		if err != nil {
+			continue
+		}
+		msg, ids, err := wire.Decode(received.Frames, nil)
+		if err != nil {
+			continue
+		}
+		span := startSpan(nil, "replay "+header.MsgType)
+		kernel.handleShellMsg(msgReceipt{msg, ids, channel, span})
+		span.end()
+
+		w.mu.Lock()
+		results = append(results, replayResult{
+			Request:  header,
+			Recorded: replySummaries(replies[header.MsgID]),
+			Replayed: replySummaries(w.sent),
+		})
+		w.mu.Unlock()
+	}
+	return results
+}
+
+// runReplay implements `gopyter replay`, which handles the requests of a recording
+// again and reports the replies that differ.
+func runReplay(config KernelConfig, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "also list the requests replied to as recorded")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gopyter [-record replayed.jsonl] replay [-v] session.jsonl")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	msgs, err := readRecording(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	kernel, err := newKernel(config)
+	if err != nil {
+		return err
+	}
+	results := kernel.replay(msgs, messageLog)
+	differ := 0
+	for _, r := range results {
+		if !r.same() {
+			differ++
+		} else if !*verbose {
+			continue
+		}
+		fmt.Println(r)
+	}
+	if differ != 0 {
+		return fmt.Errorf("%d of %d requests were replied to differently", differ, len(results))
+	}
+	fmt.Printf("The %d requests were replied to as recorded.\n", len(results))
+	return nil
+}
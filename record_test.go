@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/wangfenjin/gopyter/wire"
+)
+
+// TestReplay tests recording the messages of the kernel and replaying them.
+func TestReplay(t *testing.T) {
+	defer func(count int) { ExecCounter = count }(ExecCounter)
+
+	var requests []recordedMsg
+	for _, code := range []string{"x := 1", "x + 1"} {
+		msg, err := NewMsg("execute_request", ComposedMsg{})
+		if err != nil {
+			t.Fatalf("\t%s Could not create a request: %v.", failure, err)
+		}
+		msg.Content = map[string]interface{}{"code": code, "silent": false, "store_history": true}
+		parts, err := wire.Encode(msg, []byte("key"))
+		if err != nil {
+			t.Fatalf("\t%s Could not encode a request: %v.", failure, err)
+		}
+		frames := append([][]byte{[]byte("client"), []byte("<IDS|MSG>")}, parts...)
+		request, ok := decodeRecordedMsg("shell", "in", frames)
+		if !ok {
+			t.Fatalf("\t%s Could not decode the frames %q.", failure, frames)
+		}
+		requests = append(requests, request)
+	}
+
+	t.Logf("Should record the requests and the replies, without signatures.")
+
+	var buf bytes.Buffer
+	ExecCounter = 0
+	(&Kernel{NewSession(), defaultConfig()}).replay(requests, newMessageRecorder(&buf))
+	if strings.Contains(buf.String(), "client") {
+		t.Fatalf("\t%s Expected the identities to be left out, got %s.", failure, buf.String())
+	}
+	recording, err := readRecording(&buf)
+	if err != nil {
+		t.Fatalf("\t%s Could not read the recording: %v.", failure, err)
+	}
+	var types []string
+	for _, m := range recording {
+		types = append(types, m.Channel+" "+m.Direction+" "+m.header().MsgType)
+	}
+	for _, want := range []string{"shell in execute_request", "iopub out execute_result", "shell out execute_reply"} {
+		if !strings.Contains(strings.Join(types, "\n"), want) {
+			t.Fatalf("\t%s Expected %q to be recorded, got %q.", failure, want, types)
+		}
+	}
+	t.Logf("\t%s Recorded %d messages.", success, len(recording))
+
+	t.Logf("Should replay the recording with the same replies.")
+
+	ExecCounter = 0
+	results := (&Kernel{NewSession(), defaultConfig()}).replay(recording, nil)
+	if len(results) != 2 {
+		t.Fatalf("\t%s Expected 2 requests to be replayed, got %v.", failure, results)
+	}
+	for _, r := range results {
+		if !r.same() || len(r.Replayed) == 0 {
+			t.Fatalf("\t%s Expected the same replies, got %s.", failure, r)
+		}
+	}
+	t.Logf("\t%s Replayed the recording.", success)
+
+	t.Logf("Should report the replies that differ.")
+
+	for i, m := range recording {
+		if m.header().MsgType == "execute_result" {
+			recording[i].Content = json.RawMessage(strings.Replace(string(m.Content), `"2"`, `"3"`, 1))
+		}
+	}
+	ExecCounter = 0
+	results = (&Kernel{NewSession(), defaultConfig()}).replay(recording, nil)
+	if !results[0].same() || results[1].same() || !strings.Contains(results[1].String(), "\n+ iopub execute_result") {
+		t.Fatalf("\t%s Expected the execute_result to differ, got %s and %s.", failure, results[0], results[1])
+	}
+	t.Logf("\t%s Reported %s.", success, results[1])
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+)
+
+func init() {
+	cellMagics["init"] = evalInitMagic
+	documentMagic(initSyntax)
+}
+
+var initSyntax = &magicSyntax{
+	name: "%%init",
+	doc:  "Evaluates the cell and makes it the init cell, which is replayed when the interpreter is restarted after a crash.",
+}
+
+// kernelCrash is a panic that escaped the evaluation of a cell or the handling of a
+// message. Unlike the errors of user code, it means the kernel's own state can no
+// longer be trusted.
+type kernelCrash struct {
+	value interface{}
+	stack []byte
+}
+
+func (crash *kernelCrash) Error() string {
+	return fmt.Sprint(crash.value)
+}
+
+// recoverCrash recovers from a crash while handling the message of receipt. It must be
+// deferred. The crash is reported to the front-end as an error, and the interpreter is
+// replaced with a fresh one in which the init cell is replayed, so the kernel process and
+// its connections stay alive.
+func (kernel *Kernel) recoverCrash(receipt msgReceipt) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	crash, ok := r.(*kernelCrash)
+	if !ok {
+		crash = &kernelCrash{r, debug.Stack()}
+	}
+
+	msgType := receipt.Msg.Header.MsgType
+	log.Printf("Recovered from a crash handling %s: %v\n%s", msgType, crash.value, crash.stack)
+
+	evalue := kernel.session.redactor.redact(crash.Error())
+	traceback := append([]string{"KernelCrash: " + evalue}, strings.Split(strings.TrimSpace(string(crash.stack)), "\n")...)
+	if err := receipt.Publish("error", map[string]interface{}{
+		"ename":     "KernelCrash",
+		"evalue":    evalue,
+		"traceback": traceback,
+	}); err != nil {
+		log.Printf("Error publishing the crash: %v\n", err)
+	}
+
+	if msgType == "execute_request" {
+		if err := receipt.Reply("execute_reply", map[string]interface{}{
+			"status":          "error",
+			"execution_count": ExecCounter,
+			"ename":           "KernelCrash",
+			"evalue":          evalue,
+			"traceback":       traceback,
+		}); err != nil {
+			log.Printf("Error replying to the crashed request: %v\n", err)
+		}
+	}
+
+	note := "The interpreter crashed and was restarted, variables were lost.\n"
+	if kernel.config.InitCell != "" {
+		note = "The interpreter crashed and was restarted, replaying the init cell.\n"
+	}
+	receipt.PublishWriteStream(StreamStderr, note)
+
+	jupyterStdErr := JupyterStreamWriter{StreamStderr, &receipt, nil, kernel.session.redactor, nil, ""}
+	err := kernel.restartSession(OutErr{&jupyterStdErr, &jupyterStdErr})
+	jupyterStdErr.flush()
+	if err != nil {
+		receipt.PublishWriteStream(StreamStderr, fmt.Sprintf("Replaying the init cell failed: %v\n", err))
+	}
+}
+
+// restartSession replaces the interpreter with a fresh one and replays the init cell in
+// it, writing its output to outerr. The session keeps the state it holds outside of the
+// interpreter, like the history, the checkpoints, the secrets, the dependencies of the
+// cells, the watches and traces and the required packages. The Python interpreter of
+// the %%python cells is stopped, since its variables are lost like those of the cells.
+func (kernel *Kernel) restartSession(outerr OutErr) error {
+	old := kernel.session
+	if old.python != nil {
+		old.python.stop()
+	}
+	session := new(Session)
+	*session = *old
+	session.resetInterpreter()
+	sourceLock.Lock()
+	kernel.session = session
+	sourceLock.Unlock()
+
+	if kernel.config.InitCell == "" {
+		return nil
+	}
+	_, err := kernel.doEvalGop(outerr, kernel.config.InitCell)
+	return err
+}
+
+// evalInitMagic implements `%%init`, which evaluates the cell and designates it as the
+// init cell replayed when the interpreter is restarted after a crash. It replaces the
+// init_cell of the configuration.
+func evalInitMagic(kernel *Kernel, outerr OutErr, args string, body string) ([]interface{}, error) {
+	if _, err := initSyntax.parse(args); err != nil {
+		return nil, err
+	}
+	kernel.config.InitCell = body
+	return kernel.doEvalGop(outerr, body)
+}
+
+// evalRecovering evaluates code like doEvalGop, for callers that are not handling a
+// message. A crash is returned as an error after the interpreter has been restarted.
+func (kernel *Kernel) evalRecovering(outerr OutErr, code string) (vals []interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			crash, ok := r.(*kernelCrash)
+			if !ok {
+				panic(r)
+			}
+			log.Printf("Recovered from a crash: %v\n%s", crash.value, crash.stack)
+			vals, err = nil, crash
+			if restartErr := kernel.restartSession(outerr); restartErr != nil {
+				fmt.Fprintf(outerr.err, "Replaying the init cell failed: %v\n", restartErr)
+			}
+		}
+	}()
+	return kernel.doEvalGop(outerr, code)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-zeromq/zmq4"
+)
+
+// TestCrashRecovery tests that a crash restarts the interpreter and replays the init cell.
+func TestCrashRecovery(t *testing.T) {
+	cellMagics["test_crash"] = func(kernel *Kernel, outerr OutErr, args, body string) ([]interface{}, error) {
+		var m map[string]int
+		m["crash"] = 1
+		return nil, nil
+	}
+	defer delete(cellMagics, "test_crash")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+
+	if _, err := kernel.evalRecovering(outerr, "%%init\ny := 2"); err != nil {
+		t.Fatalf("\t%s Evaluating the init cell failed: %v.", failure, err)
+	}
+	if _, err := kernel.evalRecovering(outerr, "x := 1"); err != nil {
+		t.Fatalf("\t%s Evaluating a cell failed: %v.", failure, err)
+	}
+	kernel.session.history.record(1, "x := 1", nil)
+	kernel.session.deps.record(1, "", "x := 1")
+	for _, code := range []string{"%watch y", "%trace var x"} {
+		if _, err := kernel.evalRecovering(outerr, code); err != nil {
+			t.Fatalf("\t%s Evaluating %q failed: %v.", failure, code, err)
+		}
+	}
+	old := kernel.session
+
+	t.Logf("Should restart the interpreter after a crash.")
+
+	_, err := kernel.evalRecovering(outerr, "%%test_crash\n")
+	if _, ok := err.(*kernelCrash); !ok {
+		t.Fatalf("\t%s Expected a crash, got %v.", failure, err)
+	}
+
+	if _, err := kernel.session.Peek("x"); err == nil {
+		t.Fatalf("\t%s Expected x to be lost with the crashed interpreter.", failure)
+	}
+	if vals, err := kernel.session.Peek("y"); err != nil || len(vals) != 1 || vals[0] != 2 {
+		t.Fatalf("\t%s Expected y to be set by the init cell, got %v, %v.", failure, vals, err)
+	}
+	if kernel.session.history.In[1] != "x := 1" {
+		t.Fatalf("\t%s Expected the history to be kept.", failure)
+	}
+	t.Logf("\t%s Restarted the interpreter and replayed the init cell.", success)
+
+	t.Logf("Should keep the state held outside of the interpreter.")
+
+	s := kernel.session
+	if s == old || s.deps != old.deps || len(s.deps.cells) != 1 || s.watches != old.watches || s.traces != old.traces {
+		t.Fatalf("\t%s Expected the dependencies, watches and traces to be kept.", failure)
+	}
+	t.Logf("\t%s Kept them.", success)
+}
+
+// TestCrashRecoveryRestoresStreams tests that a crash escaping an execute_request leaves
+// the standard streams of the kernel restored and no cell running.
+func TestCrashRecoveryRestoresStreams(t *testing.T) {
+	cellMagics["test_crash"] = func(kernel *Kernel, outerr OutErr, args, body string) ([]interface{}, error) {
+		var m map[string]int
+		m["crash"] = 1
+		return nil, nil
+	}
+	defer delete(cellMagics, "test_crash")
+
+	connInfo := testConnectionInfo(t, "")
+	sockets, err := prepareSockets(connInfo)
+	if err != nil {
+		t.Fatalf("\t%s Could not listen: %v.", failure, err)
+	}
+	defer func() {
+		for _, s := range []Socket{sockets.ShellSocket, sockets.ControlSocket, sockets.StdinSocket, sockets.IOPubSocket, sockets.HBSocket} {
+			s.Socket.Close()
+		}
+	}()
+
+	// The front-end, to which the kernel sends the reply to the crashed request.
+	frontend := zmq4.NewDealer(context.Background(), zmq4.WithID(zmq4.SocketIdentity("frontend")))
+	defer frontend.Close()
+	if err := frontend.Dial(fmt.Sprintf("tcp://127.0.0.1:%d", connInfo.ShellPort)); err != nil {
+		t.Fatalf("\t%s Could not connect: %v.", failure, err)
+	}
+	if err := frontend.Send(zmq4.NewMsgString("hello")); err != nil {
+		t.Fatalf("\t%s Could not send: %v.", failure, err)
+	}
+	if _, err := sockets.ShellSocket.Socket.Recv(); err != nil {
+		t.Fatalf("\t%s Could not receive: %v.", failure, err)
+	}
+	go func() {
+		for {
+			if _, err := frontend.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+
+	receipt := msgReceipt{
+		Msg:        ComposedMsg{Header: MsgHeader{MsgID: "crash", MsgType: "execute_request"}, Content: map[string]interface{}{"code": "%%test_crash\n"}},
+		Identities: [][]byte{[]byte("frontend")},
+		Sockets:    sockets,
+	}
+	kernel := Kernel{NewSession(), defaultConfig()}
+	stdout, stderr := os.Stdout, os.Stderr
+
+	t.Logf("Should restore the streams and the running flag after a crash.")
+
+	func() {
+		defer kernel.recoverCrash(receipt)
+		kernel.handleExecuteRequest(receipt)
+	}()
+	if os.Stdout != stdout || os.Stderr != stderr {
+		t.Fatalf("\t%s Expected the standard streams to be restored.", failure)
+	}
+	interrupts.Lock()
+	running := interrupts.running
+	interrupts.Unlock()
+	if running {
+		t.Fatalf("\t%s Expected no cell to be running.", failure)
+	}
+	t.Logf("\t%s Restored them.", success)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+func init() {
+	lineMagics["mask"] = evalMaskMagic
+	documentMagic(maskSyntax)
+}
+
+var maskSyntax = &magicSyntax{
+	name:  "%mask",
+	usage: []string{"add <pattern>", "remove <pattern>", "list", "clear"},
+	doc:   "Manages the regular expressions redacted from everything the kernel publishes.",
+	args: []magicParam{
+		{name: "command", help: "`add`, `remove`, `list` or `clear`"},
+		{name: "pattern", help: "a regular expression, taken as written up to the end of the line", optional: true, rest: true},
+	},
+}
+
+// redactionMask replaces text removed by the redactor.
+const redactionMask = "********"
+
+// redactor removes sensitive text, such as tokens and personal data, from everything
+// the kernel publishes: stream output, results, errors and the history. It applies
+// the regular expressions added by the configuration or `%mask add`, followed by the
+// redaction functions registered by other parts of the kernel.
+type redactor struct {
+	mu        sync.Mutex
+	patterns  []*regexp.Regexp
+	funcs     []func(string) string
+	matchLens []func() int // the length of the longest text each of funcs replaces
+}
+
+// maxHeldBytes bounds the output streams hold back in case it is the start of text to
+// redact.
+const maxHeldBytes = 64 << 10
+
+func newRedactor() *redactor {
+	return &redactor{}
+}
+
+// addPattern adds a regular expression whose matches are redacted.
+func (r *redactor) addPattern(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.patterns {
+		if p.String() == expr {
+			return nil
+		}
+	}
+	r.patterns = append(r.patterns, re)
+	return nil
+}
+
+// removePattern removes a regular expression added with addPattern. It reports
+// whether the expression was found.
+func (r *redactor) removePattern(expr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.patterns {
+		if p.String() == expr {
+			r.patterns = append(r.patterns[:i], r.patterns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// addFunc registers a function redacting text.
+func (r *redactor) addFunc(f func(string) string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.funcs = append(r.funcs, f)
+}
+
+// addMatchLen registers a function returning the length of the longest text a function
+// added with addFunc replaces, so that streams hold back output that may start it.
+func (r *redactor) addMatchLen(f func() int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.matchLens = append(r.matchLens, f)
+}
+
+// split returns where to cut text written to an output stream, so that the part before
+// the cut can be redacted and published, while the rest, which may be the start of text
+// to redact, is held until more output is written: the text a redaction function may
+// replace once it is complete and, if there are patterns, the last line. No more than
+// maxHeldBytes are held.
+func (r *redactor) split(text string) int {
+	r.mu.Lock()
+	longest := 0
+	for _, f := range r.matchLens {
+		if l := f(); l > longest {
+			longest = l
+		}
+	}
+	patterns := append([]*regexp.Regexp(nil), r.patterns...)
+	r.mu.Unlock()
+
+	held := 0
+	if longest > 1 {
+		held = longest - 1
+	}
+	if len(patterns) != 0 {
+		if l := len(text) - strings.LastIndexByte(text, '\n') - 1; l > held {
+			held = l
+		}
+	}
+	if held > maxHeldBytes {
+		held = maxHeldBytes
+	}
+	cut := len(text) - held
+	if cut <= 0 {
+		return 0
+	}
+
+	// Cut before the matches of patterns the cut falls in.
+	for moved := true; moved; {
+		moved = false
+		for _, p := range patterns {
+			for _, m := range p.FindAllStringIndex(text, -1) {
+				if m[0] < cut && cut < m[1] {
+					cut, moved = m[0], true
+				}
+			}
+		}
+	}
+	// Cut before the text the redaction functions replace across the cut.
+	whole := r.redact(text)
+	for back := 0; cut > 0 && back <= longest; back, cut = back+1, cut-1 {
+		if r.redact(text[:cut])+r.redact(text[cut:]) == whole {
+			return cut
+		}
+	}
+	if cut = len(text) - maxHeldBytes; cut < 0 {
+		cut = 0
+	}
+	return cut
+}
+
+// redact returns s with its sensitive parts replaced.
+func (r *redactor) redact(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.patterns {
+		s = p.ReplaceAllLiteralString(s, redactionMask)
+	}
+	for _, f := range r.funcs {
+		s = f(s)
+	}
+	return s
+}
+
+// redactData redacts the textual representations of data.
+func (r *redactor) redactData(data Data) Data {
+	for mimeType, value := range data.Data {
+		if s, ok := value.(string); ok {
+			data.Data[mimeType] = r.redact(s)
+		}
+	}
+	return data
+}
+
+// evalMaskMagic implements
+//
+//	%mask add <pattern>
+//	%mask remove <pattern>
+//	%mask list
+//	%mask clear
+//
+// which manages the regular expressions redacted from the output of the kernel.
+func evalMaskMagic(kernel *Kernel, outerr OutErr, args string) error {
+	r := kernel.session.redactor
+	parsed, err := maskSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	cmd, pattern := parsed.arg(0), parsed.arg(1)
+	switch cmd {
+	case "add":
+		if pattern == "" {
+			return fmt.Errorf("%%mask add: missing pattern")
+		}
+		if err := r.addPattern(pattern); err != nil {
+			return fmt.Errorf("%%mask add: %v", err)
+		}
+	case "remove":
+		if !r.removePattern(pattern) {
+			return fmt.Errorf("%%mask remove: no pattern %q", pattern)
+		}
+	case "list":
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, p := range r.patterns {
+			fmt.Fprintln(outerr.out, p.String())
+		}
+	case "clear":
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.patterns = nil
+	default:
+		return maskSyntax.errorf("expected add, remove, list or clear")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestRedactor tests that patterns and functions are applied to published output.
+func TestRedactor(t *testing.T) {
+	r := newRedactor()
+	if err := r.addPattern(`ghp_[A-Za-z0-9]+`); err != nil {
+		t.Fatalf("\t%s Adding a pattern failed: %v.", failure, err)
+	}
+	if err := r.addPattern(`[a-z]+@example\.com`); err != nil {
+		t.Fatalf("\t%s Adding a pattern failed: %v.", failure, err)
+	}
+	if err := r.addPattern(`(`); err == nil {
+		t.Fatalf("\t%s Expected an error for an invalid pattern.", failure)
+	}
+	r.addFunc(func(s string) string {
+		if s == "hidden" {
+			return redactionMask
+		}
+		return s
+	})
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{"token ghp_abc123 used", "token ******** used"},
+		{"mail bob@example.com", "mail ********"},
+		{"hidden", "********"},
+		{"nothing to hide", "nothing to hide"},
+	}
+
+	t.Logf("Should redact the sensitive parts of output.")
+
+	for k, tc := range cases {
+		t.Logf("  Redacting output %d/%d.", k+1, len(cases))
+
+		if got := r.redact(tc.Input); got != tc.Output {
+			t.Errorf("\t%s redact(%q) = %q, expected %q.", failure, tc.Input, got, tc.Output)
+			continue
+		}
+		t.Logf("\t%s Returned the expected output.", success)
+	}
+
+	data := r.redactData(MakeData(MIMETypeText, "ghp_x"))
+	if data.Data[MIMETypeText] != redactionMask {
+		t.Errorf("\t%s Unexpected redacted data %v.", failure, data.Data)
+	}
+
+	if !r.removePattern(`ghp_[A-Za-z0-9]+`) || r.redact("ghp_abc") != "ghp_abc" {
+		t.Errorf("\t%s Removing a pattern had no effect.", failure)
+	}
+}
+
+// TestStreamRedaction tests that secrets and patterns split across writes to an output
+// stream are redacted.
+func TestStreamRedaction(t *testing.T) {
+	store := &secretStore{revealed: map[string]bool{"abcdefgh": true}}
+	r := newRedactor()
+	r.addFunc(store.mask)
+	r.addMatchLen(store.longest)
+	if err := r.addPattern(`ghp_[A-Za-z0-9]+`); err != nil {
+		t.Fatalf("\t%s Adding a pattern failed: %v.", failure, err)
+	}
+
+	cases := []struct {
+		Writes []string
+		Output string
+	}{
+		{[]string{"xx abcd", "efgh yy"}, "xx ******** yy"},
+		{[]string{"a", "bcdefg", "h\n"}, "********\n"},
+		{[]string{"token ghp_", "abc", "123 used\n", "done"}, "token ******** used\ndone"},
+		{[]string{"abc"}, "abc"},
+	}
+
+	t.Logf("Should redact the text split across writes.")
+
+	for k, tc := range cases {
+		t.Logf("  Writing output %d/%d.", k+1, len(cases))
+
+		var messages []string
+		throttle := newIOPubThrottle(1<<20, func(stream, text string) error {
+			messages = append(messages, text)
+			return nil
+		})
+		writer := JupyterStreamWriter{StreamStdout, nil, nil, r, throttle, ""}
+		for _, text := range tc.Writes {
+			if n, err := writer.Write([]byte(text)); err != nil || n != len(text) {
+				t.Fatalf("\t%s Write(%q) = %d, %v.", failure, text, n, err)
+			}
+		}
+		if err := writer.flush(); err != nil {
+			t.Fatalf("\t%s Flushing failed: %v.", failure, err)
+		}
+		throttle.finish()
+
+		if got := strings.Join(messages, ""); got != tc.Output {
+			t.Errorf("\t%s Published %q, expected %q.", failure, got, tc.Output)
+			continue
+		}
+		t.Logf("\t%s Published the expected output.", success)
+	}
+
+	t.Logf("Should hold nothing back when there is nothing to redact.")
+
+	if cut := newRedactor().split("abc"); cut != 3 {
+		t.Fatalf("\t%s Expected to publish all the output, cut at %d.", failure, cut)
+	}
+	t.Logf("\t%s Held nothing.", success)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/go-zeromq/zmq4"
+	"golang.org/x/xerrors"
+)
+
+// Supervisors like Enterprise Gateway may rotate the ports and the key of a running
+// kernel: they rewrite its connection file and send it SIGHUP. The kernel then binds
+// its sockets to the new ports and signs its messages with the new key, keeping the
+// session. The messages of cells running meanwhile go to the new sockets, since the
+// sockets of the kernel forward to whichever zmq socket is bound at the time.
+
+// rebindableSocket is a zmq socket that can be bound to another endpoint.
+type rebindableSocket struct {
+	mu       sync.RWMutex
+	socket   zmq4.Socket
+	endpoint string
+	create   func() zmq4.Socket // creates the zmq sockets bound
+
+	// With -record, the messages received and sent on the channel are recorded.
+	channel  string
+	recorder *messageRecorder
+}
+
+// newRebindableSocket returns a socket forwarding to the sockets created by create.
+func newRebindableSocket(create func() zmq4.Socket) *rebindableSocket {
+	return &rebindableSocket{socket: create(), create: create}
+}
+
+// current returns the zmq socket currently bound.
+func (r *rebindableSocket) current() zmq4.Socket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.socket
+}
+
+// bind returns a new zmq socket listening on endpoint, to be swapped in, or nil if the
+// socket already listens there. The socket keeps forwarding to the zmq socket bound to
+// the previous endpoint until then.
+func (r *rebindableSocket) bind(endpoint string) (zmq4.Socket, error) {
+	r.mu.RLock()
+	same := endpoint == r.endpoint
+	r.mu.RUnlock()
+	if same {
+		return nil, nil
+	}
+	socket := r.create()
+	if err := socket.Listen(endpoint); err != nil {
+		socket.Close()
+		return nil, err
+	}
+	return socket, nil
+}
+
+// swap makes the socket forward to socket, bound to endpoint, and closes the zmq socket
+// bound before.
+func (r *rebindableSocket) swap(socket zmq4.Socket, endpoint string) {
+	r.mu.Lock()
+	old := r.socket
+	r.socket, r.endpoint = socket, endpoint
+	r.mu.Unlock()
+	// Recv moves on to the new socket once the old one is closed.
+	old.Close()
+}
+
+func (r *rebindableSocket) Close() error {
+	return r.current().Close()
+}
+
+func (r *rebindableSocket) Send(msg zmq4.Msg) error {
+	return r.current().Send(msg)
+}
+
+func (r *rebindableSocket) SendMulti(msg zmq4.Msg) error {
+	if err := r.current().SendMulti(msg); err != nil {
+		return err
+	}
+	r.recorder.record(r.channel, "out", msg.Frames)
+	return nil
+}
+
+// Recv receives a message. Receiving from a socket fails when it is closed, so if it
+// was rebound meanwhile, Recv receives from the new socket instead.
+func (r *rebindableSocket) Recv() (zmq4.Msg, error) {
+	for {
+		socket := r.current()
+		msg, err := socket.Recv()
+		// rebind holds the lock until the new socket is bound.
+		if err != nil && r.current() != socket {
+			continue
+		}
+		if err == nil {
+			r.recorder.record(r.channel, "in", msg.Frames)
+		}
+		return msg, err
+	}
+}
+
+func (r *rebindableSocket) Listen(endpoint string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.socket.Listen(endpoint); err != nil {
+		return err
+	}
+	r.endpoint = endpoint
+	return nil
+}
+
+func (r *rebindableSocket) Dial(endpoint string) error {
+	return r.current().Dial(endpoint)
+}
+
+func (r *rebindableSocket) Type() zmq4.SocketType {
+	return r.current().Type()
+}
+
+func (r *rebindableSocket) Addr() net.Addr {
+	return r.current().Addr()
+}
+
+func (r *rebindableSocket) GetOption(name string) (interface{}, error) {
+	return r.current().GetOption(name)
+}
+
+func (r *rebindableSocket) SetOption(name string, value interface{}) error {
+	return r.current().SetOption(name, value)
+}
+
+// signingKey is the key messages are signed with, which changes when the connection
+// file is reloaded.
+type signingKey struct {
+	mu  sync.RWMutex
+	key []byte
+}
+
+func (k *signingKey) get() []byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.key
+}
+
+func (k *signingKey) set(key []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.key = key
+}
+
+// connectionFilePath is the resolved path of the connection file of the kernel, which holds
+// the key signing its messages. The file server and the file comm never expose it.
+var connectionFilePath string
+
+// isConnectionFile reports whether path is the connection file of the kernel.
+func isConnectionFile(path string) bool {
+	resolved, err := resolvePath(path)
+	return err == nil && connectionFilePath != "" && resolved == connectionFilePath
+}
+
+// readConnectionInfo reads the connection file at path.
+func readConnectionInfo(path string) (ConnectionInfo, error) {
+	var connInfo ConnectionInfo
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return connInfo, err
+	}
+	if err := json.Unmarshal(data, &connInfo); err != nil {
+		return connInfo, xerrors.Errorf("could not parse connection file %s: %w", path, err)
+	}
+	return connInfo, nil
+}
+
+// rebind binds the sockets to the ports of connInfo and signs messages with its key. The
+// new ports are all bound before any socket moves to them, so that if one cannot be
+// bound, the sockets keep their ports and the key is kept. Once they are, the sockets
+// and the key change together.
+func (sg SocketGroup) rebind(connInfo ConnectionInfo) error {
+	address := fmt.Sprintf("%v://%v:%%v", connInfo.Transport, connInfo.IP)
+	sockets := []struct {
+		name   string
+		socket Socket
+		port   int
+	}{
+		{"shell", sg.ShellSocket, connInfo.ShellPort},
+		{"control", sg.ControlSocket, connInfo.ControlPort},
+		{"stdin", sg.StdinSocket, connInfo.StdinPort},
+		{"iopub", sg.IOPubSocket, connInfo.IOPubPort},
+		{"hbeat", sg.HBSocket, connInfo.HBPort},
+	}
+	rebindables := make([]*rebindableSocket, len(sockets))
+	bound := make([]zmq4.Socket, len(sockets))
+	rollback := func() {
+		for _, socket := range bound {
+			if socket != nil {
+				socket.Close()
+			}
+		}
+	}
+	for i, s := range sockets {
+		r, ok := s.socket.Socket.(*rebindableSocket)
+		if !ok {
+			rollback()
+			return xerrors.Errorf("the %s-socket cannot be rebound", s.name)
+		}
+		socket, err := r.bind(fmt.Sprintf(address, s.port))
+		if err != nil {
+			rollback()
+			return xerrors.Errorf("could not listen on %s-socket: %w", s.name, err)
+		}
+		rebindables[i], bound[i] = r, socket
+	}
+
+	// Messages are not signed with the new key until all the sockets moved.
+	sg.Key.mu.Lock()
+	defer sg.Key.mu.Unlock()
+	for i, r := range rebindables {
+		if bound[i] != nil {
+			r.swap(bound[i], fmt.Sprintf(address, sockets[i].port))
+		}
+	}
+	sg.Key.key = []byte(connInfo.Key)
+	return nil
+}
+
+// reloadOnHangup rebinds the sockets to the connection file at path whenever the
+// kernel receives SIGHUP.
+func (sg SocketGroup) reloadOnHangup(path string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			connInfo, err := readConnectionInfo(path)
+			if err == nil {
+				err = sg.rebind(connInfo)
+			}
+			if err != nil {
+				log.Printf("Could not reload the connection file %s: %v\n", path, err)
+				continue
+			}
+			log.Printf("Reloaded the connection file %s\n", path)
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+)
+
+// freePorts returns n TCP ports nothing listens on.
+func freePorts(t *testing.T, n int) []int {
+	var ports []int
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("\t%s Could not find a free port: %v.", failure, err)
+		}
+		defer l.Close()
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports
+}
+
+// testConnectionInfo returns the connection info of a kernel listening on free ports.
+func testConnectionInfo(t *testing.T, key string) ConnectionInfo {
+	ports := freePorts(t, 5)
+	return ConnectionInfo{
+		SignatureScheme: "hmac-sha256",
+		Transport:       "tcp",
+		IP:              "127.0.0.1",
+		ShellPort:       ports[0],
+		ControlPort:     ports[1],
+		StdinPort:       ports[2],
+		IOPubPort:       ports[3],
+		HBPort:          ports[4],
+		Key:             key,
+	}
+}
+
+// TestRebind tests rebinding the sockets of the kernel to new ports and keys.
+func TestRebind(t *testing.T) {
+	first := testConnectionInfo(t, "first")
+	sockets, err := prepareSockets(first)
+	if err != nil {
+		t.Fatalf("\t%s Could not listen: %v.", failure, err)
+	}
+	defer func() {
+		for _, s := range []Socket{sockets.ShellSocket, sockets.ControlSocket, sockets.StdinSocket, sockets.IOPubSocket, sockets.HBSocket} {
+			s.Socket.Close()
+		}
+	}()
+	received := make(chan error, 1)
+	go func() {
+		_, err := sockets.ShellSocket.Socket.Recv()
+		received <- err
+	}()
+
+	t.Logf("Should receive messages on the new ports, signed with the new key.")
+
+	second := testConnectionInfo(t, "second")
+	if err := sockets.rebind(second); err != nil {
+		t.Fatalf("\t%s Could not rebind the sockets: %v.", failure, err)
+	}
+	if key := string(sockets.Key.get()); key != "second" {
+		t.Fatalf("\t%s Expected the new key, got %q.", failure, key)
+	}
+	dealer := zmq4.NewDealer(context.Background())
+	defer dealer.Close()
+	if err := dealer.Dial(fmt.Sprintf("tcp://127.0.0.1:%d", second.ShellPort)); err != nil {
+		t.Fatalf("\t%s Could not connect to the new shell port: %v.", failure, err)
+	}
+	if err := dealer.Send(zmq4.NewMsgString("hello")); err != nil {
+		t.Fatalf("\t%s Could not send a message: %v.", failure, err)
+	}
+	select {
+	case err := <-received:
+		if err != nil {
+			t.Fatalf("\t%s Expected the message, got %v.", failure, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("\t%s Expected the message on the new port.", failure)
+	}
+	t.Logf("\t%s Received them.", success)
+
+	t.Logf("Should keep all the ports and the key when one of the ports cannot be bound.")
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("\t%s Could not listen: %v.", failure, err)
+	}
+	defer busy.Close()
+	third := testConnectionInfo(t, "third")
+	third.HBPort = busy.Addr().(*net.TCPAddr).Port
+	if err := sockets.rebind(third); err == nil || !strings.Contains(err.Error(), "hbeat-socket") {
+		t.Fatalf("\t%s Expected the heartbeat port to be busy, got %v.", failure, err)
+	}
+	if addr := sockets.ShellSocket.Socket.Addr().String(); addr != fmt.Sprintf("127.0.0.1:%d", second.ShellPort) {
+		t.Fatalf("\t%s Expected the shell socket to listen on its port, got %s.", failure, addr)
+	}
+	if key := string(sockets.Key.get()); key != "second" {
+		t.Fatalf("\t%s Expected the key to be kept, got %q.", failure, key)
+	}
+	// The ports bound for the other sockets were released.
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", third.ShellPort))
+	if err != nil {
+		t.Fatalf("\t%s Expected the new shell port to be released: %v.", failure, err)
+	}
+	listener.Close()
+	t.Logf("\t%s Kept them.", success)
+}
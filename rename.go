@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	gotoken "go/token"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/token"
+)
+
+// renamedCell is the code of an executed cell after a rename, which the front-end
+// puts in the cell.
+type renamedCell struct {
+	Count  int    `json:"execution_count"`
+	CellID string `json:"cell_id,omitempty"`
+	Code   string `json:"code"`
+}
+
+// renameReply answers a rename request sent on a structure comm, which renames the
+// name declared by the session in "name" to "new_name". The reply {"rename": ...} lists
+// in "cells" the new code of the executed cells referring to it.
+func (kernel *Kernel) renameReply(data map[string]interface{}) (map[string]interface{}, error) {
+	name, _ := data["name"].(string)
+	newName, _ := data["new_name"].(string)
+	cells, err := kernel.session.rename(name, newName)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"rename": map[string]interface{}{"name": name, "new_name": newName, "cells": cells}}, nil
+}
+
+// rename renames the variable, function or type called name, declared by the session,
+// to newName, in the source of the session, so that later cells use the new name and
+// the variable keeps its value, and in the executed cells, whose new code it returns.
+// It fails if newName is taken or the session no longer compiles.
+func (s *Session) rename(name, newName string) ([]renamedCell, error) {
+	if !gotoken.IsIdentifier(newName) {
+		return nil, fmt.Errorf("%q is not a valid name", newName)
+	}
+	if name == newName {
+		return nil, fmt.Errorf("%s already has that name", name)
+	}
+	if s.src == "" {
+		return nil, fmt.Errorf("%s is not declared by the session", name)
+	}
+	// The source is renamed as it was compiled, so that its bytecode does not change.
+	session, err := parseCell(s.compiledSource())
+	if err != nil {
+		return nil, err
+	}
+	declared := map[string]bool{}
+	session.topLevelIdents(func(ident *ast.Ident) { declared[ident.Name] = true })
+	if prelude, err := parseCell(sessionPrelude); err == nil {
+		// The variables of the kernel, like In and Out.
+		prelude.topLevelIdents(func(ident *ast.Ident) { delete(declared, ident.Name) })
+	}
+	if !declared[name] {
+		return nil, fmt.Errorf("%s is not declared by the session", name)
+	}
+	// The packages of the session are reserved for the cells referring to them.
+	_, imports := s.declaredNames()
+	for _, p := range sessionPackages {
+		imports[p] = true
+	}
+	for p := range imports {
+		if path.Base(p) == newName {
+			declared[newName] = true
+		}
+	}
+	if declared[newName] {
+		return nil, fmt.Errorf("%s is already declared", newName)
+	}
+
+	// The statements still start with the prelude, whose names are not renamed.
+	renamed := session.renameIdents(name, newName)[len(s.imports):]
+	i := strings.Index(renamed, sessionPrelude)
+	if i < 0 {
+		return nil, fmt.Errorf("could not find the statements of the session")
+	}
+	decls, src := renamed[:i], renamed[i:]
+	if _, err := s.compile(s.imports + decls + src); err != nil {
+		return nil, fmt.Errorf("renaming %s to %s breaks the session: %v", name, newName, err)
+	}
+	sourceLock.Lock()
+	s.decls, s.src = decls, src
+	sourceLock.Unlock()
+
+	cells := []renamedCell{}
+	for _, cell := range s.deps.cells {
+		c, err := parseCell(cell.code)
+		if err != nil {
+			continue
+		}
+		if code := c.renameIdents(name, newName); code != cell.code {
+			cell.code = code
+			cells = append(cells, renamedCell{Count: cell.Count, CellID: cell.CellID, Code: code})
+		}
+		cell.Reads, cell.Writes = renameIn(cell.Reads, name, newName), renameIn(cell.Writes, name, newName)
+		if version, ok := cell.readVersions[name]; ok {
+			delete(cell.readVersions, name)
+			cell.readVersions[newName] = version
+		}
+	}
+	if version, ok := s.deps.versions[name]; ok {
+		delete(s.deps.versions, name)
+		s.deps.versions[newName] = version
+	}
+	return cells, nil
+}
+
+// renameIn returns the sorted names with name renamed to newName.
+func renameIn(names []string, name, newName string) []string {
+	for i := range names {
+		if names[i] == name {
+			names[i] = newName
+			sort.Strings(names)
+			break
+		}
+	}
+	return names
+}
+
+// renameIdents returns the code of the cell with the identifiers referring to the
+// top-level name renamed to newName.
+func (c *parsedCell) renameIdents(name, newName string) string {
+	var offsets []int
+	for _, ident := range c.topLevelRefs(name) {
+		offsets = append(offsets, c.offset(ident.Pos()))
+	}
+	code := c.code
+	sort.Sort(sort.Reverse(sort.IntSlice(offsets)))
+	for _, at := range offsets {
+		code = code[:at] + newName + code[at+len(name):]
+	}
+	return code
+}
+
+// topLevelRefs returns the identifiers of the cell referring to the top-level name, in
+// the order of the code. As Go+ cannot be type-checked, names are resolved
+// syntactically: fields, methods and labels are never references, nor are the names
+// within the scopes declaring a local name, which shadows the top-level one.
+func (c *parsedCell) topLevelRefs(name string) []*ast.Ident {
+	skipped := make(map[*ast.Ident]bool)
+	var shadowing []ast.Node
+	main := c.main()
+	declares := func(stmts ...ast.Stmt) bool {
+		for _, stmt := range stmts {
+			switch stmt := stmt.(type) {
+			case *ast.AssignStmt:
+				if stmt.Tok == token.DEFINE && hasIdent(name, stmt.Lhs...) {
+					return true
+				}
+			case *ast.DeclStmt:
+				if decl, ok := stmt.Decl.(*ast.GenDecl); ok {
+					for _, spec := range decl.Specs {
+						switch spec := spec.(type) {
+						case *ast.ValueSpec:
+							for _, ident := range spec.Names {
+								if ident.Name == name {
+									return true
+								}
+							}
+						case *ast.TypeSpec:
+							if spec.Name.Name == name {
+								return true
+							}
+						}
+					}
+				}
+			case *ast.RangeStmt:
+				if stmt.Tok == token.DEFINE && hasIdent(name, stmt.Key, stmt.Value) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	declaresParams := func(t *ast.FuncType) bool {
+		for _, list := range []*ast.FieldList{t.Params, t.Results} {
+			if list == nil {
+				continue
+			}
+			for _, field := range list.List {
+				for _, ident := range field.Names {
+					if ident.Name == name {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+	declaresPhrase := func(fors ...ast.ForPhrase) bool {
+		for _, f := range fors {
+			if (f.Key != nil && f.Key.Name == name) || (f.Value != nil && f.Value.Name == name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	inspectAST(c.file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			skipped[n.Sel] = true
+		case *ast.Field:
+			// Parameters, results and struct fields.
+			for _, ident := range n.Names {
+				skipped[ident] = true
+			}
+		case *ast.CompositeLit:
+			// The keys of struct literals are fields.
+			for _, elt := range n.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if ident, ok := kv.Key.(*ast.Ident); ok {
+						skipped[ident] = true
+					}
+				}
+			}
+		case *ast.LabeledStmt:
+			skipped[n.Label] = true
+		case *ast.BranchStmt:
+			if n.Label != nil {
+				skipped[n.Label] = true
+			}
+		case *ast.ImportSpec:
+			if n.Name != nil {
+				skipped[n.Name] = true
+			}
+		case *ast.FuncDecl:
+			if n.Recv != nil {
+				skipped[n.Name] = true
+			}
+			if n != main && n.Body != nil && declaresParams(n.Type) {
+				shadowing = append(shadowing, n.Type.Params, n.Body)
+			}
+		case *ast.FuncLit:
+			if declaresParams(n.Type) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.BlockStmt:
+			if (main == nil || n != main.Body) && declares(n.List...) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.CaseClause:
+			if declares(n.Body...) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.CommClause:
+			if declares(append([]ast.Stmt{n.Comm}, n.Body...)...) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.IfStmt:
+			if declares(n.Init) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.ForStmt:
+			if declares(n.Init) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.SwitchStmt:
+			if declares(n.Init) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.TypeSwitchStmt:
+			if declares(n.Init, n.Assign) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.RangeStmt:
+			if declares(n) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.ForPhraseStmt:
+			if declaresPhrase(n.ForPhrase) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.ListComprehensionExpr:
+			if declaresPhrase(n.Fors...) {
+				shadowing = append(shadowing, n)
+			}
+		case *ast.MapComprehensionExpr:
+			if declaresPhrase(n.Fors...) {
+				shadowing = append(shadowing, n)
+			}
+		}
+		return true
+	})
+
+	var refs []*ast.Ident
+	inspectAST(c.file, func(n ast.Node) bool {
+		for _, scope := range shadowing {
+			if scope.Pos() <= n.Pos() && n.End() <= scope.End() {
+				return false
+			}
+		}
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == name && !skipped[ident] {
+			refs = append(refs, ident)
+		}
+		return true
+	})
+	return refs
+}
+
+// hasIdent reports whether one of exprs is the identifier name.
+func hasIdent(name string, exprs ...ast.Expr) bool {
+	for _, x := range exprs {
+		if ident, ok := x.(*ast.Ident); ok && ident.Name == name {
+			return true
+		}
+	}
+	return false
+}
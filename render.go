@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/goplus/gop"
+)
+
+// renderPackage is the Go+ package letting cells customize how values are displayed.
+var renderPackage = gop.NewGoPackage("render")
+
+func init() {
+	renderPackage.RegisterFuncs(
+		renderPackage.Func("Register", registerRenderer, execRegisterRenderer),
+	)
+}
+
+// customRenderer renders the values it matches, in place of or before the built-in
+// rendering.
+type customRenderer struct {
+	typeName string                 // the type of the values to render, as printed by %T
+	match    func(interface{}) bool // matches the values to render, if typeName is empty
+	render   func(interface{}) interface{}
+	priority int
+}
+
+// customRenderers returns the renderers registered in the active session, from the
+// highest priority to the lowest. Among renderers of the same priority, the last
+// registered comes first. They are closures of the interpreter of the session, so each
+// session has its own, and they are dropped with the interpreter.
+func customRenderers() []*customRenderer {
+	if activeSession == nil {
+		return nil
+	}
+	return activeSession.renderers
+}
+
+// registerRenderer registers in the active session a renderer for the values matched by matcher: either the
+// name of their type, as printed by %T, e.g. "*orders.Order", or a func(interface{})
+// bool reporting whether a value matches. render returns how to display a value:
+//
+//   - a string of HTML,
+//   - a map from MIME types to data,
+//   - a Data,
+//   - nil to leave the value to the next renderer,
+//   - or any other value, which is displayed in its place.
+//
+// Renderers of a priority of 0 or more take precedence over the built-in rendering,
+// the others are only used for values the kernel does not render itself. Registering a
+// renderer for a type name replaces the renderer registered for it before.
+func registerRenderer(matcher interface{}, render func(interface{}) interface{}, priority int) error {
+	if render == nil {
+		return errors.New("render.Register: nil renderer")
+	}
+	r := &customRenderer{render: render, priority: priority}
+	switch matcher := matcher.(type) {
+	case string:
+		r.typeName = matcher
+	case func(interface{}) bool:
+		r.match = matcher
+	default:
+		return fmt.Errorf("render.Register: expected a type name or a func(interface{}) bool, got %T", matcher)
+	}
+	activeSession.addRenderer(r)
+	return nil
+}
+
+// addRenderer adds r to the renderers of the session, in the order of their priorities.
+func (s *Session) addRenderer(r *customRenderer) {
+	renderers := []*customRenderer{r}
+	for _, other := range s.renderers {
+		if r.typeName == "" || other.typeName != r.typeName {
+			renderers = append(renderers, other)
+		}
+	}
+	sort.SliceStable(renderers, func(i, j int) bool {
+		return renderers[i].priority > renderers[j].priority
+	})
+	s.renderers = renderers
+}
+
+func execRegisterRenderer(_ int, p *gop.Context) {
+	args := p.GetArgs(3)
+	render, _ := args[1].(func(interface{}) interface{})
+	if err := registerRenderer(args[0], render, args[2].(int)); err != nil {
+		panic(err)
+	}
+	p.Ret(3)
+}
+
+func (r *customRenderer) matches(v interface{}) bool {
+	if r.match != nil {
+		return r.match(v)
+	}
+	return fmt.Sprintf("%T", v) == r.typeName
+}
+
+// renderCustom renders v with the first matching renderer that does not decline it,
+// among those taking precedence over the built-in rendering if builtin is true, or the
+// others otherwise.
+func renderCustom(v interface{}, builtin bool) (Data, bool) {
+	for _, r := range customRenderers() {
+		if (r.priority >= 0) != builtin || !r.matches(v) {
+			continue
+		}
+		if d, ok := r.apply(v); ok {
+			return d, true
+		}
+	}
+	return Data{}, false
+}
+
+// apply renders v, recovering from the panics of the renderer.
+func (r *customRenderer) apply(v interface{}) (d Data, ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			d, ok = makeDataErr(fmt.Errorf("rendering %T: %v", v, err)), true
+		}
+	}()
+
+	switch result := r.render(v).(type) {
+	case nil:
+		return Data{}, false
+	case Data:
+		d = result
+	case string:
+		d.Data = MIMEMap{MIMETypeHTML: result}
+	case map[string]interface{}:
+		d = Data{Data: result}
+	case map[string]string:
+		d.Data = MIMEMap{}
+		for mimeType, data := range result {
+			d.Data[mimeType] = data
+		}
+	default:
+		// The value is displayed in place of v, but not with the custom renderers,
+		// which could render it back into v.
+		return renderBuiltin(result), true
+	}
+	if _, ok := d.Data[MIMETypeText]; !ok {
+		d.Data = ensure(d.Data)
+		d.Data[MIMETypeText] = fmt.Sprint(v)
+	}
+	return d, true
+}
+
+// hasCustomRenderer reports whether a registered renderer matches v.
+func hasCustomRenderer(v interface{}) bool {
+	for _, r := range customRenderers() {
+		if r.matches(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestRegisterRenderer tests customizing the display of values with render.Register.
+func TestRegisterRenderer(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	setup := `import "fmt"
+render.Register("int", func(v interface{}) interface{} { return "<b>" + fmt.Sprint(v) + "</b>" }, 0)
+render.Register("string", func(v interface{}) interface{} { return {"text/markdown": "*" + fmt.Sprint(v) + "*"} }, -1)
+render.Register("float64", func(v interface{}) interface{} { return nil }, 5)
+render.Register("*main.DownloadLink", func(v interface{}) interface{} { return "link" }, -1)`
+	if _, err := kernel.session.Eval(setup); err != nil {
+		t.Fatalf("\t%s Registering the renderers failed: %v.", failure, err)
+	}
+	if err := registerRenderer(func(v interface{}) bool { _, ok := v.(bool); return ok },
+		func(v interface{}) interface{} { return newLatexMath(fmt.Sprint(v)) }, 1); err != nil {
+		t.Fatalf("\t%s Registering a renderer from Go failed: %v.", failure, err)
+	}
+
+	cases := []struct {
+		code string
+		data MIMEMap
+	}{
+		{"42", MIMEMap{MIMETypeHTML: "<b>42</b>", MIMETypeText: "42"}},
+		{`"hi"`, MIMEMap{MIMETypeMarkdown: "*hi*", MIMETypeText: "hi"}},
+		// The renderer declines floats, and the one of DownloadLink comes after the
+		// built-in rendering.
+		{"1.5", MIMEMap{MIMETypeText: "1.5"}},
+		{"true", MIMEMap{MIMETypeLatex: "$$true$$", MIMETypeText: "true"}},
+	}
+
+	t.Logf("Should render values with the registered renderers in order of priority.")
+
+	for _, tc := range cases {
+		vals, err := kernel.session.Eval(tc.code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %s failed: %v.", failure, tc.code, err)
+		}
+		if data := kernel.autoRenderResults(vals).Data; !reflect.DeepEqual(data, tc.data) {
+			t.Fatalf("\t%s Expected %s to render as %v, got %v.", failure, tc.code, tc.data, data)
+		}
+		t.Logf("\t%s Rendered %s.", success, tc.code)
+	}
+	if html, _ := autoRender(newDownload("missing", "")).Data[MIMETypeHTML]; html == "link" {
+		t.Fatalf("\t%s Expected the built-in rendering to take precedence.", failure)
+	}
+
+	t.Logf("Should replace the renderer of a type.")
+
+	if _, err := kernel.session.Eval(`render.Register("int", func(v interface{}) interface{} { return "<i>int</i>" }, 0)`); err != nil {
+		t.Fatalf("\t%s Registering the renderer failed: %v.", failure, err)
+	}
+	if html := autoRender(7).Data[MIMETypeHTML]; html != "<i>int</i>" || len(kernel.session.renderers) != 5 {
+		t.Fatalf("\t%s Expected the new renderer to replace the old one, got %v with %d renderers.", failure, html, len(kernel.session.renderers))
+	}
+	t.Logf("\t%s Replaced the renderer.", success)
+
+	t.Logf("Should keep the renderers in the session registering them.")
+
+	other := Kernel{NewSession(), defaultConfig()}
+	if _, err := other.session.Eval("42"); err != nil {
+		t.Fatalf("\t%s Evaluating 42 failed: %v.", failure, err)
+	}
+	if html, ok := autoRender(42).Data[MIMETypeHTML]; ok {
+		t.Fatalf("\t%s Expected another session not to use the renderers, got %v.", failure, html)
+	}
+	if _, err := kernel.session.Eval("42"); err != nil {
+		t.Fatalf("\t%s Evaluating 42 failed: %v.", failure, err)
+	}
+	if html := autoRender(42).Data[MIMETypeHTML]; html != "<i>int</i>" {
+		t.Fatalf("\t%s Expected the session to keep its renderers, got %v.", failure, html)
+	}
+	kernel.session.resetInterpreter()
+	if len(kernel.session.renderers) != 0 {
+		t.Fatalf("\t%s Expected the renderers to be dropped with the interpreter, got %d.", failure, len(kernel.session.renderers))
+	}
+	t.Logf("\t%s Kept them in the session.", success)
+}
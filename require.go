@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"plugin"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	gopexec "github.com/goplus/gop/exec/bytecode"
+)
+
+func init() {
+	lineMagics["require"] = evalRequireMagic
+	documentMagic(requireSyntax)
+	lineMagics["requirements"] = evalRequirementsMagic
+	documentMagic(requirementsSyntax)
+}
+
+var requireSyntax = &magicSyntax{
+	name:  "%require",
+	usage: []string{"<package>@<version>"},
+	doc: "Downloads a Go package at a pinned version and makes it importable in the session. The version may " +
+		"be a semantic version, a commit or `latest`; `gopyter run` records the versions it resolved in the " +
+		"notebook and uses them on the next runs.",
+	args: []magicParam{{name: "package@version", help: "the package to require, e.g. github.com/google/uuid@v1.6.0"}},
+}
+
+var requirementsSyntax = &magicSyntax{
+	name:  "%requirements",
+	doc:   "Lists the packages required with %require in the session, at the versions they were resolved to.",
+	usage: []string{""},
+}
+
+// Go+ can only import the Go packages it has bindings for. %require generates the
+// bindings of a package with the qexp tool of Go+, builds them as a Go plugin and loads
+// it, which registers the package. Plugins must be built with the same versions of the
+// packages they share with the kernel, so they are built in a module requiring the
+// version of Go+ the kernel was built with, and packages changing the version of a
+// module of the kernel are refused. The plugins built are kept in the import cache,
+// keyed by the toolchain building them (see toolchain.go).
+
+// goCommand is the go tool run by %require and `gopyter prewarm`.
+var goCommand = "go"
+
+// requirementsMetadata is the key of the notebook metadata where `gopyter run` records
+// the versions of the required packages.
+const requirementsMetadata = "requirements"
+
+// requirement is a package required with %require.
+type requirement struct {
+	Package string // the path of the package
+	Module  string // the path of its module
+	Version string // the version of its module
+}
+
+// requirements are the packages required in a session.
+type requirements struct {
+	imports *importCache
+	pins    []requirement            // in the order they were required
+	locked  map[string]string        // the versions recorded in the notebook, by package
+	loads   map[string]time.Duration // the time loading their plugin took, by package
+}
+
+// sessionRequirements returns the requirements of the session, creating them if needed.
+func (kernel *Kernel) sessionRequirements() *requirements {
+	s := kernel.session
+	if s.requirements == nil {
+		sourceLock.Lock()
+		s.requirements = &requirements{imports: kernel.importCache(), loads: make(map[string]time.Duration)}
+		sourceLock.Unlock()
+	}
+	return s.requirements
+}
+
+// evalRequireMagic implements `%require <package>@<version>`, which makes a package
+// importable.
+func evalRequireMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := requireSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	pkg, version := splitPackageVersion(parsed.arg(0))
+	if pkg == "" || version == "" {
+		return requireSyntax.errorf("expected <package>@<version>, got %q", parsed.arg(0))
+	}
+	if sandboxed {
+		return errors.New("%require: not permitted in the sandbox")
+	}
+
+	r := kernel.sessionRequirements()
+	if locked, ok := r.locked[pkg]; ok && locked != version {
+		fmt.Fprintf(outerr.out, "Using %s %s, as recorded in the notebook.\n", pkg, locked)
+		version = locked
+	}
+	for _, pin := range r.pins {
+		if pin.Package != pkg {
+			continue
+		}
+		if pin.Version == version {
+			fmt.Fprintf(outerr.out, "%s %s is already required.\n", pkg, version)
+			return nil
+		}
+		// Plugins cannot be unloaded, and Go refuses to load a package twice.
+		return fmt.Errorf("%%require: %s is already required at %s; restart the kernel to change its version", pkg, pin.Version)
+	}
+	if gopexec.FindGoPackage(pkg) != nil {
+		return fmt.Errorf("%%require: %s can already be imported without %%require", pkg)
+	}
+
+	pin, ok := r.imports.lookup(outerr.out, pkg, version)
+	if !ok {
+		if pin, err = r.imports.build(outerr.out, pkg, version); err != nil {
+			return fmt.Errorf("%%require: %v", err)
+		}
+	}
+	start := time.Now()
+	if _, err := plugin.Open(r.imports.plugin(pin)); err != nil {
+		return fmt.Errorf("%%require: %v", err)
+	}
+	r.loads[pkg] = time.Since(start)
+	sourceLock.Lock()
+	r.pins = append(r.pins, pin)
+	sourceLock.Unlock()
+	fmt.Fprintf(outerr.out, "Required %s %s; import %q to use it.\n", pin.Module, pin.Version, pkg)
+	return nil
+}
+
+// splitPackageVersion splits path@version.
+func splitPackageVersion(arg string) (pkg, version string) {
+	i := strings.LastIndex(arg, "@")
+	if i < 0 {
+		return arg, ""
+	}
+	return arg[:i], arg[i+1:]
+}
+
+// importCache holds the plugins built for required packages, in a directory per package
+// and version, like the module cache, so they are only built once. Each has a directory
+// per toolchain, so kernels built differently share the cache without loading plugins
+// they cannot load.
+type importCache struct {
+	dir       string
+	toolchain *toolchain // the toolchain of the kernel
+
+	// offline prevents downloading packages: they are read from the vendor directory
+	// in vendor mode, and must be in the cache otherwise.
+	offline bool
+}
+
+// importCache returns the import cache of the kernel.
+func (kernel *Kernel) importCache() *importCache {
+	dir := kernel.config.ImportCache
+	if dir == "" {
+		if userDir, err := os.UserCacheDir(); err == nil {
+			dir = filepath.Join(userDir, "gopyter", "imports")
+		} else {
+			dir = filepath.Join(os.TempDir(), "gopyter-imports")
+		}
+	}
+	return &importCache{dir: dir, toolchain: kernelToolchain(), offline: kernel.config.Offline || os.Getenv("GOPROXY") == "off" || vendorMode()}
+}
+
+// vendorMode reports whether GOFLAGS makes the go tool read packages from the vendor
+// directory of the module.
+func vendorMode() bool {
+	for _, flag := range strings.Fields(os.Getenv("GOFLAGS")) {
+		if flag == "-mod=vendor" {
+			return true
+		}
+	}
+	return false
+}
+
+// entry returns the directory of the cache holding the plugin of pkg at version for the
+// toolchain of the kernel.
+func (c *importCache) entry(pkg, version string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(pkg)+"@"+version, c.toolchain.key())
+}
+
+// plugin returns the path of the plugin of pin.
+func (c *importCache) plugin(pin requirement) string {
+	return filepath.Join(c.entry(pin.Package, pin.Version), "bindings.so")
+}
+
+// lookup returns the requirement of pkg at version if the cache has a plugin of it the
+// kernel can load. Only exact versions are found, not queries like latest. It reports
+// the cached plugins the kernel cannot load to out, e.g. when the kernel was rebuilt
+// with other versions of its modules.
+func (c *importCache) lookup(out io.Writer, pkg, version string) (requirement, bool) {
+	var pin requirement
+	data, err := ioutil.ReadFile(filepath.Join(c.entry(pkg, version), "requirement.json"))
+	if err != nil || json.Unmarshal(data, &pin) != nil {
+		return pin, false
+	}
+	if _, err := os.Stat(c.plugin(pin)); err != nil {
+		return pin, false
+	}
+	if err := c.toolchain.check(c.plugin(pin)); err != nil {
+		fmt.Fprintf(out, "The cached plugin of %s %s cannot be loaded: %v.\n", pin.Module, pin.Version, err)
+		return pin, false
+	}
+	return pin, true
+}
+
+// cachedVersions returns the versions of pkg in the cache built for the toolchain of the
+// kernel.
+func (c *importCache) cachedVersions(pkg string) []string {
+	base := filepath.Base(filepath.FromSlash(pkg)) + "@"
+	dir := filepath.Join(c.dir, filepath.Dir(filepath.FromSlash(pkg)))
+	entries, _ := ioutil.ReadDir(dir)
+	var versions []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), base) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), c.toolchain.key())); err == nil {
+			versions = append(versions, strings.TrimPrefix(e.Name(), base))
+		}
+	}
+	return versions
+}
+
+// build downloads pkg at version, builds the plugin of its bindings and stores it in the
+// cache. It reports its progress to out.
+func (c *importCache) build(out io.Writer, pkg, version string) (requirement, error) {
+	vendor := vendorMode()
+	if c.offline && !vendor {
+		message := fmt.Sprintf("%s@%s is not in the import cache %s, and packages cannot be downloaded offline", pkg, version, c.dir)
+		if versions := c.cachedVersions(pkg); len(versions) != 0 {
+			message += "; it has " + strings.Join(versions, ", ")
+		}
+		return requirement{}, errors.New(message)
+	}
+	if c.toolchain.settings["CGO_ENABLED"] == "0" {
+		return requirement{}, errors.New("the kernel was built without cgo, so it cannot load plugins")
+	}
+	b, err := newBindingsModule(vendor)
+	if err != nil {
+		return requirement{}, err
+	}
+	defer os.RemoveAll(b.dir)
+
+	if vendor {
+		fmt.Fprintf(out, "Reading %s from the vendor directory...\n", pkg)
+	} else {
+		fmt.Fprintf(out, "Downloading %s@%s...\n", pkg, version)
+		if _, err := b.goTool("get", pkg+"@"+version); err != nil {
+			return requirement{}, err
+		}
+	}
+	resolved, err := b.goTool("list", "-f", "{{.Module.Path}} {{.Module.Version}}", pkg)
+	if err != nil {
+		return requirement{}, err
+	}
+	fields := strings.Fields(resolved)
+	if len(fields) != 2 {
+		return requirement{}, fmt.Errorf("%s is not in a versioned module", pkg)
+	}
+	pin := requirement{Package: pkg, Module: fields[0], Version: fields[1]}
+	if vendor && version != pin.Version && version != "latest" {
+		return requirement{}, fmt.Errorf("the vendor directory has %s %s, not %s", pin.Module, pin.Version, version)
+	}
+	if err := b.checkKernelVersions(pin); err != nil {
+		return requirement{}, err
+	}
+
+	fmt.Fprintf(out, "Building %s...\n", pkg)
+	lib := filepath.Join(b.dir, "lib")
+	// qexp reports the packages it could not export without failing.
+	_, problems, err := b.goToolOutput("run", "github.com/goplus/gop/cmd/qexp", "-outdir", lib, pkg)
+	if err != nil {
+		return requirement{}, err
+	}
+	if _, err := os.Stat(filepath.Join(lib, filepath.FromSlash(pkg), "gomod_export.go")); err != nil {
+		return requirement{}, fmt.Errorf("could not export %s to Go+: %s", pkg, strings.TrimSpace(problems))
+	}
+	// The path of the main package of a plugin identifies it, and Go refuses to load two
+	// plugins with the same path, so each package has its own.
+	mainDir := filepath.Join(b.dir, "plugins", filepath.FromSlash(pkg))
+	if err := os.MkdirAll(mainDir, 0755); err != nil {
+		return requirement{}, err
+	}
+	src := fmt.Sprintf("package main\n\nimport _ %q\n", b.module+"/lib/"+pkg)
+	if err := ioutil.WriteFile(filepath.Join(mainDir, "main.go"), []byte(src), 0644); err != nil {
+		return requirement{}, err
+	}
+
+	entry := c.entry(pin.Package, pin.Version)
+	if err := os.MkdirAll(entry, 0755); err != nil {
+		return requirement{}, err
+	}
+	flags, env := c.toolchain.buildFlags()
+	b.env = env
+	args := append([]string{"build", "-buildmode=plugin", "-o", c.plugin(pin)}, flags...)
+	if _, err := b.goTool(append(args, "./plugins/"+pkg)...); err != nil {
+		return requirement{}, err
+	}
+	if err := c.toolchain.check(c.plugin(pin)); err != nil {
+		os.Remove(c.plugin(pin))
+		return requirement{}, fmt.Errorf("the plugin of %s %s cannot be loaded: %v", pin.Module, pin.Version, err)
+	}
+	data, _ := json.MarshalIndent(pin, "", "  ")
+	if err := ioutil.WriteFile(filepath.Join(entry, "requirement.json"), data, 0644); err != nil {
+		return requirement{}, err
+	}
+	return pin, nil
+}
+
+// bindingsModule is the module the bindings of a package are built in.
+type bindingsModule struct {
+	dir    string
+	module string   // the path of the module
+	vendor bool     // whether the packages are read from the vendor directory
+	env    []string // added to the environment of the go tool
+}
+
+// newBindingsModule creates the module the bindings of a package are built in. In vendor
+// mode, it is a directory of the module of the working directory, whose vendor
+// directory has the packages. Otherwise, it is a new module requiring the version of
+// Go+ the kernel was built with.
+func newBindingsModule(vendor bool) (*bindingsModule, error) {
+	if vendor {
+		out, err := exec.Command(goCommand, "list", "-m", "-f", "{{.Path}}\n{{.Dir}}").Output()
+		fields := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if err != nil || len(fields) != 2 {
+			return nil, errors.New("GOFLAGS has -mod=vendor, but the working directory is not in a module")
+		}
+		dir, err := ioutil.TempDir(fields[1], "gopyter-bindings")
+		if err != nil {
+			return nil, err
+		}
+		return &bindingsModule{dir: dir, module: fields[0] + "/" + filepath.Base(dir), vendor: true}, nil
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil, errors.New("the kernel was built without module support")
+	}
+	var mod string
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/goplus/gop" {
+			mod = fmt.Sprintf("module %s\n\ngo 1.13\n\nrequire %s %s\n", bindingsModulePath, dep.Path, dep.Version)
+		}
+	}
+	if mod == "" {
+		return nil, errors.New("the kernel was built without module information")
+	}
+	dir, err := ioutil.TempDir("", "gopyter-bindings")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &bindingsModule{dir: dir, module: bindingsModulePath}, nil
+}
+
+// bindingsModulePath is the path of the modules bindings are built in.
+const bindingsModulePath = "gopyter.local/bindings"
+
+// checkKernelVersions returns an error if pin needs another version of a module the
+// kernel was built with, since its plugin would then fail to load.
+func (b *bindingsModule) checkKernelVersions(pin requirement) error {
+	info, _ := debug.ReadBuildInfo()
+	kernelVersions := map[string]string{}
+	for _, dep := range info.Deps {
+		kernelVersions[dep.Path] = dep.Version
+	}
+	// The modules of the packages pin depends on are those the plugin shares with the
+	// kernel, along with Go+, which the module requires.
+	all, err := b.goTool("list", "-deps", "-f", "{{with .Module}}{{.Path}} {{.Version}}{{end}}", pin.Package)
+	if err != nil {
+		return err
+	}
+	var conflicts []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(all, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if want, ok := kernelVersions[fields[0]]; ok && want != fields[1] && !seen[fields[0]] {
+			seen[fields[0]] = true
+			conflicts = append(conflicts, fmt.Sprintf("%s %s (the kernel has %s)", fields[0], fields[1], want))
+		}
+	}
+	if len(conflicts) != 0 {
+		return fmt.Errorf("%s %s needs versions of modules the kernel was not built with: %s", pin.Module, pin.Version, strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// goTool runs the go tool in the module and returns its standard output.
+func (b *bindingsModule) goTool(args ...string) (string, error) {
+	stdout, _, err := b.goToolOutput(args...)
+	return stdout, err
+}
+
+// goToolOutput runs the go tool in the module and returns its standard output and
+// error. The error it returns on failure holds the standard error.
+func (b *bindingsModule) goToolOutput(args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(goCommand, args...)
+	cmd.Dir = b.dir
+	cmd.Env = os.Environ()
+	if !b.vendor {
+		cmd.Env = append(cmd.Env, "GOFLAGS=-mod=mod")
+	}
+	cmd.Env = append(cmd.Env, b.env...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", "", errors.New(message)
+		}
+		return "", "", fmt.Errorf("go %s: %v", args[0], err)
+	}
+	return stdout.String(), stderr.String(), nil
+}
+
+// evalRequirementsMagic implements `%requirements`, which lists the required packages.
+func evalRequirementsMagic(kernel *Kernel, outerr OutErr, args string) error {
+	if _, err := requirementsSyntax.parse(args); err != nil {
+		return err
+	}
+	r := kernel.session.requirements
+	if r == nil || len(r.pins) == 0 {
+		fmt.Fprintln(outerr.out, "No packages are required.")
+		return nil
+	}
+	for _, pin := range r.pins {
+		fmt.Fprintf(outerr.out, "%s@%s\n", pin.Package, pin.Version)
+	}
+	return nil
+}
+
+// lockRequirements makes %require use the versions recorded in the metadata of nb by an
+// earlier run.
+func (kernel *Kernel) lockRequirements(nb *notebook) {
+	gopyter, _ := nb.Metadata["gopyter"].(map[string]interface{})
+	recorded, _ := gopyter[requirementsMetadata].(map[string]interface{})
+	if len(recorded) == 0 {
+		return
+	}
+	r := kernel.sessionRequirements()
+	r.locked = map[string]string{}
+	for pkg, version := range recorded {
+		if v, ok := version.(string); ok {
+			r.locked[pkg] = v
+		}
+	}
+}
+
+// recordRequirements records the versions of the packages required while running nb in
+// its metadata.
+func (kernel *Kernel) recordRequirements(nb *notebook) {
+	r := kernel.session.requirements
+	if r == nil || len(r.pins) == 0 {
+		return
+	}
+	recorded := map[string]interface{}{}
+	for _, pin := range r.pins {
+		recorded[pin.Package] = pin.Version
+	}
+	if nb.Metadata == nil {
+		nb.Metadata = map[string]interface{}{}
+	}
+	gopyter, _ := nb.Metadata["gopyter"].(map[string]interface{})
+	if gopyter == nil {
+		gopyter = map[string]interface{}{}
+		nb.Metadata["gopyter"] = gopyter
+	}
+	gopyter[requirementsMetadata] = recorded
+}
+
+var (
+	moduleCacheOnce sync.Once
+	moduleCache     string
+)
+
+// moduleCacheDir returns the module cache of the go tool, or "" if there is none.
+func moduleCacheDir() string {
+	moduleCacheOnce.Do(func() {
+		if moduleCache = os.Getenv("GOMODCACHE"); moduleCache != "" {
+			return
+		}
+		if out, err := exec.Command(goCommand, "env", "GOMODCACHE").Output(); err == nil {
+			moduleCache = strings.TrimSpace(string(out))
+		}
+	})
+	return moduleCache
+}
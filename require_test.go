@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useModuleCache skips the test in short mode or without the go tool, which building
+// plugins needs, and makes the go tool read modules from the module cache if they are
+// there, so the test can run offline. It returns a function restoring the environment.
+func useModuleCache(t *testing.T) func() {
+	if testing.Short() {
+		t.Skip("building a plugin is slow")
+	}
+	if _, err := exec.LookPath(goCommand); err != nil {
+		t.Skip("the go tool is not installed")
+	}
+	cache, err := exec.Command(goCommand, "env", "GOMODCACHE").Output()
+	if err != nil {
+		return func() {}
+	}
+	proxy := "file://" + filepath.Join(strings.TrimSpace(string(cache)), "cache", "download")
+	if current := os.Getenv("GOPROXY"); current != "" && current != "off" {
+		proxy += "," + current
+	}
+	return setenv(map[string]string{"GOPROXY": proxy, "GOSUMDB": "off"})
+}
+
+// setenv sets the environment variables vars, and returns a function restoring them.
+func setenv(vars map[string]string) func() {
+	old := make(map[string]*string)
+	for name, value := range vars {
+		if v, ok := os.LookupEnv(name); ok {
+			old[name] = &v
+		} else {
+			old[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, value := range old {
+			if value != nil {
+				os.Setenv(name, *value)
+			} else {
+				os.Unsetenv(name)
+			}
+		}
+	}
+}
+
+// tempImportCache returns an import cache, and a function removing it.
+func tempImportCache(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "imports")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// TestRequireMagic tests requiring a package and importing it, which downloads and
+// builds it.
+func TestRequireMagic(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var removeCache func()
+	kernel.config.ImportCache, removeCache = tempImportCache(t)
+	defer removeCache()
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+
+	t.Logf("Should refuse requirements without a version, and packages it can already import.")
+
+	errCases := []struct {
+		args, err string
+	}{
+		{"github.com/google/uuid", "expected <package>@<version>"},
+		{"strings@v1.0.0", "can already be imported"},
+	}
+	for _, tc := range errCases {
+		if _, err := kernel.doEvalGop(outerr, "%require "+tc.args); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("\t%s Expected an error with %q, got %v.", failure, tc.err, err)
+		}
+	}
+	if _, err := kernel.doEvalGop(outerr, "%requirements"); err != nil || out.String() != "No packages are required.\n" {
+		t.Fatalf("\t%s Expected no requirements, got %q (%v).", failure, out.String(), err)
+	}
+	t.Logf("\t%s Refused them.", success)
+
+	defer useModuleCache(t)()
+
+	t.Logf("Should make a required package importable.")
+
+	out.Reset()
+	if _, err := kernel.doEvalGop(outerr, "%require github.com/google/uuid@v1.6.0"); err != nil {
+		t.Fatalf("\t%s %%require failed: %v.", failure, err)
+	}
+	if !strings.HasSuffix(out.String(), "Required github.com/google/uuid v1.6.0; import \"github.com/google/uuid\" to use it.\n") {
+		t.Fatalf("\t%s Expected the package to be required, got %q.", failure, out.String())
+	}
+	vals, err := kernel.doEvalGop(outerr, "import \"github.com/google/uuid\"\nlen(uuid.NewString())")
+	if err != nil || len(vals) != 1 || vals[0] != 36 {
+		t.Fatalf("\t%s Expected a UUID, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Imported it.", success)
+
+	t.Logf("Should list the requirements, and keep their versions.")
+
+	out.Reset()
+	if _, err := kernel.doEvalGop(outerr, "%requirements"); err != nil || out.String() != "github.com/google/uuid@v1.6.0\n" {
+		t.Fatalf("\t%s Expected the requirement, got %q (%v).", failure, out.String(), err)
+	}
+	out.Reset()
+	if _, err := kernel.doEvalGop(outerr, "%require github.com/google/uuid@v1.6.0"); err != nil || out.String() != "github.com/google/uuid v1.6.0 is already required.\n" {
+		t.Fatalf("\t%s Expected the requirement to be kept, got %q (%v).", failure, out.String(), err)
+	}
+	if _, err := kernel.doEvalGop(outerr, "%require github.com/google/uuid@v1.5.0"); err == nil || !strings.Contains(err.Error(), "restart the kernel") {
+		t.Fatalf("\t%s Expected another version to be refused, got %v.", failure, err)
+	}
+	t.Logf("\t%s Listed and kept them.", success)
+
+	t.Logf("Should record the versions in notebooks, and use the recorded versions.")
+
+	nb := &notebook{}
+	kernel.recordRequirements(nb)
+	want := map[string]interface{}{"gopyter": map[string]interface{}{"requirements": map[string]interface{}{"github.com/google/uuid": "v1.6.0"}}}
+	if !reflect.DeepEqual(nb.Metadata, want) {
+		t.Fatalf("\t%s Expected the versions in the metadata, got %v.", failure, nb.Metadata)
+	}
+	kernel.lockRequirements(nb)
+	out.Reset()
+	if _, err := kernel.doEvalGop(outerr, "%require github.com/google/uuid@latest"); err != nil ||
+		out.String() != "Using github.com/google/uuid v1.6.0, as recorded in the notebook.\ngithub.com/google/uuid v1.6.0 is already required.\n" {
+		t.Fatalf("\t%s Expected the recorded version to be used, got %q (%v).", failure, out.String(), err)
+	}
+	t.Logf("\t%s Recorded and used them.", success)
+}
+
+// TestPrewarm tests building plugins ahead of time, and requiring them offline.
+func TestPrewarm(t *testing.T) {
+	t.Logf("Should read lists of packages.")
+
+	packages, err := readPackageList(strings.NewReader("# Course packages\ngithub.com/gofrs/uuid@v3.3.0+incompatible\n\n  golang.org/x/xerrors@latest  # errors\n"))
+	if want := []string{"github.com/gofrs/uuid@v3.3.0+incompatible", "golang.org/x/xerrors@latest"}; err != nil || !reflect.DeepEqual(packages, want) {
+		t.Fatalf("\t%s Expected %q, got %q (%v).", failure, want, packages, err)
+	}
+	t.Logf("\t%s Read them.", success)
+
+	defer useModuleCache(t)()
+	config := defaultConfig()
+	var removeCache func()
+	config.ImportCache, removeCache = tempImportCache(t)
+	defer removeCache()
+
+	t.Logf("Should build plugins into the import cache.")
+
+	var out bytes.Buffer
+	if err := (&Kernel{NewSession(), config}).prewarm(&out, packages[:1]); err != nil {
+		t.Fatalf("\t%s Prewarming failed: %v (%s).", failure, err, out.String())
+	}
+	if !strings.HasSuffix(out.String(), "Prewarmed github.com/gofrs/uuid v3.3.0+incompatible.\n") {
+		t.Fatalf("\t%s Expected the package to be prewarmed, got %q.", failure, out.String())
+	}
+	out.Reset()
+	if err := (&Kernel{NewSession(), config}).prewarm(&out, []string{"github.com/gofrs/uuid@v3.3.0+incompatible", "fmt"}); err == nil ||
+		out.String() != "github.com/gofrs/uuid@v3.3.0+incompatible is already in the import cache.\nfmt: expected <package>@<version>\n" {
+		t.Fatalf("\t%s Expected the cached package to be skipped and fmt to fail, got %q (%v).", failure, out.String(), err)
+	}
+	t.Logf("\t%s Built them.", success)
+
+	t.Logf("Should require the cached packages offline.")
+
+	config.Offline = true
+	kernel := Kernel{NewSession(), config}
+	out.Reset()
+	outerr := OutErr{&out, ioutil.Discard}
+	if _, err := kernel.doEvalGop(outerr, "%require github.com/gofrs/uuid@v3.3.0+incompatible"); err != nil ||
+		out.String() != "Required github.com/gofrs/uuid v3.3.0+incompatible; import \"github.com/gofrs/uuid\" to use it.\n" {
+		t.Fatalf("\t%s Expected the package to be loaded from the cache, got %q (%v).", failure, out.String(), err)
+	}
+	vals, err := kernel.doEvalGop(outerr, "import \"github.com/gofrs/uuid\"\nuuid.NamespaceDNS.String()")
+	if err != nil || len(vals) != 1 || vals[0] != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" {
+		t.Fatalf("\t%s Expected the namespace, got %v (%v).", failure, vals, err)
+	}
+	if _, err := kernel.doEvalGop(outerr, "%require github.com/gofrs/uuid@v3.2.0"); err == nil {
+		t.Fatalf("\t%s Expected another version to be refused.", failure)
+	}
+	if _, err := kernel.doEvalGop(outerr, "%require golang.org/x/xerrors@latest"); err == nil || !strings.Contains(err.Error(), "cannot be downloaded offline") {
+		t.Fatalf("\t%s Expected an uncached package to be refused offline, got %v.", failure, err)
+	}
+	t.Logf("\t%s Required them.", success)
+
+	t.Logf("Should not use cached plugins the kernel cannot load.")
+
+	// The plugin is loaded, so it is replaced rather than overwritten.
+	imports := kernel.importCache()
+	path := imports.plugin(requirement{Package: "github.com/gofrs/uuid", Version: "v3.3.0+incompatible"})
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("\t%s Expected the plugin in the cache: %v.", failure, err)
+	}
+	ioutil.WriteFile(path, []byte("not a plugin"), 0644)
+	out.Reset()
+	if err := (&Kernel{NewSession(), config}).prewarm(&out, packages[:1]); err == nil ||
+		!strings.HasPrefix(out.String(), "The cached plugin of github.com/gofrs/uuid v3.3.0+incompatible cannot be loaded: ") ||
+		!strings.Contains(out.String(), "cannot be downloaded offline") {
+		t.Fatalf("\t%s Expected the plugin to be rebuilt, got %q (%v).", failure, out.String(), err)
+	}
+	t.Logf("\t%s Did not use them.", success)
+}
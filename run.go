@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// runNotebook implements `gopyter run`, which executes a notebook without Jupyter and
+// saves it with the outputs of its cells. Parameters given with -param are set in a
+// cell inserted after the cell tagged `parameters`, like papermill does, so notebooks
+// can be run on a schedule with different inputs.
+func runNotebook(config KernelConfig, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	var params paramList
+	flags.Var(&params, "param", "set a notebook parameter, as name=value (may be repeated)")
+	grade := flags.Bool("nbgrader", false, "autograde the notebook using its nbgrader metadata")
+	scores := flags.String("scores", "", "with -nbgrader, the file to write the scores to (default stderr)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gopyter run [-param name=value]... [-nbgrader [-scores file]] input.ipynb [output.ipynb]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	output := "-"
+	if flags.NArg() > 1 {
+		output = flags.Arg(1)
+	}
+
+	nb, err := readNotebook(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	kernel, err := newKernel(config)
+	if err != nil {
+		return err
+	}
+	if *grade {
+		report := kernel.gradeNotebook(nb)
+		if err := writeNotebook(output, nb); err != nil {
+			return err
+		}
+		return writeGradeReport(*scores, report)
+	}
+
+	kernel.lockRequirements(nb)
+	runErr := kernel.executeNotebook(nb, params)
+	kernel.recordRequirements(nb)
+
+	// Save the notebook even if a cell failed, so the error can be inspected.
+	if err := writeNotebook(output, nb); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// executeNotebook executes the code cells of nb in order and stores their outputs in
+// the cells. It stops at the first cell that fails.
+func (kernel *Kernel) executeNotebook(nb *notebook, params []param) error {
+	// Drop parameters injected by an earlier run and find where to inject ours.
+	cells := make([]notebookCell, 0, len(nb.Cells)+1)
+	inject := 0
+	for _, cell := range nb.Cells {
+		if cell.hasTag(injectedParametersTag) {
+			continue
+		}
+		cells = append(cells, cell)
+		if cell.hasTag(parametersTag) {
+			inject = len(cells)
+		}
+	}
+	nb.Cells = cells
+
+	count := 0
+	for i := 0; i < len(nb.Cells); i++ {
+		if i == inject && len(params) != 0 {
+			cell := newCodeCell(kernel.paramsCode(params), injectedParametersTag)
+			nb.Cells = append(nb.Cells[:i], append([]notebookCell{cell}, nb.Cells[i:]...)...)
+		}
+
+		cell := nb.Cells[i]
+		if !cell.isCode() {
+			continue
+		}
+
+		count++
+		outputs, err := kernel.executeCell(count, cell.id(), cell.source())
+		cell["execution_count"] = count
+		cell["outputs"] = outputs
+		if err != nil {
+			return fmt.Errorf("cell %d failed: %v", count, err)
+		}
+	}
+	return nil
+}
+
+// executeCell executes the code of a cell and returns its outputs in nbformat.
+func (kernel *Kernel) executeCell(count int, cellID string, code string) ([]interface{}, error) {
+	var vals []interface{}
+	var err error
+	stdout, stderr, captureErr := captureOutput(func(outerr OutErr) {
+		vals, err = kernel.evalRecovering(outerr, code)
+	})
+	if captureErr != nil {
+		return nil, captureErr
+	}
+	kernel.session.history.record(count, code, vals)
+	if err == nil {
+		kernel.session.deps.record(count, cellID, code)
+	}
+
+	stdout, stderr = kernel.session.redactor.redact(stdout), kernel.session.redactor.redact(stderr)
+	outputs := []interface{}{}
+	if stdout != "" {
+		outputs = append(outputs, map[string]interface{}{"output_type": "stream", "name": StreamStdout, "text": stdout})
+	}
+	if stderr != "" {
+		outputs = append(outputs, map[string]interface{}{"output_type": "stream", "name": StreamStderr, "text": stderr})
+	}
+
+	if err != nil {
+		evalue := kernel.session.redactor.redact(err.Error())
+		outputs = append(outputs, map[string]interface{}{
+			"output_type": "error",
+			"ename":       "ERROR",
+			"evalue":      evalue,
+			"traceback":   cellTraceback(count, cellID, evalue),
+		})
+		return outputs, err
+	}
+
+	data := kernel.session.redactor.redactData(kernel.autoRenderResults(vals))
+	if len(data.Data) != 0 {
+		metadata := data.Metadata
+		if metadata == nil {
+			metadata = MIMEMap{}
+		}
+		outputs = append(outputs, map[string]interface{}{
+			"output_type":     "execute_result",
+			"execution_count": count,
+			"data":            data.Data,
+			"metadata":        metadata,
+		})
+	}
+	return outputs, nil
+}
+
+// captureOutput runs f with the standard output and error redirected, and returns
+// what was written to them.
+func captureOutput(f func(outerr OutErr)) (stdout, stderr string, err error) {
+	oldStdout, oldStderr := os.Stdout, os.Stderr
+
+	rOut, wOut, err := os.Pipe()
+	if err != nil {
+		return "", "", err
+	}
+	rErr, wErr, err := os.Pipe()
+	if err != nil {
+		rOut.Close()
+		wOut.Close()
+		return "", "", err
+	}
+	os.Stdout, os.Stderr = wOut, wErr
+
+	var writersWG sync.WaitGroup
+	writersWG.Add(2)
+
+	var outBuf, errBuf bytes.Buffer
+	go func() {
+		defer writersWG.Done()
+		io.Copy(&outBuf, rOut)
+	}()
+	go func() {
+		defer writersWG.Done()
+		io.Copy(&errBuf, rErr)
+	}()
+
+	f(OutErr{wOut, wErr})
+
+	wOut.Close()
+	wErr.Close()
+	os.Stdout, os.Stderr = oldStdout, oldStderr
+	writersWG.Wait()
+
+	return outBuf.String(), errBuf.String(), nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	lineMagics["runbook"] = evalRunbookMagic
+	documentMagic(runbookSyntax)
+}
+
+var runbookSyntax = &magicSyntax{
+	name:  "%runbook",
+	usage: []string{"[on|off]"},
+	doc: "Enables or disables runbook mode, or reports whether it is enabled. In runbook mode, cells marked " +
+		"`# !dangerous` only run once confirmed, and every cell is recorded in the audit log.",
+	args: []magicParam{{name: "on|off", help: "enables or disables runbook mode", optional: true}},
+}
+
+// dangerMarker matches the lines marking a cell as dangerous. They are comments for
+// Python but not for Go+, so the kernel drops them.
+var dangerMarker = regexp.MustCompile(`(?m)^[ \t]*(#+|//)[ \t]*!dangerous[ \t]*\r?\n?`)
+
+// stripDangerMarkers removes the markers of dangerous cells from code.
+func stripDangerMarkers(code string) string {
+	return dangerMarker.ReplaceAllString(code, "")
+}
+
+// errNotConfirmed is returned for a dangerous cell the user did not confirm.
+var errNotConfirmed = errors.New("%runbook: the cell is marked dangerous and was not confirmed, so it was not run")
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time           time.Time `json:"time"`
+	User           string    `json:"user"`
+	ExecutionCount int       `json:"execution_count"`
+	CellID         string    `json:"cell_id,omitempty"`
+	Code           string    `json:"code"`
+	Dangerous      bool      `json:"dangerous"`
+	Status         string    `json:"status"` // "ok", "error" or "declined"
+	Error          string    `json:"error,omitempty"`
+	OutputSHA256   string    `json:"output_sha256"`
+}
+
+// cellAudit records the execution of a cell in runbook mode. Its methods do nothing on
+// a nil cellAudit, which is the audit of cells outside runbook mode.
+type cellAudit struct {
+	record auditRecord
+	output hash.Hash // hashes the output of the cell as it is written
+}
+
+// startAudit starts recording the execution of code by user, from the notebook cell
+// cellID if it is known, if runbook mode is enabled. The user defaults to the one
+// running the kernel.
+func (kernel *Kernel) startAudit(username string, cellID string, code string) *cellAudit {
+	if !kernel.config.Runbook {
+		return nil
+	}
+	if username == "" {
+		if u, err := user.Current(); err == nil {
+			username = u.Username
+		}
+	}
+	return &cellAudit{
+		record: auditRecord{
+			Time:           time.Now().UTC(),
+			User:           username,
+			ExecutionCount: ExecCounter,
+			CellID:         cellID,
+			Code:           code,
+			Dangerous:      dangerMarker.MatchString(code),
+		},
+		output: sha256.New(),
+	}
+}
+
+// tee returns outerr, also writing to the hash of the output of the cell.
+func (a *cellAudit) tee(outerr OutErr) OutErr {
+	if a == nil {
+		return outerr
+	}
+	return OutErr{io.MultiWriter(outerr.out, a.output), io.MultiWriter(outerr.err, a.output)}
+}
+
+// confirm asks the user to confirm the execution of a dangerous cell, through the
+// front-end, and returns errNotConfirmed unless they do.
+func (kernel *Kernel) confirm(a *cellAudit) error {
+	if a == nil || !a.record.Dangerous {
+		return nil
+	}
+	input := kernel.session.input
+	if input == nil {
+		a.record.Status = "declined"
+		return errors.New("%runbook: the cell is marked dangerous, but the front-end cannot ask for a confirmation")
+	}
+	answer, err := input(`This cell is marked dangerous. Type "yes" to run it: `, false)
+	if err != nil {
+		a.record.Status = "declined"
+		return fmt.Errorf("%%runbook: could not ask for a confirmation: %v", err)
+	}
+	if answer := strings.ToLower(strings.TrimSpace(answer)); answer != "yes" && answer != "y" {
+		a.record.Status = "declined"
+		return errNotConfirmed
+	}
+	return nil
+}
+
+// finishAudit completes the record of the cell with its results and error, once its
+// output was written, and appends it to the audit log.
+func (kernel *Kernel) finishAudit(a *cellAudit, vals []interface{}, err error) error {
+	if a == nil {
+		return nil
+	}
+	for _, v := range vals {
+		fmt.Fprintf(a.output, "%v\n", v)
+	}
+	a.record.OutputSHA256 = hex.EncodeToString(a.output.Sum(nil))
+	if err != nil {
+		if a.record.Status == "" {
+			a.record.Status = "error"
+		}
+		a.record.Error = err.Error()
+	} else {
+		a.record.Status = "ok"
+	}
+	a.record.Code = kernel.session.redactor.redact(a.record.Code)
+	a.record.Error = kernel.session.redactor.redact(a.record.Error)
+
+	path, err := kernel.auditLog()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(a.record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// The log is only ever appended to, so earlier records cannot be lost.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// auditLog returns the path of the audit log of runbook mode. Unless the config sets
+// one, it is gopyter/audit.log in the user's configuration directory, out of the reach
+// of the front-ends that manage the files of the working directory.
+func (kernel *Kernel) auditLog() (string, error) {
+	if kernel.config.AuditLog != "" {
+		return kernel.config.AuditLog, nil
+	}
+	userDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("%%runbook: could not find a directory for the audit log, set audit_log in the config: %v", err)
+	}
+	return filepath.Join(userDir, "gopyter", "audit.log"), nil
+}
+
+// isAuditLog reports whether path is the audit log of runbook mode, which cells and
+// front-ends must not be able to replace.
+func (kernel *Kernel) isAuditLog(path string) bool {
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return false
+	}
+	auditLog, err := kernel.auditLog()
+	if err != nil {
+		return false
+	}
+	auditLog, err = resolvePath(auditLog)
+	return err == nil && resolved == auditLog
+}
+
+// evalRunbookMagic implements `%runbook [on|off]`, which enables or disables runbook
+// mode, or reports whether it is enabled.
+func evalRunbookMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := runbookSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	switch parsed.arg(0) {
+	case "on":
+		kernel.config.Runbook = true
+	case "off":
+		kernel.config.Runbook = false
+	case "":
+	default:
+		return runbookSyntax.errorf("expected on or off, got %q", args)
+	}
+	if !kernel.config.Runbook {
+		fmt.Fprintln(outerr.out, "Runbook mode is off.")
+		return nil
+	}
+	path, err := kernel.auditLog()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(outerr.out, "Runbook mode is on, auditing cells to %s.\n", path)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRunbook tests confirming dangerous cells and auditing cells in runbook mode.
+func TestRunbook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runbook")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	defer os.RemoveAll(dir)
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	kernel.config.AuditLog = filepath.Join(dir, "audit.log")
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+	var prompts []string
+	answer := "yes"
+	kernel.session.input = func(prompt string, password bool) (string, error) {
+		prompts = append(prompts, prompt)
+		return answer, nil
+	}
+
+	// execute runs a cell like handleExecuteRequest does.
+	execute := func(code string) ([]interface{}, error) {
+		audit := kernel.startAudit("alice", "", code)
+		outerr := audit.tee(outerr)
+		err := kernel.confirm(audit)
+		var vals []interface{}
+		if err == nil {
+			vals, err = kernel.doEvalGop(outerr, code)
+		}
+		if auditErr := kernel.finishAudit(audit, vals, err); auditErr != nil {
+			t.Fatalf("\t%s Could not write the audit log: %v.", failure, auditErr)
+		}
+		return vals, err
+	}
+
+	t.Logf("Should run dangerous cells without confirmation outside runbook mode.")
+
+	if vals, err := execute("# !dangerous\nx := 1\nx + 1"); err != nil || len(vals) != 1 || vals[0] != 2 || len(prompts) != 0 {
+		t.Fatalf("\t%s Expected the cell to run, got %v (%v) after %q.", failure, vals, err, prompts)
+	}
+	t.Logf("\t%s Ran it.", success)
+
+	t.Logf("Should ask for a confirmation before running dangerous cells in runbook mode.")
+
+	if _, err := execute("%runbook on"); err != nil || !strings.Contains(out.String(), "auditing cells to "+kernel.config.AuditLog) {
+		t.Fatalf("\t%s Expected runbook mode to be on, got %q (%v).", failure, out.String(), err)
+	}
+	answer = "no"
+	if _, err := execute("// !dangerous\nx = 10"); err != errNotConfirmed || len(prompts) != 1 || !strings.Contains(prompts[0], "marked dangerous") {
+		t.Fatalf("\t%s Expected the cell to be declined, got %v after %q.", failure, err, prompts)
+	}
+	answer = " Yes\n"
+	if vals, err := execute("x = 20\n  # !dangerous\nx"); err != nil || len(vals) != 1 || vals[0] != 20 || len(prompts) != 2 {
+		t.Fatalf("\t%s Expected the confirmed cell to run, got %v (%v).", failure, vals, err)
+	}
+	if vals, err := execute("x"); err != nil || vals[0] != 20 || len(prompts) != 2 {
+		t.Fatalf("\t%s Expected a cell not marked dangerous to run without confirmation, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Asked for confirmations.", success)
+
+	t.Logf("Should refuse dangerous cells when the front-end cannot ask for confirmation.")
+
+	kernel.session.input = func(string, bool) (string, error) { return "", errors.New("interrupted") }
+	if _, err := execute("# !dangerous\nx = 30"); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("\t%s Expected the cell to be refused, got %v.", failure, err)
+	}
+	kernel.session.input = nil
+	if _, err := execute("# !dangerous\nx = 30"); err == nil || !strings.Contains(err.Error(), "cannot ask for a confirmation") {
+		t.Fatalf("\t%s Expected the cell to be refused, got %v.", failure, err)
+	}
+	t.Logf("\t%s Refused them.", success)
+
+	t.Logf("Should record the cells run in runbook mode in the audit log.")
+
+	if _, err := execute("_ = undefined\n%runbook off"); err == nil {
+		t.Fatalf("\t%s Expected the cell to fail.", failure)
+	}
+	execute("%runbook off")
+	execute("x")
+
+	f, err := os.Open(kernel.config.AuditLog)
+	if err != nil {
+		t.Fatalf("\t%s Could not open the audit log: %v.", failure, err)
+	}
+	defer f.Close()
+	var records []auditRecord
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("\t%s Could not parse %q: %v.", failure, scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	var statuses []string
+	for _, r := range records {
+		statuses = append(statuses, r.Status)
+	}
+	if want := "declined ok ok declined declined error ok"; strings.Join(statuses, " ") != want {
+		t.Fatalf("\t%s Expected the statuses %q, got %q.", failure, want, statuses)
+	}
+	confirmed := records[1]
+	if confirmed.User != "alice" || !confirmed.Dangerous || !strings.Contains(confirmed.Code, "# !dangerous") || records[2].Dangerous ||
+		records[0].OutputSHA256 == confirmed.OutputSHA256 || confirmed.OutputSHA256 != records[2].OutputSHA256 || records[5].Error == "" {
+		t.Fatalf("\t%s Expected the details of the cells, got %+v.", failure, records)
+	}
+	t.Logf("\t%s Recorded %d cells.", success, len(records))
+
+	t.Logf("Should keep the audit log in the configuration directory by default.")
+
+	defer setenv(map[string]string{"XDG_CONFIG_HOME": dir, "HOME": dir})()
+	kernel.config.AuditLog = ""
+	path, err := kernel.auditLog()
+	if wd, _ := os.Getwd(); err != nil || !strings.HasPrefix(path, dir) || strings.HasPrefix(path, wd) {
+		t.Fatalf("\t%s Expected the audit log in %s, got %s: %v.", failure, dir, path, err)
+	}
+	for _, code := range []string{"%runbook on", "x"} {
+		if _, err := execute(code); err != nil {
+			t.Fatalf("\t%s Could not execute %q: %v.", failure, code, err)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("\t%s Expected the audit log to be created: %v.", failure, err)
+	}
+	t.Logf("\t%s Audited to %s.", success, path)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/goplus/gop"
+	gopioutil "github.com/goplus/gop/lib/io/ioutil"
+	gopos "github.com/goplus/gop/lib/os"
+	"golang.org/x/xerrors"
+)
+
+// SandboxConfig restricts what cells can do. It is meant for kernels exposed to
+// untrusted users, e.g. students on a shared JupyterHub. It confines the packages and
+// builtins offered to cells, not the kernel process, which keeps the rights of its user.
+type SandboxConfig struct {
+	// Enabled turns the sandbox on. The -sandbox flag has the same effect.
+	Enabled bool `json:"enabled"`
+
+	// Roots lists the directories cells may access through the os and ioutil packages. It
+	// defaults to the working directory of the kernel and the temporary directory.
+	Roots []string `json:"roots,omitempty"`
+
+	// MemoryMB bounds the address space of the kernel process, in MiB. Zero leaves
+	// it unbounded.
+	MemoryMB int `json:"memory_mb,omitempty"`
+
+	// CPUSeconds bounds the CPU time of the kernel process, in seconds. The process is
+	// killed when it is exceeded. Zero leaves it unbounded.
+	CPUSeconds int `json:"cpu_seconds,omitempty"`
+}
+
+// sandboxed is set once the sandbox is enabled. Features that cannot be confined, such
+// as shell commands, refuse to run when it is set.
+var sandboxed bool
+
+// errSandboxed is returned by the operations refused by the sandbox.
+var errSandboxed = errors.New("not permitted in the sandbox")
+
+// sandboxRoots holds the cleaned absolute paths of SandboxConfig.Roots.
+var sandboxRoots []string
+
+// sandboxedFunc describes a function of a Go+ package that is confined by the sandbox.
+// The arguments at the indexes in paths are checked against the sandbox roots; a
+// function without paths is refused altogether. An empty path, which ioutil.TempDir
+// and ioutil.TempFile take for the temporary directory, is checked as that directory.
+type sandboxedFunc struct {
+	name  string
+	fn    interface{}
+	paths []int
+}
+
+// sandboxedPackages are the packages of Go+ giving access to the file system or to
+// processes, with their functions the sandbox confines. Every function of these
+// packages taking a path or starting a process must be listed.
+var sandboxedPackages = []struct {
+	name  string
+	pkg   *gop.GoPackage
+	funcs []sandboxedFunc
+}{
+	{"os", gopos.I, sandboxedOsFuncs},
+	{"ioutil", gopioutil.I, sandboxedIoutilFuncs},
+}
+
+var sandboxedOsFuncs = []sandboxedFunc{
+	{"Chdir", os.Chdir, []int{0}},
+	{"Chmod", os.Chmod, []int{0}},
+	{"Chown", os.Chown, []int{0}},
+	{"Chtimes", os.Chtimes, []int{0}},
+	{"Create", os.Create, []int{0}},
+	{"Lchown", os.Lchown, []int{0}},
+	{"Link", os.Link, []int{0, 1}},
+	{"Lstat", os.Lstat, []int{0}},
+	{"Mkdir", os.Mkdir, []int{0}},
+	{"MkdirAll", os.MkdirAll, []int{0}},
+	{"Open", os.Open, []int{0}},
+	{"OpenFile", os.OpenFile, []int{0}},
+	{"Readlink", os.Readlink, []int{0}},
+	{"Remove", os.Remove, []int{0}},
+	{"RemoveAll", os.RemoveAll, []int{0}},
+	{"Rename", os.Rename, []int{0, 1}},
+	{"Stat", os.Stat, []int{0}},
+	{"Symlink", os.Symlink, []int{0, 1}},
+	{"Truncate", os.Truncate, []int{0}},
+	{"Exit", os.Exit, nil},
+	{"FindProcess", os.FindProcess, nil},
+	{"StartProcess", os.StartProcess, nil},
+	// Files opened by the kernel could be reached by their descriptors.
+	{"NewFile", os.NewFile, nil},
+}
+
+var sandboxedIoutilFuncs = []sandboxedFunc{
+	{"ReadDir", ioutil.ReadDir, []int{0}},
+	{"ReadFile", ioutil.ReadFile, []int{0}},
+	{"TempDir", ioutil.TempDir, []int{0}},
+	{"TempFile", ioutil.TempFile, []int{0}},
+	{"WriteFile", ioutil.WriteFile, []int{0}},
+}
+
+// enableSandbox confines the evaluation of cells for the rest of the process. Cells
+// only reach the system through the Go packages bound into Go+, none of which give
+// access to the network, so the sandbox:
+//
+//   - disables shell commands and other features starting processes,
+//   - limits the file system access of the os and ioutil packages to the sandbox roots
+//     and refuses the process functions of os,
+//   - bounds the memory and CPU time of the kernel process, where supported.
+func enableSandbox(config SandboxConfig) error {
+	roots := config.Roots
+	if len(roots) == 0 {
+		wd, err := os.Getwd()
+		if err != nil {
+			return xerrors.Errorf("sandbox: %w", err)
+		}
+		roots = []string{wd, os.TempDir()}
+	}
+	for _, root := range roots {
+		abs, err := resolvePath(root)
+		if err != nil {
+			return xerrors.Errorf("sandbox: invalid root %s: %w", root, err)
+		}
+		sandboxRoots = append(sandboxRoots, abs)
+	}
+
+	if err := setResourceLimits(config); err != nil {
+		return xerrors.Errorf("sandbox: %w", err)
+	}
+
+	for _, p := range sandboxedPackages {
+		confine(p.pkg, p.name, p.funcs)
+	}
+
+	sandboxed = true
+	return nil
+}
+
+// confine replaces the functions funcs of the package pkg, named name, by their
+// implementations checked by the sandbox.
+func confine(pkg *gop.GoPackage, name string, funcs []sandboxedFunc) {
+	for _, f := range funcs {
+		pkg.RegisterFuncs(pkg.Func(f.name, f.fn, sandboxExec(name, f)))
+	}
+}
+
+// sandboxExec returns the Go+ implementation of f, a function of the package pkg, which
+// calls f only if the sandbox permits it.
+func sandboxExec(pkg string, f sandboxedFunc) func(int, *gop.Context) {
+	fn := reflect.ValueOf(f.fn)
+	typ := fn.Type()
+	arity := typ.NumIn()
+
+	return func(_ int, p *gop.Context) {
+		args := p.GetArgs(arity)
+
+		var err error
+		if f.paths == nil {
+			err = xerrors.Errorf("%s.%s: %w", pkg, f.name, errSandboxed)
+		}
+		for _, i := range f.paths {
+			path := args[i].(string)
+			checked := path
+			if checked == "" {
+				checked = os.TempDir()
+			}
+			if !inSandbox(checked) {
+				err = &os.PathError{Op: f.name, Path: path, Err: errSandboxed}
+				break
+			}
+		}
+
+		var rets []interface{}
+		if err != nil {
+			// Report the error like the function would, or fail the cell if the
+			// function does not return errors.
+			n := typ.NumOut()
+			if n == 0 || typ.Out(n-1) != reflect.TypeOf((*error)(nil)).Elem() {
+				panic(err)
+			}
+			for i := 0; i < n-1; i++ {
+				rets = append(rets, reflect.Zero(typ.Out(i)).Interface())
+			}
+			rets = append(rets, err)
+		} else {
+			in := make([]reflect.Value, arity)
+			for i, arg := range args {
+				switch v := reflect.ValueOf(arg); {
+				case arg == nil:
+					in[i] = reflect.Zero(typ.In(i))
+				case v.Type() != typ.In(i) && v.Type().ConvertibleTo(typ.In(i)):
+					// Constants are passed with their basic types, like uint32 for an
+					// os.FileMode.
+					in[i] = v.Convert(typ.In(i))
+				default:
+					in[i] = v
+				}
+			}
+			for _, out := range fn.Call(in) {
+				rets = append(rets, out.Interface())
+			}
+		}
+
+		if len(rets) == 0 {
+			p.PopN(arity)
+		} else {
+			p.Ret(arity, rets...)
+		}
+	}
+}
+
+// inSandbox reports whether path is inside one of the sandbox roots.
+func inSandbox(path string) bool {
+	abs, err := resolvePath(path)
+	if err != nil {
+		return false
+	}
+	for _, root := range sandboxRoots {
+		if abs == root || strings.HasPrefix(abs, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath returns the absolute path of path with symbolic links resolved, so that
+// links cannot be used to escape the sandbox. Paths that do not exist yet are resolved
+// through their closest existing parent.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var rest []string
+	for {
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return filepath.Join(append([]string{abs}, rest...)...), nil
+		}
+		rest = append([]string{filepath.Base(abs)}, rest...)
+		abs = parent
+	}
+}
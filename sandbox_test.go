@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goplus/gop"
+)
+
+// TestInSandbox tests that only paths below the sandbox roots are permitted.
+func TestInSandbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := resolvePath(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(roots []string) { sandboxRoots = roots }(sandboxRoots)
+	sandboxRoots = []string{resolved}
+
+	cases := []struct {
+		Path    string
+		Allowed bool
+	}{
+		{root, true},
+		{filepath.Join(root, "data.csv"), true},
+		{filepath.Join(root, "new", "dir", "file"), true},
+		{filepath.Join(root, "..", "other"), false},
+		{root + "2", false},
+		{filepath.Join(root, "escape", "secret"), false},
+		{"/etc/passwd", false},
+	}
+
+	t.Logf("Should only permit paths inside the sandbox roots.")
+
+	for k, tc := range cases {
+		t.Logf("  Checking path %d/%d.", k+1, len(cases))
+
+		if got := inSandbox(tc.Path); got != tc.Allowed {
+			t.Errorf("\t%s inSandbox(%q) = %v, expected %v.", failure, tc.Path, got, tc.Allowed)
+			continue
+		}
+		t.Logf("\t%s Returned the expected answer.", success)
+	}
+}
+
+// sandboxTestPackage is confined by TestSandboxedPackages like the sandbox confines
+// ioutil, without confining the packages of the other tests.
+var sandboxTestPackage = gop.NewGoPackage("sandboxtest/ioutil")
+
+// TestSandboxedPackages tests that cells cannot leave the sandbox roots through the
+// functions of ioutil.
+func TestSandboxedPackages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root, err := resolvePath(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	defer func(roots []string) { sandboxRoots = roots }(sandboxRoots)
+	sandboxRoots = []string{root}
+	confine(sandboxTestPackage, "ioutil", sandboxedIoutilFuncs)
+
+	cases := []struct {
+		call    string
+		allowed bool
+	}{
+		{fmt.Sprintf("ioutil.WriteFile(%q, []byte(\"data\"), 0644)", filepath.Join(root, "data")), true},
+		{fmt.Sprintf("ioutil.ReadFile(%q)", filepath.Join(root, "data")), true},
+		{fmt.Sprintf("ioutil.ReadDir(%q)", root), true},
+		{fmt.Sprintf("ioutil.TempDir(%q, \"x\")", root), true},
+		{fmt.Sprintf("ioutil.ReadFile(%q)", filepath.Join(dir, "secret")), false},
+		{fmt.Sprintf("ioutil.ReadFile(%q)", filepath.Join(root, "escape", "secret")), false},
+		{fmt.Sprintf("ioutil.ReadFile(%q)", filepath.Join(root, "..", "secret")), false},
+		{`ioutil.ReadFile("/etc/passwd")`, false},
+		{fmt.Sprintf("ioutil.WriteFile(%q, []byte(\"data\"), 0644)", filepath.Join(dir, "written")), false},
+		{fmt.Sprintf("ioutil.ReadDir(%q)", dir), false},
+		{fmt.Sprintf("ioutil.TempFile(%q, \"x\")", dir), false},
+		{`ioutil.TempDir("", "x")`, false},
+	}
+
+	t.Logf("Should only let ioutil access the files below the sandbox roots.")
+
+	for _, tc := range cases {
+		session := NewSession()
+		vals, err := session.Eval("import ioutil \"sandboxtest/ioutil\"\n" + tc.call)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %s failed: %v.", failure, tc.call, err)
+		}
+		callErr, _ := vals[len(vals)-1].(error)
+		if refused := callErr != nil && strings.Contains(callErr.Error(), errSandboxed.Error()); refused == tc.allowed {
+			t.Fatalf("\t%s Expected %s to be allowed: %v, got %v.", failure, tc.call, tc.allowed, callErr)
+		}
+		t.Logf("\t%s %s: %v.", success, tc.call, callErr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "written")); !os.IsNotExist(err) {
+		t.Fatalf("\t%s Expected no file written outside of the sandbox, got %v.", failure, err)
+	}
+}
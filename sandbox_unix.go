@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// setResourceLimits bounds the memory and CPU time of the process.
+func setResourceLimits(config SandboxConfig) error {
+	if config.MemoryMB > 0 {
+		limit := uint64(config.MemoryMB) << 20
+		if err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return err
+		}
+	}
+	if config.CPUSeconds > 0 {
+		limit := uint64(config.CPUSeconds)
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package main
+
+import "errors"
+
+// setResourceLimits bounds the memory and CPU time of the process. Resource limits
+// are not supported on Windows.
+func setResourceLimits(config SandboxConfig) error {
+	if config.MemoryMB > 0 || config.CPUSeconds > 0 {
+		return errors.New("memory and CPU limits are not supported on Windows")
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"go/doc"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+func init() {
+	lineMagics["search"] = evalSearchMagic
+	documentMagic(searchSyntax)
+}
+
+var searchSyntax = &magicSyntax{
+	name:  "%search",
+	usage: []string{"[n=10] words..."},
+	doc: "Searches the documentation of the standard library, of the modules required with `%require` and of the " +
+		"packages imported in the session for the names matching all `words`, like `%search json marshal`, " +
+		"and lists the best matches with their signatures, the first sentence of their documentation and links to pkg.go.dev.",
+	args: []magicParam{
+		{name: "words", help: "the words searched in the names, packages and documentation", variadic: true},
+	},
+	options: []magicParam{
+		{name: "n", help: "the number of results shown, 10 by default"},
+	},
+}
+
+// searchResult is a package, or a name it declares, matching the words of %search.
+type searchResult struct {
+	Package  string // the import path of the package
+	Name     string // the name of the package, qualifying Symbol
+	Symbol   string // the name declared, Type.Method for methods, or "" for the package
+	Decl     string
+	Synopsis string // the first sentence of its documentation
+	URL      string
+	Score    int
+}
+
+// searchDocs returns the packages of docs and the names they declare matching all the
+// words, best first. Words are matched case-insensitively, and rank a name higher when
+// they match it than its package or its documentation. url returns the links of the
+// results.
+func searchDocs(docs []*packageDocs, words []string, url func(pkg, symbol string) string) []searchResult {
+	queried := map[string]bool{}
+	for i, w := range words {
+		words[i] = strings.ToLower(w)
+		queried[words[i]] = true
+	}
+	var results []searchResult
+	match := func(d *packageDocs, symbol, decl, comment string) {
+		// A name is scored on its last part, e.g. the method of Type.Method.
+		name, typ := d.Name, ""
+		if symbol != "" {
+			name = symbol
+		}
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name, typ = name[i+1:], strings.ToLower(name[:i])
+		}
+		nameWords := camelWords(name)
+		name = strings.ToLower(name)
+		text := strings.ToLower(comment)
+		score := 0
+		for _, w := range words {
+			s := 0
+			switch {
+			case name == w:
+				s = 10
+			case nameWords[w]:
+				s = 8
+			case strings.HasPrefix(name, w):
+				s = 6
+			case strings.Contains(name, w):
+				s = 4
+			case strings.Contains(typ, w):
+				s = 1
+			}
+			// A word naming the package, like json, is as good as one naming the symbol.
+			if symbol != "" && d.Name == w && s < 5 {
+				s = 5
+			} else if symbol != "" && s < 2 && strings.Contains(strings.ToLower(d.Path), w) {
+				s = 2
+			}
+			if strings.Contains(text, w) {
+				s++
+			}
+			if s == 0 {
+				return
+			}
+			score += s
+		}
+		// Prefer the names made of the words only, and functions and types to methods.
+		covered := true
+		for w := range nameWords {
+			covered = covered && queried[w]
+		}
+		if covered {
+			score += 3
+		}
+		if typ != "" {
+			score -= 3
+		}
+		results = append(results, searchResult{
+			Package:  d.Path,
+			Name:     d.Name,
+			Symbol:   symbol,
+			Decl:     declLine(symbol, decl),
+			Synopsis: doc.Synopsis(comment),
+			URL:      url(d.Path, symbol),
+			Score:    score,
+		})
+	}
+	for _, d := range docs {
+		match(d, "", "package "+d.Name, d.Doc)
+		for symbol, s := range d.Symbols {
+			match(d, symbol, s.Decl, s.Doc)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if len(a.Symbol) != len(b.Symbol) {
+			return len(a.Symbol) < len(b.Symbol)
+		}
+		if len(a.Package) != len(b.Package) {
+			return len(a.Package) < len(b.Package)
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.Symbol < b.Symbol
+	})
+	return results
+}
+
+// camelWords returns the words of the camel-case name, in lower case, e.g. read and
+// file for ReadFile.
+func camelWords(name string) map[string]bool {
+	words := map[string]bool{}
+	start := 0
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(name[i-1])) {
+			words[strings.ToLower(name[start:i])] = true
+			start = i
+		}
+	}
+	words[strings.ToLower(name[start:])] = true
+	return words
+}
+
+// declLine returns the line of decl declaring name, like the index of go doc: its first
+// line, without the brace opening the body of a type, or the line of its group naming
+// name, like `var ErrBareQuote = ...` in var (...).
+func declLine(name, decl string) string {
+	lines := strings.Split(decl, "\n")
+	first := strings.TrimSpace(lines[0])
+	if keyword := strings.TrimSuffix(first, " ("); keyword == "var" || keyword == "const" {
+		for _, line := range lines[1:] {
+			line = strings.TrimSpace(line)
+			if fields := strings.Fields(strings.Replace(line, ",", " ", -1)); len(fields) != 0 && fields[0] == name {
+				return keyword + " " + line
+			}
+		}
+	}
+	return strings.TrimSuffix(first, " {")
+}
+
+// searchSources returns the sources of the documentation %search looks in: the
+// standard library, the packages of the modules required in the session, and the
+// packages imported in the session.
+func (kernel *Kernel) searchSources() []docSource {
+	var sources []docSource
+	seen := map[string]bool{}
+	add := func(src docSource, ok bool) {
+		if ok && !seen[src.Path] {
+			seen[src.Path] = true
+			sources = append(sources, src)
+		}
+	}
+	for _, pkg := range stdLibrary() {
+		add(kernel.docSource(pkg))
+	}
+	if r := kernel.session.requirements; r != nil {
+		for _, pin := range r.pins {
+			root, ok := moduleDocSource(pin.Module, "", pin.Module, pin.Version)
+			if !ok {
+				continue
+			}
+			for _, rel := range packageDirs(root.Dir) {
+				add(moduleDocSource(path.Join(pin.Module, rel), "/"+rel, pin.Module, pin.Version))
+			}
+		}
+	}
+	_, imports := kernel.session.declaredNames()
+	paths := make([]string, 0, len(imports))
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		add(kernel.docSource(p))
+	}
+	return sources
+}
+
+var (
+	stdLibraryOnce     sync.Once
+	stdLibraryPackages []string
+)
+
+// stdLibrary returns the import paths of the packages of the standard library,
+// without the internal ones.
+func stdLibrary() []string {
+	stdLibraryOnce.Do(func() {
+		if root := goRoot(); root != "" {
+			stdLibraryPackages = packageDirs(filepath.Join(root, "src"))
+		}
+	})
+	return stdLibraryPackages
+}
+
+// packageDirs returns root and the directories below it, as slash-separated paths
+// relative to root, holding the Go files of a package that can be imported from
+// outside of root.
+func packageDirs(root string) []string {
+	var dirs []string
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if p != root && (name == "internal" || name == "vendor" || name == "testdata" || name == "cmd" ||
+			strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		if hasGoFiles(p) {
+			if rel, err := filepath.Rel(root, p); err == nil {
+				dirs = append(dirs, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	return dirs
+}
+
+// hasGoFiles reports whether dir holds Go files other than tests.
+func hasGoFiles(dir string) bool {
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".go") && !strings.HasSuffix(f.Name(), "_test.go") {
+			return true
+		}
+	}
+	return false
+}
+
+// pkgGoDevURL returns the link to the documentation of symbol of the package of src on
+// pkg.go.dev, or to that of the package if symbol is "".
+func pkgGoDevURL(src docSource, symbol string) string {
+	url := pkgGoDev + "/" + src.Path
+	if strings.Contains(strings.SplitN(src.Path, "/", 2)[0], ".") && !src.Local && src.Version != "" {
+		url += "@" + src.Version
+	}
+	if symbol != "" {
+		url += "#" + symbol
+	}
+	return url
+}
+
+// evalSearchMagic implements `%search words...`, which lists the names of the
+// documentation matching all the words.
+func evalSearchMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := searchSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(parsed.args) == 0 {
+		return searchSyntax.errorf("no words to search")
+	}
+	n := 10
+	if v, ok := parsed.options["n"]; ok {
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			return searchSyntax.errorf("invalid number of results %q", v)
+		}
+	}
+
+	var docs []*packageDocs
+	sources := map[string]docSource{}
+	for _, src := range kernel.searchSources() {
+		// Packages without sources for the platform of the kernel have no documentation.
+		if d, err := packageDocCache.lookup(src); err == nil {
+			docs = append(docs, d)
+			sources[d.Path] = src
+		}
+	}
+	results := searchDocs(docs, parsed.args, func(pkg, symbol string) string {
+		return pkgGoDevURL(sources[pkg], symbol)
+	})
+	if len(results) == 0 {
+		fmt.Fprintf(outerr.out, "Nothing matches %s.\n", strings.Join(parsed.args, " "))
+		return nil
+	}
+	if len(results) > n {
+		results = results[:n]
+	}
+
+	var md, text strings.Builder
+	for i, r := range results {
+		name := r.Package
+		if r.Symbol != "" {
+			name = r.Name + "." + r.Symbol
+		}
+		fmt.Fprintf(&md, "%d. [`%s`](%s)", i+1, name, r.URL)
+		if r.Symbol != "" {
+			fmt.Fprintf(&md, " in `%s`", r.Package)
+		}
+		fmt.Fprintf(&text, "%s\n    %s\n", name, r.Decl)
+		md.WriteString("  \n   `" + r.Decl + "`")
+		if r.Synopsis != "" {
+			md.WriteString("  \n   " + r.Synopsis)
+			text.WriteString("    " + r.Synopsis + "\n")
+		}
+		md.WriteString("\n")
+	}
+	kernel.display(outerr, MakeData3(MIMETypeMarkdown, text.String(), md.String()))
+	return nil
+}
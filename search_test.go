@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSearch tests ranking the names of the documentation matching words.
+func TestSearch(t *testing.T) {
+	docs := []*packageDocs{
+		{Path: "encoding/json", Name: "json", Doc: "Package json implements encoding and decoding of JSON.", Symbols: map[string]symbolDoc{
+			"Marshal":        {Decl: "func Marshal(v any) ([]byte, error)", Doc: "Marshal returns the JSON encoding of v.\n\nMore."},
+			"MarshalIndent":  {Decl: "func MarshalIndent(v any, prefix, indent string) ([]byte, error)", Doc: "MarshalIndent is like Marshal but applies Indent."},
+			"Marshaler":      {Decl: "type Marshaler interface {\n\tMarshalJSON() ([]byte, error)\n}", Doc: "Marshaler is the interface implemented by types that can marshal themselves into valid JSON."},
+			"Unmarshal":      {Decl: "func Unmarshal(data []byte, v any) error", Doc: "Unmarshal parses the JSON-encoded data."},
+			"Encoder.Encode": {Decl: "func (enc *Encoder) Encode(v any) error", Doc: "Encode writes the JSON encoding of v to the stream."},
+		}},
+		{Path: "encoding/xml", Name: "xml", Doc: "Package xml implements a simple XML 1.0 parser.", Symbols: map[string]symbolDoc{
+			"Marshal": {Decl: "func Marshal(v any) ([]byte, error)", Doc: "Marshal returns the XML encoding of v."},
+		}},
+	}
+	url := func(pkg, symbol string) string { return "https://pkg.go.dev/" + pkg + "#" + symbol }
+	cases := []struct {
+		words string
+		want  []string
+	}{
+		{"json marshal", []string{"json.Marshal", "json.MarshalIndent", "json.Marshaler", "json.Unmarshal"}},
+		{"MARSHAL xml", []string{"xml.Marshal"}},
+		{"encode stream", []string{"json.Encoder.Encode"}},
+		{"json", []string{"encoding/json"}},
+		{"yaml", nil},
+	}
+
+	t.Logf("Should rank the names matching all the words, those matching by name first.")
+
+	for _, tc := range cases {
+		results := searchDocs(docs, strings.Fields(tc.words), url)
+		var got []string
+		for _, r := range results {
+			if r.Symbol == "" {
+				got = append(got, r.Package)
+			} else {
+				got = append(got, r.Name+"."+r.Symbol)
+			}
+		}
+		if len(got) > len(tc.want) {
+			got = got[:len(tc.want)]
+		}
+		if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Fatalf("\t%s Expected %q for %q, got %q.", failure, tc.want, tc.words, got)
+		}
+		t.Logf("\t%s %s: %q.", success, tc.words, got)
+	}
+
+	t.Logf("Should show the declarations, the first sentences and the links of the results.")
+
+	r := searchDocs(docs, []string{"json", "marshal"}, url)[0]
+	if r.Decl != "func Marshal(v any) ([]byte, error)" || r.Synopsis != "Marshal returns the JSON encoding of v." || r.URL != "https://pkg.go.dev/encoding/json#Marshal" {
+		t.Fatalf("\t%s Unexpected result %+v.", failure, r)
+	}
+	if got := declLine("ErrFieldCount", "var (\n\tErrBareQuote  = errors.New(\"bare quote\")\n\tErrFieldCount = errors.New(\"wrong number of fields\")\n)"); got != `var ErrFieldCount = errors.New("wrong number of fields")` {
+		t.Fatalf("\t%s Unexpected declaration %s.", failure, got)
+	}
+	if got := pkgGoDevURL(docSource{Path: "github.com/google/uuid", Version: "v1.3.0"}, "New"); got != "https://pkg.go.dev/github.com/google/uuid@v1.3.0#New" {
+		t.Fatalf("\t%s Unexpected link %s.", failure, got)
+	}
+	t.Logf("\t%s Showed %+v.", success, r)
+}
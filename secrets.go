@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goplus/gop"
+	"golang.org/x/xerrors"
+)
+
+// SecretProviderConfig configures a source of secrets for secrets.Get. Providers are
+// asked in the order they are configured until one of them knows the secret.
+type SecretProviderConfig struct {
+	// Type is one of "env", "file", "vault" or "aws".
+	Type string `json:"type"`
+
+	// Prefix is prepended to the name of a secret to form the environment variable
+	// read by the "env" provider.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Path is the JSON file mapping names to values for the "file" provider, or the
+	// path of the secret holding the values under Mount for the "vault" provider.
+	Path string `json:"path,omitempty"`
+
+	// Address, Token and Mount locate a HashiCorp Vault KV version 2 secrets engine.
+	// Address and Token default to $VAULT_ADDR and $VAULT_TOKEN, Mount to "secret".
+	Address string `json:"address,omitempty"`
+	Token   string `json:"token,omitempty"`
+	Mount   string `json:"mount,omitempty"`
+
+	// Region and Profile select the AWS Secrets Manager region and credentials
+	// profile. The "aws" provider runs the aws command-line tool.
+	Region  string `json:"region,omitempty"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// secretProvider looks up secrets by name. ok is false if the provider does not know
+// the secret.
+type secretProvider interface {
+	lookup(name string) (value string, ok bool, err error)
+}
+
+// secretStore reads secrets from the configured providers and remembers the values it
+// handed out, so that they can be masked in the output of the kernel.
+type secretStore struct {
+	providers []secretProvider
+
+	mu       sync.Mutex
+	revealed map[string]bool
+}
+
+func newSecretStore(configs []SecretProviderConfig) (*secretStore, error) {
+	store := &secretStore{revealed: make(map[string]bool)}
+	if len(configs) == 0 {
+		configs = []SecretProviderConfig{{Type: "env"}}
+	}
+
+	for _, config := range configs {
+		var provider secretProvider
+		switch config.Type {
+		case "env":
+			provider = envSecrets{config.Prefix}
+		case "file":
+			if config.Path == "" {
+				return nil, xerrors.New("the file secrets provider needs a path")
+			}
+			provider = fileSecrets{config.Path}
+		case "vault":
+			provider = newVaultSecrets(config)
+		case "aws":
+			provider = awsSecrets{config.Region, config.Profile}
+		default:
+			return nil, xerrors.Errorf("unknown secrets provider %q", config.Type)
+		}
+		store.providers = append(store.providers, provider)
+	}
+	return store, nil
+}
+
+// get returns the secret called name from the first provider that has it.
+func (store *secretStore) get(name string) (string, error) {
+	for _, provider := range store.providers {
+		value, ok, err := provider.lookup(name)
+		if err != nil {
+			return "", xerrors.Errorf("reading secret %s: %w", name, err)
+		}
+		if ok {
+			store.mu.Lock()
+			if value != "" {
+				store.revealed[value] = true
+			}
+			store.mu.Unlock()
+			return value, nil
+		}
+	}
+	return "", xerrors.Errorf("secret %s not found", name)
+}
+
+// mask replaces the values of the secrets read so far in s.
+func (store *secretStore) mask(s string) string {
+	if store == nil {
+		return s
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for value := range store.revealed {
+		s = strings.Replace(s, value, redactionMask, -1)
+	}
+	return s
+}
+
+// longest returns the length of the longest value of the secrets read so far.
+func (store *secretStore) longest() int {
+	if store == nil {
+		return 0
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	n := 0
+	for value := range store.revealed {
+		if len(value) > n {
+			n = len(value)
+		}
+	}
+	return n
+}
+
+// envSecrets reads secrets from environment variables.
+type envSecrets struct {
+	prefix string
+}
+
+func (p envSecrets) lookup(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(p.prefix + name)
+	return value, ok, nil
+}
+
+// fileSecrets reads secrets from a JSON object mapping names to values. The file is
+// read on every lookup, so it may be updated while the kernel runs.
+type fileSecrets struct {
+	path string
+}
+
+func (p fileSecrets) lookup(name string) (string, bool, error) {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return "", false, err
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return "", false, xerrors.Errorf("parsing %s: %w", p.path, err)
+	}
+	value, ok := values[name]
+	return value, ok, nil
+}
+
+// vaultSecrets reads the keys of a secret stored in a HashiCorp Vault KV version 2
+// secrets engine.
+type vaultSecrets struct {
+	address, token, mount, path string
+}
+
+func newVaultSecrets(config SecretProviderConfig) vaultSecrets {
+	p := vaultSecrets{config.Address, config.Token, config.Mount, config.Path}
+	if p.address == "" {
+		p.address = os.Getenv("VAULT_ADDR")
+	}
+	if p.token == "" {
+		p.token = os.Getenv("VAULT_TOKEN")
+	}
+	if p.mount == "" {
+		p.mount = "secret"
+	}
+	return p
+}
+
+func (p vaultSecrets) lookup(name string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	url := strings.TrimRight(p.address, "/") + "/v1/" + p.mount + "/data/" + strings.Trim(p.path, "/")
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", p.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", false, xerrors.Errorf("vault replied %s", resp.Status)
+	}
+
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", false, xerrors.Errorf("parsing vault reply: %w", err)
+	}
+	value, ok := secret.Data.Data[name]
+	if !ok {
+		return "", false, nil
+	}
+	return fmt.Sprint(value), true, nil
+}
+
+// awsSecrets reads secrets from AWS Secrets Manager using the aws command-line tool,
+// which takes care of credentials the same way as for the user's shell.
+type awsSecrets struct {
+	region, profile string
+}
+
+func (p awsSecrets) lookup(name string) (string, bool, error) {
+	args := []string{"secretsmanager", "get-secret-value", "--secret-id", name,
+		"--query", "SecretString", "--output", "text"}
+	if p.region != "" {
+		args = append(args, "--region", p.region)
+	}
+	if p.profile != "" {
+		args = append(args, "--profile", p.profile)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("aws", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "ResourceNotFoundException") {
+			return "", false, nil
+		}
+		return "", false, xerrors.Errorf("aws secretsmanager: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSuffix(string(out), "\n"), true, nil
+}
+
+// secretsGet implements secrets.Get. Like shell commands, it reports errors by
+// panicking, which fails the cell.
+func secretsGet(name string) string {
+	value, err := activeSession.secrets.get(name)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func execSecretsGet(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, secretsGet(args[0].(string)))
+}
+
+// secretsPackage is the Go+ package providing secrets.Get to the cells.
+var secretsPackage = gop.NewGoPackage("secrets")
+
+func init() {
+	secretsPackage.RegisterFuncs(
+		secretsPackage.Func("Get", secretsGet, execSecretsGet),
+	)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSecretStore tests that secrets are read from the providers in order and masked
+// once they have been read.
+func TestSecretStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secrets.json")
+	if err := ioutil.WriteFile(path, []byte(`{"db_password": "s3cret", "token": "from-file"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GOPYTER_TEST_token", "from-env")
+	defer os.Unsetenv("GOPYTER_TEST_token")
+
+	store, err := newSecretStore([]SecretProviderConfig{
+		{Type: "env", Prefix: "GOPYTER_TEST_"},
+		{Type: "file", Path: path},
+	})
+	if err != nil {
+		t.Fatalf("\t%s Creating the store failed: %v.", failure, err)
+	}
+
+	t.Logf("Should read secrets from the first provider that has them.")
+
+	if got, err := store.get("token"); err != nil || got != "from-env" {
+		t.Fatalf("\t%s get(token) = %q, %v, expected from-env.", failure, got, err)
+	}
+	if got, err := store.get("db_password"); err != nil || got != "s3cret" {
+		t.Fatalf("\t%s get(db_password) = %q, %v, expected s3cret.", failure, got, err)
+	}
+	if _, err := store.get("missing"); err == nil {
+		t.Fatalf("\t%s Expected an error for a missing secret.", failure)
+	}
+	t.Logf("\t%s Read the expected secrets.", success)
+
+	t.Logf("Should mask the secrets that were read.")
+
+	if got := store.mask("password=s3cret token=from-env other=from-file"); got != "password=******** token=******** other=from-file" {
+		t.Fatalf("\t%s Unexpected masked output %q.", failure, got)
+	}
+	t.Logf("\t%s Masked the secrets.", success)
+
+	if _, err := newSecretStore([]SecretProviderConfig{{Type: "keyring"}}); err == nil {
+		t.Fatalf("\t%s Expected an error for an unknown provider.", failure)
+	}
+}
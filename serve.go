@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	lineMagics["serve"] = evalServeMagic
+	documentMagic(serveSyntax)
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("ServeURL", serveURL, execServeURL),
+	)
+}
+
+// fileServer serves the files of the working directory of the kernel over HTTP, so that
+// outputs can reference large artifacts by URL instead of embedding them in the
+// notebook. It is nil until started with %serve start.
+var fileServer *staticServer
+
+// staticServer serves the files below root at URLs starting with a random token, so
+// that other users of the host cannot guess them. It serves neither directory listings
+// nor hidden files, nor files outside of root or the sandbox roots.
+type staticServer struct {
+	root     string
+	token    string
+	listener net.Listener
+	server   *http.Server
+}
+
+// startFileServer starts serving the files below root on addr, which must be a
+// loopback address unless public is set.
+func startFileServer(root, addr string, public bool) (*staticServer, error) {
+	if !public && !isLoopback(addr) {
+		return nil, fmt.Errorf("%s is not a loopback address, use --public to serve files on it", addr)
+	}
+	root, err := resolvePath(root)
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &staticServer{root: root, token: hex.EncodeToString(token), listener: listener}
+	s.server = &http.Server{Handler: http.HandlerFunc(s.serveFile)}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error serving files: %v\n", err)
+		}
+	}()
+	return s, nil
+}
+
+// isLoopback reports whether addr, as host:port, only listens on the loopback
+// interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serveFile serves the file of the request, answering 404 Not Found for every path it
+// refuses so that they cannot be told apart.
+func (s *staticServer) serveFile(w http.ResponseWriter, r *http.Request) {
+	prefix := "/" + s.token + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	rel := strings.TrimPrefix(r.URL.Path, prefix)
+	for _, name := range strings.Split(rel, "/") {
+		if strings.HasPrefix(name, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	path, err := resolvePath(filepath.Join(s.root, filepath.FromSlash(rel)))
+	if err != nil || !strings.HasPrefix(path, s.root+string(filepath.Separator)) ||
+		(sandboxed && !inSandbox(path)) || isConnectionFile(path) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// url returns the URL of the file at path, relative to the root of the server or
+// absolute within it.
+func (s *staticServer) url(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s is not below the served directory %s", path, s.root)
+		}
+		path = rel
+	}
+	u := url.URL{Scheme: "http", Host: s.listener.Addr().String(), Path: "/" + s.token + "/" + filepath.ToSlash(filepath.Clean(path))}
+	return u.String(), nil
+}
+
+func (s *staticServer) String() string {
+	return fmt.Sprintf("Serving %s at http://%s/%s/", s.root, s.listener.Addr(), s.token)
+}
+
+var serveSyntax = &magicSyntax{
+	name:  "%serve",
+	usage: []string{"start [--public] [addr]", "stop", ""},
+	doc: "Starts or stops an HTTP server of the files of the working directory, or reports " +
+		"on it. Use `ServeURL(path)` to get the URL of a file. The URLs start with a random " +
+		"token; hidden files and directory listings are not served.",
+	args: []magicParam{
+		{name: "start|stop", help: "starts or stops the server", optional: true},
+		{name: "addr", help: "the address to listen on, a free port of localhost by default", optional: true},
+	},
+	options: []magicParam{
+		{name: "public", help: "allow listening on addresses other hosts can reach", isSwitch: true},
+	},
+}
+
+// evalServeMagic implements
+//
+//	%serve start [--public] [addr]
+//	%serve stop
+//	%serve
+//
+// which starts, stops or reports on the server of the files of the working directory.
+// The server listens on a free port of localhost unless given an address, which must be
+// a loopback address unless --public is given.
+func evalServeMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := serveSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	command, addr := parsed.arg(0), parsed.arg(1)
+	switch command {
+	case "start":
+		if fileServer != nil {
+			return fmt.Errorf("%%serve: already serving files at http://%s/", fileServer.listener.Addr())
+		}
+		if addr == "" {
+			addr = "127.0.0.1:0"
+		}
+		root, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("%%serve: %v", err)
+		}
+		if sandboxed && !inSandbox(root) {
+			return xerrors.Errorf("%%serve: %s: %w", root, errSandboxed)
+		}
+		if fileServer, err = startFileServer(root, addr, parsed.options["public"] == "true"); err != nil {
+			return fmt.Errorf("%%serve: %v", err)
+		}
+	case "stop":
+		if fileServer == nil {
+			return fmt.Errorf("%%serve: not serving files")
+		}
+		err := fileServer.server.Close()
+		fileServer = nil
+		if err != nil {
+			return fmt.Errorf("%%serve: %v", err)
+		}
+		fmt.Fprintln(outerr.out, "Stopped serving files.")
+		return nil
+	case "":
+	default:
+		return serveSyntax.errorf("expected start or stop, got %q", command)
+	}
+
+	if fileServer == nil {
+		fmt.Fprintln(outerr.out, "Not serving files.")
+	} else {
+		fmt.Fprintln(outerr.out, fileServer)
+	}
+	return nil
+}
+
+// serveURL returns the URL at which the file server serves the file at path. Like the
+// other builtins it reports errors by panicking, which fails the cell.
+func serveURL(path string) string {
+	if fileServer == nil {
+		panic(fmt.Errorf("ServeURL: not serving files, start the server with %%serve start"))
+	}
+	u, err := fileServer.url(path)
+	if err != nil {
+		panic(fmt.Errorf("ServeURL: %v", err))
+	}
+	return u
+}
+
+func execServeURL(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, serveURL(args[0].(string)))
+}
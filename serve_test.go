@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestServe tests serving the files of the working directory.
+func TestServe(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var out bytes.Buffer
+	outerr := OutErr{&out, ioutil.Discard}
+
+	if _, err := kernel.session.Eval(`ServeURL("README.md")`); err == nil || !strings.Contains(err.Error(), "%serve start") {
+		t.Fatalf("\t%s Expected ServeURL to fail before the server starts, got %v.", failure, err)
+	}
+
+	t.Logf("Should serve the files of the working directory at the URLs of ServeURL.")
+
+	if _, err := kernel.doEvalGop(outerr, "%serve start"); err != nil {
+		t.Fatalf("\t%s Starting the server failed: %v.", failure, err)
+	}
+	defer kernel.doEvalGop(outerr, "%serve stop")
+	if !strings.HasPrefix(out.String(), "Serving ") {
+		t.Fatalf("\t%s Expected the server to be reported, got %q.", failure, out.String())
+	}
+
+	vals, err := kernel.session.Eval(`ServeURL("README.md")`)
+	if err != nil {
+		t.Fatalf("\t%s ServeURL failed: %v.", failure, err)
+	}
+	resp, err := http.Get(vals[0].(string))
+	if err != nil {
+		t.Fatalf("\t%s Getting %s failed: %v.", failure, vals[0], err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	want, _ := ioutil.ReadFile("README.md")
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, want) {
+		t.Fatalf("\t%s Expected the file at %s, got %s.", failure, vals[0], resp.Status)
+	}
+	t.Logf("\t%s Served %s.", success, vals[0])
+
+	if _, err := kernel.session.Eval(`ServeURL("/etc/passwd")`); err == nil {
+		t.Fatalf("\t%s Expected ServeURL to refuse files outside of the directory.", failure)
+	}
+
+	t.Logf("Should not serve the URLs without the token, hidden files, directories and the connection file.")
+
+	token := "/" + fileServer.token + "/"
+	base := "http://" + fileServer.listener.Addr().String()
+	for _, path := range []string{"/README.md", token + ".git/HEAD", token + "fixtures/connection_file.json", token + "fixtures/", token, token + "../go.mod"} {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("\t%s Getting %s failed: %v.", failure, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("\t%s Expected %s not to be served, got %s.", failure, path, resp.Status)
+		}
+	}
+	t.Logf("\t%s Did not serve them.", success)
+
+	t.Logf("Should only listen on other addresses than loopback ones when asked to.")
+
+	if _, err := startFileServer(".", "0.0.0.0:0", false); err == nil || !strings.Contains(err.Error(), "--public") {
+		t.Fatalf("\t%s Expected the address to be refused, got %v.", failure, err)
+	}
+	t.Logf("\t%s Refused it.", success)
+}
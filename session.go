@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/cl"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/scanner"
+	"github.com/goplus/gop/token"
+
+	exec "github.com/goplus/gop/exec/bytecode"
+)
+
+// Session evaluates cells with the Go+ compiler and bytecode interpreter. Like repl.REPL
+// it compiles the source of all earlier cells together with each new cell and executes
+// only the new code, but it hands the result values and errors back to the caller
+// instead of printing them.
+type Session struct {
+	imports  string        // the imports of the cells evaluated so far
+	decls    string        // the declarations of functions and types the cells start with
+	src      string        // the statements of the cells evaluated so far
+	ctx      *exec.Context // the context after the last execution
+	ip       int           // the instruction pointer after the last execution
+	history  *history
+	deps     *depGraph
+	secrets  *secretStore
+	redactor *redactor
+	span     *span      // traces the request being evaluated, if any
+	display  func(Data) // shows data in the output of the cell being evaluated, if any
+
+	// updateDisplay replaces the data shown with the display_id of its transient data.
+	updateDisplay func(Data)
+
+	// input asks the user of the front-end for a line of input, if it allows it.
+	input func(prompt string, password bool) (string, error)
+
+	checkpoints    map[string]sessionState
+	expectFailures []string          // the expectations that failed in the cell being evaluated
+	payloads       []interface{}     // the payloads for the reply to the cell being evaluated
+	memSample      *memSample        // the memory usage at the last %memstats, if any
+	python         *pythonProcess    // the interpreter of the %%python cells, once started
+	grpc           *grpcRegistry     // the methods loaded by %grpc load, if any
+	openAPI        *openAPIRegistry  // the operations loaded by %openapi, if any
+	preload        string            // the imports of the optional packages preloaded by the config
+	kubeContext    string            // the Kubernetes context set by %kubectx, if any
+	requirements   *requirements     // the packages required with %require, if any
+	watches        *watchTable       // the expressions watched with %watch, if any
+	traces         *varTracer        // the variables traced with %trace var, if any
+	renderers      []*customRenderer // the renderers registered with render.Register
+}
+
+// sessionPackages are the packages of the kernel that cells use without importing them,
+// like secrets.Get, the expect assertions, render.Register, magic.RegisterLine,
+// arrowipc, grpcx, openapi, mq and the Go+ standard packages. A package is imported by
+// the first cell referring to it, so that the cells that do not are free to use its name.
+var sessionPackages = []string{
+	"secrets", "expect", "render", "magic", "arrowipc", "grpcx", "openapi", "mq", "gop/osx", "gop/stringx",
+}
+
+// activeSession is the session currently evaluating a cell. It is used by the builtins
+// that give cells access to the state of their session.
+var activeSession *Session
+
+// NewSession creates an empty session. It reads secrets from environment variables and
+// redacts the secrets it read from the history.
+func NewSession() *Session {
+	secrets, _ := newSecretStore(nil)
+	s := &Session{history: newHistory(), deps: newDepGraph(), secrets: secrets, redactor: newRedactor()}
+	s.redactor.addFunc(func(v string) string { return s.secrets.mask(v) })
+	s.redactor.addMatchLen(func() int { return s.secrets.longest() })
+	s.history.mask = s.redactor.redact
+	return s
+}
+
+// resetInterpreter forgets the source and the context of the interpreter, and the state
+// of the cell being evaluated, so that the next cell starts in a fresh interpreter.
+func (s *Session) resetInterpreter() {
+	s.imports, s.decls, s.src, s.ctx, s.ip = "", "", "", nil, 0
+	s.span, s.display, s.updateDisplay, s.input = nil, nil, nil, nil
+	s.expectFailures, s.payloads, s.python = nil, nil, nil
+	s.renderers = nil
+}
+
+// Eval evaluates a cell and returns the values its last expression left behind. If the
+// cell fails to compile or panics, the session is left as it was before the cell.
+func (s *Session) Eval(code string) ([]interface{}, error) {
+	return s.eval(code, true)
+}
+
+// Peek evaluates an expression against the session, like Eval, but does not keep the
+// code or its effects on the session. It is meant for reading the value of variables.
+func (s *Session) Peek(expr string) ([]interface{}, error) {
+	return s.eval(expr, false)
+}
+
+func (s *Session) eval(code string, commit bool) (vals []interface{}, err error) {
+	activeSession = s
+
+	if s.src == "" {
+		sourceLock.Lock()
+		s.imports, s.src = s.preload, sessionPrelude
+		sourceLock.Unlock()
+	}
+
+	// Go+ only accepts imports before the first statement, so the imports of every
+	// cell are moved in front of the code of all cells. So are the declarations of
+	// functions and types, which Go+ only accepts at the top level.
+	imports, code := splitImports(code)
+	if imports != "" {
+		imports = s.imports + imports + "\n"
+	} else {
+		imports = s.imports
+	}
+	cellDecls, code := splitDecls(code)
+	decls := s.decls
+	if cellDecls != "" {
+		decls += cellDecls + "\n"
+	}
+
+	src := s.src + code + "\n"
+	imports += packageImports(imports+decls+src, cellDecls+code)
+	defer func() {
+		if r := recover(); r != nil {
+			vals = nil
+			var ok bool
+			if err, ok = r.(error); !ok {
+				err = errors.New(fmt.Sprint(r))
+			}
+		}
+		if err == nil && commit {
+			sourceLock.Lock()
+			s.imports, s.decls, s.src = imports, decls, src
+			sourceLock.Unlock()
+		}
+	}()
+
+	prog, err := s.compile(imports + decls + src)
+	if prog == nil {
+		return nil, err
+	}
+
+	ctx := exec.NewContext(prog)
+	if s.ctx != nil {
+		s.ctx.CloneSetVarScope(ctx)
+	}
+	execute := startSpan(s.span, "gop execute")
+	defer execute.end()
+	currentIP := ctx.Exec(s.ip, prog.Len())
+	if commit {
+		s.ctx = ctx
+		// "currentIP - 1" is the index of the final `return`, which the code of the
+		// next cell will replace.
+		s.ip = currentIP - 1
+	}
+
+	n := ctx.Len()
+	for i := 0; i < n; i++ {
+		vals = append(vals, ctx.Get(i-n))
+	}
+	return vals, nil
+}
+
+// compile parses and compiles the source of a session. It returns a nil program and
+// error if the source only holds declarations, so there is nothing to execute.
+func (s *Session) compile(source string) (*exec.Code, error) {
+	parse := startSpan(s.span, "gop parse")
+	fset := token.NewFileSet()
+	pkgs, err := parser.Parse(fset, "", source, 0)
+	parse.setError(err)
+	parse.end()
+	if err != nil {
+		return nil, err
+	}
+
+	// Compiling also resolves the imported packages.
+	compile := startSpan(s.span, "gop compile")
+	defer compile.end()
+	cl.CallBuiltinOp = exec.CallBuiltinOp
+	b := exec.NewBuilder(nil)
+	if _, err = cl.NewPackage(b.Interface(), pkgs["main"], fset, cl.PkgActClMain); err != nil {
+		if err == cl.ErrMainFuncNotFound {
+			// The cells only hold declarations, there is nothing to execute yet.
+			return nil, nil
+		}
+		compile.setError(err)
+		return nil, err
+	}
+	prog := b.Resolve()
+	compile.setAttribute("gop.instructions", prog.Len())
+	return prog, nil
+}
+
+// splitImports splits code into the import declarations it starts with and the code
+// following them.
+func splitImports(code string) (imports, rest string) {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(code))
+	s.Init(file, []byte(code), nil, 0)
+
+	end := 0
+	for {
+		_, tok, _ := s.Scan()
+		if tok == token.SEMICOLON {
+			continue
+		}
+		if tok != token.IMPORT {
+			break
+		}
+
+		pos, tok, lit := s.Scan()
+		if tok == token.LPAREN {
+			for tok != token.RPAREN {
+				if tok == token.EOF {
+					return "", code
+				}
+				pos, tok, lit = s.Scan()
+			}
+			end = file.Offset(pos) + 1
+			continue
+		}
+		if tok == token.IDENT || tok == token.PERIOD {
+			pos, tok, lit = s.Scan()
+		}
+		if tok != token.STRING {
+			// Leave the invalid import to the compiler.
+			return "", code
+		}
+		end = file.Offset(pos) + len(lit)
+	}
+	return code[:end], code[end:]
+}
+
+// splitDecls splits code into the declarations of functions, methods and types it
+// starts with and the code following them. A function literal, like func() {...}(),
+// starts a statement.
+func splitDecls(code string) (decls, rest string) {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(code))
+	s.Init(file, []byte(code), nil, 0)
+
+	end := 0
+	for {
+		_, tok, _ := s.Scan()
+		if tok == token.SEMICOLON {
+			continue
+		}
+		depth := 0
+		switch tok {
+		case token.TYPE:
+		case token.FUNC:
+			_, tok, _ = s.Scan()
+			if tok == token.LPAREN {
+				// The receiver of a method is followed by its name and parameters, the
+				// parameters of a function literal by its results or body.
+				if !skipBrackets(&s) {
+					return "", code
+				}
+				if _, tok, _ = s.Scan(); tok != token.IDENT {
+					return code[:end], code[end:]
+				}
+				if _, tok, _ = s.Scan(); tok != token.LPAREN {
+					return code[:end], code[end:]
+				}
+				depth++
+			} else if tok != token.IDENT {
+				return code[:end], code[end:]
+			}
+		default:
+			return code[:end], code[end:]
+		}
+
+		// The declaration ends with the first semicolon outside of brackets.
+		for {
+			pos, tok, _ := s.Scan()
+			switch tok {
+			case token.LPAREN, token.LBRACK, token.LBRACE:
+				depth++
+			case token.RPAREN, token.RBRACK, token.RBRACE:
+				depth--
+			case token.EOF:
+				// Leave the incomplete declaration to the compiler.
+				return "", code
+			}
+			if tok == token.SEMICOLON && depth == 0 {
+				if end = file.Offset(pos) + 1; end > len(code) {
+					end = len(code)
+				}
+				break
+			}
+		}
+	}
+}
+
+// skipBrackets scans the tokens up to the bracket closing the one just scanned. It
+// returns false if the code ends first.
+func skipBrackets(s *scanner.Scanner) bool {
+	for depth := 1; depth > 0; {
+		_, tok, _ := s.Scan()
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.EOF:
+			return false
+		}
+	}
+	return true
+}
+
+// packageImports returns the imports of the session packages that code refers to, and
+// that source, the source of the session evaluating code, neither imports nor declares
+// a name for.
+func packageImports(source, code string) string {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(code))
+	s.Init(file, []byte(code), nil, 0)
+
+	// A package is referred to by its name followed by a selector.
+	referred := map[string]bool{}
+	var prev, last token.Token
+	var name string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.PERIOD && last == token.IDENT && prev != token.PERIOD {
+			referred[name] = true
+		}
+		prev, last, name = last, tok, lit
+	}
+	var paths []string
+	for _, p := range sessionPackages {
+		if referred[path.Base(p)] {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return ""
+	}
+
+	c, err := parseCell(source)
+	if err != nil {
+		// Leave the errors to the compiler.
+		return ""
+	}
+	taken := map[string]bool{}
+	c.topLevelIdents(func(ident *ast.Ident) { taken[ident.Name] = true })
+	for _, spec := range c.file.Imports {
+		p, _ := strconv.Unquote(spec.Path.Value)
+		taken[p] = true
+		if spec.Name != nil {
+			taken[spec.Name.Name] = true
+		} else {
+			taken[path.Base(p)] = true
+		}
+	}
+	var imports string
+	for _, p := range paths {
+		if !taken[p] && !taken[path.Base(p)] {
+			imports += "import " + strconv.Quote(p) + "\n"
+		}
+	}
+	return imports
+}
+
+// source returns the source of the cells evaluated so far, as they were typed.
+func (s *Session) source() string {
+	return uninstrumented(s.compiledSource())
+}
+
+// compiledSource returns the source of the cells evaluated so far, as they were
+// compiled. The bytecode of the session is the bytecode of that source.
+func (s *Session) compiledSource() string {
+	return s.imports + s.decls + s.src
+}
+
+// uninstrumented returns src without the calls the kernel instruments the cells with.
+func uninstrumented(src string) string {
+	return traceCalls.ReplaceAllString(strings.Replace(src, interruptCheck, "", -1), "")
+}
+
+// setNextInput asks the front-end to put text in the next cell, or to replace the
+// current cell with it.
+func (s *Session) setNextInput(text string, replace bool) {
+	s.payloads = append(s.payloads, map[string]interface{}{
+		"source":  "set_next_input",
+		"text":    text,
+		"replace": replace,
+	})
+}
+
+// page asks the front-end to show data in its pager, like the help of a magic.
+func (s *Session) page(data MIMEMap) {
+	s.payloads = append(s.payloads, map[string]interface{}{
+		"source": "page",
+		"data":   data,
+		"start":  0,
+	})
+}
+
+// takePayloads returns the payloads added since the last call.
+func (s *Session) takePayloads() []interface{} {
+	payloads := s.payloads
+	s.payloads = nil
+	return payloads
+}
@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+// TestSplitImports tests that the imports at the start of a cell are split off.
+func TestSplitImports(t *testing.T) {
+	cases := []struct {
+		Input   string
+		Imports string
+	}{
+		{"x := 1", ""},
+		{`import "strings"` + "\nx := 1", `import "strings"`},
+		{"// comment\nimport s \"strings\"; import . \"fmt\"\nx", "// comment\nimport s \"strings\"; import . \"fmt\""},
+		{"import (\n\t\"strings\"\n\t\"fmt\"\n)\n", "import (\n\t\"strings\"\n\t\"fmt\"\n)"},
+		{"import (\n\t\"strings\"\n", ""},
+	}
+
+	t.Logf("Should split the imports off cells.")
+
+	for k, tc := range cases {
+		t.Logf("  Splitting cell %d/%d.", k+1, len(cases))
+
+		imports, rest := splitImports(tc.Input)
+		if imports != tc.Imports || imports+rest != tc.Input {
+			t.Errorf("\t%s splitImports(%q) = %q, %q, expected imports %q.", failure, tc.Input, imports, rest, tc.Imports)
+			continue
+		}
+		t.Logf("\t%s Returned the expected imports.", success)
+	}
+}
+
+// TestSplitDecls tests that the declarations of functions and types at the start of a
+// cell are split off.
+func TestSplitDecls(t *testing.T) {
+	cases := []struct {
+		Input string
+		Decls string
+	}{
+		{"x := 1", ""},
+		{"func f() int { return 2 }", "func f() int { return 2 }"},
+		{"func f() int {\n\treturn 2\n}\nf()", "func f() int {\n\treturn 2\n}\n"},
+		{"type P struct{ A int }\nfunc (p P) Get() int { return p.A }\np := P{1}", "type P struct{ A int }\nfunc (p P) Get() int { return p.A }\n"},
+		{"type (\n\tA int\n\tB string\n); var a A", "type (\n\tA int\n\tB string\n);"},
+		{"func() { println(1) }()", ""},
+		{"func(a int) int { return a }(5)", ""},
+		{"func f() {\n", ""},
+	}
+
+	t.Logf("Should split the declarations of functions and types off cells.")
+
+	for k, tc := range cases {
+		t.Logf("  Splitting cell %d/%d.", k+1, len(cases))
+
+		decls, rest := splitDecls(tc.Input)
+		if decls != tc.Decls || decls+rest != tc.Input {
+			t.Errorf("\t%s splitDecls(%q) = %q, %q, expected declarations %q.", failure, tc.Input, decls, rest, tc.Decls)
+			continue
+		}
+		t.Logf("\t%s Returned the expected declarations.", success)
+	}
+}
+
+// TestPackageImports tests that the session packages are imported by the cells referring
+// to them, unless the session took their names.
+func TestPackageImports(t *testing.T) {
+	cases := []struct {
+		source, code string
+		imports      string
+	}{
+		{"x := 1\n", "x := 1", ""},
+		{"expect := 5\n", "expect := 5", ""},
+		{"expect.Equal(1, 1)\n", "expect.Equal(1, 1)", "import \"expect\"\n"},
+		{"v := secrets.Get(\"a\"); osx.Getenv(\"b\")\n", "v := secrets.Get(\"a\"); osx.Getenv(\"b\")", "import \"secrets\"\nimport \"gop/osx\"\n"},
+		{"import \"expect\"\nexpect.True(true)\n", "expect.True(true)", ""},
+		{"type render struct{ X int }\nr := render{}\nr.render.X\n", "r.render.X", ""},
+		{"// render.Register\ns := \"mq.Publish\"\n", "// render.Register\ns := \"mq.Publish\"", ""},
+	}
+
+	t.Logf("Should import the session packages that cells refer to.")
+
+	for _, tc := range cases {
+		if got := packageImports(tc.source, tc.code); got != tc.imports {
+			t.Errorf("\t%s Expected %q for %q, got %q.", failure, tc.imports, tc.code, got)
+			continue
+		}
+		t.Logf("\t%s Imported %q for %q.", success, tc.imports, tc.code)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/token"
+)
+
+// codeSnippet is a template for a common construct, completing the word it is
+// triggered by. Its body has LSP-style placeholders, like ${1:name}, and the final
+// position of the cursor, $0.
+type codeSnippet struct {
+	trigger string
+	doc     string
+	body    string
+}
+
+// codeSnippets are the templates offered to complete keywords.
+var codeSnippets = []codeSnippet{
+	{"func", "function declaration", "func ${1:name}(${2}) {\n\t$0\n}"},
+	{"forr", "for range loop", "for ${1:i}, ${2:v} := range ${3:xs} {\n\t$0\n}"},
+	{"iferr", "error check", "if err != nil {\n\t${1:panic(err)}\n}"},
+}
+
+// snippetPlaceholder matches the placeholders of a snippet, with their default value.
+var snippetPlaceholder = regexp.MustCompile(`\$\{\d+(?::([^}]*))?\}|\$\d+`)
+
+// snippetText returns the text of a snippet template, with the default values of its
+// placeholders.
+func snippetText(body string) string {
+	return snippetPlaceholder.ReplaceAllStringFunc(body, func(p string) string {
+		return snippetPlaceholder.FindStringSubmatch(p)[1]
+	})
+}
+
+// completeSnippets adds to c the snippets completing the identifier of code from
+// c.start to c.end: the templates of constructs whose trigger it starts, and the
+// literals of the struct types declared in the session whose name it starts. The
+// snippets are indented like the line they are inserted in.
+func (kernel *Kernel) completeSnippets(c *completions, code string) {
+	prefix := code[c.start:c.end]
+	lineStart := strings.LastIndexByte(code[:c.start], '\n') + 1
+	line := code[lineStart:c.start]
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	add := func(body, doc string) {
+		body = strings.Replace(body, "\n", "\n"+indent, -1)
+		c.add(snippetText(body), completionType{Type: "snippet", Signature: doc, Snippet: body})
+	}
+
+	for _, s := range codeSnippets {
+		if strings.HasPrefix(s.trigger, prefix) {
+			add(s.body, s.doc)
+		}
+	}
+	for _, t := range kernel.session.structTypes() {
+		if strings.HasPrefix(t.Name.Name, prefix) {
+			add(structLiteral(t), "struct literal")
+		}
+	}
+}
+
+// structTypes returns the declarations of the struct types declared in the session,
+// at the top level or, after statements, in the body of main.
+func (s *Session) structTypes() []*ast.TypeSpec {
+	fset := token.NewFileSet()
+	pkgs, err := parser.Parse(fset, "", s.source(), 0)
+	if err != nil {
+		return nil
+	}
+	var types []*ast.TypeSpec
+	addDecl := func(decl ast.Decl) {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			return
+		}
+		for _, spec := range gen.Specs {
+			if t, ok := spec.(*ast.TypeSpec); ok {
+				if _, ok := t.Type.(*ast.StructType); ok {
+					types = append(types, t)
+				}
+			}
+		}
+	}
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == "main" && fn.Body != nil {
+					for _, stmt := range fn.Body.List {
+						if d, ok := stmt.(*ast.DeclStmt); ok {
+							addDecl(d.Decl)
+						}
+					}
+				}
+				addDecl(decl)
+			}
+		}
+	}
+	return types
+}
+
+// structLiteral returns the template of a literal of the struct type t, setting each
+// field to a placeholder with its zero value.
+func structLiteral(t *ast.TypeSpec) string {
+	var fields []string
+	for _, field := range t.Type.(*ast.StructType).Fields.List {
+		names := field.Names
+		if len(names) == 0 {
+			// An embedded field is named after its type.
+			names = []*ast.Ident{{Name: embeddedName(field.Type)}}
+		}
+		for _, name := range names {
+			fields = append(fields, fmt.Sprintf("%s: ${%d:%s}", name.Name, len(fields)+1, zeroValue(field.Type)))
+		}
+	}
+	return t.Name.Name + "{" + strings.Join(fields, ", ") + "}$0"
+}
+
+// embeddedName returns the name of an embedded field of type typ.
+func embeddedName(typ ast.Expr) string {
+	switch typ := typ.(type) {
+	case *ast.StarExpr:
+		return embeddedName(typ.X)
+	case *ast.SelectorExpr:
+		return typ.Sel.Name
+	case *ast.Ident:
+		return typ.Name
+	}
+	return "_"
+}
+
+// zeroValue returns the zero value of typ, as code. Types named by an identifier are
+// taken for struct types.
+func zeroValue(typ ast.Expr) string {
+	switch typ := typ.(type) {
+	case *ast.Ident:
+		switch typ.Name {
+		case "string":
+			return `""`
+		case "bool":
+			return "false"
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64",
+			"uintptr", "float32", "float64", "complex64", "complex128", "byte", "rune":
+			return "0"
+		case "error":
+			return "nil"
+		}
+		return typ.Name + "{}"
+	case *ast.SelectorExpr:
+		if pkg, ok := typ.X.(*ast.Ident); ok {
+			return fmt.Sprintf("%s.%s{}", pkg.Name, typ.Sel.Name)
+		}
+	case *ast.ArrayType:
+		if typ.Len != nil {
+			// Left for the user to fill in.
+			return ""
+		}
+	}
+	return "nil"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/format"
+	"github.com/goplus/gop/scanner"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	lineMagics["source"] = evalSourceMagic
+	documentMagic(sourceSyntax)
+}
+
+var sourceSyntax = &magicSyntax{
+	name:  "%source",
+	usage: []string{"name"},
+	doc: "Shows the formatted and highlighted source of a function declared by an executed cell, like `double`, " +
+		"of a function literal assigned to a variable, or of a method, like `Point.Move`.",
+	args: []magicParam{
+		{name: "name", help: "the name of the function, or Type.Method for a method"},
+	},
+}
+
+// funcSource finds the source of the function or method called name, like Point.Move,
+// declared by the last executed cell declaring it or, if it is not found in the cells,
+// by the session. It returns the execution count of the cell, or 0.
+func (s *Session) funcSource(name string) (string, int, bool) {
+	g := s.deps
+	for i := len(g.cells) - 1; i >= 0; i-- {
+		if c, err := parseCell(g.cells[i].code); err == nil {
+			if src, ok := c.funcSource(name); ok {
+				return src, g.cells[i].Count, true
+			}
+		}
+	}
+	if c, err := parseCell(s.source()); err == nil {
+		if src, ok := c.funcSource(name); ok {
+			return src, 0, true
+		}
+	}
+	return "", 0, false
+}
+
+// funcSource returns the source of the top-level declaration of the function or method
+// name in the cell, or of the statement assigning a function literal to the variable
+// name.
+func (c *parsedCell) funcSource(name string) (string, bool) {
+	recv, fn := "", name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		recv, fn = name[:i], name[i+1:]
+	}
+	var found ast.Node
+	main := c.main()
+	for _, decl := range c.file.Decls {
+		if d, ok := decl.(*ast.FuncDecl); ok && d != main && d.Name.Name == fn && receiverType(d) == recv {
+			found = d
+		}
+	}
+	if found == nil && recv == "" && main != nil {
+		for _, stmt := range main.Body.List {
+			switch stmt := stmt.(type) {
+			case *ast.AssignStmt:
+				for i, lhs := range stmt.Lhs {
+					if ident, ok := lhs.(*ast.Ident); ok && ident.Name == name && i < len(stmt.Rhs) {
+						if _, ok := stmt.Rhs[i].(*ast.FuncLit); ok {
+							found = stmt
+						}
+					}
+				}
+			case *ast.DeclStmt:
+				if decl, ok := stmt.Decl.(*ast.GenDecl); ok && decl.Tok == token.VAR {
+					for _, spec := range decl.Specs {
+						spec := spec.(*ast.ValueSpec)
+						for i, ident := range spec.Names {
+							if _, ok := valueAt(spec.Values, i).(*ast.FuncLit); ok && ident.Name == name {
+								found = stmt
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if found == nil {
+		return "", false
+	}
+	return c.code[c.offset(found.Pos()):c.offset(found.End())], true
+}
+
+// receiverType returns the name of the type of the receiver of a method, or "" for a
+// function.
+func receiverType(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+	t := fn.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if ident, ok := t.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// valueAt returns the i-th of values, or nil.
+func valueAt(values []ast.Expr, i int) ast.Expr {
+	if i < len(values) {
+		return values[i]
+	}
+	return nil
+}
+
+// highlight colors the tokens of Go+ code for terminals, with ANSI escape sequences,
+// and as HTML, with the colors of the default highlighting style of Jupyter.
+func highlight(code string) (text, markup string) {
+	var t, h strings.Builder
+	fset := token.NewFileSet()
+	var sc scanner.Scanner
+	sc.Init(fset.AddFile("", -1, len(code)), []byte(code), nil, scanner.ScanComments)
+	last := 0
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		start := fset.Position(pos).Offset
+		if start < last || start > len(code) {
+			continue
+		}
+		end := start + len(tok.String())
+		if lit != "" {
+			end = start + len(lit)
+		}
+		if tok == token.SEMICOLON && lit == "\n" || end > len(code) {
+			// The semicolons inserted at the end of lines.
+			continue
+		}
+		ansi, style := "", ""
+		switch {
+		case tok.IsKeyword():
+			ansi, style = "1;32", "color:#008000;font-weight:bold"
+		case tok == token.STRING || tok == token.CHAR:
+			ansi, style = "31", "color:#BA2121"
+		case tok == token.INT || tok == token.FLOAT || tok == token.IMAG || tok == token.RAT:
+			ansi, style = "32", "color:#080"
+		case tok == token.COMMENT:
+			ansi, style = "3;36", "color:#408080;font-style:italic"
+		}
+		t.WriteString(code[last:start])
+		h.WriteString(html.EscapeString(code[last:start]))
+		if ansi == "" {
+			t.WriteString(code[start:end])
+			h.WriteString(html.EscapeString(code[start:end]))
+		} else {
+			t.WriteString("\x1b[" + ansi + "m" + code[start:end] + "\x1b[0m")
+			h.WriteString(`<span style="` + style + `">` + html.EscapeString(code[start:end]) + "</span>")
+		}
+		last = end
+	}
+	t.WriteString(code[last:])
+	h.WriteString(html.EscapeString(code[last:]))
+	return t.String(), "<pre>" + h.String() + "</pre>"
+}
+
+// evalSourceMagic implements `%source name`, which shows the source of a function
+// declared by the session.
+func evalSourceMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := sourceSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	name := parsed.arg(0)
+	src, count, ok := kernel.session.funcSource(name)
+	if !ok {
+		return fmt.Errorf("no function %s is declared by the session", name)
+	}
+	if formatted, err := format.Source([]byte(src)); err == nil {
+		src = strings.TrimSpace(string(formatted))
+	}
+	if count != 0 {
+		src = fmt.Sprintf("// In[%d]\n", count) + src
+	}
+	text, markup := highlight(src)
+	kernel.display(outerr, Data{Data: MIMEMap{MIMETypeText: text, MIMETypeHTML: markup}})
+	return nil
+}
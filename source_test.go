@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFuncSource tests finding the source of the functions declared by the session.
+func TestFuncSource(t *testing.T) {
+	s := NewSession()
+	cells := []string{
+		"func double(x int) int {\n\treturn 2 * x\n}\ntype Point struct{ X int }\nfunc (p *Point) Move(dx int) { p.X += dx }",
+		"%%time\nhalve := func(x int) int { return x / 2 }\nn := halve(4)",
+		"func double(x int) int { return x + x }",
+	}
+	for i, code := range cells {
+		s.deps.record(i+1, "", code)
+	}
+	cases := []struct {
+		name  string
+		want  string
+		count int
+	}{
+		{"double", "func double(x int) int { return x + x }", 3},
+		{"Point.Move", "func (p *Point) Move(dx int) { p.X += dx }", 1},
+		{"halve", "halve := func(x int) int { return x / 2 }", 2},
+		{"n", "", 0},
+		{"Point.Nothing", "", 0},
+	}
+
+	t.Logf("Should find the source of functions, methods and function literals.")
+
+	for _, tc := range cases {
+		src, count, ok := s.funcSource(tc.name)
+		if ok != (tc.want != "") || src != tc.want || count != tc.count {
+			t.Fatalf("\t%s Expected the source of %s to be %q in In[%d], got %q in In[%d].", failure, tc.name, tc.want, tc.count, src, count)
+		}
+		t.Logf("\t%s %s.", success, tc.name)
+	}
+}
+
+// TestHighlight tests highlighting code.
+func TestHighlight(t *testing.T) {
+	text, markup := highlight("// Doubles.\nfunc double(x int) int {\n\treturn 2 * x // \"<\"\n}")
+
+	t.Logf("Should color keywords, literals and comments.")
+
+	wantText := "\x1b[3;36m// Doubles.\x1b[0m\n\x1b[1;32mfunc\x1b[0m double(x int) int {\n\t\x1b[1;32mreturn\x1b[0m \x1b[32m2\x1b[0m * x \x1b[3;36m// \"<\"\x1b[0m\n}"
+	if text != wantText {
+		t.Fatalf("\t%s Expected %q, got %q.", failure, wantText, text)
+	}
+	if !strings.HasPrefix(markup, "<pre>") || !strings.Contains(markup, `<span style="color:#408080;font-style:italic">// &#34;&lt;&#34;</span>`) {
+		t.Fatalf("\t%s Unexpected HTML %q.", failure, markup)
+	}
+	t.Logf("\t%s Highlighted the code.", success)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goplus/gop/scanner"
+	"github.com/goplus/gop/token"
+)
+
+// The kernel can keep usage statistics for the administrators of shared deployments,
+// like JupyterHub: how many cells are executed per day, which packages they import and
+// which kinds of errors they fail with. Nothing is collected unless the configuration
+// enables it, the statistics never include code or output, and the kernel sends them
+// nowhere by itself: it updates a local file, or hands them to a sink an institution
+// registered to route them to its own collector.
+
+// cellStats are the statistics of the execution of a cell.
+type cellStats struct {
+	Time     time.Time
+	Imports  []string // the paths of the packages the cell imports
+	Category string   // the category of its error, see errorCategory; "" if it succeeded
+}
+
+// statsSink receives the statistics of the cells executed by the kernel.
+type statsSink interface {
+	record(cellStats) error
+}
+
+// statsSinks are the sinks the stats field of the configuration can name, as
+// name:target. Builds of the kernel add theirs with registerStatsSink.
+var statsSinks = map[string]func(target string) (statsSink, error){}
+
+// registerStatsSink makes the sink opened by open available as name.
+func registerStatsSink(name string, open func(target string) (statsSink, error)) {
+	statsSinks[name] = open
+}
+
+func init() {
+	registerStatsSink("file", func(path string) (statsSink, error) { return &fileStatsSink{path: path}, nil })
+}
+
+// usageStats receives the statistics of the kernel. It is nil unless they are enabled.
+var usageStats statsSink
+
+// startStats enables the usage statistics if the configuration sets where they go:
+// either a sink as name:target, or the path of a statistics file.
+func startStats(config KernelConfig) error {
+	if config.Stats == "" {
+		return nil
+	}
+	name, target := "file", config.Stats
+	if i := strings.Index(config.Stats, ":"); i > 0 && statsSinks[config.Stats[:i]] != nil {
+		name, target = config.Stats[:i], config.Stats[i+1:]
+	}
+	sink, err := statsSinks[name](target)
+	if err != nil {
+		return fmt.Errorf("could not open the %s stats sink: %v", name, err)
+	}
+	usageStats = sink
+	return nil
+}
+
+// recordStats records the execution of code, which failed with err if it is not nil.
+func recordStats(code string, err error) {
+	if usageStats == nil {
+		return
+	}
+	stats := cellStats{Time: time.Now(), Imports: importedPackages(code), Category: errorCategory(err)}
+	if err := usageStats.record(stats); err != nil {
+		log.Printf("Error recording usage stats: %v\n", err)
+	}
+}
+
+// importedPackages returns the paths of the packages imported by code.
+func importedPackages(code string) []string {
+	imports, _ := splitImports(code)
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", -1, len(imports)), []byte(imports), nil, 0)
+	var paths []string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return paths
+		}
+		if tok == token.STRING {
+			if path, err := strconv.Unquote(lit); err == nil {
+				paths = append(paths, path)
+			}
+		}
+	}
+}
+
+// errorCategory returns the kind of err, without anything from the code of the cell:
+// "syntax", "undefined" (a name that is not declared), "expectation", "declined" (a
+// dangerous cell that was not confirmed), "sandbox", "magic" or "runtime" for the
+// other errors of cells.
+func errorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	if _, ok := err.(scanner.ErrorList); ok {
+		return "syntax"
+	}
+	if _, ok := err.(*expectationError); ok {
+		return "expectation"
+	}
+	if err == errNotConfirmed {
+		return "declined"
+	}
+	message := err.Error()
+	switch {
+	case unknownIdent.MatchString(message):
+		return "undefined"
+	case strings.Contains(message, "not permitted in the sandbox"):
+		return "sandbox"
+	case strings.HasPrefix(message, "%"):
+		return "magic"
+	}
+	return "runtime"
+}
+
+// statsFile is the content of the statistics file, which adds up the statistics of all
+// the kernels writing to it.
+type statsFile struct {
+	ExecutionsPerDay map[string]int `json:"executions_per_day"` // by date, as 2006-01-02
+	Imports          map[string]int `json:"imports"`            // by package path
+	Errors           map[string]int `json:"errors"`             // by category
+}
+
+// newStatsFile returns empty statistics.
+func newStatsFile() *statsFile {
+	return &statsFile{ExecutionsPerDay: map[string]int{}, Imports: map[string]int{}, Errors: map[string]int{}}
+}
+
+// read reads the statistics file at path into f, adding them up with those it
+// has. A file that does not exist has no statistics.
+func (f *statsFile) read(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	read := newStatsFile()
+	if err := json.Unmarshal(data, read); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	f.add(read)
+	return nil
+}
+
+// add adds the statistics of other to f.
+func (f *statsFile) add(other *statsFile) {
+	for day, n := range other.ExecutionsPerDay {
+		f.ExecutionsPerDay[day] += n
+	}
+	for path, n := range other.Imports {
+		f.Imports[path] += n
+	}
+	for category, n := range other.Errors {
+		f.Errors[category] += n
+	}
+}
+
+// topImports returns the n packages imported most often, most imported first.
+func (f *statsFile) topImports(n int) []string {
+	var paths []string
+	for path := range f.Imports {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if f.Imports[paths[i]] != f.Imports[paths[j]] {
+			return f.Imports[paths[i]] > f.Imports[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	if len(paths) > n {
+		paths = paths[:n]
+	}
+	return paths
+}
+
+// fileStatsSink adds the statistics up in a JSON file, which administrators can
+// aggregate across users.
+type fileStatsSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// record reads the file, adds stats to it and replaces it, so the kernels of a user
+// can share it, and it is complete even if the kernel is killed.
+func (f *fileStatsSink) record(stats cellStats) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	totals := newStatsFile()
+	if err := totals.read(f.path); err != nil {
+		return err
+	}
+
+	totals.ExecutionsPerDay[stats.Time.Format("2006-01-02")]++
+	for _, path := range stats.Imports {
+		totals.Imports[path]++
+	}
+	if stats.Category != "" {
+		totals.Errors[stats.Category]++
+	}
+
+	data, err := json.MarshalIndent(totals, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), ".gopyter-stats")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// runStats implements `gopyter stats [-json] [-top n] file...`, which adds up statistics
+// files, e.g. those of the users of a JupyterHub, and prints them.
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the statistics added up as a statistics file")
+	top := flags.Int("top", 10, "the number of most imported packages to print")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gopyter stats [-json] [-top n] file...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	totals := newStatsFile()
+	for _, path := range flags.Args() {
+		if err := totals.read(path); err != nil {
+			return err
+		}
+	}
+	return totals.write(os.Stdout, *asJSON, *top)
+}
+
+// write writes the statistics to out, as JSON or as a summary with the top most
+// imported packages.
+func (f *statsFile) write(out io.Writer, asJSON bool, top int) error {
+	if asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(f)
+	}
+	var days []string
+	for day := range f.ExecutionsPerDay {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	fmt.Fprintln(out, "Executions per day:")
+	for _, day := range days {
+		fmt.Fprintf(out, "  %s  %d\n", day, f.ExecutionsPerDay[day])
+	}
+	fmt.Fprintln(out, "Top imported packages:")
+	for _, path := range f.topImports(top) {
+		fmt.Fprintf(out, "  %-30s  %d\n", path, f.Imports[path])
+	}
+	var categories []string
+	for category := range f.Errors {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	fmt.Fprintln(out, "Errors:")
+	for _, category := range categories {
+		fmt.Fprintf(out, "  %-12s  %d\n", category, f.Errors[category])
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeStatsSink keeps the statistics it receives.
+type fakeStatsSink struct {
+	target string
+	stats  []cellStats
+}
+
+func (f *fakeStatsSink) record(stats cellStats) error {
+	f.stats = append(f.stats, stats)
+	return nil
+}
+
+// TestStats tests recording usage statistics in a file, or in a registered sink.
+func TestStats(t *testing.T) {
+	t.Logf("Should find the packages imported by cells and categorize their errors.")
+
+	imports := importedPackages("import \"fmt\"\nimport (\n\tx \"strings\"\n\t\"github.com/google/uuid\"\n)\nfmt.Println(\"os\")")
+	if want := []string{"fmt", "strings", "github.com/google/uuid"}; !reflect.DeepEqual(imports, want) {
+		t.Fatalf("\t%s Expected %q, got %q.", failure, want, imports)
+	}
+	kernel := Kernel{NewSession(), defaultConfig()}
+	categoryCases := []struct {
+		code, category string
+	}{
+		{"x := 1", ""},
+		{"x := (", "syntax"},
+		{"undefinedName + 1", "undefined"},
+		{"%require fmt", "magic"},
+		{"panic(\"boom\")", "runtime"},
+	}
+	for _, tc := range categoryCases {
+		_, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, tc.code)
+		if category := errorCategory(err); category != tc.category {
+			t.Fatalf("\t%s Expected %q for %q, got %q (%v).", failure, tc.category, tc.code, category, err)
+		}
+	}
+	if category := errorCategory(errNotConfirmed); category != "declined" {
+		t.Fatalf("\t%s Expected declined, got %q.", failure, category)
+	}
+	t.Logf("\t%s Found and categorized them.", success)
+
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { usageStats = nil }()
+
+	t.Logf("Should add the statistics up in the statistics file.")
+
+	path := filepath.Join(dir, "stats.json")
+	config := defaultConfig()
+	config.Stats = path
+	if err := startStats(config); err != nil {
+		t.Fatalf("\t%s Could not enable the statistics: %v.", failure, err)
+	}
+	recordStats("import \"fmt\"\nfmt.Println(1)", nil)
+	recordStats("import (\n\t\"fmt\"\n\t\"strings\"\n)\nx := (", errors.New("syntax"))
+	usageStats.record(cellStats{Time: time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC), Category: "runtime"})
+
+	totals := newStatsFile()
+	if err := totals.read(path); err != nil {
+		t.Fatalf("\t%s Could not read the statistics: %v.", failure, err)
+	}
+	today := time.Now().Format("2006-01-02")
+	if totals.ExecutionsPerDay[today] != 2 || totals.ExecutionsPerDay["2020-01-02"] != 1 ||
+		!reflect.DeepEqual(totals.Imports, map[string]int{"fmt": 2, "strings": 1}) ||
+		!reflect.DeepEqual(totals.Errors, map[string]int{"runtime": 2}) {
+		t.Fatalf("\t%s Expected the statistics of the cells, got %+v.", failure, totals)
+	}
+	t.Logf("\t%s Added them up.", success)
+
+	t.Logf("Should add up statistics files and print them.")
+
+	totals.read(path)
+	totals.read(filepath.Join(dir, "missing.json"))
+	var out bytes.Buffer
+	totals.write(&out, false, 1)
+	for _, want := range []string{"  2020-01-02  2\n", "Top imported packages:\n  fmt                             4\nErrors:\n", "  runtime       4\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("\t%s Expected %q in the summary, got %q.", failure, want, out.String())
+		}
+	}
+	t.Logf("\t%s Printed them.", success)
+
+	t.Logf("Should hand the statistics to registered sinks.")
+
+	sink := &fakeStatsSink{}
+	registerStatsSink("fake", func(target string) (statsSink, error) {
+		sink.target = target
+		return sink, nil
+	})
+	defer delete(statsSinks, "fake")
+	config.Stats = "fake:collector.example.com:4000"
+	if err := startStats(config); err != nil {
+		t.Fatalf("\t%s Could not enable the statistics: %v.", failure, err)
+	}
+	recordStats("import \"os\"", nil)
+	if sink.target != "collector.example.com:4000" || len(sink.stats) != 1 || !reflect.DeepEqual(sink.stats[0].Imports, []string{"os"}) {
+		t.Fatalf("\t%s Expected the statistics in the sink, got %+v.", failure, sink)
+	}
+	t.Logf("\t%s Handed them.", success)
+}
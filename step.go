@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	cellMagics["step"] = evalStepMagic
+	documentMagic(stepSyntax)
+}
+
+var stepSyntax = &magicSyntax{
+	name: "%%step",
+	doc: "Executes the cell one top-level statement at a time, showing each statement before it runs and the " +
+		"variables it assigned after, and pausing for input between statements: Enter runs the next statement, " +
+		"`c` runs the rest of the cell without pausing and `q` stops.",
+}
+
+// The answers to the prompt of %%step between two statements.
+const (
+	stepContinue = "c"
+	stepQuit     = "q"
+)
+
+// evalStepMagic implements `%%step`, which executes the cell statement by statement,
+// pausing between them. Without a front-end allowing input, the statements run without
+// pausing.
+func evalStepMagic(kernel *Kernel, outerr OutErr, args string, body string) ([]interface{}, error) {
+	if _, err := stepSyntax.parse(args); err != nil {
+		return nil, err
+	}
+	code := kernel.evalSpecialCommands(outerr, body)
+	stmts := splitStatements(code)
+	input := kernel.session.input
+	if input == nil && len(stmts) > 1 {
+		fmt.Fprintln(outerr.out, "The front-end does not allow input, so the statements run without pausing.")
+	}
+	pausing := input != nil
+	for i, stmt := range stmts {
+		if strings.TrimSpace(stmt.src) == "" {
+			continue
+		}
+		kernel.display(outerr, echoData(stmt.src))
+		vals, err := kernel.evalChunk(outerr, stmt.src)
+		if err != nil {
+			return nil, fmt.Errorf("step %d of %d: %v", i+1, len(stmts), err)
+		}
+		for _, name := range stmt.assigns {
+			if data, ok := kernel.assignmentData(name); ok {
+				kernel.display(outerr, data)
+			}
+		}
+		if i == len(stmts)-1 {
+			return vals, nil
+		}
+		if data := kernel.autoRenderResults(vals); stmt.expr && len(data.Data) != 0 {
+			kernel.display(outerr, data)
+		}
+		if !pausing {
+			continue
+		}
+		prompt := fmt.Sprintf("Step %d of %d done. Enter: next statement, %s: continue, %s: stop ", i+1, len(stmts), stepContinue, stepQuit)
+		answer, err := input(prompt, false)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.TrimSpace(answer) {
+		case stepContinue:
+			pausing = false
+		case stepQuit:
+			fmt.Fprintf(outerr.out, "Stopped after step %d of %d; the rest of the cell was not executed.\n", i+1, len(stmts))
+			return nil, nil
+		}
+	}
+	return nil, nil
+}
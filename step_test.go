@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// TestStep tests executing cells statement by statement.
+func TestStep(t *testing.T) {
+	cases := []struct {
+		answers []string
+		shown   []string
+		vals    []interface{}
+		x, y    interface{} // after the cell, or nil if undeclared
+	}{
+		{[]string{"", ""}, []string{">>> x := 1", "x: 1", ">>> y := x + 1", "y: 2", ">>> x + y"}, []interface{}{3}, 1, 2},
+		{[]string{"q"}, []string{">>> x := 1", "x: 1"}, nil, 1, nil},
+		{[]string{"c"}, []string{">>> x := 1", "x: 1", ">>> y := x + 1", "y: 2", ">>> x + y"}, []interface{}{3}, 1, 2},
+	}
+
+	t.Logf("Should pause between the statements as the answers to the prompts tell.")
+
+	for _, tc := range cases {
+		kernel := Kernel{NewSession(), defaultConfig()}
+		var shown, prompts []string
+		kernel.session.display = func(data Data) {
+			shown = append(shown, fmt.Sprint(data.Data[MIMETypeText]))
+		}
+		kernel.session.input = func(prompt string, password bool) (string, error) {
+			answer := tc.answers[len(prompts)]
+			prompts = append(prompts, prompt)
+			return answer, nil
+		}
+		vals, err := kernel.doEvalGop(OutErr{ioutil.Discard, ioutil.Discard}, "%%step\nx := 1\ny := x + 1\nx + y")
+		if err != nil || !reflect.DeepEqual(vals, tc.vals) {
+			t.Fatalf("\t%s Expected %v, got %v (%v).", failure, tc.vals, vals, err)
+		}
+		if !reflect.DeepEqual(shown, tc.shown) || len(prompts) != len(tc.answers) {
+			t.Fatalf("\t%s Expected %q to be shown after %d prompts, got %q after %q.", failure, tc.shown, len(tc.answers), shown, prompts)
+		}
+		for name, want := range map[string]interface{}{"x": tc.x, "y": tc.y} {
+			got, err := kernel.session.Peek(name)
+			if want == nil && err == nil || want != nil && (err != nil || got[0] != want) {
+				t.Fatalf("\t%s Expected %s to be %v, got %v (%v).", failure, name, want, got, err)
+			}
+		}
+		t.Logf("\t%s Answered %q.", success, tc.answers)
+	}
+}
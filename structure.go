@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	commTargets[structureCommTarget] = openStructureComm
+}
+
+// structureCommTarget is the comm target through which tools read the structure of
+// the program accumulated by the session: the syntax tree of everything the cells
+// defined, and the types of the symbols they declared. Each message
+// {"request": "ast"} or {"request": "symbols"} is answered with {"ast": ...} or
+// {"symbols": [...]}, or {"error": ...}; the symbols are also sent when the comm opens.
+// {"request": "definition"} asks where a name is defined, see definitionReply, and
+// {"request": "rename"} renames a name across cells, see renameReply.
+const structureCommTarget = "gopyter.structure"
+
+// symbol is a name declared by the cells of a session.
+type symbol struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"` // "var", "func" or "package"
+	Type string `json:"type,omitempty"`
+}
+
+// syntaxTree parses the source accumulated by the session.
+func (s *Session) syntaxTree() (*token.FileSet, *ast.File, string, error) {
+	source := s.source()
+	fset := token.NewFileSet()
+	pkgs, err := parser.Parse(fset, "", source, 0)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	for _, f := range pkgs["main"].Files {
+		return fset, f, source, nil
+	}
+	return nil, nil, "", fmt.Errorf("no source")
+}
+
+// symbols returns the names declared by the cells of the session, with the types of
+// their current values.
+func (s *Session) symbols() []symbol {
+	vars, imports := s.declaredNames()
+	symbols := make([]symbol, 0, len(vars)+len(imports))
+	for name := range vars {
+		sym := symbol{Name: name, Kind: "var"}
+		if vals, err := s.Peek(name); err == nil && len(vals) == 1 && vals[0] != nil {
+			t := reflect.TypeOf(vals[0])
+			sym.Type = t.String()
+			if t.Kind() == reflect.Func {
+				sym.Kind = "func"
+			}
+		}
+		symbols = append(symbols, sym)
+	}
+	for path := range imports {
+		symbols = append(symbols, symbol{Name: path, Kind: "package"})
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Name < symbols[j].Name })
+	return symbols
+}
+
+// astJSON converts a syntax tree to values encodable as JSON. Each node becomes an
+// object with its type in "node" and its fields; positions become "line:column"
+// strings.
+func astJSON(fset *token.FileSet, node ast.Node) interface{} {
+	return astValueJSON(fset, reflect.ValueOf(node))
+}
+
+var (
+	posType   = reflect.TypeOf(token.Pos(0))
+	tokenType = reflect.TypeOf(token.Token(0))
+)
+
+func astValueJSON(fset *token.FileSet, v reflect.Value) interface{} {
+	switch v.Type() {
+	case posType:
+		pos := fset.Position(token.Pos(v.Int()))
+		if !pos.IsValid() {
+			return nil
+		}
+		return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	case tokenType:
+		return token.Token(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return astValueJSON(fset, v.Elem())
+	case reflect.Struct:
+		obj := map[string]interface{}{"node": v.Type().Name()}
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Type().Field(i); field.PkgPath == "" && !skippedASTFields[field.Name] {
+				obj[field.Name] = astValueJSON(fset, v.Field(i))
+			}
+		}
+		return obj
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = astValueJSON(fset, v.Index(i))
+		}
+		return list
+	case reflect.Map:
+		return nil
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	}
+	return nil
+}
+
+// openStructureComm sets up a comm answering requests for the structure of the
+// session's program.
+func openStructureComm(kernel *Kernel, receipt msgReceipt, c *comm, data map[string]interface{}) error {
+	c.onMsg = func(receipt msgReceipt, data map[string]interface{}) {
+		request, _ := data["request"].(string)
+		reply, err := kernel.structureReply(request, data)
+		if err != nil {
+			reply = map[string]interface{}{"error": err.Error()}
+		}
+		if err := c.send(receipt, reply); err != nil {
+			log.Printf("Error sending the structure of the session: %v\n", err)
+		}
+	}
+	return c.send(receipt, map[string]interface{}{"symbols": kernel.session.symbols()})
+}
+
+// structureReply answers a request sent on a structure comm with data.
+func (kernel *Kernel) structureReply(request string, data map[string]interface{}) (map[string]interface{}, error) {
+	switch request {
+	case "ast":
+		fset, file, source, err := kernel.session.syntaxTree()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"ast": astJSON(fset, file), "source": source}, nil
+	case "symbols":
+		return map[string]interface{}{"symbols": kernel.session.symbols()}, nil
+	case "definition":
+		return kernel.definitionReply(data)
+	case "rename":
+		return kernel.renameReply(data)
+	}
+	return nil, fmt.Errorf("unknown request %q, expected ast, symbols, definition or rename", request)
+}
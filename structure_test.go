@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSessionStructure tests reading the structure of the program of a session.
+func TestSessionStructure(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	if _, err := kernel.session.Eval("import \"strings\"\nn := 2\nf := func(s string) string { return strings.Repeat(s, n) }"); err != nil {
+		t.Fatalf("\t%s Could not set up the session: %v.", failure, err)
+	}
+
+	t.Logf("Should list the symbols declared by the cells with their types.")
+
+	want := []symbol{
+		{"In", "var", "map[int]string"},
+		{"Out", "var", "map[int]interface {}"},
+		{"f", "func", "func(string) string"},
+		{"n", "var", "int"},
+		{"strings", "package", ""},
+	}
+	if got := kernel.session.symbols(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("\t%s Expected %v, got %v.", failure, want, got)
+	}
+	t.Logf("\t%s Listed the symbols.", success)
+
+	t.Logf("Should return the syntax tree as JSON.")
+
+	reply, err := kernel.structureReply("ast", nil)
+	if err != nil {
+		t.Fatalf("\t%s Requesting the syntax tree failed: %v.", failure, err)
+	}
+	data, err := json.Marshal(reply["ast"])
+	if err != nil {
+		t.Fatalf("\t%s Could not encode the syntax tree: %v.", failure, err)
+	}
+	for _, s := range []string{`"node":"File"`, `"node":"FuncLit"`, `"Name":"n"`, `"Tok":":="`} {
+		if !strings.Contains(string(data), s) {
+			t.Fatalf("\t%s Expected %s in the syntax tree.", failure, s)
+		}
+	}
+	t.Logf("\t%s Returned %d bytes of JSON.", success, len(data))
+
+	if _, err := kernel.structureReply("unknown", nil); err == nil {
+		t.Fatalf("\t%s Expected an error for an unknown request.", failure)
+	}
+}
+
+// TestDefinition tests finding where names are defined, in the cells of the session or
+// in packages.
+func TestDefinition(t *testing.T) {
+	defer func(cache *docCache) { packageDocCache = cache }(packageDocCache)
+	packageDocCache = newDocCache("")
+	kernel := Kernel{NewSession(), defaultConfig()}
+	cells := []string{"import \"strings\"\nn := 2", "%%time\nπ, r := 3.14, 1\ntype Point struct{ X int }", "n := 3", "type Celsius float64\n\tk := Celsius(1)"}
+	if _, err := kernel.session.Eval(cells[0]); err != nil {
+		t.Fatalf("\t%s Could not set up the session: %v.", failure, err)
+	}
+	for i, code := range cells {
+		kernel.session.deps.record(i+1, fmt.Sprintf("c%d", i+1), code)
+	}
+	cases := []struct {
+		request map[string]interface{}
+		want    string // the definition as JSON
+	}{
+		{map[string]interface{}{"name": "r"}, `{"cell_id":"c2","column":4,"execution_count":2,"line":2,"name":"r","offset":10}`},
+		{map[string]interface{}{"name": "Point"}, `{"cell_id":"c2","column":6,"execution_count":2,"line":3,"name":"Point","offset":28}`},
+		{map[string]interface{}{"name": "n"}, `{"cell_id":"c3","column":1,"execution_count":3,"line":1,"name":"n","offset":0}`},
+		{map[string]interface{}{"name": "k"}, `{"cell_id":"c4","column":2,"execution_count":4,"line":2,"name":"k","offset":22}`},
+		{map[string]interface{}{"name": "Celsius"}, `{"cell_id":"c4","column":6,"execution_count":4,"line":1,"name":"Celsius","offset":5}`},
+		{map[string]interface{}{"code": "x := r + 1", "cursor_pos": 5.0}, `{"cell_id":"c2","column":4,"execution_count":2,"line":2,"name":"r","offset":10}`},
+		{map[string]interface{}{"name": "nothing"}, `null`},
+		{map[string]interface{}{"code": `s := "r"`, "cursor_pos": 6.0}, `null`},
+	}
+
+	t.Logf("Should find the cells defining names.")
+
+	for _, tc := range cases {
+		reply, err := kernel.structureReply("definition", tc.request)
+		if err != nil {
+			t.Fatalf("\t%s Requesting the definition failed: %v.", failure, err)
+		}
+		data, _ := json.Marshal(reply["definition"])
+		if string(data) != tc.want {
+			t.Fatalf("\t%s Expected the definition of %v to be %s, got %s.", failure, tc.request, tc.want, data)
+		}
+		t.Logf("\t%s %v.", success, tc.request)
+	}
+
+	t.Logf("Should find the files defining the names of packages.")
+
+	reply, _ := kernel.structureReply("definition", map[string]interface{}{"name": "strings.Split"})
+	definition, _ := reply["definition"].(map[string]interface{})
+	if definition == nil || filepath.Base(definition["file"].(string)) != "strings.go" || definition["line"].(int) <= 0 {
+		t.Fatalf("\t%s Expected strings.Split to be defined in strings.go, got %v.", failure, definition)
+	}
+	t.Logf("\t%s Found %s:%d.", success, definition["file"], definition["line"])
+}
+
+// TestRename tests renaming a name declared by the session across its cells.
+func TestRename(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	cells := []string{
+		"total := 40",
+		"%%time\ntotal += 2\nf := func(total int) int { return total }\ntype point struct{ total int }\np := point{total: total}",
+		"for i := 0; i < 1; i++ {\n\ttotal := 1\n\t_ = total\n}\nxs := [total for total <- []int{1}]\nys := [x + total for x <- []int{1}]",
+	}
+	for i, code := range cells {
+		if _, err := kernel.session.Eval(strings.TrimPrefix(code, "%%time\n")); err != nil {
+			t.Fatalf("\t%s Could not evaluate %q: %v.", failure, code, err)
+		}
+		kernel.session.deps.record(i+1, fmt.Sprintf("c%d", i+1), code)
+	}
+
+	t.Logf("Should rename the references to a name in the cells and in the session.")
+
+	reply, err := kernel.structureReply("rename", map[string]interface{}{"name": "total", "new_name": "sum"})
+	if err != nil {
+		t.Fatalf("\t%s Could not rename: %v.", failure, err)
+	}
+	want := []renamedCell{
+		{1, "c1", "sum := 40"},
+		{2, "c2", "%%time\nsum += 2\nf := func(total int) int { return total }\ntype point struct{ total int }\np := point{total: sum}"},
+		{3, "c3", "for i := 0; i < 1; i++ {\n\ttotal := 1\n\t_ = total\n}\nxs := [total for total <- []int{1}]\nys := [x + sum for x <- []int{1}]"},
+	}
+	if got := reply["rename"].(map[string]interface{})["cells"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("\t%s Expected the cells %q, got %q.", failure, want, got)
+	}
+	if vals, err := kernel.session.Eval("sum + p.total"); err != nil || len(vals) != 1 || vals[0] != 84 {
+		t.Fatalf("\t%s Expected sum to keep its value, got %v (%v).", failure, vals, err)
+	}
+	if _, err := kernel.session.Peek("total"); err == nil {
+		t.Fatalf("\t%s Expected total to be gone.", failure)
+	}
+	t.Logf("\t%s Renamed total in %d cells.", success, len(want))
+
+	t.Logf("Should refuse names that are taken or invalid.")
+
+	for _, request := range []map[string]interface{}{
+		{"name": "sum", "new_name": "xs"},
+		{"name": "sum", "new_name": "stringx"},
+		{"name": "sum", "new_name": "1x"},
+		{"name": "In", "new_name": "Inputs"},
+		{"name": "nothing", "new_name": "something"},
+	} {
+		if _, err := kernel.structureReply("rename", request); err == nil {
+			t.Fatalf("\t%s Expected renaming %s to %s to fail.", failure, request["name"], request["new_name"])
+		}
+		t.Logf("\t%s Refused %s to %s.", success, request["name"], request["new_name"])
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// The shell channel is served by the main shell and by the subshells front-ends create
+// with a create_subshell_request on the control channel (JEP 91). Each shell handles its
+// requests one at a time, in the order they arrive, concurrently with the other shells,
+// so a front-end can, e.g., check whether code is complete while the main shell runs a
+// long cell. Requests tagged with the subshell_id of a subshell in their header go to
+// that subshell, the others to the main shell.
+//
+// The interpreter, the standard streams and the comms are shared by all shells, so the
+// requests using them, like execute_request and the comm messages, still run one at a
+// time: those of a subshell wait for the cell running in another shell. Completions and
+// inspections only read the source and the requirements of the session, which they copy
+// under sourceLock, so they are answered while a cell runs.
+
+// interpreterLock is held while a shell handles a request using the interpreter.
+var interpreterLock sync.Mutex
+
+// sourceLock is held while the source or the requirements of the session, or the
+// session of the kernel, are changed, and while they are copied by snapshot.
+var sourceLock sync.Mutex
+
+// usesInterpreter reports whether the requests of type msgType use the interpreter or
+// the comms, and so must hold interpreterLock.
+func usesInterpreter(msgType string) bool {
+	switch msgType {
+	case "execute_request", "comm_open", "comm_msg", "comm_close", "comm_info_request":
+		return true
+	}
+	return false
+}
+
+// snapshot returns a kernel with a copy of the source and the requirements of the
+// session, from which code is completed and inspected without holding interpreterLock.
+func (kernel *Kernel) snapshot() *Kernel {
+	sourceLock.Lock()
+	defer sourceLock.Unlock()
+	s := kernel.session
+	copied := &Session{imports: s.imports, decls: s.decls, src: s.src, preload: s.preload}
+	if r := s.requirements; r != nil {
+		copied.requirements = &requirements{imports: r.imports, pins: append([]requirement(nil), r.pins...)}
+	}
+	return &Kernel{copied, KernelConfig{Language: kernel.config.Language}}
+}
+
+// shell handles the requests sent to the main shell or to a subshell.
+type shell struct {
+	id         string // "" for the main shell
+	mu         sync.Mutex
+	ready      *sync.Cond // signaled when a request is queued or the shell is closed
+	pending    []msgReceipt
+	closed     bool
+	abortUntil time.Time // execute_requests are aborted until then, after a cell failed
+}
+
+// newShell returns a shell with no pending requests.
+func newShell(id string) *shell {
+	s := &shell{id: id}
+	s.ready = sync.NewCond(&s.mu)
+	return s
+}
+
+// push queues a request.
+func (s *shell) push(receipt msgReceipt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, receipt)
+	s.ready.Signal()
+}
+
+// next waits for the next request. It returns false once the shell is closed and has
+// no pending requests.
+func (s *shell) next() (msgReceipt, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.pending) == 0 && !s.closed {
+		s.ready.Wait()
+	}
+	if len(s.pending) == 0 {
+		return msgReceipt{}, false
+	}
+	receipt := s.pending[0]
+	s.pending = s.pending[1:]
+	return receipt, true
+}
+
+// close stops the shell once it has handled its pending requests.
+func (s *shell) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.ready.Broadcast()
+}
+
+// run handles the requests of the shell with handle until it is closed.
+func (s *shell) run(handle func(msgReceipt)) {
+	for {
+		receipt, ok := s.next()
+		if !ok {
+			return
+		}
+		if s.aborts(receipt) {
+			abortExecuteRequest(receipt)
+			continue
+		}
+		handle(receipt)
+		receipt.Span.end()
+	}
+}
+
+// abortFor aborts the execute_requests the shell receives for d.
+func (s *shell) abortFor(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abortUntil = time.Now().Add(d)
+}
+
+// aborts reports whether the shell aborts receipt rather than handling it.
+func (s *shell) aborts(receipt msgReceipt) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return receipt.Msg.Header.MsgType == "execute_request" && time.Now().Before(s.abortUntil)
+}
+
+// shells are the main shell, with the empty id, and the subshells of the kernel.
+var shells = struct {
+	sync.Mutex
+	byID map[string]*shell
+}{byID: map[string]*shell{}}
+
+// startShell creates the shell with id, handling its requests with handle.
+func startShell(id string, handle func(msgReceipt)) *shell {
+	s := newShell(id)
+	shells.Lock()
+	shells.byID[id] = s
+	shells.Unlock()
+	go s.run(handle)
+	return s
+}
+
+// dispatchShellMsg queues a request received on the shell channel in the shell it is
+// sent to.
+func dispatchShellMsg(receipt msgReceipt) error {
+	id := receipt.Msg.Header.SubshellID
+	shells.Lock()
+	s, ok := shells.byID[id]
+	shells.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown subshell %q", id)
+	}
+	s.push(receipt)
+	return nil
+}
+
+// subshellIDs returns the ids of the subshells, sorted.
+func subshellIDs() []string {
+	shells.Lock()
+	defer shells.Unlock()
+	ids := []string{}
+	for id := range shells.byID {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// deleteSubshell stops the subshell with id once it has handled its pending requests.
+func deleteSubshell(id string) error {
+	shells.Lock()
+	s, ok := shells.byID[id]
+	if ok && id != "" {
+		delete(shells.byID, id)
+	}
+	shells.Unlock()
+	if !ok || id == "" {
+		return fmt.Errorf("unknown subshell %q", id)
+	}
+	s.close()
+	return nil
+}
+
+// handleCreateSubshellRequest creates a subshell and replies with its id.
+func (kernel *Kernel) handleCreateSubshellRequest(receipt msgReceipt) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	startShell(id.String(), kernel.handleShellMsg)
+	return receipt.Reply("create_subshell_reply", map[string]interface{}{
+		"status":      "ok",
+		"subshell_id": id.String(),
+	})
+}
+
+// handleDeleteSubshellRequest deletes the subshell named by the request.
+func handleDeleteSubshellRequest(receipt msgReceipt) error {
+	content := receipt.Msg.Content.(map[string]interface{})
+	id, _ := content["subshell_id"].(string)
+	if err := deleteSubshell(id); err != nil {
+		return receipt.Reply("delete_subshell_reply", map[string]interface{}{
+			"status": "error",
+			"ename":  "SubshellNotFound",
+			"evalue": err.Error(),
+		})
+	}
+	return receipt.Reply("delete_subshell_reply", map[string]interface{}{"status": "ok"})
+}
+
+// handleListSubshellRequest replies with the ids of the subshells.
+func handleListSubshellRequest(receipt msgReceipt) error {
+	return receipt.Reply("list_subshell_reply", map[string]interface{}{
+		"status":      "ok",
+		"subshell_id": subshellIDs(),
+	})
+}
+
+// replyUnknownSubshell replies to a request sent to a subshell that does not exist.
+func replyUnknownSubshell(receipt msgReceipt, err error) {
+	log.Println(err)
+	msgType := receipt.Msg.Header.MsgType
+	if strings.HasSuffix(msgType, "_request") {
+		reply := strings.TrimSuffix(msgType, "_request") + "_reply"
+		if err := receipt.Reply(reply, map[string]interface{}{
+			"status": "error",
+			"ename":  "SubshellNotFound",
+			"evalue": err.Error(),
+		}); err != nil {
+			log.Printf("Error replying to %s: %v\n", msgType, err)
+		}
+	}
+	receipt.Span.end()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+)
+
+// TestSubshells tests routing shell requests to subshells, which handle them
+// concurrently with the main shell.
+func TestSubshells(t *testing.T) {
+	t.Logf("Should handle the requests of a shell in order.")
+
+	var mu sync.Mutex
+	var handled []string
+	release := make(chan struct{})
+	handle := func(receipt msgReceipt) {
+		if receipt.Msg.Header.MsgType == "execute_request" {
+			<-release
+		}
+		mu.Lock()
+		handled = append(handled, receipt.Msg.Header.SubshellID+":"+receipt.Msg.Header.MsgID)
+		mu.Unlock()
+	}
+	request := func(subshell, id, msgType string) msgReceipt {
+		return msgReceipt{Msg: ComposedMsg{Header: MsgHeader{MsgID: id, MsgType: msgType, SubshellID: subshell}}}
+	}
+	handledNow := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), handled...)
+	}
+	waitFor := func(n int) []string {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(handledNow()) < n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		return handledNow()
+	}
+
+	main := startShell("test-main", handle)
+	defer deleteSubshell("test-main")
+	for _, id := range []string{"1", "2", "3"} {
+		if err := dispatchShellMsg(request("test-main", id, "is_complete_request")); err != nil {
+			t.Fatalf("\t%s Could not dispatch the request: %v.", failure, err)
+		}
+	}
+	if got, want := waitFor(3), []string{"test-main:1", "test-main:2", "test-main:3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("\t%s Expected %q, got %q.", failure, want, got)
+	}
+	t.Logf("\t%s Handled them in order.", success)
+
+	t.Logf("Should handle the requests of a subshell while another shell is busy.")
+
+	startShell("test-sub", handle)
+	main.push(request("test-main", "4", "execute_request"))
+	dispatchShellMsg(request("test-sub", "5", "is_complete_request"))
+	if got := waitFor(4); len(got) != 4 || got[3] != "test-sub:5" {
+		t.Fatalf("\t%s Expected the subshell to handle its request first, got %q.", failure, got)
+	}
+	close(release)
+	if got := waitFor(5); got[4] != "test-main:4" {
+		t.Fatalf("\t%s Expected the main shell to finish its request, got %q.", failure, got)
+	}
+	t.Logf("\t%s Handled them concurrently.", success)
+
+	t.Logf("Should list and delete subshells, and refuse requests to unknown ones.")
+
+	ids := subshellIDs()
+	if !contains(ids, "test-main") || !contains(ids, "test-sub") {
+		t.Fatalf("\t%s Expected the subshells, got %q.", failure, ids)
+	}
+	if err := deleteSubshell("test-sub"); err != nil {
+		t.Fatalf("\t%s Could not delete the subshell: %v.", failure, err)
+	}
+	if err := deleteSubshell("test-sub"); err == nil {
+		t.Fatalf("\t%s Expected the subshell to be deleted already.", failure)
+	}
+	if err := dispatchShellMsg(request("test-sub", "6", "execute_request")); err == nil {
+		t.Fatalf("\t%s Expected the request to a deleted subshell to be refused.", failure)
+	}
+	if usesInterpreter("is_complete_request") || usesInterpreter("complete_request") || !usesInterpreter("execute_request") || !usesInterpreter("comm_msg") {
+		t.Fatalf("\t%s Expected only the requests using the interpreter to wait for it.", failure)
+	}
+	t.Logf("\t%s Listed, deleted and refused them.", success)
+}
+
+// TestCompletionDuringExecution tests completing and inspecting code in a subshell while
+// the main shell executes cells. Run with -race, it fails if they access the session
+// concurrently.
+func TestCompletionDuringExecution(t *testing.T) {
+	connInfo := testConnectionInfo(t, "")
+	sockets, err := prepareSockets(connInfo)
+	if err != nil {
+		t.Fatalf("\t%s Could not listen: %v.", failure, err)
+	}
+	defer func() {
+		for _, s := range []Socket{sockets.ShellSocket, sockets.ControlSocket, sockets.StdinSocket, sockets.IOPubSocket, sockets.HBSocket} {
+			s.Socket.Close()
+		}
+	}()
+
+	// The front-end, which drains the replies.
+	frontend := zmq4.NewDealer(context.Background(), zmq4.WithID(zmq4.SocketIdentity("frontend")))
+	defer frontend.Close()
+	if err := frontend.Dial(fmt.Sprintf("tcp://127.0.0.1:%d", connInfo.ShellPort)); err != nil {
+		t.Fatalf("\t%s Could not connect: %v.", failure, err)
+	}
+	if err := frontend.Send(zmq4.NewMsgString("hello")); err != nil {
+		t.Fatalf("\t%s Could not send: %v.", failure, err)
+	}
+	if _, err := sockets.ShellSocket.Socket.Recv(); err != nil {
+		t.Fatalf("\t%s Could not receive: %v.", failure, err)
+	}
+	go func() {
+		for {
+			if _, err := frontend.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+
+	kernel := &Kernel{NewSession(), defaultConfig()}
+	request := func(id, msgType string, content map[string]interface{}) msgReceipt {
+		return msgReceipt{
+			Msg:        ComposedMsg{Header: MsgHeader{MsgID: id, MsgType: msgType}, Content: content},
+			Identities: [][]byte{[]byte("frontend")},
+			Sockets:    sockets,
+		}
+	}
+
+	t.Logf("Should complete and inspect code while cells are executed.")
+
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			code := fmt.Sprintf("func f%d() int { return %d }\nx%d := f%d()", i, i, i, i)
+			kernel.handleShellMsg(request(fmt.Sprint("e", i), "execute_request", map[string]interface{}{"code": code, "silent": true}))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			kernel.handleShellMsg(request(fmt.Sprint("c", i), "complete_request", map[string]interface{}{"code": "x", "cursor_pos": 1}))
+			kernel.handleShellMsg(request(fmt.Sprint("i", i), "inspect_request", map[string]interface{}{"code": "x0", "cursor_pos": 2, "detail_level": 0}))
+		}
+	}()
+	wg.Wait()
+	if vals, err := kernel.session.Eval(fmt.Sprintf("x%d", n-1)); err != nil || len(vals) != 1 || vals[0] != n-1 {
+		t.Fatalf("\t%s Expected the cells to be executed, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Completed and inspected code during %d cells.", success, n)
+
+	t.Logf("Should not wait for the cell running to complete code.")
+
+	// The cell running holds the interpreter.
+	interpreterLock.Lock()
+	done := make(chan struct{})
+	go func() {
+		kernel.handleShellMsg(request("c", "complete_request", map[string]interface{}{"code": "x1", "cursor_pos": 2}))
+		close(done)
+	}()
+	select {
+	case <-done:
+		interpreterLock.Unlock()
+	case <-time.After(5 * time.Second):
+		interpreterLock.Unlock()
+		t.Fatalf("\t%s Expected the completion not to wait for the interpreter.", failure)
+	}
+	t.Logf("\t%s Completed code while a cell was running.", success)
+}
+
+// contains reports whether names has name.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/parser"
+	"github.com/goplus/gop/token"
+
+	exec "github.com/goplus/gop/exec/bytecode"
+)
+
+func init() {
+	lineMagics["autoimport"] = evalAutoimportMagic
+	documentMagic(autoimportSyntax)
+}
+
+var autoimportSyntax = &magicSyntax{
+	name:  "%autoimport",
+	usage: []string{"on|off", "<path>..."},
+	doc: "Enables or disables importing the standard library packages used by cells " +
+		"automatically, or imports packages into the session.",
+	args: []magicParam{{name: "on|off|path", help: "`on`, `off`, or the import paths of the packages to import", variadic: true}},
+}
+
+// stdPackages are the packages of the standard library the interpreter provides.
+var stdPackages = []string{
+	"bytes", "errors", "flag", "fmt", "io", "io/ioutil", "log", "os", "reflect", "sort",
+	"strconv", "strings", "sync", "sync/atomic", "time",
+}
+
+// unknownIdent matches the errors of the compiler about an identifier that is not
+// declared.
+var unknownIdent = regexp.MustCompile(`(?:unknown - |undefined: )([\pL_][\pL\pN_]*)`)
+
+// errorSuggestions returns hints on fixing err, if it is a mistake commonly made in a
+// REPL: a misspelled variable or a package used without importing it. importPath is
+// the package to import, if that would fix err.
+func (s *Session) errorSuggestions(err error) (suggestions []string, importPath string) {
+	match := unknownIdent.FindStringSubmatch(err.Error())
+	if match == nil {
+		return nil, ""
+	}
+	name := match[1]
+
+	vars, imports := s.declaredNames()
+	if importPath = stdPackage(name); importPath != "" && !imports[importPath] {
+		suggestions = append(suggestions, fmt.Sprintf("%s is a package that is not imported: add import %q, or run %%autoimport %s.", name, importPath, importPath))
+	} else {
+		importPath = ""
+	}
+
+	var candidates []string
+	for v := range vars {
+		candidates = append(candidates, v)
+	}
+	for p := range imports {
+		candidates = append(candidates, path.Base(p))
+	}
+	if similar := similarNames(name, candidates); len(similar) != 0 {
+		suggestions = append(suggestions, fmt.Sprintf("Did you mean %s?", strings.Join(similar, " or ")))
+	}
+	return suggestions, importPath
+}
+
+// stdPackage returns the path of the standard library package named name, or "" if
+// the interpreter provides no such package.
+func stdPackage(name string) string {
+	for _, p := range stdPackages {
+		if path.Base(p) == name && exec.FindGoPackage(p) != nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// declaredNames returns the names declared by the cells evaluated so far and the paths
+// of the packages they imported.
+func (s *Session) declaredNames() (vars map[string]bool, imports map[string]bool) {
+	vars = make(map[string]bool)
+	imports = make(map[string]bool)
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.Parse(fset, "", s.source(), 0)
+	if err != nil {
+		return vars, imports
+	}
+	v := varUses{reads: make(map[string]bool), writes: vars}
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, spec := range f.Imports {
+				if p, err := strconv.Unquote(spec.Path.Value); err == nil {
+					imports[p] = true
+				}
+			}
+			for _, decl := range f.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == "main" && fn.Body != nil {
+					for _, stmt := range fn.Body.List {
+						v.addStmt(stmt)
+					}
+				} else if ok {
+					vars[fn.Name.Name] = true
+				}
+			}
+		}
+	}
+	return vars, imports
+}
+
+// similarNames returns the candidates that are likely misspellings of name, closest
+// first.
+func similarNames(name string, candidates []string) []string {
+	maxDistance := len(name) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	distances := make(map[string]int)
+	var similar []string
+	for _, c := range candidates {
+		if c == name || strings.HasPrefix(c, "_gopyter") {
+			continue
+		}
+		if d := editDistance(strings.ToLower(name), strings.ToLower(c)); d <= maxDistance {
+			if _, seen := distances[c]; !seen {
+				similar = append(similar, c)
+			}
+			distances[c] = d
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if distances[similar[i]] != distances[similar[j]] {
+			return distances[similar[i]] < distances[similar[j]]
+		}
+		return similar[i] < similar[j]
+	})
+	if len(similar) > 3 {
+		similar = similar[:3]
+	}
+	return similar
+}
+
+// editDistance returns the Damerau-Levenshtein distance between a and b, counting
+// transpositions of adjacent characters as one edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, minInt(d[i][j-1]+1, d[i-1][j-1]+cost))
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// evalAutoimportMagic implements
+//
+//	%autoimport on|off
+//	%autoimport <path>...
+//
+// which enables or disables importing the standard library packages used by cells
+// automatically, or imports packages into the session. The second form is proposed by
+// the error shown when a cell uses a package it did not import.
+func evalAutoimportMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := autoimportSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	paths := parsed.args
+	switch {
+	case len(paths) == 1 && (paths[0] == "on" || paths[0] == "off"):
+		kernel.config.AutoImport = paths[0] == "on"
+		fmt.Fprintf(outerr.out, "Automatic imports are %s.\n", paths[0])
+		return nil
+	}
+
+	var imports strings.Builder
+	for _, p := range paths {
+		fmt.Fprintf(&imports, "import %q\n", p)
+	}
+	_, err = kernel.session.Eval(imports.String())
+	return err
+}
+
+// evalAutoImporting evaluates code like evalCode, but when the code uses a standard
+// library package it did not import, it imports the package and evaluates the code
+// again, like goimports would fix the code.
+func (kernel *Kernel) evalAutoImporting(outerr OutErr, code string) ([]interface{}, error) {
+	var imports []string
+	for {
+		vals, err := kernel.evalCode(code)
+		if err == nil {
+			return vals, nil
+		}
+		_, importPath := kernel.session.errorSuggestions(err)
+		if importPath == "" || !usesPackage(code, path.Base(importPath)) {
+			return vals, err
+		}
+		for _, imported := range imports {
+			if imported == importPath {
+				return vals, err
+			}
+		}
+		imports = append(imports, importPath)
+		fmt.Fprintf(outerr.err, "%%autoimport: importing %q\n", importPath)
+		code = fmt.Sprintf("import %q\n%s", importPath, code)
+	}
+}
+
+// usesPackage reports whether code refers to a member of a package named name, as in
+// name.Member.
+func usesPackage(code string, name string) bool {
+	selector := regexp.MustCompile(`(^|[^\pL\pN_.])` + regexp.QuoteMeta(name) + `\.[\pL_]`)
+	return selector.MatchString(code)
+}
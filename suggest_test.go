@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestErrorSuggestions tests the hints given for undeclared identifiers.
+func TestErrorSuggestions(t *testing.T) {
+	s := NewSession()
+	if _, err := s.Eval("import \"fmt\"\ncount := 1\ntotal := func() int { return count }"); err != nil {
+		t.Fatalf("\t%s Could not set up the session: %v.", failure, err)
+	}
+
+	cases := []struct {
+		code       string
+		suggestion string
+		importPath string
+	}{
+		{"cuont + 1", "Did you mean count?", ""},
+		{"totl()", "Did you mean total?", ""},
+		{"strings.ToUpper(\"a\")", "run %autoimport strings", "strings"},
+		{"fnt.Println(1)", "Did you mean fmt?", ""},
+		{"xyzzy", "", ""},
+	}
+
+	t.Logf("Should suggest fixes for undeclared identifiers.")
+
+	for _, tc := range cases {
+		_, err := s.Eval(tc.code)
+		if err == nil {
+			t.Fatalf("\t%s Expected %q to fail.", failure, tc.code)
+		}
+		suggestions, importPath := s.errorSuggestions(err)
+		if importPath != tc.importPath {
+			t.Errorf("\t%s %q: expected to import %q, got %q.", failure, tc.code, tc.importPath, importPath)
+			continue
+		}
+		if tc.suggestion == "" && len(suggestions) != 0 || !strings.Contains(strings.Join(suggestions, "\n"), tc.suggestion) {
+			t.Errorf("\t%s %q: expected %q in %q.", failure, tc.code, tc.suggestion, suggestions)
+			continue
+		}
+		t.Logf("\t%s %q: %q", success, tc.code, suggestions)
+	}
+}
+
+// TestSimilarNames tests finding the likely misspellings of a name.
+func TestSimilarNames(t *testing.T) {
+	t.Logf("Should find the names within a few edits, closest first.")
+
+	got := similarNames("totl", []string{"total", "tot", "title", "other"})
+	if want := []string{"tot", "total"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("\t%s Expected %v, got %v.", failure, want, got)
+	}
+	t.Logf("\t%s Found %v.", success, got)
+}
+
+// TestAutoImport tests importing the packages used by cells automatically.
+func TestAutoImport(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	var stderr strings.Builder
+	outerr := OutErr{&stderr, &stderr}
+
+	t.Logf("Should import the standard library packages a cell uses once enabled.")
+
+	if _, err := kernel.doEvalGop(outerr, "strings.ToUpper(\"a\")"); err == nil {
+		t.Fatalf("\t%s Expected the cell to fail before enabling automatic imports.", failure)
+	}
+
+	vals, err := kernel.doEvalGop(outerr, "%autoimport on\nstrings.ToUpper(strconv.Itoa(1) + \"a\")")
+	if err != nil || len(vals) != 1 || vals[0] != "1A" {
+		t.Fatalf("\t%s Expected \"1A\", got %v (%v).", failure, vals, err)
+	}
+	if !strings.Contains(stderr.String(), `importing "strconv"`) {
+		t.Fatalf("\t%s Expected the imports to be reported, got %q.", failure, stderr.String())
+	}
+	t.Logf("\t%s Imported the packages.", success)
+
+	t.Logf("Should not import a package for a variable with the name of a package.")
+
+	if _, err := kernel.doEvalGop(outerr, "fmt + 1"); err == nil {
+		t.Fatalf("\t%s Expected the cell to fail.", failure)
+	}
+	t.Logf("\t%s The cell failed.", success)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// MIMETypeDataResource is the MIME type of tables in the Tabular Data Resource format of
+// Frictionless Data, which JupyterLab extensions show as sortable and filterable grids.
+// See https://specs.frictionlessdata.io/tabular-data-resource/.
+const MIMETypeDataResource = "application/vnd.dataresource+json"
+
+const (
+	// maxTableRows bounds the rows of tables sent to the front-end.
+	maxTableRows = 10000
+
+	// maxHTMLTableRows bounds the rows of tables rendered as HTML, which browsers show
+	// slowly.
+	maxHTMLTableRows = 100
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// isTable reports whether v is a slice or an array of structs, or of pointers to
+// structs, which are rendered as tables.
+func isTable(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	if t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) {
+		return false
+	}
+	elem := t.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	return elem.Kind() == reflect.Struct && elem != timeType && len(tableFields(elem)) != 0
+}
+
+// tableFields returns the indexes of the exported fields of the struct type t.
+func tableFields(t reflect.Type) []int {
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			fields = append(fields, i)
+		}
+	}
+	return fields
+}
+
+// renderTable renders a slice of structs as a table, both as a data resource and as
+// HTML, with a column for each exported field.
+func renderTable(v interface{}) Data {
+	rows := reflect.ValueOf(v)
+	elem := rows.Type().Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	fields := tableFields(elem)
+
+	schema := make([]interface{}, 0, len(fields)+1)
+	schema = append(schema, map[string]interface{}{"name": "index", "type": "integer"})
+	for _, i := range fields {
+		schema = append(schema, map[string]interface{}{
+			"name": elem.Field(i).Name,
+			"type": dataResourceType(elem.Field(i).Type),
+		})
+	}
+
+	n := rows.Len()
+	if n > maxTableRows {
+		n = maxTableRows
+	}
+	data := make([]interface{}, 0, n)
+	var b strings.Builder
+	b.WriteString("<table><thead><tr><th></th>")
+	for _, i := range fields {
+		b.WriteString("<th>" + html.EscapeString(elem.Field(i).Name) + "</th>")
+	}
+	b.WriteString("</tr></thead><tbody>")
+	for r := 0; r < n; r++ {
+		row := rows.Index(r)
+		record := map[string]interface{}{"index": r}
+		if r < maxHTMLTableRows {
+			fmt.Fprintf(&b, "<tr><th>%d</th>", r)
+		}
+		for _, i := range fields {
+			var value interface{}
+			if row.Kind() != reflect.Ptr || !row.IsNil() {
+				value = dataResourceValue(reflect.Indirect(row).Field(i))
+			}
+			record[elem.Field(i).Name] = value
+			if r < maxHTMLTableRows {
+				if value == nil {
+					b.WriteString("<td></td>")
+				} else {
+					b.WriteString("<td>" + html.EscapeString(fmt.Sprint(value)) + "</td>")
+				}
+			}
+		}
+		if r < maxHTMLTableRows {
+			b.WriteString("</tr>")
+		}
+		data = append(data, record)
+	}
+	b.WriteString("</tbody></table>")
+	if shown := minInt(rows.Len(), maxHTMLTableRows); shown < rows.Len() {
+		fmt.Fprintf(&b, "<small>%d of %d rows shown.</small>", shown, rows.Len())
+	}
+
+	return Data{Data: MIMEMap{
+		MIMETypeDataResource: map[string]interface{}{
+			"schema": map[string]interface{}{"fields": schema, "primaryKey": []string{"index"}},
+			"data":   data,
+		},
+		MIMETypeHTML: b.String(),
+		MIMETypeText: fmt.Sprint(v),
+	}}
+}
+
+// dataResourceType returns the Table Schema type of the values of type t.
+func dataResourceType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	}
+	if t == timeType {
+		return "datetime"
+	}
+	return "any"
+}
+
+// dataResourceValue converts v to a JSON value of the type given by dataResourceType.
+func dataResourceValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339Nano)
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		// JSON has no NaN nor infinities.
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil
+		}
+		return v.Interface()
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.String:
+		return v.Interface()
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tableRow struct {
+	Name    string
+	Age     int
+	Score   float64
+	Joined  time.Time
+	Manager *tableRow
+	secret  string
+}
+
+// TestTable tests rendering slices of structs as tables.
+func TestTable(t *testing.T) {
+	joined := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	rows := []*tableRow{
+		{Name: "ann", Age: 31, Score: 9.5, Joined: joined},
+		nil,
+		{Name: "<bob>", Score: math.NaN(), secret: "x"},
+	}
+
+	t.Logf("Should render slices of structs as data resources and HTML tables.")
+
+	if !isTable(rows) || !isTable([2]tableRow{}) || isTable([]time.Time{}) || isTable([]int{}) || isTable(tableRow{}) {
+		t.Fatalf("\t%s Expected only slices and arrays of structs to be tables.", failure)
+	}
+
+	data := autoRender(rows)
+	resource, err := json.Marshal(data.Data[MIMETypeDataResource])
+	if err != nil {
+		t.Fatalf("\t%s Could not encode the data resource: %v.", failure, err)
+	}
+	want := `{"data":[` +
+		`{"Age":31,"Joined":"2021-03-04T05:06:07Z","Manager":null,"Name":"ann","Score":9.5,"index":0},` +
+		`{"Age":null,"Joined":null,"Manager":null,"Name":null,"Score":null,"index":1},` +
+		`{"Age":0,"Joined":"0001-01-01T00:00:00Z","Manager":null,"Name":"\u003cbob\u003e","Score":null,"index":2}],` +
+		`"schema":{"fields":[{"name":"index","type":"integer"},{"name":"Name","type":"string"},{"name":"Age","type":"integer"},` +
+		`{"name":"Score","type":"number"},{"name":"Joined","type":"datetime"},{"name":"Manager","type":"any"}],"primaryKey":["index"]}}`
+	if string(resource) != want {
+		t.Fatalf("\t%s Expected the data resource\n%s\ngot\n%s.", failure, want, resource)
+	}
+	html, _ := data.Data[MIMETypeHTML].(string)
+	if !strings.Contains(html, "<th>Joined</th><th>Manager</th></tr>") || !strings.Contains(html, "<tr><th>2</th><td>&lt;bob&gt;</td><td>0</td><td></td>") {
+		t.Fatalf("\t%s Expected the HTML table, got %s.", failure, html)
+	}
+	t.Logf("\t%s Rendered the table.", success)
+
+	t.Logf("Should only show the first rows of large tables as HTML.")
+
+	data = autoRender(make([]tableRow, maxHTMLTableRows+1))
+	html, _ = data.Data[MIMETypeHTML].(string)
+	if strings.Count(html, "<tr>") != maxHTMLTableRows+1 || !strings.Contains(html, "100 of 101 rows shown.") {
+		t.Fatalf("\t%s Expected %d rows in the HTML table.", failure, maxHTMLTableRows)
+	}
+	t.Logf("\t%s Cut the HTML table.", success)
+}
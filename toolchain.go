@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// Go only loads a plugin built by the same Go release as the program, for the same
+// platform, with the same build flags and the same versions of the packages they share,
+// and crashes or refuses to load it otherwise. The import cache keys plugins by the
+// toolchain, and the kernel checks the build information of a plugin before loading it.
+
+// pluginSettings are the build settings a plugin must share with the kernel. The other
+// settings, like -ldflags or the VCS revision, do not change the packages it shares.
+var pluginSettings = []string{
+	"-asan", "-gcflags", "-msan", "-race", "-tags", "-trimpath",
+	"CGO_ENABLED", "GO386", "GOAMD64", "GOARCH", "GOARM", "GOARM64", "GOMIPS", "GOMIPS64", "GOOS", "GOPPC64", "GORISCV64", "GOWASM",
+}
+
+// toolchain is how the kernel or a plugin was built.
+type toolchain struct {
+	goVersion string
+	settings  map[string]string // the pluginSettings it was built with
+	deps      map[string]string // the versions of its modules, by path
+}
+
+// kernelToolchain returns how the kernel was built.
+func kernelToolchain() *toolchain {
+	t := &toolchain{
+		goVersion: runtime.Version(),
+		settings:  map[string]string{"GOOS": runtime.GOOS, "GOARCH": runtime.GOARCH},
+		deps:      map[string]string{},
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		t.read(info)
+	}
+	return t
+}
+
+// read reads the settings and modules of the toolchain from info.
+func (t *toolchain) read(info *debug.BuildInfo) {
+	wanted := map[string]bool{}
+	for _, key := range pluginSettings {
+		wanted[key] = true
+	}
+	for _, s := range info.Settings {
+		if wanted[s.Key] {
+			t.settings[s.Key] = s.Value
+		}
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		t.deps[dep.Path] = dep.Version
+	}
+}
+
+// key identifies the plugins the kernel can load in the import cache. Besides the Go
+// release and the platform, it hashes the build flags and the version of Go+, which the
+// bindings are generated for.
+func (t *toolchain) key() string {
+	var lines []string
+	for key, value := range t.settings {
+		lines = append(lines, key+"="+value)
+	}
+	sort.Strings(lines)
+	lines = append(lines, "github.com/goplus/gop "+t.deps["github.com/goplus/gop"])
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return fmt.Sprintf("%s-%s-%s-%s", t.goVersion, t.settings["GOOS"], t.settings["GOARCH"], hex.EncodeToString(sum[:4]))
+}
+
+// buildFlags returns the flags and the environment of `go build` building plugins the
+// kernel can load.
+func (t *toolchain) buildFlags() (flags, env []string) {
+	for _, key := range []string{"-asan", "-msan", "-race", "-trimpath"} {
+		if t.settings[key] == "true" {
+			flags = append(flags, key)
+		}
+	}
+	for _, key := range []string{"-gcflags", "-tags"} {
+		if value := t.settings[key]; value != "" {
+			flags = append(flags, key+"="+value)
+		}
+	}
+	for _, key := range pluginSettings {
+		if value := t.settings[key]; value != "" && !strings.HasPrefix(key, "-") {
+			env = append(env, key+"="+value)
+		}
+	}
+	return flags, env
+}
+
+// check returns an error if the kernel cannot load the plugin at path.
+func (t *toolchain) check(path string) error {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read the build information of %s: %v", path, err)
+	}
+	p := &toolchain{goVersion: info.GoVersion, settings: map[string]string{}, deps: map[string]string{}}
+	p.read(info)
+
+	var mismatches []string
+	if p.goVersion != t.goVersion {
+		mismatches = append(mismatches, fmt.Sprintf("Go %s (the kernel has %s)", p.goVersion, t.goVersion))
+	}
+	for _, key := range pluginSettings {
+		if p.settings[key] != t.settings[key] {
+			mismatches = append(mismatches, fmt.Sprintf("%s=%q (the kernel has %q)", key, p.settings[key], t.settings[key]))
+		}
+	}
+	var paths []string
+	for path := range p.deps {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if want, ok := t.deps[path]; ok && want != p.deps[path] {
+			mismatches = append(mismatches, fmt.Sprintf("%s %s (the kernel has %s)", path, p.deps[path], want))
+		}
+	}
+	if len(mismatches) != 0 {
+		return fmt.Errorf("it was built with %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
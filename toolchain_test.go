@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestToolchain tests keying plugins by the toolchain of the kernel, and checking them
+// before loading them.
+func TestToolchain(t *testing.T) {
+	t.Logf("Should key plugins by the Go release, the platform and the build flags.")
+
+	kernel := kernelToolchain()
+	if kernel.key() != kernelToolchain().key() || !strings.HasPrefix(kernel.key(), kernel.goVersion+"-") {
+		t.Fatalf("\t%s Expected a stable key starting with the Go release, got %q.", failure, kernel.key())
+	}
+	base := &toolchain{goVersion: "go1.20", settings: map[string]string{"GOOS": "linux", "GOARCH": "amd64"}, deps: map[string]string{}}
+	keys := map[string]bool{base.key(): true}
+	for _, other := range []*toolchain{
+		{goVersion: "go1.21", settings: base.settings, deps: base.deps},
+		{goVersion: "go1.20", settings: map[string]string{"GOOS": "linux", "GOARCH": "arm64"}, deps: base.deps},
+		{goVersion: "go1.20", settings: map[string]string{"GOOS": "linux", "GOARCH": "amd64", "-race": "true"}, deps: base.deps},
+		{goVersion: "go1.20", settings: base.settings, deps: map[string]string{"github.com/goplus/gop": "v0.7.17"}},
+	} {
+		if keys[other.key()] {
+			t.Fatalf("\t%s Expected another key for %+v, got %q.", failure, other, other.key())
+		}
+		keys[other.key()] = true
+	}
+	t.Logf("\t%s Keyed them.", success)
+
+	t.Logf("Should build plugins with the flags of the kernel.")
+
+	race := &toolchain{settings: map[string]string{"-race": "true", "-tags": "netgo", "-trimpath": "false", "CGO_ENABLED": "1", "GOAMD64": "v3"}}
+	flags, env := race.buildFlags()
+	if want := []string{"-race", "-tags=netgo"}; !reflect.DeepEqual(flags, want) {
+		t.Fatalf("\t%s Expected the flags %q, got %q.", failure, want, flags)
+	}
+	if want := []string{"CGO_ENABLED=1", "GOAMD64=v3"}; !reflect.DeepEqual(env, want) {
+		t.Fatalf("\t%s Expected the environment %q, got %q.", failure, want, env)
+	}
+	t.Logf("\t%s Used them.", success)
+
+	t.Logf("Should refuse binaries built differently from the kernel.")
+
+	// The test binary is built like the kernel, since it is the kernel.
+	if err := kernel.check(os.Args[0]); err != nil {
+		t.Fatalf("\t%s Expected the test binary to match, got %v.", failure, err)
+	}
+	other := &toolchain{goVersion: "go1.0", settings: map[string]string{}, deps: map[string]string{}}
+	for k, v := range kernel.settings {
+		other.settings[k] = v
+	}
+	other.settings["GOOS"] = "plan9"
+	other.deps["github.com/goplus/gop"] = "v0.1.0"
+	err := other.check(os.Args[0])
+	for _, want := range []string{"Go " + kernel.goVersion + " (the kernel has go1.0)", `GOOS="` + kernel.settings["GOOS"] + `" (the kernel has "plan9")`, "(the kernel has v0.1.0)"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("\t%s Expected an error with %q, got %v.", failure, want, err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "toolchain")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	defer os.RemoveAll(dir)
+	garbage := filepath.Join(dir, "bindings.so")
+	ioutil.WriteFile(garbage, []byte("not a plugin"), 0644)
+	if err := kernel.check(garbage); err == nil || !strings.Contains(err.Error(), "could not read the build information") {
+		t.Fatalf("\t%s Expected the file to be refused, got %v.", failure, err)
+	}
+	t.Logf("\t%s Refused them.", success)
+}
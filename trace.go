@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/ast"
+	"github.com/goplus/gop/lib/builtin"
+	"github.com/goplus/gop/token"
+)
+
+func init() {
+	lineMagics["trace"] = evalTraceMagic
+	documentMagic(traceSyntax)
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("_gopyter_trace", traceWrite, execTraceWrite),
+	)
+}
+
+var traceSyntax = &magicSyntax{
+	name:  "%trace",
+	usage: []string{"", "var name", "off [name]"},
+	doc: "`%trace var name` logs every assignment to the variable `name` made by the cells executed next, " +
+		"with the cell, the statement and the old and new values; `%trace` lists the assignments logged, " +
+		"and `%trace off` stops tracing `name`, or every variable.",
+	args: []magicParam{
+		{name: "var|off", help: "starts or stops tracing a variable", optional: true},
+		{name: "name", help: "the variable", optional: true},
+	},
+}
+
+// Writes to traced variables are trapped by instrumenting the cells: each statement
+// assigning to a traced top-level variable is followed by a call to _gopyter_trace
+// with the new value. Writes made through pointers, or by functions compiled before
+// the variable was traced, are not seen.
+
+// varTracer is the variables traced with %trace var, and the writes logged.
+type varTracer struct {
+	last   map[string]string // the last value of each traced variable
+	writes []traceEntry
+}
+
+// traceEntry is a write to a traced variable.
+type traceEntry struct {
+	Name     string
+	Count    int    // the execution count of the cell
+	Line     int    // the line of the statement in the cell
+	Stmt     string // the source of the statement
+	Old, New string
+}
+
+func (e traceEntry) String() string {
+	return fmt.Sprintf("%s: %s → %s by `%s` in In[%d] line %d", e.Name, e.Old, e.New, e.Stmt, e.Count, e.Line)
+}
+
+// undeclaredValue stands for the value of a traced variable before its declaration.
+const undeclaredValue = "(undeclared)"
+
+// traceWrite logs the write of value to the traced variable name, by the statement stmt
+// at line of the cell being evaluated, and prints it to the standard error of the cell.
+func traceWrite(name string, line int, stmt string, value interface{}) {
+	s := activeSession
+	if s == nil || s.traces == nil {
+		return
+	}
+	old, ok := s.traces.last[name]
+	if !ok {
+		// No longer traced.
+		return
+	}
+	e := traceEntry{
+		Name:  name,
+		Count: ExecCounter,
+		Line:  line,
+		Stmt:  stmt,
+		Old:   old,
+		New:   s.redactor.redact(resultPrinter.sprint(value)),
+	}
+	s.traces.last[name] = e.New
+	s.traces.writes = append(s.traces.writes, e)
+	fmt.Fprintln(os.Stderr, "trace: "+e.String())
+}
+
+func execTraceWrite(_ int, p *gop.Context) {
+	args := p.GetArgs(4)
+	traceWrite(args[0].(string), args[1].(int), args[2].(string), args[3])
+	p.Ret(4)
+}
+
+// traceCalls matches the calls to _gopyter_trace that instrumentTraces inserts.
+var traceCalls = regexp.MustCompile(`; _gopyter_trace\("(?:[^"\\]|\\.)*", \d+, "(?:[^"\\]|\\.)*", [\pL\pN_]+\)`)
+
+// instrumentTraces returns code, Go+ code free of magics, with a call to _gopyter_trace
+// after each statement assigning to a traced variable.
+func (s *Session) instrumentTraces(code string) string {
+	if s.traces == nil || len(s.traces.last) == 0 {
+		return code
+	}
+	c, err := parseCell(code)
+	if err != nil {
+		// Evaluating it reports the error.
+		return code
+	}
+	writes := make(map[*ast.Ident]string)
+	for name := range s.traces.last {
+		for _, ident := range c.topLevelRefs(name) {
+			writes[ident] = name
+		}
+	}
+	type insertion struct {
+		at   int
+		call string
+	}
+	var insertions []insertion
+	instrument := func(stmts []ast.Stmt) {
+		for _, stmt := range stmts {
+			for {
+				labeled, ok := stmt.(*ast.LabeledStmt)
+				if !ok {
+					break
+				}
+				stmt = labeled.Stmt
+			}
+			var names []string
+			add := func(x ast.Expr) {
+				if ident, ok := x.(*ast.Ident); ok && writes[ident] != "" {
+					names = append(names, ident.Name)
+				}
+			}
+			switch stmt := stmt.(type) {
+			case *ast.AssignStmt:
+				for _, lhs := range stmt.Lhs {
+					add(lhs)
+				}
+			case *ast.IncDecStmt:
+				add(stmt.X)
+			case *ast.DeclStmt:
+				if decl, ok := stmt.Decl.(*ast.GenDecl); ok && decl.Tok == token.VAR {
+					for _, spec := range decl.Specs {
+						for _, ident := range spec.(*ast.ValueSpec).Names {
+							add(ident)
+						}
+					}
+				}
+			}
+			if len(names) == 0 {
+				continue
+			}
+			start, end := c.offset(stmt.Pos()), c.offset(stmt.End())
+			line := strings.Count(code[:start], "\n") + 1
+			src := strings.Join(strings.Fields(code[start:end]), " ")
+			for _, name := range names {
+				call := fmt.Sprintf("; _gopyter_trace(%q, %d, %s, %s)", name, line, strconv.Quote(src), name)
+				insertions = append(insertions, insertion{end, call})
+			}
+		}
+	}
+	inspectAST(c.file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			instrument(n.List)
+		case *ast.CaseClause:
+			instrument(n.Body)
+		case *ast.CommClause:
+			instrument(n.Body)
+		}
+		return true
+	})
+	// The statements of blocks end before the statements around them.
+	sort.SliceStable(insertions, func(i, j int) bool { return insertions[i].at < insertions[j].at })
+	var b strings.Builder
+	last := 0
+	for _, in := range insertions {
+		b.WriteString(code[last:in.at] + in.call)
+		last = in.at
+	}
+	b.WriteString(code[last:])
+	return b.String()
+}
+
+// evalTraceMagic implements `%trace var name`, which traces the writes to a variable,
+// `%trace off [name]`, which stops tracing it or every variable, and `%trace`, which
+// lists the writes logged.
+func evalTraceMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := traceSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	s := kernel.session
+	name := parsed.arg(1)
+	switch parsed.arg(0) {
+	case "":
+		if s.traces == nil || len(s.traces.writes) == 0 {
+			fmt.Fprintln(outerr.out, "No writes to traced variables.")
+			return nil
+		}
+		for _, e := range s.traces.writes {
+			fmt.Fprintln(outerr.out, e)
+		}
+	case "var":
+		if !token.IsIdentifier(name) || name == "_" {
+			return traceSyntax.errorf("expected the name of a variable, got %q", name)
+		}
+		if s.traces == nil {
+			s.traces = &varTracer{last: make(map[string]string)}
+		}
+		s.traces.last[name] = undeclaredValue
+		if vals, err := s.Peek(name); err == nil {
+			s.traces.last[name] = s.redactor.redact(resultPrinter.sprint(vals...))
+		}
+		fmt.Fprintf(outerr.out, "Tracing the writes to %s, now %s.\n", name, s.traces.last[name])
+	case "off":
+		if s.traces == nil {
+			return nil
+		}
+		if name == "" {
+			s.traces.last = make(map[string]string)
+			fmt.Fprintln(outerr.out, "No variables are traced.")
+			return nil
+		}
+		if _, ok := s.traces.last[name]; !ok {
+			return fmt.Errorf("%s is not traced", name)
+		}
+		delete(s.traces.last, name)
+		fmt.Fprintf(outerr.out, "%s is no longer traced.\n", name)
+	default:
+		return traceSyntax.errorf("expected var or off, got %q", parsed.arg(0))
+	}
+	return nil
+}
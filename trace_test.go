@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestTraceVar tests logging the writes to a traced variable.
+func TestTraceVar(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+	for _, code := range []string{"x := 1", "%trace var x"} {
+		if _, err := kernel.doEvalGop(outerr, code); err != nil {
+			t.Fatalf("\t%s Could not evaluate %q: %v.", failure, code, err)
+		}
+	}
+
+	t.Logf("Should log the writes to the variable, with the cell, the statement and the values.")
+
+	defer func(count int) { ExecCounter = count }(ExecCounter)
+	ExecCounter = 3
+	cells := []string{
+		"x = 2\nfor i := 0; i < 2; i++ {\n\tx += i * 5\n}\ny := 3; x++",
+		"if true {\n\tx := 100\n\tx++\n}\nz := x",
+	}
+	for _, code := range cells {
+		if _, err := kernel.doEvalGop(outerr, code); err != nil {
+			t.Fatalf("\t%s Could not evaluate %q: %v.", failure, code, err)
+		}
+	}
+	want := []string{
+		"x: 1 → 2 by `x = 2` in In[3] line 1",
+		"x: 2 → 2 by `x += i * 5` in In[3] line 3",
+		"x: 2 → 7 by `x += i * 5` in In[3] line 3",
+		"x: 7 → 8 by `x++` in In[3] line 5",
+	}
+	var got []string
+	for _, e := range kernel.session.traces.writes {
+		got = append(got, e.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("\t%s Expected the writes %q, got %q.", failure, want, got)
+	}
+	t.Logf("\t%s Logged %d writes.", success, len(got))
+
+	t.Logf("Should keep the source of the cells as they were typed.")
+
+	if src := kernel.session.source(); !strings.Contains(src, cells[0]+"\n"+cells[1]) {
+		t.Fatalf("\t%s Expected the source to hold the cells, got %q.", failure, src)
+	}
+	t.Logf("\t%s Kept it.", success)
+
+	t.Logf("Should stop logging the writes.")
+
+	for _, code := range []string{"%trace off x", "x = 9"} {
+		if _, err := kernel.doEvalGop(outerr, code); err != nil {
+			t.Fatalf("\t%s Could not evaluate %q: %v.", failure, code, err)
+		}
+	}
+	if len(kernel.session.traces.writes) != len(want) {
+		t.Fatalf("\t%s Expected no more writes, got %v.", failure, kernel.session.traces.writes)
+	}
+	if _, err := kernel.doEvalGop(outerr, "%trace var 1x"); err == nil {
+		t.Fatalf("\t%s Expected tracing an invalid name to fail.", failure)
+	}
+	t.Logf("\t%s Stopped tracing x.", success)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The kernel can trace the handling of messages with OpenTelemetry spans, so that slow
+// kernels in large deployments can be diagnosed: each message gets a span, with child
+// spans for decoding it, for parsing, compiling (which resolves the imports) and
+// executing cells, and for every message published in response. Spans are exported in
+// batches to an OTLP/HTTP collector, using the JSON encoding of the protocol.
+
+// tracer exports the spans of the kernel. It is nil when tracing is disabled, in which
+// case startSpan returns nil spans that do nothing.
+var tracer *otlpTracer
+
+// otlpTracer sends spans to an OTLP/HTTP endpoint in batches.
+type otlpTracer struct {
+	endpoint string
+	service  string
+	spans    chan *span
+}
+
+// span is an operation traced by the kernel.
+type span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	hasParent  bool
+	name       string
+	startTime  time.Time
+	endTime    time.Time
+	attributes map[string]interface{}
+	err        string
+}
+
+// startTracing enables tracing if an OTLP endpoint is configured, either by the
+// configuration or by the standard OTEL_EXPORTER_OTLP_ENDPOINT environment variable.
+func startTracing(config KernelConfig) {
+	endpoint := config.OTLPEndpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	}
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return
+	}
+
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "gopyter"
+	}
+
+	tracer = &otlpTracer{
+		endpoint: endpoint,
+		service:  service,
+		spans:    make(chan *span, 1024),
+	}
+	go tracer.export()
+}
+
+// startSpan starts a span named name, as a child of parent if it is not nil.
+func startSpan(parent *span, name string) *span {
+	if tracer == nil {
+		return nil
+	}
+	s := &span{name: name, startTime: time.Now(), attributes: make(map[string]interface{})}
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+		s.hasParent = true
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// setName renames the span, e.g. once the type of the message it traces is known.
+func (s *span) setName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+// setAttribute sets an attribute of the span. value must be a string, bool or int.
+func (s *span) setAttribute(key string, value interface{}) {
+	if s != nil {
+		s.attributes[key] = value
+	}
+}
+
+// setError marks the span as failed with err, if err is not nil.
+func (s *span) setError(err error) {
+	if s != nil && err != nil {
+		s.err = err.Error()
+	}
+}
+
+// end ends the span and queues it for export. Spans are dropped if the collector cannot
+// keep up, so tracing never slows the kernel down.
+func (s *span) end() {
+	if s == nil {
+		return
+	}
+	s.endTime = time.Now()
+	select {
+	case tracer.spans <- s:
+	default:
+	}
+}
+
+// export sends the queued spans to the collector every few seconds.
+func (t *otlpTracer) export() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) < 256 {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := t.send(batch); err != nil {
+			log.Printf("Error exporting %d spans: %v\n", len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+// send posts a batch of spans to the collector.
+func (t *otlpTracer) send(batch []*span) error {
+	body, err := json.Marshal(t.request(batch))
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector replied %s", resp.Status)
+	}
+	return nil
+}
+
+// request builds the ExportTraceServiceRequest for a batch, in the JSON encoding of
+// OTLP.
+func (t *otlpTracer) request(batch []*span) map[string]interface{} {
+	spans := make([]interface{}, 0, len(batch))
+	for _, s := range batch {
+		otlpSpan := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.startTime.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.endTime.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attributes),
+		}
+		if s.hasParent {
+			otlpSpan["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != "" {
+			otlpSpan["status"] = map[string]interface{}{"code": 2, "message": s.err} // STATUS_CODE_ERROR
+		}
+		spans = append(spans, otlpSpan)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{
+						"service.name":    t.service,
+						"service.version": Version,
+					}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "gopyter"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// otlpAttributes converts attributes to OTLP key-values.
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	kvs := make([]interface{}, 0, len(attributes))
+	for key, value := range attributes {
+		var v map[string]interface{}
+		switch value := value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": value}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+		default:
+			v = map[string]interface{}{"stringValue": value}
+		}
+		kvs = append(kvs, map[string]interface{}{"key": key, "value": v})
+	}
+	return kvs
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestTracing tests that spans are exported to the collector in OTLP/JSON.
+func TestTracing(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("\t%s Invalid request body: %v.", failure, err)
+		}
+		received <- req
+	}))
+	defer server.Close()
+
+	defer func(t *otlpTracer) { tracer = t }(tracer)
+	tracer = &otlpTracer{endpoint: server.URL, service: "gopyter-test", spans: make(chan *span, 10)}
+
+	root := startSpan(nil, "jupyter execute_request")
+	child := startSpan(root, "gop execute")
+	child.setAttribute("gop.instructions", 3)
+	child.setError(errors.New("boom"))
+	child.end()
+	root.end()
+
+	batch := []*span{<-tracer.spans, <-tracer.spans}
+	if err := tracer.send(batch); err != nil {
+		t.Fatalf("\t%s Sending the spans failed: %v.", failure, err)
+	}
+
+	req := <-received
+	resourceSpans := req["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("\t%s Expected 2 spans, got %d.", failure, len(spans))
+	}
+
+	exported := spans[0].(map[string]interface{})
+	parent := spans[1].(map[string]interface{})
+	if exported["name"] != "gop execute" || exported["parentSpanId"] != parent["spanId"] || exported["traceId"] != parent["traceId"] {
+		t.Fatalf("\t%s Unexpected spans %v.", failure, spans)
+	}
+	if exported["status"].(map[string]interface{})["message"] != "boom" {
+		t.Fatalf("\t%s Expected the error in the status of %v.", failure, exported)
+	}
+	t.Logf("\t%s Exported the spans.", success)
+
+	tracer = nil
+	if s := startSpan(nil, "disabled"); s != nil {
+		t.Fatalf("\t%s Expected no span with tracing disabled.", failure)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	lineMagics["unused"] = evalUnusedMagic
+	documentMagic(unusedSyntax)
+}
+
+var unusedSyntax = &magicSyntax{
+	name:  "%unused",
+	usage: []string{"", "on [cells]", "off"},
+	doc: "Lists the variables, functions and imports declared by executed cells that no cell used since. " +
+		"`%unused on` reports them after the cell that makes `cells` cells (3 by default) executed without using them; " +
+		"`%unused off` stops reporting them.",
+	args: []magicParam{
+		{name: "on|off", help: "enables or disables the reports after cells", optional: true},
+		{name: "cells", help: "the number of cells after which names are reported", optional: true},
+	},
+}
+
+// defaultUnusedCells is the number of cells after which %unused on reports unused names.
+const defaultUnusedCells = 3
+
+// unusedName is a name declared by an executed cell that no cell executed since used.
+type unusedName struct {
+	Name   string
+	Import bool // Name is the path of an imported package
+	Count  int  // the execution count of the cell declaring it
+	Since  int  // the number of cells executed after it
+}
+
+func (u unusedName) String() string {
+	if u.Import {
+		return fmt.Sprintf("import %q in In[%d]", u.Name, u.Count)
+	}
+	return fmt.Sprintf("%s in In[%d]", u.Name, u.Count)
+}
+
+// unusedNames returns the names declared by the executed cells and not read since: the
+// packages imported by a cell and the variables, functions and types it last wrote, in
+// the order of the cells.
+func (g *depGraph) unusedNames() []unusedName {
+	readSince := func(i int, name string) bool {
+		for _, cell := range g.cells[i:] {
+			for _, read := range cell.Reads {
+				if read == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	var unused []unusedName
+	for i, cell := range g.cells {
+		since := len(g.cells) - 1 - i
+		if c, err := parseCell(cell.code); err == nil {
+			for _, spec := range c.file.Imports {
+				p, err := strconv.Unquote(spec.Path.Value)
+				if err != nil {
+					continue
+				}
+				name := path.Base(p)
+				if spec.Name != nil {
+					name = spec.Name.Name
+				}
+				if name != "_" && name != "." && !readSince(i, name) {
+					unused = append(unused, unusedName{Name: p, Import: true, Count: cell.Count, Since: since})
+				}
+			}
+		}
+		for _, name := range cell.Writes {
+			if g.versions[name] == cell.Count && name != "_" && !strings.HasPrefix(name, "_gopyter") && !readSince(i, name) {
+				unused = append(unused, unusedName{Name: name, Count: cell.Count, Since: since})
+			}
+		}
+	}
+	return unused
+}
+
+// unusedAdvisory returns an advisory listing the names that the cells executed since
+// their declaration, as many as the UnusedCells setting of the configuration, did not
+// use, if there are. Each name is reported once, after the cell reaching the setting.
+func (kernel *Kernel) unusedAdvisory() (Data, bool) {
+	cells := kernel.config.UnusedCells
+	if cells <= 0 {
+		return Data{}, false
+	}
+	var names []unusedName
+	for _, u := range kernel.session.deps.unusedNames() {
+		if u.Since == cells {
+			names = append(names, u)
+		}
+	}
+	if len(names) == 0 {
+		return Data{}, false
+	}
+
+	summary := fmt.Sprintf("%d names unused in the %d cells since their declaration", len(names), cells)
+	if len(names) == 1 {
+		summary = fmt.Sprintf("1 name unused in the %d cells since its declaration", cells)
+	}
+	var text, markup strings.Builder
+	text.WriteString("\x1b[36m" + summary + ":\x1b[0m\n")
+	markup.WriteString(`<details style="border-left:4px solid #5bc0de;color:#31708f;padding:2px 8px">` +
+		"<summary>ⓘ " + html.EscapeString(summary) + "</summary><ul>")
+	for _, u := range names {
+		text.WriteString("  " + u.String() + "\n")
+		markup.WriteString("<li><code>" + html.EscapeString(u.String()) + "</code></li>")
+	}
+	markup.WriteString("</ul></details>")
+	return Data{Data: MIMEMap{MIMETypeText: text.String(), MIMETypeHTML: markup.String()}}, true
+}
+
+// evalUnusedMagic implements `%unused`, which lists the unused names of the session, and
+// `%unused on [cells]` and `%unused off`, which enable or disable reporting them after
+// cells.
+func evalUnusedMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := unusedSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	switch parsed.arg(0) {
+	case "":
+		names := kernel.session.deps.unusedNames()
+		if len(names) == 0 {
+			fmt.Fprintln(outerr.out, "No unused names.")
+		}
+		for _, u := range names {
+			fmt.Fprintf(outerr.out, "%s, unused in %d cells\n", u, u.Since)
+		}
+		return nil
+	case "on":
+		cells := defaultUnusedCells
+		if arg := parsed.arg(1); arg != "" {
+			if cells, err = strconv.Atoi(arg); err != nil || cells < 1 {
+				return unusedSyntax.errorf("expected a positive number of cells, got %q", arg)
+			}
+		}
+		kernel.config.UnusedCells = cells
+		fmt.Fprintf(outerr.out, "Names unused in %d cells are reported.\n", cells)
+	case "off":
+		kernel.config.UnusedCells = 0
+		fmt.Fprintln(outerr.out, "Unused names are not reported.")
+	default:
+		return unusedSyntax.errorf("expected on or off, got %q", parsed.arg(0))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestUnusedNames tests finding the names that no cell used since their declaration.
+func TestUnusedNames(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	for i, code := range []string{
+		"import \"strings\"\nimport \"math\"\nwords := strings.Fields(\"a b\")\nscratch := 1",
+		"func double(x int) int { return 2 * x }\nn := len(words)",
+		"m := double(n)",
+		"println(math.Pi)",
+	} {
+		kernel.session.deps.record(i+1, "", code)
+	}
+
+	t.Logf("Should list the names no later cell used.")
+
+	want := []unusedName{
+		{Name: "scratch", Count: 1, Since: 3},
+		{Name: "m", Count: 3, Since: 1},
+	}
+	if got := kernel.session.deps.unusedNames(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("\t%s Expected %v, got %v.", failure, want, got)
+	}
+	t.Logf("\t%s Listed %d names.", success, len(want))
+
+	t.Logf("Should report the names once the configured number of cells did not use them.")
+
+	kernel.session.deps.record(5, "", "import \"os\"\ns := \"x\"")
+	kernel.config.UnusedCells = 4
+	data, ok := kernel.unusedAdvisory()
+	if !ok || !strings.Contains(data.Data[MIMETypeText].(string), "scratch in In[1]") || strings.Contains(data.Data[MIMETypeText].(string), "m in") {
+		t.Fatalf("\t%s Expected scratch to be reported, got %v.", failure, data.Data[MIMETypeText])
+	}
+	if !strings.Contains(data.Data[MIMETypeHTML].(string), "<details") {
+		t.Fatalf("\t%s Expected a collapsible advisory, got %v.", failure, data.Data[MIMETypeHTML])
+	}
+	kernel.config.UnusedCells = 0
+	if _, ok := kernel.unusedAdvisory(); ok {
+		t.Fatalf("\t%s Expected no advisory when it is disabled.", failure)
+	}
+	kernel.config.UnusedCells = 1
+	if data, ok := kernel.unusedAdvisory(); ok {
+		t.Fatalf("\t%s Expected no name unused in exactly 1 cell, got %v.", failure, data.Data[MIMETypeText])
+	}
+	t.Logf("\t%s Reported scratch.", success)
+
+	t.Logf("Should list the imports no later cell used.")
+
+	unused := kernel.session.deps.unusedNames()
+	if last := unused[len(unused)-1]; last.Name != "s" || unused[len(unused)-2] != (unusedName{Name: "os", Import: true, Count: 5}) {
+		t.Fatalf("\t%s Expected the import of os and s to be unused, got %v.", failure, unused)
+	}
+	t.Logf("\t%s Listed the import of os.", success)
+}
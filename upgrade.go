@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// latestReleaseURL is the GitHub API endpoint describing the latest release of gopyter.
+const latestReleaseURL = "https://api.github.com/repos/wangfenjin/gopyter/releases/latest"
+
+// upgradeTimeout bounds the download of a release.
+const upgradeTimeout = 5 * time.Minute
+
+// release is the description of a release by the GitHub API.
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// asset returns the download URL of the asset of r called name.
+func (r *release) asset(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// upgrade replaces the gopyter binary by the one of the latest release, and reinstalls
+// the kernelspec running it.
+type upgrade struct {
+	releaseURL string // where the latest release is described
+	executable string // the binary to replace
+	kernelspec string // the directory of the kernelspec
+	goos       string
+	goarch     string
+	force      bool // whether to reinstall the current version
+	check      bool // whether to only report whether an upgrade is available
+}
+
+// runUpgrade implements `gopyter upgrade`.
+func runUpgrade(args []string) error {
+	flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	releaseURL := flags.String("release-url", latestReleaseURL, "the URL describing the latest release, as the GitHub API does, e.g. of a mirror")
+	kernelspec := flags.String("kernelspec", defaultKernelspecDir(), "the directory of the kernelspec to reinstall")
+	force := flags.Bool("force", false, "reinstall the binary even if it is the latest release")
+	check := flags.Bool("check", false, "only report whether a newer release is available")
+	flags.Parse(args)
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return err
+	}
+	u := &upgrade{
+		releaseURL: *releaseURL,
+		executable: executable,
+		kernelspec: *kernelspec,
+		goos:       runtime.GOOS,
+		goarch:     runtime.GOARCH,
+		force:      *force,
+		check:      *check,
+	}
+	return u.run(os.Stdout)
+}
+
+// defaultKernelspecDir returns the directory where the installation instructions put
+// the kernelspec of gopyter, for the user running it.
+func defaultKernelspecDir() string {
+	if dir := os.Getenv("JUPYTER_DATA_DIR"); dir != "" {
+		return filepath.Join(dir, "kernels", "gopyter")
+	}
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Jupyter", "kernels", "gopyter")
+	case "windows":
+		return filepath.Join(os.Getenv("APPDATA"), "jupyter", "kernels", "gopyter")
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "jupyter", "kernels", "gopyter")
+	}
+	return filepath.Join(home, ".local", "share", "jupyter", "kernels", "gopyter")
+}
+
+// run performs the upgrade, reporting its progress to out.
+func (u *upgrade) run(out io.Writer) error {
+	client := &http.Client{Timeout: upgradeTimeout}
+	var latest release
+	if err := getJSON(client, u.releaseURL, &latest); err != nil {
+		return fmt.Errorf("could not find the latest release: %v", err)
+	}
+	version := strings.TrimPrefix(latest.TagName, "v")
+	if version == "" {
+		return fmt.Errorf("the latest release at %s has no tag", u.releaseURL)
+	}
+	cmp, err := compareVersions(version, Version)
+	if err != nil {
+		return fmt.Errorf("the latest release at %s has an invalid version: %v", u.releaseURL, err)
+	}
+	switch {
+	case u.force:
+	case cmp == 0:
+		fmt.Fprintf(out, "gopyter v%s is the latest release.\n", Version)
+		return nil
+	case cmp < 0:
+		fmt.Fprintf(out, "gopyter v%s is newer than the latest release v%s.\n", Version, version)
+		return nil
+	}
+	if u.check {
+		fmt.Fprintf(out, "gopyter v%s is available (this is v%s); run `gopyter upgrade` to install it.\n", version, Version)
+		return nil
+	}
+
+	// Releases have a binary per platform, like gopyter_linux_amd64, and list their
+	// SHA-256 in checksums.txt.
+	name := fmt.Sprintf("gopyter_%s_%s", u.goos, u.goarch)
+	if u.goos == "windows" {
+		name += ".exe"
+	}
+	url, ok := latest.asset(name)
+	if !ok {
+		return fmt.Errorf("the release v%s has no binary %s", version, name)
+	}
+	sumsURL, ok := latest.asset("checksums.txt")
+	if !ok {
+		return fmt.Errorf("the release v%s has no checksums.txt to verify %s", version, name)
+	}
+	fmt.Fprintf(out, "Downloading gopyter v%s...\n", version)
+	binary, err := download(client, url)
+	if err != nil {
+		return fmt.Errorf("could not download %s: %v", name, err)
+	}
+	sums, err := download(client, sumsURL)
+	if err != nil {
+		return fmt.Errorf("could not download the checksums: %v", err)
+	}
+	if err := verifyChecksum(sums, name, binary); err != nil {
+		return err
+	}
+
+	if err := u.install(binary, version); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Installed gopyter v%s at %s.\n", version, u.executable)
+	if err := installKernelspec(u.kernelspec, u.executable); err != nil {
+		return fmt.Errorf("could not reinstall the kernelspec: %v", err)
+	}
+	fmt.Fprintf(out, "Reinstalled the kernelspec in %s.\n", u.kernelspec)
+	return nil
+}
+
+// install replaces the executable by binary, once it ran and reported version.
+func (u *upgrade) install(binary []byte, version string) error {
+	f, err := ioutil.TempFile(filepath.Dir(u.executable), ".gopyter-upgrade")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(binary)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0755); err != nil {
+		return err
+	}
+
+	// A binary that does not run, e.g. for another platform, must not replace one that does.
+	output, err := exec.Command(f.Name(), "version", "-json").Output()
+	var v versionInfo
+	if err != nil || json.Unmarshal(output, &v) != nil {
+		return fmt.Errorf("the binary of v%s does not run: %v", version, err)
+	}
+	if v.Kernel != version {
+		return fmt.Errorf("the binary of v%s reports v%s", version, v.Kernel)
+	}
+
+	// Windows cannot replace the binary of a running program, but can rename it.
+	if u.goos == "windows" {
+		os.Remove(u.executable + ".old")
+		if err := os.Rename(u.executable, u.executable+".old"); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.Name(), u.executable)
+}
+
+// verifyChecksum returns an error unless sums, in the format of sha256sum, has the
+// SHA-256 of binary for name.
+func verifyChecksum(sums []byte, name string, binary []byte) error {
+	sum := sha256.Sum256(binary)
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+			return fmt.Errorf("the checksum of %s does not match checksums.txt", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("checksums.txt has no checksum for %s", name)
+}
+
+// compareVersions compares the semantic versions a and b, without their "v" prefix,
+// and returns -1, 0 or +1 as a is older, the same as, or newer than b. Build metadata
+// is ignored, and a pre-release is older than its release, see https://semver.org.
+func compareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < 3; i++ {
+		if c := compareNumbers(va.core[i], vb.core[i]); c != 0 {
+			return c, nil
+		}
+	}
+	switch {
+	case len(va.pre) == 0 && len(vb.pre) == 0:
+		return 0, nil
+	case len(va.pre) == 0:
+		return 1, nil
+	case len(vb.pre) == 0:
+		return -1, nil
+	}
+	for i := 0; i < len(va.pre) && i < len(vb.pre); i++ {
+		x, y := va.pre[i], vb.pre[i]
+		xNum, yNum := isNumber(x), isNumber(y)
+		switch {
+		case xNum && yNum:
+			if c := compareNumbers(x, y); c != 0 {
+				return c, nil
+			}
+		case xNum:
+			return -1, nil
+		case yNum:
+			return 1, nil
+		case x != y:
+			if x < y {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	// A pre-release with more identifiers is newer than its prefix.
+	switch {
+	case len(va.pre) < len(vb.pre):
+		return -1, nil
+	case len(va.pre) > len(vb.pre):
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// semanticVersion is a version major.minor.patch[-pre-release][+build].
+type semanticVersion struct {
+	core [3]string // major, minor and patch
+	pre  []string  // the identifiers of the pre-release
+}
+
+// parseVersion parses the semantic version v.
+func parseVersion(v string) (semanticVersion, error) {
+	var sv semanticVersion
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	core := v
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		core = v[:i]
+		sv.pre = strings.Split(v[i+1:], ".")
+		for _, id := range sv.pre {
+			if id == "" {
+				return sv, fmt.Errorf("%q is not a semantic version", v)
+			}
+		}
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return sv, fmt.Errorf("%q is not a semantic version", v)
+	}
+	for i, part := range parts {
+		if !isNumber(part) {
+			return sv, fmt.Errorf("%q is not a semantic version", v)
+		}
+		sv.core[i] = part
+	}
+	return sv, nil
+}
+
+// isNumber reports whether s is a non-empty string of decimal digits.
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compareNumbers compares the decimal numbers x and y, of any length.
+func compareNumbers(x, y string) int {
+	x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+	switch {
+	case len(x) != len(y):
+		if len(x) < len(y) {
+			return -1
+		}
+		return 1
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// installKernelspec writes the kernel.json of the kernelspec in dir to run executable.
+// It keeps the other fields and arguments of an existing kernel.json, like -config, and
+// writes the one of kernel/kernel.json.in otherwise.
+func installKernelspec(dir, executable string) error {
+	path := filepath.Join(dir, "kernel.json")
+	spec := map[string]interface{}{}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	case os.IsNotExist(err):
+		spec = map[string]interface{}{"display_name": "GoPlus", "language": "go+", "name": "go+"}
+	default:
+		return err
+	}
+	argv, _ := spec["argv"].([]interface{})
+	if len(argv) == 0 {
+		argv = []interface{}{executable, "{connection_file}"}
+	} else {
+		argv[0] = executable
+	}
+	spec["argv"] = argv
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(spec, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// getJSON decodes the JSON at url into v.
+func getJSON(client *http.Client, url string, v interface{}) error {
+	data, err := download(client, url)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// download returns the content at url.
+func download(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
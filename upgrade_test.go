@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestUpgrade tests replacing the binary by the one of the latest release, and
+// reinstalling the kernelspec.
+func TestUpgrade(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake release binary is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "upgrade")
+	if err != nil {
+		t.Fatalf("\t%s Could not create a directory: %v.", failure, err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The release binary is a script reporting its version.
+	binary := []byte("#!/bin/sh\necho '{\"kernel\": \"9.9.9\"}'\n")
+	sum := sha256.Sum256(binary)
+	checksums := hex.EncodeToString(sum[:]) + "  gopyter_linux_amd64\n"
+	tag := "v9.9.9"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest":
+			fmt.Fprintf(w, `{"tag_name": %q, "assets": [
+				{"name": "gopyter_linux_amd64", "browser_download_url": "http://%s/binary"},
+				{"name": "checksums.txt", "browser_download_url": "http://%s/checksums.txt"}]}`, tag, r.Host, r.Host)
+		case "/nochecksums":
+			fmt.Fprintf(w, `{"tag_name": %q, "assets": [
+				{"name": "gopyter_linux_amd64", "browser_download_url": "http://%s/binary"}]}`, tag, r.Host)
+		case "/binary":
+			w.Write(binary)
+		case "/checksums.txt":
+			fmt.Fprint(w, checksums)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	executable := filepath.Join(dir, "gopyter")
+	ioutil.WriteFile(executable, []byte("old"), 0755)
+	kernelspec := filepath.Join(dir, "kernels", "gopyter")
+	os.MkdirAll(kernelspec, 0755)
+	ioutil.WriteFile(filepath.Join(kernelspec, "kernel.json"), []byte(`{"argv": ["/old/gopyter", "-config", "c.json", "{connection_file}"], "display_name": "Go+ (course)"}`), 0644)
+	u := &upgrade{releaseURL: server.URL + "/latest", executable: executable, kernelspec: kernelspec, goos: "linux", goarch: "amd64"}
+	var out bytes.Buffer
+
+	t.Logf("Should refuse binaries not matching their checksums.")
+
+	checksums = strings.Repeat("0", 64) + "  gopyter_linux_amd64\n"
+	if err := u.run(&out); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("\t%s Expected the binary to be refused, got %v.", failure, err)
+	}
+	if old, _ := ioutil.ReadFile(executable); string(old) != "old" {
+		t.Fatalf("\t%s Expected the binary to be kept, got %q.", failure, old)
+	}
+	checksums = hex.EncodeToString(sum[:]) + "  gopyter_linux_amd64\n"
+	t.Logf("\t%s Refused them.", success)
+
+	t.Logf("Should refuse releases without checksums.")
+
+	noChecksums := &upgrade{releaseURL: server.URL + "/nochecksums", executable: executable, kernelspec: kernelspec, goos: "linux", goarch: "amd64"}
+	if err := noChecksums.run(&out); err == nil || !strings.Contains(err.Error(), "has no checksums.txt") {
+		t.Fatalf("\t%s Expected the release to be refused, got %v.", failure, err)
+	}
+	if old, _ := ioutil.ReadFile(executable); string(old) != "old" {
+		t.Fatalf("\t%s Expected the binary to be kept, got %q.", failure, old)
+	}
+	t.Logf("\t%s Refused them.", success)
+
+	t.Logf("Should report available releases.")
+
+	u.check = true
+	out.Reset()
+	if err := u.run(&out); err != nil || !strings.HasPrefix(out.String(), "gopyter v9.9.9 is available") {
+		t.Fatalf("\t%s Expected the release to be reported, got %q (%v).", failure, out.String(), err)
+	}
+	u.check = false
+	t.Logf("\t%s Reported them.", success)
+
+	t.Logf("Should install the latest release and reinstall the kernelspec.")
+
+	out.Reset()
+	if err := u.run(&out); err != nil {
+		t.Fatalf("\t%s Upgrading failed: %v (%s).", failure, err, out.String())
+	}
+	if installed, _ := ioutil.ReadFile(executable); !bytes.Equal(installed, binary) {
+		t.Fatalf("\t%s Expected the release binary, got %q.", failure, installed)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(kernelspec, "kernel.json"))
+	var spec map[string]interface{}
+	json.Unmarshal(data, &spec)
+	if want := []interface{}{executable, "-config", "c.json", "{connection_file}"}; !reflect.DeepEqual(spec["argv"], want) || spec["display_name"] != "Go+ (course)" {
+		t.Fatalf("\t%s Expected the kernelspec to run the binary, got %s.", failure, data)
+	}
+	t.Logf("\t%s Installed it.", success)
+
+	t.Logf("Should not install the running version, nor binaries that do not run.")
+
+	tag = "v" + Version
+	out.Reset()
+	if err := u.run(&out); err != nil || out.String() != "gopyter v"+Version+" is the latest release.\n" {
+		t.Fatalf("\t%s Expected the binary to be up to date, got %q (%v).", failure, out.String(), err)
+	}
+	tag = "v0.1.0"
+	out.Reset()
+	if err := u.run(&out); err != nil || out.String() != "gopyter v"+Version+" is newer than the latest release v0.1.0.\n" {
+		t.Fatalf("\t%s Expected the older release to be ignored, got %q (%v).", failure, out.String(), err)
+	}
+	tag = "v" + Version
+	u.force = true
+	if err := u.run(&out); err == nil || !strings.Contains(err.Error(), "reports v9.9.9") {
+		t.Fatalf("\t%s Expected the binary to be refused, got %v.", failure, err)
+	}
+	t.Logf("\t%s Did not install them.", success)
+
+	t.Logf("Should write a kernelspec if there is none.")
+
+	other := filepath.Join(dir, "other")
+	if err := installKernelspec(other, executable); err != nil {
+		t.Fatalf("\t%s Could not write the kernelspec: %v.", failure, err)
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(other, "kernel.json"))
+	spec = nil
+	json.Unmarshal(data, &spec)
+	if want := []interface{}{executable, "{connection_file}"}; !reflect.DeepEqual(spec["argv"], want) || spec["language"] != "go+" {
+		t.Fatalf("\t%s Expected a kernelspec running the binary, got %s.", failure, data)
+	}
+	t.Logf("\t%s Wrote it.", success)
+}
+
+// TestCompareVersions tests ordering semantic versions.
+func TestCompareVersions(t *testing.T) {
+	t.Logf("Should order versions by their numbers, then their pre-releases.")
+
+	ordered := []string{"0.9.0", "0.10.0", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "2.0.0"}
+	for i := range ordered {
+		for j := range ordered {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got, err := compareVersions(ordered[i], ordered[j]); err != nil || got != want {
+				t.Fatalf("\t%s Expected comparing %s to %s to give %d, got %d (%v).", failure, ordered[i], ordered[j], want, got, err)
+			}
+		}
+	}
+	if got, err := compareVersions("1.0.0+build.5", "1.0.0"); err != nil || got != 0 {
+		t.Fatalf("\t%s Expected the build metadata to be ignored, got %d (%v).", failure, got, err)
+	}
+	t.Logf("\t%s Ordered %d versions.", success, len(ordered))
+
+	for _, v := range []string{"", "1.0", "1.0.x", "1.0.0-", "1.0.0-a..b", "latest"} {
+		if _, err := compareVersions(v, "1.0.0"); err == nil {
+			t.Fatalf("\t%s Expected %q to be refused.", failure, v)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	commTargets[uploadCommTarget] = openUploadComm
+}
+
+// uploadCommTarget is the comm target through which front-ends upload files into the
+// working directory of the kernel, so that cells can read files picked in the browser.
+// Files are sent as a series of messages {"name": ..., "data": ..., "final": ...}
+// holding consecutive chunks of the file encoded in base64, the last one with final
+// set. A chunk can also be sent as the binary buffer of its message, leaving out
+// "data", which spares the encoding of large files. The kernel answers the final chunk with {"name": ..., "path": ...} holding the
+// absolute path of the written file, or any failing chunk with {"name": ...,
+// "error": ...}. Existing files are only replaced if the first chunk sets "overwrite",
+// hidden files, like .bashrc, and the files of the kernel, like its audit log, never
+// are, and files larger than the upload_max_bytes setting are refused.
+const uploadCommTarget = "gopyter.upload"
+
+// upload is a file being uploaded. Chunks are written to a temporary file next to its
+// destination, which is only replaced once the upload completes.
+type upload struct {
+	path      string
+	tmp       *os.File
+	size      int
+	maxSize   int // zero for no limit
+	overwrite bool
+}
+
+// newUpload starts the upload of a file named name into dir, of at most maxSize bytes
+// unless maxSize is zero. The name must not contain a directory, nor be hidden or one
+// of the files of the kernel. An existing file is only replaced if overwrite is set.
+func newUpload(kernel *Kernel, dir, name string, maxSize int, overwrite bool) (*upload, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid file name %q", name)
+	}
+	path, err := filepath.Abs(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if sandboxed && !inSandbox(path) {
+		return nil, &os.PathError{Op: "upload", Path: path, Err: errSandboxed}
+	}
+	if kernel.isKernelFile(path) {
+		return nil, &os.PathError{Op: "upload", Path: path, Err: os.ErrPermission}
+	}
+	u := &upload{path: path, maxSize: maxSize, overwrite: overwrite}
+	if err := u.checkExists(); err != nil {
+		return nil, err
+	}
+	if u.tmp, err = ioutil.TempFile(filepath.Dir(path), "."+name+".*.upload"); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// checkExists fails if the destination of the upload exists and may not be replaced.
+func (u *upload) checkExists() error {
+	if u.overwrite {
+		return nil
+	}
+	if _, err := os.Lstat(u.path); !os.IsNotExist(err) {
+		return &os.PathError{Op: "upload", Path: u.path, Err: os.ErrExist}
+	}
+	return nil
+}
+
+// write appends a base64 encoded chunk to the file.
+func (u *upload) write(chunk string) error {
+	data, err := base64.StdEncoding.DecodeString(chunk)
+	if err != nil {
+		return fmt.Errorf("invalid chunk: %v", err)
+	}
+	return u.writeBytes(data)
+}
+
+// writeBytes appends a binary chunk to the file.
+func (u *upload) writeBytes(data []byte) error {
+	if u.maxSize != 0 && u.size+len(data) > u.maxSize {
+		return fmt.Errorf("the file is larger than %d bytes", u.maxSize)
+	}
+	n, err := u.tmp.Write(data)
+	u.size += n
+	return err
+}
+
+// finish moves the uploaded file to its destination.
+func (u *upload) finish() error {
+	if err := u.tmp.Close(); err != nil {
+		os.Remove(u.tmp.Name())
+		return err
+	}
+	// The destination may have been created since the upload started.
+	if err := u.checkExists(); err != nil {
+		os.Remove(u.tmp.Name())
+		return err
+	}
+	return os.Rename(u.tmp.Name(), u.path)
+}
+
+// abort removes the partially uploaded file.
+func (u *upload) abort() {
+	u.tmp.Close()
+	os.Remove(u.tmp.Name())
+}
+
+// openUploadComm sets up a comm receiving files from the front-end. Uploads that are
+// still incomplete when the comm closes are discarded.
+func openUploadComm(kernel *Kernel, receipt msgReceipt, c *comm, data map[string]interface{}) error {
+	uploads := map[string]*upload{}
+
+	c.onMsg = func(receipt msgReceipt, data map[string]interface{}) {
+		name, _ := data["name"].(string)
+		chunk, _ := data["data"].(string)
+		final, _ := data["final"].(bool)
+		overwrite, _ := data["overwrite"].(bool)
+
+		reply := map[string]interface{}{"name": name}
+		err := func() error {
+			u, ok := uploads[name]
+			if !ok {
+				var err error
+				if u, err = newUpload(kernel, ".", name, kernel.config.UploadMaxBytes, overwrite); err != nil {
+					return err
+				}
+				uploads[name] = u
+			}
+			var err error
+			if buffers := receipt.Msg.Buffers; len(buffers) > 0 && chunk == "" {
+				err = u.writeBytes(buffers[0])
+			} else {
+				err = u.write(chunk)
+			}
+			if err != nil {
+				u.abort()
+				delete(uploads, name)
+				return err
+			}
+			if !final {
+				return nil
+			}
+			delete(uploads, name)
+			if err := u.finish(); err != nil {
+				return err
+			}
+			reply["path"] = u.path
+			reply["size"] = u.size
+			return nil
+		}()
+		if err != nil {
+			reply["error"] = err.Error()
+		} else if !final {
+			return
+		}
+		if err := c.send(receipt, reply); err != nil {
+			log.Printf("Error sending upload reply: %v\n", err)
+		}
+	}
+
+	c.onClose = func() {
+		for _, u := range uploads {
+			u.abort()
+		}
+	}
+	return nil
+}
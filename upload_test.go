@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestUpload tests writing uploaded chunks into a directory.
+func TestUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopyter-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kernel := Kernel{NewSession(), defaultConfig()}
+	kernel.config.AuditLog = filepath.Join(dir, "audit.log")
+
+	t.Logf("Should write the chunks of a file to its destination once complete.")
+
+	u, err := newUpload(&kernel, dir, "data.csv", 0, false)
+	if err != nil {
+		t.Fatalf("\t%s Could not start the upload: %v.", failure, err)
+	}
+	if err := u.write(base64.StdEncoding.EncodeToString([]byte("a,b\n"))); err != nil {
+		t.Fatalf("\t%s Could not write a chunk: %v.", failure, err)
+	}
+	// Chunks sent as binary buffers are not encoded.
+	if err := u.writeBytes([]byte("1,2\n")); err != nil {
+		t.Fatalf("\t%s Could not write a binary chunk: %v.", failure, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data.csv")); !os.IsNotExist(err) {
+		t.Fatalf("\t%s Expected the file to only appear once complete.", failure)
+	}
+	if err := u.finish(); err != nil {
+		t.Fatalf("\t%s Could not finish the upload: %v.", failure, err)
+	}
+	data, err := ioutil.ReadFile(u.path)
+	if err != nil || string(data) != "a,b\n1,2\n" || u.size != 8 {
+		t.Fatalf("\t%s Expected the uploaded file, got %q (%d bytes): %v.", failure, data, u.size, err)
+	}
+	t.Logf("\t%s Wrote %s.", success, u.path)
+
+	t.Logf("Should refuse names outside of the directory and discard aborted uploads.")
+
+	for _, name := range []string{"", "..", "../x", "a/b", ".bashrc", "audit.log"} {
+		if _, err := newUpload(&kernel, dir, name, 0, false); err == nil {
+			t.Fatalf("\t%s Expected the name %q to be refused.", failure, name)
+		}
+	}
+	u, err = newUpload(&kernel, dir, "partial.bin", 0, false)
+	if err != nil {
+		t.Fatalf("\t%s Could not start the upload: %v.", failure, err)
+	}
+	if err := u.write("not base64!"); err == nil {
+		t.Fatalf("\t%s Expected an invalid chunk to fail.", failure)
+	}
+	u.abort()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("\t%s Expected the aborted upload to be removed, found %d files.", failure, len(files))
+	}
+	t.Logf("\t%s Refused the invalid uploads.", success)
+
+	t.Logf("Should only replace existing files when asked to.")
+
+	if _, err := newUpload(&kernel, dir, "data.csv", 0, false); !os.IsExist(err) {
+		t.Fatalf("\t%s Expected replacing the file to be refused, got %v.", failure, err)
+	}
+	u, err = newUpload(&kernel, dir, "new.csv", 0, false)
+	if err != nil {
+		t.Fatalf("\t%s Could not start the upload: %v.", failure, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "new.csv"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.finish(); !os.IsExist(err) {
+		t.Fatalf("\t%s Expected the file created meanwhile to be kept, got %v.", failure, err)
+	}
+	u, err = newUpload(&kernel, dir, "data.csv", 0, true)
+	if err != nil {
+		t.Fatalf("\t%s Could not start the upload: %v.", failure, err)
+	}
+	if err := u.writeBytes([]byte("c,d\n")); err != nil {
+		t.Fatalf("\t%s Could not write a chunk: %v.", failure, err)
+	}
+	if err := u.finish(); err != nil {
+		t.Fatalf("\t%s Could not finish the upload: %v.", failure, err)
+	}
+	if data, _ := ioutil.ReadFile(u.path); string(data) != "c,d\n" {
+		t.Fatalf("\t%s Expected the file to be replaced, got %q.", failure, data)
+	}
+	t.Logf("\t%s Replaced data.csv only with overwrite.", success)
+
+	t.Logf("Should refuse files larger than the limit.")
+
+	u, err = newUpload(&kernel, dir, "big.bin", 4, false)
+	if err != nil {
+		t.Fatalf("\t%s Could not start the upload: %v.", failure, err)
+	}
+	if err := u.writeBytes([]byte("abc")); err != nil {
+		t.Fatalf("\t%s Could not write a chunk: %v.", failure, err)
+	}
+	if err := u.writeBytes([]byte("de")); err == nil {
+		t.Fatalf("\t%s Expected the chunk beyond the limit to fail.", failure)
+	}
+	u.abort()
+	t.Logf("\t%s Refused it.", success)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+
+	"github.com/goplus/gop"
+)
+
+// magicPackage is the Go+ package letting cells and init cells add their own magics.
+var magicPackage = gop.NewGoPackage("magic")
+
+func init() {
+	magicPackage.RegisterFuncs(
+		magicPackage.Func("RegisterLine", registerLineMagic, execRegisterLineMagic),
+		magicPackage.Func("RegisterCell", registerCellMagic, execRegisterCellMagic),
+		magicPackage.Func("Describe", describeMagic, execDescribeMagic),
+		magicPackage.Func("Args", magicPositionalArgs, execMagicPositionalArgs),
+		magicPackage.Func("Options", magicOptions, execMagicOptions),
+	)
+}
+
+// customMagics holds the magics registered with the magic package, as `%name` or
+// `%%name`. They may be registered again, unlike the magics of the kernel.
+var customMagics = map[string]bool{}
+
+// checkMagicName checks that name can be registered as a custom magic of the given kind.
+func checkMagicName(kind, magic, name string, builtin bool) error {
+	if !token.IsIdentifier(name) {
+		return fmt.Errorf("magic.Register%s: invalid magic name %q", kind, name)
+	}
+	if builtin && !customMagics[magic] {
+		return fmt.Errorf("magic.Register%s: %q is a magic of the kernel", kind, name)
+	}
+	return nil
+}
+
+// registerLineMagic registers handler as the line magic `%name args`. The handler
+// receives the arguments of the magic; what it prints is part of the output of the cell
+// and an error it returns fails the cell.
+func registerLineMagic(name string, handler func(args string) error) error {
+	_, builtin := lineMagics[name]
+	if err := checkMagicName("Line", "%"+name, name, builtin); err != nil {
+		return err
+	}
+	if handler == nil {
+		return fmt.Errorf("magic.RegisterLine: nil handler for %%%s", name)
+	}
+	customMagics["%"+name] = true
+	lineMagics[name] = func(kernel *Kernel, outerr OutErr, args string) (err error) {
+		defer recoverMagic("%"+name, &err)
+		return handler(args)
+	}
+	return nil
+}
+
+func execRegisterLineMagic(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	handler, _ := args[1].(func(string) error)
+	if err := registerLineMagic(args[0].(string), handler); err != nil {
+		panic(err)
+	}
+	p.Ret(2)
+}
+
+// registerCellMagic registers handler as the cell magic `%%name args`. The handler
+// receives the arguments of the magic and the rest of the cell, and returns the result
+// of the cell. Returning an error fails the cell.
+func registerCellMagic(name string, handler func(args, body string) interface{}) error {
+	_, builtin := cellMagics[name]
+	if err := checkMagicName("Cell", "%%"+name, name, builtin); err != nil {
+		return err
+	}
+	if handler == nil {
+		return fmt.Errorf("magic.RegisterCell: nil handler for %%%%%s", name)
+	}
+	customMagics["%%"+name] = true
+	cellMagics[name] = func(kernel *Kernel, outerr OutErr, args, body string) (vals []interface{}, err error) {
+		defer recoverMagic("%%"+name, &err)
+		switch result := handler(args, body).(type) {
+		case nil:
+			return nil, nil
+		case error:
+			return nil, result
+		default:
+			return []interface{}{result}, nil
+		}
+	}
+	return nil
+}
+
+func execRegisterCellMagic(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	handler, _ := args[1].(func(string, string) interface{})
+	if err := registerCellMagic(args[0].(string), handler); err != nil {
+		panic(err)
+	}
+	p.Ret(2)
+}
+
+// recoverMagic turns a panic of the handler of a custom magic into an error, so that
+// a bug in a magic fails the cell rather than the kernel.
+func recoverMagic(magic string, err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%s: %v", magic, r)
+	}
+}
+
+// describeMagic documents the custom magic name, given as `%name` or `%%name`, for
+// --help. usage shows how it is called, without its name, and doc what it does, in
+// Markdown.
+func describeMagic(name, usage, doc string) error {
+	if !customMagics[name] {
+		return fmt.Errorf("magic.Describe: no custom magic %s", name)
+	}
+	documentMagic(&magicSyntax{name: name, usage: []string{usage}, doc: doc})
+	return nil
+}
+
+func execDescribeMagic(_ int, p *gop.Context) {
+	args := p.GetArgs(3)
+	if err := describeMagic(args[0].(string), args[1].(string), args[2].(string)); err != nil {
+		panic(err)
+	}
+	p.Ret(3)
+}
+
+// magicPositionalArgs returns the words of the arguments of a magic that are not
+// options, unquoted, for the handlers of custom magics.
+func magicPositionalArgs(args string) []string {
+	words, err := splitMagicArgs(args, false)
+	if err != nil {
+		panic(fmt.Errorf("magic.Args: %v", err))
+	}
+	positional := []string{}
+	for _, w := range words {
+		if !w.option {
+			positional = append(positional, w.text)
+		}
+	}
+	return positional
+}
+
+func execMagicPositionalArgs(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, magicPositionalArgs(args[0].(string)))
+}
+
+// magicOptions returns the key=value options of the arguments of a magic, with the
+// --switches set to "true", for the handlers of custom magics.
+func magicOptions(args string) map[string]string {
+	words, err := splitMagicArgs(args, false)
+	if err != nil {
+		panic(fmt.Errorf("magic.Options: %v", err))
+	}
+	options := map[string]string{}
+	for _, w := range words {
+		if w.option {
+			options[w.key] = w.text
+		}
+	}
+	return options
+}
+
+func execMagicOptions(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, magicOptions(args[0].(string)))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestCustomMagics tests magics registered from cells with the magic package.
+func TestCustomMagics(t *testing.T) {
+	defer func() {
+		for magic := range customMagics {
+			if strings.HasPrefix(magic, "%%") {
+				delete(cellMagics, magic[2:])
+			} else {
+				delete(lineMagics, magic[1:])
+			}
+		}
+		customMagics = map[string]bool{}
+	}()
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+	setup := `import "fmt"
+greeted := ""
+magic.RegisterLine("greet", func(args string) error {
+	if args == "" {
+		return fmt.Errorf("whom to greet?")
+	}
+	greeted = args
+	return nil
+})
+magic.RegisterCell("shout", func(args, body string) interface{} { return args + ": " + body + "!" })`
+	if _, err := kernel.doEvalGop(outerr, setup); err != nil {
+		t.Fatalf("\t%s Registering the magics failed: %v.", failure, err)
+	}
+
+	t.Logf("Should run the registered line magics.")
+
+	if _, err := kernel.doEvalGop(outerr, "%greet world\n1"); err != nil {
+		t.Fatalf("\t%s Running the line magic failed: %v.", failure, err)
+	}
+	if vals, err := kernel.session.Peek("greeted"); err != nil || len(vals) != 1 || vals[0] != "world" {
+		t.Fatalf("\t%s Expected the magic to set greeted to \"world\", got %v (%v).", failure, vals, err)
+	}
+	if _, err := kernel.doEvalGop(outerr, "%greet\n1"); err == nil || err.Error() != "whom to greet?" {
+		t.Fatalf("\t%s Expected the error of the magic, got %v.", failure, err)
+	}
+	t.Logf("\t%s Ran the line magic.", success)
+
+	t.Logf("Should run the registered cell magics.")
+
+	vals, err := kernel.doEvalGop(outerr, "%%shout hey\nyou")
+	if err != nil || len(vals) != 1 || vals[0] != "hey: you!" {
+		t.Fatalf("\t%s Expected the result of the magic, got %v (%v).", failure, vals, err)
+	}
+	t.Logf("\t%s Ran the cell magic.", success)
+
+	t.Logf("Should not replace the magics of the kernel.")
+
+	if err := registerCellMagic("capture", func(args, body string) interface{} { return nil }); err == nil {
+		t.Fatalf("\t%s Expected %%%%capture not to be replaced.", failure)
+	}
+	if err := registerLineMagic("shout", func(args string) error { return nil }); err != nil {
+		t.Fatalf("\t%s Expected a line magic named like a custom cell magic to be registered, got %v.", failure, err)
+	}
+	if err := registerLineMagic("greet", func(args string) error { panic("oops") }); err != nil {
+		t.Fatalf("\t%s Expected the custom magic to be replaced, got %v.", failure, err)
+	}
+	if _, err := kernel.doEvalGop(outerr, "%greet world"); err == nil || err.Error() != "%greet: oops" {
+		t.Fatalf("\t%s Expected the panic of the magic to fail the cell, got %v.", failure, err)
+	}
+	t.Logf("\t%s Protected the magics of the kernel.", success)
+}
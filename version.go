@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// versionInfo holds the versions `gopyter version` reports.
+type versionInfo struct {
+	Kernel   string `json:"kernel"`
+	Protocol string `json:"protocol"`
+	Go       string `json:"go"`
+	Gop      string `json:"gop"`
+	Platform string `json:"platform"`
+}
+
+// currentVersion returns the versions of the running kernel.
+func currentVersion() versionInfo {
+	gop := kernelToolchain().deps["github.com/goplus/gop"]
+	if gop == "" {
+		gop = "unknown"
+	}
+	return versionInfo{
+		Kernel:   Version,
+		Protocol: ProtocolVersion,
+		Go:       runtime.Version(),
+		Gop:      gop,
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+func (v versionInfo) String() string {
+	return fmt.Sprintf("gopyter v%s (Jupyter protocol %s, Go+ %s, %s %s)", v.Kernel, v.Protocol, v.Gop, v.Go, v.Platform)
+}
+
+// banner returns the banner of kernel_info replies, which front-ends like the console
+// show on start.
+func (v versionInfo) banner() string {
+	return fmt.Sprintf("Go kernel: gopyter - v%s\nGo+ %s, %s on %s", v.Kernel, v.Gop, v.Go, v.Platform)
+}
+
+// runVersion implements `gopyter version [-json]`, which prints the versions of the
+// kernel, of the Jupyter protocol it speaks, and of Go and Go+ it was built with.
+func runVersion(args []string) error {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the versions as JSON")
+	flags.Parse(args)
+	return writeVersion(os.Stdout, currentVersion(), *asJSON)
+}
+
+// writeVersion writes v to out, as text or as JSON.
+func writeVersion(out io.Writer, v versionInfo, asJSON bool) error {
+	if !asJSON {
+		_, err := fmt.Fprintln(out, v)
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
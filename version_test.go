@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestVersion tests reporting the versions of the kernel.
+func TestVersion(t *testing.T) {
+	t.Logf("Should report the versions of the kernel, of the protocol, of Go and of Go+.")
+
+	v := currentVersion()
+	if v.Kernel != Version || v.Protocol != ProtocolVersion || v.Go != runtime.Version() || !strings.HasPrefix(v.Gop, "v") {
+		t.Fatalf("\t%s Expected the versions of the kernel, got %+v.", failure, v)
+	}
+	var out bytes.Buffer
+	if err := writeVersion(&out, v, false); err != nil || !strings.HasPrefix(out.String(), "gopyter v"+Version+" (Jupyter protocol "+ProtocolVersion+", Go+ "+v.Gop) {
+		t.Fatalf("\t%s Expected the versions as text, got %q (%v).", failure, out.String(), err)
+	}
+	if !strings.Contains(v.banner(), "Go+ "+v.Gop+", "+v.Go) {
+		t.Fatalf("\t%s Expected the versions in the banner, got %q.", failure, v.banner())
+	}
+	t.Logf("\t%s Reported them.", success)
+
+	t.Logf("Should report them as JSON.")
+
+	out.Reset()
+	var decoded versionInfo
+	if err := writeVersion(&out, v, true); err != nil || json.Unmarshal(out.Bytes(), &decoded) != nil || decoded != v {
+		t.Fatalf("\t%s Expected the versions as JSON, got %q (%v).", failure, out.String(), err)
+	}
+	if !strings.Contains(out.String(), `"protocol": "`+ProtocolVersion+`"`) {
+		t.Fatalf("\t%s Expected the protocol version, got %q.", failure, out.String())
+	}
+	t.Logf("\t%s Reported them.", success)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/goplus/gop"
+	"github.com/goplus/gop/lib/builtin"
+)
+
+func init() {
+	builtin.I.RegisterFuncs(
+		builtin.I.Func("PointCloud", newPointCloud, execPointCloud),
+		builtin.I.Func("Mesh", newMesh, execMesh),
+	)
+}
+
+// threeVersion is the version of three.js loaded by the 3D viewer. It is loaded from the
+// jsDelivr CDN rather than embedded in the kernel, as it weighs more than a megabyte, so
+// the viewer needs the browser to be online.
+const threeVersion = "0.160.0"
+
+// Scene3D is a point cloud or a triangle mesh, as returned by PointCloud and Mesh. It
+// renders as an interactive 3D viewer.
+type Scene3D struct {
+	Points [][]float64 // x, y, z, and optionally r, g, b between 0 and 1
+	Faces  [][]int     // the indexes of the points of each triangle, for meshes
+}
+
+// newPointCloud returns a point cloud. points is a slice of points, each a slice of
+// numbers: x, y, z, and optionally their color as r, g, b between 0 and 1. Like the
+// other builtins it reports errors by panicking, which fails the cell.
+func newPointCloud(points interface{}) *Scene3D {
+	s := &Scene3D{Points: toFloatRows("PointCloud", points)}
+	s.check("PointCloud")
+	return s
+}
+
+func execPointCloud(_ int, p *gop.Context) {
+	args := p.GetArgs(1)
+	p.Ret(1, newPointCloud(args[0]))
+}
+
+// newMesh returns a triangle mesh of vertices, given like the points of a point cloud,
+// and faces, each the indexes of the three vertices of a triangle.
+func newMesh(vertices, faces interface{}) *Scene3D {
+	s := &Scene3D{Points: toFloatRows("Mesh", vertices)}
+	for _, face := range toFloatRows("Mesh", faces) {
+		if len(face) != 3 {
+			panic(fmt.Errorf("Mesh: faces must have 3 vertices, got %d", len(face)))
+		}
+		indexes := make([]int, 3)
+		for i, f := range face {
+			indexes[i] = int(f)
+			if indexes[i] < 0 || indexes[i] >= len(s.Points) {
+				panic(fmt.Errorf("Mesh: vertex %d out of range", indexes[i]))
+			}
+		}
+		s.Faces = append(s.Faces, indexes)
+	}
+	s.check("Mesh")
+	return s
+}
+
+func execMesh(_ int, p *gop.Context) {
+	args := p.GetArgs(2)
+	p.Ret(2, newMesh(args[0], args[1]))
+}
+
+// toFloatRows converts a slice of slices of numbers, of any numeric types, to
+// [][]float64.
+func toFloatRows(fn string, v interface{}) [][]float64 {
+	rows := reflect.ValueOf(v)
+	if rows.Kind() != reflect.Slice && rows.Kind() != reflect.Array {
+		panic(fmt.Errorf("%s: expected a slice of slices of numbers, got %T", fn, v))
+	}
+	result := make([][]float64, rows.Len())
+	for i := range result {
+		row := reflect.Indirect(rows.Index(i))
+		if row.Kind() == reflect.Interface {
+			row = row.Elem()
+		}
+		if row.Kind() != reflect.Slice && row.Kind() != reflect.Array {
+			panic(fmt.Errorf("%s: expected a slice of slices of numbers, got %T", fn, v))
+		}
+		result[i] = make([]float64, row.Len())
+		for j := range result[i] {
+			x := row.Index(j)
+			if x.Kind() == reflect.Interface {
+				x = x.Elem()
+			}
+			switch x.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				result[i][j] = float64(x.Int())
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				result[i][j] = float64(x.Uint())
+			case reflect.Float32, reflect.Float64:
+				result[i][j] = x.Float()
+			default:
+				panic(fmt.Errorf("%s: expected numbers, got %s", fn, x.Type()))
+			}
+		}
+	}
+	return result
+}
+
+// check checks that the points all have finite coordinates, and either all or none a
+// color.
+func (s *Scene3D) check(fn string) {
+	for _, p := range s.Points {
+		if len(p) != 3 && len(p) != 6 {
+			panic(fmt.Errorf("%s: points must be x, y, z or x, y, z, r, g, b, got %d numbers", fn, len(p)))
+		}
+		if len(p) != len(s.Points[0]) {
+			panic(fmt.Errorf("%s: either all or none of the points must have a color", fn))
+		}
+		for _, x := range p {
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				panic(fmt.Errorf("%s: points must be finite numbers, got %v", fn, p))
+			}
+		}
+	}
+}
+
+func (s *Scene3D) String() string {
+	if s.Faces != nil {
+		return fmt.Sprintf("Mesh(%d vertices, %d faces)", len(s.Points), len(s.Faces))
+	}
+	return fmt.Sprintf("PointCloud(%d points)", len(s.Points))
+}
+
+// Render renders the scene as an isolated output running three.js, which is loaded from
+// a CDN. The scene can be rotated, zoomed and panned with the mouse. Scenes that cannot
+// be encoded, like those with NaN coordinates, render as an error.
+func (s *Scene3D) Render() Data {
+	var positions, colors []float64
+	for _, p := range s.Points {
+		positions = append(positions, p[:3]...)
+		if len(p) == 6 {
+			colors = append(colors, p[3:]...)
+		}
+	}
+	var indexes []int
+	for _, f := range s.Faces {
+		indexes = append(indexes, f...)
+	}
+	scene, err := json.Marshal(map[string]interface{}{
+		"positions": positions,
+		"colors":    colors,
+		"indexes":   indexes,
+		"mesh":      s.Faces != nil,
+	})
+	if err != nil {
+		return makeDataErr(fmt.Errorf("%s: %v", s, err))
+	}
+
+	cdn := "https://cdn.jsdelivr.net/npm/three@" + threeVersion
+	page := `<div id="scene" style="width:100%;height:400px"></div>` +
+		`<script type="importmap">{"imports": {"three": "` + cdn + `/build/three.module.js", "three/addons/": "` + cdn + `/examples/jsm/"}}</script>` +
+		`<script type="module">` + fmt.Sprintf(viewer3DScript, scene) + `</script>`
+
+	d := MakeData3(MIMETypeHTML, s.String(), page)
+	d.Metadata = MIMEMap{MIMETypeHTML: map[string]interface{}{"isolated": true}}
+	return d
+}
+
+// viewer3DScript renders the scene given as JSON.
+const viewer3DScript = `
+import * as THREE from "three";
+import { OrbitControls } from "three/addons/controls/OrbitControls.js";
+
+const data = %s;
+const container = document.getElementById("scene");
+const renderer = new THREE.WebGLRenderer({antialias: true});
+renderer.setSize(container.clientWidth, container.clientHeight);
+renderer.setClearColor(0xffffff);
+container.appendChild(renderer.domElement);
+
+const geometry = new THREE.BufferGeometry();
+geometry.setAttribute("position", new THREE.Float32BufferAttribute(data.positions, 3));
+if (data.colors) geometry.setAttribute("color", new THREE.Float32BufferAttribute(data.colors, 3));
+geometry.computeBoundingSphere();
+const {center, radius} = geometry.boundingSphere;
+
+const scene = new THREE.Scene();
+if (data.mesh) {
+  geometry.setIndex(data.indexes);
+  geometry.computeVertexNormals();
+  scene.add(new THREE.Mesh(geometry, new THREE.MeshStandardMaterial({
+    color: data.colors ? 0xffffff : 0x4682b4, vertexColors: !!data.colors, side: THREE.DoubleSide, flatShading: true})));
+  scene.add(new THREE.AmbientLight(0xffffff, 0.6));
+  const light = new THREE.DirectionalLight(0xffffff, 1.5);
+  light.position.set(1, 2, 3);
+  scene.add(light);
+} else {
+  scene.add(new THREE.Points(geometry, new THREE.PointsMaterial({
+    color: data.colors ? 0xffffff : 0x4682b4, vertexColors: !!data.colors, size: (radius || 1) / 50})));
+}
+
+const camera = new THREE.PerspectiveCamera(50, container.clientWidth / container.clientHeight, (radius || 1) / 100, (radius || 1) * 100);
+camera.position.set(center.x, center.y, center.z + 2.5 * (radius || 1));
+const controls = new OrbitControls(camera, renderer.domElement);
+controls.target.copy(center);
+controls.update();
+renderer.setAnimationLoop(() => renderer.render(scene, camera));
+`
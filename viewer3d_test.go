@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestScene3D tests building and rendering point clouds and meshes.
+func TestScene3D(t *testing.T) {
+	cases := []struct {
+		code   string
+		scene  *Scene3D
+		render string
+	}{
+		{
+			"PointCloud([[0, 0, 0], [1.5, 2, 3]])",
+			&Scene3D{Points: [][]float64{{0, 0, 0}, {1.5, 2, 3}}},
+			`const data = {"colors":null,"indexes":null,"mesh":false,"positions":[0,0,0,1.5,2,3]};`,
+		},
+		{
+			"PointCloud([[0, 0, 0, 1, 0, 0]])",
+			&Scene3D{Points: [][]float64{{0, 0, 0, 1, 0, 0}}},
+			`"colors":[1,0,0]`,
+		},
+		{
+			"Mesh([[0, 0, 0], [1, 0, 0], [0, 1, 0]], [[0, 1, 2]])",
+			&Scene3D{Points: [][]float64{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}, Faces: [][]int{{0, 1, 2}}},
+			`"indexes":[0,1,2],"mesh":true`,
+		},
+	}
+
+	t.Logf("Should render point clouds and meshes with three.js.")
+
+	kernel := Kernel{NewSession(), defaultConfig()}
+	for _, tc := range cases {
+		vals, err := kernel.session.Eval(tc.code)
+		if err != nil {
+			t.Fatalf("\t%s Evaluating %s failed: %v.", failure, tc.code, err)
+		}
+		if !reflect.DeepEqual(vals[0], tc.scene) {
+			t.Fatalf("\t%s Expected %v, got %v.", failure, tc.scene, vals[0])
+		}
+		data := kernel.autoRenderResults(vals)
+		if html, _ := data.Data[MIMETypeHTML].(string); !strings.Contains(html, tc.render) || !strings.Contains(html, "three@"+threeVersion) {
+			t.Fatalf("\t%s Expected %s in the viewer, got %s.", failure, tc.render, html)
+		}
+		t.Logf("\t%s Rendered %s.", success, data.Data[MIMETypeText])
+	}
+
+	t.Logf("Should refuse invalid scenes.")
+
+	for _, code := range []string{
+		"PointCloud([[0, 0]])",
+		"PointCloud([[0, 0, 0], [0, 0, 0, 1, 1, 1]])",
+		"PointCloud([0, 0, 0])",
+		`PointCloud([["a", "b", "c"]])`,
+		"Mesh([[0, 0, 0]], [[0, 1, 2]])",
+	} {
+		if _, err := kernel.session.Eval(code); err == nil {
+			t.Fatalf("\t%s Expected %s to fail.", failure, code)
+		}
+	}
+	t.Logf("\t%s Refused the invalid scenes.", success)
+
+	t.Logf("Should render scenes that cannot be encoded as errors.")
+
+	data := (&Scene3D{Points: [][]float64{{math.NaN(), 0, 0}}}).Render()
+	if data.Data["status"] != "error" || data.Data[MIMETypeHTML] != nil {
+		t.Fatalf("\t%s Expected an error, got %v.", failure, data.Data)
+	}
+	t.Logf("\t%s Rendered %s.", success, data.Data["evalue"])
+}
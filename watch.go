@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gofrs/uuid"
+)
+
+func init() {
+	lineMagics["watch"] = evalWatchMagic
+	lineMagics["unwatch"] = evalUnwatchMagic
+	documentMagic(watchSyntax)
+	documentMagic(unwatchSyntax)
+	commTargets[watchCommTarget] = openWatchComm
+}
+
+var watchSyntax = &magicSyntax{
+	name:     "%watch",
+	usage:    []string{"[expr]"},
+	verbatim: true,
+	doc: "Adds an expression to the watch table, shown in the output of the cell and updated after every cell " +
+		"executed, or shows the table of the watched expressions again.",
+	args: []magicParam{
+		{name: "expr", help: "the expression to watch, taken as written up to the end of the line", optional: true, rest: true},
+	},
+}
+
+var unwatchSyntax = &magicSyntax{
+	name:     "%unwatch",
+	usage:    []string{"[expr]"},
+	verbatim: true,
+	doc:      "Removes an expression from the watch table, or all of them.",
+	args: []magicParam{
+		{name: "expr", help: "the expression to remove, as it was watched", optional: true, rest: true},
+	},
+}
+
+// watchCommTarget is the comm target through which front-end extensions show the watched
+// expressions in a side panel. When the comm is opened and after every execution, the
+// kernel sends {"watches": [{"expr": ..., "value": ...}, ...]} on it, with "error"
+// instead of "value" for the expressions that failed.
+const watchCommTarget = "gopyter.watch"
+
+// watchTable is the expressions watched with %watch, whose values are shown in a table
+// updated after every cell.
+type watchTable struct {
+	exprs []string
+	id    string // the display_id of the table in the output of the last %watch, if any
+}
+
+// watchValue is the value of a watched expression.
+type watchValue struct {
+	Expr  string `json:"expr"`
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// watchValues evaluates the watched expressions against the session, without keeping
+// their effects.
+func (s *Session) watchValues() []watchValue {
+	values := []watchValue{}
+	if s.watches == nil {
+		return values
+	}
+	for _, expr := range s.watches.exprs {
+		v := watchValue{Expr: expr}
+		if vals, err := s.Peek(expr); err != nil {
+			v.Error = s.redactor.redact(err.Error())
+		} else {
+			v.Value = s.redactor.redact(resultPrinter.sprint(vals...))
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// watchData renders the values of the watched expressions as a table.
+func watchData(values []watchValue) Data {
+	rows := make([][]string, len(values))
+	for i, v := range values {
+		rows[i] = []string{v.Expr, v.Value}
+		if v.Error != "" {
+			rows[i][1] = "error: " + v.Error
+		}
+	}
+	return k8sTable([]string{"Expression", "Value"}, rows, -1, nil)
+}
+
+// showWatches shows the table of the watched expressions in the output of the cell being
+// evaluated, where it is updated after every cell.
+func (kernel *Kernel) showWatches(outerr OutErr) {
+	w := kernel.session.watches
+	data := watchData(kernel.session.watchValues())
+	if kernel.session.display == nil {
+		fmt.Fprintln(outerr.out, data.Data[MIMETypeText])
+		return
+	}
+	id, _ := uuid.NewV4()
+	w.id = id.String()
+	data.Transient = MIMEMap{"display_id": w.id}
+	kernel.display(outerr, data)
+}
+
+// publishWatches updates the table of the watched expressions and sends their values on
+// the open watch comms, after an execution.
+func (kernel *Kernel) publishWatches(receipt msgReceipt) {
+	w := kernel.session.watches
+	comms := commsOf(watchCommTarget)
+	if w == nil || (w.id == "" && len(comms) == 0) {
+		return
+	}
+	values := kernel.session.watchValues()
+	if w.id != "" {
+		data := watchData(values)
+		data.Transient = MIMEMap{"display_id": w.id}
+		if err := receipt.PublishUpdateDisplayData(data); err != nil {
+			log.Printf("Error updating the watch table: %v\n", err)
+		}
+	}
+	for _, c := range comms {
+		if err := c.send(receipt, map[string]interface{}{"watches": values}); err != nil {
+			log.Printf("Error sending the watched expressions: %v\n", err)
+		}
+	}
+}
+
+// openWatchComm sends the values of the watched expressions on a newly opened watch
+// comm.
+func openWatchComm(kernel *Kernel, receipt msgReceipt, c *comm, data map[string]interface{}) error {
+	return c.send(receipt, map[string]interface{}{"watches": kernel.session.watchValues()})
+}
+
+// evalWatchMagic implements `%watch [expr]`, which watches expr and shows the table of
+// the watched expressions.
+func evalWatchMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := watchSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	s := kernel.session
+	if s.watches == nil {
+		s.watches = &watchTable{}
+	}
+	if expr := parsed.arg(0); expr != "" {
+		for _, e := range s.watches.exprs {
+			if e == expr {
+				return fmt.Errorf("%s is already watched", expr)
+			}
+		}
+		s.watches.exprs = append(s.watches.exprs, expr)
+	} else if len(s.watches.exprs) == 0 {
+		fmt.Fprintln(outerr.out, "No expressions are watched.")
+		return nil
+	}
+	kernel.showWatches(outerr)
+	return nil
+}
+
+// evalUnwatchMagic implements `%unwatch [expr]`, which stops watching expr, or every
+// expression.
+func evalUnwatchMagic(kernel *Kernel, outerr OutErr, args string) error {
+	parsed, err := unwatchSyntax.parse(args)
+	if err != nil {
+		return err
+	}
+	w := kernel.session.watches
+	expr := parsed.arg(0)
+	if expr == "" {
+		kernel.session.watches = nil
+		fmt.Fprintln(outerr.out, "No expressions are watched.")
+		return nil
+	}
+	if w != nil {
+		for i, e := range w.exprs {
+			if e == expr {
+				w.exprs = append(w.exprs[:i], w.exprs[i+1:]...)
+				fmt.Fprintf(outerr.out, "%s is no longer watched.\n", expr)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s is not watched", expr)
+}
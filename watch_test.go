@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestWatch tests watching expressions.
+func TestWatch(t *testing.T) {
+	kernel := Kernel{NewSession(), defaultConfig()}
+	outerr := OutErr{ioutil.Discard, ioutil.Discard}
+	var shown []Data
+	kernel.session.display = func(data Data) { shown = append(shown, data) }
+	if _, err := kernel.doEvalGop(outerr, "import \"strings\"\nx := 1\nname := \"a b\""); err != nil {
+		t.Fatalf("\t%s Could not set up the session: %v.", failure, err)
+	}
+
+	t.Logf("Should show the table of the watched expressions with a display_id.")
+
+	if _, err := kernel.doEvalGop(outerr, "%watch x * 2\n%watch strings.Fields(name)"); err != nil {
+		t.Fatalf("\t%s Could not watch the expressions: %v.", failure, err)
+	}
+	if len(shown) != 2 || shown[1].Transient["display_id"] == "" || shown[1].Transient["display_id"] != kernel.session.watches.id {
+		t.Fatalf("\t%s Expected the table to be shown with a display_id, got %v.", failure, shown)
+	}
+	if text := shown[1].Data[MIMETypeText].(string); !strings.Contains(text, "x * 2") || !strings.Contains(text, "[a b]") {
+		t.Fatalf("\t%s Unexpected table %q.", failure, text)
+	}
+	t.Logf("\t%s Showed the table.", success)
+
+	t.Logf("Should evaluate the watched expressions again after cells.")
+
+	for _, code := range []string{"x = 5", "%watch y"} {
+		if _, err := kernel.doEvalGop(outerr, code); err != nil {
+			t.Fatalf("\t%s Could not evaluate %q: %v.", failure, code, err)
+		}
+	}
+	values := kernel.session.watchValues()
+	if len(values) != 3 || values[0] != (watchValue{Expr: "x * 2", Value: "10"}) || values[2].Error == "" {
+		t.Fatalf("\t%s Unexpected values %+v.", failure, values)
+	}
+	t.Logf("\t%s Evaluated %d expressions.", success, len(values))
+
+	t.Logf("Should stop watching expressions.")
+
+	if _, err := kernel.doEvalGop(outerr, "%unwatch y"); err != nil {
+		t.Fatalf("\t%s Could not stop watching y: %v.", failure, err)
+	}
+	if want := []string{"x * 2", "strings.Fields(name)"}; !reflect.DeepEqual(kernel.session.watches.exprs, want) {
+		t.Fatalf("\t%s Expected %q to be watched, got %q.", failure, want, kernel.session.watches.exprs)
+	}
+	if _, err := kernel.doEvalGop(outerr, "%unwatch y"); err == nil {
+		t.Fatalf("\t%s Expected unwatching y again to fail.", failure)
+	}
+	if _, err := kernel.doEvalGop(outerr, "%unwatch"); err != nil || len(kernel.session.watchValues()) != 0 {
+		t.Fatalf("\t%s Expected no expressions to be watched, got %v.", failure, err)
+	}
+	t.Logf("\t%s Stopped watching.", success)
+}
@@ -0,0 +1,53 @@
+//go:build go1.18
+// +build go1.18
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// FuzzDecode tests that Decode does not panic on arbitrary frames, given as the bytes
+// between NUL separators, and that the messages it decodes encode back to themselves.
+func FuzzDecode(f *testing.F) {
+	key := []byte("secret")
+	for _, msg := range []Message{
+		testMessage(),
+		{Header: Header{MsgType: "comm_msg"}, Content: map[string]interface{}{"comm_id": "c"}, Buffers: [][]byte{{1, 2}}},
+		{Header: Header{MsgType: "shutdown_request"}, Content: map[string]interface{}{"restart": true}},
+	} {
+		for _, key := range [][]byte{key, nil} {
+			parts, err := Encode(msg, key)
+			if err != nil {
+				f.Fatal(err)
+			}
+			frames := append([][]byte{[]byte("id"), []byte(Delimiter)}, parts...)
+			f.Add(bytes.Join(frames, []byte{0}), key != nil)
+		}
+	}
+	f.Add([]byte(Delimiter+"\x00\x00{}\x00{}\x00{}\x00{}"), false)
+
+	f.Fuzz(func(t *testing.T, data []byte, signed bool) {
+		var k []byte
+		if signed {
+			k = key
+		}
+		msg, _, err := Decode(bytes.Split(data, []byte{0}), k)
+		if err != nil {
+			return
+		}
+		parts, err := Encode(msg, k)
+		if err != nil {
+			t.Fatalf("Could not encode %+v: %v", msg, err)
+		}
+		again, _, err := Decode(append([][]byte{[]byte(Delimiter)}, parts...), k)
+		if err != nil {
+			t.Fatalf("Could not decode %+v encoded again: %v", msg, err)
+		}
+		if !reflect.DeepEqual(again.Header, msg.Header) || !reflect.DeepEqual(again.Content, msg.Content) {
+			t.Fatalf("Expected %+v, got %+v", msg, again)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+// Package wire encodes and decodes the messages of the Jupyter messaging protocol as
+// they are sent over ZMQ: the return identities, the <IDS|MSG> delimiter, the HMAC
+// signature, the header, parent header, metadata and content encoded as JSON, and the
+// binary buffers.
+//
+// Decode never panics on the frames it is given: malformed or oversized messages are
+// reported as errors, so that a kernel can reply to them or ignore them.
+package wire
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Delimiter separates the return identities of a message from its other frames.
+const Delimiter = "<IDS|MSG>"
+
+// MaxSize is the size of the largest message Decode accepts, in bytes over all its
+// frames.
+var MaxSize = 256 << 20
+
+// Header is the header of a message.
+type Header struct {
+	MsgID           string `json:"msg_id"`
+	Username        string `json:"username"`
+	Session         string `json:"session"`
+	MsgType         string `json:"msg_type"`
+	ProtocolVersion string `json:"version"`
+	Timestamp       string `json:"date"`
+	SubshellID      string `json:"subshell_id,omitempty"` // the subshell a shell request is sent to
+}
+
+// Message is a message of the protocol. The content of the messages decoded is a
+// map[string]interface{}.
+type Message struct {
+	Header       Header
+	ParentHeader Header
+	Metadata     map[string]interface{}
+	Content      interface{}
+	Buffers      [][]byte // binary buffers sent after the content, e.g. by widgets
+}
+
+var (
+	// ErrNoDelimiter is returned for frames without a delimiter.
+	ErrNoDelimiter = errors.New("wire: no " + Delimiter + " delimiter")
+	// ErrMissingFrames is returned when the delimiter is followed by fewer than the
+	// signature, header, parent header, metadata and content frames.
+	ErrMissingFrames = errors.New("wire: missing frames after the delimiter")
+	// ErrInvalidSignature is returned when the signature of a message does not match
+	// its frames. Such messages must be ignored.
+	ErrInvalidSignature = errors.New("wire: invalid signature")
+	// ErrTooLarge is returned for messages larger than MaxSize.
+	ErrTooLarge = errors.New("wire: message too large")
+)
+
+// FrameError is returned when a frame of a message is not valid.
+type FrameError struct {
+	Frame string // header, parent_header, metadata or content
+	Err   error
+}
+
+func (e *FrameError) Error() string {
+	return "wire: invalid " + e.Frame + ": " + e.Err.Error()
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// Decode decodes the frames of a message received, and returns it with its return
+// identities. Unless key is empty, the signature of the message is verified first.
+// When the header could be decoded, msg holds it even if err is not nil, so that the
+// error can be replied to.
+func Decode(frames [][]byte, key []byte) (msg Message, identities [][]byte, err error) {
+	size := 0
+	for _, frame := range frames {
+		size += len(frame)
+	}
+	if size > MaxSize {
+		return msg, nil, ErrTooLarge
+	}
+
+	i := 0
+	for i < len(frames) && string(frames[i]) != Delimiter {
+		i++
+	}
+	if i == len(frames) {
+		return msg, nil, ErrNoDelimiter
+	}
+	identities = frames[:i]
+	parts := frames[i+1:]
+	if len(parts) < 5 {
+		return msg, identities, ErrMissingFrames
+	}
+
+	// The binary buffers are not signed.
+	if len(key) != 0 {
+		signature := make([]byte, hex.DecodedLen(len(parts[0])))
+		if _, err := hex.Decode(signature, parts[0]); err != nil || !hmac.Equal(signature, sign(key, parts[1:5])) {
+			return msg, identities, ErrInvalidSignature
+		}
+	}
+
+	if err := json.Unmarshal(parts[1], &msg.Header); err != nil {
+		return Message{}, identities, &FrameError{"header", err}
+	}
+	if msg.Header.MsgType == "" {
+		return Message{}, identities, &FrameError{"header", errors.New("no msg_type")}
+	}
+	if err := json.Unmarshal(parts[2], &msg.ParentHeader); err != nil {
+		return msg, identities, &FrameError{"parent_header", err}
+	}
+	if err := json.Unmarshal(parts[3], &msg.Metadata); err != nil {
+		return msg, identities, &FrameError{"metadata", err}
+	}
+	var content map[string]interface{}
+	if err := json.Unmarshal(parts[4], &content); err != nil {
+		return msg, identities, &FrameError{"content", err}
+	}
+	if content == nil {
+		content = make(map[string]interface{})
+	}
+	if err := checkContent(msg.Header.MsgType, content); err != nil {
+		return msg, identities, &FrameError{"content", err}
+	}
+	msg.Content = content
+	if len(parts) > 5 {
+		msg.Buffers = parts[5:]
+	}
+	return msg, identities, nil
+}
+
+// Encode encodes msg into the frames to send after the return identities and the
+// delimiter, and signs it with key unless it is empty.
+func Encode(msg Message, key []byte) ([][]byte, error) {
+	parts := make([][]byte, 5, 5+len(msg.Buffers))
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]interface{})
+	}
+	for i, v := range []interface{}{msg.Header, msg.ParentHeader, msg.Metadata, msg.Content} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		parts[i+1] = data
+	}
+	if len(key) != 0 {
+		signature := sign(key, parts[1:5])
+		parts[0] = make([]byte, hex.EncodedLen(len(signature)))
+		hex.Encode(parts[0], signature)
+	}
+	return append(parts, msg.Buffers...), nil
+}
+
+// sign returns the HMAC-SHA256 of the header, parent header, metadata and content
+// frames.
+func sign(key []byte, frames [][]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, frame := range frames {
+		mac.Write(frame)
+	}
+	return mac.Sum(nil)
+}
+
+// field is a field of the content of a message, with its JSON type.
+type field struct {
+	name     string
+	kind     string // string, number, bool, object or array
+	required bool
+}
+
+// contentFields are the fields of the content of the messages received by kernels,
+// as the protocol specifies them. Other fields and messages are not checked.
+var contentFields = map[string][]field{
+	"execute_request": {
+		{"code", "string", true},
+		{"silent", "bool", false},
+		{"store_history", "bool", false},
+		{"user_expressions", "object", false},
+		{"allow_stdin", "bool", false},
+		{"stop_on_error", "bool", false},
+	},
+	"complete_request":    {{"code", "string", true}, {"cursor_pos", "number", true}},
+	"inspect_request":     {{"code", "string", true}, {"cursor_pos", "number", true}, {"detail_level", "number", false}},
+	"is_complete_request": {{"code", "string", true}},
+	"history_request":     {{"hist_access_type", "string", false}},
+	"comm_info_request":   {{"target_name", "string", false}},
+	"comm_open":           {{"comm_id", "string", true}, {"target_name", "string", true}, {"data", "object", false}},
+	"comm_msg":            {{"comm_id", "string", true}, {"data", "object", false}},
+	"comm_close":          {{"comm_id", "string", true}, {"data", "object", false}},
+	"input_reply":         {{"value", "string", true}},
+	"shutdown_request":    {{"restart", "bool", true}},
+}
+
+// checkContent checks the fields of the content of a message of type msgType.
+func checkContent(msgType string, content map[string]interface{}) error {
+	for _, f := range contentFields[msgType] {
+		v, ok := content[f.name]
+		if !ok || v == nil {
+			if f.required {
+				return fmt.Errorf("%s has no %s", msgType, f.name)
+			}
+			continue
+		}
+		var kind string
+		switch v.(type) {
+		case string:
+			kind = "string"
+		case float64:
+			kind = "number"
+		case bool:
+			kind = "bool"
+		case map[string]interface{}:
+			kind = "object"
+		case []interface{}:
+			kind = "array"
+		}
+		if kind != f.kind {
+			return fmt.Errorf("the %s of %s is a %s, not a %s", f.name, msgType, kind, f.kind)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const (
+	failure = "✗"
+	success = "✓"
+)
+
+// testMessage returns an execute_request.
+func testMessage() Message {
+	return Message{
+		Header:   Header{MsgID: "1", Session: "s", MsgType: "execute_request", ProtocolVersion: "5.3"},
+		Metadata: map[string]interface{}{"cellId": "c"},
+		Content:  map[string]interface{}{"code": "x := 1", "silent": false},
+	}
+}
+
+// testFrames returns the frames of msg sent by the identity id and signed with key.
+func testFrames(t *testing.T, msg Message, key []byte) [][]byte {
+	parts, err := Encode(msg, key)
+	if err != nil {
+		t.Fatalf("\t%s Could not encode the message: %v.", failure, err)
+	}
+	return append([][]byte{[]byte("id"), []byte(Delimiter)}, parts...)
+}
+
+// TestRoundTrip tests decoding the messages encoded.
+func TestRoundTrip(t *testing.T) {
+	key := []byte("secret")
+	msg := testMessage()
+	msg.ParentHeader = Header{MsgID: "0", MsgType: "kernel_info_request"}
+
+	t.Logf("Should decode the messages encoded, with their identities.")
+
+	for _, key := range [][]byte{key, nil} {
+		decoded, identities, err := Decode(testFrames(t, msg, key), key)
+		if err != nil {
+			t.Fatalf("\t%s Could not decode the message: %v.", failure, err)
+		}
+		if !reflect.DeepEqual(decoded, msg) || len(identities) != 1 || string(identities[0]) != "id" {
+			t.Fatalf("\t%s Expected %+v from id, got %+v from %q.", failure, msg, decoded, identities)
+		}
+		t.Logf("\t%s Decoded the message signed with %q.", success, key)
+	}
+
+	t.Logf("Should decode the messages without identities.")
+
+	parts, _ := Encode(msg, key)
+	decoded, identities, err := Decode(append([][]byte{[]byte(Delimiter)}, parts...), key)
+	if err != nil || len(identities) != 0 || decoded.Header != msg.Header {
+		t.Fatalf("\t%s Expected the message without identities, got %+v, %q (%v).", failure, decoded, identities, err)
+	}
+	t.Logf("\t%s Decoded the message.", success)
+}
+
+// TestBuffers tests that binary buffers follow the content of messages on the wire,
+// without being signed.
+func TestBuffers(t *testing.T) {
+	key := []byte("secret")
+	cases := []struct {
+		name    string
+		buffers [][]byte
+	}{
+		{"no buffers", nil},
+		{"one buffer", [][]byte{{0, 1, 2, 255}}},
+		{"several buffers", [][]byte{[]byte("image"), {}, {42}}},
+	}
+
+	t.Logf("Should send and receive the binary buffers of messages.")
+
+	for _, tc := range cases {
+		msg := Message{
+			Header:  Header{MsgID: "1", MsgType: "comm_msg"},
+			Content: map[string]interface{}{"comm_id": "c", "data": map[string]interface{}{}},
+			Buffers: tc.buffers,
+		}
+		parts, err := Encode(msg, key)
+		if err != nil {
+			t.Fatalf("\t%s %s: could not encode the message: %v.", failure, tc.name, err)
+		}
+		if len(parts) != 5+len(tc.buffers) {
+			t.Fatalf("\t%s %s: expected %d frames, got %d.", failure, tc.name, 5+len(tc.buffers), len(parts))
+		}
+		frames := append([][]byte{[]byte("id"), []byte(Delimiter)}, parts...)
+		received, identities, err := Decode(frames, key)
+		if err != nil {
+			t.Fatalf("\t%s %s: could not decode the message: %v.", failure, tc.name, err)
+		}
+		if len(identities) != 1 || len(received.Buffers) != len(tc.buffers) {
+			t.Fatalf("\t%s %s: expected %d buffers, got %d.", failure, tc.name, len(tc.buffers), len(received.Buffers))
+		}
+		for i, buffer := range tc.buffers {
+			if !bytes.Equal(received.Buffers[i], buffer) {
+				t.Fatalf("\t%s %s: expected buffer %d to be %v, got %v.", failure, tc.name, i, buffer, received.Buffers[i])
+			}
+		}
+		t.Logf("\t%s %s.", success, tc.name)
+	}
+
+	t.Logf("Should not sign the buffers, as front-ends do not.")
+
+	msg := Message{Header: Header{MsgType: "comm_msg"}}
+	plain, _ := Encode(msg, key)
+	msg.Buffers = [][]byte{[]byte("data")}
+	withBuffers, _ := Encode(msg, key)
+	if !bytes.Equal(plain[0], withBuffers[0]) {
+		t.Fatalf("\t%s Expected the signature to ignore the buffers.", failure)
+	}
+	t.Logf("\t%s The signature only covers the header, metadata and content.", success)
+}
+
+// TestMalformed tests decoding malformed messages.
+func TestMalformed(t *testing.T) {
+	key := []byte("secret")
+	valid := testFrames(t, testMessage(), key)
+	// with returns the valid frames with the frame i after the delimiter replaced, and
+	// signed again.
+	with := func(i int, frame string) [][]byte {
+		frames := append([][]byte(nil), valid...)
+		frames[i+2] = []byte(frame)
+		if i != 0 {
+			frames[2] = hexSign(key, frames[3:7])
+		}
+		return frames
+	}
+	cases := []struct {
+		name   string
+		frames [][]byte
+		err    error  // the error expected, or nil for a FrameError
+		header string // the type of the message decoded along with the error
+	}{
+		{"no frames", nil, ErrNoDelimiter, ""},
+		{"no delimiter", valid[2:], ErrNoDelimiter, ""},
+		{"only identities", valid[:1], ErrNoDelimiter, ""},
+		{"missing frames", valid[:5], ErrMissingFrames, ""},
+		{"empty signature", with(0, ""), ErrInvalidSignature, ""},
+		{"invalid hex signature", with(0, "zz"), ErrInvalidSignature, ""},
+		{"wrong signature", with(0, string(hexSign([]byte("other"), valid[3:7]))), ErrInvalidSignature, ""},
+		{"invalid header", with(1, "{"), nil, ""},
+		{"header array", with(1, "[]"), nil, ""},
+		{"no msg_type", with(1, `{"msg_id": "1"}`), nil, ""},
+		{"invalid parent header", with(2, `"x"`), nil, "execute_request"},
+		{"invalid metadata", with(3, "[1]"), nil, "execute_request"},
+		{"invalid content", with(4, "{"), nil, "execute_request"},
+		{"content string", with(4, `"code"`), nil, "execute_request"},
+		{"no code", with(4, `{"silent": false}`), nil, "execute_request"},
+		{"code number", with(4, `{"code": 1}`), nil, "execute_request"},
+		{"silent string", with(4, `{"code": "", "silent": "no"}`), nil, "execute_request"},
+	}
+
+	t.Logf("Should fail to decode malformed messages, with the header when it is valid.")
+
+	for _, tc := range cases {
+		msg, _, err := Decode(tc.frames, key)
+		var frameErr *FrameError
+		if err == nil || tc.err != nil && err != tc.err || tc.err == nil && !errors.As(err, &frameErr) {
+			t.Fatalf("\t%s %s: expected the error %v, got %v.", failure, tc.name, tc.err, err)
+		}
+		if msg.Header.MsgType != tc.header {
+			t.Fatalf("\t%s %s: expected the header of %q, got %+v.", failure, tc.name, tc.header, msg.Header)
+		}
+		t.Logf("\t%s %s: %v.", success, tc.name, err)
+	}
+
+	t.Logf("Should decode the content without the optional fields.")
+
+	msg, _, err := Decode(with(4, `{"code": "x", "silent": null}`), key)
+	if err != nil || msg.Content.(map[string]interface{})["code"] != "x" {
+		t.Fatalf("\t%s Expected the content to be decoded, got %+v (%v).", failure, msg.Content, err)
+	}
+	if msg, _, err := Decode(with(4, "null"), key); err == nil {
+		t.Fatalf("\t%s Expected an execute_request without content to fail, got %+v.", failure, msg)
+	}
+	msg.Header.MsgType = "status"
+	if msg, _, err := Decode(testFrames(t, Message{Header: msg.Header, Content: nil}, key), key); err != nil || msg.Content == nil {
+		t.Fatalf("\t%s Expected a null content to be decoded as an empty object, got %+v (%v).", failure, msg.Content, err)
+	}
+	t.Logf("\t%s Decoded the content.", success)
+
+	t.Logf("Should refuse the messages larger than MaxSize.")
+
+	defer func(size int) { MaxSize = size }(MaxSize)
+	MaxSize = 100
+	if _, _, err := Decode(valid, key); err != ErrTooLarge {
+		t.Fatalf("\t%s Expected %v, got %v.", failure, ErrTooLarge, err)
+	}
+	t.Logf("\t%s Refused the message.", success)
+}
+
+// hexSign returns the signature of the frames, as sent.
+func hexSign(key []byte, frames [][]byte) []byte {
+	return []byte(hex.EncodeToString(sign(key, frames)))
+}